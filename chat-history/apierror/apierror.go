@@ -0,0 +1,103 @@
+// Package apierror is the body of every error response: a stable, machine-readable code
+// clients can switch on, and a message for people
+package apierror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Codes of the errors. They're part of the API, don't change them
+const (
+	// the request is malformed or has an invalid value
+	CodeInvalidRequest = "invalid_request"
+	// there are no credentials
+	CodeUnauthorized = "unauthorized"
+	// the caller isn't allowed to do it
+	CodeForbidden = "forbidden"
+	// what the request refers to doesn't exist, or belongs to another user
+	CodeNotFound = "not_found"
+	// the chat history is read-only, nothing can be changed
+	CodeReadOnly = "read_only"
+	// the conversation changed since the version the caller sent in If-Match. Read it again and retry
+	CodeConflict = "conflict"
+	// a reply to the conversation is already being generated. Try again once it's done
+	CodeGenerationInProgress = "generation_in_progress"
+	// the database is already being compacted (see POST /admin/maintenance). Try again once it's done
+	CodeMaintenanceRunning = "maintenance_in_progress"
+	// the user already has as many conversations as they can (see config.ChatDbConfig.MaxConversationsPerUser). Delete some first
+	CodeTooManyConversations = "too_many_conversations"
+	// the share link is past its expiry, or was revoked
+	CodeShareExpired = "share_expired"
+	CodeShareRevoked = "share_revoked"
+	// the message was blocked by content moderation (see config.ChatDbConfig.ModerationBlocklist), the error says why
+	CodeContentBlocked = "content_blocked"
+	// the message is longer than the limit (see config.ChatDbConfig.MaxMessageChars)
+	CodeMessageTooLong = "message_too_long"
+	// the body is over the size limit (see config.ChatDbConfig.MaxRequestBodyBytes)
+	CodeTooLarge = "request_too_large"
+	// the caller sent too many requests
+	CodeRateLimited = "rate_limited"
+	// something failed on our side
+	CodeInternal = "internal_error"
+	// a service it depends on is down, i.e., TigerGraph can't check the credentials. Try again later
+	CodeUnavailable = "unavailable"
+	// the feature isn't configured on this instance, i.e., there's no LLM
+	CodeNotImplemented = "not_implemented"
+	// the request took longer than it's allowed to (see config.ChatDbConfig.HandlerTimeoutSeconds)
+	CodeTimeout = "timeout"
+)
+
+// APIError is an error response. Status isn't in the body, it's the response's status code
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// New returns an error with the status, code and message
+func New(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func InvalidRequest(message string) *APIError {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+func Unauthorized(message string) *APIError {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *APIError {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(message string) *APIError {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// TooLarge is the error for a body over limit bytes
+func TooLarge(limit int64) *APIError {
+	return New(http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("the body is over the %d byte limit", limit))
+}
+
+func Timeout(message string) *APIError {
+	return New(http.StatusGatewayTimeout, CodeTimeout, message)
+}
+
+func Internal(message string) *APIError {
+	return New(http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Write responds with the error as {"code": ..., "message": ...}
+func Write(w http.ResponseWriter, err *APIError) {
+	body, _ := json.Marshal(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+	w.Write(body)
+}
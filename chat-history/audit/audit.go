@@ -0,0 +1,72 @@
+// Package audit records accesses to other users' data, and the changes to who can read a conversation or whether
+// it exists, in an append-only JSONL file. It's separate from the request log, entries are never dropped
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is a single access or change
+type Entry struct {
+	Timestamp time.Time `json:"timestamp"`
+	// the user that read or changed the data, and their roles
+	Actor string   `json:"actor"`
+	Roles []string `json:"roles,omitempty"`
+	// what they did, i.e., list_conversations or grant_access
+	Action string `json:"action"`
+	// whose data it was
+	UserId         string `json:"user_id,omitempty"`
+	ConversationId string `json:"conversation_id,omitempty"`
+	// who it was given to, for transfers, or whose access changed, for grants and revocations
+	NewUserId string `json:"new_user_id,omitempty"`
+	// the access granted
+	Permission string `json:"permission,omitempty"`
+	// the share link revoked
+	ShareId   string `json:"share_id,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// Log is an append-only JSONL file with one line per Entry
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f}, nil
+}
+
+// Record appends the entry and syncs it to disk, so an access isn't lost if the server crashes.
+// The timestamp is set if it's zero
+func (l *Log) Record(entry Entry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(b); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,105 @@
+// Package authn works out who made a request: the user id and roles of the caller
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrUnauthenticated is matched by the errors of Authenticate when the request has no valid credentials.
+// Their messages say why, and are safe to send to the caller
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the caller of a request
+type Identity struct {
+	UserId string
+	Roles  []string
+	// the tenant the caller's token is for, empty if it doesn't say. See Tenants
+	Tenant string
+}
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	// Authenticate returns the caller. Its error wraps ErrUnauthenticated if the request is missing
+	// credentials or they aren't valid. Any other error means they couldn't be checked
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// BasicAuth authenticates callers by their basic auth credentials, looking up the user's roles with them.
+// ctx is the context of the request being authenticated
+type BasicAuth func(ctx context.Context, username, password string) ([]string, error)
+
+// authError is an ErrUnauthenticated with the reason, which is safe to show the caller
+type authError struct {
+	reason string
+}
+
+func (e *authError) Error() string {
+	return e.reason
+}
+
+func (e *authError) Is(target error) bool {
+	return target == ErrUnauthenticated
+}
+
+func unauthenticated(format string, args ...any) error {
+	return &authError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (resolve BasicAuth) Authenticate(r *http.Request) (Identity, error) {
+	usr, pass, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, unauthenticated("missing Authorization header")
+	}
+	roles, err := resolve(r.Context(), usr, pass)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{UserId: usr, Roles: roles}, nil
+}
+
+type ctxKey int
+
+const (
+	identityKey ctxKey = iota
+	callerKey
+)
+
+// NewContext returns a copy of ctx with the caller's identity. It's recorded in ctx's Caller too, if it has one
+func NewContext(ctx context.Context, id Identity) context.Context {
+	if c, ok := ctx.Value(callerKey).(*Caller); ok {
+		c.mu.Lock()
+		c.id, c.ok = id, true
+		c.mu.Unlock()
+	}
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// Caller is the identity of a request's caller, for the code the request goes through before it's authenticated,
+// i.e., the request logger, which reads it after the handler returns. See WithCaller
+type Caller struct {
+	mu sync.Mutex
+	id Identity
+	ok bool
+}
+
+// WithCaller returns a copy of ctx whose caller's identity is recorded in c once NewContext sets it
+func WithCaller(ctx context.Context, c *Caller) context.Context {
+	return context.WithValue(ctx, callerKey, c)
+}
+
+// Identity is the caller's identity, ok is false if the request hasn't been authenticated
+func (c *Caller) Identity() (id Identity, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id, c.ok
+}
+
+// FromContext returns the caller's identity set by NewContext
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey).(Identity)
+	return id, ok
+}
@@ -0,0 +1,65 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	// a fake TigerGraph, which knows sam_pull's password
+	tg := BasicAuth(func(ctx context.Context, username, password string) ([]string, error) {
+		if username == "broken" {
+			return nil, errors.New("tigergraph is down")
+		}
+		if username == "sam_pull" && password == "sam_pull" {
+			return []string{"globaldesigner"}, nil
+		}
+		return nil, nil
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("sam_pull", "sam_pull")
+	id, err := tg.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner"}) {
+		t.Fatalf("the identity should be the basic auth user with their roles: %+v", id)
+	}
+
+	if _, err := tg.Authenticate(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, ErrUnauthenticated) || err.Error() != "missing Authorization header" {
+		t.Fatalf("a request without credentials should be rejected with ErrUnauthenticated. Got: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("broken", "broken")
+	if _, err := tg.Authenticate(r); err == nil || errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("failing to look up the roles isn't the caller's fault. Got: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("there's no identity without NewContext")
+	}
+	id := Identity{UserId: "sam_pull", Roles: []string{"superuser"}}
+	if got, ok := FromContext(NewContext(context.Background(), id)); !ok || got.UserId != id.UserId {
+		t.Fatalf("the identity should be in the context. Got %+v", got)
+	}
+}
+
+func TestContext_Caller(t *testing.T) {
+	caller := &Caller{}
+	ctx := WithCaller(context.Background(), caller)
+	if _, ok := caller.Identity(); ok {
+		t.Fatal("there's no identity before NewContext")
+	}
+	// the code that made ctx sees the identity set further down
+	NewContext(ctx, Identity{UserId: "sam_pull"})
+	if got, ok := caller.Identity(); !ok || got.UserId != "sam_pull" {
+		t.Fatalf("the identity should be recorded in the caller. Got %+v", got)
+	}
+}
@@ -0,0 +1,31 @@
+package authn
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestDev(t *testing.T) {
+	roles := []string{"globaldesigner", "superuser"}
+	dev := NewDev(roles)
+	// changing the config's roles afterwards doesn't change who gets what
+	roles[0] = "changed"
+
+	// any credentials are accepted, as the basic auth user
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("sam_pull", "wrong password")
+	id, err := dev.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner", "superuser"}) {
+		t.Fatalf("the caller should be the basic auth user with the dev roles: %+v", id)
+	}
+
+	// and none at all
+	id, err = dev.Authenticate(httptest.NewRequest("GET", "/", nil))
+	if err != nil || id.UserId != DevUser || !slices.Equal(id.Roles, []string{"globaldesigner", "superuser"}) {
+		t.Fatalf("a request without credentials should be the dev user with the dev roles. Got %+v, %v", id, err)
+	}
+}
@@ -0,0 +1,153 @@
+package authn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is matched by the errors of Authenticate when the credentials can't be checked
+// right now because the provider is down. The request can be retried later
+var ErrUnavailable = errors.New("authentication is unavailable")
+
+// the most credentials a Fallback remembers at once
+const maxRemembered = 10000
+
+// Health is whether the provider credentials are checked with can be reached. tigergraph.Monitor implements it
+type Health interface {
+	// Err is why the provider can't be reached, or nil if it can
+	Err() error
+	// Fail records that a call to the provider failed with err
+	Fail(err error)
+}
+
+// Fallback is a BasicAuth that keeps reads working while its provider is down. It remembers the
+// identity of each caller it authenticates for ttl, and while the provider can't be reached, GET and
+// HEAD requests with the same credentials are let through as that identity. Every other request fails
+// with ErrUnavailable until the provider is back, so changes are only made with up to date roles. That
+// includes WebSocket handshakes, which are GETs but open a connection that writes
+type Fallback struct {
+	auth   BasicAuth
+	health Health
+	ttl    time.Duration
+	now    func() time.Time
+	// credentials are remembered by their HMAC with key, so they aren't kept in memory
+	key []byte
+
+	mu    sync.Mutex
+	known map[[sha256.Size]byte]remembered
+}
+
+type remembered struct {
+	id      Identity
+	expires time.Time
+}
+
+// NewFallback returns a Fallback that authenticates with auth while health has no error
+func NewFallback(auth BasicAuth, health Health, ttl time.Duration) *Fallback {
+	return &Fallback{
+		auth:   auth,
+		health: health,
+		ttl:    ttl,
+		now:    time.Now,
+		key:    newDigestKey(),
+		known:  map[[sha256.Size]byte]remembered{},
+	}
+}
+
+func (f *Fallback) Authenticate(r *http.Request) (Identity, error) {
+	usr, pass, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, unauthenticated("missing Authorization header")
+	}
+	credentials := f.digest(usr, pass)
+
+	down := f.health.Err()
+	if down == nil {
+		roles, err := f.auth(r.Context(), usr, pass)
+		if err == nil {
+			id := Identity{UserId: usr, Roles: roles}
+			f.remember(credentials, id)
+			return id, nil
+		}
+		// the caller going away doesn't mean the provider is down
+		if errors.Is(err, ErrUnauthenticated) || r.Context().Err() != nil {
+			return Identity{}, err
+		}
+		f.health.Fail(err)
+		down = err
+	}
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !isUpgrade(r) {
+		if id, ok := f.recall(credentials); ok {
+			return id, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: %w", ErrUnavailable, down)
+}
+
+// isUpgrade reports whether r asks to switch to another protocol, i.e., a WebSocket
+func isUpgrade(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fallback) digest(usr, pass string) [sha256.Size]byte {
+	return digest(f.key, usr, pass)
+}
+
+// newDigestKey is a random key to digest credentials with, so they can't be guessed from what's kept in memory
+func newDigestKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// digest is the HMAC of a username and password with key
+func digest(key []byte, usr, pass string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, key)
+	// the length keeps "a:b" + "c" apart from "a" + "b:c"
+	fmt.Fprintf(mac, "%d:%s%s", len(usr), usr, pass)
+	var sum [sha256.Size]byte
+	copy(sum[:], mac.Sum(nil))
+	return sum
+}
+
+func (f *Fallback) remember(credentials [sha256.Size]byte, id Identity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if len(f.known) >= maxRemembered {
+		for k, v := range f.known {
+			if now.After(v.expires) {
+				delete(f.known, k)
+			}
+		}
+		if _, ok := f.known[credentials]; !ok && len(f.known) >= maxRemembered {
+			return
+		}
+	}
+	f.known[credentials] = remembered{id: id, expires: now.Add(f.ttl)}
+}
+
+func (f *Fallback) recall(credentials [sha256.Size]byte) (Identity, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.known[credentials]
+	if !ok || f.now().After(r.expires) {
+		return Identity{}, false
+	}
+	return r.id, true
+}
@@ -0,0 +1,116 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeHealth is down while err is set
+type fakeHealth struct {
+	err error
+}
+
+func (h *fakeHealth) Err() error {
+	return h.err
+}
+
+func (h *fakeHealth) Fail(err error) {
+	h.err = err
+}
+
+func basicRequest(method, username, password string) *http.Request {
+	r := httptest.NewRequest(method, "/", nil)
+	r.SetBasicAuth(username, password)
+	return r
+}
+
+func TestFallback(t *testing.T) {
+	refused := errors.New("connection refused")
+	health := &fakeHealth{}
+	calls := 0
+	f := NewFallback(func(ctx context.Context, username, password string) ([]string, error) {
+		calls++
+		if health.err != nil {
+			return nil, health.err
+		}
+		if password != "sam_pull" {
+			return nil, nil
+		}
+		return []string{"globaldesigner"}, nil
+	}, health, time.Hour)
+
+	// while TigerGraph is up, every request is checked with it
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		id, err := f.Authenticate(basicRequest(method, "sam_pull", "sam_pull"))
+		if err != nil || id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner"}) {
+			t.Fatalf("%s should be authenticated. Got %+v, %v", method, id, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("TigerGraph should be asked every time it's up. It was asked %d times", calls)
+	}
+
+	// a request that fails marks it down
+	health.err = refused
+	calls = 0
+	if _, err := f.Authenticate(basicRequest(http.MethodPost, "sam_pull", "sam_pull")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("writes should fail with ErrUnavailable while TigerGraph is down. Got: %v", err)
+	}
+	id, err := f.Authenticate(basicRequest(http.MethodGet, "sam_pull", "sam_pull"))
+	if err != nil || id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner"}) {
+		t.Fatalf("reads should be authenticated as the remembered identity. Got %+v, %v", id, err)
+	}
+	if calls != 0 {
+		t.Fatalf("TigerGraph shouldn't be asked while it's down. It was asked %d times", calls)
+	}
+	// a WebSocket handshake is a GET, but the connection writes
+	upgrade := basicRequest(http.MethodGet, "sam_pull", "sam_pull")
+	upgrade.Header.Set("Connection", "keep-alive, Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	if _, err := f.Authenticate(upgrade); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("WebSocket handshakes should fail with ErrUnavailable while TigerGraph is down. Got: %v", err)
+	}
+	for _, r := range []*http.Request{
+		basicRequest(http.MethodGet, "sam_pull", "wrong"),
+		basicRequest(http.MethodGet, "Miss_Take", "Miss_Take"),
+	} {
+		if _, err := f.Authenticate(r); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("credentials that weren't checked before can't be checked while TigerGraph is down. Got: %v", err)
+		}
+	}
+
+	// remembered identities expire
+	f.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := f.Authenticate(basicRequest(http.MethodGet, "sam_pull", "sam_pull")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("the identity should be forgotten after the ttl. Got: %v", err)
+	}
+
+	// back up
+	health.err = nil
+	if _, err := f.Authenticate(basicRequest(http.MethodPost, "sam_pull", "sam_pull")); err != nil {
+		t.Fatalf("writes should work again once TigerGraph is back. Got: %v", err)
+	}
+}
+
+func TestFallback_ProviderFails(t *testing.T) {
+	health := &fakeHealth{}
+	refused := errors.New("connection refused")
+	f := NewFallback(func(ctx context.Context, username, password string) ([]string, error) {
+		return nil, refused
+	}, health, time.Hour)
+
+	if _, err := f.Authenticate(basicRequest(http.MethodGet, "sam_pull", "sam_pull")); !errors.Is(err, ErrUnavailable) || !errors.Is(err, refused) {
+		t.Fatalf("the error should be ErrUnavailable with the cause. Got: %v", err)
+	}
+	if !errors.Is(health.err, refused) {
+		t.Fatalf("the failure should be reported to the health. It's: %v", health.err)
+	}
+	if _, err := f.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("a request without credentials is unauthenticated, whether or not TigerGraph is up. Got: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package authn
+
+import (
+	"chat-history/config"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWT authenticates callers by a bearer token from an OIDC provider, i.e., an ID token.
+// The token must be signed with one of the configured keys and be issued by the issuer for the audience
+type JWT struct {
+	issuer      string
+	audience    string
+	userClaim   string
+	rolesClaim  string
+	tenantClaim string
+	keys        []crypto.PublicKey
+	secret      []byte
+	// the clock skew between us and the provider allowed when checking exp and nbf
+	leeway time.Duration
+	now    func() time.Time
+}
+
+// NewJWT reads the keys in cfg. cfg is expected to have passed cfg.Validate(). Tokens are accepted for leeway
+// after they expire, and leeway before they're valid
+func NewJWT(cfg config.AuthConfig, leeway time.Duration) (*JWT, error) {
+	j := &JWT{
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		userClaim:   cfg.UserClaim,
+		rolesClaim:  cfg.RolesClaim,
+		tenantClaim: cfg.TenantClaim,
+		leeway:      leeway,
+		now:         time.Now,
+	}
+	for _, pth := range cfg.PublicKeyPaths {
+		data, err := os.ReadFile(pth)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pth, err)
+		}
+		j.keys = append(j.keys, keys...)
+	}
+	secret, err := cfg.Secret()
+	if err != nil {
+		return nil, err
+	}
+	j.secret = secret
+	if len(j.keys) == 0 && j.secret == nil {
+		return nil, errors.New("no keys to verify tokens with")
+	}
+	return j, nil
+}
+
+// parsePublicKeys reads the RSA and ECDSA keys in PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE blocks
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys in the PEM file")
+	}
+	return keys, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (Identity, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return Identity{}, unauthenticated("missing bearer token")
+	}
+	claims, err := j.verify(strings.TrimSpace(header[len("Bearer "):]))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	userId, _ := claim(claims, j.userClaim).(string)
+	if userId == "" {
+		return Identity{}, unauthenticated("token has no %s claim", j.userClaim)
+	}
+	var roles []string
+	switch v := claim(claims, j.rolesClaim).(type) {
+	case []any:
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	case string:
+		// space separated, like scope
+		roles = strings.Fields(v)
+	}
+	id := Identity{UserId: userId, Roles: roles}
+	if j.tenantClaim != "" {
+		id.Tenant, _ = claim(claims, j.tenantClaim).(string)
+	}
+	return id, nil
+}
+
+// verify checks the token's signature and its registered claims, and returns its claims
+func (j *JWT) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, unauthenticated("token is not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, unauthenticated("token header is not valid")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, unauthenticated("token signature is not valid")
+	}
+	if err := j.verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, unauthenticated("token claims are not valid")
+	}
+	now := j.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, unauthenticated("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(j.leeway)) {
+		return nil, unauthenticated("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, unauthenticated("token is not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); iss != j.issuer {
+		return nil, unauthenticated("token is not from the issuer")
+	}
+	if !hasAudience(claims["aud"], j.audience) {
+		return nil, unauthenticated("token is not for the audience")
+	}
+	return claims, nil
+}
+
+// the size of the curve of each ES algorithm's hash
+var esCurveBits = map[crypto.Hash]int{crypto.SHA256: 256, crypto.SHA384: 384, crypto.SHA512: 521}
+
+func (j *JWT) verifySignature(alg, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return unauthenticated("unsupported token algorithm %q", alg)
+	}
+	digest := func() []byte {
+		h := hash.New()
+		h.Write([]byte(signed))
+		return h.Sum(nil)
+	}
+
+	invalid := unauthenticated("token signature is not valid")
+	switch alg[:2] {
+	case "HS":
+		if j.secret == nil {
+			return invalid
+		}
+		mac := hmac.New(hash.New, j.secret)
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return nil
+		}
+	case "RS", "PS":
+		for _, key := range j.keys {
+			pub, ok := key.(*rsa.PublicKey)
+			if !ok {
+				continue
+			}
+			if alg[:2] == "RS" && rsa.VerifyPKCS1v15(pub, hash, digest(), sig) == nil {
+				return nil
+			}
+			if alg[:2] == "PS" && rsa.VerifyPSS(pub, hash, digest(), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+				return nil
+			}
+		}
+	case "ES":
+		for _, key := range j.keys {
+			// ES256 is only P-256, ES384 P-384 and ES512 P-521
+			pub, ok := key.(*ecdsa.PublicKey)
+			if !ok || pub.Curve.Params().BitSize != esCurveBits[hash] {
+				continue
+			}
+			// the signature is r and s, each the size of the curve
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				continue
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(pub, digest(), r, s) {
+				return nil
+			}
+		}
+	default:
+		return unauthenticated("unsupported token algorithm %q", alg)
+	}
+	return invalid
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// hasAudience checks the aud claim, a string or a list of them, has the audience
+func hasAudience(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		return slices.Contains(v, any(audience))
+	}
+	return false
+}
+
+// claim looks up the claim at the dot separated path, i.e., realm_access.roles
+func claim(claims map[string]any, path string) any {
+	var v any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
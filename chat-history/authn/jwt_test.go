@@ -0,0 +1,260 @@
+package authn
+
+import (
+	"chat-history/config"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	issuer   = "https://login.example.com"
+	audience = "chat-history"
+)
+
+// signer signs a JWT's header and claims
+type signer func(signed string) []byte
+
+func sign(t *testing.T, alg string, sig signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig(signed))
+}
+
+func rsaSigner(t *testing.T, key *rsa.PrivateKey) signer {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+}
+
+func ecdsaSigner(t *testing.T, key *ecdsa.PrivateKey) signer {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// r and s, each padded to 32 bytes
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+}
+
+func hmacSigner(secret []byte) signer {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":   issuer,
+		"aud":   audience,
+		"sub":   "sam_pull",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"globaldesigner"},
+	}
+}
+
+// setupJWT writes the public keys to a PEM file and loads it, with secret as the HS256 secret
+func setupJWT(t *testing.T, secret []byte, keys ...crypto.PublicKey) *JWT {
+	t.Helper()
+	var pemData []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	cfg := config.AuthConfig{Provider: config.AuthOIDC, Issuer: issuer, Audience: audience, UserClaim: "sub", RolesClaim: "roles"}
+	if len(pemData) > 0 {
+		pth := fmt.Sprintf("%s/%s", t.TempDir(), "keys.pem")
+		if err := os.WriteFile(pth, pemData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg.PublicKeyPaths = []string{pth}
+	}
+	if secret != nil {
+		cfg.SecretEnv = "TEST_JWT_SECRET"
+		t.Setenv(cfg.SecretEnv, base64.StdEncoding.EncodeToString(secret))
+	}
+	j, err := NewJWT(cfg, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func authenticate(j *JWT, token string) (Identity, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return j.Authenticate(r)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	j := setupJWT(t, secret, &rsaKey.PublicKey, &ecKey.PublicKey)
+
+	tests := []struct {
+		alg string
+		sig signer
+	}{
+		{"RS256", rsaSigner(t, rsaKey)},
+		{"ES256", ecdsaSigner(t, ecKey)},
+		{"HS256", hmacSigner(secret)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			id, err := authenticate(j, sign(t, tt.alg, tt.sig, validClaims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner"}) {
+				t.Fatalf("the identity should come from the claims: %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWT_Invalid(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	j := setupJWT(t, nil, &rsaKey.PublicKey)
+	withClaim := func(key string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"not a JWT", "nope"},
+		{"signed with another key", sign(t, "RS256", rsaSigner(t, otherKey), validClaims())},
+		{"no signature", sign(t, "none", func(string) []byte { return nil }, validClaims())},
+		{"HS256 without a secret", sign(t, "HS256", hmacSigner([]byte("0123456789abcdef0123456789abcdef")), validClaims())},
+		{"expired", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("exp", time.Now().Add(-2*time.Minute).Unix()))},
+		{"no expiry", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("exp", nil))},
+		{"not valid yet", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("nbf", time.Now().Add(time.Hour).Unix()))},
+		{"another issuer", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("iss", "https://evil.example.com"))},
+		{"another audience", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("aud", []string{"other-app"}))},
+		{"no user", sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("sub", nil))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := authenticate(j, tt.token); !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("the token should be rejected with ErrUnauthenticated. Got: %v", err)
+			}
+		})
+	}
+
+	// within the leeway for clock skew
+	if _, err := authenticate(j, sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("exp", time.Now().Add(-30*time.Second).Unix()))); err != nil {
+		t.Fatalf("a token that expired within the leeway should be accepted. Got: %v", err)
+	}
+	// aud can be a list
+	if _, err := authenticate(j, sign(t, "RS256", rsaSigner(t, rsaKey), withClaim("aud", []string{"other-app", audience}))); err != nil {
+		t.Fatalf("a token with the audience in a list should be accepted. Got: %v", err)
+	}
+}
+
+func TestJWT_ClockSkew(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	j := setupJWT(t, secret)
+	now := time.Now().Truncate(time.Second)
+	j.now = func() time.Time { return now }
+	token := func(claim string, at time.Time) string {
+		claims := validClaims()
+		claims[claim] = at.Unix()
+		return sign(t, "HS256", hmacSigner(secret), claims)
+	}
+
+	for _, leeway := range []time.Duration{0, 30 * time.Second} {
+		j.leeway = leeway
+		tests := []struct {
+			name     string
+			token    string
+			accepted bool
+		}{
+			{"expired just inside the leeway", token("exp", now.Add(-leeway)), true},
+			{"expired just outside the leeway", token("exp", now.Add(-leeway-time.Second)), false},
+			{"valid just inside the leeway", token("nbf", now.Add(leeway)), true},
+			{"valid just outside the leeway", token("nbf", now.Add(leeway+time.Second)), false},
+		}
+		for _, tt := range tests {
+			if _, err := authenticate(j, tt.token); (err == nil) != tt.accepted {
+				t.Fatalf("%s of %v: accepted should be %v. Got: %v", tt.name, leeway, tt.accepted, err)
+			}
+		}
+	}
+}
+
+func TestJWT_NestedRolesClaim(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	j := setupJWT(t, secret)
+	j.userClaim, j.rolesClaim = "preferred_username", "realm_access.roles"
+
+	claims := validClaims()
+	claims["preferred_username"] = "Miss_Take"
+	claims["realm_access"] = map[string]any{"roles": []string{"superuser", "globalobserver"}}
+	id, err := authenticate(j, sign(t, "HS256", hmacSigner(secret), claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UserId != "Miss_Take" || !slices.Equal(id.Roles, []string{"superuser", "globalobserver"}) {
+		t.Fatalf("the identity should come from the configured claims: %+v", id)
+	}
+}
+
+func TestNewJWT_InvalidKeys(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "keys.pem")
+	if err := os.WriteFile(pth, []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJWT(config.AuthConfig{Issuer: issuer, Audience: audience, PublicKeyPaths: []string{pth}}, 0); err == nil {
+		t.Fatal("a PEM file without keys should be an error")
+	}
+	if _, err := NewJWT(config.AuthConfig{Issuer: issuer, Audience: audience}, 0); err == nil {
+		t.Fatal("no keys should be an error")
+	}
+}
@@ -0,0 +1,108 @@
+package authn
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RoleCache is a BasicAuth that remembers the roles it looks up for ttl, so a caller's requests don't each
+// wait for the provider. They're remembered with the credentials they were looked up with, so a wrong password
+// is still checked, and lookups that fail aren't remembered. Once a user's roles change, Invalidate them so
+// they're looked up on the next request instead of when the ttl is up
+type RoleCache struct {
+	auth BasicAuth
+	ttl  time.Duration
+	now  func() time.Time
+	// credentials are remembered by their HMAC with key, so they aren't kept in memory
+	key []byte
+
+	mu     sync.Mutex
+	cached map[[sha256.Size]byte]cachedRoles
+}
+
+type cachedRoles struct {
+	userId  string
+	roles   []string
+	expires time.Time
+}
+
+// NewRoleCache returns a RoleCache that looks roles up with auth. With a ttl of 0 nothing is remembered
+func NewRoleCache(auth BasicAuth, ttl time.Duration) *RoleCache {
+	return &RoleCache{
+		auth:   auth,
+		ttl:    ttl,
+		now:    time.Now,
+		key:    newDigestKey(),
+		cached: map[[sha256.Size]byte]cachedRoles{},
+	}
+}
+
+// Roles is the BasicAuth of the cache: the user's remembered roles, or else the ones auth looks up
+func (c *RoleCache) Roles(ctx context.Context, username, password string) ([]string, error) {
+	if c.ttl <= 0 {
+		return c.auth(ctx, username, password)
+	}
+	credentials := digest(c.key, username, password)
+	if roles, ok := c.recall(credentials); ok {
+		return roles, nil
+	}
+	roles, err := c.auth(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(credentials, username, roles)
+	return slices.Clone(roles), nil
+}
+
+func (c *RoleCache) Authenticate(r *http.Request) (Identity, error) {
+	return BasicAuth(c.Roles).Authenticate(r)
+}
+
+// Invalidate forgets the roles of userId, whichever credentials they were looked up with
+func (c *RoleCache) Invalidate(userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.cached {
+		if v.userId == userId {
+			delete(c.cached, k)
+		}
+	}
+}
+
+// InvalidateAll forgets every user's roles
+func (c *RoleCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.cached)
+}
+
+func (c *RoleCache) remember(credentials [sha256.Size]byte, userId string, roles []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.cached) >= maxRemembered {
+		for k, v := range c.cached {
+			if now.After(v.expires) {
+				delete(c.cached, k)
+			}
+		}
+		if _, ok := c.cached[credentials]; !ok && len(c.cached) >= maxRemembered {
+			return
+		}
+	}
+	c.cached[credentials] = cachedRoles{userId: userId, roles: slices.Clone(roles), expires: now.Add(c.ttl)}
+}
+
+func (c *RoleCache) recall(credentials [sha256.Size]byte) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.cached[credentials]
+	if !ok || c.now().After(r.expires) {
+		return nil, false
+	}
+	return slices.Clone(r.roles), true
+}
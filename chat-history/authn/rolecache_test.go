@@ -0,0 +1,105 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// countingRoles is a fake TigerGraph that counts how often it's asked. sam_pull's password is sam_pull
+type countingRoles struct {
+	calls int
+	roles []string
+	err   error
+}
+
+func (c *countingRoles) auth(ctx context.Context, username, password string) ([]string, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if username != "sam_pull" || password != "sam_pull" {
+		return nil, unauthenticated("invalid credentials")
+	}
+	return c.roles, nil
+}
+
+func TestRoleCache(t *testing.T) {
+	tg := &countingRoles{roles: []string{"globaldesigner"}}
+	c := NewRoleCache(tg.auth, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		id, err := c.Authenticate(basicRequest(http.MethodGet, "sam_pull", "sam_pull"))
+		if err != nil || id.UserId != "sam_pull" || !slices.Equal(id.Roles, []string{"globaldesigner"}) {
+			t.Fatalf("the caller should be authenticated. Got %+v, %v", id, err)
+		}
+	}
+	if tg.calls != 1 {
+		t.Fatalf("a cached user's roles shouldn't be looked up again. TigerGraph was asked %d times", tg.calls)
+	}
+
+	// the roles are cached with the password they were looked up with
+	if _, err := c.Authenticate(basicRequest(http.MethodGet, "sam_pull", "wrong")); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("a wrong password shouldn't get the cached roles. Got: %v", err)
+	}
+	if _, err := c.Authenticate(basicRequest(http.MethodGet, "sam_pull", "wrong")); !errors.Is(err, ErrUnauthenticated) || tg.calls != 3 {
+		t.Fatalf("failed lookups shouldn't be cached. Got %v, after %d calls", err, tg.calls)
+	}
+
+	// once the ttl is up they're looked up again
+	tg.calls = 0
+	tg.roles = []string{"superuser"}
+	now = now.Add(59 * time.Second)
+	if roles, _ := c.Roles(context.Background(), "sam_pull", "sam_pull"); !slices.Equal(roles, []string{"globaldesigner"}) || tg.calls != 0 {
+		t.Fatalf("the roles should be cached until the ttl is up. Got %v after %d calls", roles, tg.calls)
+	}
+	now = now.Add(2 * time.Second)
+	if roles, _ := c.Roles(context.Background(), "sam_pull", "sam_pull"); !slices.Equal(roles, []string{"superuser"}) || tg.calls != 1 {
+		t.Fatalf("the roles should be looked up again after the ttl. Got %v after %d calls", roles, tg.calls)
+	}
+
+	// or straight away once they're invalidated
+	tg.roles = []string{"globalobserver"}
+	c.Invalidate("Miss_Take")
+	if roles, _ := c.Roles(context.Background(), "sam_pull", "sam_pull"); !slices.Equal(roles, []string{"superuser"}) {
+		t.Fatalf("invalidating another user shouldn't change the cached roles. Got %v", roles)
+	}
+	c.Invalidate("sam_pull")
+	if roles, _ := c.Roles(context.Background(), "sam_pull", "sam_pull"); !slices.Equal(roles, []string{"globalobserver"}) || tg.calls != 2 {
+		t.Fatalf("the roles should be looked up again after they're invalidated. Got %v after %d calls", roles, tg.calls)
+	}
+	c.InvalidateAll()
+	if c.Roles(context.Background(), "sam_pull", "sam_pull"); tg.calls != 3 {
+		t.Fatalf("the roles should be looked up again after the cache is cleared. TigerGraph was asked %d times", tg.calls)
+	}
+}
+
+func TestRoleCache_NoTTL(t *testing.T) {
+	tg := &countingRoles{roles: []string{"globaldesigner"}}
+	c := NewRoleCache(tg.auth, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Roles(context.Background(), "sam_pull", "sam_pull"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tg.calls != 2 {
+		t.Fatalf("nothing should be cached without a ttl. TigerGraph was asked %d times", tg.calls)
+	}
+}
+
+func TestRoleCache_ProviderFails(t *testing.T) {
+	tg := &countingRoles{roles: []string{"globaldesigner"}, err: errors.New("connection refused")}
+	c := NewRoleCache(tg.auth, time.Minute)
+	if _, err := c.Roles(context.Background(), "sam_pull", "sam_pull"); err == nil {
+		t.Fatal("the provider's error should be returned")
+	}
+	tg.err = nil
+	if roles, err := c.Roles(context.Background(), "sam_pull", "sam_pull"); err != nil || !slices.Equal(roles, []string{"globaldesigner"}) || tg.calls != 2 {
+		t.Fatalf("a failed lookup shouldn't be cached. Got %v, %v after %d calls", roles, err, tg.calls)
+	}
+}
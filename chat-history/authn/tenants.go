@@ -0,0 +1,50 @@
+package authn
+
+import (
+	"chat-history/tenant"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNoTenant is returned by Tenants for requests that don't say which tenant they're for
+	ErrNoTenant = errors.New("the request has no tenant, set the " + tenant.Header + " header")
+	// ErrInvalidTenant is returned by Tenants for a tenant that can't be one, see tenant.Valid
+	ErrInvalidTenant = errors.New("tenants must be 1 to 64 printable ASCII characters without spaces")
+	// ErrWrongTenant is returned by Tenants when the tenant header isn't the one of the caller's token
+	ErrWrongTenant = errors.New("the caller can't use the tenant")
+)
+
+// Tenants is the Authenticator of deployments that serve several tenants, see config.ChatDbConfig.MultiTenant.
+// It authenticates with the one it wraps, and the caller's tenant is their token's, or else the one of the
+// tenant.Header header
+type Tenants struct {
+	next Authenticator
+}
+
+// NewTenants returns a Tenants that authenticates callers with next
+func NewTenants(next Authenticator) *Tenants {
+	return &Tenants{next: next}
+}
+
+func (t *Tenants) Authenticate(r *http.Request) (Identity, error) {
+	id, err := t.next.Authenticate(r)
+	if err != nil {
+		return Identity{}, err
+	}
+	header := r.Header.Get(tenant.Header)
+	switch {
+	case id.Tenant != "" && header != "" && header != id.Tenant:
+		return Identity{}, fmt.Errorf("%w: %s is in tenant %s", ErrWrongTenant, id.UserId, id.Tenant)
+	case id.Tenant == "":
+		id.Tenant = header
+	}
+	if id.Tenant == "" {
+		return Identity{}, ErrNoTenant
+	}
+	if !tenant.Valid(id.Tenant) {
+		return Identity{}, ErrInvalidTenant
+	}
+	return id, nil
+}
@@ -0,0 +1,58 @@
+package authn
+
+import (
+	"chat-history/tenant"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	j := setupJWT(t, secret)
+	j.tenantClaim = "org.tenant"
+	tenants := NewTenants(j)
+	authenticate := func(claimed, header string) (Identity, error) {
+		claims := validClaims()
+		if claimed != "" {
+			claims["org"] = map[string]any{"tenant": claimed}
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+sign(t, "HS256", hmacSigner(secret), claims))
+		if header != "" {
+			r.Header.Set(tenant.Header, header)
+		}
+		return tenants.Authenticate(r)
+	}
+
+	// the token's tenant, which the header can repeat
+	for _, header := range []string{"", "acme"} {
+		if id, err := authenticate("acme", header); err != nil || id.Tenant != "acme" || id.UserId != "sam_pull" {
+			t.Fatalf("the caller should be in their token's tenant with header %q. Got %+v, %v", header, id, err)
+		}
+	}
+	// or the header's, for tokens that don't say
+	if id, err := authenticate("", "globex"); err != nil || id.Tenant != "globex" {
+		t.Fatalf("the caller should be in the header's tenant. Got %+v, %v", id, err)
+	}
+
+	if _, err := authenticate("acme", "globex"); !errors.Is(err, ErrWrongTenant) {
+		t.Fatalf("a header for another tenant than the token's should be ErrWrongTenant, got %v", err)
+	}
+	if _, err := authenticate("", ""); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("a request without a tenant should be ErrNoTenant, got %v", err)
+	}
+	for _, invalid := range []string{"ac me", "acme\n", strings.Repeat("a", 65)} {
+		if _, err := authenticate("", invalid); !errors.Is(err, ErrInvalidTenant) {
+			t.Fatalf("%q should be ErrInvalidTenant, got %v", invalid, err)
+		}
+	}
+
+	// the caller has to be authenticated first
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(tenant.Header, "acme")
+	if _, err := tenants.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("a request without credentials should be ErrUnauthenticated, got %v", err)
+	}
+}
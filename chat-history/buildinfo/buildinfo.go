@@ -0,0 +1,39 @@
+// Package buildinfo has what the binary was built from. Version and Commit are set when it's built, with
+//
+//	go build -ldflags "-X chat-history/buildinfo.Version=v1.2.0 -X chat-history/buildinfo.Commit=$(git rev-parse HEAD)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// the release, dev for builds that didn't set it
+	Version = "dev"
+	// the git commit. Without it, it's the one go build stamped into the binary, if it was built in a checkout
+	Commit = ""
+)
+
+// Info is the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of the running binary. Commit is unknown if it wasn't set and go build didn't stamp it
+func Get() Info {
+	commit := Commit
+	if commit == "" {
+		commit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					commit = s.Value
+				}
+			}
+		}
+	}
+	return Info{Version: Version, Commit: commit, GoVersion: runtime.Version()}
+}
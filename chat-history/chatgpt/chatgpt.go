@@ -0,0 +1,196 @@
+// Package chatgpt reads the conversations.json of a ChatGPT data export into conversations of the chat history
+package chatgpt
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the namespace of the ids of imported conversations and messages
+var namespace = uuid.MustParse("6f1c6a4e-3f0b-4d5e-9a57-2b8f4c0d7e61")
+
+// Conversation is a conversation of the export, ready to import
+type Conversation struct {
+	// its position in the export
+	Index          int
+	ConversationId uuid.UUID
+	Title          string
+	// the messages from the first to the one the conversation was left at, each replying to the one before
+	Messages []structs.Message
+	// why the conversation can't be imported. It has no messages if it's set
+	Err error
+}
+
+// ErrNotAnExport is returned when what's read isn't a ChatGPT export at all
+var ErrNotAnExport = errors.New("not a ChatGPT export, it must be the JSON array of conversations.json")
+
+type conversation struct {
+	Title          string          `json:"title"`
+	Id             string          `json:"id"`
+	ConversationId string          `json:"conversation_id"`
+	CurrentNode    string          `json:"current_node"`
+	Mapping        map[string]node `json:"mapping"`
+}
+
+// node is a message of a conversation's tree. Regenerating or editing a message adds a child to its parent
+type node struct {
+	Message *message `json:"message"`
+	Parent  *string  `json:"parent"`
+}
+
+type message struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string `json:"content_type"`
+		// strings, and objects for images and files
+		Parts []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// Parse reads a ChatGPT export for userId. Conversations that can't be read have Err set, the rest are still returned.
+//
+// Only the branch the conversation was left at is read, following the parents of its current node.
+// Messages of the user and ChatGPT's replies are read, as user and system messages. System prompts, tool
+// calls and hidden messages are left out, and of the rest only the text is. The ids of the conversations and
+// messages are derived from userId and the export's, so the same export always has the same ids for the user
+func Parse(r io.Reader, userId string) ([]Conversation, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAnExport, err)
+	}
+
+	convos := make([]Conversation, len(items))
+	for i, item := range items {
+		convos[i] = parseConversation(i, item, userId)
+	}
+	return convos, nil
+}
+
+func parseConversation(i int, item json.RawMessage, userId string) Conversation {
+	var c conversation
+	if err := json.Unmarshal(item, &c); err != nil {
+		return Conversation{Index: i, Err: fmt.Errorf("it's not a conversation: %w", err)}
+	}
+	convo := Conversation{Index: i, Title: c.Title}
+	id := c.ConversationId
+	if id == "" {
+		id = c.Id
+	}
+	if id == "" {
+		convo.Err = errors.New("it has no id")
+		return convo
+	}
+	convo.ConversationId = newId(userId, id)
+
+	path, err := c.path()
+	if err != nil {
+		convo.Err = err
+		return convo
+	}
+
+	var parent *uuid.UUID
+	var last time.Time
+	for _, nodeId := range path {
+		m := c.Mapping[nodeId].Message
+		content := m.text()
+		if m.Metadata.Hidden || strings.TrimSpace(content) == "" {
+			continue
+		}
+		var role structs.MessagengerRole
+		switch m.Author.Role {
+		case "user":
+			role = structs.UserRole
+		case "assistant":
+			role = structs.SystemRole
+		default:
+			continue
+		}
+
+		msg := structs.Message{MessageId: newId(userId, id+"/"+nodeId), ParentId: parent, Role: role, Content: content}
+		if role == structs.SystemRole {
+			msg.ModelName = m.Metadata.ModelSlug
+		}
+		// messages can't go back in time, and the ones without a time are after the one before
+		if m.CreateTime != nil {
+			sec, frac := math.Modf(*m.CreateTime)
+			msg.CreatedAt = time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+		}
+		if msg.CreatedAt.Before(last) {
+			msg.CreatedAt = last
+		}
+		last = msg.CreatedAt
+
+		convo.Messages = append(convo.Messages, msg)
+		parent = &convo.Messages[len(convo.Messages)-1].MessageId
+	}
+	if len(convo.Messages) == 0 {
+		convo.Err = errors.New("it has no messages")
+	}
+	return convo
+}
+
+// path is the ids of the nodes from the root of the conversation's tree to its current node
+func (c conversation) path() ([]string, error) {
+	if c.CurrentNode == "" {
+		return nil, errors.New("it has no current_node")
+	}
+	var path []string
+	seen := map[string]bool{}
+	for id := c.CurrentNode; ; {
+		n, ok := c.Mapping[id]
+		if !ok {
+			return nil, fmt.Errorf("node %q is not in its mapping", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("node %q is its own ancestor", id)
+		}
+		seen[id] = true
+		if n.Message != nil {
+			path = append(path, id)
+		}
+		if n.Parent == nil || *n.Parent == "" {
+			break
+		}
+		id = *n.Parent
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// text is the parts of the message that are text. It's empty for code, browsing results and the like
+func (m *message) text() string {
+	switch m.Content.ContentType {
+	case "text", "multimodal_text":
+		var parts []string
+		for _, raw := range m.Content.Parts {
+			var part string
+			// images and files are objects
+			if json.Unmarshal(raw, &part) == nil && part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+func newId(userId, exportId string) uuid.UUID {
+	return uuid.NewSHA1(namespace, []byte(userId+"/"+exportId))
+}
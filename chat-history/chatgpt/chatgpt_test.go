@@ -0,0 +1,112 @@
+package chatgpt
+
+import (
+	"chat-history/structs"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const USER = "sam"
+
+func parseFixture(t *testing.T, userId string) []Conversation {
+	f, err := os.Open("testdata/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	convos, err := Parse(f, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return convos
+}
+
+func TestParse(t *testing.T) {
+	convos := parseFixture(t, USER)
+	if len(convos) != 5 {
+		t.Fatalf("expected every conversation of the export, got %d", len(convos))
+	}
+	for i, c := range convos {
+		if c.Index != i {
+			t.Fatalf("conversation %d has index %d", i, c.Index)
+		}
+	}
+
+	c := convos[0]
+	if c.Err != nil {
+		t.Fatal(c.Err)
+	}
+	if c.Title != "Graph databases" {
+		t.Fatalf("expected the title of the export, got %q", c.Title)
+	}
+	// the branch the conversation was left at, without the hidden system prompt and the code it ran
+	want := []struct {
+		role    structs.MessagengerRole
+		content string
+		model   string
+	}{
+		{structs.UserRole, "What is a graph database?", ""},
+		{structs.SystemRole, "It stores entities as vertices and relationships as edges.", "gpt-4"},
+		{structs.UserRole, "Plot this graph", ""},
+		{structs.SystemRole, "Here is the plot.", "gpt-4"},
+	}
+	if len(c.Messages) != len(want) {
+		t.Fatalf("expected %d messages, got %d: %+v", len(want), len(c.Messages), c.Messages)
+	}
+	for i, m := range c.Messages {
+		if m.Role != want[i].role || m.Content != want[i].content || m.ModelName != want[i].model {
+			t.Fatalf("message %d should be %+v, got %s %q %q", i, want[i], m.Role, m.Content, m.ModelName)
+		}
+		if i == 0 && m.ParentId != nil {
+			t.Fatalf("the first message shouldn't have a parent, it has %s", m.ParentId)
+		}
+		if i > 0 && (m.ParentId == nil || *m.ParentId != c.Messages[i-1].MessageId) {
+			t.Fatalf("message %d should reply to the one before", i)
+		}
+	}
+	if first := time.Unix(1700000000, int64(500*time.Millisecond)); !c.Messages[0].CreatedAt.Equal(first) {
+		t.Fatalf("expected the message to be sent at %v, got %v", first, c.Messages[0].CreatedAt)
+	}
+	// the last reply's time is before the message it replies to
+	if !c.Messages[3].CreatedAt.Equal(c.Messages[2].CreatedAt) {
+		t.Fatalf("messages shouldn't go back in time: %v is before %v", c.Messages[3].CreatedAt, c.Messages[2].CreatedAt)
+	}
+
+	for i, want := range map[int]string{1: "it's not a conversation", 2: `node "gone" is not in its mapping`, 3: "it has no messages"} {
+		if convos[i].Err == nil || !strings.Contains(convos[i].Err.Error(), want) {
+			t.Fatalf("conversation %d should be reported with %q, got %v", i, want, convos[i].Err)
+		}
+		if len(convos[i].Messages) > 0 {
+			t.Fatalf("conversation %d can't be imported, it shouldn't have messages", i)
+		}
+	}
+
+	// without a time, it's left for the import to fill in
+	if c := convos[4]; c.Err != nil || len(c.Messages) != 1 || !c.Messages[0].CreatedAt.IsZero() {
+		t.Fatalf("expected the untitled conversation's question without a time, got %+v", c)
+	}
+}
+
+func TestParse_Ids(t *testing.T) {
+	first, again, other := parseFixture(t, USER), parseFixture(t, USER), parseFixture(t, "alex")
+	if first[0].ConversationId != again[0].ConversationId || first[0].Messages[0].MessageId != again[0].Messages[0].MessageId {
+		t.Fatal("the same export should have the same ids for the user")
+	}
+	if first[0].ConversationId == other[0].ConversationId || first[0].Messages[0].MessageId == other[0].Messages[0].MessageId {
+		t.Fatal("the same export should have different ids for different users")
+	}
+	if first[0].ConversationId == first[4].ConversationId {
+		t.Fatal("conversations should have different ids")
+	}
+}
+
+func TestParse_NotAnExport(t *testing.T) {
+	for _, body := range []string{``, `{"title": "not in an array"}`, `[{"title": `} {
+		if _, err := Parse(strings.NewReader(body), USER); !errors.Is(err, ErrNotAnExport) {
+			t.Fatalf("%q should be rejected with ErrNotAnExport, got %v", body, err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	if !f.Now().Equal(start) {
+		t.Fatalf("the clock should start at %v, it's %v", start, f.Now())
+	}
+	f.Advance(90 * time.Minute)
+	if want := start.Add(90 * time.Minute); !f.Now().Equal(want) {
+		t.Fatalf("the clock should be at %v, it's %v", want, f.Now())
+	}
+	f.Set(start)
+	if !f.Now().Equal(start) {
+		t.Fatalf("the clock should be back at %v, it's %v", start, f.Now())
+	}
+}
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	now := Real.Now()
+	if now.Before(before) || now.After(time.Now()) {
+		t.Fatalf("the real clock should tell the time, it says %v", now)
+	}
+}
@@ -0,0 +1,126 @@
+// checkconfig validates config files before they're deployed, without starting the server.
+// It loads and validates them the way the server does, logs in to TigerGraph with the
+// credentials in db_config, and prints what it found. Secrets are redacted.
+//
+//	checkconfig -config server_config.json [-chat-config chat_config.json] [-skip-probe]
+//
+// It exits with 1 if anything failed.
+package main
+
+import (
+	"chat-history/config"
+	"chat-history/tigergraph"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run checks the config given by args, writes the report to out and returns the exit code
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("checkconfig", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILES"), "path to the config file")
+	chatConfigPath := flags.String("chat-config", os.Getenv("CHAT_CONFIG_FILE"), "path to a separate chat_config file")
+	skipProbe := flags.Bool("skip-probe", false, "don't log in to TigerGraph")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" && *chatConfigPath == "" {
+		fmt.Fprintln(out, "-config is required")
+		return 2
+	}
+
+	paths := map[string]string{}
+	if *configPath != "" {
+		paths["tgconfig"] = *configPath
+	}
+	if *chatConfigPath != "" {
+		paths["chatconfig"] = *chatConfigPath
+	}
+
+	r := report{out: out}
+	cfg, err := config.LoadConfig(paths)
+	if err != nil {
+		r.fail("config: %v", err)
+		return r.exitCode()
+	}
+	r.ok("config is valid")
+
+	// TgDbConfig redacts the password when it's marshalled
+	if b, err := json.MarshalIndent(cfg, "", "  "); err == nil {
+		fmt.Fprintf(out, "%s\n", b)
+	}
+
+	if *skipProbe {
+		r.warn("skipped logging in to TigerGraph")
+	} else {
+		probeTigerGraph(&r, cfg.TgDbConfig)
+	}
+	checkSecrets(&r, cfg)
+	return r.exitCode()
+}
+
+// probeTigerGraph logs in to TigerGraph. The token is thrown away with the client
+func probeTigerGraph(r *report, cfg config.TgDbConfig) {
+	baseURL := tigergraph.BaseURL(cfg.Hostname, cfg.GsPort)
+	client, err := tigergraph.NewTgClient(cfg)
+	if err != nil {
+		r.fail("db_config: %v", err)
+		return
+	}
+	if _, err := client.RequestToken(); err != nil {
+		r.fail("db_config: failed to log in to TigerGraph at %s as %s: %v", baseURL, cfg.Username, err)
+		return
+	}
+	r.ok("logged in to TigerGraph at %s as %s", baseURL, cfg.Username)
+}
+
+// checkSecrets checks the environment variables that secrets are read from are set
+func checkSecrets(r *report, cfg config.Config) {
+	if cfg.LLMConfig.Enabled() && cfg.LLMConfig.APIKeyEnv != "" {
+		if cfg.LLMConfig.APIKey() == "" {
+			r.fail("llm_config.api_key_env: %s is empty", cfg.LLMConfig.APIKeyEnv)
+		} else {
+			r.ok("llm_config.api_key_env: %s is set", cfg.LLMConfig.APIKeyEnv)
+		}
+	}
+	if env := cfg.ChatDbConfig.EncryptionKeyEnv; env != "" {
+		if key, _ := cfg.ChatDbConfig.EncryptionKey(); key == nil {
+			r.warn("chat_config.encryptionKeyEnv: %s is empty, messages will be stored in plaintext", env)
+		} else {
+			r.ok("chat_config.encryptionKeyEnv: %s has a %d-bit key", env, len(key)*8)
+		}
+	}
+}
+
+// report prints a line per check and remembers if any failed
+type report struct {
+	out    io.Writer
+	failed bool
+}
+
+func (r *report) ok(format string, args ...any) {
+	fmt.Fprintf(r.out, "OK    "+format+"\n", args...)
+}
+
+func (r *report) warn(format string, args ...any) {
+	fmt.Fprintf(r.out, "WARN  "+format+"\n", args...)
+}
+
+func (r *report) fail(format string, args ...any) {
+	r.failed = true
+	fmt.Fprintf(r.out, "FAIL  "+format+"\n", args...)
+}
+
+func (r *report) exitCode() int {
+	if r.failed {
+		return 1
+	}
+	return 0
+}
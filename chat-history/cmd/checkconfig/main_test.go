@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeTigerGraph only lets tigergraph/s3cret log in
+func fakeTigerGraph(t *testing.T) *url.URL {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "tigergraph" || p != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":true,"message":"bad credentials"}`))
+			return
+		}
+		w.Write([]byte(`{"error":false,"results":{"token":"t0ken"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u
+}
+
+func writeConfig(t *testing.T, tg *url.URL, password, roles string) string {
+	path := fmt.Sprintf("%s/server_config.json", t.TempDir())
+	data := fmt.Sprintf(`
+{
+	"db_config": {
+		"hostname": "http://%s",
+		"gsPort": "%s",
+		"username": "tigergraph",
+		"password": "%s"
+	},
+	"chat_config": {
+		"apiPort": "8002",
+		"dbPath": "chats.db",
+		"conversationAccessRoles": %s
+	}
+}`, tg.Hostname(), tg.Port(), password, roles)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_Valid(t *testing.T) {
+	path := writeConfig(t, fakeTigerGraph(t), "s3cret", `["superuser"]`)
+
+	var out bytes.Buffer
+	if code := run([]string{"-config", path}, &out); code != 0 {
+		t.Fatalf("a valid config should exit with 0. It exited with %d:\n%s", code, &out)
+	}
+	report := out.String()
+	if !strings.Contains(report, "OK    config is valid") || !strings.Contains(report, "OK    logged in to TigerGraph") {
+		t.Fatalf("report should say the config is valid and TigerGraph login worked:\n%s", report)
+	}
+	if strings.Contains(report, "s3cret") || strings.Contains(report, "t0ken") || !strings.Contains(report, `"password": "***"`) {
+		t.Fatalf("the password and token should be redacted:\n%s", report)
+	}
+}
+
+func TestRun_Invalid(t *testing.T) {
+	path := writeConfig(t, fakeTigerGraph(t), "s3cret", `[]`)
+
+	var out bytes.Buffer
+	if code := run([]string{"-config", path}, &out); code != 1 {
+		t.Fatalf("an invalid config should exit with 1. It exited with %d:\n%s", code, &out)
+	}
+	if report := out.String(); !strings.Contains(report, "FAIL  config: invalid config: chat_config.conversationAccessRoles") {
+		t.Fatalf("report should name the invalid field:\n%s", report)
+	}
+}
+
+func TestRun_LoginFails(t *testing.T) {
+	path := writeConfig(t, fakeTigerGraph(t), "wrong", `["superuser"]`)
+
+	var out bytes.Buffer
+	if code := run([]string{"-config", path}, &out); code != 1 {
+		t.Fatalf("a failed TigerGraph login should exit with 1. It exited with %d:\n%s", code, &out)
+	}
+	report := out.String()
+	if !strings.Contains(report, "FAIL  db_config: failed to log in to TigerGraph") || strings.Contains(report, "wrong") {
+		t.Fatalf("report should have the failed login without the password:\n%s", report)
+	}
+
+	// the login can be skipped, i.e., when TigerGraph isn't reachable from where it's run
+	out.Reset()
+	if code := run([]string{"-config", path, "-skip-probe"}, &out); code != 0 {
+		t.Fatalf("with -skip-probe the config should pass. It exited with %d:\n%s", code, &out)
+	}
+}
@@ -0,0 +1,62 @@
+// rekey re-encrypts the messages in a chat history database with a new key.
+// Keys are read from environment variables so they don't end up in shell history or ps.
+// Stop the server first, and back up the database.
+//
+//	GRAPHRAG_OLD_KEY=... GRAPHRAG_NEW_KEY=... rekey -db chats.db -old-key-env GRAPHRAG_OLD_KEY -new-key-env GRAPHRAG_NEW_KEY
+//
+// Leave out -old-key-env to encrypt a plaintext database, or -new-key-env to decrypt one.
+package main
+
+import (
+	"chat-history/config"
+	"chat-history/db"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	dbPath := flag.String("db", "chats.db", "path to the database")
+	logPath := flag.String("log", os.DevNull, "where to write the SQL log")
+	oldKeyEnv := flag.String("old-key-env", "", "environment variable with the base64 key the messages are encrypted with now")
+	newKeyEnv := flag.String("new-key-env", "", "environment variable with the base64 key to encrypt the messages with")
+	flag.Parse()
+
+	oldKey, err := readKey(*oldKeyEnv)
+	if err != nil {
+		fail(err)
+	}
+	newKey, err := readKey(*newKeyEnv)
+	if err != nil {
+		fail(err)
+	}
+	if oldKey == nil && newKey == nil {
+		fail(fmt.Errorf("at least one of -old-key-env and -new-key-env is required"))
+	}
+
+	n, err := db.Rekey(*dbPath, *logPath, oldKey, newKey)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Rekeyed %d messages in %s\n", n, *dbPath)
+}
+
+func readKey(env string) ([]byte, error) {
+	if env == "" {
+		return nil, nil
+	}
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, fmt.Errorf("%s is empty", env)
+	}
+	key, err := config.DecodeKey(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	return key, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "rekey:", err)
+	os.Exit(1)
+}
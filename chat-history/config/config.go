@@ -1,50 +1,1413 @@
 package config
 
 import (
+	"bytes"
+	"chat-history/moderation"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Supported LLM providers
+const (
+	ProviderOpenAI  = "openai"
+	ProviderAzure   = "azure"
+	ProviderBedrock = "bedrock"
+	ProviderOllama  = "ollama"
 )
 
+// LLMConfig is optional. When Provider is empty, LLM features are disabled
 type LLMConfig struct {
-	ModelName string `json:"model_name"`
+	Provider  string `json:"provider" env:"GRAPHRAG_LLM_PROVIDER"`
+	ModelName string `json:"model_name" env:"GRAPHRAG_LLM_MODEL_NAME"`
+	BaseURL   string `json:"base_url" env:"GRAPHRAG_LLM_BASE_URL"`
+	// name of the environment variable holding the API key, so the key itself isn't in the file
+	APIKeyEnv string `json:"api_key_env" env:"GRAPHRAG_LLM_API_KEY_ENV"`
+	// tokens of conversation history sent to the model. 0 uses the model's own context window
+	MaxContextTokens int `json:"max_context_tokens" env:"GRAPHRAG_LLM_MAX_CONTEXT_TOKENS"`
+	// models a conversation can use instead of model_name, which is always allowed
+	AllowedModels []string `json:"allowed_models" env:"GRAPHRAG_LLM_ALLOWED_MODELS"`
+	// the instructions replies are generated with, ahead of the conversation's messages, unless the conversation
+	// has a system prompt of its own. Empty sends none
+	DefaultSystemPrompt string `json:"default_system_prompt" env:"GRAPHRAG_LLM_DEFAULT_SYSTEM_PROMPT"`
+	// the provider's model that messages are embedded with for RetrieveSimilar. Empty doesn't embed them
+	EmbeddingModel string `json:"embedding_model" env:"GRAPHRAG_LLM_EMBEDDING_MODEL"`
+	// the most texts embedded in one call to the provider when concurrent requests are coalesced.
+	// 0 or 1 sends each request on its own
+	BatchSize int `json:"batch_size" env:"GRAPHRAG_LLM_BATCH_SIZE"`
+	// how long a batch waits for more texts before it's sent. 0 uses 20
+	BatchFlushMillis int `json:"batch_flush_millis" env:"GRAPHRAG_LLM_BATCH_FLUSH_MILLIS"`
+	// times a chat is sent again when the provider can't be reached or answers 429 or a 5xx. 0 doesn't retry.
+	// The wait starts at retry_base_millis and doubles with every retry. A streamed reply isn't sent again
+	// once a piece of it arrived
+	MaxRetries      int `json:"max_retries" env:"GRAPHRAG_LLM_MAX_RETRIES"`
+	RetryBaseMillis int `json:"retry_base_millis" env:"GRAPHRAG_LLM_RETRY_BASE_MILLIS"`
+	// after this many chats in a row failed that way, retries and all, chats fail right away for
+	// breaker_open_seconds. Then one is let through, and the others are once it succeeds. 0 never stops them
+	BreakerThreshold   int `json:"breaker_threshold" env:"GRAPHRAG_LLM_BREAKER_THRESHOLD"`
+	BreakerOpenSeconds int `json:"breaker_open_seconds" env:"GRAPHRAG_LLM_BREAKER_OPEN_SECONDS"`
+	// how long a chat with the provider can take, reply and all. 0 uses 60
+	TimeoutSeconds int `json:"timeout_seconds" env:"GRAPHRAG_LLM_TIMEOUT_SECONDS"`
+	// the providers a chat is sent to, in order, when the one before failed the way a chat is retried for (see
+	// max_retries) or timed out. They use their own model_name instead of the conversation's. A streamed reply
+	// isn't sent to the next one once a piece of it arrived
+	Fallbacks []LLMProvider `json:"fallbacks"`
+	// store the exact request sent to the provider for each reply, and the response it got, for admins to debug
+	// replies with (see GET /admin/messages/{messageId}/llm-io). They have the whole conversation in them, so it's
+	// off unless it's needed. They're deleted debug_retention_hours after the reply, 24 unless it's set
+	DebugStoreLLMIO     bool `json:"debug_store_llm_io" env:"GRAPHRAG_LLM_DEBUG_STORE_LLM_IO"`
+	DebugRetentionHours int  `json:"debug_retention_hours" env:"GRAPHRAG_LLM_DEBUG_RETENTION_HOURS"`
+}
+
+// LLMProvider is a provider chats fail over to, see LLMConfig.Fallbacks. The fields are those of LLMConfig
+type LLMProvider struct {
+	Provider       string `json:"provider"`
+	ModelName      string `json:"model_name"`
+	BaseURL        string `json:"base_url"`
+	APIKeyEnv      string `json:"api_key_env"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// Enabled reports whether an LLM provider is configured
+func (c LLMConfig) Enabled() bool {
+	return c.Provider != ""
+}
+
+// Validate checks that the fields the provider needs are set
+func (c LLMConfig) Validate() error {
+	if c.Provider == "" {
+		return nil
+	}
+	if err := c.provider().validate("llm_config"); err != nil {
+		return err
+	}
+	for i, p := range c.Fallbacks {
+		if p.Provider == "" {
+			return fmt.Errorf("llm_config.fallbacks[%d].provider: required", i)
+		}
+		if err := p.validate(fmt.Sprintf("llm_config.fallbacks[%d]", i)); err != nil {
+			return err
+		}
+	}
+	if c.MaxContextTokens < 0 {
+		return fmt.Errorf("llm_config.max_context_tokens: must not be negative")
+	}
+	for _, model := range c.AllowedModels {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("llm_config.allowed_models: must not have empty names")
+		}
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("llm_config.batch_size: must not be negative")
+	}
+	if c.BatchFlushMillis < 0 {
+		return fmt.Errorf("llm_config.batch_flush_millis: must not be negative")
+	}
+	for field, v := range map[string]int{"max_retries": c.MaxRetries, "retry_base_millis": c.RetryBaseMillis, "breaker_threshold": c.BreakerThreshold, "debug_retention_hours": c.DebugRetentionHours} {
+		if v < 0 {
+			return fmt.Errorf("llm_config.%s: must not be negative", field)
+		}
+	}
+	if c.BreakerThreshold > 0 && c.BreakerOpenSeconds <= 0 {
+		return fmt.Errorf("llm_config.breaker_open_seconds: must be positive when breaker_threshold is set")
+	}
+	if c.EmbeddingModel != "" && c.Provider == ProviderBedrock {
+		return fmt.Errorf("llm_config.embedding_model: not supported for provider %s", c.Provider)
+	}
+	return nil
+}
+
+// validate checks that the fields the provider needs are set, naming them after field
+func (p LLMProvider) validate(field string) error {
+	var required []string
+	switch p.Provider {
+	case ProviderOpenAI:
+		// BaseURL defaults to the OpenAI API
+		required = []string{"model_name", "api_key_env"}
+	case ProviderAzure:
+		// model_name is the deployment name
+		required = []string{"model_name", "base_url", "api_key_env"}
+	case ProviderBedrock:
+		// base_url is the bedrock-runtime endpoint of the region, api_key_env holds a Bedrock API key
+		required = []string{"model_name", "base_url", "api_key_env"}
+	case ProviderOllama:
+		required = []string{"model_name", "base_url"}
+	default:
+		return fmt.Errorf("%s.provider: unknown provider %q (must be one of %s, %s, %s, %s)",
+			field, p.Provider, ProviderOpenAI, ProviderAzure, ProviderBedrock, ProviderOllama)
+	}
+
+	values := map[string]string{"model_name": p.ModelName, "base_url": p.BaseURL, "api_key_env": p.APIKeyEnv}
+	for _, name := range required {
+		if values[name] == "" {
+			return fmt.Errorf("%s.%s: required for provider %s", field, name, p.Provider)
+		}
+	}
+	if p.BaseURL != "" {
+		if u, err := url.Parse(p.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s.base_url: %q is not a valid URL", field, p.BaseURL)
+		}
+	}
+	if p.TimeoutSeconds < 0 {
+		return fmt.Errorf("%s.timeout_seconds: must not be negative", field)
+	}
+	return nil
+}
+
+// provider is the fields of c that make its primary provider
+func (c LLMConfig) provider() LLMProvider {
+	return LLMProvider{Provider: c.Provider, ModelName: c.ModelName, BaseURL: c.BaseURL, APIKeyEnv: c.APIKeyEnv, TimeoutSeconds: c.TimeoutSeconds}
+}
+
+// Providers is c, then c with the provider fields of each of its fallbacks in order. None of them have fallbacks
+func (c LLMConfig) Providers() []LLMConfig {
+	providers := make([]LLMConfig, 0, len(c.Fallbacks)+1)
+	for _, p := range append([]LLMProvider{c.provider()}, c.Fallbacks...) {
+		cfg := c
+		cfg.Provider, cfg.ModelName, cfg.BaseURL, cfg.APIKeyEnv, cfg.TimeoutSeconds = p.Provider, p.ModelName, p.BaseURL, p.APIKeyEnv, p.TimeoutSeconds
+		cfg.Fallbacks = nil
+		providers = append(providers, cfg)
+	}
+	return providers
+}
+
+// DebugRetention is how long the requests and responses stored with DebugStoreLLMIO are kept
+func (c LLMConfig) DebugRetention() time.Duration {
+	if c.DebugRetentionHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.DebugRetentionHours) * time.Hour
+}
+
+// Timeout is how long a chat with the provider can take
+func (c LLMConfig) Timeout() time.Duration {
+	if c.TimeoutSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// AllowsModel reports whether a conversation can use the model, i.e., it's model_name or in allowed_models
+func (c LLMConfig) AllowsModel(model string) bool {
+	return c.Enabled() && (model == c.ModelName || slices.Contains(c.AllowedModels, model))
+}
+
+// APIKey reads the API key from the environment variable named by APIKeyEnv
+func (c LLMConfig) APIKey() string {
+	if c.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.APIKeyEnv)
+}
+
+// Supported authentication providers
+const (
+	AuthTigerGraph = "tigergraph"
+	AuthOIDC       = "oidc"
+)
+
+// What chat_config.messageLengthPolicy does with messages over maxMessageChars
+const (
+	LengthPolicyReject   = "reject"
+	LengthPolicyTruncate = "truncate"
+)
+
+// How chat_config.jsonCase names the fields of the JSON responses
+const (
+	JSONSnakeCase = "snake_case"
+	JSONCamelCase = "camelCase"
+)
+
+// Where chat_config.requestLogOutput and appLogOutput send a log: to stdout, to its file, or to both
+const (
+	LogOutputStdout = "stdout"
+	LogOutputFile   = "file"
+	LogOutputBoth   = "both"
+)
+
+// ToStdout reports whether a log with the output goes to stdout
+func ToStdout(output string) bool {
+	return output == LogOutputStdout || output == LogOutputBoth
+}
+
+// ToFile reports whether a log with the output goes to its file
+func ToFile(output string) bool {
+	return output == LogOutputFile || output == LogOutputBoth
+}
+
+// Features that chat_config.disabledFeatures can turn off
+const (
+	// GET /conversations/{conversationId}/summary
+	FeatureSummary = "summary"
+	// replies from the LLM: POST /conversations/{conversationId}/stream and .../resume
+	FeatureStream = "stream"
+	// GET /search, GET /search/all and GET /conversations/{conversationId}/similar, and embedding messages for it
+	FeatureSearch = "search"
+	// GET /conversations/{conversationId}/export and GET /user/{userId}/export
+	FeatureExport = "export"
+	// POST /conversations/{conversationId}/import and POST /import/chatgpt
+	FeatureImport = "import"
+	// the share links of conversations and GET /shared/{token}
+	FeatureShare = "share"
+)
+
+// Features are the features that can be disabled
+var Features = []string{FeatureSummary, FeatureStream, FeatureSearch, FeatureExport, FeatureImport, FeatureShare}
+
+// AuthConfig picks how callers are authenticated. With the tigergraph provider (the default) they
+// use basic auth with their TigerGraph credentials and their roles come from SHOW USER. With oidc
+// they send a JWT from the provider as a bearer token, verified with the configured keys
+type AuthConfig struct {
+	Provider string `json:"provider" env:"GRAPHRAG_AUTH_PROVIDER"`
+	// the token's iss and aud claims must match
+	Issuer   string `json:"issuer" env:"GRAPHRAG_AUTH_ISSUER"`
+	Audience string `json:"audience" env:"GRAPHRAG_AUTH_AUDIENCE"`
+	// PEM files with the RSA or ECDSA public keys tokens are signed with (RS256, ES256, ...)
+	PublicKeyPaths []string `json:"public_key_paths" env:"GRAPHRAG_AUTH_PUBLIC_KEY_PATHS"`
+	// name of the environment variable with the base64 secret (at least 32 bytes) of HS256 tokens
+	SecretEnv string `json:"secret_env" env:"GRAPHRAG_AUTH_SECRET_ENV"`
+	// the claims with the user id and the user's roles. Nested claims are separated by dots,
+	// i.e., realm_access.roles. They default to sub and roles
+	UserClaim  string `json:"user_claim" env:"GRAPHRAG_AUTH_USER_CLAIM"`
+	RolesClaim string `json:"roles_claim" env:"GRAPHRAG_AUTH_ROLES_CLAIM"`
+	// the claim with the caller's tenant, for chat_config.multiTenant. Callers whose tokens have one can only use
+	// that tenant. Empty by default, the tenant is then the X-Tenant-ID header's
+	TenantClaim string `json:"tenant_claim" env:"GRAPHRAG_AUTH_TENANT_CLAIM"`
+	// how long the roles of a TigerGraph user are remembered after they're looked up, so their requests don't
+	// each wait for TigerGraph. 0 looks them up on every request. POST /admin/user/{userId}/roles/refresh
+	// forgets them sooner
+	RoleCacheTTLSeconds int `json:"role_cache_ttl_seconds" env:"GRAPHRAG_AUTH_ROLE_CACHE_TTL_SECONDS"`
+}
+
+// the shortest HS256 secret accepted, the size of the hash
+const minAuthSecretBytes = 32
+
+// Secret reads the HS256 secret from the environment variable named by SecretEnv. It's nil if that's unset or empty
+func (c AuthConfig) Secret() ([]byte, error) {
+	if c.SecretEnv == "" || os.Getenv(c.SecretEnv) == "" {
+		return nil, nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(os.Getenv(c.SecretEnv))
+	if err != nil {
+		return nil, fmt.Errorf("secret is not valid base64: %w", err)
+	}
+	if len(secret) < minAuthSecretBytes {
+		return nil, fmt.Errorf("secret must be at least %d bytes. It's %d", minAuthSecretBytes, len(secret))
+	}
+	return secret, nil
+}
+
+// Validate checks that the fields the provider needs are set
+func (c AuthConfig) Validate() error {
+	if c.RoleCacheTTLSeconds < 0 {
+		return fmt.Errorf("auth_config.role_cache_ttl_seconds: must not be negative")
+	}
+	switch c.Provider {
+	case "", AuthTigerGraph:
+		return nil
+	case AuthOIDC:
+	default:
+		return fmt.Errorf("auth_config.provider: unknown provider %q (must be %s or %s)", c.Provider, AuthTigerGraph, AuthOIDC)
+	}
+
+	if c.Issuer == "" {
+		return fmt.Errorf("auth_config.issuer: required for provider %s", c.Provider)
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("auth_config.audience: required for provider %s", c.Provider)
+	}
+	secret, err := c.Secret()
+	if err != nil {
+		return fmt.Errorf("auth_config.secret_env: %s %w", c.SecretEnv, err)
+	}
+	if len(c.PublicKeyPaths) == 0 && secret == nil {
+		return fmt.Errorf("auth_config.public_key_paths: a public key or secret_env is required for provider %s", c.Provider)
+	}
+	for _, pth := range c.PublicKeyPaths {
+		if _, err := os.Stat(pth); err != nil {
+			return fmt.Errorf("auth_config.public_key_paths: %w", err)
+		}
+	}
+	return nil
 }
 
 type ChatDbConfig struct {
-	Port                    string   `json:"apiPort"`
-	DbPath                  string   `json:"dbPath"`
-	DbLogPath               string   `json:"dbLogPath"`
-	LogPath                 string   `json:"logPath"`
-	ConversationAccessRoles []string `json:"conversationAccessRoles"`
+	Port string `json:"apiPort" env:"GRAPHRAG_CHAT_PORT"`
+	// the path every route is served under, i.e., /chat-history behind a gateway that routes it to the
+	// service without taking it off. The links the service returns include it. Empty serves them at /
+	BasePath  string `json:"basePath" env:"GRAPHRAG_CHAT_BASE_PATH"`
+	DbPath    string `json:"dbPath" env:"GRAPHRAG_CHAT_DB_PATH"`
+	DbLogPath string `json:"dbLogPath" env:"GRAPHRAG_CHAT_DB_LOG_PATH"`
+	LogPath   string `json:"logPath" env:"GRAPHRAG_CHAT_LOG_PATH"`
+	// the octal mode dbPath and archiveDbPath are created with, or changed to if they exist. It defaults to 0600.
+	// Their directories are created readable by the service only
+	DbFileMode string `json:"dbFileMode" env:"GRAPHRAG_CHAT_DB_FILE_MODE"`
+	// the request log at LogPath is rotated to a timestamped file before it grows past MaxLogSizeMB.
+	// The newest MaxLogBackups rotated files are kept, and they're removed once they're MaxLogAgeDays
+	// old if it's set. They're gzipped if CompressLogBackups is set
+	MaxLogSizeMB       int  `json:"maxLogSizeMB" env:"GRAPHRAG_CHAT_MAX_LOG_SIZE_MB"`
+	MaxLogBackups      int  `json:"maxLogBackups" env:"GRAPHRAG_CHAT_MAX_LOG_BACKUPS"`
+	MaxLogAgeDays      int  `json:"maxLogAgeDays" env:"GRAPHRAG_CHAT_MAX_LOG_AGE_DAYS"`
+	CompressLogBackups bool `json:"compressLogBackups" env:"GRAPHRAG_CHAT_COMPRESS_LOG_BACKUPS"`
+	// where the request logs go: to LogPath with "file", the default, to stdout as the same JSON lines with
+	// "stdout" (i.e., for a container's log collector), or to both
+	RequestLogOutput string `json:"requestLogOutput" env:"GRAPHRAG_CHAT_REQUEST_LOG_OUTPUT"`
+	// where the service's own log goes: to stdout, to AppLogPath with "file", or to both. It's stderr if it's empty
+	AppLogOutput string `json:"appLogOutput" env:"GRAPHRAG_CHAT_APP_LOG_OUTPUT"`
+	AppLogPath   string `json:"appLogPath" env:"GRAPHRAG_CHAT_APP_LOG_PATH"`
+	// an OTLP/HTTP collector (i.e., http://collector:4318) the request logs are also sent to, as OpenTelemetry
+	// log records POSTed as JSON to its /v1/logs. With OtlpOnly they're only sent there, LogPath isn't written
+	OtlpEndpoint string `json:"otlpEndpoint" env:"GRAPHRAG_CHAT_OTLP_ENDPOINT"`
+	OtlpOnly     bool   `json:"otlpOnly" env:"GRAPHRAG_CHAT_OTLP_ONLY"`
+	// minimum level of the HTTP logs and of the service log on stderr: debug, info, warn or error
+	LogLevel                string   `json:"logLevel" env:"GRAPHRAG_CHAT_LOG_LEVEL"`
+	ConversationAccessRoles []string `json:"conversationAccessRoles" env:"GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES"`
+	// roles that can list and read every user's conversations through /admin. Each access is
+	// written to AuditLogPath. Nobody can use /admin if it's empty
+	AdminRoles []string `json:"adminRoles" env:"GRAPHRAG_CHAT_ADMIN_ROLES"`
+	// append-only JSONL file of the admin accesses, and of the access grants, share links and deletions of
+	// every user. It's separate from LogPath, a request whose entry can't be written fails
+	AuditLogPath string `json:"auditLogPath" env:"GRAPHRAG_CHAT_AUDIT_LOG_PATH"`
+	// number of days a deleted conversation can be restored before it's permanently removed
+	TrashRetentionDays int `json:"trashRetentionDays" env:"GRAPHRAG_CHAT_TRASH_RETENTION_DAYS"`
+	// how long a retry of POST /conversation with the same Idempotency-Key returns the conversation the
+	// first request created, instead of creating another
+	IdempotencyKeyHours int `json:"idempotencyKeyHours" env:"GRAPHRAG_CHAT_IDEMPOTENCY_KEY_HOURS"`
+	// how long a user sending the same message to a conversation over a WebSocket again, as clients do when they
+	// reconnect, gets the reply to the first one instead of another. 0 generates one every time
+	ReconnectDedupSeconds int `json:"reconnectDedupSeconds" env:"GRAPHRAG_CHAT_RECONNECT_DEDUP_SECONDS"`
+	// how long /healthz waits for TigerGraph before reporting it as down
+	HealthCheckTimeoutSeconds int `json:"healthCheckTimeoutSeconds" env:"GRAPHRAG_CHAT_HEALTH_CHECK_TIMEOUT_SECONDS"`
+	// log in to TigerGraph and connect to the LLM before taking requests, so the first ones don't wait for it.
+	// Startup isn't stopped if they're down
+	WarmupOnStart bool `json:"warmupOnStart" env:"GRAPHRAG_CHAT_WARMUP_ON_START"`
+	// how long in-flight requests get to finish on SIGTERM before the server stops anyway
+	ShutdownTimeoutSeconds int `json:"shutdownTimeoutSeconds" env:"GRAPHRAG_CHAT_SHUTDOWN_TIMEOUT_SECONDS"`
+	// how long a request gets before its store queries and TigerGraph and LLM calls are cancelled and it gets a 504.
+	// Streamed replies are cut off at it too
+	HandlerTimeoutSeconds int `json:"handlerTimeoutSeconds" env:"GRAPHRAG_CHAT_HANDLER_TIMEOUT_SECONDS"`
+	// how many conversation titles are generated with the LLM at once, after the requests that need them are done
+	AsyncWorkers int `json:"asyncWorkers" env:"GRAPHRAG_CHAT_ASYNC_WORKERS"`
+	// per-user limit on requests that change conversations: WriteBurst at once, then WriteRatePerSec.
+	// A WriteRatePerSec of 0 turns the limit off
+	WriteRatePerSec float64 `json:"writeRatePerSec" env:"GRAPHRAG_CHAT_WRITE_RATE_PER_SEC"`
+	WriteBurst      int     `json:"writeBurst" env:"GRAPHRAG_CHAT_WRITE_BURST"`
+	// scheduled backups of dbPath to BackupDir, disabled when BackupIntervalHours is 0.
+	// Only the newest BackupRetention backups are kept
+	BackupIntervalHours int    `json:"backupIntervalHours" env:"GRAPHRAG_CHAT_BACKUP_INTERVAL_HOURS"`
+	BackupDir           string `json:"backupDir" env:"GRAPHRAG_CHAT_BACKUP_DIR"`
+	BackupRetention     int    `json:"backupRetention" env:"GRAPHRAG_CHAT_BACKUP_RETENTION"`
+	// a second SQLite file archived conversations are moved to. Archiving is disabled if it's empty
+	ArchiveDbPath string `json:"archiveDbPath" env:"GRAPHRAG_CHAT_ARCHIVE_DB_PATH"`
+	// conversations that haven't been updated for this many days are moved to archiveDbPath. 0 never moves them
+	AutoArchiveAfterDays int `json:"autoArchiveAfterDays" env:"GRAPHRAG_CHAT_AUTO_ARCHIVE_AFTER_DAYS"`
+	// conversations that still have no messages this many hours after they were created are permanently deleted,
+	// unless they're pinned or tagged. 0 keeps them
+	EmptyConversationTTLHours int `json:"emptyConversationTTLHours" env:"GRAPHRAG_CHAT_EMPTY_CONVERSATION_TTL_HOURS"`
+	// largest request body accepted, bigger ones get a 413. Imports are limited by it too
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes" env:"GRAPHRAG_CHAT_MAX_REQUEST_BODY_BYTES"`
+	// most attachments a message can have
+	MaxAttachmentsPerMessage int `json:"maxAttachmentsPerMessage" env:"GRAPHRAG_CHAT_MAX_ATTACHMENTS_PER_MESSAGE"`
+	// most messages of a conversation that can be pinned
+	MaxPinsPerConversation int `json:"maxPinsPerConversation" env:"GRAPHRAG_CHAT_MAX_PINS_PER_CONVERSATION"`
+	// most conversations a user can pin to the top of their list
+	MaxPinnedConversations int `json:"maxPinnedConversations" env:"GRAPHRAG_CHAT_MAX_PINNED_CONVERSATIONS"`
+	// how many conversations a user can have, not counting the trash and the archive. Superusers can have more.
+	// 0 is no limit
+	MaxConversationsPerUser int `json:"maxConversationsPerUser" env:"GRAPHRAG_CHAT_MAX_CONVERSATIONS_PER_USER"`
+	// roles messages can have besides user, assistant, system and context. They default to tool and function, for
+	// tool-calling workflows. Messages with any other role are rejected
+	MessageRoles []string `json:"messageRoles" env:"GRAPHRAG_CHAT_MESSAGE_ROLES"`
+	// the longest content, in characters, a message that's written can have. 0 is no limit. With
+	// MessageLengthPolicy "reject", the default, longer messages are rejected with a 422, with "truncate" they're
+	// cut short and end with "[…truncated]". Either is logged
+	MaxMessageChars     int    `json:"maxMessageChars" env:"GRAPHRAG_CHAT_MAX_MESSAGE_CHARS"`
+	MessageLengthPolicy string `json:"messageLengthPolicy" env:"GRAPHRAG_CHAT_MESSAGE_LENGTH_POLICY"`
+	// content moderation of the messages that are written: they're rejected with a 422 and not stored if they match
+	// one of the ModerationBlocklist regular expressions (i.e., "(?i)\\bpassword\\b"), or if the service at
+	// ModerationURL flags them. It takes requests like OpenAI's /v1/moderations, with the API key in the environment
+	// variable named by ModerationAPIKeyEnv. A message is rejected if the service can't be reached too.
+	// Messages aren't moderated if both are empty
+	ModerationBlocklist []string `json:"moderationBlocklist" env:"GRAPHRAG_CHAT_MODERATION_BLOCKLIST"`
+	ModerationURL       string   `json:"moderationURL" env:"GRAPHRAG_CHAT_MODERATION_URL"`
+	ModerationAPIKeyEnv string   `json:"moderationAPIKeyEnv" env:"GRAPHRAG_CHAT_MODERATION_API_KEY_ENV"`
+	// features this instance doesn't serve, see Features. Their endpoints answer 501 and what only they
+	// use isn't started, i.e., search doesn't embed messages
+	DisabledFeatures []string `json:"disabledFeatures" env:"GRAPHRAG_CHAT_DISABLED_FEATURES"`
+	// how GET /search/all ranks matches on conversation names, tags and message content against each other.
+	// They default to 3, 2 and 1
+	SearchTitleWeight   float64 `json:"searchTitleWeight" env:"GRAPHRAG_CHAT_SEARCH_TITLE_WEIGHT"`
+	SearchTagWeight     float64 `json:"searchTagWeight" env:"GRAPHRAG_CHAT_SEARCH_TAG_WEIGHT"`
+	SearchContentWeight float64 `json:"searchContentWeight" env:"GRAPHRAG_CHAT_SEARCH_CONTENT_WEIGHT"`
+	// how long a write waits for another connection's lock on dbPath before failing with "database is locked"
+	BusyTimeoutMillis int `json:"busyTimeoutMillis" env:"GRAPHRAG_CHAT_BUSY_TIMEOUT_MILLIS"`
+	// the pool of connections to the database. 0 leaves the driver's default. A writable SQLite database
+	// always has a single open connection, since it only has one writer
+	MaxOpenConns           int `json:"maxOpenConns" env:"GRAPHRAG_CHAT_MAX_OPEN_CONNS"`
+	MaxIdleConns           int `json:"maxIdleConns" env:"GRAPHRAG_CHAT_MAX_IDLE_CONNS"`
+	ConnMaxLifetimeSeconds int `json:"connMaxLifetimeSeconds" env:"GRAPHRAG_CHAT_CONN_MAX_LIFETIME_SECONDS"`
+	// open dbPath read-only (i.e., for an analytics instance). Write endpoints return 405
+	ReadOnly bool `json:"readOnly" env:"GRAPHRAG_CHAT_READ_ONLY"`
+	// keep conversations in memory instead of dbPath, which must be empty or ":memory:", for integration tests
+	// and demos. They're gone when the service stops. It's refused in production, see Production
+	EphemeralMode bool `json:"ephemeralMode" env:"GRAPHRAG_CHAT_EPHEMERAL_MODE"`
+	// name of the environment variable with the base64 AES key (16, 24 or 32 bytes) that message
+	// contents and comments are encrypted with. Messages are stored in plaintext if it's unset or empty
+	EncryptionKeyEnv string `json:"encryptionKeyEnv" env:"GRAPHRAG_CHAT_ENCRYPTION_KEY_ENV"`
+	// name of the environment variable with the base64 key (16, 24 or 32 bytes) share links are signed
+	// with. If it's unset or empty a random key is used and links stop working on restart
+	ShareKeyEnv string `json:"shareKeyEnv" env:"GRAPHRAG_CHAT_SHARE_KEY_ENV"`
+	// how far the clocks of the replicas and the OIDC provider can be apart: share links and bearer tokens are
+	// still accepted for this long after they expire, and tokens this long before they're valid. 60 by default
+	ClockSkewSeconds int `json:"clockSkewSeconds" env:"GRAPHRAG_CHAT_CLOCK_SKEW_SECONDS"`
+	// serve several tenants from the instance, with their own conversations, users and everything else. Every
+	// request is for the tenant of the caller's token (see auth_config.tenant_claim) or else of its X-Tenant-ID
+	// header, which is trusted, so the proxy in front must set it. Requests without one are refused with a 400.
+	// The conversations of a single-tenant deployment that's switched are in no tenant, requests can't reach them
+	MultiTenant bool `json:"multiTenant" env:"GRAPHRAG_CHAT_MULTI_TENANT"`
+	// the JSON of the responses: JSONIndent indents it, to read it while debugging, and JSONCase names its fields
+	// in snake_case, the default, or camelCase for frontends that expect it. Responses are compact otherwise.
+	// Streamed events are always one line, the keys of maps like a conversation's metadata are kept as they are,
+	// and exported files and the database keep their own field names
+	JSONIndent bool   `json:"jsonIndent" env:"GRAPHRAG_CHAT_JSON_INDENT"`
+	JSONCase   string `json:"jsonCase" env:"GRAPHRAG_CHAT_JSON_CASE"`
+	// origins a browser frontend can call the API from, "*" for any. CORS is off if it's empty.
+	// AllowCredentials lets the browser send the Authorization header, it can't be used with "*"
+	AllowedOrigins   []string `json:"allowedOrigins" env:"GRAPHRAG_CHAT_ALLOWED_ORIGINS"`
+	AllowCredentials bool     `json:"allowCredentials" env:"GRAPHRAG_CHAT_ALLOW_CREDENTIALS"`
+	// the load balancers and proxies in front of the service, as CIDRs (i.e., 10.0.0.0/8) or single IPs. Requests
+	// from one of them are from the client in X-Forwarded-For or X-Real-IP, the others are from their peer
+	TrustedProxies []string `json:"trustedProxies" env:"GRAPHRAG_CHAT_TRUSTED_PROXIES"`
+	// URL every conversation.created and message.appended event is POSTed to, for other services to act on.
+	// Webhooks are off if it's empty. Deliveries are signed with the secret in the environment variable
+	// named by WebhookSecretEnv, if it's set
+	WebhookURL       string `json:"webhookURL" env:"GRAPHRAG_CHAT_WEBHOOK_URL"`
+	WebhookSecretEnv string `json:"webhookSecretEnv" env:"GRAPHRAG_CHAT_WEBHOOK_SECRET_ENV"`
+	// don't fail on keys of chat_config and auth_config that chat-history doesn't know, i.e., ones a newer
+	// version added. They're most likely misspelled, so they're rejected unless this is set
+	AllowUnknownFields bool `json:"allowUnknownFields" env:"GRAPHRAG_CHAT_ALLOW_UNKNOWN_FIELDS"`
+	// let every request through without checking its credentials, as the basic auth username (or "dev") with
+	// DevRoles, to run the service locally without TigerGraph. DevRoles default to conversationAccessRoles.
+	// It's refused in production, see Production
+	DevMode  bool     `json:"devMode" env:"GRAPHRAG_CHAT_DEV_MODE"`
+	DevRoles []string `json:"devRoles" env:"GRAPHRAG_CHAT_DEV_ROLES"`
+}
+
+// Level is LogLevel as a slog.Level
+func (c ChatDbConfig) Level() (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(c.LogLevel))
+	return l, err
+}
+
+// EmptyConversationTTL is how long a conversation can go without messages before it's deleted, 0 if they're kept
+func (c ChatDbConfig) EmptyConversationTTL() time.Duration {
+	return time.Duration(c.EmptyConversationTTLHours) * time.Hour
+}
+
+// FileMode is DbFileMode as an os.FileMode, 0600 if it's empty
+func (c ChatDbConfig) FileMode() (os.FileMode, error) {
+	if c.DbFileMode == "" {
+		return 0600, nil
+	}
+	mode, err := strconv.ParseUint(c.DbFileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("must be an octal mode like 0600")
+	}
+	if mode&0600 != 0600 {
+		return 0, fmt.Errorf("the service must be able to read and write the file")
+	}
+	return os.FileMode(mode), nil
+}
+
+// EncryptionKey decodes the key in the environment variable named by EncryptionKeyEnv.
+// It's nil if either is unset
+func (c ChatDbConfig) EncryptionKey() ([]byte, error) {
+	return DecodeKey(os.Getenv(c.EncryptionKeyEnv))
+}
+
+// ShareKey decodes the key in the environment variable named by ShareKeyEnv.
+// It's nil if either is unset
+func (c ChatDbConfig) ShareKey() ([]byte, error) {
+	return DecodeKey(os.Getenv(c.ShareKeyEnv))
+}
+
+// Enabled reports whether the feature isn't in DisabledFeatures
+func (c ChatDbConfig) Enabled(feature string) bool {
+	return !slices.Contains(c.DisabledFeatures, feature)
+}
+
+// Proxies parses TrustedProxies. A single IP is a prefix with just itself
+func (c ChatDbConfig) Proxies() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR or an IP", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Moderator is the moderation ModerationBlocklist and ModerationURL configure, the blocklist first.
+// It's moderation.PassThrough if neither is set
+func (c ChatDbConfig) Moderator() (moderation.Moderator, error) {
+	var moderators []moderation.Moderator
+	if len(c.ModerationBlocklist) > 0 {
+		blocklist, err := moderation.NewBlocklist(c.ModerationBlocklist)
+		if err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, blocklist)
+	}
+	if c.ModerationURL != "" {
+		moderators = append(moderators, moderation.NewAPI(c.ModerationURL, os.Getenv(c.ModerationAPIKeyEnv)))
+	}
+	if len(moderators) == 0 {
+		return moderation.PassThrough{}, nil
+	}
+	return moderation.All(moderators...), nil
+}
+
+// WebhookSecret reads the secret in the environment variable named by WebhookSecretEnv.
+// It's nil if either is unset
+func (c ChatDbConfig) WebhookSecret() []byte {
+	if c.WebhookSecretEnv == "" || os.Getenv(c.WebhookSecretEnv) == "" {
+		return nil
+	}
+	return []byte(os.Getenv(c.WebhookSecretEnv))
 }
 
 type TgDbConfig struct {
-	Hostname string `json:"hostname"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	GsPort   string `json:"gsPort"`
+	Hostname string `json:"hostname" env:"GRAPHRAG_DB_HOSTNAME"`
+	Username string `json:"username" env:"GRAPHRAG_DB_USERNAME"`
+	Password string `json:"password" env:"GRAPHRAG_DB_PASSWORD"`
+	GsPort   string `json:"gsPort" env:"GRAPHRAG_DB_GS_PORT"`
+	// standby TigerGraphs with the same users and graph, i.e., http://tg-standby. TgClient fails over to them in
+	// order when it can't connect to hostname. They're on gsPort unless the URL has a port
+	Replicas []string `json:"replicas" env:"GRAPHRAG_DB_REPLICAS"`
+	// graph the installed queries of TgClient.RunQuery are run on
+	Graphname string `json:"graphname" env:"GRAPHRAG_DB_GRAPHNAME"`
+	// the installed queries TgClient.RunQuery can run, by name. Empty allows every query
+	AllowedQueries []string `json:"allowedQueries" env:"GRAPHRAG_DB_ALLOWED_QUERIES"`
+	// PEM file with the CA that signed TigerGraph's certificate, trusted on top of the system roots
+	CACertPath string `json:"caCertPath" env:"GRAPHRAG_DB_CA_CERT_PATH"`
+	// don't verify TigerGraph's certificate at all. Only for testing
+	InsecureSkipVerify bool `json:"insecureSkipVerify" env:"GRAPHRAG_DB_INSECURE_SKIP_VERIFY"`
+	// how long a request to TigerGraph can take, including reading the response
+	RequestTimeoutSeconds int `json:"requestTimeoutSeconds" env:"GRAPHRAG_DB_REQUEST_TIMEOUT_SECONDS"`
+	// idle connections kept open to TigerGraph for reuse
+	MaxIdleConns int `json:"maxIdleConns" env:"GRAPHRAG_DB_MAX_IDLE_CONNS"`
+	// times an idempotent request is retried after a connection error or 5xx, 0 never retries.
+	// The wait starts at RetryBaseMillis and doubles with every retry
+	MaxRetries      int `json:"maxRetries" env:"GRAPHRAG_DB_MAX_RETRIES"`
+	RetryBaseMillis int `json:"retryBaseMillis" env:"GRAPHRAG_DB_RETRY_BASE_MILLIS"`
 	// GetToken string `json:"getToken"`
-	// DefaultTimeout       string `json:"default_timeout"`
-	// DefaultMemThreshold string `json:"default_mem_threshold"`
-	// DefaultThreadLimit  string `json:"default_thread_limit"`
+	// limits sent with every query. 0 leaves it to TigerGraph's own default
+	DefaultTimeout      int `json:"default_timeout" env:"GRAPHRAG_DB_DEFAULT_TIMEOUT"`             // seconds
+	DefaultMemThreshold int `json:"default_mem_threshold" env:"GRAPHRAG_DB_DEFAULT_MEM_THRESHOLD"` // MB
+	DefaultThreadLimit  int `json:"default_thread_limit" env:"GRAPHRAG_DB_DEFAULT_THREAD_LIMIT"`
+}
+
+const redacted = "***"
+
+// redact returns a copy of c that is safe to log. The original keeps the real
+// password so it can still be used for connections.
+func (c TgDbConfig) redact() tgDbConfig {
+	r := tgDbConfig(c)
+	if r.Password != "" {
+		r.Password = redacted
+	}
+	return r
+}
+
+// tgDbConfig has the same fields as TgDbConfig without its methods, so
+// formatting and marshalling it doesn't recurse.
+type tgDbConfig TgDbConfig
+
+func (c TgDbConfig) String() string {
+	return fmt.Sprintf("%+v", c.redact())
+}
+
+func (c TgDbConfig) GoString() string {
+	return fmt.Sprintf("%#v", c.redact())
+}
+
+func (c TgDbConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.redact())
 }
 
+// CurrentSchemaVersion is the schema_version of configs written for this version of the service.
+// Config files without a schema_version are version 1, from before it was added
+const CurrentSchemaVersion = 2
+
 type Config struct {
-	TgDbConfig TgDbConfig `json:"db_config"`
-	ChatDbConfig ChatDbConfig `json:"chat_config"`
-	// LLMConfig LLMConfig `json:"llm_config"`
+	// the layout of the file, so older files can be upgraded as fields are added. It's read from the
+	// tgconfig file and is CurrentSchemaVersion once the config is loaded
+	SchemaVersion int          `json:"schema_version"`
+	TgDbConfig    TgDbConfig   `json:"db_config"`
+	ChatDbConfig  ChatDbConfig `json:"chat_config"`
+	LLMConfig     LLMConfig    `json:"llm_config"`
+	AuthConfig    AuthConfig   `json:"auth_config"`
 }
 
+var (
+	// ErrConfigNotFound is wrapped by the errors of LoadConfig when a config file doesn't exist
+	ErrConfigNotFound = errors.New("config file not found")
+	// ErrConfigParse is wrapped by the errors of LoadConfig when a config file isn't valid JSON or YAML
+	ErrConfigParse = errors.New("config file is not valid")
+	// ErrUnknownField is wrapped by the errors of LoadConfig when a config file has a key chat-history doesn't know,
+	// unless chat_config.allowUnknownFields is set
+	ErrUnknownField = errors.New("config file has an unknown field")
+	// ErrConfigVersion is wrapped by the errors of LoadConfig when the schema_version isn't one this service can read
+	ErrConfigVersion = errors.New("config schema_version is not supported")
+)
+
+// LoadConfig starts from DefaultConfig, so partial files work, and reads the config file (JSON or YAML) at
+// paths["tgconfig"] (if present) over it, and then
+// the optional paths["chatconfig"] file, which holds only the chat_config
+// object. Values from the chatconfig file are merged over the chat_config
+// section of the tgconfig file. Finally any values set through environment
+// variables are overlaid; env values take precedence over both files.
+//
+// Environment variable mapping:
+//
+// The env tag on a field names the variable that overrides it. Names are the
+// field's key in upper snake case, prefixed with GRAPHRAG_DB_ for db_config,
+// GRAPHRAG_CHAT_ for chat_config, GRAPHRAG_LLM_ for llm_config and GRAPHRAG_AUTH_ for auth_config, except
+// chat_config.apiPort which is GRAPHRAG_CHAT_PORT. For example:
+//
+//	GRAPHRAG_DB_HOSTNAME                    db_config.hostname
+//	GRAPHRAG_DB_PASSWORD                    db_config.password
+//	GRAPHRAG_DB_GS_PORT                     db_config.gsPort
+//	GRAPHRAG_CHAT_PORT                      chat_config.apiPort
+//	GRAPHRAG_CHAT_DB_PATH                   chat_config.dbPath
+//	GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES chat_config.conversationAccessRoles
+//
+// List values are comma separated.
+//
+// Keys in chat_config and auth_config that chat-history doesn't know, and keys set twice, fail with
+// ErrUnknownField unless chat_config.allowUnknownFields is set. The other sections are shared with
+// the GraphRAG service, so only their types are checked.
 func LoadConfig(paths map[string]string) (Config, error) {
-	var config Config
+	return LoadConfigContext(context.Background(), FileSources(paths))
+}
+
+// LoadConfigContext is LoadConfig with the files read from sources["tgconfig"] and
+// sources["chatconfig"], which may be remote. It gives up once ctx is done, so a timeout
+// on ctx bounds how long an unreachable source can hold up startup
+func LoadConfigContext(ctx context.Context, sources map[string]Source) (Config, error) {
+	config := DefaultConfig()
 
-        if config_path, ok := paths["tgconfig"]; ok {
-	        b, err := os.ReadFile(config_path)
-	        if err != nil {
+	// the files as they were read, checked for unknown fields once it's known whether they're allowed
+	var tgFile, chatFile []byte
+	var err error
+	if src, ok := sources["tgconfig"]; ok {
+		if tgFile, err = readSource(ctx, src, &config); err != nil {
 			return Config{}, err
-	        }
-	        if err := json.Unmarshal(b, &config); err != nil {
-		        return Config{}, err
-	        }
-        }
+		}
+	}
+
+	// unmarshalling into the already populated struct only overwrites the keys present in the file
+	if src, ok := sources["chatconfig"]; ok {
+		if chatFile, err = readSource(ctx, src, &config.ChatDbConfig); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := migrateConfig(&config); err != nil {
+		return Config{}, err
+	}
+	if err := applyEnv(reflect.ValueOf(&config).Elem()); err != nil {
+		return Config{}, err
+	}
+	if !config.ChatDbConfig.AllowUnknownFields {
+		if tgFile != nil {
+			if err := checkFields(sources["tgconfig"].Name(), tgFile, &strictFile{}); err != nil {
+				return Config{}, err
+			}
+		}
+		if chatFile != nil {
+			if err := checkFields(sources["chatconfig"].Name(), chatFile, &ChatDbConfig{}); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+	applyDefaults(&config)
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
 	return config, nil
 }
+
+// readSource unmarshals a JSON or YAML file from src into v based on its extension, and returns the file.
+// YAML is converted to JSON first so the same json tags apply to both.
+// Errors parsing the file wrap ErrConfigParse
+func readSource(ctx context.Context, src Source, v any) ([]byte, error) {
+	b, err := src.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := src.Name()
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return b, parseError(name, json.Unmarshal(b, v))
+	case ".yaml", ".yml":
+		return b, parseError(name, yaml.Unmarshal(b, v))
+	}
+
+	// unknown extension, try both
+	jsonErr := json.Unmarshal(b, v)
+	if jsonErr == nil {
+		return b, nil
+	}
+	if yamlErr := yaml.Unmarshal(b, v); yamlErr != nil {
+		return nil, fmt.Errorf("%w: %s is neither valid JSON (%v) nor valid YAML (%v)", ErrConfigParse, name, jsonErr, yamlErr)
+	}
+	return b, nil
+}
+
+// strictFile is the tgconfig file as checkFields checks it. chat_config and auth_config are only read by
+// chat-history, so all of their keys must be known. The other sections are shared with the GraphRAG
+// service, which has keys of its own in them
+type strictFile struct {
+	SchemaVersion  int             `json:"schema_version"`
+	ChatDbConfig   ChatDbConfig    `json:"chat_config"`
+	AuthConfig     AuthConfig      `json:"auth_config"`
+	TgDbConfig     json.RawMessage `json:"db_config"`
+	LLMConfig      json.RawMessage `json:"llm_config"`
+	GraphRAGConfig json.RawMessage `json:"graphrag_config"`
+}
+
+// checkFields returns an error wrapping ErrUnknownField if the file b, which was already read into a
+// config, has a key v doesn't, i.e., a misspelled one, or the same key twice
+func checkFields(name string, b []byte, v any) error {
+	var err error
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".yaml" || ext == ".yml" || !json.Valid(b) {
+		// it also rejects duplicate keys
+		err = yaml.UnmarshalStrict(b, v)
+	} else if key, ok := duplicateKey(b); ok {
+		err = fmt.Errorf("key %q is set more than once", key)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	}
+	if err == nil {
+		return nil
+	}
+	// the decoders' messages are prefixed with where they come from, the rest names the key
+	msg := err.Error()
+	for _, start := range []string{"unknown field ", "key ", "mapping key "} {
+		if i := strings.Index(msg, start); i >= 0 {
+			msg = msg[i:]
+			break
+		}
+	}
+	return fmt.Errorf("%w: %s: %s (chat_config.allowUnknownFields turns this check off)", ErrUnknownField, name, msg)
+}
+
+// duplicateKey returns the first key that's in the same JSON object twice, at any depth
+func duplicateKey(b []byte) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var walk func() (string, bool)
+	walk = func() (string, bool) {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		switch tok {
+		case json.Delim('{'):
+			seen := map[string]bool{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return "", false
+				}
+				key, _ := keyTok.(string)
+				if seen[key] {
+					return key, true
+				}
+				seen[key] = true
+				if key, ok := walk(); ok {
+					return key, true
+				}
+			}
+			dec.Token()
+		case json.Delim('['):
+			for dec.More() {
+				if key, ok := walk(); ok {
+					return key, true
+				}
+			}
+			dec.Token()
+		}
+		return "", false
+	}
+	return walk()
+}
+
+// parseError wraps err in ErrConfigParse, with the byte offset of JSON errors
+func parseError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%w: %s at byte %d: %w", ErrConfigParse, name, syntaxErr.Offset, err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%w: %s at byte %d: %w", ErrConfigParse, name, typeErr.Offset, err)
+	}
+	return fmt.Errorf("%w: %s: %w", ErrConfigParse, name, err)
+}
+
+// migrations upgrade a config from the version it's keyed by to the next one
+var migrations = map[int]func(*Config){
+	// version 1 files predate the health check and shutdown timeouts. The ones that leave them out keep
+	// DefaultConfig's, which they're read over, and the zeros they set are kept
+	1: func(*Config) {},
+}
+
+// migrateConfig upgrades the config as it was read from the files to CurrentSchemaVersion, in memory.
+// The files themselves aren't changed. Versions newer than this service knows wrap ErrConfigVersion
+func migrateConfig(c *Config) error {
+	if c.SchemaVersion == 0 {
+		c.SchemaVersion = 1
+	}
+	if c.SchemaVersion < 0 || c.SchemaVersion > CurrentSchemaVersion {
+		return fmt.Errorf("%w: schema_version %d is not between 1 and %d, the newest this version of chat-history can read",
+			ErrConfigVersion, c.SchemaVersion, CurrentSchemaVersion)
+	}
+	for ; c.SchemaVersion < CurrentSchemaVersion; c.SchemaVersion++ {
+		migrations[c.SchemaVersion](c)
+	}
+	return nil
+}
+
+const (
+	defaultHealthCheckTimeoutSeconds = 5
+	defaultShutdownTimeoutSeconds    = 15
+)
+
+// MemoryDbPath is the dbPath of an in-memory database, see ChatDbConfig.EphemeralMode
+const MemoryDbPath = ":memory:"
+
+// EnvironmentVar names the environment variable with the deployment the service runs in, i.e., staging
+const EnvironmentVar = "GRAPHRAG_ENVIRONMENT"
+
+// Production reports whether EnvironmentVar says the service runs in production: prod or production
+func Production() bool {
+	env := strings.ToLower(strings.TrimSpace(os.Getenv(EnvironmentVar)))
+	return env == "prod" || env == "production"
+}
+
+// DefaultConfig returns the config LoadConfig reads the files and env over. The fields they don't set keep
+// these values, and the ones they set, to zero too, replace them. There are no defaults for what depends on
+// the deployment, like TigerGraph's hostname, dbPath and the roles
+func DefaultConfig() Config {
+	return Config{
+		TgDbConfig: TgDbConfig{
+			GsPort:                "14240",
+			RequestTimeoutSeconds: 30,
+			MaxIdleConns:          10,
+			RetryBaseMillis:       100,
+		},
+		LLMConfig: LLMConfig{
+			MaxRetries:         2,
+			RetryBaseMillis:    250,
+			BreakerThreshold:   5,
+			BreakerOpenSeconds: 30,
+		},
+		ChatDbConfig: ChatDbConfig{
+			Port:                      "8002",
+			JSONCase:                  JSONSnakeCase,
+			DbLogPath:                 "db.log",
+			DbFileMode:                "0600",
+			LogPath:                   "requestLogs.jsonl",
+			RequestLogOutput:          LogOutputFile,
+			MaxLogSizeMB:              100,
+			MaxLogBackups:             5,
+			LogLevel:                  "debug",
+			AuditLogPath:              "audit.jsonl",
+			TrashRetentionDays:        30,
+			IdempotencyKeyHours:       24,
+			ReconnectDedupSeconds:     30,
+			ClockSkewSeconds:          60,
+			BusyTimeoutMillis:         5000,
+			HealthCheckTimeoutSeconds: defaultHealthCheckTimeoutSeconds,
+			ShutdownTimeoutSeconds:    defaultShutdownTimeoutSeconds,
+			HandlerTimeoutSeconds:     120,
+			AsyncWorkers:              4,
+			WriteRatePerSec:           5,
+			WriteBurst:                20,
+			BackupRetention:           7,
+			MaxRequestBodyBytes:       10 << 20,
+			MaxAttachmentsPerMessage:  10,
+			MaxPinsPerConversation:    10,
+			MaxPinnedConversations:    5,
+			MessageRoles:              []string{"tool", "function"},
+			SearchTitleWeight:         3,
+			SearchTagWeight:           2,
+			SearchContentWeight:       1,
+		},
+		AuthConfig: AuthConfig{
+			Provider:            AuthTigerGraph,
+			UserClaim:           "sub",
+			RolesClaim:          "roles",
+			RoleCacheTTLSeconds: 60,
+		},
+	}
+}
+
+// maxMessageRoleLength is the longest role chat_config.messageRoles can add
+const maxMessageRoleLength = 32
+
+func validMessageRole(role string) bool {
+	if role == "" || len(role) > maxMessageRoleLength || role[0] < 'a' || role[0] > 'z' {
+		return false
+	}
+	for _, r := range role {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyDefaults fills in what the files and env left empty that can't be empty, and the defaults that
+// depend on other fields. Zeros that mean something, like a trashRetentionDays of 0, are kept
+func applyDefaults(c *Config) {
+	d := DefaultConfig()
+	for _, field := range []struct {
+		value    *string
+		fallback string
+	}{
+		{&c.ChatDbConfig.DbLogPath, d.ChatDbConfig.DbLogPath},
+		{&c.ChatDbConfig.LogPath, d.ChatDbConfig.LogPath},
+		{&c.ChatDbConfig.RequestLogOutput, d.ChatDbConfig.RequestLogOutput},
+		{&c.ChatDbConfig.LogLevel, d.ChatDbConfig.LogLevel},
+		{&c.ChatDbConfig.JSONCase, d.ChatDbConfig.JSONCase},
+		{&c.ChatDbConfig.AuditLogPath, d.ChatDbConfig.AuditLogPath},
+		{&c.AuthConfig.Provider, d.AuthConfig.Provider},
+		{&c.AuthConfig.UserClaim, d.AuthConfig.UserClaim},
+		{&c.AuthConfig.RolesClaim, d.AuthConfig.RolesClaim},
+	} {
+		if *field.value == "" {
+			*field.value = field.fallback
+		}
+	}
+	// health checks can't run in no time
+	if c.ChatDbConfig.HealthCheckTimeoutSeconds == 0 {
+		c.ChatDbConfig.HealthCheckTimeoutSeconds = d.ChatDbConfig.HealthCheckTimeoutSeconds
+	}
+	if c.ChatDbConfig.DevMode && len(c.ChatDbConfig.DevRoles) == 0 {
+		c.ChatDbConfig.DevRoles = slices.Clone(c.ChatDbConfig.ConversationAccessRoles)
+	}
+	c.ChatDbConfig.BasePath = strings.TrimRight(c.ChatDbConfig.BasePath, "/")
+}
+
+// Validate checks that the config has everything the service needs to run.
+// The returned error names the offending field using its key in the config file.
+func (c Config) Validate() error {
+	if u, err := url.Parse(c.TgDbConfig.Hostname); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("db_config.hostname: %q is not a valid URL", c.TgDbConfig.Hostname)
+	}
+	for _, replica := range c.TgDbConfig.Replicas {
+		if u, err := url.Parse(replica); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("db_config.replicas: %q is not a valid URL", replica)
+		}
+	}
+	if err := validatePort(c.TgDbConfig.GsPort); err != nil {
+		return fmt.Errorf("db_config.gsPort: %w", err)
+	}
+	if c.TgDbConfig.RequestTimeoutSeconds < 0 {
+		return fmt.Errorf("db_config.requestTimeoutSeconds: must not be negative")
+	}
+	if c.TgDbConfig.MaxIdleConns < 0 {
+		return fmt.Errorf("db_config.maxIdleConns: must not be negative")
+	}
+	if c.TgDbConfig.MaxRetries < 0 {
+		return fmt.Errorf("db_config.maxRetries: must not be negative")
+	}
+	if c.TgDbConfig.RetryBaseMillis < 0 {
+		return fmt.Errorf("db_config.retryBaseMillis: must not be negative")
+	}
+	if c.TgDbConfig.DefaultTimeout < 0 {
+		return fmt.Errorf("db_config.default_timeout: must not be negative")
+	}
+	if c.TgDbConfig.DefaultMemThreshold < 0 {
+		return fmt.Errorf("db_config.default_mem_threshold: must not be negative")
+	}
+	if c.TgDbConfig.DefaultThreadLimit < 0 {
+		return fmt.Errorf("db_config.default_thread_limit: must not be negative")
+	}
+	if c.TgDbConfig.CACertPath != "" {
+		if _, err := LoadCACert(c.TgDbConfig.CACertPath); err != nil {
+			return fmt.Errorf("db_config.caCertPath: %w", err)
+		}
+	}
+	if err := validatePort(c.ChatDbConfig.Port); err != nil {
+		return fmt.Errorf("chat_config.apiPort: %w", err)
+	}
+	if err := validateBasePath(c.ChatDbConfig.BasePath); err != nil {
+		return fmt.Errorf("chat_config.basePath: %w", err)
+	}
+	switch c.ChatDbConfig.JSONCase {
+	case "", JSONSnakeCase, JSONCamelCase:
+	default:
+		return fmt.Errorf("chat_config.jsonCase: unknown case %q (must be %s or %s)", c.ChatDbConfig.JSONCase, JSONSnakeCase, JSONCamelCase)
+	}
+	if c.ChatDbConfig.EphemeralMode {
+		if c.ChatDbConfig.DbPath != "" && c.ChatDbConfig.DbPath != MemoryDbPath {
+			return fmt.Errorf("chat_config.ephemeralMode: dbPath must be empty or %q", MemoryDbPath)
+		}
+		if Production() {
+			return fmt.Errorf("chat_config.ephemeralMode: can't be enabled in production (%s is %q)", EnvironmentVar, os.Getenv(EnvironmentVar))
+		}
+	} else if c.ChatDbConfig.DbPath == MemoryDbPath {
+		return fmt.Errorf("chat_config.dbPath: %q needs ephemeralMode", MemoryDbPath)
+	} else if c.ChatDbConfig.DbPath == "" {
+		return fmt.Errorf("chat_config.dbPath: must not be empty")
+	}
+	if c.ChatDbConfig.ArchiveDbPath != "" && filepath.Clean(c.ChatDbConfig.ArchiveDbPath) == filepath.Clean(c.ChatDbConfig.DbPath) {
+		return fmt.Errorf("chat_config.archiveDbPath: must not be dbPath")
+	}
+	if c.ChatDbConfig.AutoArchiveAfterDays < 0 {
+		return fmt.Errorf("chat_config.autoArchiveAfterDays: must not be negative")
+	}
+	if c.ChatDbConfig.AutoArchiveAfterDays > 0 && c.ChatDbConfig.ArchiveDbPath == "" {
+		return fmt.Errorf("chat_config.autoArchiveAfterDays: archiveDbPath must be set to archive conversations")
+	}
+	if c.ChatDbConfig.EmptyConversationTTLHours < 0 {
+		return fmt.Errorf("chat_config.emptyConversationTTLHours: must not be negative")
+	}
+	if len(c.ChatDbConfig.ConversationAccessRoles) == 0 {
+		return fmt.Errorf("chat_config.conversationAccessRoles: at least one role is required")
+	}
+	if c.ChatDbConfig.DevMode && Production() {
+		return fmt.Errorf("chat_config.devMode: can't be enabled in production (%s is %q)", EnvironmentVar, os.Getenv(EnvironmentVar))
+	}
+	if _, err := c.ChatDbConfig.FileMode(); err != nil {
+		return fmt.Errorf("chat_config.dbFileMode: %q %w", c.ChatDbConfig.DbFileMode, err)
+	}
+	if _, err := c.ChatDbConfig.Level(); c.ChatDbConfig.LogLevel != "" && err != nil {
+		return fmt.Errorf("chat_config.logLevel: %q must be debug, info, warn or error", c.ChatDbConfig.LogLevel)
+	}
+	for _, output := range []struct {
+		name, value, pathName, path string
+	}{
+		{"requestLogOutput", c.ChatDbConfig.RequestLogOutput, "logPath", c.ChatDbConfig.LogPath},
+		{"appLogOutput", c.ChatDbConfig.AppLogOutput, "appLogPath", c.ChatDbConfig.AppLogPath},
+	} {
+		switch output.value {
+		case "", LogOutputStdout, LogOutputFile, LogOutputBoth:
+		default:
+			return fmt.Errorf("chat_config.%s: unknown output %q (must be %s, %s or %s)", output.name, output.value, LogOutputStdout, LogOutputFile, LogOutputBoth)
+		}
+		if ToFile(output.value) && output.path == "" {
+			return fmt.Errorf("chat_config.%s: required when %s is %s", output.pathName, output.name, output.value)
+		}
+	}
+	if c.ChatDbConfig.TrashRetentionDays < 0 {
+		return fmt.Errorf("chat_config.trashRetentionDays: must not be negative")
+	}
+	if c.ChatDbConfig.ClockSkewSeconds < 0 {
+		return fmt.Errorf("chat_config.clockSkewSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.IdempotencyKeyHours < 0 {
+		return fmt.Errorf("chat_config.idempotencyKeyHours: must not be negative")
+	}
+	if c.ChatDbConfig.ReconnectDedupSeconds < 0 {
+		return fmt.Errorf("chat_config.reconnectDedupSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.HealthCheckTimeoutSeconds < 0 {
+		return fmt.Errorf("chat_config.healthCheckTimeoutSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.ShutdownTimeoutSeconds < 0 {
+		return fmt.Errorf("chat_config.shutdownTimeoutSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.HandlerTimeoutSeconds < 0 {
+		return fmt.Errorf("chat_config.handlerTimeoutSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.AsyncWorkers < 0 {
+		return fmt.Errorf("chat_config.asyncWorkers: must not be negative")
+	}
+	if c.ChatDbConfig.WriteRatePerSec < 0 {
+		return fmt.Errorf("chat_config.writeRatePerSec: must not be negative")
+	}
+	if c.ChatDbConfig.WriteBurst < 0 {
+		return fmt.Errorf("chat_config.writeBurst: must not be negative")
+	}
+	if c.ChatDbConfig.SearchTitleWeight < 0 {
+		return fmt.Errorf("chat_config.searchTitleWeight: must not be negative")
+	}
+	if c.ChatDbConfig.SearchTagWeight < 0 {
+		return fmt.Errorf("chat_config.searchTagWeight: must not be negative")
+	}
+	if c.ChatDbConfig.SearchContentWeight < 0 {
+		return fmt.Errorf("chat_config.searchContentWeight: must not be negative")
+	}
+	if c.ChatDbConfig.MaxLogSizeMB < 0 {
+		return fmt.Errorf("chat_config.maxLogSizeMB: must not be negative")
+	}
+	if c.ChatDbConfig.MaxLogBackups < 0 {
+		return fmt.Errorf("chat_config.maxLogBackups: must not be negative")
+	}
+	if c.ChatDbConfig.MaxLogAgeDays < 0 {
+		return fmt.Errorf("chat_config.maxLogAgeDays: must not be negative")
+	}
+	if c.ChatDbConfig.BackupIntervalHours < 0 {
+		return fmt.Errorf("chat_config.backupIntervalHours: must not be negative")
+	}
+	if c.ChatDbConfig.BackupIntervalHours > 0 && c.ChatDbConfig.BackupDir == "" {
+		return fmt.Errorf("chat_config.backupDir: required when backupIntervalHours is set")
+	}
+	if c.ChatDbConfig.BackupRetention < 0 {
+		return fmt.Errorf("chat_config.backupRetention: must not be negative")
+	}
+	if c.ChatDbConfig.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("chat_config.maxRequestBodyBytes: must not be negative")
+	}
+	if c.ChatDbConfig.MaxPinsPerConversation < 0 {
+		return fmt.Errorf("chat_config.maxPinsPerConversation: must not be negative")
+	}
+	if c.ChatDbConfig.MaxPinnedConversations < 0 {
+		return fmt.Errorf("chat_config.maxPinnedConversations: must not be negative")
+	}
+	if c.ChatDbConfig.MaxConversationsPerUser < 0 {
+		return fmt.Errorf("chat_config.maxConversationsPerUser: must not be negative")
+	}
+	for _, role := range c.ChatDbConfig.MessageRoles {
+		if !validMessageRole(role) {
+			return fmt.Errorf("chat_config.messageRoles: %q must be up to %d lowercase letters, digits, _ or -, starting with a letter", role, maxMessageRoleLength)
+		}
+	}
+	if c.ChatDbConfig.MaxMessageChars < 0 {
+		return fmt.Errorf("chat_config.maxMessageChars: must not be negative")
+	}
+	switch c.ChatDbConfig.MessageLengthPolicy {
+	case "", LengthPolicyReject, LengthPolicyTruncate:
+	default:
+		return fmt.Errorf("chat_config.messageLengthPolicy: unknown policy %q (must be %s or %s)", c.ChatDbConfig.MessageLengthPolicy, LengthPolicyReject, LengthPolicyTruncate)
+	}
+	for _, feature := range c.ChatDbConfig.DisabledFeatures {
+		if !slices.Contains(Features, feature) {
+			return fmt.Errorf("chat_config.disabledFeatures: unknown feature %q (must be one of %s)", feature, strings.Join(Features, ", "))
+		}
+	}
+	if c.ChatDbConfig.MaxAttachmentsPerMessage < 0 {
+		return fmt.Errorf("chat_config.maxAttachmentsPerMessage: must not be negative")
+	}
+	if c.ChatDbConfig.BusyTimeoutMillis < 0 {
+		return fmt.Errorf("chat_config.busyTimeoutMillis: must not be negative")
+	}
+	if c.ChatDbConfig.MaxOpenConns < 0 {
+		return fmt.Errorf("chat_config.maxOpenConns: must not be negative")
+	}
+	if c.ChatDbConfig.MaxIdleConns < 0 {
+		return fmt.Errorf("chat_config.maxIdleConns: must not be negative")
+	}
+	if c.ChatDbConfig.ConnMaxLifetimeSeconds < 0 {
+		return fmt.Errorf("chat_config.connMaxLifetimeSeconds: must not be negative")
+	}
+	if c.ChatDbConfig.EncryptionKeyEnv != "" {
+		if _, err := c.ChatDbConfig.EncryptionKey(); err != nil {
+			return fmt.Errorf("chat_config.encryptionKeyEnv: %s %w", c.ChatDbConfig.EncryptionKeyEnv, err)
+		}
+	}
+	for _, origin := range c.ChatDbConfig.AllowedOrigins {
+		if origin == "*" {
+			if c.ChatDbConfig.AllowCredentials {
+				return fmt.Errorf("chat_config.allowedOrigins: \"*\" can't be used with allowCredentials")
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("chat_config.allowedOrigins: %q must be \"*\" or scheme://host[:port]", origin)
+		}
+	}
+	if _, err := c.ChatDbConfig.Proxies(); err != nil {
+		return fmt.Errorf("chat_config.trustedProxies: %w", err)
+	}
+	if _, err := c.ChatDbConfig.Moderator(); err != nil {
+		return fmt.Errorf("chat_config.moderationBlocklist: %w", err)
+	}
+	if c.ChatDbConfig.ModerationURL != "" {
+		if u, err := url.Parse(c.ChatDbConfig.ModerationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("chat_config.moderationURL: %q is not a valid http(s) URL", c.ChatDbConfig.ModerationURL)
+		}
+	}
+	if c.ChatDbConfig.WebhookURL != "" {
+		if u, err := url.Parse(c.ChatDbConfig.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("chat_config.webhookURL: %q is not a valid http(s) URL", c.ChatDbConfig.WebhookURL)
+		}
+	}
+	if c.ChatDbConfig.OtlpEndpoint != "" {
+		if u, err := url.Parse(c.ChatDbConfig.OtlpEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("chat_config.otlpEndpoint: %q is not a valid http(s) URL", c.ChatDbConfig.OtlpEndpoint)
+		}
+	} else if c.ChatDbConfig.OtlpOnly {
+		return fmt.Errorf("chat_config.otlpOnly: otlpEndpoint must be set to send the request logs to")
+	}
+	if c.ChatDbConfig.ShareKeyEnv != "" {
+		if _, err := c.ChatDbConfig.ShareKey(); err != nil {
+			return fmt.Errorf("chat_config.shareKeyEnv: %s %w", c.ChatDbConfig.ShareKeyEnv, err)
+		}
+	}
+	if err := c.LLMConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.AuthConfig.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DecodeKey decodes a base64 AES key, checking it's 16, 24 or 32 bytes. An empty key decodes to nil
+func DecodeKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key must be 16, 24 or 32 bytes. It's %d", len(key))
+}
+
+// LoadCACert reads a PEM file of CA certificates into a pool with the system roots
+func LoadCACert(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s has no PEM encoded certificates", path)
+	}
+	return pool, nil
+}
+
+// the characters a base path can have besides letters and digits, none of which are special in a route's pattern
+const basePathChars = "/-._~"
+
+func validateBasePath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%q must start with /", path)
+	}
+	for _, r := range path {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(basePathChars, r)) {
+			return fmt.Errorf("%q can only have letters, digits and %s", path, basePathChars)
+		}
+	}
+	if strings.Contains(path, "//") {
+		return fmt.Errorf("%q must not have empty segments", path)
+	}
+	return nil
+}
+
+func validatePort(port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", port)
+	}
+	if p < 1 || p > 65535 {
+		return fmt.Errorf("%d is out of range 1-65535", p)
+	}
+	return nil
+}
+
+// applyEnv walks the struct and sets every field tagged with `env` whose
+// environment variable is set. Unset variables leave the field untouched.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(val)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(val, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("env %s: %q is not a number", name, val)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("env %s: %q is not a boolean", name, val)
+			}
+			field.SetBool(b)
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("env %s: %q is not a number", name, val)
+			}
+			field.SetFloat(f)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("env %s: unsupported slice type %s", name, field.Type())
+			}
+			parts := []string{}
+			for _, p := range strings.Split(val, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+			field.Set(reflect.ValueOf(parts))
+		default:
+			return fmt.Errorf("env %s: unsupported field type %s", name, field.Type())
+		}
+	}
+	return nil
+}
@@ -1,16 +1,27 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
 	tgConfigPath := setup(t)
 
 	cfg, err := LoadConfig(map[string]string{
-		"tgconfig":   tgConfigPath,
+		"tgconfig": tgConfigPath,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -22,14 +33,75 @@ func TestLoadConfig(t *testing.T) {
 		cfg.ChatDbConfig.LogPath != "requestLogs.jsonl" {
 		t.Fatalf("config is wrong, %v", cfg.ChatDbConfig)
 	}
+	// not in the file, defaulted
+	if cfg.ChatDbConfig.RequestLogOutput != LogOutputFile || cfg.ChatDbConfig.AppLogOutput != "" {
+		t.Fatalf("the request logs should default to their file and the service log to stderr. They're: %q, %q", cfg.ChatDbConfig.RequestLogOutput, cfg.ChatDbConfig.AppLogOutput)
+	}
+	if cfg.ChatDbConfig.TrashRetentionDays != 30 {
+		t.Fatalf("trashRetentionDays should default to 30. It's: %d", cfg.ChatDbConfig.TrashRetentionDays)
+	}
+	if cfg.ChatDbConfig.IdempotencyKeyHours != 24 {
+		t.Fatalf("idempotencyKeyHours should default to 24. It's: %d", cfg.ChatDbConfig.IdempotencyKeyHours)
+	}
+	if cfg.LLMConfig.DebugStoreLLMIO {
+		t.Fatal("LLM requests and responses shouldn't be stored by default")
+	}
+	if cfg.ChatDbConfig.ReconnectDedupSeconds != 30 {
+		t.Fatalf("reconnectDedupSeconds should default to 30. It's: %d", cfg.ChatDbConfig.ReconnectDedupSeconds)
+	}
+	if cfg.ChatDbConfig.ClockSkewSeconds != 60 {
+		t.Fatalf("clockSkewSeconds should default to 60. It's: %d", cfg.ChatDbConfig.ClockSkewSeconds)
+	}
+	if cfg.ChatDbConfig.JSONCase != JSONSnakeCase || cfg.ChatDbConfig.JSONIndent {
+		t.Fatalf("responses should default to compact snake_case. They're %q, indented %v", cfg.ChatDbConfig.JSONCase, cfg.ChatDbConfig.JSONIndent)
+	}
+	if cfg.ChatDbConfig.MaxAttachmentsPerMessage != 10 {
+		t.Fatalf("maxAttachmentsPerMessage should default to 10. It's: %d", cfg.ChatDbConfig.MaxAttachmentsPerMessage)
+	}
+	if cfg.ChatDbConfig.MaxPinsPerConversation != 10 {
+		t.Fatalf("maxPinsPerConversation should default to 10. It's: %d", cfg.ChatDbConfig.MaxPinsPerConversation)
+	}
+	if cfg.ChatDbConfig.MaxRequestBodyBytes != 10<<20 {
+		t.Fatalf("maxRequestBodyBytes should default to 10MB. It's: %d", cfg.ChatDbConfig.MaxRequestBodyBytes)
+	}
+	if cfg.ChatDbConfig.MaxLogSizeMB != 100 || cfg.ChatDbConfig.MaxLogBackups != 5 {
+		t.Fatalf("the request log should rotate at 100MB keeping 5 files. It's %dMB, %d", cfg.ChatDbConfig.MaxLogSizeMB, cfg.ChatDbConfig.MaxLogBackups)
+	}
+	if cfg.ChatDbConfig.ShutdownTimeoutSeconds != 15 {
+		t.Fatalf("shutdownTimeoutSeconds should default to 15. It's: %d", cfg.ChatDbConfig.ShutdownTimeoutSeconds)
+	}
+	if cfg.ChatDbConfig.HandlerTimeoutSeconds != 120 {
+		t.Fatalf("handlerTimeoutSeconds should default to 120. It's: %d", cfg.ChatDbConfig.HandlerTimeoutSeconds)
+	}
+	if cfg.ChatDbConfig.AsyncWorkers != 4 {
+		t.Fatalf("asyncWorkers should default to 4. It's: %d", cfg.ChatDbConfig.AsyncWorkers)
+	}
+	if w := cfg.ChatDbConfig; w.SearchTitleWeight != 3 || w.SearchTagWeight != 2 || w.SearchContentWeight != 1 {
+		t.Fatalf("search weights should default to 3, 2 and 1. They're: %v, %v, %v", w.SearchTitleWeight, w.SearchTagWeight, w.SearchContentWeight)
+	}
+	if cfg.ChatDbConfig.BusyTimeoutMillis != 5000 {
+		t.Fatalf("busyTimeoutMillis should default to 5000. It's: %d", cfg.ChatDbConfig.BusyTimeoutMillis)
+	}
+	if cfg.ChatDbConfig.AuditLogPath != "audit.jsonl" || len(cfg.ChatDbConfig.AdminRoles) != 0 {
+		t.Fatalf("the audit log should default to audit.jsonl with no admin roles. It's: %q, %v", cfg.ChatDbConfig.AuditLogPath, cfg.ChatDbConfig.AdminRoles)
+	}
+	if cfg.AuthConfig.Provider != AuthTigerGraph || cfg.AuthConfig.UserClaim != "sub" || cfg.AuthConfig.RolesClaim != "roles" {
+		t.Fatalf("auth should default to tigergraph, with the sub and roles claims. It's: %+v", cfg.AuthConfig)
+	}
+	if cfg.TgDbConfig.MaxRetries != 0 || cfg.TgDbConfig.RetryBaseMillis != 100 {
+		t.Fatalf("retries should be off with a 100ms base by default. They're: %d, %dms", cfg.TgDbConfig.MaxRetries, cfg.TgDbConfig.RetryBaseMillis)
+	}
 
-	if cfg.TgDbConfig.Hostname != "https://tg-0cdef603-3760-41c3-af6f-41e95afc40de.us-east-1.i.tgcloud.io" ||
-		cfg.TgDbConfig.GsPort != "14240" {
+	if cfg.TgDbConfig.Hostname != "http://tigergraph" ||
+		cfg.TgDbConfig.GsPort != "14240" ||
+		cfg.TgDbConfig.DefaultTimeout != 300 ||
+		cfg.TgDbConfig.DefaultMemThreshold != 5000 ||
+		cfg.TgDbConfig.DefaultThreadLimit != 8 {
 		t.Fatalf("TigerGraph config is wrong, %v", cfg.TgDbConfig)
 	}
 }
 
-func setup(t *testing.T) (string, string) {
+func setup(t *testing.T) string {
 	tmp := t.TempDir()
 
 	tgConfigPath := fmt.Sprintf("%s/%s", tmp, "server_config.json")
@@ -39,7 +111,10 @@ func setup(t *testing.T) (string, string) {
         "hostname": "http://tigergraph",
         "gsPort": "14240",
         "username": "tigergraph",
-        "password": "tigergraph"
+        "password": "tigergraph",
+        "default_timeout": 300,
+        "default_mem_threshold": 5000,
+        "default_thread_limit": 8
     },
     "chat_config": {
 	"apiPort":"8002",
@@ -55,3 +130,959 @@ func setup(t *testing.T) (string, string) {
 
 	return tgConfigPath
 }
+
+func TestLoadConfig_EnvOverrides(t *testing.T) {
+	tgConfigPath := setup(t)
+
+	// only some of the env vars are set, the rest should come from the file
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "https://tg.example.com")
+	t.Setenv("GRAPHRAG_DB_PASSWORD", "s3cret")
+	t.Setenv("GRAPHRAG_CHAT_PORT", "9000")
+	t.Setenv("GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES", "superuser, admin")
+	t.Setenv("GRAPHRAG_CHAT_TRASH_RETENTION_DAYS", "7")
+	t.Setenv("GRAPHRAG_CHAT_WRITE_RATE_PER_SEC", "0.5")
+	t.Setenv("GRAPHRAG_DB_INSECURE_SKIP_VERIFY", "true")
+	t.Setenv("GRAPHRAG_CHAT_BASE_PATH", "/chat-history/")
+	t.Setenv("GRAPHRAG_CHAT_MAX_REQUEST_BODY_BYTES", "5368709120")
+
+	cfg, err := LoadConfig(map[string]string{
+		"tgconfig": tgConfigPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.TgDbConfig.Hostname != "https://tg.example.com" ||
+		cfg.TgDbConfig.Password != "s3cret" ||
+		cfg.ChatDbConfig.Port != "9000" {
+		t.Fatalf("env overrides were not applied, %v %v", cfg.TgDbConfig, cfg.ChatDbConfig)
+	}
+	if roles := cfg.ChatDbConfig.ConversationAccessRoles; len(roles) != 2 || roles[0] != "superuser" || roles[1] != "admin" {
+		t.Fatalf("conversationAccessRoles should be [superuser admin]. It's: %v", roles)
+	}
+	if cfg.ChatDbConfig.TrashRetentionDays != 7 {
+		t.Fatalf("trashRetentionDays should be 7. It's: %d", cfg.ChatDbConfig.TrashRetentionDays)
+	}
+	if cfg.ChatDbConfig.WriteRatePerSec != 0.5 {
+		t.Fatalf("writeRatePerSec should be 0.5. It's: %v", cfg.ChatDbConfig.WriteRatePerSec)
+	}
+	if !cfg.TgDbConfig.InsecureSkipVerify {
+		t.Fatal("insecureSkipVerify should be true")
+	}
+	if cfg.ChatDbConfig.BasePath != "/chat-history" {
+		t.Fatalf("basePath should be /chat-history, without the trailing /. It's: %q", cfg.ChatDbConfig.BasePath)
+	}
+	// an int64, bigger than an int32
+	if cfg.ChatDbConfig.MaxRequestBodyBytes != 5368709120 {
+		t.Fatalf("maxRequestBodyBytes should be 5368709120. It's: %d", cfg.ChatDbConfig.MaxRequestBodyBytes)
+	}
+
+	// not set in env, should keep file values
+	if cfg.TgDbConfig.Username != "tigergraph" ||
+		cfg.TgDbConfig.GsPort != "14240" ||
+		cfg.ChatDbConfig.DbPath != "chats.db" {
+		t.Fatalf("file values should be kept when env is not set, %v %v", cfg.TgDbConfig, cfg.ChatDbConfig)
+	}
+}
+
+func TestLoadConfig_EnvOnly(t *testing.T) {
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "http://tigergraph")
+	t.Setenv("GRAPHRAG_DB_GS_PORT", "14240")
+	t.Setenv("GRAPHRAG_CHAT_PORT", "8002")
+	t.Setenv("GRAPHRAG_CHAT_DB_PATH", "env.db")
+	t.Setenv("GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES", "superuser")
+
+	cfg, err := LoadConfig(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ChatDbConfig.DbPath != "env.db" {
+		t.Fatalf("dbPath should be env.db. It's: %s", cfg.ChatDbConfig.DbPath)
+	}
+	// absent from both file and env, the ones without a default are zero values
+	if cfg.TgDbConfig.Username != "" || cfg.TgDbConfig.Password != "" || cfg.ChatDbConfig.LogPath != "requestLogs.jsonl" {
+		t.Fatalf("fields absent from both should be their defaults or zero values, %v %v", cfg.TgDbConfig, cfg.ChatDbConfig)
+	}
+}
+
+func TestLoadConfig_DevMode(t *testing.T) {
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "http://tigergraph")
+	t.Setenv("GRAPHRAG_DB_GS_PORT", "14240")
+	t.Setenv("GRAPHRAG_CHAT_PORT", "8002")
+	t.Setenv("GRAPHRAG_CHAT_DB_PATH", "env.db")
+	t.Setenv("GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES", "globaldesigner")
+	t.Setenv("GRAPHRAG_CHAT_DEV_MODE", "true")
+
+	// the dev roles default to the conversation access roles
+	cfg, err := LoadConfig(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ChatDbConfig.DevMode || !slices.Equal(cfg.ChatDbConfig.DevRoles, []string{"globaldesigner"}) {
+		t.Fatalf("dev mode should be on with the conversation access roles. It's %v, %v", cfg.ChatDbConfig.DevMode, cfg.ChatDbConfig.DevRoles)
+	}
+	t.Setenv("GRAPHRAG_CHAT_DEV_ROLES", "superuser, support")
+	if cfg, err = LoadConfig(map[string]string{}); err != nil || !slices.Equal(cfg.ChatDbConfig.DevRoles, []string{"superuser", "support"}) {
+		t.Fatalf("the dev roles should be the ones set. Got %v, %v", cfg.ChatDbConfig.DevRoles, err)
+	}
+
+	// anything that isn't production can run in dev mode
+	for _, env := range []string{"", "staging", "development"} {
+		t.Setenv(EnvironmentVar, env)
+		if _, err := LoadConfig(map[string]string{}); err != nil {
+			t.Fatalf("dev mode should be allowed in %q. Got: %v", env, err)
+		}
+	}
+	for _, env := range []string{"production", "Prod", " PRODUCTION "} {
+		t.Setenv(EnvironmentVar, env)
+		if _, err := LoadConfig(map[string]string{}); err == nil || !strings.Contains(err.Error(), "chat_config.devMode") {
+			t.Fatalf("dev mode should be refused in %q. Got: %v", env, err)
+		}
+	}
+	// production without dev mode is fine
+	t.Setenv("GRAPHRAG_CHAT_DEV_MODE", "false")
+	if _, err := LoadConfig(map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig_EphemeralMode(t *testing.T) {
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "http://tigergraph")
+	t.Setenv("GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES", "globaldesigner")
+	t.Setenv("GRAPHRAG_CHAT_EPHEMERAL_MODE", "true")
+
+	// dbPath can be left out or be :memory:
+	for _, path := range []string{"", MemoryDbPath} {
+		t.Setenv("GRAPHRAG_CHAT_DB_PATH", path)
+		cfg, err := LoadConfig(map[string]string{})
+		if err != nil {
+			t.Fatalf("dbPath %q: %v", path, err)
+		}
+		if !cfg.ChatDbConfig.EphemeralMode {
+			t.Fatal("ephemeral mode should be on")
+		}
+	}
+	failures := map[string]func(t *testing.T){
+		"dbPath must be empty":             func(t *testing.T) { t.Setenv("GRAPHRAG_CHAT_DB_PATH", "chats.db") },
+		"can't be enabled":                 func(t *testing.T) { t.Setenv(EnvironmentVar, "production") },
+		"\":memory:\" needs ephemeralMode": func(t *testing.T) { t.Setenv("GRAPHRAG_CHAT_EPHEMERAL_MODE", "false") },
+	}
+	for want, set := range failures {
+		t.Run(want, func(t *testing.T) {
+			t.Setenv("GRAPHRAG_CHAT_DB_PATH", MemoryDbPath)
+			set(t)
+			if _, err := LoadConfig(map[string]string{}); err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("should fail with %q. Got: %v", want, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("TEST_SHARE_KEY_SHORT", base64.StdEncoding.EncodeToString(make([]byte, 8)))
+	t.Setenv("TEST_AUTH_SECRET", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	valid := func() Config {
+		return Config{
+			TgDbConfig: TgDbConfig{
+				Hostname: "http://tigergraph",
+				GsPort:   "14240",
+			},
+			ChatDbConfig: ChatDbConfig{
+				Port:                    "8002",
+				DbPath:                  "chats.db",
+				ConversationAccessRoles: []string{"superuser"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		field  string // empty if the config should be valid
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"empty hostname", func(c *Config) { c.TgDbConfig.Hostname = "" }, "db_config.hostname"},
+		{"hostname without scheme", func(c *Config) { c.TgDbConfig.Hostname = "tigergraph" }, "db_config.hostname"},
+		{"unparseable hostname", func(c *Config) { c.TgDbConfig.Hostname = "http://tiger graph:%" }, "db_config.hostname"},
+		{"replica without scheme", func(c *Config) { c.TgDbConfig.Replicas = []string{"http://standby", "standby"} }, "db_config.replicas"},
+		{"negative request timeout", func(c *Config) { c.TgDbConfig.RequestTimeoutSeconds = -1 }, "db_config.requestTimeoutSeconds"},
+		{"negative max idle conns", func(c *Config) { c.TgDbConfig.MaxIdleConns = -1 }, "db_config.maxIdleConns"},
+		{"negative max retries", func(c *Config) { c.TgDbConfig.MaxRetries = -1 }, "db_config.maxRetries"},
+		{"negative retry base", func(c *Config) { c.TgDbConfig.RetryBaseMillis = -1 }, "db_config.retryBaseMillis"},
+		{"negative default timeout", func(c *Config) { c.TgDbConfig.DefaultTimeout = -1 }, "db_config.default_timeout"},
+		{"non-numeric gsPort", func(c *Config) { c.TgDbConfig.GsPort = "abc" }, "db_config.gsPort"},
+		{"gsPort too large", func(c *Config) { c.TgDbConfig.GsPort = "65536" }, "db_config.gsPort"},
+		{"apiPort zero", func(c *Config) { c.ChatDbConfig.Port = "0" }, "chat_config.apiPort"},
+		{"apiPort empty", func(c *Config) { c.ChatDbConfig.Port = "" }, "chat_config.apiPort"},
+		{"relative basePath", func(c *Config) { c.ChatDbConfig.BasePath = "chat-history" }, "chat_config.basePath"},
+		{"basePath with a pattern", func(c *Config) { c.ChatDbConfig.BasePath = "/{tenant}" }, "chat_config.basePath"},
+		{"basePath with an empty segment", func(c *Config) { c.ChatDbConfig.BasePath = "/api//chat" }, "chat_config.basePath"},
+		{"empty dbPath", func(c *Config) { c.ChatDbConfig.DbPath = "" }, "chat_config.dbPath"},
+		{"no access roles", func(c *Config) { c.ChatDbConfig.ConversationAccessRoles = nil }, "chat_config.conversationAccessRoles"},
+		{"unknown log level", func(c *Config) { c.ChatDbConfig.LogLevel = "verbose" }, "chat_config.logLevel"},
+		{"negative trash retention", func(c *Config) { c.ChatDbConfig.TrashRetentionDays = -1 }, "chat_config.trashRetentionDays"},
+		{"negative idempotency window", func(c *Config) { c.ChatDbConfig.IdempotencyKeyHours = -1 }, "chat_config.idempotencyKeyHours"},
+		{"negative reconnect dedup window", func(c *Config) { c.ChatDbConfig.ReconnectDedupSeconds = -1 }, "chat_config.reconnectDedupSeconds"},
+		{"negative shutdown timeout", func(c *Config) { c.ChatDbConfig.ShutdownTimeoutSeconds = -1 }, "chat_config.shutdownTimeoutSeconds"},
+		{"negative clock skew", func(c *Config) { c.ChatDbConfig.ClockSkewSeconds = -1 }, "chat_config.clockSkewSeconds"},
+		{"unknown json case", func(c *Config) { c.ChatDbConfig.JSONCase = "kebab-case" }, "chat_config.jsonCase"},
+		{"negative handler timeout", func(c *Config) { c.ChatDbConfig.HandlerTimeoutSeconds = -1 }, "chat_config.handlerTimeoutSeconds"},
+		{"negative async workers", func(c *Config) { c.ChatDbConfig.AsyncWorkers = -1 }, "chat_config.asyncWorkers"},
+		{"negative write rate", func(c *Config) { c.ChatDbConfig.WriteRatePerSec = -1 }, "chat_config.writeRatePerSec"},
+		{"negative search weight", func(c *Config) { c.ChatDbConfig.SearchTagWeight = -1 }, "chat_config.searchTagWeight"},
+		{"negative write burst", func(c *Config) { c.ChatDbConfig.WriteBurst = -1 }, "chat_config.writeBurst"},
+		{"archive is the primary db", func(c *Config) { c.ChatDbConfig.ArchiveDbPath = c.ChatDbConfig.DbPath }, "chat_config.archiveDbPath"},
+		{"negative auto archive", func(c *Config) { c.ChatDbConfig.AutoArchiveAfterDays = -1 }, "chat_config.autoArchiveAfterDays"},
+		{"auto archive without an archive", func(c *Config) { c.ChatDbConfig.AutoArchiveAfterDays = 90 }, "chat_config.autoArchiveAfterDays"},
+		{"negative empty conversation ttl", func(c *Config) { c.ChatDbConfig.EmptyConversationTTLHours = -1 }, "chat_config.emptyConversationTTLHours"},
+		{"backups without dir", func(c *Config) { c.ChatDbConfig.BackupIntervalHours = 24 }, "chat_config.backupDir"},
+		{"negative max request body", func(c *Config) { c.ChatDbConfig.MaxRequestBodyBytes = -1 }, "chat_config.maxRequestBodyBytes"},
+		{"negative max attachments", func(c *Config) { c.ChatDbConfig.MaxAttachmentsPerMessage = -1 }, "chat_config.maxAttachmentsPerMessage"},
+		{"negative max pins", func(c *Config) { c.ChatDbConfig.MaxPinsPerConversation = -1 }, "chat_config.maxPinsPerConversation"},
+		{"negative max pinned conversations", func(c *Config) { c.ChatDbConfig.MaxPinnedConversations = -1 }, "chat_config.maxPinnedConversations"},
+		{"negative max conversations", func(c *Config) { c.ChatDbConfig.MaxConversationsPerUser = -1 }, "chat_config.maxConversationsPerUser"},
+		{"message roles", func(c *Config) { c.ChatDbConfig.MessageRoles = []string{"tool", "function", "tool_result"} }, ""},
+		{"uppercase message role", func(c *Config) { c.ChatDbConfig.MessageRoles = []string{"Tool"} }, "chat_config.messageRoles"},
+		{"empty message role", func(c *Config) { c.ChatDbConfig.MessageRoles = []string{""} }, "chat_config.messageRoles"},
+		{"invalid trusted proxy", func(c *Config) { c.ChatDbConfig.TrustedProxies = []string{"10.0.0.0/8", "lb.internal"} }, "chat_config.trustedProxies"},
+		{"invalid trusted proxy prefix", func(c *Config) { c.ChatDbConfig.TrustedProxies = []string{"10.0.0.0/33"} }, "chat_config.trustedProxies"},
+		{"negative max message chars", func(c *Config) { c.ChatDbConfig.MaxMessageChars = -1 }, "chat_config.maxMessageChars"},
+		{"truncate long messages", func(c *Config) { c.ChatDbConfig.MaxMessageChars, c.ChatDbConfig.MessageLengthPolicy = 4000, "truncate" }, ""},
+		{"unknown message length policy", func(c *Config) { c.ChatDbConfig.MessageLengthPolicy = "split" }, "chat_config.messageLengthPolicy"},
+		{"moderation blocklist", func(c *Config) { c.ChatDbConfig.ModerationBlocklist = []string{"(?i)\\bpassword\\b"} }, ""},
+		{"invalid moderation pattern", func(c *Config) { c.ChatDbConfig.ModerationBlocklist = []string{"ok", "(unclosed"} }, "chat_config.moderationBlocklist"},
+		{"invalid moderation url", func(c *Config) { c.ChatDbConfig.ModerationURL = "moderation.internal" }, "chat_config.moderationURL"},
+		{"unknown disabled feature", func(c *Config) { c.ChatDbConfig.DisabledFeatures = []string{"summary", "chat"} }, "chat_config.disabledFeatures"},
+		{"negative backup retention", func(c *Config) { c.ChatDbConfig.BackupRetention = -1 }, "chat_config.backupRetention"},
+		{"request logs to stdout", func(c *Config) { c.ChatDbConfig.RequestLogOutput = LogOutputStdout }, ""},
+		{"request logs to both", func(c *Config) {
+			c.ChatDbConfig.RequestLogOutput, c.ChatDbConfig.LogPath = LogOutputBoth, "requests.jsonl"
+		}, ""},
+		{"request logs to file without logPath", func(c *Config) { c.ChatDbConfig.RequestLogOutput = LogOutputFile }, "chat_config.logPath"},
+		{"unknown request log output", func(c *Config) { c.ChatDbConfig.RequestLogOutput = "syslog" }, "chat_config.requestLogOutput"},
+		{"app log to file", func(c *Config) { c.ChatDbConfig.AppLogOutput, c.ChatDbConfig.AppLogPath = LogOutputFile, "app.jsonl" }, ""},
+		{"app log to both without appLogPath", func(c *Config) { c.ChatDbConfig.AppLogOutput = LogOutputBoth }, "chat_config.appLogPath"},
+		{"unknown app log output", func(c *Config) { c.ChatDbConfig.AppLogOutput = "stderr" }, "chat_config.appLogOutput"},
+		{"negative max log size", func(c *Config) { c.ChatDbConfig.MaxLogSizeMB = -1 }, "chat_config.maxLogSizeMB"},
+		{"negative max log backups", func(c *Config) { c.ChatDbConfig.MaxLogBackups = -1 }, "chat_config.maxLogBackups"},
+		{"negative max log age", func(c *Config) { c.ChatDbConfig.MaxLogAgeDays = -1 }, "chat_config.maxLogAgeDays"},
+		{"negative busy timeout", func(c *Config) { c.ChatDbConfig.BusyTimeoutMillis = -1 }, "chat_config.busyTimeoutMillis"},
+		{"negative max open conns", func(c *Config) { c.ChatDbConfig.MaxOpenConns = -1 }, "chat_config.maxOpenConns"},
+		{"negative max idle conns", func(c *Config) { c.ChatDbConfig.MaxIdleConns = -1 }, "chat_config.maxIdleConns"},
+		{"negative conn lifetime", func(c *Config) { c.ChatDbConfig.ConnMaxLifetimeSeconds = -1 }, "chat_config.connMaxLifetimeSeconds"},
+		{"short share key", func(c *Config) { c.ChatDbConfig.ShareKeyEnv = "TEST_SHARE_KEY_SHORT" }, "chat_config.shareKeyEnv"},
+		{"allowed origins", func(c *Config) {
+			c.ChatDbConfig.AllowedOrigins = []string{"https://ui.example.com", "http://localhost:3000"}
+			c.ChatDbConfig.AllowCredentials = true
+		}, ""},
+		{"any origin", func(c *Config) { c.ChatDbConfig.AllowedOrigins = []string{"*"} }, ""},
+		{"any origin with credentials", func(c *Config) {
+			c.ChatDbConfig.AllowedOrigins = []string{"*"}
+			c.ChatDbConfig.AllowCredentials = true
+		}, "chat_config.allowedOrigins"},
+		{"origin with path", func(c *Config) { c.ChatDbConfig.AllowedOrigins = []string{"https://ui.example.com/app"} }, "chat_config.allowedOrigins"},
+		{"origin without scheme", func(c *Config) { c.ChatDbConfig.AllowedOrigins = []string{"ui.example.com"} }, "chat_config.allowedOrigins"},
+		{"webhook URL", func(c *Config) { c.ChatDbConfig.WebhookURL = "https://hooks.example.com/chat" }, ""},
+		{"webhook URL without scheme", func(c *Config) { c.ChatDbConfig.WebhookURL = "hooks.example.com/chat" }, "chat_config.webhookURL"},
+		{"webhook URL not http", func(c *Config) { c.ChatDbConfig.WebhookURL = "ftp://hooks.example.com" }, "chat_config.webhookURL"},
+		{"OTLP endpoint", func(c *Config) { c.ChatDbConfig.OtlpEndpoint = "http://collector:4318" }, ""},
+		{"OTLP endpoint not http", func(c *Config) { c.ChatDbConfig.OtlpEndpoint = "collector:4317" }, "chat_config.otlpEndpoint"},
+		{"OTLP only", func(c *Config) { c.ChatDbConfig.OtlpEndpoint, c.ChatDbConfig.OtlpOnly = "http://collector:4318", true }, ""},
+		{"OTLP only without endpoint", func(c *Config) { c.ChatDbConfig.OtlpOnly = true }, "chat_config.otlpOnly"},
+		{"db file mode", func(c *Config) { c.ChatDbConfig.DbFileMode = "0640" }, ""},
+		{"db file mode not octal", func(c *Config) { c.ChatDbConfig.DbFileMode = "rw-------" }, "chat_config.dbFileMode"},
+		{"db file mode too big", func(c *Config) { c.ChatDbConfig.DbFileMode = "1777" }, "chat_config.dbFileMode"},
+		{"db file mode not writable", func(c *Config) { c.ChatDbConfig.DbFileMode = "0400" }, "chat_config.dbFileMode"},
+		{"role cache off", func(c *Config) { c.AuthConfig.RoleCacheTTLSeconds = 0 }, ""},
+		{"negative role cache ttl", func(c *Config) { c.AuthConfig.RoleCacheTTLSeconds = -1 }, "auth_config.role_cache_ttl_seconds"},
+		{"unknown auth provider", func(c *Config) { c.AuthConfig.Provider = "ldap" }, "auth_config.provider"},
+		{"oidc with a secret", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Issuer: "https://login.example.com", Audience: "chat", SecretEnv: "TEST_AUTH_SECRET"}
+		}, ""},
+		{"oidc without an issuer", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Audience: "chat", SecretEnv: "TEST_AUTH_SECRET"}
+		}, "auth_config.issuer"},
+		{"oidc without an audience", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Issuer: "https://login.example.com", SecretEnv: "TEST_AUTH_SECRET"}
+		}, "auth_config.audience"},
+		{"oidc without keys", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Issuer: "https://login.example.com", Audience: "chat"}
+		}, "auth_config.public_key_paths"},
+		{"oidc with a missing key file", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Issuer: "https://login.example.com", Audience: "chat", PublicKeyPaths: []string{"missing.pem"}}
+		}, "auth_config.public_key_paths"},
+		{"oidc with a short secret", func(c *Config) {
+			c.AuthConfig = AuthConfig{Provider: AuthOIDC, Issuer: "https://login.example.com", Audience: "chat", SecretEnv: "TEST_SHARE_KEY_SHORT"}
+		}, "auth_config.secret_env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("expected config to be valid, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error for %s", tt.field)
+			}
+			if !strings.Contains(err.Error(), tt.field) {
+				t.Fatalf("error should name %s. It's: %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestValidate_CACertPath(t *testing.T) {
+	tmp := t.TempDir()
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	caPath := fmt.Sprintf("%s/%s", tmp, "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := fmt.Sprintf("%s/%s", tmp, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"valid CA", caPath, false},
+		{"missing file", fmt.Sprintf("%s/%s", tmp, "missing.pem"), true},
+		{"not PEM", notPEM, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				TgDbConfig: TgDbConfig{Hostname: "https://tigergraph", GsPort: "14240", CACertPath: tt.path},
+				ChatDbConfig: ChatDbConfig{
+					Port:                    "8002",
+					DbPath:                  "chats.db",
+					ConversationAccessRoles: []string{"superuser"},
+				},
+			}
+			err := cfg.Validate()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("expected config to be valid, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "db_config.caCertPath") {
+				t.Fatalf("error should name db_config.caCertPath. It's: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate_EncryptionKey(t *testing.T) {
+	t.Setenv("TEST_KEY_VALID", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	t.Setenv("TEST_KEY_SHORT", base64.StdEncoding.EncodeToString(make([]byte, 10)))
+	t.Setenv("TEST_KEY_NOT_BASE64", "not base64!")
+
+	tests := []struct {
+		env     string
+		wantKey bool
+		wantErr bool
+	}{
+		{"TEST_KEY_VALID", true, false},
+		// an empty key means plaintext
+		{"TEST_KEY_UNSET", false, false},
+		{"TEST_KEY_SHORT", false, true},
+		{"TEST_KEY_NOT_BASE64", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			cfg := Config{
+				TgDbConfig: TgDbConfig{Hostname: "http://tigergraph", GsPort: "14240"},
+				ChatDbConfig: ChatDbConfig{
+					Port:                    "8002",
+					DbPath:                  "chats.db",
+					ConversationAccessRoles: []string{"superuser"},
+					EncryptionKeyEnv:        tt.env,
+				},
+			}
+			err := cfg.Validate()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "chat_config.encryptionKeyEnv") {
+					t.Fatalf("error should name chat_config.encryptionKeyEnv. It's: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected config to be valid, got: %v", err)
+			}
+			if key, _ := cfg.ChatDbConfig.EncryptionKey(); (key != nil) != tt.wantKey {
+				t.Fatalf("key should be set: %v. It's: %v", tt.wantKey, key)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	// only what has no default
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "server_config.json")
+	minimal := `{
+	"db_config": {"hostname": "http://tigergraph"},
+	"chat_config": {"dbPath": "chats.db", "conversationAccessRoles": ["superuser"]}
+}`
+	if err := os.WriteFile(pth, []byte(minimal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(map[string]string{"tgconfig": pth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.SchemaVersion = CurrentSchemaVersion
+	want.TgDbConfig.Hostname = "http://tigergraph"
+	want.ChatDbConfig.DbPath = "chats.db"
+	want.ChatDbConfig.ConversationAccessRoles = []string{"superuser"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("the defaults should fill in the rest.\nGot:  %+v\nWant: %+v", cfg, want)
+	}
+
+	// zeros that are set replace the defaults, empty strings that can't be empty don't
+	zeros := `{
+	"db_config": {"hostname": "http://tigergraph", "requestTimeoutSeconds": 0},
+	"chat_config": {"dbPath": "chats.db", "conversationAccessRoles": ["superuser"], "trashRetentionDays": 0,
+		"handlerTimeoutSeconds": 0, "searchTagWeight": 0, "maxRequestBodyBytes": 0, "logLevel": ""}
+}`
+	if err := os.WriteFile(pth, []byte(zeros), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = LoadConfig(map[string]string{"tgconfig": pth}); err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.ChatDbConfig; c.TrashRetentionDays != 0 || c.HandlerTimeoutSeconds != 0 || c.SearchTagWeight != 0 || c.MaxRequestBodyBytes != 0 ||
+		cfg.TgDbConfig.RequestTimeoutSeconds != 0 {
+		t.Fatalf("the zeros in the file should be kept, %+v %+v", cfg.TgDbConfig, c)
+	}
+	if cfg.ChatDbConfig.LogLevel != "debug" || cfg.ChatDbConfig.IdempotencyKeyHours != 24 {
+		t.Fatalf("the rest should still be defaulted, %+v", cfg.ChatDbConfig)
+	}
+
+	// and so do zeros from env
+	t.Setenv("GRAPHRAG_CHAT_WRITE_RATE_PER_SEC", "0")
+	if cfg, err = LoadConfig(map[string]string{"tgconfig": pth}); err != nil || cfg.ChatDbConfig.WriteRatePerSec != 0 {
+		t.Fatalf("writeRatePerSec should be 0 from env. Got %v, %v", cfg.ChatDbConfig.WriteRatePerSec, err)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tgConfigPath := setup(t)
+	t.Setenv("GRAPHRAG_CHAT_PORT", "not-a-port")
+
+	_, err := LoadConfig(map[string]string{
+		"tgconfig": tgConfigPath,
+	})
+	if err == nil || !strings.Contains(err.Error(), "chat_config.apiPort") {
+		t.Fatalf("LoadConfig should fail validation on chat_config.apiPort. It returned: %v", err)
+	}
+}
+
+func setupChatConfig(t *testing.T, data string) string {
+	chatConfigPath := fmt.Sprintf("%s/%s", t.TempDir(), "chat_config.json")
+	if err := os.WriteFile(chatConfigPath, []byte(data), 0644); err != nil {
+		t.Fatal("error setting up chat_config.json")
+	}
+	return chatConfigPath
+}
+
+func TestLoadConfig_ChatConfigPrecedence(t *testing.T) {
+	tgConfigPath := setup(t)
+	chatConfigPath := setupChatConfig(t, `
+{
+	"apiPort": "9000",
+	"dbPath": "/data/chats.db"
+}`)
+
+	cfg, err := LoadConfig(map[string]string{
+		"tgconfig":   tgConfigPath,
+		"chatconfig": chatConfigPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// defined in both, chatconfig wins
+	if cfg.ChatDbConfig.Port != "9000" || cfg.ChatDbConfig.DbPath != "/data/chats.db" {
+		t.Fatalf("chatconfig values should take precedence, %v", cfg.ChatDbConfig)
+	}
+	// only in tgconfig, kept
+	if cfg.ChatDbConfig.DbLogPath != "db.log" ||
+		cfg.ChatDbConfig.LogPath != "requestLogs.jsonl" ||
+		len(cfg.ChatDbConfig.ConversationAccessRoles) != 2 {
+		t.Fatalf("tgconfig values missing from chatconfig should be kept, %v", cfg.ChatDbConfig)
+	}
+	if cfg.TgDbConfig.Hostname != "http://tigergraph" {
+		t.Fatalf("TigerGraph config is wrong, %v", cfg.TgDbConfig)
+	}
+}
+
+func TestLoadConfig_ChatConfigOnly(t *testing.T) {
+	chatConfigPath := setupChatConfig(t, `
+{
+	"apiPort": "8002",
+	"dbPath": "chats.db",
+	"conversationAccessRoles": ["superuser"]
+}`)
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "http://tigergraph")
+	t.Setenv("GRAPHRAG_DB_GS_PORT", "14240")
+
+	cfg, err := LoadConfig(map[string]string{
+		"chatconfig": chatConfigPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ChatDbConfig.Port != "8002" || cfg.ChatDbConfig.DbPath != "chats.db" {
+		t.Fatalf("config is wrong, %v", cfg.ChatDbConfig)
+	}
+}
+
+func TestLoadConfig_TgConfigOnly(t *testing.T) {
+	tgConfigPath := setup(t)
+
+	cfg, err := LoadConfig(map[string]string{
+		"tgconfig": tgConfigPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ChatDbConfig.Port != "8002" || cfg.ChatDbConfig.DbPath != "chats.db" {
+		t.Fatalf("config is wrong, %v", cfg.ChatDbConfig)
+	}
+}
+
+func TestRedactPassword(t *testing.T) {
+	tgConfigPath := setup(t)
+	t.Setenv("GRAPHRAG_DB_PASSWORD", "sup3r-s3cret")
+
+	cfg, err := LoadConfig(map[string]string{
+		"tgconfig": tgConfigPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, out := range []string{
+		fmt.Sprintf("%v", cfg),
+		fmt.Sprintf("%+v", cfg),
+		fmt.Sprintf("%#v", cfg),
+		fmt.Sprint(cfg.TgDbConfig),
+	} {
+		if strings.Contains(out, "sup3r-s3cret") {
+			t.Fatalf("password leaked: %s", out)
+		}
+		if !strings.Contains(out, "***") {
+			t.Fatalf("password should be replaced with ***: %s", out)
+		}
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "sup3r-s3cret") {
+		t.Fatalf("password leaked: %s", b)
+	}
+	if !strings.Contains(string(b), `"password":"***"`) {
+		t.Fatalf("password should be replaced with ***: %s", b)
+	}
+
+	// the real value is still available for connecting
+	if cfg.TgDbConfig.Password != "sup3r-s3cret" {
+		t.Fatalf("password field should not be modified. It's: %s", cfg.TgDbConfig.Password)
+	}
+}
+
+func TestRedactEmptyPassword(t *testing.T) {
+	cfg := TgDbConfig{Hostname: "http://tigergraph"}
+	if strings.Contains(cfg.String(), "***") {
+		t.Fatalf("empty password should not be redacted: %s", cfg.String())
+	}
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	tmp := t.TempDir()
+	yamlData := `
+db_config:
+  hostname: http://tigergraph
+  gsPort: "14240"
+  username: tigergraph
+  password: tigergraph
+  default_timeout: 300
+  default_mem_threshold: 5000
+  default_thread_limit: 8
+chat_config:
+  apiPort: "8002"
+  dbPath: chats.db
+  dbLogPath: db.log
+  logPath: requestLogs.jsonl
+  conversationAccessRoles:
+    - superuser
+    - globaldesigner
+`
+	jsonCfg, err := LoadConfig(map[string]string{"tgconfig": setup(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"server_config.yaml", "server_config.yml", "server_config.conf"} {
+		t.Run(name, func(t *testing.T) {
+			pth := fmt.Sprintf("%s/%s", tmp, name)
+			if err := os.WriteFile(pth, []byte(yamlData), 0644); err != nil {
+				t.Fatal(err)
+			}
+			yamlCfg, err := LoadConfig(map[string]string{"tgconfig": pth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(jsonCfg, yamlCfg) {
+				t.Fatalf("YAML config should match JSON config.\nJSON: %+v\nYAML: %+v", jsonCfg, yamlCfg)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_UnknownFormat(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "server_config.conf")
+	if err := os.WriteFile(pth, []byte("{not: [valid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(map[string]string{"tgconfig": pth})
+	if err == nil || !strings.Contains(err.Error(), "neither valid JSON") {
+		t.Fatalf("expected an error naming both formats. Got: %v", err)
+	}
+	if !errors.Is(err, ErrConfigParse) {
+		t.Fatalf("the error should wrap ErrConfigParse. Got: %v", err)
+	}
+}
+
+func TestLoadConfig_NotFound(t *testing.T) {
+	for _, key := range []string{"tgconfig", "chatconfig"} {
+		_, err := LoadConfig(map[string]string{key: fmt.Sprintf("%s/%s", t.TempDir(), "missing.json")})
+		if !errors.Is(err, ErrConfigNotFound) {
+			t.Fatalf("a missing %s should wrap ErrConfigNotFound. Got: %v", key, err)
+		}
+		// the os error is still there for callers that checked it
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("a missing %s should still wrap fs.ErrNotExist. Got: %v", key, err)
+		}
+		if errors.Is(err, ErrConfigParse) {
+			t.Fatalf("a missing %s isn't a parse error. Got: %v", key, err)
+		}
+	}
+}
+
+func TestLoadConfig_ParseError(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		// in the message, or empty when there's no offset
+		offset string
+	}{
+		{"JSON syntax", "server_config.json", `{"chat_config": {"apiPort": }}`, "at byte 29"},
+		{"JSON type", "server_config.json", `{"chat_config": {"apiPort": 8000}}`, "at byte 32"},
+		{"YAML", "server_config.yaml", "chat_config: [not, a, map", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := fmt.Sprintf("%s/%s", t.TempDir(), tt.file)
+			if err := os.WriteFile(pth, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(map[string]string{"tgconfig": pth})
+			if !errors.Is(err, ErrConfigParse) || errors.Is(err, ErrConfigNotFound) {
+				t.Fatalf("the error should wrap ErrConfigParse. Got: %v", err)
+			}
+			if !strings.Contains(err.Error(), pth) || !strings.Contains(err.Error(), tt.offset) {
+				t.Fatalf("the error should name the file and the offset %q. Got: %v", tt.offset, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_SchemaVersion(t *testing.T) {
+	// setup's file has no schema_version, it's a version 1 file
+	cfg, err := LoadConfig(map[string]string{"tgconfig": setup(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SchemaVersion != CurrentSchemaVersion {
+		t.Fatalf("the config should be upgraded to version %d. It's: %d", CurrentSchemaVersion, cfg.SchemaVersion)
+	}
+
+	// the upgrade keeps the values, zeros included, the defaults of what version 1 didn't have come from DefaultConfig
+	v1 := Config{ChatDbConfig: ChatDbConfig{Port: "8002", HealthCheckTimeoutSeconds: 2}}
+	if err := migrateConfig(&v1); err != nil {
+		t.Fatal(err)
+	}
+	if v1.SchemaVersion != CurrentSchemaVersion || v1.ChatDbConfig.ShutdownTimeoutSeconds != 0 ||
+		v1.ChatDbConfig.HealthCheckTimeoutSeconds != 2 || v1.ChatDbConfig.Port != "8002" {
+		t.Fatalf("the version 1 config should keep its own values. It's: %+v", v1)
+	}
+
+	// a version 1 file that turns the shutdown timeout off keeps it off
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "server_config.json")
+	data := `{"db_config": {"hostname": "http://tigergraph", "gsPort": "14240"},
+		"chat_config": {"apiPort": "8002", "dbPath": "chats.db", "conversationAccessRoles": ["superuser"], "shutdownTimeoutSeconds": 0}}`
+	if err := os.WriteFile(pth, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig(map[string]string{"tgconfig": pth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ChatDbConfig.ShutdownTimeoutSeconds != 0 || cfg.ChatDbConfig.HealthCheckTimeoutSeconds != 5 {
+		t.Fatalf("the version 1 file's zero should override the default, and what it leaves out keep it. It's: %+v", cfg.ChatDbConfig)
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		// whether LoadConfig should fail with ErrConfigVersion
+		fails bool
+	}{
+		{"v1", 1, false},
+		{"current", CurrentSchemaVersion, false},
+		{"future", CurrentSchemaVersion + 1, true},
+		{"negative", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := fmt.Sprintf("%s/%s", t.TempDir(), "server_config.json")
+			data := fmt.Sprintf(`{"schema_version": %d, "db_config": {"hostname": "http://tigergraph", "gsPort": "14240"},
+				"chat_config": {"apiPort": "8002", "dbPath": "chats.db", "conversationAccessRoles": ["superuser"]}}`, tt.version)
+			if err := os.WriteFile(pth, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(map[string]string{"tgconfig": pth})
+			if tt.fails {
+				if !errors.Is(err, ErrConfigVersion) || !strings.Contains(err.Error(), fmt.Sprint(tt.version)) {
+					t.Fatalf("the error should wrap ErrConfigVersion and name the version. Got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SchemaVersion != CurrentSchemaVersion || cfg.ChatDbConfig.ShutdownTimeoutSeconds != 15 {
+				t.Fatalf("the config should be upgraded with defaults. It's version %d: %+v", cfg.SchemaVersion, cfg.ChatDbConfig)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_UnknownFields(t *testing.T) {
+	// what the files below leave out, so they only fail for their keys
+	t.Setenv("GRAPHRAG_DB_HOSTNAME", "http://tigergraph")
+	t.Setenv("GRAPHRAG_DB_GS_PORT", "14240")
+	t.Setenv("GRAPHRAG_CHAT_PORT", "8002")
+	t.Setenv("GRAPHRAG_CHAT_DB_PATH", "chats.db")
+	t.Setenv("GRAPHRAG_CHAT_CONVERSATION_ACCESS_ROLES", "superuser")
+	tests := []struct {
+		name, file, data string
+		// the key the error should name, or empty when the file should load
+		field string
+	}{
+		{"misspelled", "server_config.json", `{"chat_config": {"apPort": "8002"}}`, "apPort"},
+		{"misspelled auth", "server_config.json", `{"auth_config": {"trustedProxy": true}}`, "trustedProxy"},
+		{"unknown section", "server_config.json", `{"chat_confg": {}}`, "chat_confg"},
+		{"duplicate", "server_config.json", `{"chat_config": {"apiPort": "8002", "apiPort": "8003"}}`, "apiPort"},
+		{"YAML", "server_config.yaml", "chat_config:\n  apPort: \"8002\"\n", "apPort"},
+		{"YAML duplicate", "server_config.yaml", "chat_config:\n  apiPort: \"8002\"\n  apiPort: \"8003\"\n", "apiPort"},
+		// the sections the GraphRAG service shares have keys chat-history doesn't read
+		{"shared sections", "server_config.json", `{"db_config": {"restppPort": "9000", "getToken": false}, "graphrag_config": {"reuse_embedding": true},
+			"llm_config": {"embedding_service": {}, "completion_service": {}}}`, ""},
+		{"opted out", "server_config.json", `{"chat_config": {"apPort": "8002", "allowUnknownFields": true}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := fmt.Sprintf("%s/%s", t.TempDir(), tt.file)
+			if err := os.WriteFile(pth, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(map[string]string{"tgconfig": pth})
+			if tt.field == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), tt.field) || !strings.Contains(err.Error(), pth) {
+				t.Fatalf("the error should wrap ErrUnknownField and name the file and %s. Got: %v", tt.field, err)
+			}
+		})
+	}
+
+	// the chatconfig file is checked too, and the check can be turned off from env
+	pth := setupChatConfig(t, `{"apiPort": "8002", "dbPth": "typo.db"}`)
+	if _, err := LoadConfig(map[string]string{"chatconfig": pth}); !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), "dbPth") {
+		t.Fatalf("the chatconfig file's unknown field should be named. Got: %v", err)
+	}
+	t.Setenv("GRAPHRAG_CHAT_ALLOW_UNKNOWN_FIELDS", "true")
+	if _, err := LoadConfig(map[string]string{"chatconfig": pth}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLLMConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   LLMConfig
+		field string // empty if the config should be valid
+	}{
+		{"unset", LLMConfig{}, ""},
+		{"unknown provider", LLMConfig{Provider: "skynet", ModelName: "t-800"}, "llm_config.provider"},
+
+		{"openai", LLMConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKeyEnv: "OPENAI_API_KEY"}, ""},
+		{"openai missing model", LLMConfig{Provider: ProviderOpenAI, APIKeyEnv: "OPENAI_API_KEY"}, "llm_config.model_name"},
+		{"openai missing key", LLMConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o"}, "llm_config.api_key_env"},
+		{"openai bad base url", LLMConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKeyEnv: "OPENAI_API_KEY", BaseURL: "api.openai.com"}, "llm_config.base_url"},
+
+		{"azure", LLMConfig{Provider: ProviderAzure, ModelName: "gpt-4o", BaseURL: "https://x.openai.azure.com", APIKeyEnv: "AZURE_OPENAI_API_KEY"}, ""},
+		{"azure missing base url", LLMConfig{Provider: ProviderAzure, ModelName: "gpt-4o", APIKeyEnv: "AZURE_OPENAI_API_KEY"}, "llm_config.base_url"},
+		{"azure missing key", LLMConfig{Provider: ProviderAzure, ModelName: "gpt-4o", BaseURL: "https://x.openai.azure.com"}, "llm_config.api_key_env"},
+
+		{"bedrock", LLMConfig{Provider: ProviderBedrock, ModelName: "anthropic.claude-3-haiku-20240307-v1:0", BaseURL: "https://bedrock-runtime.us-east-1.amazonaws.com", APIKeyEnv: "AWS_BEARER_TOKEN_BEDROCK"}, ""},
+		{"bedrock missing model", LLMConfig{Provider: ProviderBedrock, BaseURL: "https://bedrock-runtime.us-east-1.amazonaws.com", APIKeyEnv: "AWS_BEARER_TOKEN_BEDROCK"}, "llm_config.model_name"},
+		{"bedrock missing base url", LLMConfig{Provider: ProviderBedrock, ModelName: "anthropic.claude-3-haiku-20240307-v1:0", APIKeyEnv: "AWS_BEARER_TOKEN_BEDROCK"}, "llm_config.base_url"},
+
+		{"ollama", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434"}, ""},
+		{"ollama missing base url", LLMConfig{Provider: ProviderOllama, ModelName: "llama3"}, "llm_config.base_url"},
+		{"ollama missing model", LLMConfig{Provider: ProviderOllama, BaseURL: "http://localhost:11434"}, "llm_config.model_name"},
+
+		{"max context tokens", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", MaxContextTokens: 4096}, ""},
+		{"negative max context tokens", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", MaxContextTokens: -1}, "llm_config.max_context_tokens"},
+		{"allowed models", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", AllowedModels: []string{"mistral"}}, ""},
+		{"empty allowed model", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", AllowedModels: []string{" "}}, "llm_config.allowed_models"},
+		{"embedding model", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", EmbeddingModel: "nomic-embed-text"}, ""},
+		{"bedrock embeddings", LLMConfig{Provider: ProviderBedrock, ModelName: "m", BaseURL: "https://bedrock-runtime.us-east-1.amazonaws.com", APIKeyEnv: "K", EmbeddingModel: "e"}, "llm_config.embedding_model"},
+		{"batched", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", BatchSize: 64, BatchFlushMillis: 50}, ""},
+		{"negative batch size", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", BatchSize: -1}, "llm_config.batch_size"},
+		{"negative batch flush", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", BatchFlushMillis: -1}, "llm_config.batch_flush_millis"},
+		{"retries and breaker", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", MaxRetries: 3, RetryBaseMillis: 100, BreakerThreshold: 5, BreakerOpenSeconds: 30}, ""},
+		{"negative retries", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", MaxRetries: -1}, "llm_config.max_retries"},
+		{"negative retry base", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", RetryBaseMillis: -1}, "llm_config.retry_base_millis"},
+		{"negative breaker threshold", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", BreakerThreshold: -1}, "llm_config.breaker_threshold"},
+		{"breaker never closes", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", BreakerThreshold: 5}, "llm_config.breaker_open_seconds"},
+		{"negative timeout", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", TimeoutSeconds: -1}, "llm_config.timeout_seconds"},
+		{"debug io", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", DebugStoreLLMIO: true, DebugRetentionHours: 2}, ""},
+		{"negative debug retention", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", DebugStoreLLMIO: true, DebugRetentionHours: -1}, "llm_config.debug_retention_hours"},
+		{"fallbacks", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", TimeoutSeconds: 20,
+			Fallbacks: []LLMProvider{{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKeyEnv: "OPENAI_API_KEY", TimeoutSeconds: 30}}}, ""},
+		{"fallback without provider", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434",
+			Fallbacks: []LLMProvider{{ModelName: "gpt-4o"}}}, "llm_config.fallbacks[0].provider"},
+		{"fallback missing model", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434",
+			Fallbacks: []LLMProvider{{Provider: ProviderOpenAI, APIKeyEnv: "OPENAI_API_KEY"}}}, "llm_config.fallbacks[0].model_name"},
+		{"fallback bad base url", LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434",
+			Fallbacks: []LLMProvider{{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434"}, {Provider: ProviderOllama, ModelName: "llama3", BaseURL: "localhost"}}}, "llm_config.fallbacks[1].base_url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("expected config to be valid, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Fatalf("error should name %s. It's: %v", tt.field, err)
+			}
+		})
+	}
+}
+
+func TestLLMConfigAllowsModel(t *testing.T) {
+	cfg := LLMConfig{Provider: ProviderOpenAI, ModelName: "gpt-4o", AllowedModels: []string{"gpt-4o-mini"}}
+	for model, allowed := range map[string]bool{"gpt-4o": true, "gpt-4o-mini": true, "gpt-3.5-turbo": false, "": false} {
+		if got := cfg.AllowsModel(model); got != allowed {
+			t.Fatalf("AllowsModel(%q) should be %v", model, allowed)
+		}
+	}
+	if (LLMConfig{}).AllowsModel("") {
+		t.Fatal("no model is allowed without an LLM")
+	}
+}
+
+func TestLLMConfigProviders(t *testing.T) {
+	cfg := LLMConfig{Provider: ProviderOllama, ModelName: "llama3", BaseURL: "http://localhost:11434", MaxRetries: 2,
+		Fallbacks: []LLMProvider{{Provider: ProviderOpenAI, ModelName: "gpt-4o", APIKeyEnv: "OPENAI_API_KEY", TimeoutSeconds: 30}}}
+	providers := cfg.Providers()
+	if len(providers) != 2 || providers[0].Provider != ProviderOllama || providers[0].Timeout() != 60*time.Second {
+		t.Fatalf("the primary should be first, with the default timeout. Got %+v", providers)
+	}
+	// the fallbacks keep the rest of the config
+	if p := providers[1]; p.Provider != ProviderOpenAI || p.ModelName != "gpt-4o" || p.BaseURL != "" || p.APIKeyEnv != "OPENAI_API_KEY" ||
+		p.Timeout() != 30*time.Second || p.MaxRetries != 2 || len(p.Fallbacks) != 0 {
+		t.Fatalf("the fallback should be the config with its provider. Got %+v", p)
+	}
+}
+
+func TestLoadConfig_LLMConfig(t *testing.T) {
+	tgConfigPath := setup(t)
+	t.Setenv("GRAPHRAG_LLM_PROVIDER", "ollama")
+
+	// provider is set but the rest isn't
+	_, err := LoadConfig(map[string]string{"tgconfig": tgConfigPath})
+	if err == nil || !strings.Contains(err.Error(), "llm_config.model_name") {
+		t.Fatalf("LoadConfig should validate llm_config. It returned: %v", err)
+	}
+
+	t.Setenv("GRAPHRAG_LLM_MODEL_NAME", "llama3")
+	t.Setenv("GRAPHRAG_LLM_BASE_URL", "http://localhost:11434")
+	cfg, err := LoadConfig(map[string]string{"tgconfig": tgConfigPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.LLMConfig.Enabled() || cfg.LLMConfig.ModelName != "llama3" {
+		t.Fatalf("llm config is wrong, %v", cfg.LLMConfig)
+	}
+
+	// the retries and the breaker are on by default, and setting them to 0 turns them off
+	if cfg.LLMConfig.MaxRetries != 2 || cfg.LLMConfig.BreakerThreshold != 5 || cfg.LLMConfig.BreakerOpenSeconds != 30 {
+		t.Fatalf("llm retries and breaker should have their defaults, %+v", cfg.LLMConfig)
+	}
+	t.Setenv("GRAPHRAG_LLM_MAX_RETRIES", "0")
+	t.Setenv("GRAPHRAG_LLM_BREAKER_THRESHOLD", "0")
+	if cfg, err = LoadConfig(map[string]string{"tgconfig": tgConfigPath}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LLMConfig.MaxRetries != 0 || cfg.LLMConfig.BreakerThreshold != 0 {
+		t.Fatalf("zero should replace the defaults, %+v", cfg.LLMConfig)
+	}
+}
+
+func TestProxies(t *testing.T) {
+	c := ChatDbConfig{TrustedProxies: []string{"10.1.0.0/16", "192.168.1.5", "2001:db8::/32"}}
+	proxies, err := c.Proxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.1.0.0/16", "192.168.1.5/32", "2001:db8::/32"}
+	if len(proxies) != len(want) {
+		t.Fatalf("expected %v, got: %v", want, proxies)
+	}
+	for i, p := range proxies {
+		if p.String() != want[i] {
+			t.Fatalf("expected %v, got: %v", want, proxies)
+		}
+	}
+	// the host bits of a CIDR don't matter
+	if proxies, err := (ChatDbConfig{TrustedProxies: []string{"10.1.2.3/16"}}).Proxies(); err != nil || proxies[0].String() != "10.1.0.0/16" {
+		t.Fatalf("expected 10.1.0.0/16, got: %v, %v", proxies, err)
+	}
+}
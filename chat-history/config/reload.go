@@ -0,0 +1,133 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// fields that are read on every request, so they can be swapped while the server is running.
+// Everything else (i.e., dbPath, ports) is only read on startup
+var reloadable = map[string]bool{
+	"chat_config.conversationAccessRoles": true,
+	"chat_config.adminRoles":              true,
+	"chat_config.logLevel":                true,
+	"chat_config.writeRatePerSec":         true,
+	"chat_config.writeBurst":              true,
+}
+
+// Live is the config the server is running with. Reload reads the config files again
+// and swaps in the reloadable fields
+type Live struct {
+	paths map[string]string
+	cur   atomic.Pointer[Config]
+
+	// serializes reloads so callbacks see them in order
+	mu       sync.Mutex
+	onReload []func(Config)
+}
+
+// NewLive starts from cfg, which was loaded from paths
+func NewLive(cfg Config, paths map[string]string) *Live {
+	l := &Live{paths: paths}
+	l.cur.Store(&cfg)
+	return l
+}
+
+// Get returns the current config
+func (l *Live) Get() Config {
+	return *l.cur.Load()
+}
+
+// OnReload calls fn with the new config after every successful reload
+func (l *Live) OnReload(fn func(Config)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReload = append(l.onReload, fn)
+}
+
+// Reload runs LoadConfig on the paths again and swaps in the reloadable fields that changed.
+// It returns the keys of the other fields that changed, which are ignored until a restart.
+// If the new config doesn't load or isn't valid, the current one is kept
+func (l *Live) Reload() ([]string, error) {
+	loaded, err := LoadConfig(l.paths)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := l.Get()
+	ignored := merge(&next, loaded)
+	l.cur.Store(&next)
+	for _, fn := range l.onReload {
+		fn(next)
+	}
+	return ignored, nil
+}
+
+// merge copies the reloadable fields of src to dst, and returns the keys of the fields that differ but aren't
+func merge(dst *Config, src Config) []string {
+	var ignored []string
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+	for i := range d.NumField() {
+		section := jsonKey(d.Type().Field(i))
+		dSection, sSection := d.Field(i), s.Field(i)
+		// schema_version, which is the same once both are loaded
+		if dSection.Kind() != reflect.Struct {
+			continue
+		}
+		for j := range dSection.NumField() {
+			key := section + "." + jsonKey(dSection.Type().Field(j))
+			if reflect.DeepEqual(dSection.Field(j).Interface(), sSection.Field(j).Interface()) {
+				continue
+			}
+			if reloadable[key] {
+				dSection.Field(j).Set(sSection.Field(j))
+			} else {
+				ignored = append(ignored, key)
+			}
+		}
+	}
+	return ignored
+}
+
+func jsonKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// ReloadOnSIGHUP reloads the config every time the process gets SIGHUP, until the returned stop func is called.
+// Ignored fields and failed reloads are logged
+func (l *Live) ReloadOnSIGHUP() (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				ignored, err := l.Reload()
+				if err != nil {
+					slog.Error("failed to reload the config, keeping the current one", "err", err)
+					continue
+				}
+				for _, key := range ignored {
+					slog.Warn("changed but can't be reloaded, restart to apply it", "key", key)
+				}
+				slog.Info("reloaded the config")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
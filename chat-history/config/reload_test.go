@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeChatConfig(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	paths := map[string]string{"tgconfig": setup(t), "chatconfig": setupChatConfig(t, `
+{
+	"apiPort": "8002",
+	"dbPath": "chats.db",
+	"conversationAccessRoles": ["superuser"],
+	"writeBurst": 20
+}`)}
+	cfg, err := LoadConfig(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewLive(cfg, paths)
+	reloaded := make(chan Config, 1)
+	live.OnReload(func(cfg Config) { reloaded <- cfg })
+	stop := live.ReloadOnSIGHUP()
+	defer stop()
+
+	writeChatConfig(t, paths["chatconfig"], `
+{
+	"apiPort": "9000",
+	"dbPath": "other.db",
+	"conversationAccessRoles": ["superuser", "analyst"],
+	"writeBurst": 5,
+	"logLevel": "warn"
+}`)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the config wasn't reloaded after SIGHUP")
+	}
+	got := live.Get().ChatDbConfig
+	if !slices.Equal(got.ConversationAccessRoles, []string{"superuser", "analyst"}) || got.WriteBurst != 5 || got.LogLevel != "warn" {
+		t.Fatalf("roles, rate limits and the log level should be reloaded: %+v", got)
+	}
+	if got.Port != "8002" || got.DbPath != "chats.db" {
+		t.Fatalf("the port and dbPath can't change without a restart: %+v", got)
+	}
+}
+
+func TestReload_IgnoredFields(t *testing.T) {
+	paths := map[string]string{"tgconfig": setup(t), "chatconfig": setupChatConfig(t, `
+{
+	"apiPort": "8002",
+	"dbPath": "chats.db",
+	"conversationAccessRoles": ["superuser"]
+}`)}
+	cfg, err := LoadConfig(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewLive(cfg, paths)
+
+	writeChatConfig(t, paths["chatconfig"], `
+{
+	"apiPort": "9000",
+	"dbPath": "other.db",
+	"conversationAccessRoles": ["analyst"]
+}`)
+	ignored, err := live.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"chat_config.apiPort", "chat_config.dbPath"}; !reflect.DeepEqual(ignored, want) {
+		t.Fatalf("ignored fields should be %v. They're: %v", want, ignored)
+	}
+}
+
+func TestReload_InvalidKeepsCurrent(t *testing.T) {
+	paths := map[string]string{"tgconfig": setup(t), "chatconfig": setupChatConfig(t, `
+{
+	"apiPort": "8002",
+	"dbPath": "chats.db",
+	"conversationAccessRoles": ["superuser"]
+}`)}
+	cfg, err := LoadConfig(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewLive(cfg, paths)
+
+	// an empty role list doesn't validate
+	writeChatConfig(t, paths["chatconfig"], `
+{
+	"apiPort": "8002",
+	"dbPath": "chats.db",
+	"conversationAccessRoles": []
+}`)
+	if _, err := live.Reload(); err == nil {
+		t.Fatal("an invalid config shouldn't reload")
+	}
+	if roles := live.Get().ChatDbConfig.ConversationAccessRoles; !slices.Equal(roles, []string{"superuser"}) {
+		t.Fatalf("the current roles should be kept. They're: %v", roles)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// the largest config document read from an HTTP source
+const maxHTTPConfigBytes = 1 << 20
+
+// Source is somewhere a config file (JSON or YAML) is read from, i.e., a local file or a
+// secrets manager. Other backends (S3, vault) can be plugged in by implementing it.
+// Read's error should wrap ErrConfigNotFound if there's no file
+type Source interface {
+	// Read returns the contents of the file. It gives up with ctx's error once ctx is done
+	Read(ctx context.Context) ([]byte, error)
+	// Name identifies the source in errors. Its extension, if there is one, picks the format
+	Name() string
+}
+
+// FileSource reads the config file at the path
+type FileSource string
+
+func (s FileSource) Read(ctx context.Context) ([]byte, error) {
+	// reading a local file can't be interrupted, so only check ctx before starting
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(string(s))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrConfigNotFound, err)
+	}
+	return b, err
+}
+
+func (s FileSource) Name() string {
+	return string(s)
+}
+
+// HTTPSource GETs the config file from URL, sending Header with the request (i.e., the
+// secrets manager's credentials). Client defaults to http.DefaultClient
+type HTTPSource struct {
+	URL    string
+	Header http.Header
+	Client *http.Client
+}
+
+func (s HTTPSource) Read(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the url in the error could have credentials in its query
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("GET %s: %w", s.Name(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: GET %s: %s", ErrConfigNotFound, s.Name(), resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", s.Name(), resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPConfigBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxHTTPConfigBytes {
+		return nil, fmt.Errorf("GET %s: config is larger than %d bytes", s.Name(), maxHTTPConfigBytes)
+	}
+	return b, nil
+}
+
+// Name is the URL without its query, which could hold credentials (i.e., a presigned S3 URL)
+func (s HTTPSource) Name() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return s.URL
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// FileSources makes a FileSource of each path, for LoadConfigContext
+func FileSources(paths map[string]string) map[string]Source {
+	sources := make(map[string]Source, len(paths))
+	for key, path := range paths {
+		sources[key] = FileSource(path)
+	}
+	return sources
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigContext_FileSource(t *testing.T) {
+	tgConfigPath := setup(t)
+
+	fromSource, err := LoadConfigContext(context.Background(), map[string]Source{"tgconfig": FileSource(tgConfigPath)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPath, err := LoadConfig(map[string]string{"tgconfig": tgConfigPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromSource, fromPath) {
+		t.Fatalf("a file source should load the same config as its path.\nsource: %+v\npath: %+v", fromSource, fromPath)
+	}
+
+	// a cancelled context stops it before the file is read
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := LoadConfigContext(ctx, map[string]Source{"tgconfig": FileSource(tgConfigPath)}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("loading with a cancelled context should fail with context.Canceled. Got: %v", err)
+	}
+}
+
+func TestLoadConfigContext_HTTPSource(t *testing.T) {
+	tgConfig, err := os.ReadFile(setup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"/secrets/server_config.json": string(tgConfig),
+		"/secrets/chat_config.yaml":   "apiPort: \"8003\"\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+	header := http.Header{"Authorization": {"Bearer secret"}}
+
+	cfg, err := LoadConfigContext(context.Background(), map[string]Source{
+		"tgconfig":   HTTPSource{URL: srv.URL + "/secrets/server_config.json", Header: header},
+		"chatconfig": HTTPSource{URL: srv.URL + "/secrets/chat_config.yaml?version=2", Header: header},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the YAML chat config is merged over the JSON file's chat_config
+	if cfg.TgDbConfig.Hostname != "http://tigergraph" || cfg.ChatDbConfig.Port != "8003" || cfg.ChatDbConfig.DbPath != "chats.db" {
+		t.Fatalf("config should be read from the HTTP sources: %+v", cfg)
+	}
+
+	// anything other than a 200 is an error, named without the URL's query
+	_, err = LoadConfigContext(context.Background(), map[string]Source{
+		"tgconfig": HTTPSource{URL: srv.URL + "/secrets/server_config.json?token=hunter2"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "hunter2") {
+		t.Fatalf("expected a 401 error without the query. Got: %v", err)
+	}
+
+	// a 404 is a missing file
+	_, err = LoadConfigContext(context.Background(), map[string]Source{
+		"tgconfig": HTTPSource{URL: srv.URL + "/secrets/missing.json", Header: header},
+	})
+	if !errors.Is(err, ErrConfigNotFound) {
+		t.Fatalf("a 404 should wrap ErrConfigNotFound. Got: %v", err)
+	}
+}
+
+func TestLoadConfigContext_HTTPSourceTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := LoadConfigContext(ctx, map[string]Source{"tgconfig": HTTPSource{URL: srv.URL + "/server_config.json"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("an unresponsive source should fail with context.DeadlineExceeded. Got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the timeout should be honored. Loading took %v", elapsed)
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidAccess is returned for a grant that isn't read or write, or that's to the conversation's owner
+var ErrInvalidAccess = errors.New("access must be read or write, to a user other than the owner")
+
+func (s *sqliteStore) GrantAccess(ownerId, conversationId string, access structs.ConversationAccess) (*structs.ConversationAccess, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(ownerId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	if (access.Permission != structs.PermissionRead && access.Permission != structs.PermissionWrite) ||
+		access.UserId == "" || access.UserId == ownerId {
+		return nil, ErrInvalidAccess
+	}
+	access.ConversationId = convoId
+	// granting again changes the permission, and keeps when it was first granted
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"permission", "granted_by", "updated_at"})}).
+			Create(&access).Error
+		if err != nil {
+			return err
+		}
+		return appendEvent(tx, structs.ConversationEvent{Type: EventConversationShared, ConversationId: convoId, UserId: access.GrantedBy, Detail: access.UserId})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.access(access.UserId, convoId.String())
+}
+
+func (s *sqliteStore) RevokeAccess(ownerId, conversationId, userId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(ownerId, conversationId)
+	if err != nil {
+		return err
+	}
+	res := s.db.Where("conversation_id = ? AND user_id = ?", convoId, userId).Delete(&structs.ConversationAccess{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) ListAccess(ownerId, conversationId string) ([]structs.ConversationAccess, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convoId, err := s.ownedConversation(ownerId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	grants := []structs.ConversationAccess{}
+	if err := s.db.Where("conversation_id = ?", convoId).Order("created_at, user_id").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (s *sqliteStore) GetAccess(userId, conversationId string) (*structs.ConversationAccess, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.access(userId, conversationId)
+}
+
+// access is GetAccess for callers that hold the lock
+func (s *sqliteStore) access(userId, conversationId string) (*structs.ConversationAccess, error) {
+	grant := structs.ConversationAccess{}
+	tx := s.db.Where("conversation_id = ? AND user_id = ?", conversationId, userId).First(&grant)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &grant, nil
+}
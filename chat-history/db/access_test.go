@@ -0,0 +1,119 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGrantAccess(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER).String()
+	read := structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead, GrantedBy: USER}
+
+	grant, err := s.GrantAccess(USER, convoId, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.ConversationId.String() != convoId || grant.UserId != "Miss_Take" || grant.Permission != structs.PermissionRead ||
+		grant.GrantedBy != USER || grant.CreatedAt.IsZero() {
+		t.Fatalf("the grant should be returned as it's stored: %+v", grant)
+	}
+	if got, err := s.GetAccess("Miss_Take", convoId); err != nil || !got.Allows(structs.PermissionRead) || got.Allows(structs.PermissionWrite) {
+		t.Fatalf("the user should have read access and not write access. Got %+v, %v", got, err)
+	}
+
+	// granting again changes the permission and keeps the grant's place
+	if _, err := s.GrantAccess(USER, convoId, structs.ConversationAccess{UserId: "Mr_Nobody", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	write := read
+	write.Permission = structs.PermissionWrite
+	if regrant, err := s.GrantAccess(USER, convoId, write); err != nil || !regrant.Allows(structs.PermissionRead) || !regrant.CreatedAt.Equal(grant.CreatedAt) {
+		t.Fatalf("write access should include read, and keep when it was first granted. Got %+v, %v", regrant, err)
+	}
+	grants, err := s.ListAccess(USER, convoId)
+	if err != nil || len(grants) != 2 || grants[0].UserId != "Miss_Take" || grants[0].Permission != structs.PermissionWrite ||
+		grants[1].UserId != "Mr_Nobody" {
+		t.Fatalf("the grants should be listed in the order they were first made. Got %+v, %v", grants, err)
+	}
+
+	tests := []struct {
+		name, owner string
+		access      structs.ConversationAccess
+		want        error
+	}{
+		{"not the owner", "Miss_Take", structs.ConversationAccess{UserId: "Mr_Nobody", Permission: structs.PermissionWrite}, ErrNotFound},
+		{"to the owner", USER, structs.ConversationAccess{UserId: USER, Permission: structs.PermissionRead}, ErrInvalidAccess},
+		{"no user", USER, structs.ConversationAccess{Permission: structs.PermissionRead}, ErrInvalidAccess},
+		{"unknown permission", USER, structs.ConversationAccess{UserId: "Mr_Nobody", Permission: "admin"}, ErrInvalidAccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.GrantAccess(tt.owner, convoId, tt.access); !errors.Is(err, tt.want) {
+				t.Fatalf("GrantAccess should return %v. It returned: %v", tt.want, err)
+			}
+		})
+	}
+	// grantees can't see or change who else has access
+	if _, err := s.ListAccess("Miss_Take", convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("only the owner should list the grants. Got: %v", err)
+	}
+	if err := s.RevokeAccess("Miss_Take", convoId, "Mr_Nobody"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("only the owner should revoke grants. Got: %v", err)
+	}
+
+	if err := s.RevokeAccess(USER, convoId, "Miss_Take"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAccess("Miss_Take", convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the revoked access should be gone. Got: %v", err)
+	}
+	if err := s.RevokeAccess(USER, convoId, "Miss_Take"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("revoking access that isn't there should be not found. Got: %v", err)
+	}
+}
+
+func TestGrantAccess_FollowsConversation(t *testing.T) {
+	s := newArchiveStore(t)
+	convoId := seedConversation(t, s, USER).String()
+	for _, user := range []string{"Miss_Take", "Mr_Nobody"} {
+		if _, err := s.GrantAccess(USER, convoId, structs.ConversationAccess{UserId: user, Permission: structs.PermissionRead}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// grants are archived with the conversation
+	if err := s.ArchiveConversation(USER, convoId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAccess("Miss_Take", convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the grant should move to the archive. Got: %v", err)
+	}
+	if err := s.UnarchiveConversation(USER, convoId); err != nil {
+		t.Fatal(err)
+	}
+	if grants, err := s.ListAccess(USER, convoId); err != nil || len(grants) != 2 {
+		t.Fatalf("the grants should come back with the conversation. Got %+v, %v", grants, err)
+	}
+
+	// the new owner keeps the others' grants, and doesn't need their own
+	if err := s.TransferOwnership(convoId, "Miss_Take"); err != nil {
+		t.Fatal(err)
+	}
+	if grants, err := s.ListAccess("Miss_Take", convoId); err != nil || len(grants) != 1 || grants[0].UserId != "Mr_Nobody" {
+		t.Fatalf("only Mr_Nobody's grant should be left. Got %+v, %v", grants, err)
+	}
+
+	// and they're purged with it
+	if err := s.DeleteConversation("Miss_Take", convoId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PurgeTrash(-time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAccess("Mr_Nobody", convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the grant should be purged with the conversation. Got: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ErrNoArchive is returned by ArchiveConversation and UnarchiveConversation if the store wasn't opened with ArchivePath
+var ErrNoArchive = errors.New("no archive database is configured")
+
+// openArchive opens (or creates) the archive database at path. It has the same schema as the
+// primary one, but messages aren't indexed for search
+func openArchive(path, logPath string, o options) (*gorm.DB, error) {
+	if !o.readOnly {
+		if err := prepareFile(path, o.fileMode); err != nil {
+			return nil, err
+		}
+	}
+	archive, err := gorm.Open(sqlite.Open(dsn(path, o)), gormConfig(logPath, o))
+	if err != nil {
+		return nil, err
+	}
+	if err := registerTenants(archive); err != nil {
+		return nil, err
+	}
+	if o.readOnly {
+		if err := checkSchema(archive); err != nil {
+			return nil, err
+		}
+		return archive, nil
+	}
+	sqlDB, err := archive.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := ensureSchema(archive); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+func (s *sqliteStore) ArchiveConversation(userId, conversationId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.archive == nil {
+		return ErrNoArchive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return moveConversation(s.db, s.archive, userId, conversationId)
+}
+
+func (s *sqliteStore) UnarchiveConversation(userId, conversationId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.archive == nil {
+		return ErrNoArchive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return moveConversation(s.archive, s.db, userId, conversationId)
+}
+
+func (s *sqliteStore) ArchiveInactive(olderThan time.Duration) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	if s.archive == nil {
+		return 0, ErrNoArchive
+	}
+	cutoff := s.now().Add(-olderThan)
+
+	s.mu.RLock()
+	var inactive []structs.Conversation
+	err := s.db.Select("user_id", "conversation_id").Where("updated_at < ?", cutoff).Order("updated_at").Find(&inactive).Error
+	s.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// one at a time, so writes to other conversations aren't held up for the whole sweep
+	var archived int64
+	for _, c := range inactive {
+		moved, err := s.archiveIfInactive(c.UserId, c.ConversationId, cutoff)
+		if err != nil {
+			return archived, err
+		}
+		if moved {
+			archived++
+		}
+	}
+	return archived, nil
+}
+
+// archiveIfInactive moves the conversation to the archive if it still hasn't been updated since cutoff.
+// It may have been written to, deleted or archived since it was found
+func (s *sqliteStore) archiveIfInactive(userId string, conversationId uuid.UUID, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	err := s.db.Model(&structs.Conversation{}).Where("conversation_id = ? AND updated_at < ?", conversationId, cutoff).Count(&count).Error
+	if err != nil || count == 0 {
+		return false, err
+	}
+	if err := moveConversation(s.db, s.archive, userId, conversationId.String()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StartAutoArchive archives the conversations that haven't been updated for olderThan every interval,
+// until the returned stop func is called
+func StartAutoArchive(store ConversationStore, olderThan, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.ArchiveInactive(olderThan)
+				if err != nil {
+					slog.Error("failed to archive inactive conversations", "archived", n, "err", err)
+				} else if n > 0 {
+					slog.Info("archived inactive conversations", "count", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// moveConversation copies the user's conversation with its messages, revisions, attachments, embeddings, graph contexts, tags, share links, grants, read markers and events
+// from one database to the other, keeping their ids, and then deletes it from the first. The two can't
+// be changed in one transaction, so if deleting fails the conversation is in both until it's moved again
+func moveConversation(from, to *gorm.DB, userId, conversationId string) error {
+	// conversations in the trash aren't moved, they're purged from where they are
+	convo := structs.Conversation{}
+	tx := from.Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	} else if tx.Error != nil {
+		return tx.Error
+	}
+
+	var messages []structs.Message
+	if err := from.Unscoped().Where("conversation_id = ?", convo.ConversationId).Find(&messages).Error; err != nil {
+		return err
+	}
+	messageIds := from.Unscoped().Model(&structs.Message{}).Select("message_id").Where("conversation_id = ?", convo.ConversationId)
+	var revisions []structs.MessageRevision
+	if err := from.Where("message_id IN (?)", messageIds).Find(&revisions).Error; err != nil {
+		return err
+	}
+	var attachments []structs.Attachment
+	if err := from.Where("message_id IN (?)", messageIds).Find(&attachments).Error; err != nil {
+		return err
+	}
+	var embeddings []structs.MessageEmbedding
+	if err := from.Where("message_id IN (?)", messageIds).Find(&embeddings).Error; err != nil {
+		return err
+	}
+	var contexts []structs.MessageContext
+	if err := from.Where("message_id IN (?)", messageIds).Find(&contexts).Error; err != nil {
+		return err
+	}
+	var feedback []structs.MessageFeedback
+	if err := from.Where("message_id IN (?)", messageIds).Find(&feedback).Error; err != nil {
+		return err
+	}
+	var exchanges []structs.LLMExchange
+	if err := from.Where("message_id IN (?)", messageIds).Find(&exchanges).Error; err != nil {
+		return err
+	}
+	var tags []structs.ConversationTag
+	if err := from.Where("conversation_id = ?", convo.ConversationId).Find(&tags).Error; err != nil {
+		return err
+	}
+	var links []structs.ShareLink
+	if err := from.Where("conversation_id = ?", convo.ConversationId).Find(&links).Error; err != nil {
+		return err
+	}
+	var grants []structs.ConversationAccess
+	if err := from.Where("conversation_id = ?", convo.ConversationId).Find(&grants).Error; err != nil {
+		return err
+	}
+	var markers []structs.ReadMarker
+	if err := from.Where("conversation_id = ?", convo.ConversationId).Find(&markers).Error; err != nil {
+		return err
+	}
+	var events []structs.ConversationEvent
+	if err := from.Where("conversation_id = ?", convo.ConversationId).Find(&events).Error; err != nil {
+		return err
+	}
+
+	// contents are copied as they're stored, so encrypted messages stay sealed
+	err := to.Transaction(func(tx *gorm.DB) error {
+		// a copy left behind by an earlier move that didn't finish is replaced
+		if err := deleteConversationRows(tx, convo.ConversationId); err != nil {
+			return err
+		}
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		for _, rows := range []any{messages, revisions, attachments, embeddings, contexts, feedback, exchanges, tags, links, grants, markers, events} {
+			if err := tx.CreateInBatches(rows, 100).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return from.Transaction(func(tx *gorm.DB) error {
+		return deleteConversationRows(tx, convo.ConversationId)
+	})
+}
+
+// deleteConversationRows permanently deletes the conversation and everything that belongs to it
+func deleteConversationRows(tx *gorm.DB, conversationId uuid.UUID) error {
+	messageIds := tx.Unscoped().Model(&structs.Message{}).Select("message_id").Where("conversation_id = ?", conversationId)
+	for _, model := range []any{&structs.MessageRevision{}, &structs.Attachment{}, &structs.MessageEmbedding{}, &structs.MessageContext{}, &structs.MessageFeedback{}, &structs.LLMExchange{}} {
+		if err := tx.Where("message_id IN (?)", messageIds).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	for _, model := range []any{&structs.Message{}, &structs.ConversationTag{}, &structs.ShareLink{}, &structs.ConversationAccess{}, &structs.ReadMarker{}, &structs.ConversationEvent{}, &structs.Conversation{}} {
+		if err := tx.Unscoped().Where("conversation_id = ?", conversationId).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newArchiveStore(t *testing.T, opts ...Option) ConversationStore {
+	tmp := t.TempDir()
+	opts = append(opts, ArchivePath(fmt.Sprintf("%s/archive.db", tmp)))
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestArchiveConversation(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EditMessage(USER, convoId.String(), messages[0].MessageId.String(), "edited", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag(USER, convoId.String(), "work"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAttachment(USER, convoId.String(), messages[0].MessageId.String(), testAttachment("graph.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEmbedding(structs.MessageEmbedding{MessageId: messages[0].MessageId, Model: "embed", Vector: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead(USER, convoId.String(), messages[0].MessageId.String()); err != nil {
+		t.Fatal(err)
+	}
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another user can't archive it
+	if err := s.ArchiveConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	if err := s.ArchiveConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// it's gone from the primary database
+	if _, err := s.FindConversation(convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the archived conversation shouldn't be in the primary database. It returned: %v", err)
+	}
+	if convos, _, err := s.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 0 {
+		t.Fatalf("the archived conversation shouldn't be listed. Got %+v, %v", convos, err)
+	}
+	if all, err := s.GetAllMessages(); err != nil || len(all) != 0 {
+		t.Fatalf("the messages should have moved to the archive. Got %+v, %v", all, err)
+	}
+	if err := s.ArchiveConversation(USER, convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("archiving it again should return ErrNotFound, got: %v", err)
+	}
+
+	// another user can't unarchive it
+	if err := s.UnarchiveConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	if err := s.UnarchiveConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything came back as it was
+	messages, err = s.GetConversation(USER, convoId.String())
+	if err != nil || len(messages) != 1 || messages[0].Content != "edited" {
+		t.Fatalf("the messages should be back and decrypt. Got %+v, %v", messages, err)
+	}
+	if revisions, err := s.ListRevisions(USER, convoId.String(), messages[0].MessageId.String()); err != nil || len(revisions) != 1 || revisions[0].Content != "Hello, world" {
+		t.Fatalf("the revision should be back. Got %+v, %v", revisions, err)
+	}
+	if attachments, err := s.ListAttachments(USER, convoId.String(), ""); err != nil || len(attachments) != 1 {
+		t.Fatalf("the attachment should be back. Got %+v, %v", attachments, err)
+	}
+	if similar, err := s.RetrieveSimilar(convoId.String(), []float32{1, 0}, "embed", 1); err != nil || len(similar) != 1 {
+		t.Fatalf("the embedding should be back. Got %+v, %v", similar, err)
+	}
+	if unread, err := s.GetUnreadCount(USER, convoId.String()); err != nil || unread != 0 {
+		t.Fatalf("the read marker should be back. Got %d unread, %v", unread, err)
+	}
+	convos, _, err := s.ListConversations(USER, ListOptions{Tags: []string{"work"}})
+	if err != nil || len(convos) != 1 || convos[0].Archived {
+		t.Fatalf("the tag should be back. Got %+v, %v", convos, err)
+	}
+	if _, _, err := s.GetSharedConversation(link.Token); err != nil {
+		t.Fatalf("the share link should work again. It returned: %v", err)
+	}
+	if err := s.UnarchiveConversation(USER, convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unarchiving it again should return ErrNotFound, got: %v", err)
+	}
+}
+
+func TestArchiveConversation_Trashed(t *testing.T) {
+	s := newArchiveStore(t)
+	convoId := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("conversations in the trash shouldn't be archived. It returned: %v", err)
+	}
+}
+
+func TestArchiveConversation_NoArchive(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	if err := s.ArchiveConversation(USER, convoId.String()); !errors.Is(err, ErrNoArchive) {
+		t.Fatalf("expected ErrNoArchive, got: %v", err)
+	}
+	if err := s.UnarchiveConversation(USER, convoId.String()); !errors.Is(err, ErrNoArchive) {
+		t.Fatalf("expected ErrNoArchive, got: %v", err)
+	}
+}
+
+// age makes the conversation look like it was last updated d ago
+func age(t *testing.T, s *sqliteStore, conversationId uuid.UUID, d time.Duration) {
+	t.Helper()
+	err := s.db.Unscoped().Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).
+		UpdateColumn("updated_at", time.Now().Add(-d)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveInactive(t *testing.T) {
+	s := newArchiveStore(t).(*sqliteStore)
+	old, otherOld, recent, trashed := seedConversation(t, s, USER), seedConversation(t, s, "Miss_Take"), seedConversation(t, s, USER), seedConversation(t, s, USER)
+	for _, id := range []uuid.UUID{old, otherOld, trashed} {
+		age(t, s, id, 100*24*time.Hour)
+	}
+	age(t, s, recent, 10*24*time.Hour)
+	if err := s.DeleteConversation(USER, trashed.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.ArchiveInactive(90 * 24 * time.Hour)
+	if err != nil || n != 2 {
+		t.Fatalf("the 2 old conversations should be archived. Got %d, %v", n, err)
+	}
+	for _, id := range []uuid.UUID{old, otherOld} {
+		if _, err := s.FindConversation(id.String()); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("old conversation %s should have left the primary database. Got: %v", id, err)
+		}
+	}
+	var archived int64
+	if err := s.archive.Model(&structs.Message{}).Where("conversation_id = ?", old).Count(&archived).Error; err != nil || archived != 1 {
+		t.Fatalf("the old conversation should be in the archive with its message. Got %d, %v", archived, err)
+	}
+	if _, err := s.FindConversation(recent.String()); err != nil {
+		t.Fatalf("the recent conversation should be left alone. Got: %v", err)
+	}
+	if err := s.RestoreConversation(USER, trashed.String(), time.Hour*24*365); err != nil {
+		t.Fatalf("the conversation in the trash should be left where it is. Got: %v", err)
+	}
+
+	// nothing left to archive
+	if n, err := s.ArchiveInactive(90 * 24 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("there should be nothing left to archive. Got %d, %v", n, err)
+	}
+}
+
+func TestArchiveInactive_NoArchive(t *testing.T) {
+	if _, err := newTestStore(t).ArchiveInactive(time.Hour); !errors.Is(err, ErrNoArchive) {
+		t.Fatalf("expected ErrNoArchive, got: %v", err)
+	}
+}
+
+func TestListConversations_IncludeArchived(t *testing.T) {
+	s := newArchiveStore(t)
+	// oldest first, so the list is newest first: 4, 3, 2, 1, 0
+	var ids []string
+	for range 5 {
+		ids = append(ids, seedConversation(t, s, USER).String())
+		time.Sleep(2 * time.Millisecond)
+	}
+	seedConversation(t, s, "Miss_Take")
+	for _, i := range []int{1, 3} {
+		if err := s.ArchiveConversation(USER, ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddTag(USER, ids[0], "work"); err != nil {
+		t.Fatal(err)
+	}
+
+	// page through both databases, two at a time
+	var got []structs.Conversation
+	opts := ListOptions{Limit: 2, IncludeArchived: true}
+	for {
+		page, next, err := s.ListConversations(USER, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page...)
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if len(got) != 5 {
+		t.Fatalf("all 5 of the user's conversations should be listed. Got %+v", got)
+	}
+	for i, c := range got {
+		want := ids[len(ids)-1-i]
+		if c.ConversationId.String() != want {
+			t.Fatalf("conversation %d should be %s. Got %s", i, want, c.ConversationId)
+		}
+		if archived := want == ids[1] || want == ids[3]; c.Archived != archived {
+			t.Fatalf("conversation %s should have archived=%v", want, archived)
+		}
+	}
+	if got[4].Tags == nil || got[4].Tags[0] != "work" {
+		t.Fatalf("the tags should be loaded. Got %+v", got[4])
+	}
+
+	// tags filter the archive too
+	if err := s.UnarchiveConversation(USER, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag(USER, ids[1], "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	tagged, _, err := s.ListConversations(USER, ListOptions{Tags: []string{"work"}, IncludeArchived: true})
+	if err != nil || len(tagged) != 2 || !tagged[0].Archived || tagged[0].Tags[0] != "work" {
+		t.Fatalf("both tagged conversations should be listed. Got %+v, %v", tagged, err)
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// how many attachments a message can have unless MaxAttachments is set
+const defaultMaxAttachments = 10
+
+const maxFilenameLength = 255
+
+var (
+	// ErrInvalidAttachment is returned, wrapped with what's wrong, when an attachment's metadata isn't valid
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	// ErrTooManyAttachments is returned when a message already has as many attachments as it can
+	ErrTooManyAttachments = errors.New("the message has too many attachments")
+)
+
+// the schemes a storage URL can have. Clients link to it, so it can't be something like javascript:
+var storageSchemes = []string{"http", "https", "s3", "gs"}
+
+// validateAttachment trims the attachment's filename and content type, and checks its metadata is usable
+func validateAttachment(a *structs.Attachment) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidAttachment, fmt.Sprintf(format, args...))
+	}
+	a.Filename = strings.TrimSpace(a.Filename)
+	if a.Filename == "" || utf8.RuneCountInString(a.Filename) > maxFilenameLength {
+		return invalid("filename must be 1 to %d characters", maxFilenameLength)
+	}
+	a.ContentType = strings.TrimSpace(a.ContentType)
+	if _, _, err := mime.ParseMediaType(a.ContentType); err != nil {
+		return invalid("content type %q is not a media type", a.ContentType)
+	}
+	if a.Size < 0 {
+		return invalid("size must not be negative")
+	}
+	u, err := url.Parse(a.StorageURL)
+	if err != nil || u.Host == "" || !slices.Contains(storageSchemes, strings.ToLower(u.Scheme)) {
+		return invalid("storage url must be an absolute %s url", strings.Join(storageSchemes, ", "))
+	}
+	return nil
+}
+
+func (s *sqliteStore) AddAttachment(userId, conversationId, messageId string, attachment structs.Attachment) (*structs.Attachment, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := validateAttachment(&attachment); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, err := s.ownedMessage(userId, conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	attachment.ID = 0
+	attachment.AttachmentId = uuid.New()
+	attachment.MessageId = message.MessageId
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&structs.Attachment{}).Where("message_id = ?", message.MessageId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(s.maxAttachments) {
+			return ErrTooManyAttachments
+		}
+		return tx.Create(&attachment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (s *sqliteStore) ListAttachments(userId, conversationId, messageId string) ([]structs.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachments := []structs.Attachment{}
+	tx := s.db.Order("created_at").Order("id")
+	if messageId == "" {
+		convoId, err := s.ownedConversation(userId, conversationId)
+		if err != nil {
+			return nil, err
+		}
+		messageIds := s.db.Model(&structs.Message{}).Select("message_id").Where("conversation_id = ?", convoId)
+		tx = tx.Where("message_id IN (?)", messageIds)
+	} else {
+		message, err := s.ownedMessage(userId, conversationId, messageId)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where("message_id = ?", message.MessageId)
+	}
+	if err := tx.Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// ownedMessage returns the message if it's in the user's conversation, or ErrNotFound
+func (s *sqliteStore) ownedMessage(userId, conversationId, messageId string) (*structs.Message, error) {
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	message := structs.Message{}
+	tx := s.db.Where("conversation_id = ? AND message_id = ?", convoId, messageId).First(&message)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &message, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func testAttachment(name string) structs.Attachment {
+	return structs.Attachment{
+		Filename:    name,
+		ContentType: "image/png",
+		Size:        1024,
+		StorageURL:  "https://files.example.com/" + name,
+	}
+}
+
+func TestAddAttachment(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageId := messages[0].MessageId.String()
+
+	graph := testAttachment("graph.png")
+	graph.Filename = " graph.png "
+	added, err := s.AddAttachment(USER, convoId.String(), messageId, graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.AttachmentId == uuid.Nil || added.MessageId != messages[0].MessageId || added.Filename != "graph.png" {
+		t.Fatalf("the attachment should be returned with its id and trimmed filename: %+v", added)
+	}
+	if _, err := s.AddAttachment(USER, convoId.String(), messageId, testAttachment("schema.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	attachments, err := s.ListAttachments(USER, convoId.String(), messageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || attachments[0].Filename != "graph.png" || attachments[1].Filename != "schema.png" {
+		t.Fatalf("both attachments should be listed, oldest first: %+v", attachments)
+	}
+	if attachments[0].ContentType != "image/png" || attachments[0].Size != 1024 || attachments[0].StorageURL != "https://files.example.com/graph.png" {
+		t.Fatalf("the metadata should be kept: %+v", attachments[0])
+	}
+
+	// every message in the conversation
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "a reply", Role: structs.SystemRole}
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAttachment(USER, convoId.String(), reply.MessageId.String(), testAttachment("answer.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := s.ListAttachments(USER, convoId.String(), ""); err != nil || len(all) != 3 {
+		t.Fatalf("the attachments of every message should be listed. Got %+v, %v", all, err)
+	}
+	if none, err := s.ListAttachments(USER, seedConversation(t, s, USER).String(), ""); err != nil || none == nil || len(none) != 0 {
+		t.Fatalf("a conversation without attachments should have an empty list. Got %+v, %v", none, err)
+	}
+}
+
+func TestAddAttachment_Ownership(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageId := messages[0].MessageId.String()
+
+	if _, err := s.AddAttachment("Miss_Take", convoId.String(), messageId, testAttachment("a.png")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user shouldn't be able to attach to the message. It returned: %v", err)
+	}
+	if _, err := s.AddAttachment(USER, convoId.String(), uuid.NewString(), testAttachment("a.png")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing message, got: %v", err)
+	}
+	if _, err := s.ListAttachments("Miss_Take", convoId.String(), messageId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user shouldn't be able to list the attachments. It returned: %v", err)
+	}
+	if _, err := s.ListAttachments("Miss_Take", convoId.String(), ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user shouldn't be able to list the conversation's attachments. It returned: %v", err)
+	}
+}
+
+func TestAddAttachment_Invalid(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*structs.Attachment)
+	}{
+		{"no filename", func(a *structs.Attachment) { a.Filename = " " }},
+		{"long filename", func(a *structs.Attachment) { a.Filename = strings.Repeat("a", 256) }},
+		{"no content type", func(a *structs.Attachment) { a.ContentType = "" }},
+		{"invalid content type", func(a *structs.Attachment) { a.ContentType = "not a type" }},
+		{"negative size", func(a *structs.Attachment) { a.Size = -1 }},
+		{"relative url", func(a *structs.Attachment) { a.StorageURL = "/files/a.png" }},
+		{"javascript url", func(a *structs.Attachment) { a.StorageURL = "javascript:alert(1)" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAttachment("a.png")
+			tt.change(&a)
+			if _, err := s.AddAttachment(USER, convoId.String(), messages[0].MessageId.String(), a); !errors.Is(err, ErrInvalidAttachment) {
+				t.Fatalf("expected ErrInvalidAttachment, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestAddAttachment_Max(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), MaxAttachments(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageId := messages[0].MessageId.String()
+
+	for i := range 2 {
+		if _, err := s.AddAttachment(USER, convoId.String(), messageId, testAttachment(fmt.Sprintf("%d.png", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddAttachment(USER, convoId.String(), messageId, testAttachment("2.png")); !errors.Is(err, ErrTooManyAttachments) {
+		t.Fatalf("expected ErrTooManyAttachments, got: %v", err)
+	}
+	if attachments, err := s.ListAttachments(USER, convoId.String(), messageId); err != nil || len(attachments) != 2 {
+		t.Fatalf("the message should keep 2 attachments. Got %+v, %v", attachments, err)
+	}
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Backuper is implemented by stores that can snapshot their database while it's in use
+type Backuper interface {
+	// Backup writes a consistent copy of the database to dest, replacing it if it exists
+	Backup(dest string) error
+}
+
+// BackupDatabase writes a consistent snapshot of the database opened by InitDB to dest
+func BackupDatabase(dest string) error {
+	return store.Backup(dest)
+}
+
+func (s *sqliteStore) Backup(dest string) error {
+	// back up to a temp file and rename it, so dest is never a partial copy
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	if err := s.backup(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// backup copies the database to dest with SQLite's online backup API. Writers aren't
+// blocked for long since all pages are copied in a single step
+func (s *sqliteStore) backup(dest string) error {
+	ctx := context.Background()
+
+	srcDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			d, ok := destRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup destination is not a sqlite3 connection")
+			}
+			src, ok := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup source is not a sqlite3 connection")
+			}
+
+			b, err := d.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			// -1 copies every page
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// backups are named backupPrefix + timestamp + backupExt so they sort oldest first
+const (
+	backupPrefix = "chats-"
+	backupExt    = ".db"
+)
+
+// backupNow backs up to a new timestamped file in dir and removes all but the newest keep backups
+func backupNow(b Backuper, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%s%s%s", backupPrefix, time.Now().UTC().Format("20060102T150405.000Z"), backupExt))
+	if err := b.Backup(dest); err != nil {
+		return "", err
+	}
+	return dest, pruneBackups(dir, keep)
+}
+
+// pruneBackups removes all but the newest keep backups in dir. keep <= 0 keeps everything
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backups := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupExt) {
+			backups = append(backups, e.Name())
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartBackups backs up to dir every interval, keeping the newest keep backups, until the returned stop func is called
+func StartBackups(b Backuper, dir string, interval time.Duration, keep int) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if dest, err := backupNow(b, dir, keep); err != nil {
+					slog.Error("failed to back up the database", "err", err)
+				} else {
+					slog.Info("backed up the database", "path", dest)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
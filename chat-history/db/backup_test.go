@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 5; i++ {
+		seedConversation(t, s, USER)
+	}
+	seedConversation(t, s, "Miss_Take")
+
+	dest := fmt.Sprintf("%s/%s", t.TempDir(), "backup.db")
+	if err := s.(Backuper).Backup(dest); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := NewSQLiteStore(dest, fmt.Sprintf("%s/backup.log", t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	for _, user := range []string{USER, "Miss_Take"} {
+		want, _, err := s.ListConversations(user, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := backup.ListConversations(user, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("backup should have %d conversations for %s. It has: %d", len(want), user, len(got))
+		}
+		for i := range want {
+			if got[i].ConversationId != want[i].ConversationId || got[i].Name != want[i].Name || !got[i].UpdatedAt.Equal(want[i].UpdatedAt) {
+				t.Fatalf("backup conversation %d doesn't match the source. %v != %v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// the temp file is renamed away
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("the temporary backup file should be removed. Stat returned: %v", err)
+	}
+}
+
+func TestBackupNow_Prunes(t *testing.T) {
+	s := newTestStore(t)
+	seedConversation(t, s, USER)
+	dir := t.TempDir()
+
+	// files that aren't backups are left alone
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var made []string
+	for i := 0; i < 4; i++ {
+		dest, err := backupNow(s.(Backuper), dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		made = append(made, dest)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupExt))
+	if len(matches) != 2 {
+		t.Fatalf("2 backups should be kept. There are: %v", matches)
+	}
+	for _, dest := range made[2:] {
+		if _, err := os.Stat(dest); err != nil {
+			t.Fatalf("the newest backups should be kept. %s: %v", dest, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("non-backup files should not be pruned. %v", err)
+	}
+}
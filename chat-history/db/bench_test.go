@@ -0,0 +1,85 @@
+package db
+
+import (
+	"chat-history/structs"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// countQueries counts the statements run on db from now on
+func countQueries(tb testing.TB, db *gorm.DB) *atomic.Int64 {
+	tb.Helper()
+	n := &atomic.Int64{}
+	name := fmt.Sprintf("bench:count_%p", n)
+	count := func(*gorm.DB) { n.Add(1) }
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().Before("gorm:query").Register(name, count),
+		cb.Create().Before("gorm:create").Register(name, count),
+		cb.Update().Before("gorm:update").Register(name, count),
+		cb.Delete().Before("gorm:delete").Register(name, count),
+		cb.Row().Before("gorm:row").Register(name, count),
+		cb.Raw().Before("gorm:raw").Register(name, count),
+	} {
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return n
+}
+
+// seedConversations gives the user n conversations of messages messages each, with a tag on every other one
+func seedConversations(tb testing.TB, s ConversationStore, userId string, n, messages int) []uuid.UUID {
+	tb.Helper()
+	ids := make([]uuid.UUID, n)
+	for i := range n {
+		ids[i] = seedConversation(tb, s, userId)
+		seedReplies(tb, s, ids[i], messages-1)
+		if i%2 == 0 {
+			if err := s.AddTag(userId, ids[i].String(), "even"); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return ids
+}
+
+func BenchmarkListConversations(b *testing.B) {
+	s := newTestStore(b).(*sqliteStore)
+	seedConversations(b, s, USER, 500, 5)
+	// another user's conversations are in the same tables
+	seedConversations(b, s, "Miss_Take", 500, 5)
+
+	for _, limit := range []int{20, 100, 0} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			queries := countQueries(b, s.db)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, _, err := s.ListConversations(USER, ListOptions{Limit: limit}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func BenchmarkAppendMessage(b *testing.B) {
+	s := newTestStore(b).(*sqliteStore)
+	convoId := seedConversation(b, s, USER)
+	queries := countQueries(b, s.db)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: fmt.Sprintf("reply %d", i), Role: structs.AssistantRole}
+		if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) CloneConversation(ownerId, conversationId, userId string, opts CloneOptions) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source := structs.Conversation{}
+	tx := s.db.Where("user_id = ? AND conversation_id = ?", ownerId, conversationId).First(&source)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	messages := []structs.Message{}
+	if err := s.db.Where("conversation_id = ?", source.ConversationId).Order("id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	// the copies get new ids, and the content is sealed with the message id
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	clone := structs.Conversation{
+		UserId:         userId,
+		ConversationId: id,
+		Name:           source.Name,
+		ModelName:      source.ModelName,
+		SystemPrompt:   source.SystemPrompt,
+	}
+	if opts.Name != "" {
+		clone.Name = opts.Name
+	}
+	newIds := make(map[uuid.UUID]uuid.UUID, len(messages))
+	for _, m := range messages {
+		newIds[m.MessageId] = uuid.New()
+	}
+	copies := make([]structs.Message, len(messages))
+	for i, m := range messages {
+		// like ForkConversation's, the copies start without the original's feedback, comments and pins
+		copies[i] = structs.Message{
+			ConversationId: id,
+			MessageId:      newIds[m.MessageId],
+			ModelName:      m.ModelName,
+			Content:        m.Content,
+			Role:           m.Role,
+			ResponseTime:   m.ResponseTime,
+			Incomplete:     m.Incomplete,
+			ToolName:       m.ToolName,
+			ToolCallId:     m.ToolCallId,
+		}
+		// the branches are kept, under the new ids. A parent that isn't in the conversation starts a branch
+		if m.ParentId != nil {
+			if parent, ok := newIds[*m.ParentId]; ok {
+				copies[i].ParentId = &parent
+			}
+		}
+		if !opts.ResetTimestamps {
+			copies[i].CreatedAt, copies[i].UpdatedAt = m.CreatedAt, m.UpdatedAt
+		}
+		detectLanguage(&copies[i])
+		s.countTokens(&copies[i])
+	}
+	var first structs.Message
+	if len(copies) > 0 {
+		first = copies[0]
+	}
+	for i := range copies {
+		if err := s.sealer.sealMessage(&copies[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+		if err := tx.Create(&clone).Error; err != nil {
+			return err
+		}
+		if len(copies) == 0 {
+			return appendEvent(tx, structs.ConversationEvent{Type: EventConversationCreated, ConversationId: clone.ConversationId, UserId: clone.UserId})
+		}
+		if err := tx.CreateInBatches(copies, 100).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, createdEvent(clone, copies[0])); err != nil {
+			return err
+		}
+		if clone.Language, err = updateLanguage(tx, id); err != nil {
+			return err
+		}
+		clone.TokenCount, err = updateTokenCount(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(copies) > 0 {
+		s.emit(EventConversationCreated, clone, first, copies[0])
+	}
+	return &clone, nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"chat-history/clock"
+	"chat-history/structs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCloneConversation(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")), Clock(fake))
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hello <- answer <- question, and a second answer to hello on a branch of its own
+	reply := func(parent structs.Message, content string) structs.Message {
+		t.Helper()
+		fake.Advance(time.Minute)
+		m := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &parent.MessageId, Content: content, Role: structs.AssistantRole}
+		if _, err := s.AppendMessage(m, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	answer := reply(messages[0], "answer")
+	reply(messages[0], "another answer")
+	reply(answer, "question")
+	answer.Feedback = structs.ThumbsUp
+	if _, err := s.AppendMessage(answer, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSystemPrompt(USER, convoId.String(), "Answer in French"); err != nil {
+		t.Fatal(err)
+	}
+	original, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Advance(time.Hour)
+	clone, err := s.CloneConversation(USER, convoId.String(), "Miss_Take", CloneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.ConversationId == convoId || clone.UserId != "Miss_Take" || clone.Name != "convo" || clone.ParentId != nil || clone.SystemPrompt != "Answer in French" {
+		t.Fatalf("the clone should be a new conversation of the caller's, like the original but without a parent: %+v", clone)
+	}
+	if found, err := s.FindConversation(clone.ConversationId.String()); err != nil || found.ParentId != nil {
+		t.Fatalf("the clone shouldn't have a parent. Got %+v, %v", found, err)
+	}
+
+	// every message is copied, under new ids, with the same branches and timestamps
+	copied, err := s.GetConversation("Miss_Take", clone.ConversationId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != len(original) {
+		t.Fatalf("the clone should have all %d messages. It has %d", len(original), len(copied))
+	}
+	position := map[uuid.UUID]int{}
+	for i, m := range original {
+		position[m.MessageId] = i
+	}
+	for i, m := range copied {
+		o := original[i]
+		if m.MessageId == o.MessageId || m.ConversationId != clone.ConversationId || m.Content != o.Content || m.Role != o.Role {
+			t.Fatalf("message %d should be a copy of %+v. It's %+v", i, o, m)
+		}
+		if !m.CreatedAt.Equal(o.CreatedAt) || !m.UpdatedAt.Equal(o.UpdatedAt) {
+			t.Fatalf("message %d should keep its timestamps. It's %s, the original %s", i, m.UpdatedAt, o.UpdatedAt)
+		}
+		if m.Feedback != structs.NoFeedback {
+			t.Fatalf("the copies shouldn't have the original's feedback: %+v", m)
+		}
+		switch {
+		case o.ParentId == nil:
+			if m.ParentId != nil {
+				t.Fatalf("message %d shouldn't have a parent: %+v", i, m)
+			}
+		case m.ParentId == nil || *m.ParentId != copied[position[*o.ParentId]].MessageId:
+			t.Fatalf("message %d should be a reply to the copy of its parent: %+v", i, m)
+		}
+	}
+
+	// they're independent: changing one doesn't change the other
+	if _, err := s.EditMessage("Miss_Take", clone.ConversationId.String(), copied[1].MessageId.String(), "edited", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSystemPrompt("Miss_Take", clone.ConversationId.String(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.GetConversation(USER, convoId.String()); again[1].Content != "answer" {
+		t.Fatalf("the original shouldn't be edited. It's %q", again[1].Content)
+	}
+	if revisions, _ := s.ListRevisions(USER, convoId.String(), original[1].MessageId.String()); len(revisions) != 0 {
+		t.Fatalf("editing the clone shouldn't touch the original: %+v", revisions)
+	}
+	if prompt, _ := s.GetSystemPrompt(USER, convoId.String()); prompt != "Answer in French" {
+		t.Fatalf("the original should keep its system prompt. It's %q", prompt)
+	}
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.GetConversation("Miss_Take", clone.ConversationId.String())
+	if err != nil || len(after) != len(original) || after[1].Content != "edited" {
+		t.Fatalf("the clone should be left as it was when the original is deleted. Got %d messages, %v", len(after), err)
+	}
+}
+
+func TestCloneConversation_ResetTimestamps(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newArchiveStore(t, Clock(fake))
+	convoId := seedConversation(t, s, USER)
+	first, _ := s.GetConversation(USER, convoId.String())
+	fake.Advance(time.Minute)
+	second := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &first[0].MessageId, Content: "answer", Role: structs.AssistantRole}
+	if _, err := s.AppendMessage(second, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Advance(24 * time.Hour)
+	clone, err := s.CloneConversation(USER, convoId.String(), USER, CloneOptions{Name: "template", ResetTimestamps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Name != "template" {
+		t.Fatalf("the clone should have the name it was given. It's %q", clone.Name)
+	}
+	copied, err := s.GetConversation(USER, clone.ConversationId.String())
+	if err != nil || len(copied) != 2 {
+		t.Fatalf("the clone should have both messages. Got %d, %v", len(copied), err)
+	}
+	for _, m := range copied {
+		if !m.CreatedAt.Equal(fake.Now()) || !m.UpdatedAt.Equal(fake.Now()) {
+			t.Fatalf("the copies should be written as of now, %s. Got %s", fake.Now(), m.CreatedAt)
+		}
+	}
+	if copied[0].Content != "Hello, world" || copied[1].Content != "answer" || *copied[1].ParentId != copied[0].MessageId {
+		t.Fatalf("the copies should be in the order they were written: %+v", copied)
+	}
+}
+
+func TestCloneConversation_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	if _, err := s.CloneConversation("Miss_Take", convoId.String(), "Miss_Take", CloneOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	if _, err := s.CloneConversation(USER, uuid.NewString(), USER, CloneOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"chat-history/structs"
+
+	"github.com/google/uuid"
+)
+
+func (s *sqliteStore) GraphContexts(conversationId string) (map[uuid.UUID]structs.GraphContext, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rows []structs.MessageContext
+	if err := s.db.Where("conversation_id = ?", conversationId).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	contexts := make(map[uuid.UUID]structs.GraphContext, len(rows))
+	for _, row := range rows {
+		contexts[row.MessageId] = row.Context
+	}
+	return contexts, nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"chat-history/structs"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var testContext = structs.GraphContext{
+	Query:    "answer_question",
+	Vertices: []structs.GraphVertex{{Type: "Entity", Id: "TigerGraph"}},
+	Edges:    []structs.GraphEdge{{Type: "RELATES_TO", FromType: "Entity", FromId: "TigerGraph", ToType: "Entity", ToId: "GSQL"}},
+}
+
+// appendWithContext appends a reply to the conversation with testContext and returns its id
+func appendWithContext(t *testing.T, s ConversationStore, convoId uuid.UUID) uuid.UUID {
+	t.Helper()
+	ctx := testContext
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "GSQL is TigerGraph's query language", Role: structs.SystemRole, GraphContext: &ctx}
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	return reply.MessageId
+}
+
+func TestGraphContexts(t *testing.T) {
+	s := newArchiveStore(t)
+	convoId := seedConversation(t, s, USER)
+	first := firstMessage(t, s, USER, convoId)
+	reply := appendWithContext(t, s, convoId)
+
+	contexts, err := s.GraphContexts(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contexts) != 1 || !reflect.DeepEqual(contexts[reply], testContext) {
+		t.Fatalf("only the reply should have a graph context, as it was appended. Got %+v", contexts)
+	}
+	if _, ok := contexts[uuid.MustParse(first)]; ok {
+		t.Fatal("the message appended without a graph context shouldn't have one")
+	}
+
+	// it's only returned by GraphContexts
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if m.GraphContext != nil {
+			t.Fatalf("messages should be read without their graph context, %s has %+v", m.MessageId, m.GraphContext)
+		}
+	}
+
+	// feedback on the reply leaves its context as it was
+	if _, err := s.AppendMessage(structs.Message{ConversationId: convoId, MessageId: reply, Feedback: structs.ThumbsUp, GraphContext: &structs.GraphContext{Query: "other"}}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if contexts, err := s.GraphContexts(convoId.String()); err != nil || contexts[reply].Query != testContext.Query {
+		t.Fatalf("feedback shouldn't change the graph context. Got %+v, %v", contexts, err)
+	}
+
+	// and it moves with the conversation
+	if err := s.ArchiveConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnarchiveConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if contexts, err := s.GraphContexts(convoId.String()); err != nil || !reflect.DeepEqual(contexts[reply], testContext) {
+		t.Fatalf("the graph context should be back with the conversation. Got %+v, %v", contexts, err)
+	}
+
+	if contexts, err := s.GraphContexts(uuid.NewString()); err != nil || len(contexts) != 0 {
+		t.Fatalf("a conversation without messages has no graph contexts. Got %+v, %v", contexts, err)
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor marks the last conversation of a page. Both fields are needed so that
+// conversations updated at the exact same time aren't skipped or repeated.
+// PinnedAt is set if it was pinned, the page after it starts with the rest of the pinned ones
+type cursor struct {
+	UpdatedAt time.Time  `json:"u"`
+	PinnedAt  *time.Time `json:"p,omitempty"`
+	ID        uint       `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	// compare in the same location the timestamps were stored in
+	c.UpdatedAt = c.UpdatedAt.Local()
+	if c.PinnedAt != nil {
+		local := c.PinnedAt.Local()
+		c.PinnedAt = &local
+	}
+	return c, nil
+}
@@ -3,22 +3,19 @@ package db
 import (
 	"chat-history/middleware"
 	"chat-history/structs"
-	"errors"
 	"log"
 	"os"
 	"strings"
-	"sync"
 
 	"github.com/google/uuid"
 
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 var (
-	db *gorm.DB
-	mu = sync.RWMutex{}
+	db    *gorm.DB
+	store *sqliteStore
 )
 
 func createLogger(logPath string) logger.Interface {
@@ -30,122 +27,58 @@ func createLogger(logPath string) logger.Interface {
 }
 
 // Initialize the DB
-func InitDB(dbPath, logPath string) {
-
-	chatHistDB, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: createLogger(logPath)})
+// The returned store is also used by the package level functions below
+func InitDB(dbPath, logPath string, opts ...Option) ConversationStore {
+	s, err := OpenDB(dbPath, logPath, opts...)
 	if err != nil {
-		panic("failed to connect database")
+		panic(err)
 	}
-	db = chatHistDB
+	return s
+}
 
-	// Migrate the schema
-	err = db.AutoMigrate(&structs.Conversation{}, &structs.Message{})
+// OpenDB is InitDB, returning the error instead of panicking
+func OpenDB(dbPath, logPath string, opts ...Option) (ConversationStore, error) {
+	s, err := openSQLiteStore(dbPath, logPath, opts...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	store = s
+	db = s.db
 
 	// Create -- for testing only
 	dev := strings.ToLower(os.Getenv("DEV")) == "true"
-	if dev {
+	if dev && !s.readOnly {
 		populateDB()
 	}
+	return store, nil
 }
 
 func GetUserConversations(userId string) []structs.Conversation {
-	mu.RLock()
-	defer mu.RUnlock()
-
-	convos := []structs.Conversation{}
-	db.Where("user_id = ?", userId).Find(&convos)
-
+	convos, _, _ := store.ListConversations(userId, ListOptions{})
 	return convos
 }
 
 func GetUserConversationById(userId, conversationId string) []structs.Message {
-	messages := []structs.Message{}
-	convos := GetUserConversations(userId)
-
-	// ensure that conversatonId is a convo for this user
-	mu.RLock()
-	defer mu.RUnlock()
-	for _, c := range convos {
-		// conversaton belongs to this user. get the messages
-		if c.ConversationId.String() == conversationId {
-			db.Where("conversation_id = ?", conversationId).Find(&messages)
-			break
-		}
-	}
+	messages, _ := store.GetConversation(userId, conversationId)
 	return messages
 }
 
 func NewConversation(userId, name string, message structs.Message) (*structs.Conversation, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	convo := structs.Conversation{UserId: userId, ConversationId: message.ConversationId, Name: name}
-	tx := db.Create(&convo)
-	if err := tx.Error; err != nil {
-		return nil, err
-	}
-	tx = db.Create(&message)
-	if err := tx.Error; err != nil {
-		return nil, err
-	}
-
-	return &convo, nil
+	return store.CreateConversation(userId, name, message)
 }
 
 func UpdateConversationById(message structs.Message) (*structs.Conversation, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	// Find the existing message by conversation ID and message ID
-	var existingMessage structs.Message
-	tx := db.Where("conversation_id = ? AND message_id = ? ", message.ConversationId, message.MessageId).First(&existingMessage)
-	if tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			if result := db.Create(&message); result.Error != nil {
-				return nil, result.Error
-			}
-		} else {
-			return nil, tx.Error
-		}
-	} else {
-		// Update only the feedback and comments fields if the message exists
-		if result := db.Model(&existingMessage).Select("Feedback", "Comment").Updates(
-			structs.Message{
-				Feedback: message.Feedback,
-				Comment:  message.Comment,
-			}); result.Error != nil {
-			return nil, result.Error
-		}
-	}
-
-	// Retrieve the updated conversation
-	convo := structs.Conversation{}
-	tx = db.Where("conversation_id = ?", message.ConversationId).Find(&convo)
-
-	if err := tx.Error; err != nil {
-		return nil, err
-	}
-	return &convo, nil
+	return store.AppendMessage(message, AnyVersion)
 }
 
 // GetAllMessages retrieves all messages from the database
 func GetAllMessages() ([]structs.Message, error) {
-	var messages []structs.Message
-
-	// Use GORM to query all messages
-	if err := db.Find(&messages).Error; err != nil {
-		return nil, err
-	}
-
-	return messages, nil
+	return store.GetAllMessages()
 }
 
 func populateDB() {
-	mu.Lock()
-	defer mu.Unlock()
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	// init convos
 	conv1 := uuid.MustParse("601529eb-4927-4e24-b285-bd6b9519a951")
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"chat-history/structs"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Embedder turns message contents into vectors for SaveEmbedding, see llm.Embedder
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+}
+
+const (
+	// messages embedded at once by EmbedStale
+	embeddingBatchSize = 100
+	// the most of a message that's embedded, well within the context of embedding models
+	maxEmbeddedChars = 8000
+)
+
+func (s *sqliteStore) SaveEmbedding(embedding structs.MessageEmbedding) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := structs.Message{}
+	tx := s.db.Where("message_id = ?", embedding.MessageId).First(&message)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	} else if tx.Error != nil {
+		return tx.Error
+	}
+	// embeddings made in the background are the message's tenant's
+	embedding.ConversationId, embedding.TenantId = message.ConversationId, message.TenantId
+	embedding.CreatedAt = time.Time{}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&embedding).Error
+}
+
+func (s *sqliteStore) UnembeddedMessages(model string, limit int) ([]structs.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []structs.Message{}
+	err := s.db.
+		Joins("LEFT JOIN message_embeddings ON message_embeddings.message_id = messages.message_id AND message_embeddings.model = ? AND message_embeddings.message_updated_at = messages.updated_at", model).
+		Where("message_embeddings.message_id IS NULL").
+		Order("messages.id").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (s *sqliteStore) RetrieveSimilar(conversationId string, query []float32, model string, k int) ([]structs.SimilarMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	embeddings := []structs.MessageEmbedding{}
+	if err := s.db.Where("conversation_id = ? AND model = ?", conversationId, model).Find(&embeddings).Error; err != nil {
+		return nil, err
+	}
+	type scored struct {
+		embedding  structs.MessageEmbedding
+		similarity float64
+	}
+	ranked := make([]scored, 0, len(embeddings))
+	for _, e := range embeddings {
+		// vectors of another size can't be compared, they'd be from another version of the model
+		if sim, ok := cosine(query, e.Vector); ok {
+			ranked = append(ranked, scored{e, sim})
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b scored) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+	if k > 0 && len(ranked) > k {
+		ranked = ranked[:k]
+	}
+
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.embedding.MessageId.String()
+	}
+	messages := []structs.Message{}
+	if err := s.db.Where("message_id IN ?", ids).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	byId := make(map[string]structs.Message, len(messages))
+	for _, m := range messages {
+		byId[m.MessageId.String()] = m
+	}
+	results := []structs.SimilarMessage{}
+	for _, r := range ranked {
+		// deleted since it was embedded
+		if m, ok := byId[r.embedding.MessageId.String()]; ok {
+			results = append(results, structs.SimilarMessage{Message: m, Similarity: r.similarity})
+		}
+	}
+	return results, nil
+}
+
+// cosine is the cosine similarity of a and b. It's false if they aren't the same size, or one has no length
+func cosine(a, b []float32) (float64, bool) {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0, false
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0, false
+	}
+	return dot / math.Sqrt(normA*normB), true
+}
+
+// EmbedMessages embeds the messages' contents with embedder and saves the vectors. Messages deleted in the
+// meantime are skipped
+func EmbedMessages(ctx context.Context, store ConversationStore, embedder Embedder, messages []structs.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	texts := make([]string, len(messages))
+	for i, m := range messages {
+		texts[i] = m.Content
+		if r := []rune(m.Content); len(r) > maxEmbeddedChars {
+			texts[i] = string(r[:maxEmbeddedChars])
+		}
+	}
+	vectors, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+	store = store.WithContext(ctx)
+	for i, m := range messages {
+		err := store.SaveEmbedding(structs.MessageEmbedding{
+			MessageId:        m.MessageId,
+			Model:            embedder.Model(),
+			Vector:           vectors[i],
+			MessageUpdatedAt: m.UpdatedAt,
+		})
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmbedStale embeds the messages UnembeddedMessages returns for embedder's model, in batches, until there are
+// none left. It returns how many it embedded
+func EmbedStale(ctx context.Context, store ConversationStore, embedder Embedder) (int, error) {
+	store = store.WithContext(ctx)
+	var n int
+	var last uint
+	for {
+		messages, err := store.UnembeddedMessages(embedder.Model(), embeddingBatchSize)
+		if err != nil || len(messages) == 0 {
+			return n, err
+		}
+		// they come oldest first, so one that's back after it was saved would loop forever
+		if messages[0].ID <= last {
+			return n, fmt.Errorf("message %s is still not embedded after saving its embedding", messages[0].MessageId)
+		}
+		last = messages[len(messages)-1].ID
+		if err := EmbedMessages(ctx, store, embedder, messages); err != nil {
+			return n, err
+		}
+		n += len(messages)
+	}
+}
+
+// StartEmbedder embeds the messages that were added or changed without being embedded, or were embedded with
+// another model, every interval until the returned stop func is called
+func StartEmbedder(store ConversationStore, embedder Embedder, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := EmbedStale(ctx, store, embedder)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("failed to embed messages", "embedded", n, "err", err)
+			} else if n > 0 {
+				slog.Info("embedded messages", "count", n)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"chat-history/structs"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// stubEmbedder counts the words of vocabulary in each text, so texts sharing more of them are closer
+type stubEmbedder struct {
+	model      string
+	vocabulary []string
+	calls      int
+}
+
+func (e *stubEmbedder) Model() string {
+	return e.model
+}
+
+func (e *stubEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e.vocabulary))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, v := range e.vocabulary {
+				if word == v {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+// seedMessages starts a conversation of the user's with the contents, in order
+func seedMessages(t *testing.T, s ConversationStore, contents ...string) uuid.UUID {
+	t.Helper()
+	convoId := uuid.New()
+	var parent *uuid.UUID
+	for i, content := range contents {
+		m := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: parent, Content: content, Role: structs.UserRole}
+		var err error
+		if i == 0 {
+			_, err = s.CreateConversation(USER, "convo", m)
+		} else {
+			_, err = s.AppendMessage(m, AnyVersion)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = &m.MessageId
+	}
+	return convoId
+}
+
+func TestRetrieveSimilar(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	convoId := seedMessages(t, s, "fraud ring in the graph", "the weather is sunny", "fraud alerts today")
+	other := seedMessages(t, s, "fraud fraud fraud")
+	embedder := &stubEmbedder{model: "v1", vocabulary: []string{"fraud", "graph", "weather"}}
+
+	n, err := EmbedStale(context.Background(), s, embedder)
+	if err != nil || n != 4 {
+		t.Fatalf("every message should be embedded. Got %d, %v", n, err)
+	}
+	if n, err := EmbedStale(context.Background(), s, embedder); err != nil || n != 0 {
+		t.Fatalf("there should be nothing left to embed. Got %d, %v", n, err)
+	}
+
+	query, _ := embedder.Embed(context.Background(), []string{"fraud"})
+	similar, err := s.RetrieveSimilar(convoId.String(), query[0], "v1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range similar {
+		got = append(got, m.Content)
+	}
+	if strings.Join(got, "|") != "fraud alerts today|fraud ring in the graph" {
+		t.Fatalf("the 2 nearest messages of the conversation should be returned, nearest first and decrypted. Got %v", got)
+	}
+	if similar[0].Similarity < 0.99 || similar[1].Similarity > similar[0].Similarity || similar[0].ConversationId != convoId {
+		t.Fatalf("the similarities should be the cosine of the vectors: %+v", similar)
+	}
+	if all, err := s.RetrieveSimilar(convoId.String(), query[0], "v1", 0); err != nil || len(all) != 3 {
+		t.Fatalf("k of 0 should return every embedded message of the conversation. Got %d, %v", len(all), err)
+	}
+	if all, err := s.RetrieveSimilar(other.String(), query[0], "v1", 5); err != nil || len(all) != 1 {
+		t.Fatalf("only the conversation's messages should be returned. Got %d, %v", len(all), err)
+	}
+}
+
+func TestUnembeddedMessages(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedMessages(t, s, "fraud ring in the graph", "the weather is sunny")
+	v1 := &stubEmbedder{model: "v1", vocabulary: []string{"fraud", "graph", "weather"}}
+	if _, err := EmbedStale(context.Background(), s, v1); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an edited message is embedded again
+	if _, err := s.EditMessage(USER, convoId.String(), messages[1].MessageId.String(), "the weather graph", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := s.UnembeddedMessages("v1", 10)
+	if err != nil || len(stale) != 1 || stale[0].Content != "the weather graph" {
+		t.Fatalf("the edited message should need embedding again. Got %+v, %v", stale, err)
+	}
+	if n, err := EmbedStale(context.Background(), s, v1); err != nil || n != 1 {
+		t.Fatalf("only the edited message should be embedded. Got %d, %v", n, err)
+	}
+	query, _ := v1.Embed(context.Background(), []string{"weather graph"})
+	if similar, err := s.RetrieveSimilar(convoId.String(), query[0], "v1", 1); err != nil || len(similar) != 1 || similar[0].MessageId != messages[1].MessageId {
+		t.Fatalf("the edited content should be what's found. Got %+v, %v", similar, err)
+	}
+
+	// so is every message, for a new model. Until then the old model's vectors aren't compared with its
+	v2 := &stubEmbedder{model: "v2", vocabulary: []string{"weather", "sunny"}}
+	if stale, err := s.UnembeddedMessages("v2", 10); err != nil || len(stale) != 2 {
+		t.Fatalf("every message should need embedding with a new model. Got %d, %v", len(stale), err)
+	}
+	if similar, err := s.RetrieveSimilar(convoId.String(), []float32{1, 0}, "v2", 5); err != nil || len(similar) != 0 {
+		t.Fatalf("vectors from another model shouldn't be compared. Got %+v, %v", similar, err)
+	}
+	if n, err := EmbedStale(context.Background(), s, v2); err != nil || n != 2 {
+		t.Fatalf("both messages should be embedded with the new model. Got %d, %v", n, err)
+	}
+	if stale, err := s.UnembeddedMessages("v1", 10); err != nil || len(stale) != 2 {
+		t.Fatalf("a message has one embedding, so they're v2's now. Got %d, %v", len(stale), err)
+	}
+}
+
+func TestSaveEmbedding_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.SaveEmbedding(structs.MessageEmbedding{MessageId: uuid.New(), Model: "v1", Vector: []float32{1}}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"chat-history/structs"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) DeleteEmptyConversations(olderThan time.Duration) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-olderThan)
+	var deleted int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// deleted messages count, the conversation isn't empty if it had some
+		messages := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&structs.Message{}).Select("conversation_id")
+		tagged := tx.Session(&gorm.Session{NewDB: true}).Model(&structs.ConversationTag{}).Select("conversation_id")
+		var empty []uuid.UUID
+		err := tx.Model(&structs.Conversation{}).
+			Where("created_at <= ? AND pinned_at IS NULL", cutoff).
+			Where("conversation_id NOT IN (?) AND conversation_id NOT IN (?)", messages, tagged).
+			Pluck("conversation_id", &empty).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range empty {
+			if err := tx.Where("conversation_id = ?", id).Delete(&idempotencyKey{}).Error; err != nil {
+				return err
+			}
+			if err := deleteConversationRows(tx, id); err != nil {
+				return err
+			}
+		}
+		deleted = int64(len(empty))
+		return nil
+	})
+	return deleted, err
+}
+
+// StartEmptyConversationSweeper deletes the conversations that have had no messages for ttl every interval, until
+// the returned stop func is called
+func StartEmptyConversationSweeper(store ConversationStore, ttl, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.DeleteEmptyConversations(ttl)
+				if err != nil {
+					slog.Error("failed to delete empty conversations", "err", err)
+				} else if n > 0 {
+					slog.Info("deleted empty conversations", "count", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"chat-history/structs"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteEmptyConversations(t *testing.T) {
+	s := newTestStore(t)
+	gdb := s.(*sqliteStore).db
+	// a conversation without messages, created age ago
+	seedEmpty := func(age time.Duration) uuid.UUID {
+		t.Helper()
+		convo := structs.Conversation{UserId: USER, ConversationId: uuid.New(), Name: "empty"}
+		convo.CreatedAt = time.Now().Add(-age)
+		if err := gdb.Create(&convo).Error; err != nil {
+			t.Fatal(err)
+		}
+		return convo.ConversationId
+	}
+	emptyOld := seedEmpty(48 * time.Hour)
+	emptyRecent := seedEmpty(time.Hour)
+	pinned := seedEmpty(48 * time.Hour)
+	if err := s.PinConversation(USER, pinned.String()); err != nil {
+		t.Fatal(err)
+	}
+	tagged := seedEmpty(48 * time.Hour)
+	if err := s.AddTag(USER, tagged.String(), "later"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateShareLink(USER, emptyOld.String(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	withMessages := seedConversation(t, s, USER)
+	gdb.Model(&structs.Conversation{}).Where("conversation_id = ?", withMessages).UpdateColumn("created_at", time.Now().Add(-48*time.Hour))
+	// one whose messages were deleted is kept too, it wasn't abandoned
+	emptied := seedEmpty(48 * time.Hour)
+	reply := structs.Message{ConversationId: emptied, MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole}
+	if err := gdb.Create(&reply).Error; err != nil {
+		t.Fatal(err)
+	}
+	gdb.Delete(&reply)
+	trashed := seedEmpty(48 * time.Hour)
+	if err := s.DeleteConversation(USER, trashed.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.DeleteEmptyConversations(24 * time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("only the old empty conversation should be deleted. Got %d, %v", n, err)
+	}
+	var left []uuid.UUID
+	gdb.Unscoped().Model(&structs.Conversation{}).Order("id").Pluck("conversation_id", &left)
+	if want := []uuid.UUID{emptyRecent, pinned, tagged, withMessages, emptied, trashed}; !slices.Equal(left, want) {
+		t.Fatalf("the conversations left should be %v. They're %v", want, left)
+	}
+	var links int64
+	gdb.Model(&structs.ShareLink{}).Where("conversation_id = ?", emptyOld).Count(&links)
+	if links != 0 {
+		t.Fatalf("the deleted conversation's share links should be deleted with it. %d are left", links)
+	}
+
+	if n, err := s.DeleteEmptyConversations(24 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("there should be nothing left to delete. Got %d, %v", n, err)
+	}
+}
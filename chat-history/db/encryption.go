@@ -0,0 +1,206 @@
+package db
+
+import (
+	"chat-history/structs"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// encrypted values start with this, so plaintext written before encryption was turned on can still be read
+const encryptedPrefix = "enc:v1:"
+
+// ErrDecrypt is returned when a message can't be decrypted with the store's key, or the store has no key
+var ErrDecrypt = errors.New("message can't be decrypted, the encryption key is missing or wrong")
+
+// sealer encrypts message contents and comments with AES-GCM. A nil sealer leaves them in plaintext
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(key []byte) (*sealer, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// seal encrypts value for the message with the id. The id is authenticated with it, so a value
+// copied to another message doesn't decrypt. Empty values are left empty
+func (c *sealer) seal(id [16]byte, value string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), id[:])
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value written by seal. Values without the prefix are plaintext and returned as is
+func (c *sealer) open(id [16]byte, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrDecrypt
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, id[:])
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// sealMessage encrypts the content and comment of m in place
+func (c *sealer) sealMessage(m *structs.Message) error {
+	var err error
+	if m.Content, err = c.seal(m.MessageId, m.Content); err != nil {
+		return err
+	}
+	m.Comment, err = c.seal(m.MessageId, m.Comment)
+	return err
+}
+
+// openMessages decrypts the content and comment of every message in place
+func (c *sealer) openMessages(messages []structs.Message) error {
+	for i := range messages {
+		m := &messages[i]
+		var err error
+		if m.Content, err = c.open(m.MessageId, m.Content); err != nil {
+			return fmt.Errorf("message %s: %w", m.MessageId, err)
+		}
+		if m.Comment, err = c.open(m.MessageId, m.Comment); err != nil {
+			return fmt.Errorf("message %s: %w", m.MessageId, err)
+		}
+	}
+	return nil
+}
+
+// Rekey re-encrypts every message (and message revision and feedback comment) in the database at dbPath from oldKey to newKey in one transaction.
+// A nil oldKey encrypts a plaintext database, and a nil newKey decrypts it back to plaintext.
+// The server must not be running. It returns the number of messages rewritten. An archive database
+// (see ArchivePath) is a separate file, rekey it with its own call
+func Rekey(dbPath, logPath string, oldKey, newKey []byte) (int64, error) {
+	from, err := newSealer(oldKey)
+	if err != nil {
+		return 0, err
+	}
+	to, err := newSealer(newKey)
+	if err != nil {
+		return 0, err
+	}
+	s, err := openSQLiteStore(dbPath, logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	var n int64
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var messages []structs.Message
+		// include messages in the trash, they're still on disk
+		if err := tx.Unscoped().Find(&messages).Error; err != nil {
+			return err
+		}
+		if err := from.openMessages(messages); err != nil {
+			return err
+		}
+		for _, m := range messages {
+			if err := to.sealMessage(&m); err != nil {
+				return err
+			}
+			// UpdateColumns so rekeying doesn't change updated_at
+			tx := tx.Unscoped().Model(&structs.Message{}).Where("id = ?", m.ID).
+				UpdateColumns(map[string]any{"content": m.Content, "comment": m.Comment})
+			if err := tx.Error; err != nil {
+				return err
+			}
+			n += tx.RowsAffected
+		}
+
+		// earlier contents of edited messages are sealed with the message's id too
+		var revisions []structs.MessageRevision
+		if err := tx.Find(&revisions).Error; err != nil {
+			return err
+		}
+		for _, r := range revisions {
+			content, err := from.open(r.MessageId, r.Content)
+			if err != nil {
+				return fmt.Errorf("revision %d of message %s: %w", r.Revision, r.MessageId, err)
+			}
+			if content, err = to.seal(r.MessageId, content); err != nil {
+				return err
+			}
+			if err := tx.Model(&structs.MessageRevision{}).Where("id = ?", r.ID).UpdateColumn("content", content).Error; err != nil {
+				return err
+			}
+		}
+
+		// and the comments of feedback
+		var feedback []structs.MessageFeedback
+		if err := tx.Where("comment <> ''").Find(&feedback).Error; err != nil {
+			return err
+		}
+		for _, f := range feedback {
+			comment, err := from.open(f.MessageId, f.Comment)
+			if err != nil {
+				return fmt.Errorf("feedback of %s on message %s: %w", f.UserId, f.MessageId, err)
+			}
+			if comment, err = to.seal(f.MessageId, comment); err != nil {
+				return err
+			}
+			if err := tx.Model(&structs.MessageFeedback{}).Where("message_id = ? AND user_id = ?", f.MessageId, f.UserId).UpdateColumn("comment", comment).Error; err != nil {
+				return err
+			}
+		}
+
+		// and what was sent to the LLM for replies
+		var exchanges []structs.LLMExchange
+		if err := tx.Find(&exchanges).Error; err != nil {
+			return err
+		}
+		for _, e := range exchanges {
+			columns := map[string]any{}
+			for column, value := range map[string]string{"request": e.Request, "response": e.Response} {
+				plain, err := from.open(e.MessageId, value)
+				if err != nil {
+					return fmt.Errorf("the LLM %s for message %s: %w", column, e.MessageId, err)
+				}
+				if columns[column], err = to.seal(e.MessageId, plain); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&structs.LLMExchange{}).Where("message_id = ?", e.MessageId).UpdateColumns(columns).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"bytes"
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{1}, 32)
+	otherKey = bytes.Repeat([]byte{2}, 32)
+)
+
+type encryptedTestDB struct {
+	path, logPath string
+}
+
+func newEncryptedTestDB(t *testing.T) encryptedTestDB {
+	tmp := t.TempDir()
+	return encryptedTestDB{path: fmt.Sprintf("%s/%s", tmp, DB_NAME), logPath: fmt.Sprintf("%s/test.log", tmp)}
+}
+
+func (d encryptedTestDB) open(t *testing.T, key []byte) *sqliteStore {
+	t.Helper()
+	s, err := openSQLiteStore(d.path, d.logPath, EncryptionKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// rawContent is the content column of the message as it's stored on disk
+func rawContent(t *testing.T, s *sqliteStore, messageId uuid.UUID) string {
+	t.Helper()
+	var content string
+	if err := s.db.Raw("SELECT content FROM messages WHERE message_id = ?", messageId).Scan(&content).Error; err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, testKey)
+
+	convoId := uuid.New()
+	msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "my account number is 1234", Role: structs.UserRole}
+	if _, err := s.CreateConversation(USER, "convo", msg); err != nil {
+		t.Fatal(err)
+	}
+	// feedback on an existing message encrypts the comment too
+	msg.Feedback, msg.Comment = structs.ThumbsDown, "wrong account"
+	if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := rawContent(t, s, msg.MessageId)
+	if !strings.HasPrefix(raw, encryptedPrefix) || strings.Contains(raw, "1234") {
+		t.Fatalf("content should be encrypted on disk. It's: %s", raw)
+	}
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Content != msg.Content || messages[0].Comment != "wrong account" {
+		t.Fatalf("messages should be decrypted when read: %+v", messages)
+	}
+
+	results, err := s.SearchMessages(USER, "ACCOUNT number")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].MessageId != msg.MessageId {
+		t.Fatalf("encrypted messages should still be searchable: %+v", results)
+	}
+}
+
+func TestEncryption_PlaintextStillReadable(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	plain := d.open(t, nil)
+	convoId := seedConversation(t, plain, USER)
+	plain.Close()
+
+	// turning encryption on doesn't break messages written before it
+	s := d.open(t, testKey)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Content != "Hello, world" {
+		t.Fatalf("plaintext messages should be read as is: %+v", messages)
+	}
+}
+
+func TestEncryption_WrongKey(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, testKey)
+	convoId := seedConversation(t, s, USER)
+	s.Close()
+
+	for name, key := range map[string][]byte{"wrong key": otherKey, "no key": nil} {
+		t.Run(name, func(t *testing.T) {
+			s := d.open(t, key)
+			if _, err := s.GetConversation(USER, convoId.String()); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("reading with the wrong key should return ErrDecrypt. It's: %v", err)
+			}
+			if _, err := s.GetAllMessages(); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("reading with the wrong key should return ErrDecrypt. It's: %v", err)
+			}
+			s.Close()
+		})
+	}
+}
+
+func TestEncryption_InvalidKey(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	if _, err := openSQLiteStore(d.path, d.logPath, EncryptionKey([]byte("too short"))); err == nil {
+		t.Fatal("a key that isn't 16, 24 or 32 bytes should fail to open the store")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, nil)
+	convoId := seedConversation(t, s, USER)
+	s.Close()
+
+	// plaintext -> testKey -> otherKey
+	for _, keys := range [][2][]byte{{nil, testKey}, {testKey, otherKey}} {
+		n, err := Rekey(d.path, d.logPath, keys[0], keys[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("1 message should be rekeyed. It was: %d", n)
+		}
+	}
+
+	s = d.open(t, otherKey)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Content != "Hello, world" {
+		t.Fatalf("messages should be readable with the new key: %+v", messages)
+	}
+	s.Close()
+
+	s = d.open(t, testKey)
+	if _, err := s.GetConversation(USER, convoId.String()); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("the old key shouldn't work after rekeying. Got: %v", err)
+	}
+	s.Close()
+
+	// rekeying with the wrong old key changes nothing
+	if _, err := Rekey(d.path, d.logPath, testKey, nil); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("rekeying with the wrong old key should return ErrDecrypt. It's: %v", err)
+	}
+	s = d.open(t, otherKey)
+	if _, err := s.GetConversation(USER, convoId.String()); err != nil {
+		t.Fatalf("a failed rekey should leave the messages as they were: %v", err)
+	}
+}
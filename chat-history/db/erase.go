@@ -0,0 +1,70 @@
+package db
+
+import (
+	"chat-history/structs"
+
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) DeleteAllForUser(userId string) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for _, from := range []*gorm.DB{s.db, s.archive} {
+		if from == nil {
+			continue
+		}
+		err := from.Transaction(func(tx *gorm.DB) error {
+			n, err := deleteUserRows(tx, userId)
+			deleted += n
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteUserRows permanently deletes the user's conversations, including the ones in the trash, and
+// everything that belongs to them. It returns how many conversations were deleted
+func deleteUserRows(tx *gorm.DB, userId string) (int64, error) {
+	convoIds := tx.Unscoped().Model(&structs.Conversation{}).Select("conversation_id").Where("user_id = ?", userId)
+	messageIds := tx.Unscoped().Model(&structs.Message{}).Select("message_id").Where("conversation_id IN (?)", convoIds)
+	for _, model := range []any{&structs.MessageRevision{}, &structs.Attachment{}, &structs.MessageEmbedding{}, &structs.MessageContext{}, &structs.MessageFeedback{}, &structs.LLMExchange{}} {
+		if err := tx.Where("message_id IN (?)", messageIds).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+	for _, model := range []any{&structs.Message{}, &structs.ConversationTag{}, &structs.ShareLink{}, &structs.ConversationAccess{}, &structs.ReadMarker{}, &structs.ConversationEvent{}} {
+		if err := tx.Unscoped().Where("conversation_id IN (?)", convoIds).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+	// and what other users granted them
+	if err := tx.Where("user_id = ?", userId).Delete(&structs.ConversationAccess{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&structs.ReadMarker{}).Error; err != nil {
+		return 0, err
+	}
+	// the timelines of other users' conversations that name them
+	if err := tx.Where("user_id = ? OR (type = ? AND detail = ?)", userId, EventConversationShared, userId).Delete(&structs.ConversationEvent{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&structs.MessageFeedback{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&idempotencyKey{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&structs.Template{}).Error; err != nil {
+		return 0, err
+	}
+	res := tx.Unscoped().Where("user_id = ?", userId).Delete(&structs.Conversation{})
+	return res.RowsAffected, res.Error
+}
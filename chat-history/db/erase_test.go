@@ -0,0 +1,132 @@
+package db
+
+import (
+	"chat-history/structs"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// userRows counts the user's rows in every table of db
+func userRows(t *testing.T, db *gorm.DB, userId string) map[string]int64 {
+	t.Helper()
+	convoIds := db.Unscoped().Model(&structs.Conversation{}).Select("conversation_id").Where("user_id = ?", userId)
+	messageIds := db.Unscoped().Model(&structs.Message{}).Select("message_id").Where("conversation_id IN (?)", convoIds)
+	counts := map[string]int64{}
+	for name, q := range map[string]*gorm.DB{
+		"conversations": db.Unscoped().Model(&structs.Conversation{}).Where("user_id = ?", userId),
+		"messages":      db.Unscoped().Model(&structs.Message{}).Where("conversation_id IN (?)", convoIds),
+		"revisions":     db.Model(&structs.MessageRevision{}).Where("message_id IN (?)", messageIds),
+		"attachments":   db.Model(&structs.Attachment{}).Where("message_id IN (?)", messageIds),
+		"embeddings":    db.Model(&structs.MessageEmbedding{}).Where("message_id IN (?)", messageIds),
+		"contexts":      db.Model(&structs.MessageContext{}).Where("message_id IN (?)", messageIds),
+		"tags":          db.Model(&structs.ConversationTag{}).Where("conversation_id IN (?)", convoIds),
+		"share links":   db.Model(&structs.ShareLink{}).Where("conversation_id IN (?) OR user_id = ?", convoIds, userId),
+		"access":        db.Model(&structs.ConversationAccess{}).Where("conversation_id IN (?) OR user_id = ?", convoIds, userId),
+		"read markers":  db.Model(&structs.ReadMarker{}).Where("conversation_id IN (?) OR user_id = ?", convoIds, userId),
+		"feedback":      db.Model(&structs.MessageFeedback{}).Where("message_id IN (?) OR user_id = ?", messageIds, userId),
+		"llm exchanges": db.Model(&structs.LLMExchange{}).Where("message_id IN (?)", messageIds),
+		"events":        db.Model(&structs.ConversationEvent{}).Where("conversation_id IN (?) OR user_id = ?", convoIds, userId),
+		"keys":          db.Model(&idempotencyKey{}).Where("user_id = ?", userId),
+		"templates":     db.Model(&structs.Template{}).Where("user_id = ?", userId),
+	} {
+		var n int64
+		if err := q.Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		counts[name] = n
+	}
+	return counts
+}
+
+// seedEverything gives the user a conversation with a row in every table
+func seedEverything(t *testing.T, s ConversationStore, userId string) string {
+	t.Helper()
+	id := seedConversation(t, s, userId)
+	convoId, messageId := id.String(), firstMessage(t, s, userId, id)
+	if _, err := s.EditMessage(userId, convoId, messageId, "edited", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAttachment(userId, convoId, messageId, testAttachment("graph.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEmbedding(structs.MessageEmbedding{MessageId: uuid.MustParse(messageId), Model: "embed", Vector: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag(userId, convoId, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead(userId, convoId, messageId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateShareLink(userId, convoId, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GrantAccess(userId, convoId, structs.ConversationAccess{UserId: "Mr_Nobody", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	reply := appendWithContext(t, s, id)
+	if _, err := s.SetFeedback(userId, convoId, reply.String(), structs.ThumbsUp, "helpful"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveLLMExchange(structs.LLMExchange{MessageId: reply, ConversationId: id, Request: "{}", Response: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	return convoId
+}
+
+func TestDeleteAllForUser(t *testing.T) {
+	s := newArchiveStore(t).(*sqliteStore)
+	seedEverything(t, s, USER)
+	if err := s.DeleteConversation(USER, seedEverything(t, s, USER)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, seedEverything(t, s, USER)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.CreateConversationOnce(USER, "retry-1", "keyed", keyedMessage(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range []string{USER, "Miss_Take"} {
+		template := structs.Template{UserId: userId, Title: "start", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}}
+		if _, err := s.CreateTemplate(template); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedEverything(t, s, "Miss_Take")
+	others := userRows(t, s.db, "Miss_Take")
+	// and access to another user's conversation, which goes with the user
+	grantor := seedConversation(t, s, "Mr_Nobody").String()
+	if _, err := s.GrantAccess("Mr_Nobody", grantor, structs.ConversationAccess{UserId: USER, Permission: structs.PermissionWrite}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead(USER, grantor, firstMessage(t, s, "Mr_Nobody", uuid.MustParse(grantor))); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.DeleteAllForUser(USER)
+	if err != nil || n != 4 {
+		t.Fatalf("the active, trashed and archived conversations should be deleted. Got %d, %v", n, err)
+	}
+	for _, db := range []*gorm.DB{s.db, s.archive} {
+		for table, n := range userRows(t, db, USER) {
+			if n != 0 {
+				t.Fatalf("all of the user's %s should be deleted. There are %d left", table, n)
+			}
+		}
+	}
+	if got := userRows(t, s.db, "Miss_Take"); !maps.Equal(got, others) {
+		t.Fatalf("other users' data should be left alone. It was %v, it's %v", others, got)
+	}
+
+	// running it again does nothing
+	if n, err := s.DeleteAllForUser(USER); err != nil || n != 0 {
+		t.Fatalf("there should be nothing left to delete. Got %d, %v", n, err)
+	}
+	if got := userRows(t, s.db, "Miss_Take"); !maps.Equal(got, others) {
+		t.Fatalf("other users' data should be left alone. It was %v, it's %v", others, got)
+	}
+}
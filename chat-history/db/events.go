@@ -0,0 +1,103 @@
+package db
+
+import (
+	"chat-history/structs"
+
+	"gorm.io/gorm"
+)
+
+// the types of Event and of the structs.ConversationEvent of a conversation's timeline. Notify is only
+// called with the first two
+const (
+	EventConversationCreated = "conversation.created"
+	EventMessageAppended     = "message.appended"
+	EventConversationRenamed = "conversation.renamed"
+	EventConversationShared  = "conversation.shared"
+)
+
+// Event is a change to a conversation, as Notify passes it
+type Event struct {
+	Type         string
+	Conversation structs.Conversation
+	// the message that was added. For EventConversationCreated it's the conversation's first message
+	Message structs.Message
+}
+
+// emit tells whoever is listening about the change. message is as it was given, before it was encrypted,
+// and stored is as it was written, with its id and timestamps
+func (s *sqliteStore) emit(eventType string, convo structs.Conversation, message, stored structs.Message) {
+	if s.notify == nil {
+		return
+	}
+	message.Model = stored.Model
+	message.ConversationId = stored.ConversationId
+	s.notify(Event{Type: eventType, Conversation: convo, Message: message})
+}
+
+// appendEvent adds the events to the timelines of their conversations, in tx so they're only there if the change is
+func appendEvent(tx *gorm.DB, events ...structs.ConversationEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(events, importBatchSize).Error
+}
+
+// createdEvent is the timeline's entry for convo being created by its owner, with message as its first message
+func createdEvent(convo structs.Conversation, message structs.Message) structs.ConversationEvent {
+	return structs.ConversationEvent{Type: EventConversationCreated, ConversationId: convo.ConversationId, UserId: convo.UserId, MessageId: &message.MessageId}
+}
+
+// appendedEvent is the timeline's entry for message being added to a conversation of ownerId. The owner added
+// the user's messages, the others are the LLM's replies and the like, which the service adds
+func appendedEvent(ownerId string, message structs.Message) structs.ConversationEvent {
+	event := structs.ConversationEvent{Type: EventMessageAppended, ConversationId: message.ConversationId, MessageId: &message.MessageId}
+	if message.Role == structs.UserRole {
+		event.UserId = ownerId
+	}
+	return event
+}
+
+// importedEvents are the timeline's entries for messages being imported into convo, the first one creating it if created
+func importedEvents(convo structs.Conversation, created bool, messages []structs.Message) []structs.ConversationEvent {
+	events := make([]structs.ConversationEvent, 0, len(messages))
+	for i, m := range messages {
+		if i == 0 && created {
+			events = append(events, createdEvent(convo, m))
+			continue
+		}
+		events = append(events, appendedEvent(convo.UserId, m))
+	}
+	return events
+}
+
+func (s *sqliteStore) ListEvents(userId, conversationId string, limit int, cursorToken string) ([]structs.ConversationEvent, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, "", err
+	}
+	tx := s.db.Where("conversation_id = ?", convoId).Order("id")
+	if cursorToken != "" {
+		c, err := decodeCursor(cursorToken)
+		if err != nil {
+			return nil, "", err
+		}
+		tx = tx.Where("id > ?", c.ID)
+	}
+	if limit > 0 {
+		// one more, to know if there's a next page
+		tx = tx.Limit(limit + 1)
+	}
+	events := []structs.ConversationEvent{}
+	if err := tx.Find(&events).Error; err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+		next = encodeCursor(cursor{ID: events[len(events)-1].ID})
+	}
+	return events, next, nil
+}
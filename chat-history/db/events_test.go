@@ -0,0 +1,144 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNotify(t *testing.T) {
+	var events []Event
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp),
+		EncryptionKey([]byte("0123456789abcdef")), Notify(func(ev Event) { events = append(events, ev) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	convoId := seedConversation(t, s, USER)
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hi!", Role: structs.SystemRole}
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	// feedback on a message isn't a new message
+	reply.Feedback = structs.ThumbsUp
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	// neither is a retry that gets the conversation the first request created
+	for range 2 {
+		if _, _, err := s.CreateConversationOnce(USER, "key", "keyed", keyedMessage(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// or a failed write
+	s.AppendMessage(structs.Message{ConversationId: convoId, MessageId: uuid.New(), Role: structs.UserRole}, AnyVersion)
+
+	if len(events) != 3 {
+		t.Fatalf("there should be an event for each conversation created and message appended. Got %+v", events)
+	}
+	created, appended, keyed := events[0], events[1], events[2]
+	if created.Type != EventConversationCreated || created.Conversation.ConversationId != convoId || created.Conversation.UserId != USER ||
+		created.Message.Content != "Hello, world" || created.Message.ID == 0 {
+		t.Fatalf("the first event should be the conversation with its first message, decrypted: %+v", created)
+	}
+	if appended.Type != EventMessageAppended || appended.Message.MessageId != reply.MessageId || appended.Message.Content != "Hi!" ||
+		appended.Conversation.Version != 1 {
+		t.Fatalf("the second event should be the reply, with the conversation as it is after it: %+v", appended)
+	}
+	if keyed.Type != EventConversationCreated || keyed.Conversation.Name != "keyed" {
+		t.Fatalf("the third event should be the conversation created with the key: %+v", keyed)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	s := newTestStore(t)
+
+	// a conversation is created, answered, named, followed up on, shared with a link and with another user
+	first := newMessage()
+	convo, err := s.CreateConversation(USER, "convo", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := convo.ConversationId.String()
+	reply := structs.Message{ConversationId: convo.ConversationId, MessageId: uuid.New(), ParentId: &first.MessageId, Content: "Hi!", Role: structs.SystemRole}
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	// feedback isn't a new message
+	reply.Feedback = structs.ThumbsUp
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameConversation(convoId, "Greetings"); err != nil {
+		t.Fatal(err)
+	}
+	followUp := structs.Message{ConversationId: convo.ConversationId, MessageId: uuid.New(), ParentId: &reply.MessageId, Content: "Bye", Role: structs.UserRole}
+	if _, err := s.AppendMessage(followUp, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	link, err := s.CreateShareLink(USER, convoId, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GrantAccess(USER, convoId, structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead, GrantedBy: USER}); err != nil {
+		t.Fatal(err)
+	}
+	// and what fails isn't recorded
+	s.AppendMessage(structs.Message{ConversationId: convo.ConversationId, MessageId: uuid.New(), Role: structs.UserRole}, AnyVersion)
+
+	events, next, err := s.ListEvents(USER, convoId, 0, "")
+	if err != nil || next != "" {
+		t.Fatalf("the whole timeline should be one page. Got %q, %v", next, err)
+	}
+	want := []structs.ConversationEvent{
+		{Type: EventConversationCreated, UserId: USER, MessageId: &first.MessageId},
+		{Type: EventMessageAppended, MessageId: &reply.MessageId},
+		{Type: EventConversationRenamed, Detail: "Greetings"},
+		{Type: EventMessageAppended, UserId: USER, MessageId: &followUp.MessageId},
+		{Type: EventConversationShared, UserId: USER, Detail: link.ShareId.String()},
+		{Type: EventConversationShared, UserId: USER, Detail: "Miss_Take"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("there should be %d events. Got %+v", len(want), events)
+	}
+	for i, e := range events {
+		w := want[i]
+		if e.Type != w.Type || e.UserId != w.UserId || e.Detail != w.Detail || e.ConversationId != convo.ConversationId ||
+			(w.MessageId == nil) != (e.MessageId == nil) || (w.MessageId != nil && *e.MessageId != *w.MessageId) || e.CreatedAt.IsZero() {
+			t.Fatalf("event %d should be %+v. Got %+v", i, w, e)
+		}
+	}
+
+	// a page at a time
+	var paged []structs.ConversationEvent
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, next, err := s.ListEvents(USER, convoId, 4, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, page...)
+		if next == "" {
+			if pages != 1 {
+				t.Fatalf("6 events should be 2 pages of 4, got %d", pages+1)
+			}
+			break
+		}
+		cursor = next
+	}
+	if len(paged) != len(events) || paged[0].ID != events[0].ID || paged[5].ID != events[5].ID {
+		t.Fatalf("the pages should be the timeline in order. Got %+v", paged)
+	}
+	if _, _, err := s.ListEvents(USER, convoId, 4, "not a cursor"); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("an invalid cursor should be ErrInvalidCursor, got %v", err)
+	}
+	if _, _, err := s.ListEvents("Miss_Take", convoId, 0, ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("only the owner should get the timeline, got %v", err)
+	}
+}
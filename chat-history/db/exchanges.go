@@ -0,0 +1,89 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (s *sqliteStore) SaveLLMExchange(exchange structs.LLMExchange) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if exchange.Request, err = s.sealer.seal(exchange.MessageId, exchange.Request); err != nil {
+		return err
+	}
+	if exchange.Response, err = s.sealer.seal(exchange.MessageId, exchange.Response); err != nil {
+		return err
+	}
+	exchange.CreatedAt = s.now()
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&exchange).Error
+}
+
+func (s *sqliteStore) GetLLMExchange(messageId string) (*structs.LLMExchange, error) {
+	id, err := uuid.Parse(messageId)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exchange := structs.LLMExchange{}
+	if err := s.db.Where("message_id = ?", id).First(&exchange).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if exchange.Request, err = s.sealer.open(exchange.MessageId, exchange.Request); err != nil {
+		return nil, fmt.Errorf("the request for message %s: %w", exchange.MessageId, err)
+	}
+	if exchange.Response, err = s.sealer.open(exchange.MessageId, exchange.Response); err != nil {
+		return nil, fmt.Errorf("the response for message %s: %w", exchange.MessageId, err)
+	}
+	return &exchange, nil
+}
+
+func (s *sqliteStore) PurgeLLMExchanges(retention time.Duration) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.db.Where("created_at <= ?", s.now().Add(-retention)).Delete(&structs.LLMExchange{})
+	return res.RowsAffected, res.Error
+}
+
+// StartLLMExchangeSweeper purges the exchanges stored more than retention ago every interval until the returned
+// stop func is called
+func StartLLMExchangeSweeper(store ConversationStore, retention, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.PurgeLLMExchanges(retention)
+				if err != nil {
+					slog.Error("failed to purge the LLM exchanges", "err", err)
+				} else if n > 0 {
+					slog.Info("purged LLM exchanges", "count", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"chat-history/clock"
+	"chat-history/structs"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLLMExchanges(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")), Clock(fake)).(*sqliteStore)
+	convoId := seedConversation(t, s, USER)
+	reply := appendWithContext(t, s, convoId)
+	request := `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello, world"}],"stream":true}`
+	response := "data: {\"choices\":[{\"delta\":{\"content\":\"Hi!\"}}]}\n\ndata: [DONE]\n\n"
+
+	if err := s.SaveLLMExchange(structs.LLMExchange{MessageId: reply, ConversationId: convoId, Provider: "openai", Model: "gpt-4o", Request: request, Response: response}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetLLMExchange(reply.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Request != request || got.Response != response || got.Provider != "openai" || got.Model != "gpt-4o" || got.ConversationId != convoId || !got.CreatedAt.Equal(fake.Now()) {
+		t.Fatalf("the exchange should be returned as it was saved. Got %+v", got)
+	}
+	// sealed like the messages
+	var stored structs.LLMExchange
+	s.db.Where("message_id = ?", reply).First(&stored)
+	if strings.Contains(stored.Request, "Hello, world") || strings.Contains(stored.Response, "Hi!") {
+		t.Fatalf("the exchange should be encrypted at rest. Got %+v", stored)
+	}
+
+	// a resumed reply replaces it
+	fake.Advance(time.Hour)
+	if err := s.SaveLLMExchange(structs.LLMExchange{MessageId: reply, ConversationId: convoId, Provider: "ollama", Model: "llama3", Request: "{}", Response: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetLLMExchange(reply.String()); err != nil || got.Provider != "ollama" || got.Request != "{}" {
+		t.Fatalf("the exchange should be replaced. Got %+v, %v", got, err)
+	}
+
+	for _, id := range []string{uuid.NewString(), "not a uuid"} {
+		if _, err := s.GetLLMExchange(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s should be ErrNotFound, got %v", id, err)
+		}
+	}
+
+	// they expire retention after they're saved
+	if n, err := s.PurgeLLMExchanges(2 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("nothing should be purged within the retention. Got %d, %v", n, err)
+	}
+	fake.Advance(2 * time.Hour)
+	if n, err := s.PurgeLLMExchanges(2 * time.Hour); err != nil || n != 1 {
+		t.Fatalf("the exchange should be purged after the retention. Got %d, %v", n, err)
+	}
+	if _, err := s.GetLLMExchange(reply.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a purged exchange should be ErrNotFound, got %v", err)
+	}
+}
+
+func TestRekey_LLMExchanges(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, nil)
+	convoId := seedConversation(t, s, USER)
+	reply := appendWithContext(t, s, convoId)
+	if err := s.SaveLLMExchange(structs.LLMExchange{MessageId: reply, ConversationId: convoId, Request: `{"messages":[]}`, Response: `{"choices":[]}`}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := Rekey(d.path, d.logPath, nil, testKey); err != nil {
+		t.Fatal(err)
+	}
+	s = d.open(t, testKey)
+	defer s.Close()
+	if got, err := s.GetLLMExchange(reply.String()); err != nil || got.Request != `{"messages":[]}` || got.Response != `{"choices":[]}` {
+		t.Fatalf("the exchange should be readable with the new key. Got %+v, %v", got, err)
+	}
+	var stored structs.LLMExchange
+	s.db.Where("message_id = ?", reply).First(&stored)
+	if !strings.HasPrefix(stored.Request, encryptedPrefix) || !strings.HasPrefix(stored.Response, encryptedPrefix) {
+		t.Fatalf("the exchange should be encrypted. It's: %+v", stored)
+	}
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"chat-history/structs"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// how many conversations ExportAll reads at a time
+const exportBatchSize = 100
+
+// ConversationData is a conversation with its tags, messages and their attachments, as ExportAll passes them
+// and ImportConversation takes them
+type ConversationData struct {
+	// with its Tags
+	Conversation structs.Conversation
+	// oldest first, the order the conversation happened in
+	Messages    []structs.Message
+	Attachments []structs.Attachment
+}
+
+func (s *sqliteStore) ExportAll(userId string, includeArchived bool, fn func(ConversationData) error) error {
+	if err := s.exportAll(s.db, userId, false, fn); err != nil {
+		return err
+	}
+	if includeArchived && s.archive != nil {
+		return s.exportAll(s.archive, userId, true, fn)
+	}
+	return nil
+}
+
+// exportAll calls fn with each of the user's conversations in db, a batch at a time. The lock is only
+// held while a batch is read, so a slow fn (i.e., a slow download) doesn't hold up writes
+func (s *sqliteStore) exportAll(db *gorm.DB, userId string, archived bool, fn func(ConversationData) error) error {
+	var after uint
+	for {
+		batch, last, err := s.exportBatch(db, userId, archived, after)
+		if err != nil {
+			return err
+		}
+		for _, c := range batch {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		if last == 0 {
+			return nil
+		}
+		after = last
+	}
+}
+
+// exportBatch reads the next batch of the user's conversations after the id after. It returns the id to read
+// the next batch after, or 0 if this was the last one
+func (s *sqliteStore) exportBatch(db *gorm.DB, userId string, archived bool, after uint) ([]ConversationData, uint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convos := []structs.Conversation{}
+	err := db.Where("user_id = ? AND id > ?", userId, after).Order("id").Limit(exportBatchSize).Find(&convos).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := loadTags(db, convos); err != nil {
+		return nil, 0, err
+	}
+
+	batch := make([]ConversationData, 0, len(convos))
+	for _, c := range convos {
+		if archived {
+			// a copy left by a move that didn't finish was already exported from the primary
+			var count int64
+			if err := s.db.Model(&structs.Conversation{}).Where("conversation_id = ?", c.ConversationId).Count(&count).Error; err != nil {
+				return nil, 0, err
+			}
+			if count > 0 {
+				continue
+			}
+			c.Archived = true
+		}
+		data, err := s.conversationData(db, c)
+		if err != nil {
+			return nil, 0, err
+		}
+		batch = append(batch, data)
+	}
+	if len(convos) < exportBatchSize {
+		return batch, 0, nil
+	}
+	return batch, convos[len(convos)-1].ID, nil
+}
+
+func (s *sqliteStore) ExportEveryone(after uuid.UUID, fn func(ConversationData) error) error {
+	for {
+		batch, next, err := s.exportEveryoneBatch(after)
+		if err != nil {
+			return err
+		}
+		for _, c := range batch {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		if next == uuid.Nil {
+			return nil
+		}
+		after = next
+	}
+}
+
+// exportEveryoneBatch reads the next batch of conversations after the id after, from both databases in the order
+// of their ids. It returns the id to read the next batch after, or uuid.Nil if this was the last one
+func (s *sqliteStore) exportEveryoneBatch(after uuid.UUID) ([]ConversationData, uuid.UUID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	batch, next, err := s.everyoneBatch(s.db, false, after)
+	if err != nil || s.archive == nil {
+		return batch, next, err
+	}
+	archived, archivedNext, err := s.everyoneBatch(s.archive, true, after)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	batch = append(batch, archived...)
+	slices.SortFunc(batch, func(a, b ConversationData) int {
+		return strings.Compare(a.Conversation.ConversationId.String(), b.Conversation.ConversationId.String())
+	})
+	// a database whose batch was full has more after its last conversation, that can come before the other's
+	// last. The batch ends at the first of the two
+	if next == uuid.Nil || archivedNext != uuid.Nil && archivedNext.String() < next.String() {
+		next = archivedNext
+	}
+	if next != uuid.Nil {
+		end, _ := slices.BinarySearchFunc(batch, next.String(), func(c ConversationData, id string) int {
+			return strings.Compare(c.Conversation.ConversationId.String(), id)
+		})
+		if end < len(batch) && batch[end].Conversation.ConversationId == next {
+			end++
+		}
+		batch = batch[:end]
+	}
+	return batch, next, nil
+}
+
+// everyoneBatch reads the next batch of conversations in db after the id after, like exportBatch. The archive's
+// are Archived
+func (s *sqliteStore) everyoneBatch(db *gorm.DB, archived bool, after uuid.UUID) ([]ConversationData, uuid.UUID, error) {
+	convos := []structs.Conversation{}
+	err := db.Where("conversation_id > ?", after).Order("conversation_id").Limit(exportBatchSize).Find(&convos).Error
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if err := loadTags(db, convos); err != nil {
+		return nil, uuid.Nil, err
+	}
+	batch := make([]ConversationData, 0, len(convos))
+	for _, c := range convos {
+		if archived {
+			// a copy left by a move that didn't finish is exported from the primary
+			var count int64
+			if err := s.db.Model(&structs.Conversation{}).Where("conversation_id = ?", c.ConversationId).Count(&count).Error; err != nil {
+				return nil, uuid.Nil, err
+			}
+			if count > 0 {
+				continue
+			}
+			c.Archived = true
+		}
+		data, err := s.conversationData(db, c)
+		if err != nil {
+			return nil, uuid.Nil, err
+		}
+		batch = append(batch, data)
+	}
+	if len(convos) < exportBatchSize {
+		return batch, uuid.Nil, nil
+	}
+	return batch, convos[len(convos)-1].ConversationId, nil
+}
+
+func (s *sqliteStore) ExportConversation(userId, conversationId string) (*ConversationData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convos := []structs.Conversation{}
+	if err := s.db.Where("user_id = ? AND conversation_id = ?", userId, conversationId).Limit(1).Find(&convos).Error; err != nil {
+		return nil, err
+	}
+	if len(convos) == 0 {
+		return nil, ErrNotFound
+	}
+	if err := loadTags(s.db, convos); err != nil {
+		return nil, err
+	}
+	data, err := s.conversationData(s.db, convos[0])
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// conversationData reads the messages of c in db, oldest first, and their attachments
+func (s *sqliteStore) conversationData(db *gorm.DB, c structs.Conversation) (ConversationData, error) {
+	data := ConversationData{Conversation: c, Messages: []structs.Message{}, Attachments: []structs.Attachment{}}
+	if err := db.Where("conversation_id = ?", c.ConversationId).Order("created_at").Order("id").Find(&data.Messages).Error; err != nil {
+		return data, err
+	}
+	if err := s.sealer.openMessages(data.Messages); err != nil {
+		return data, err
+	}
+	messageIds := db.Model(&structs.Message{}).Select("message_id").Where("conversation_id = ?", c.ConversationId)
+	if err := db.Where("message_id IN (?)", messageIds).Order("created_at").Order("id").Find(&data.Attachments).Error; err != nil {
+		return data, err
+	}
+	return data, nil
+}
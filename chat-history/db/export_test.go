@@ -0,0 +1,143 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestExportAll(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	// more than a batch, so it takes a few reads
+	n := 2*exportBatchSize + 50
+	ids := make([]uuid.UUID, 0, n)
+	for range n {
+		ids = append(ids, seedConversation(t, s, USER))
+	}
+	seedConversation(t, s, "Miss_Take")
+	seedReplies(t, s, ids[0], 2)
+	if err := s.AddTag(USER, ids[0].String(), "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, ids[1].String()); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported []ConversationData
+	collect := func(c ConversationData) error {
+		exported = append(exported, c)
+		return nil
+	}
+	if err := s.ExportAll(USER, false, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != n-1 {
+		t.Fatalf("all of the user's conversations but the archived one should be exported. Got %d, expected %d", len(exported), n-1)
+	}
+	first := exported[0]
+	if first.Conversation.ConversationId != ids[0] || first.Conversation.UserId != USER || len(first.Conversation.Tags) != 1 {
+		t.Fatalf("conversations should be exported oldest first with their tags. The first is: %+v", first.Conversation)
+	}
+	if len(first.Messages) != 3 || first.Messages[0].Content != "Hello, world" {
+		t.Fatalf("the conversation's messages should be exported oldest first and decrypted: %+v", first.Messages)
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, c := range exported {
+		if c.Conversation.UserId != USER || c.Conversation.ConversationId == ids[1] || seen[c.Conversation.ConversationId] {
+			t.Fatalf("conversation %s shouldn't be exported, or was exported twice", c.Conversation.ConversationId)
+		}
+		seen[c.Conversation.ConversationId] = true
+	}
+
+	// with the archive, the archived conversation comes last
+	exported = nil
+	if err := s.ExportAll(USER, true, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != n {
+		t.Fatalf("all of the user's conversations should be exported. Got %d, expected %d", len(exported), n)
+	}
+	if last := exported[n-1]; last.Conversation.ConversationId != ids[1] || !last.Conversation.Archived || len(last.Messages) != 1 {
+		t.Fatalf("the archived conversation should be exported last with its messages: %+v", last)
+	}
+
+	// an error from fn stops the export
+	stop := errors.New("stop")
+	calls := 0
+	err := s.ExportAll(USER, true, func(ConversationData) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("the export should stop at fn's error. Got %v after %d calls", err, calls)
+	}
+}
+
+func TestExportAll_Empty(t *testing.T) {
+	s := newTestStore(t)
+	seedConversation(t, s, "Miss_Take")
+	err := s.ExportAll(USER, true, func(c ConversationData) error {
+		t.Fatalf("nothing should be exported for a user without conversations. Got: %+v", c.Conversation)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportEveryone(t *testing.T) {
+	s := newArchiveStore(t)
+	// more than a batch, of a few users, with some of each user's in the archive
+	n := exportBatchSize + 20
+	var ids []string
+	archived := map[string]bool{}
+	for i := range n {
+		user := []string{USER, "Miss_Take", "new_hire"}[i%3]
+		id := seedConversation(t, s, user).String()
+		ids = append(ids, id)
+		if i%4 == 1 {
+			if err := s.ArchiveConversation(user, id); err != nil {
+				t.Fatal(err)
+			}
+			archived[id] = true
+		}
+	}
+	if err := s.DeleteConversation(USER, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	live := slices.Clone(ids[1:])
+	slices.Sort(live)
+
+	export := func(after uuid.UUID) []string {
+		t.Helper()
+		var got []string
+		err := s.ExportEveryone(after, func(c ConversationData) error {
+			if len(c.Messages) != 1 || c.Messages[0].Content != "Hello, world" {
+				t.Fatalf("the conversation should be exported with its messages: %+v", c)
+			}
+			id := c.Conversation.ConversationId.String()
+			if c.Conversation.Archived != archived[id] {
+				t.Fatalf("the conversations in the archive should be exported Archived: %+v", c.Conversation)
+			}
+			got = append(got, id)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := export(uuid.Nil); !slices.Equal(got, live) {
+		t.Fatalf("every conversation but the one in the trash, archived or not, should be exported in the order of their ids.\nGot:  %v\nWant: %v", got, live)
+	}
+	// carrying on after one of them exports the ones after it
+	after := live[exportBatchSize-1]
+	if got := export(uuid.MustParse(after)); !slices.Equal(got, live[exportBatchSize:]) {
+		t.Fatalf("the conversations after %s should be exported. Got %v", after, got)
+	}
+	if got := export(uuid.MustParse(live[len(live)-1])); len(got) != 0 {
+		t.Fatalf("nothing should be exported after the last one. Got %v", got)
+	}
+}
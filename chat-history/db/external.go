@@ -0,0 +1,121 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxExternalIdLen is the longest external id a conversation can have
+const maxExternalIdLen = 255
+
+var (
+	ErrInvalidExternalId = errors.New("external ids must be 1 to 255 characters")
+	// ErrExternalIdTaken is returned when the user's conversation with an external id can't be returned
+	ErrExternalIdTaken = errors.New("the conversation with the external id is in the trash or the archive, restore or purge it first")
+)
+
+func (s *sqliteStore) CreateConversationWithExternalId(userId, externalId, name string, message structs.Message) (*structs.Conversation, bool, error) {
+	if s.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	if strings.TrimSpace(externalId) == "" || len(externalId) > maxExternalIdLen {
+		return nil, false, ErrInvalidExternalId
+	}
+	if err := s.validateMessage(message); err != nil {
+		return nil, false, err
+	}
+	content, err := s.checkContent(message.ConversationId.String(), message.MessageId.String(), message.Content)
+	if err != nil {
+		return nil, false, err
+	}
+	message.Content = content
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var convo structs.Conversation
+	var plain structs.Message
+	created := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// the index on external ids has the conversations in the trash too, they can't be created again
+		res := tx.Unscoped().Where("user_id = ? AND external_id = ?", userId, externalId).Limit(1).Find(&convo)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			if convo.DeletedAt.Valid {
+				return fmt.Errorf("%w: %s", ErrExternalIdTaken, convo.ConversationId)
+			}
+			return nil
+		}
+		if s.archive != nil {
+			archived := structs.Conversation{}
+			res := s.archive.Where("user_id = ? AND external_id = ?", userId, externalId).Limit(1).Find(&archived)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				return fmt.Errorf("%w: %s", ErrExternalIdTaken, archived.ConversationId)
+			}
+		}
+
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+		if message.ConversationId == uuid.Nil {
+			id, err := s.ids.NewID()
+			if err != nil {
+				return err
+			}
+			message.ConversationId = id
+		}
+		message.Pinned = false
+		message.Incomplete = false
+		detectLanguage(&message)
+		s.countTokens(&message)
+		plain = message
+		if err := s.sealer.sealMessage(&message); err != nil {
+			return err
+		}
+		convo = structs.Conversation{UserId: userId, ConversationId: message.ConversationId, Name: name, Language: message.Language, ExternalId: externalId, TokenCount: int64(message.TokenCount)}
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, createdEvent(convo, message)); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		s.emit(EventConversationCreated, convo, plain, message)
+	}
+	return &convo, created, nil
+}
+
+func (s *sqliteStore) FindConversationByExternalId(userId, externalId string) (*structs.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if externalId == "" {
+		return nil, ErrNotFound
+	}
+	convo := structs.Conversation{}
+	tx := s.db.Where("user_id = ? AND external_id = ?", userId, externalId).First(&convo)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &convo, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateConversationWithExternalId(t *testing.T) {
+	s := newTestStore(t)
+
+	first, created, err := s.CreateConversationWithExternalId(USER, "crm-1", "synced", keyedMessage())
+	if err != nil || !created || first.ExternalId != "crm-1" {
+		t.Fatalf("the first sync should create a conversation with the external id. Got %+v, %v, %v", first, created, err)
+	}
+	found, err := s.FindConversationByExternalId(USER, "crm-1")
+	if err != nil || found.ConversationId != first.ConversationId {
+		t.Fatalf("the conversation should be found by its external id. Got %+v, %v", found, err)
+	}
+
+	// syncing it again gets the same conversation, without the message
+	again, created, err := s.CreateConversationWithExternalId(USER, "crm-1", "synced again", keyedMessage())
+	if err != nil || created || again.ConversationId != first.ConversationId || again.Name != "synced" {
+		t.Fatalf("a re-sync should return conversation %s. Got %+v, %v, %v", first.ConversationId, again, created, err)
+	}
+	if messages, _ := s.GetConversation(USER, first.ConversationId.String()); len(messages) != 1 {
+		t.Fatalf("a re-sync shouldn't add its message. Got %d messages", len(messages))
+	}
+
+	// another id is another conversation, and other users have their own ids
+	other, created, err := s.CreateConversationWithExternalId(USER, "crm-2", "synced", keyedMessage())
+	if err != nil || !created || other.ConversationId == first.ConversationId {
+		t.Fatalf("a different external id should create a new conversation. Got %+v, %v, %v", other, created, err)
+	}
+	theirs, created, err := s.CreateConversationWithExternalId("Miss_Take", "crm-1", "synced", keyedMessage())
+	if err != nil || !created || theirs.ConversationId == first.ConversationId {
+		t.Fatalf("another user's external id should be their own. Got %+v, %v, %v", theirs, created, err)
+	}
+	// conversations created without one don't have one
+	plain := seedConversation(t, s, USER)
+	if c, _ := s.FindConversation(plain.String()); c.ExternalId != "" {
+		t.Fatalf("a conversation that isn't synced shouldn't have an external id: %+v", c)
+	}
+	if _, err := s.FindConversationByExternalId(USER, ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("an empty external id shouldn't find anything. Got %v", err)
+	}
+	if _, err := s.FindConversationByExternalId("Mr_Nobody", "crm-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users' external ids shouldn't be found. Got %v", err)
+	}
+
+	for _, id := range []string{"", " ", strings.Repeat("x", 256)} {
+		if _, _, err := s.CreateConversationWithExternalId(USER, id, "synced", keyedMessage()); !errors.Is(err, ErrInvalidExternalId) {
+			t.Fatalf("external id %q should be invalid. Got %v", id, err)
+		}
+	}
+}
+
+func TestCreateConversationWithExternalId_Trash(t *testing.T) {
+	s := newArchiveStore(t)
+	trashed, _, err := s.CreateConversationWithExternalId(USER, "crm-1", "synced", keyedMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived, _, err := s.CreateConversationWithExternalId(USER, "crm-2", "synced", keyedMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteConversation(USER, trashed.ConversationId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, archived.ConversationId.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// syncing them again doesn't duplicate them
+	for _, id := range []string{"crm-1", "crm-2"} {
+		if _, _, err := s.CreateConversationWithExternalId(USER, id, "synced", keyedMessage()); !errors.Is(err, ErrExternalIdTaken) {
+			t.Fatalf("%s: a conversation in the trash or the archive can't be synced again. Got %v", id, err)
+		}
+		if _, err := s.FindConversationByExternalId(USER, id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: only the conversations the user has should be found. Got %v", id, err)
+		}
+	}
+
+	// once it's restored, it's returned
+	if err := s.RestoreConversation(USER, trashed.ConversationId.String(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if again, created, err := s.CreateConversationWithExternalId(USER, "crm-1", "synced", keyedMessage()); err != nil || created || again.ConversationId != trashed.ConversationId {
+		t.Fatalf("a restored conversation should be returned. Got %+v, %v, %v", again, created, err)
+	}
+}
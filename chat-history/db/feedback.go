@@ -0,0 +1,140 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidFeedback is returned, wrapped with what's wrong, when feedback can't be set
+var ErrInvalidFeedback = errors.New("invalid feedback")
+
+func (s *sqliteStore) SetFeedback(userId, conversationId, messageId string, rating structs.Feedback, comment string) (*structs.MessageFeedback, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if rating != structs.ThumbsUp && rating != structs.ThumbsDown {
+		return nil, fmt.Errorf("%w: the rating must be %d (thumbs up) or %d (thumbs down)", ErrInvalidFeedback, structs.ThumbsUp, structs.ThumbsDown)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, err := s.conversationMessage(conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.Role == structs.UserRole {
+		return nil, fmt.Errorf("%w: only answers can be rated, not the user's messages", ErrInvalidFeedback)
+	}
+
+	now := s.now()
+	feedback := structs.MessageFeedback{
+		MessageId:      message.MessageId,
+		UserId:         userId,
+		ConversationId: message.ConversationId,
+		Rating:         rating,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	// sealed with the message's id like its own comment
+	if feedback.Comment, err = s.sealer.seal(message.MessageId, comment); err != nil {
+		return nil, err
+	}
+	err = s.db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"rating", "comment", "updated_at"})}).
+		Create(&feedback).Error
+	if err != nil {
+		return nil, err
+	}
+	// read back for the create_ts of the first rating
+	return s.feedback(userId, message.MessageId)
+}
+
+func (s *sqliteStore) GetFeedback(userId, conversationId, messageId string) (*structs.MessageFeedback, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, err := s.conversationMessage(conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	return s.feedback(userId, message.MessageId)
+}
+
+func (s *sqliteStore) AggregateFeedback(since time.Time) ([]structs.FeedbackSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// the feedback on answers of conversations that aren't in the trash, in the order the answers were written
+	var rows []struct {
+		structs.MessageFeedback
+		ModelName string
+	}
+	tx := s.db.Model(&structs.MessageFeedback{}).Select("message_feedback.*, messages.model_name").
+		Joins("JOIN messages ON messages.message_id = message_feedback.message_id AND messages.deleted_at IS NULL").
+		Joins("JOIN conversations ON conversations.conversation_id = message_feedback.conversation_id AND conversations.deleted_at IS NULL").
+		Order("messages.id, message_feedback.created_at")
+	if !since.IsZero() {
+		// every rating of the answers rated since, so their counts are complete
+		tx = tx.Where("message_feedback.message_id IN (?)", s.db.Model(&structs.MessageFeedback{}).Select("message_id").Where("updated_at >= ?", since))
+	}
+	if err := tx.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summaries := []structs.FeedbackSummary{}
+	for _, row := range rows {
+		if len(summaries) == 0 || summaries[len(summaries)-1].MessageId != row.MessageId {
+			summaries = append(summaries, structs.FeedbackSummary{MessageId: row.MessageId, ConversationId: row.ConversationId, ModelName: row.ModelName, Comments: []string{}})
+		}
+		summary := &summaries[len(summaries)-1]
+		switch row.Rating {
+		case structs.ThumbsUp:
+			summary.ThumbsUp++
+		case structs.ThumbsDown:
+			summary.ThumbsDown++
+		}
+		comment, err := s.sealer.open(row.MessageId, row.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("feedback on message %s: %w", row.MessageId, err)
+		}
+		if comment != "" {
+			summary.Comments = append(summary.Comments, comment)
+		}
+	}
+	return summaries, nil
+}
+
+// conversationMessage returns the message of the conversation, or ErrNotFound if it doesn't have it or it's in the trash
+func (s *sqliteStore) conversationMessage(conversationId, messageId string) (*structs.Message, error) {
+	message := structs.Message{}
+	tx := s.db.Where("conversation_id = ? AND message_id = ?", conversationId, messageId).
+		Where("EXISTS (?)", s.db.Model(&structs.Conversation{}).Select("1").Where("conversation_id = ?", conversationId)).
+		First(&message)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &message, nil
+}
+
+// feedback returns the user's feedback on the message with its comment decrypted, or ErrNotFound if they have none
+func (s *sqliteStore) feedback(userId string, messageId uuid.UUID) (*structs.MessageFeedback, error) {
+	feedback := structs.MessageFeedback{}
+	tx := s.db.Where("message_id = ? AND user_id = ?", messageId, userId).First(&feedback)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	var err error
+	if feedback.Comment, err = s.sealer.open(messageId, feedback.Comment); err != nil {
+		return nil, err
+	}
+	return &feedback, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetFeedback(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey(testKey))
+	convoId := seedConversation(t, s, USER)
+	first := firstMessage(t, s, USER, convoId)
+	reply := appendWithContext(t, s, convoId).String()
+
+	set, err := s.SetFeedback(USER, convoId.String(), reply, structs.ThumbsDown, "missed the edge types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Rating != structs.ThumbsDown || set.Comment != "missed the edge types" || set.ConversationId != convoId {
+		t.Fatalf("the feedback should be returned as it was set. Got: %+v", set)
+	}
+	got, err := s.GetFeedback(USER, convoId.String(), reply)
+	if err != nil || !reflect.DeepEqual(got, set) {
+		t.Fatalf("the feedback should be read back as it was set. Got %+v, %v", got, err)
+	}
+	// the comment is encrypted like the messages'
+	var stored structs.MessageFeedback
+	s.(*sqliteStore).db.Where("message_id = ?", reply).First(&stored)
+	if !strings.HasPrefix(stored.Comment, encryptedPrefix) {
+		t.Fatalf("the comment should be stored encrypted. It's: %q", stored.Comment)
+	}
+
+	// rating again replaces the rating and the comment, and keeps when it was first rated
+	time.Sleep(10 * time.Millisecond)
+	updated, err := s.SetFeedback(USER, convoId.String(), reply, structs.ThumbsUp, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Rating != structs.ThumbsUp || updated.Comment != "" || !updated.CreatedAt.Equal(set.CreatedAt) || !updated.UpdatedAt.After(set.UpdatedAt) {
+		t.Fatalf("the feedback should be overwritten. Got %+v, was %+v", updated, set)
+	}
+	// other users have their own
+	if _, err := s.GetFeedback("Miss_Take", convoId.String(), reply); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user shouldn't have feedback. Got: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name, message string
+		rating        structs.Feedback
+		want          error
+	}{
+		{"no rating", reply, structs.NoFeedback, ErrInvalidFeedback},
+		{"unknown rating", reply, 3, ErrInvalidFeedback},
+		{"user's message", first, structs.ThumbsUp, ErrInvalidFeedback},
+		{"missing message", convoId.String(), structs.ThumbsUp, ErrNotFound},
+	} {
+		if _, err := s.SetFeedback(USER, convoId.String(), tt.message, tt.rating, ""); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got: %v", tt.name, tt.want, err)
+		}
+	}
+
+	// conversations in the trash can't be rated
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetFeedback(USER, convoId.String(), reply, structs.ThumbsUp, ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	if _, err := s.GetFeedback(USER, convoId.String(), reply); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestAggregateFeedback(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	answer := appendWithContext(t, s, convoId).String()
+	other := appendWithContext(t, s, convoId).String()
+	unrated := appendWithContext(t, s, convoId)
+
+	for _, f := range []struct {
+		user, message string
+		rating        structs.Feedback
+		comment       string
+	}{
+		{USER, answer, structs.ThumbsUp, "clear"},
+		{"Miss_Take", answer, structs.ThumbsUp, ""},
+		{"Mr_Nobody", answer, structs.ThumbsDown, "too long"},
+		{USER, other, structs.ThumbsDown, ""},
+	} {
+		if _, err := s.SetFeedback(f.user, convoId.String(), f.message, f.rating, f.comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summaries, err := s.AggregateFeedback(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("there should be a summary per rated answer. Got: %+v", summaries)
+	}
+	if got := summaries[0]; got.MessageId.String() != answer || got.ConversationId != convoId || got.ThumbsUp != 2 || got.ThumbsDown != 1 ||
+		!reflect.DeepEqual(got.Comments, []string{"clear", "too long"}) {
+		t.Fatalf("the first answer should have 2 thumbs up, 1 down and both comments. Got: %+v", got)
+	}
+	if got := summaries[1]; got.MessageId.String() != other || got.ThumbsUp != 0 || got.ThumbsDown != 1 || len(got.Comments) != 0 {
+		t.Fatalf("the second answer should have 1 thumbs down. Got: %+v", got)
+	}
+	for _, summary := range summaries {
+		if summary.MessageId == unrated {
+			t.Fatal("answers without feedback shouldn't be summarized")
+		}
+	}
+
+	// since only has the answers rated after it, with all of their ratings
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	if _, err := s.SetFeedback("Miss_Take", convoId.String(), answer, structs.ThumbsDown, ""); err != nil {
+		t.Fatal(err)
+	}
+	summaries, err = s.AggregateFeedback(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].MessageId.String() != answer || summaries[0].ThumbsUp != 1 || summaries[0].ThumbsDown != 2 {
+		t.Fatalf("only the answer re-rated since should be summarized, with every rating. Got: %+v", summaries)
+	}
+
+	// conversations in the trash are left out
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, err := s.AggregateFeedback(time.Time{}); err != nil || len(summaries) != 0 {
+		t.Fatalf("the feedback in the trash shouldn't be summarized. Got %+v, %v", summaries, err)
+	}
+}
+
+func TestRekey_Feedback(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, nil)
+	convoId := seedConversation(t, s, USER)
+	reply := appendWithContext(t, s, convoId).String()
+	if _, err := s.SetFeedback(USER, convoId.String(), reply, structs.ThumbsUp, "clear"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := Rekey(d.path, d.logPath, nil, testKey); err != nil {
+		t.Fatal(err)
+	}
+	s = d.open(t, testKey)
+	defer s.Close()
+	if got, err := s.GetFeedback(USER, convoId.String(), reply); err != nil || got.Comment != "clear" {
+		t.Fatalf("the comment should be readable with the new key. Got %+v, %v", got, err)
+	}
+	var stored structs.MessageFeedback
+	s.db.Where("message_id = ?", reply).First(&stored)
+	if !strings.HasPrefix(stored.Comment, encryptedPrefix) {
+		t.Fatalf("the comment should be encrypted. It's: %q", stored.Comment)
+	}
+}
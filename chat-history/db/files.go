@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// defaultFileMode is the mode of the database files the store creates unless FileMode changes it.
+// SQLite gives its WAL and journal files the same mode
+const defaultFileMode os.FileMode = 0600
+
+// prepareFile gets a writable database file at dbPath ready for SQLite: its directory is created, readable by the
+// service only, and the file with mode, which an existing one is changed to. It fails if either can't be written to,
+// so the service doesn't start with a database it can't change
+func prepareFile(dbPath string, mode os.FileMode) error {
+	if dbPath == MemoryPath {
+		return nil
+	}
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create the directory of %s: %w", dbPath, err)
+	}
+
+	f, err := os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dbPath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm() != mode {
+		// created before, or by someone with a looser umask
+		slog.Info("changing the mode of the database file", "path", dbPath, "from", info.Mode().Perm(), "to", mode)
+		if err := f.Chmod(mode); err != nil {
+			return fmt.Errorf("failed to change the mode of %s: %w", dbPath, err)
+		}
+	}
+
+	// SQLite writes its WAL and journal next to the file
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("the directory of %s is not writable: %w", dbPath, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
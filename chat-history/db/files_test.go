@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mode(t *testing.T, pth string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestPrepareFile(t *testing.T) {
+	tmp := t.TempDir()
+	dbPath := filepath.Join(tmp, "data", "chats", DB_NAME)
+	archivePath := filepath.Join(tmp, "archive", "archive.db")
+	s, err := NewSQLiteStore(dbPath, filepath.Join(tmp, "test.log"), ArchivePath(archivePath))
+	if err != nil {
+		t.Fatalf("the store should create the directories it's in: %v", err)
+	}
+	seedConversation(t, s, USER)
+	s.Close()
+
+	for pth, want := range map[string]os.FileMode{
+		filepath.Join(tmp, "data"): 0700,
+		filepath.Dir(dbPath):       0700,
+		dbPath:                     0600,
+		filepath.Dir(archivePath):  0700,
+		archivePath:                0600,
+	} {
+		if got := mode(t, pth); got != want {
+			t.Fatalf("%s should be %o. It's %o", pth, want, got)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dbPath)); len(entries) == 0 {
+		t.Fatal("the database should be in the new directory")
+	} else {
+		for _, e := range entries {
+			if e.Name() != DB_NAME && filepath.Ext(e.Name()) != ".db-wal" && filepath.Ext(e.Name()) != ".db-shm" {
+				t.Fatalf("the write check shouldn't leave anything behind: %s", e.Name())
+			}
+		}
+	}
+}
+
+func TestPrepareFile_Mode(t *testing.T) {
+	tmp := t.TempDir()
+	dbPath := filepath.Join(tmp, DB_NAME)
+	// a mode the umask would take away
+	s, err := NewSQLiteStore(dbPath, filepath.Join(tmp, "test.log"), FileMode(0660))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := mode(t, dbPath); got != 0660 {
+		t.Fatalf("the file should be created with the mode it's given. It's %o", got)
+	}
+
+	// an existing file is changed to it
+	if err := os.Chmod(dbPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewSQLiteStore(dbPath, filepath.Join(tmp, "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := mode(t, dbPath); got != 0600 {
+		t.Fatalf("an existing file should be changed to the mode. It's %o", got)
+	}
+
+	// unless it's opened read-only
+	os.Chmod(dbPath, 0644)
+	s, err = NewSQLiteStore(dbPath, filepath.Join(tmp, "test.log"), ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := mode(t, dbPath); got != 0644 {
+		t.Fatalf("a read-only store shouldn't change the file. It's %o", got)
+	}
+}
+
+func TestPrepareFile_NotWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any file")
+	}
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "locked")
+	if err := os.Mkdir(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0700) })
+	if _, err := NewSQLiteStore(filepath.Join(dir, DB_NAME), fmt.Sprintf("%s/test.log", tmp)); err == nil {
+		t.Fatal("a database in a directory that can't be written to shouldn't open")
+	}
+}
+
+func TestPrepareFile_NotADirectory(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "file")
+	os.WriteFile(file, nil, 0600)
+	if err := prepareFile(filepath.Join(file, DB_NAME), defaultFileMode); err == nil {
+		t.Fatal("a database under a file shouldn't be prepared")
+	}
+	if err := prepareFile(MemoryPath, defaultFileMode); err != nil {
+		t.Fatalf("there's no file to prepare for a database in memory. Got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) ForkConversation(ownerId, conversationId, messageId, userId string) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source := structs.Conversation{}
+	tx := s.db.Where("user_id = ? AND conversation_id = ?", ownerId, conversationId).First(&source)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	messages := []structs.Message{}
+	if err := s.db.Where("conversation_id = ?", source.ConversationId).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	path, ok := BranchTo(messages, messageId)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// the copies get new ids, and the content is sealed with the message id
+	if err := s.sealer.openMessages(path); err != nil {
+		return nil, err
+	}
+
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	fork := structs.Conversation{
+		UserId:         userId,
+		ConversationId: id,
+		Name:           source.Name,
+		ModelName:      source.ModelName,
+		SystemPrompt:   source.SystemPrompt,
+		ParentId:       &source.ConversationId,
+	}
+	copies := make([]structs.Message, len(path))
+	var parent *uuid.UUID
+	for i, m := range path {
+		// feedback, comments and pins are on the original's messages, the copies start without them. They're all
+		// written now, in order, so the last one is the latest
+		copies[i] = structs.Message{
+			ConversationId: id,
+			MessageId:      uuid.New(),
+			ParentId:       parent,
+			ModelName:      m.ModelName,
+			Content:        m.Content,
+			Role:           m.Role,
+			ResponseTime:   m.ResponseTime,
+			Incomplete:     m.Incomplete,
+			ToolName:       m.ToolName,
+			ToolCallId:     m.ToolCallId,
+		}
+		detectLanguage(&copies[i])
+		s.countTokens(&copies[i])
+		parent = &copies[i].MessageId
+	}
+	first := copies[0]
+	for i := range copies {
+		if err := s.sealer.sealMessage(&copies[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+		if err := tx.Create(&fork).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(copies, 100).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, createdEvent(fork, copies[0])); err != nil {
+			return err
+		}
+		if fork.Language, err = updateLanguage(tx, id); err != nil {
+			return err
+		}
+		fork.TokenCount, err = updateTokenCount(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.emit(EventConversationCreated, fork, first, copies[0])
+	return &fork, nil
+}
+
+// BranchTo returns the messages from the first one to messageId following parent_id, oldest first.
+// It's false if messageId isn't one of the messages
+func BranchTo(messages []structs.Message, messageId string) ([]structs.Message, bool) {
+	byId := make(map[uuid.UUID]structs.Message, len(messages))
+	for _, m := range messages {
+		byId[m.MessageId] = m
+	}
+	id, err := uuid.Parse(messageId)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := byId[id]
+	if !ok {
+		return nil, false
+	}
+	path := []structs.Message{m}
+	// a parent that isn't in the conversation ends the branch, and so does one that was already seen
+	seen := map[uuid.UUID]bool{id: true}
+	for m.ParentId != nil && !seen[*m.ParentId] {
+		if m, ok = byId[*m.ParentId]; !ok {
+			break
+		}
+		seen[m.MessageId] = true
+		path = append(path, m)
+	}
+	slices.Reverse(path)
+	return path, true
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestForkConversation(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hello <- answer <- question <- answer, and a second answer to the first question that isn't on the way
+	reply := func(parent structs.Message, content string) structs.Message {
+		t.Helper()
+		m := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &parent.MessageId, Content: content, Role: structs.AssistantRole}
+		if _, err := s.AppendMessage(m, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	answer := reply(messages[0], "answer")
+	reply(messages[0], "another answer")
+	question := reply(answer, "question")
+	reply(question, "last answer")
+	answer.Feedback, answer.Comment = structs.ThumbsUp, "good"
+	if _, err := s.AppendMessage(answer, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := s.ForkConversation(USER, convoId.String(), question.MessageId.String(), "Miss_Take")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.ConversationId == convoId || fork.UserId != "Miss_Take" || fork.Name != "convo" {
+		t.Fatalf("the fork should be a new conversation of the caller's, named like the original: %+v", fork)
+	}
+	if fork.ParentId == nil || *fork.ParentId != convoId {
+		t.Fatalf("the fork's parent should be %s. It's %v", convoId, fork.ParentId)
+	}
+	found, err := s.FindConversation(fork.ConversationId.String())
+	if err != nil || found.ParentId == nil || *found.ParentId != convoId {
+		t.Fatalf("the parent should be stored. Got %+v, %v", found, err)
+	}
+
+	// only the branch up to the message is copied, under new ids
+	copied, err := s.GetConversation("Miss_Take", fork.ConversationId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Hello, world", "answer", "question"}
+	if len(copied) != len(want) {
+		t.Fatalf("the fork should have %d messages. It has %d: %+v", len(want), len(copied), copied)
+	}
+	for i, m := range copied {
+		if m.Content != want[i] {
+			t.Fatalf("message %d should be %q. It's %q", i, want[i], m.Content)
+		}
+		if m.ConversationId != fork.ConversationId || m.MessageId == messages[0].MessageId || m.MessageId == answer.MessageId || m.MessageId == question.MessageId {
+			t.Fatalf("the copies should be new messages of the fork: %+v", m)
+		}
+		if i == 0 && m.ParentId != nil || i > 0 && (m.ParentId == nil || *m.ParentId != copied[i-1].MessageId) {
+			t.Fatalf("message %d should follow the one before it: %+v", i, m)
+		}
+		if m.Feedback != structs.NoFeedback || m.Comment != "" {
+			t.Fatalf("the copies shouldn't have the original's feedback: %+v", m)
+		}
+	}
+
+	// the original is left as it was
+	if original, err := s.GetConversation(USER, convoId.String()); err != nil || len(original) != 5 {
+		t.Fatalf("the original should still have its 5 messages. Got %d, %v", len(original), err)
+	}
+}
+
+func TestForkConversation_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	other := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherMessages, err := s.GetConversation(USER, other.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, ownerId, conversationId, messageId string
+	}{
+		{"another user's conversation", "Miss_Take", convoId.String(), messages[0].MessageId.String()},
+		{"unknown conversation", USER, uuid.NewString(), messages[0].MessageId.String()},
+		{"unknown message", USER, convoId.String(), uuid.NewString()},
+		{"another conversation's message", USER, convoId.String(), otherMessages[0].MessageId.String()},
+		{"not a message id", USER, convoId.String(), "nope"},
+	}
+	for _, tt := range tests {
+		if _, err := s.ForkConversation(tt.ownerId, tt.conversationId, tt.messageId, "Miss_Take"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected ErrNotFound, got: %v", tt.name, err)
+		}
+	}
+	if convos, _, err := s.ListConversations("Miss_Take", ListOptions{}); err != nil || len(convos) != 0 {
+		t.Fatalf("no forks should be made. Got %d, %v", len(convos), err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotencyKey is the conversation a user created with a request's Idempotency-Key
+type idempotencyKey struct {
+	// keys are per tenant like the rest, the same user id can be in other tenants
+	TenantId       string    `gorm:"primaryKey"`
+	UserId         string    `gorm:"primaryKey"`
+	Key            string    `gorm:"primaryKey"`
+	ConversationId uuid.UUID `gorm:"not null"`
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+func (idempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+func (s *sqliteStore) CreateConversationOnce(userId, key, name string, message structs.Message, window time.Duration) (*structs.Conversation, bool, error) {
+	if s.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	if err := s.validateMessage(message); err != nil {
+		return nil, false, err
+	}
+	content, err := s.checkContent(message.ConversationId.String(), message.MessageId.String(), message.Content)
+	if err != nil {
+		return nil, false, err
+	}
+	message.Content = content
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-window)
+	var convo structs.Conversation
+	var plain structs.Message
+	created := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// the keys that have expired aren't needed anymore, whoever they belong to
+		if err := tx.Where("created_at <= ?", cutoff).Delete(&idempotencyKey{}).Error; err != nil {
+			return err
+		}
+
+		// a key whose conversation has since been deleted, archived or given away is as good as unused
+		existing := idempotencyKey{}
+		res := tx.Where("user_id = ? AND key = ?", userId, key).Limit(1).Find(&existing)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			err := tx.Where("user_id = ? AND conversation_id = ?", userId, existing.ConversationId).First(&convo).Error
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+		if message.ConversationId == uuid.Nil {
+			id, err := s.ids.NewID()
+			if err != nil {
+				return err
+			}
+			message.ConversationId = id
+		}
+		message.Pinned = false
+		message.Incomplete = false
+		detectLanguage(&message)
+		s.countTokens(&message)
+		plain = message
+		if err := s.sealer.sealMessage(&message); err != nil {
+			return err
+		}
+		convo = structs.Conversation{UserId: userId, ConversationId: message.ConversationId, Name: name, Language: message.Language, TokenCount: int64(message.TokenCount)}
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, createdEvent(convo, message)); err != nil {
+			return err
+		}
+		created = true
+		row := idempotencyKey{UserId: userId, Key: key, ConversationId: convo.ConversationId}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		s.emit(EventConversationCreated, convo, plain, message)
+	}
+	return &convo, created, nil
+}
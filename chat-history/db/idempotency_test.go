@@ -0,0 +1,73 @@
+package db
+
+import (
+	"chat-history/structs"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// keyedMessage is the first message of a conversation the store gives an id to, as a retried request would send it
+func keyedMessage() structs.Message {
+	return structs.Message{MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole}
+}
+
+func TestCreateConversationOnce(t *testing.T) {
+	s := newTestStore(t)
+
+	first, created, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil || !created {
+		t.Fatalf("the first request should create a conversation. Got %v, %v", created, err)
+	}
+
+	// a retry with the same key gets the same conversation
+	again, created, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil || created || again.ConversationId != first.ConversationId {
+		t.Fatalf("a retry should return the original conversation %s. Got %+v, %v, %v", first.ConversationId, again, created, err)
+	}
+	if messages, err := s.GetConversation(USER, first.ConversationId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("a retry shouldn't add its message. Got %v, %v", messages, err)
+	}
+
+	// a different key, or the same key from someone else, is a new conversation
+	other, created, err := s.CreateConversationOnce(USER, "retry-2", "convo", keyedMessage(), time.Hour)
+	if err != nil || !created || other.ConversationId == first.ConversationId {
+		t.Fatalf("a different key should create a conversation. Got %+v, %v, %v", other, created, err)
+	}
+	if _, created, err := s.CreateConversationOnce("Miss_Take", "retry-1", "convo", keyedMessage(), time.Hour); err != nil || !created {
+		t.Fatalf("keys should be per user. Got %v, %v", created, err)
+	}
+	if convos, _, err := s.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 2 {
+		t.Fatalf("the user should have 2 conversations. Got %v, %v", convos, err)
+	}
+}
+
+func TestCreateConversationOnce_Expired(t *testing.T) {
+	s := newTestStore(t)
+
+	first, _, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	again, created, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), 5*time.Millisecond)
+	if err != nil || !created || again.ConversationId == first.ConversationId {
+		t.Fatalf("a key past the window should create a new conversation. Got %+v, %v, %v", again, created, err)
+	}
+}
+
+func TestCreateConversationOnce_Deleted(t *testing.T) {
+	s := newTestStore(t)
+
+	first, _, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteConversation(USER, first.ConversationId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if again, created, err := s.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour); err != nil || !created {
+		t.Fatalf("the conversation of a key that's been deleted shouldn't be returned. Got %+v, %v, %v", again, created, err)
+	}
+}
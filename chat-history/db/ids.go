@@ -0,0 +1,16 @@
+package db
+
+import "github.com/google/uuid"
+
+// IDGenerator makes the ids of conversations that are created without one
+type IDGenerator interface {
+	NewID() (uuid.UUID, error)
+}
+
+// UUIDv7 is the default IDGenerator. Its ids are random enough that they can't be guessed,
+// and start with the time they were made in, so they sort in the order they were created
+type UUIDv7 struct{}
+
+func (UUIDv7) NewID() (uuid.UUID, error) {
+	return uuid.NewV7()
+}
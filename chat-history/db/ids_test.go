@@ -0,0 +1,88 @@
+package db
+
+import (
+	"chat-history/structs"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUUIDv7(t *testing.T) {
+	seen := map[uuid.UUID]bool{}
+	var last string
+	for i := range 10000 {
+		id, err := UUIDv7{}.NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.Version() != 7 {
+			t.Fatalf("%s should be a version 7 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("id %d, %s, was generated twice", i, id)
+		}
+		seen[id] = true
+		// even ids made in the same millisecond sort in the order they were made
+		if s := id.String(); s <= last {
+			t.Fatalf("id %d, %s, should sort after %s", i, s, last)
+		} else {
+			last = s
+		}
+	}
+}
+
+// fixedIDs hands out the ids in order
+type fixedIDs []uuid.UUID
+
+func (f *fixedIDs) NewID() (uuid.UUID, error) {
+	if len(*f) == 0 {
+		return uuid.Nil, fmt.Errorf("out of ids")
+	}
+	id := (*f)[0]
+	*f = (*f)[1:]
+	return id, nil
+}
+
+func TestCreateConversation_GeneratesID(t *testing.T) {
+	want := uuid.New()
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), IDs(&fixedIDs{want}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	convo, err := s.CreateConversation(USER, "new", structs.Message{MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+	if err != nil || convo.ConversationId != want {
+		t.Fatalf("the conversation should get the generated id %s. Got %+v, %v", want, convo, err)
+	}
+	if messages, err := s.GetConversation(USER, want.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("the message should be in the new conversation. Got %+v, %v", messages, err)
+	}
+
+	// an id that's given is kept, and the generator isn't asked
+	given := uuid.MustParse("601529eb-4927-4e24-b285-bd6b9519a951")
+	convo, err = s.CreateConversation(USER, "given", structs.Message{ConversationId: given, MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+	if err != nil || convo.ConversationId != given {
+		t.Fatalf("the conversation should keep its id %s. Got %+v, %v", given, convo, err)
+	}
+	if found, err := s.FindConversation(given.String()); err != nil || found.ConversationId != given {
+		t.Fatalf("the conversation should be found by its id. Got %+v, %v", found, err)
+	}
+}
+
+func TestCreateConversation_DefaultIDs(t *testing.T) {
+	s := newTestStore(t)
+	first, err := s.CreateConversation(USER, "first", structs.Message{MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.CreateConversation(USER, "second", structs.Message{MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ConversationId.Version() != 7 || second.ConversationId.String() <= first.ConversationId.String() {
+		t.Fatalf("the ids should be UUIDv7s in creation order. Got %s, %s", first.ConversationId, second.ConversationId)
+	}
+}
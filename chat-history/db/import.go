@@ -0,0 +1,339 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidMessages is returned, wrapped with what's wrong, when messages can't be imported
+	ErrInvalidMessages = errors.New("invalid messages")
+	// ErrConversationExists is returned when an imported conversation's id is already used, by any user
+	ErrConversationExists = errors.New("a conversation with the id already exists")
+)
+
+// messages are inserted this many at a time
+const importBatchSize = 100
+
+// how far apart messages without a timestamp are spaced, so they keep their order when sorted by time
+const importSpacing = time.Millisecond
+
+func (s *sqliteStore) BulkAppendMessages(userId, conversationId, name string, messages []structs.Message) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	convoId, err := uuid.Parse(conversationId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: conversation id %q is not a uuid", ErrInvalidMessages, conversationId)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("%w: there are no messages", ErrInvalidMessages)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convo := structs.Conversation{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// trashed conversations count, their id can't be reused
+		res := tx.Unscoped().Where("conversation_id = ?", convoId).Limit(1).Find(&convo)
+		if res.Error != nil {
+			return res.Error
+		}
+		exists := res.RowsAffected > 0
+		if exists && (convo.UserId != userId || convo.DeletedAt.Valid) {
+			return ErrNotFound
+		}
+
+		toInsert, err := s.prepareImport(tx, convoId, exists, messages)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			if err := s.checkConversationLimit(tx, userId); err != nil {
+				return err
+			}
+			convo = structs.Conversation{UserId: userId, ConversationId: convoId, Name: name}
+			if err := tx.Create(&convo).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.CreateInBatches(toInsert, importBatchSize).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, importedEvents(convo, !exists, toInsert)...); err != nil {
+			return err
+		}
+		if err := bumpVersion(tx, convoId, AnyVersion); err != nil {
+			return err
+		}
+		if _, err := updateLanguage(tx, convoId); err != nil {
+			return err
+		}
+		if _, err := updateTokenCount(tx, convoId); err != nil {
+			return err
+		}
+		return tx.Where("conversation_id = ?", convoId).First(&convo).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &convo, nil
+}
+
+func (s *sqliteStore) ImportConversation(userId string, data ConversationData) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	convoId := data.Conversation.ConversationId
+	if convoId == uuid.Nil {
+		return nil, fmt.Errorf("%w: the conversation has no id", ErrInvalidMessages)
+	}
+	if len(data.Messages) == 0 {
+		return nil, fmt.Errorf("%w: there are no messages", ErrInvalidMessages)
+	}
+	if _, err := marshalMetadata(data.Conversation.Metadata); err != nil {
+		return nil, err
+	}
+	tags := make([]structs.ConversationTag, len(data.Conversation.Tags))
+	for i, tag := range data.Conversation.Tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = structs.ConversationTag{ConversationId: convoId, Tag: tag}
+	}
+	attachments := make([]structs.Attachment, len(data.Attachments))
+	perMessage := map[uuid.UUID]int{}
+	for i, a := range data.Attachments {
+		if err := validateAttachment(&a); err != nil {
+			return nil, err
+		}
+		if perMessage[a.MessageId]++; perMessage[a.MessageId] > s.maxAttachments {
+			return nil, ErrTooManyAttachments
+		}
+		// like AddAttachment, the ids are the store's
+		a.ID = 0
+		a.AttachmentId = uuid.New()
+		attachments[i] = a
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convo := structs.Conversation{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// trashed and archived conversations count, their id can't be reused
+		var count int64
+		if err := tx.Unscoped().Model(&structs.Conversation{}).Where("conversation_id = ?", convoId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 && s.archive != nil {
+			if err := s.archive.Model(&structs.Conversation{}).Where("conversation_id = ?", convoId).Count(&count).Error; err != nil {
+				return err
+			}
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s", ErrConversationExists, convoId)
+		}
+
+		toInsert, err := s.prepareImport(tx, convoId, false, data.Messages)
+		if err != nil {
+			return err
+		}
+		imported := map[uuid.UUID]bool{}
+		for _, m := range toInsert {
+			imported[m.MessageId] = true
+		}
+		for _, a := range attachments {
+			if !imported[a.MessageId] {
+				return fmt.Errorf("%w: its message %s isn't in the conversation", ErrInvalidAttachment, a.MessageId)
+			}
+		}
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+
+		convo = structs.Conversation{
+			UserId:         userId,
+			ConversationId: convoId,
+			Name:           data.Conversation.Name,
+			ModelName:      data.Conversation.ModelName,
+			SystemPrompt:   data.Conversation.SystemPrompt,
+			Metadata:       data.Conversation.Metadata,
+		}
+		convo.CreatedAt = data.Conversation.CreatedAt
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(toInsert, importBatchSize).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, importedEvents(convo, true, toInsert)...); err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			// a tag that's there twice is added once
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+				return err
+			}
+		}
+		if len(attachments) > 0 {
+			if err := tx.Create(&attachments).Error; err != nil {
+				return err
+			}
+		}
+		if err := bumpVersion(tx, convoId, AnyVersion); err != nil {
+			return err
+		}
+		if _, err := updateLanguage(tx, convoId); err != nil {
+			return err
+		}
+		if _, err := updateTokenCount(tx, convoId); err != nil {
+			return err
+		}
+		// after bumpVersion, which set it to now
+		if updated := data.Conversation.UpdatedAt; !updated.IsZero() {
+			if err := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", convoId).UpdateColumn("updated_at", updated).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("conversation_id = ?", convoId).First(&convo).Error; err != nil {
+			return err
+		}
+		convos := []structs.Conversation{convo}
+		if err := loadTags(tx, convos); err != nil {
+			return err
+		}
+		convo = convos[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data.Conversation.Archived && s.archive != nil {
+		// it's imported either way, a conversation that can't be moved stays in the primary
+		if err := moveConversation(s.db, s.archive, userId, convoId.String()); err != nil {
+			slog.Error("failed to move the imported conversation to the archive", "conversation_id", convoId, "err", err)
+		} else {
+			convo.Archived = true
+		}
+	}
+	return &convo, nil
+}
+
+// prepareImport checks the messages can be appended to the conversation in the order they're given
+// and returns copies of them, ready to insert:
+//   - message ids are set and not used by any other message
+//   - roles are user, assistant or system, and feedback is none, thumbs up or thumbs down
+//   - a parent is an earlier message in the import or already in the conversation
+//   - timestamps don't go back in time, and aren't before the last message in the conversation.
+//     Missing ones are filled in after the message before, or from now for the first one
+func (s *sqliteStore) prepareImport(tx *gorm.DB, convoId uuid.UUID, exists bool, messages []structs.Message) ([]structs.Message, error) {
+	invalid := func(i int, format string, args ...any) error {
+		return fmt.Errorf("%w: message %d: %s", ErrInvalidMessages, i, fmt.Sprintf(format, args...))
+	}
+
+	// the messages already in the conversation, that imported ones can reply to
+	known := map[uuid.UUID]bool{}
+	var last time.Time
+	if exists {
+		var existing []structs.Message
+		if err := tx.Select("message_id", "created_at").Where("conversation_id = ?", convoId).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range existing {
+			known[m.MessageId] = true
+			if m.CreatedAt.After(last) {
+				last = m.CreatedAt
+			}
+		}
+	}
+
+	ids := make([]uuid.UUID, len(messages))
+	for i, m := range messages {
+		ids[i] = m.MessageId
+	}
+	// message ids are unique across conversations, and stay taken after they're deleted
+	for start := 0; start < len(ids); start += importBatchSize {
+		var taken []uuid.UUID
+		batch := ids[start:min(start+importBatchSize, len(ids))]
+		if err := tx.Unscoped().Model(&structs.Message{}).Where("message_id IN ?", batch).Pluck("message_id", &taken).Error; err != nil {
+			return nil, err
+		}
+		if len(taken) > 0 {
+			for i, id := range ids {
+				if id == taken[0] {
+					return nil, invalid(i, "message id %s already exists", id)
+				}
+			}
+		}
+	}
+
+	prepared := make([]structs.Message, len(messages))
+	imported := map[uuid.UUID]bool{}
+	now := s.now()
+	for i, m := range messages {
+		switch {
+		case m.MessageId == uuid.Nil:
+			return nil, invalid(i, "message_id is required")
+		case imported[m.MessageId]:
+			return nil, invalid(i, "message id %s is repeated", m.MessageId)
+		case m.ConversationId != uuid.Nil && m.ConversationId != convoId:
+			return nil, invalid(i, "it belongs to conversation %s", m.ConversationId)
+		case !s.roles.valid(m.Role):
+			return nil, invalid(i, "role must be %s, not %q", s.roles, m.Role)
+		case m.Feedback > structs.ThumbsDown:
+			return nil, invalid(i, "feedback %d is not a feedback value", m.Feedback)
+		case m.ParentId != nil && !imported[*m.ParentId] && !known[*m.ParentId]:
+			return nil, invalid(i, "parent %s is not an earlier message in the conversation", *m.ParentId)
+		}
+
+		created := m.CreatedAt
+		if created.IsZero() {
+			switch {
+			case i > 0:
+				created = prepared[i-1].CreatedAt.Add(importSpacing)
+			case !last.IsZero() && !last.Before(now):
+				created = last.Add(importSpacing)
+			default:
+				created = now
+			}
+		}
+		if created.Before(last) {
+			return nil, invalid(i, "it's older than the message before it")
+		}
+		last = created
+		imported[m.MessageId] = true
+
+		// only what a message is made of is imported, the store assigns the rest
+		msg := structs.Message{
+			ConversationId: convoId,
+			MessageId:      m.MessageId,
+			ParentId:       m.ParentId,
+			ModelName:      m.ModelName,
+			Content:        m.Content,
+			Role:           m.Role,
+			ResponseTime:   m.ResponseTime,
+			Feedback:       m.Feedback,
+			Comment:        m.Comment,
+			ToolName:       m.ToolName,
+			ToolCallId:     m.ToolCallId,
+		}
+		msg.CreatedAt = created
+		msg.UpdatedAt = created
+		detectLanguage(&msg)
+		s.countTokens(&msg)
+		if err := s.sealer.sealMessage(&msg); err != nil {
+			return nil, err
+		}
+		prepared[i] = msg
+	}
+	return prepared, nil
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// thread returns n messages alternating between the user and the LLM, each replying to the one before
+func thread(n int) []structs.Message {
+	messages := make([]structs.Message, n)
+	var parent *uuid.UUID
+	for i := range messages {
+		role := structs.UserRole
+		if i%2 == 1 {
+			role = structs.SystemRole
+		}
+		messages[i] = structs.Message{MessageId: uuid.New(), ParentId: parent, Role: role, Content: fmt.Sprintf("message %d", i)}
+		parent = &messages[i].MessageId
+	}
+	return messages
+}
+
+func TestBulkAppendMessages(t *testing.T) {
+	s := newTestStore(t)
+	convoId := uuid.New().String()
+
+	messages := thread(1000)
+	convo, err := s.BulkAppendMessages(USER, convoId, "imported", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.UserId != USER || convo.ConversationId.String() != convoId || convo.Name != "imported" {
+		t.Fatalf("the conversation should be created for the user: %+v", convo)
+	}
+
+	got, err := s.GetConversation(USER, convoId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(messages) {
+		t.Fatalf("all %d messages should be imported. There are %d", len(messages), len(got))
+	}
+	slices.SortFunc(got, func(a, b structs.Message) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	for i, m := range got {
+		if m.MessageId != messages[i].MessageId || m.Content != messages[i].Content {
+			t.Fatalf("message %d should be %s. It's: %s %q", i, messages[i].MessageId, m.MessageId, m.Content)
+		}
+	}
+
+	// more can be appended to it, replying to what's already there
+	more := thread(2)
+	more[0].ParentId = &messages[len(messages)-1].MessageId
+	if _, err := s.BulkAppendMessages(USER, convoId, "", more); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.GetConversation(USER, convoId)
+	if len(got) != len(messages)+len(more) {
+		t.Fatalf("there should be %d messages. There are %d", len(messages)+len(more), len(got))
+	}
+}
+
+func TestBulkAppendMessages_RollsBack(t *testing.T) {
+	s := newTestStore(t)
+	existing := seedConversation(t, s, USER)
+	stored, _ := s.GetConversation(USER, existing.String())
+
+	earlier := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		malform func(messages []structs.Message)
+	}{
+		{"bad role", func(m []structs.Message) { m[500].Role = "admin" }},
+		{"no message id", func(m []structs.Message) { m[500].MessageId = uuid.Nil }},
+		{"repeated message id", func(m []structs.Message) { m[500].MessageId = m[10].MessageId }},
+		{"message id of another conversation", func(m []structs.Message) { m[999].MessageId = stored[0].MessageId }},
+		{"unknown parent", func(m []structs.Message) { p := uuid.New(); m[500].ParentId = &p }},
+		{"parent comes later", func(m []structs.Message) { m[500].ParentId = &m[501].MessageId }},
+		{"other conversation", func(m []structs.Message) { m[500].ConversationId = existing }},
+		{"bad feedback", func(m []structs.Message) { m[500].Feedback = 7 }},
+		{"out of order", func(m []structs.Message) { m[500].CreatedAt = earlier }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			convoId := uuid.New().String()
+			messages := thread(1000)
+			tt.malform(messages)
+
+			_, err := s.BulkAppendMessages(USER, convoId, "imported", messages)
+			if !errors.Is(err, ErrInvalidMessages) {
+				t.Fatalf("expected ErrInvalidMessages, got: %v", err)
+			}
+			// nothing is kept, not even the conversation
+			if _, err := s.FindConversation(convoId); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("the conversation should not be created, got: %v", err)
+			}
+			for _, id := range []uuid.UUID{messages[0].MessageId, messages[499].MessageId} {
+				var count int64
+				s.(*sqliteStore).db.Unscoped().Model(&structs.Message{}).Where("message_id = ?", id).Count(&count)
+				if count != 0 {
+					t.Fatalf("message %s should not be imported", id)
+				}
+			}
+		})
+	}
+
+	// appending to an existing conversation is rolled back too
+	messages := thread(10)
+	messages[9].Role = ""
+	if _, err := s.BulkAppendMessages(USER, existing.String(), "", messages); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("expected ErrInvalidMessages, got: %v", err)
+	}
+	if got, _ := s.GetConversation(USER, existing.String()); len(got) != len(stored) {
+		t.Fatalf("the conversation should still have %d messages. It has %d", len(stored), len(got))
+	}
+}
+
+func TestBulkAppendMessages_Ownership(t *testing.T) {
+	s := newTestStore(t)
+	mine := seedConversation(t, s, USER)
+	trashed := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, trashed.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.BulkAppendMessages("Miss_Take", mine.String(), "", thread(2)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be ErrNotFound, got: %v", err)
+	}
+	if _, err := s.BulkAppendMessages(USER, trashed.String(), "", thread(2)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a conversation in the trash should be ErrNotFound, got: %v", err)
+	}
+	if _, err := s.BulkAppendMessages(USER, mine.String(), "", nil); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("an empty import should be ErrInvalidMessages, got: %v", err)
+	}
+	if _, err := s.BulkAppendMessages(USER, "not-a-uuid", "", thread(2)); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("a bad conversation id should be ErrInvalidMessages, got: %v", err)
+	}
+}
+
+func TestImportConversation(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	replies := seedReplies(t, s, convoId, 2)
+	s.AddTag(USER, convoId.String(), "work")
+	s.SetSystemPrompt(USER, convoId.String(), "Be brief")
+	if _, err := s.AddAttachment(USER, convoId.String(), replies[0], structs.Attachment{
+		Filename: "plot.png", ContentType: "image/png", Size: 10, StorageURL: "https://files.example.com/plot.png",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := s.ExportConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Messages) != 3 || len(exported.Attachments) != 1 || !slices.Equal(exported.Conversation.Tags, []string{"work"}) {
+		t.Fatalf("the conversation should be exported with its tags, messages and attachments: %+v", exported)
+	}
+	if _, err := s.ExportConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("only the owner's conversation should be exported, got %v", err)
+	}
+
+	other := newTestStore(t)
+	convo, err := other.ImportConversation("Miss_Take", *exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.ConversationId != convoId || convo.UserId != "Miss_Take" || convo.SystemPrompt != "Be brief" ||
+		!convo.CreatedAt.Equal(exported.Conversation.CreatedAt) || !slices.Equal(convo.Tags, []string{"work"}) {
+		t.Fatalf("the conversation should be imported as it was, for the importer: %+v", convo)
+	}
+	imported, err := other.ExportConversation("Miss_Take", convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range imported.Messages {
+		want := exported.Messages[i]
+		if m.MessageId != want.MessageId || m.Content != want.Content || m.Role != want.Role || !m.CreatedAt.Equal(want.CreatedAt) {
+			t.Fatalf("message %d should be imported as it was.\nGot:  %+v\nWant: %+v", i, m, want)
+		}
+	}
+	if a := imported.Attachments[0]; a.MessageId.String() != replies[0] || a.Filename != "plot.png" || !a.CreatedAt.Equal(exported.Attachments[0].CreatedAt) {
+		t.Fatalf("the attachment should be imported on its message: %+v", a)
+	}
+
+	// ids can't be reused, even by a conversation in the trash
+	if _, err := s.ImportConversation(USER, *exported); !errors.Is(err, ErrConversationExists) {
+		t.Fatalf("a conversation that's there should be ErrConversationExists, got %v", err)
+	}
+	other.DeleteConversation("Miss_Take", convoId.String())
+	if _, err := other.ImportConversation("Miss_Take", *exported); !errors.Is(err, ErrConversationExists) {
+		t.Fatalf("a conversation in the trash should be ErrConversationExists, got %v", err)
+	}
+
+	// it's all or nothing
+	exported.Conversation.ConversationId = uuid.New()
+	for i := range exported.Messages {
+		exported.Messages[i].ConversationId, exported.Messages[i].MessageId = exported.Conversation.ConversationId, uuid.New()
+	}
+	exported.Attachments[0].MessageId = uuid.New()
+	if _, err := other.ImportConversation("Miss_Take", *exported); !errors.Is(err, ErrInvalidAttachment) {
+		t.Fatalf("an attachment on a message that isn't imported should be ErrInvalidAttachment, got %v", err)
+	}
+	if _, err := other.FindConversation(exported.Conversation.ConversationId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("nothing should be imported when part of it can't be, got %v", err)
+	}
+}
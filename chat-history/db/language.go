@@ -0,0 +1,29 @@
+package db
+
+import (
+	"chat-history/language"
+	"chat-history/structs"
+
+	"gorm.io/gorm"
+)
+
+// detectLanguage sets the language of m from its content. Call it before m is sealed
+func detectLanguage(m *structs.Message) {
+	m.Language = language.Detect(m.Content)
+}
+
+// updateLanguage sets the language of the conversation to the one most of its messages are in, and returns it.
+// Of languages as common the latest message's wins. Messages whose language couldn't be told don't count
+func updateLanguage(tx *gorm.DB, conversationId any) (string, error) {
+	var lang string
+	err := tx.Model(&structs.Message{}).Select("language").
+		Where("conversation_id = ? AND language <> ''", conversationId).
+		Group("language").Order("COUNT(*) DESC").Order("MAX(id) DESC").Limit(1).
+		Scan(&lang).Error
+	if err != nil {
+		return "", err
+	}
+	// it's not a change of the conversation, its updated_at stays
+	err = tx.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).UpdateColumn("language", lang).Error
+	return lang, err
+}
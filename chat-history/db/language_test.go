@@ -0,0 +1,91 @@
+package db
+
+import (
+	"chat-history/structs"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationLanguage(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	// the language is detected before the content is encrypted
+	s := d.open(t, testKey)
+
+	convoId := uuid.New()
+	first := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "How many transactions were flagged as fraud?", Role: structs.UserRole}
+	convo, err := s.CreateConversation(USER, "fraud", first)
+	if err != nil || convo.Language != "en" {
+		t.Fatalf("the conversation should be in English. Got %q, %v", convo.Language, err)
+	}
+	language := func() string {
+		t.Helper()
+		found, err := s.FindConversation(convoId.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found.Language
+	}
+
+	// the language of most messages wins, the ones it can't tell don't count
+	for _, content := range []string{"¿Cuántas transacciones fueron marcadas como fraude?", "Hay 12 transacciones marcadas en la cuenta", "12", "ok"} {
+		msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+		if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := language(); got != "es" {
+		t.Fatalf("most messages are in Spanish. Got %q", got)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil || messages[0].Language != "en" || messages[1].Language != "es" || messages[3].Language != "" {
+		t.Fatalf("each message should have its own language. Got %+v, %v", messages, err)
+	}
+
+	// editing a message changes its language
+	if _, err := s.EditMessage(USER, convoId.String(), messages[1].MessageId.String(), "How many of them are in my account?", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got := language(); got != "en" {
+		t.Fatalf("the edited message is in English, so most messages are. Got %q", got)
+	}
+
+	// a streamed reply gets its language as it's saved
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Il y a ", Role: structs.SystemRole, Incomplete: true}
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	reply.Content, reply.Incomplete = "Il y a douze transactions dans le compte, et elles sont toutes signalées", false
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ = s.GetConversation(USER, convoId.String())
+	if last := messages[len(messages)-1]; last.Language != "fr" {
+		t.Fatalf("the streamed reply should be in French. Got %q", last.Language)
+	}
+}
+
+func TestListConversations_Language(t *testing.T) {
+	s := newTestStore(t)
+	for _, content := range []string{"How many accounts are there?", "Wie viele Konten gibt es und wer ist der Besitzer?", "What is the biggest transaction?", "12"} {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+		if _, err := s.CreateConversation(USER, content, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for lang, want := range map[string]int{"": 4, "en": 2, "de": 1, "fr": 0} {
+		convos, _, err := s.ListConversations(USER, ListOptions{Language: lang})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(convos) != want {
+			t.Fatalf("there should be %d conversations in %q. Got %d", want, lang, len(convos))
+		}
+		for _, c := range convos {
+			if lang != "" && c.Language != lang {
+				t.Fatalf("conversation %q should be in %q. It's in %q", c.Name, lang, c.Language)
+			}
+		}
+	}
+}
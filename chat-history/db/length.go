@@ -0,0 +1,58 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+)
+
+// ErrMessageTooLong is returned, wrapped with the limit, for a message over MaxMessageChars with RejectLong
+var ErrMessageTooLong = errors.New("the message is too long")
+
+// LengthPolicy is what happens to a message over MaxMessageChars
+type LengthPolicy int
+
+const (
+	// RejectLong doesn't write it, and returns an error wrapping ErrMessageTooLong
+	RejectLong LengthPolicy = iota
+	// TruncateLong writes the start of it, ending with TruncationMarker, so it's no longer than the limit
+	TruncateLong
+)
+
+func (p LengthPolicy) String() string {
+	if p == TruncateLong {
+		return "truncate"
+	}
+	return "reject"
+}
+
+// TruncationMarker ends the content of messages TruncateLong cut short
+const TruncationMarker = " […truncated]"
+
+// limitLength returns the content of the message with the id, cut down to the store's MaxMessageChars with
+// TruncateLong, or an error wrapping ErrMessageTooLong with RejectLong. Content within the limit is returned as it is
+func (s *sqliteStore) limitLength(conversationId, messageId, content string) (string, error) {
+	if s.maxMessageChars <= 0 {
+		return content, nil
+	}
+	chars := utf8.RuneCountInString(content)
+	if chars <= s.maxMessageChars {
+		return content, nil
+	}
+	slog.Warn("a message is over the length limit", "conversation_id", conversationId, "message_id", messageId,
+		"chars", chars, "max_chars", s.maxMessageChars, "policy", s.lengthPolicy)
+	if s.lengthPolicy != TruncateLong {
+		return "", fmt.Errorf("%w: it's %d characters, the most is %d", ErrMessageTooLong, chars, s.maxMessageChars)
+	}
+
+	keep := max(s.maxMessageChars-utf8.RuneCountInString(TruncationMarker), 0)
+	runes := 0
+	for i := range content {
+		if runes == keep {
+			return content[:i] + TruncationMarker, nil
+		}
+		runes++
+	}
+	return content, nil
+}
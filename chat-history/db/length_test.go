@@ -0,0 +1,77 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func newLengthStore(t *testing.T, n int, policy LengthPolicy) ConversationStore {
+	t.Helper()
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), MaxMessageChars(n, policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMaxMessageChars_Reject(t *testing.T) {
+	s := newLengthStore(t, 50, RejectLong)
+	convoId := seedConversation(t, s, USER)
+	message := func(content string) structs.Message {
+		return structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+	}
+
+	// up to the limit is fine, characters rather than bytes
+	if _, err := s.AppendMessage(message(strings.Repeat("é", 50)), AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.AppendMessage(message(strings.Repeat("a", 51)), AnyVersion)
+	if !errors.Is(err, ErrMessageTooLong) || !strings.Contains(err.Error(), "51") {
+		t.Fatalf("expected ErrMessageTooLong with the length, got: %v", err)
+	}
+	if messages, _ := s.GetConversation(USER, convoId.String()); len(messages) != 2 {
+		t.Fatalf("the long message shouldn't be stored. The conversation has %d messages", len(messages))
+	}
+
+	long := message(strings.Repeat("a", 51))
+	long.ConversationId = uuid.New()
+	if _, err := s.CreateConversation(USER, "convo", long); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected ErrMessageTooLong, got: %v", err)
+	}
+	messages, _ := s.GetConversation(USER, convoId.String())
+	if _, err := s.EditMessage(USER, convoId.String(), messages[0].MessageId.String(), strings.Repeat("a", 51), AnyVersion); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected ErrMessageTooLong, got: %v", err)
+	}
+}
+
+func TestMaxMessageChars_Truncate(t *testing.T) {
+	s := newLengthStore(t, 50, TruncateLong)
+	convoId := seedConversation(t, s, USER)
+	short := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "short", Role: structs.UserRole}
+	long := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: strings.Repeat("日本", 40), Role: structs.UserRole}
+	for _, m := range []structs.Message{short, long} {
+		if _, err := s.AppendMessage(m, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("both messages should be stored. Got %d, %v", len(messages), err)
+	}
+	if messages[1].Content != "short" {
+		t.Fatalf("a message within the limit should be stored as it is. It's %q", messages[1].Content)
+	}
+	stored := messages[2].Content
+	if utf8.RuneCountInString(stored) != 50 || !strings.HasSuffix(stored, TruncationMarker) || !strings.HasPrefix(long.Content, strings.TrimSuffix(stored, TruncationMarker)) {
+		t.Fatalf("the long message should be cut down to 50 characters ending with the marker. It's %q", stored)
+	}
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrTooManyConversations is returned, wrapped with the limit, when a user already has as many conversations as
+// MaxConversations allows
+var ErrTooManyConversations = errors.New("the user has too many conversations")
+
+// checkConversationLimit returns an error wrapping ErrTooManyConversations if the user can't start another
+// conversation. The store must be locked, so no other conversation is started between the count and the create
+func (s *sqliteStore) checkConversationLimit(tx *gorm.DB, userId string) error {
+	if s.maxConversations <= 0 {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&structs.Conversation{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(s.maxConversations) {
+		return fmt.Errorf("%w: %s already has %d, the most a user can have. Delete some to start new ones",
+			ErrTooManyConversations, userId, s.maxConversations)
+	}
+	return nil
+}
+
+func (s *sqliteStore) WithoutConversationLimit() ConversationStore {
+	c := *s
+	c.maxConversations = 0
+	return &c
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newMessage() structs.Message {
+	return structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole}
+}
+
+func TestMaxConversations(t *testing.T) {
+	s := newArchiveStore(t, MaxConversations(2))
+	first, err := s.CreateConversation(USER, "first", newMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exactly at the limit
+	if _, _, err := s.CreateConversationOnce(USER, "key", "second", newMessage(), time.Hour); err != nil {
+		t.Fatalf("the user should be able to have 2 conversations. Got %v", err)
+	}
+
+	// one over, however it's started
+	messages, err := s.GetConversation(USER, first.ConversationId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := s.CreateTemplate(structs.Template{UserId: USER, Title: "t", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	over := map[string]error{}
+	_, over["CreateConversation"] = s.CreateConversation(USER, "third", newMessage())
+	_, _, over["CreateConversationOnce"] = s.CreateConversationOnce(USER, "other-key", "third", newMessage(), time.Hour)
+	_, over["ForkConversation"] = s.ForkConversation(USER, first.ConversationId.String(), messages[0].MessageId.String(), USER)
+	_, over["CreateConversationFromTemplate"] = s.CreateConversationFromTemplate(USER, template.TemplateId.String(), "")
+	imported := newMessage()
+	_, over["BulkAppendMessages"] = s.BulkAppendMessages(USER, imported.ConversationId.String(), "imported", []structs.Message{imported})
+	for method, err := range over {
+		if !errors.Is(err, ErrTooManyConversations) {
+			t.Fatalf("%s should return ErrTooManyConversations. It returned: %v", method, err)
+		}
+	}
+	if convos, _, err := s.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 2 {
+		t.Fatalf("no conversation should have been started. Got %d, %v", len(convos), err)
+	}
+
+	// what the user already has can still be added to, and a retry gets the conversation it started
+	if _, err := s.AppendMessage(structs.Message{ConversationId: first.ConversationId, MessageId: uuid.New(), Content: "more", Role: structs.UserRole}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, created, err := s.CreateConversationOnce(USER, "key", "second", newMessage(), time.Hour); err != nil || created {
+		t.Fatalf("a retry should get the conversation it started. Got %v, %v", created, err)
+	}
+	// the limit is per user
+	if _, err := s.CreateConversation("Miss_Take", "theirs", newMessage()); err != nil {
+		t.Fatal(err)
+	}
+	// and admins don't have it
+	if _, err := s.WithoutConversationLimit().CreateConversation(USER, "third", newMessage()); err != nil {
+		t.Fatalf("the store without the limit should start the conversation. Got %v", err)
+	}
+}
+
+func TestMaxConversations_TrashAndArchive(t *testing.T) {
+	s := newArchiveStore(t, MaxConversations(2))
+	trashed := seedConversation(t, s, USER)
+	archived := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, trashed.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArchiveConversation(USER, archived.String()); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := s.CreateConversation(USER, "new", newMessage()); err != nil {
+			t.Fatalf("conversations in the trash and the archive shouldn't count. Got %v", err)
+		}
+	}
+	if _, err := s.CreateConversation(USER, "new", newMessage()); !errors.Is(err, ErrTooManyConversations) {
+		t.Fatalf("expected ErrTooManyConversations, got: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrMaintenanceRunning is returned by RunMaintenance while another run hasn't finished
+var ErrMaintenanceRunning = errors.New("maintenance is already running")
+
+// Maintainer is implemented by stores that can compact their database on demand
+type Maintainer interface {
+	// RunMaintenance checkpoints the WAL, rebuilds the database without its free pages and refreshes the
+	// query planner's statistics. Only one run happens at a time, the others get ErrMaintenanceRunning
+	RunMaintenance() (*MaintenanceReport, error)
+}
+
+// MaintenanceReport is what a maintenance run did. The sizes are the database's, without its WAL
+type MaintenanceReport struct {
+	SizeBefore int64 `json:"size_before_bytes"`
+	SizeAfter  int64 `json:"size_after_bytes"`
+	// Freed is how much smaller the database is, it's 0 if it didn't shrink
+	Freed int64 `json:"freed_bytes"`
+	// FreePages is how many unused pages there were before it was compacted
+	FreePages  int64 `json:"free_pages"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// RunMaintenance runs each step as its own statement, so the reads waiting on the connection get it between
+// them. VACUUM is the only long one, SQLite has to rewrite the whole file
+func (s *sqliteStore) RunMaintenance() (*MaintenanceReport, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if !s.maintenance.TryLock() {
+		return nil, ErrMaintenanceRunning
+	}
+	defer s.maintenance.Unlock()
+
+	start := time.Now()
+	report := &MaintenanceReport{}
+	var err error
+	// VACUUM copies the database through the WAL, it's checkpointed first so it doesn't hold both copies
+	if err := checkpoint(s.db); err != nil {
+		return nil, err
+	}
+	if report.SizeBefore, report.FreePages, err = databaseSize(s.db); err != nil {
+		return nil, err
+	}
+	for _, step := range []string{"VACUUM", "ANALYZE"} {
+		if err := s.db.Exec(step).Error; err != nil {
+			return nil, err
+		}
+	}
+	// the file only shrinks once the rebuilt pages are copied back from the WAL
+	if err := checkpoint(s.db); err != nil {
+		return nil, err
+	}
+	if report.SizeAfter, _, err = databaseSize(s.db); err != nil {
+		return nil, err
+	}
+	report.Freed = max(report.SizeBefore-report.SizeAfter, 0)
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+// checkpoint copies the WAL into the database and truncates it. In-memory databases have no WAL, it does nothing
+func checkpoint(db *gorm.DB) error {
+	return db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error
+}
+
+// databaseSize is the size of the database's pages and how many of them are free
+func databaseSize(db *gorm.DB) (size, free int64, err error) {
+	var pages, pageSize int64
+	if err := db.Raw("PRAGMA page_count").Scan(&pages).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := db.Raw("PRAGMA page_size").Scan(&pageSize).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := db.Raw("PRAGMA freelist_count").Scan(&free).Error; err != nil {
+		return 0, 0, err
+	}
+	return pages * pageSize, free, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"chat-history/structs"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRunMaintenance(t *testing.T) {
+	tmp := t.TempDir()
+	pth := tmp + "/" + DB_NAME
+	s, err := NewSQLiteStore(pth, tmp+"/test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	content := strings.Repeat("How many accounts were flagged as fraud last month? ", 80)
+	for range 50 {
+		convoId := seedConversation(t, s, "Miss_Take")
+		for range 10 {
+			msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+			if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	keep := seedConversation(t, s, USER)
+	if _, err := s.DeleteAllForUser("Miss_Take"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint(s.(*sqliteStore).db); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.(Maintainer).RunMaintenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("the file should shrink. It was %d bytes, it's %d", before.Size(), after.Size())
+	}
+	if report.SizeBefore != before.Size() || report.SizeAfter != after.Size() || report.Freed != before.Size()-after.Size() || report.FreePages == 0 {
+		t.Fatalf("the report should have the file's sizes, %d and %d. Got %+v", before.Size(), after.Size(), report)
+	}
+	if messages, err := s.GetConversation(USER, keep.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("the other conversations should be kept. Got %v, %v", messages, err)
+	}
+
+	// a second run has nothing left to free
+	if report, err := s.(Maintainer).RunMaintenance(); err != nil || report.Freed != 0 {
+		t.Fatalf("nothing should be freed the second time. Got %+v, %v", report, err)
+	}
+}
+
+func TestRunMaintenance_OneAtATime(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	s.maintenance.Lock()
+	if _, err := s.RunMaintenance(); !errors.Is(err, ErrMaintenanceRunning) {
+		t.Fatalf("a run should be refused while another is going. Got %v", err)
+	}
+	// copies made by WithContext share the lock
+	if _, err := s.WithContext(context.Background()).(Maintainer).RunMaintenance(); !errors.Is(err, ErrMaintenanceRunning) {
+		t.Fatalf("a run should be refused while another is going. Got %v", err)
+	}
+	s.maintenance.Unlock()
+	if _, err := s.RunMaintenance(); err != nil {
+		t.Fatalf("it should run once the other is done. Got %v", err)
+	}
+}
+
+func TestRunMaintenance_ReadOnly(t *testing.T) {
+	tmp := t.TempDir()
+	pth := tmp + "/" + DB_NAME
+	s, err := NewSQLiteStore(pth, tmp+"/test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	ro, err := NewSQLiteStore(pth, tmp+"/test.log", ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ro.Close() })
+	if _, err := ro.(Maintainer).RunMaintenance(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("a read-only store can't be compacted. Got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// MemoryPath is the dbPath of a database that's kept in memory instead of a file, see NewMemoryStore
+const MemoryPath = ":memory:"
+
+var errMemoryReadOnly = errors.New("an in-memory database can't be read-only, it starts empty")
+
+// NewMemoryStore opens a store whose database is only kept in memory, for tests and demos that don't
+// want a file on disk. It's the SQLite store on an in-memory database, so it behaves the same way.
+// Each one starts empty and is private to the store, it's gone once the store is closed
+func NewMemoryStore(logPath string, opts ...Option) (ConversationStore, error) {
+	return openSQLiteStore(MemoryPath, logPath, opts...)
+}
+
+// memoryDSN names a new in-memory database. Every connection to a plain :memory: gets a database of
+// its own, connections to a named one with a shared cache all get the same one
+func memoryDSN(params url.Values) string {
+	params.Set("mode", "memory")
+	params.Set("cache", "shared")
+	return "file:memory-" + uuid.NewString() + "?" + params.Encode()
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newMemoryStore(t *testing.T, opts ...Option) ConversationStore {
+	t.Helper()
+	s, err := NewMemoryStore(t.TempDir()+"/test.log", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// coreOperations runs the same operations on s with the same ids and describes what each of them returned
+func coreOperations(t *testing.T, s ConversationStore) []string {
+	t.Helper()
+	var log []string
+	record := func(op string, err error, result ...any) {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			log = append(log, op+": not found")
+		case errors.Is(err, ErrVersionConflict):
+			log = append(log, op+": version conflict")
+		case errors.Is(err, ErrInvalidMessages):
+			log = append(log, op+": invalid")
+		case err != nil:
+			t.Fatalf("%s: %v", op, err)
+		default:
+			log = append(log, strings.TrimSpace(fmt.Sprintln(append([]any{op + ":"}, result...)...)))
+		}
+	}
+	convos := []uuid.UUID{uuid.MustParse("0192f0a0-0000-7000-8000-000000000001"), uuid.MustParse("0192f0a0-0000-7000-8000-000000000002")}
+	messageId := func(i int) uuid.UUID { return uuid.MustParse(fmt.Sprintf("0192f0a0-0000-7000-8000-1000000000%02d", i)) }
+
+	for i, id := range convos {
+		convo, err := s.CreateConversation(USER, fmt.Sprint("convo ", i), structs.Message{ConversationId: id, MessageId: messageId(i), Content: "How many accounts are flagged?", Role: structs.UserRole})
+		if err != nil {
+			t.Fatal(err)
+		}
+		record("create", err, convo.Name, convo.Version)
+	}
+	_, err := s.CreateConversation(USER, "empty", structs.Message{MessageId: uuid.New(), Role: structs.UserRole})
+	record("create without content", err)
+
+	convo, err := s.AppendMessage(structs.Message{ConversationId: convos[0], MessageId: messageId(10), ParentId: &[]uuid.UUID{messageId(0)}[0], Content: "12 accounts are flagged", Role: structs.SystemRole}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record("append", err, convo.Version)
+	_, err = s.AppendMessage(structs.Message{ConversationId: convos[0], MessageId: messageId(11), Content: "stale", Role: structs.UserRole}, 0)
+	record("append stale", err)
+	edited, err := s.EditMessage(USER, convos[0].String(), messageId(0).String(), "How many accounts are flagged as fraud?", AnyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record("edit", err, edited.Content)
+	revisions, err := s.ListRevisions(USER, convos[0].String(), messageId(0).String())
+	record("revisions", err, len(revisions))
+
+	messages, err := s.GetConversation(USER, convos[0].String())
+	for _, m := range messages {
+		record("message", err, m.MessageId, m.Role, m.Content)
+	}
+	messages, err = s.GetConversation("Miss_Take", convos[0].String())
+	record("someone else's", err, len(messages))
+
+	record("rename", s.RenameConversation(convos[1].String(), "renamed"))
+	record("tag", s.AddTag(USER, convos[1].String(), "fraud"))
+	list, _, err := s.ListConversations(USER, ListOptions{})
+	for _, c := range list {
+		record("list", err, c.ConversationId, c.Name, c.Tags)
+	}
+	tagged, _, err := s.ListConversations(USER, ListOptions{Tags: []string{"fraud"}})
+	record("list tagged", err, len(tagged))
+
+	results, err := s.SearchMessages(USER, "flagged")
+	record("search", err, len(results))
+
+	record("delete", s.DeleteConversation(USER, convos[1].String()))
+	list, _, err = s.ListConversations(USER, ListOptions{})
+	record("list after delete", err, len(list))
+	record("restore", s.RestoreConversation(USER, convos[1].String(), 30*24*time.Hour))
+	_, err = s.FindConversation(uuid.NewString())
+	record("find missing", err)
+	return log
+}
+
+func TestMemoryStore_MatchesSQLite(t *testing.T) {
+	tmp := t.TempDir()
+	file, err := NewSQLiteStore(tmp+"/"+DB_NAME, tmp+"/test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	want := coreOperations(t, file)
+	got := coreOperations(t, newMemoryStore(t))
+	if !slices.Equal(got, want) {
+		t.Fatalf("the memory store should behave like the SQLite store.\nmemory: %q\nsqlite: %q", got, want)
+	}
+}
+
+func TestMemoryStore_Private(t *testing.T) {
+	s := newMemoryStore(t)
+	seedConversation(t, s, USER)
+
+	// another memory store doesn't see its conversations
+	convos, _, err := newMemoryStore(t).ListConversations(USER, ListOptions{})
+	if err != nil || len(convos) != 0 {
+		t.Fatalf("a new memory store should be empty. Got %v, %v", convos, err)
+	}
+	if convos, _, _ := s.ListConversations(USER, ListOptions{}); len(convos) != 1 {
+		t.Fatalf("the store should keep its conversation. Got %v", convos)
+	}
+
+	if _, err := NewMemoryStore(t.TempDir()+"/test.log", ReadOnly()); !errors.Is(err, errMemoryReadOnly) {
+		t.Fatalf("a read-only memory store should be refused. Got %v", err)
+	}
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	s := newMemoryStore(t)
+	convoId := seedConversation(t, s, USER)
+
+	const writers, appends = 8, 10
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range appends {
+				msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "more", Role: structs.UserRole}
+				if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.GetConversation(USER, convoId.String()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil || len(messages) != 1+writers*appends {
+		t.Fatalf("every append should be kept, want %d messages. Got %d, %v", 1+writers*appends, len(messages), err)
+	}
+}
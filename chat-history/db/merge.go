@@ -0,0 +1,138 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrMergeSelf is returned when a conversation is merged into itself
+var ErrMergeSelf = errors.New("a conversation can't be merged into itself")
+
+// mergeKey identifies the same message in two conversations, i.e., two imports of the same export.
+// create_ts is compared to the nanosecond
+type mergeKey struct {
+	role      structs.MessagengerRole
+	content   string
+	createdAt int64
+}
+
+func keyOf(m structs.Message) mergeKey {
+	return mergeKey{role: m.Role, content: m.Content, createdAt: m.CreatedAt.UnixNano()}
+}
+
+func (s *sqliteStore) MergeConversations(userId, targetId, sourceId string) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if targetId == sourceId {
+		return nil, ErrMergeSelf
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var target, source structs.Conversation
+	for id, convo := range map[string]*structs.Conversation{targetId: &target, sourceId: &source} {
+		tx := s.db.Where("user_id = ? AND conversation_id = ?", userId, id).First(convo)
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		} else if tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+
+	var targetMessages, sourceMessages []structs.Message
+	if err := s.db.Where("conversation_id = ?", target.ConversationId).Order("id").Find(&targetMessages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("conversation_id = ?", source.ConversationId).Order("id").Find(&sourceMessages).Error; err != nil {
+		return nil, err
+	}
+	// compared in plaintext, and moved as they're stored since they're sealed with their message id
+	opened := func(messages []structs.Message) ([]structs.Message, error) {
+		plain := append([]structs.Message{}, messages...)
+		return plain, s.sealer.openMessages(plain)
+	}
+	plainTarget, err := opened(targetMessages)
+	if err != nil {
+		return nil, err
+	}
+	plainSource, err := opened(sourceMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[mergeKey]uuid.UUID{}
+	for _, m := range plainTarget {
+		if _, ok := existing[keyOf(m)]; !ok {
+			existing[keyOf(m)] = m.MessageId
+		}
+	}
+	// replies to a duplicate reply to the target's copy of it, and the first message to the target's last
+	var last *uuid.UUID
+	if len(targetMessages) > 0 {
+		last = &targetMessages[len(targetMessages)-1].MessageId
+	}
+	duplicates := map[uuid.UUID]uuid.UUID{}
+	moved := []structs.Message{}
+	for i, m := range plainSource {
+		if id, ok := existing[keyOf(m)]; ok {
+			duplicates[m.MessageId] = id
+			continue
+		}
+		message := sourceMessages[i]
+		if message.ParentId == nil {
+			message.ParentId = last
+		} else if id, ok := duplicates[*message.ParentId]; ok {
+			message.ParentId = &id
+		}
+		moved = append(moved, message)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, target.ConversationId, AnyVersion); err != nil {
+			return err
+		}
+		if len(moved) > 0 {
+			ids := make([]uuid.UUID, len(moved))
+			for i := range moved {
+				ids[i] = moved[i].MessageId
+			}
+			// written again so they get ids after the target's, and are read after its messages
+			if err := tx.Unscoped().Where("message_id IN ?", ids).Delete(&structs.Message{}).Error; err != nil {
+				return err
+			}
+			for i := range moved {
+				moved[i].ID = 0
+				moved[i].ConversationId = target.ConversationId
+			}
+			if err := tx.CreateInBatches(moved, 100).Error; err != nil {
+				return err
+			}
+			for _, model := range []any{&structs.MessageEmbedding{}, &structs.MessageContext{}, &structs.MessageFeedback{}, &structs.LLMExchange{}} {
+				if err := tx.Model(model).Where("message_id IN ?", ids).Update("conversation_id", target.ConversationId).Error; err != nil {
+					return err
+				}
+			}
+			if _, err := updateLanguage(tx, target.ConversationId); err != nil {
+				return err
+			}
+			if _, err := updateTokenCount(tx, target.ConversationId); err != nil {
+				return err
+			}
+		}
+		// what's left of the source is its duplicates, and what's only on the conversation
+		return deleteConversationRows(tx, source.ConversationId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := structs.Conversation{}
+	if err := s.db.Where("conversation_id = ?", target.ConversationId).First(&merged).Error; err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type mergeMessage struct {
+	role    structs.MessagengerRole
+	content string
+	// seconds after base
+	at int
+}
+
+var mergeBase = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// importConversation creates a conversation of the user with the messages, each replying to the one before,
+// with new ids like another import of the same export would have
+func importConversation(t *testing.T, s ConversationStore, userId string, messages ...mergeMessage) uuid.UUID {
+	t.Helper()
+	convoId := uuid.New()
+	var parent *uuid.UUID
+	for i, m := range messages {
+		msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: parent, Role: m.role, Content: m.content}
+		msg.CreatedAt = mergeBase.Add(time.Duration(m.at) * time.Second)
+		var err error
+		if i == 0 {
+			_, err = s.CreateConversation(userId, "imported", msg)
+		} else {
+			_, err = s.AppendMessage(msg, AnyVersion)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = &msg.MessageId
+	}
+	return convoId
+}
+
+// checkMerged checks the conversation has the messages, in order, each replying to the one before
+func checkMerged(t *testing.T, s ConversationStore, convoId uuid.UUID, want []string) []structs.Message {
+	t.Helper()
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(messages))
+	for i, m := range messages {
+		got[i] = m.Content
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		if i > 0 && (messages[i].ParentId == nil || *messages[i].ParentId != messages[i-1].MessageId) {
+			t.Fatalf("%q should reply to %q", got[i], got[i-1])
+		}
+	}
+	return messages
+}
+
+func TestMergeConversations_Overlapping(t *testing.T) {
+	// contents are compared in plaintext
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	question := mergeMessage{structs.UserRole, "What is GSQL?", 0}
+	answer := mergeMessage{structs.SystemRole, "TigerGraph's query language", 1}
+	target := importConversation(t, s, USER, question, answer)
+	// the export again, after the conversation went on. The same question asked later isn't a duplicate,
+	// and neither is the same content from another role at the same time
+	source := importConversation(t, s, USER, question, answer,
+		mergeMessage{structs.UserRole, "What is GSQL?", 10},
+		mergeMessage{structs.SystemRole, "What is GSQL?", 10},
+		mergeMessage{structs.UserRole, "Thanks", 20},
+	)
+	sourceMessages, _ := s.GetConversation(USER, source.String())
+	if _, err := s.AddAttachment(USER, source.String(), sourceMessages[4].MessageId.String(), testAttachment("graph.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEmbedding(structs.MessageEmbedding{MessageId: sourceMessages[4].MessageId, Model: "embed", Vector: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := s.FindConversation(target.String())
+
+	merged, err := s.MergeConversations(USER, target.String(), source.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ConversationId != target || merged.Version != before.Version+1 {
+		t.Fatalf("the merge should change the target's version, got %+v", merged)
+	}
+	messages := checkMerged(t, s, target, []string{"What is GSQL?", "TigerGraph's query language", "What is GSQL?", "What is GSQL?", "Thanks"})
+	if messages[4].MessageId != sourceMessages[4].MessageId || !messages[4].CreatedAt.Equal(mergeBase.Add(20*time.Second)) {
+		t.Fatalf("moved messages should keep their id and create_ts, got %+v", messages[4])
+	}
+
+	// what was on the moved messages moved with them, and the source is gone
+	if attachments, err := s.ListAttachments(USER, target.String(), ""); err != nil || len(attachments) != 1 {
+		t.Fatalf("the attachment should have moved with its message. Got %+v, %v", attachments, err)
+	}
+	if similar, err := s.RetrieveSimilar(target.String(), []float32{1, 0}, "embed", 1); err != nil || len(similar) != 1 {
+		t.Fatalf("the embedding should have moved with its message. Got %+v, %v", similar, err)
+	}
+	if _, err := s.FindConversation(source.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the source should be deleted, got %v", err)
+	}
+	var left int64
+	s.(*sqliteStore).db.Unscoped().Model(&structs.Message{}).Where("conversation_id = ?", source).Count(&left)
+	if left != 0 {
+		t.Fatalf("the source's duplicates should be deleted, %d are left", left)
+	}
+}
+
+func TestMergeConversations_Disjoint(t *testing.T) {
+	s := newTestStore(t)
+	target := importConversation(t, s, USER, mergeMessage{structs.UserRole, "hello", 0}, mergeMessage{structs.SystemRole, "hi", 1})
+	// older than the target, it's still appended after it
+	source := importConversation(t, s, USER, mergeMessage{structs.UserRole, "first", -20}, mergeMessage{structs.SystemRole, "second", -10})
+
+	if _, err := s.MergeConversations(USER, target.String(), source.String()); err != nil {
+		t.Fatal(err)
+	}
+	checkMerged(t, s, target, []string{"hello", "hi", "first", "second"})
+}
+
+func TestMergeConversations_Errors(t *testing.T) {
+	s := newTestStore(t)
+	mine := importConversation(t, s, USER, mergeMessage{structs.UserRole, "hello", 0})
+	other := importConversation(t, s, USER, mergeMessage{structs.UserRole, "hello", 0})
+	theirs := importConversation(t, s, "Miss_Take", mergeMessage{structs.UserRole, "hello", 0})
+
+	for _, tt := range []struct {
+		name           string
+		target, source uuid.UUID
+		userId         string
+		want           error
+	}{
+		{"itself", mine, mine, USER, ErrMergeSelf},
+		{"other user's source", mine, theirs, USER, ErrNotFound},
+		{"other user's target", theirs, mine, USER, ErrNotFound},
+		{"not the user's", mine, other, "Miss_Take", ErrNotFound},
+		{"missing", mine, uuid.New(), USER, ErrNotFound},
+	} {
+		if _, err := s.MergeConversations(tt.userId, tt.target.String(), tt.source.String()); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	// nothing was merged
+	for _, c := range []uuid.UUID{mine, other} {
+		checkMerged(t, s, c, []string{"hello"})
+	}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// the limits of a conversation's metadata: how many keys it has, how long they and their values are in characters,
+// and how big it is as JSON
+const (
+	maxMetadataKeys        = 64
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 1024
+	maxMetadataBytes       = 16 << 10
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// validMetadataKey is whether key is 1 to 64 letters, digits, '_', '-' or '.'. Keys are used in JSON paths and
+// metadata filters, which is why they can't have anything else
+func validMetadataKey(key string) bool {
+	if key == "" || len(key) > maxMetadataKeyLength {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// marshalMetadata checks the metadata is within the limits and returns it as JSON
+func marshalMetadata(metadata map[string]string) (string, error) {
+	if len(metadata) > maxMetadataKeys {
+		return "", fmt.Errorf("%w: it can have at most %d keys", ErrInvalidMetadata, maxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if !validMetadataKey(k) {
+			return "", fmt.Errorf("%w: key %q must be 1 to %d letters, digits, '_', '-' or '.'", ErrInvalidMetadata, k, maxMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(v) > maxMetadataValueLength {
+			return "", fmt.Errorf("%w: the value of %q is longer than %d characters", ErrInvalidMetadata, k, maxMetadataValueLength)
+		}
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxMetadataBytes {
+		return "", fmt.Errorf("%w: it's larger than %d bytes", ErrInvalidMetadata, maxMetadataBytes)
+	}
+	return string(b), nil
+}
+
+func (s *sqliteStore) GetMetadata(userId, conversationId string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convo := structs.Conversation{}
+	err := s.db.Select("metadata").Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if convo.Metadata == nil {
+		return map[string]string{}, nil
+	}
+	return convo.Metadata, nil
+}
+
+func (s *sqliteStore) SetMetadata(userId, conversationId string, updates map[string]*string) (map[string]string, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metadata := map[string]string{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		convo := structs.Conversation{}
+		err := tx.Select("conversation_id", "metadata").Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		maps.Copy(metadata, convo.Metadata)
+		for k, v := range updates {
+			if v == nil {
+				delete(metadata, k)
+			} else {
+				metadata[k] = *v
+			}
+		}
+		value, err := marshalMetadata(metadata)
+		if err != nil {
+			return err
+		}
+		// UpdateColumn like SetConversationModel, it's not activity
+		return tx.Model(&structs.Conversation{}).Where("conversation_id = ?", convo.ConversationId).UpdateColumn("metadata", value).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// withMetadata filters the query to conversations whose metadata has each of the keys set to its value
+func withMetadata(tx *gorm.DB, metadata map[string]string) (*gorm.DB, error) {
+	for k, v := range metadata {
+		if !validMetadataKey(k) {
+			return nil, fmt.Errorf("%w: key %q must be 1 to %d letters, digits, '_', '-' or '.'", ErrInvalidMetadata, k, maxMetadataKeyLength)
+		}
+		tx = tx.Where("json_extract(metadata, ?) = ?", `$."`+k+`"`, v)
+	}
+	return tx, nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func ptr(s string) *string { return &s }
+
+func TestMetadata(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER).String()
+
+	if got, err := s.GetMetadata(USER, convoId); err != nil || len(got) != 0 {
+		t.Fatalf("a new conversation should have no metadata. Got %v, %v", got, err)
+	}
+	before, err := s.FindConversation(convoId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.SetMetadata(USER, convoId, map[string]*string{"source": ptr("crm"), "case_number": ptr("123")})
+	if want := map[string]string{"source": "crm", "case_number": "123"}; err != nil || !maps.Equal(got, want) {
+		t.Fatalf("the metadata should be %v. Got %v, %v", want, got, err)
+	}
+	// only the keys given change, nil removes them
+	got, err = s.SetMetadata(USER, convoId, map[string]*string{"case_number": ptr("456"), "source": nil, "priority": ptr("high")})
+	want := map[string]string{"case_number": "456", "priority": "high"}
+	if err != nil || !maps.Equal(got, want) {
+		t.Fatalf("the metadata should be %v after the partial update. Got %v, %v", want, got, err)
+	}
+	if got, err := s.GetMetadata(USER, convoId); err != nil || !maps.Equal(got, want) {
+		t.Fatalf("the stored metadata should be %v. Got %v, %v", want, got, err)
+	}
+	convo, err := s.FindConversation(convoId)
+	if err != nil || !maps.Equal(convo.Metadata, want) {
+		t.Fatalf("the conversation should have its metadata. Got %+v, %v", convo, err)
+	}
+	if !convo.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Fatalf("setting metadata shouldn't change updated_at. It went from %v to %v", before.UpdatedAt, convo.UpdatedAt)
+	}
+
+	if _, err := s.GetMetadata("Miss_Take", convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be not found. Got %v", err)
+	}
+	if _, err := s.SetMetadata("Miss_Take", convoId, map[string]*string{"source": ptr("crm")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be not found. Got %v", err)
+	}
+}
+
+func TestMetadata_Limits(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER).String()
+
+	tooMany := map[string]*string{}
+	for i := range maxMetadataKeys + 1 {
+		tooMany[fmt.Sprintf("key%d", i)] = ptr("v")
+	}
+	// fill it close to the size limit with keys that are each within theirs
+	tooBig := map[string]*string{}
+	for i := range maxMetadataBytes/maxMetadataValueLength + 1 {
+		tooBig[string(rune('a'+i))] = ptr(strings.Repeat("v", maxMetadataValueLength))
+	}
+	tests := map[string]map[string]*string{
+		"empty key":       {"": ptr("v")},
+		"key with spaces": {"case number": ptr("v")},
+		"key with quotes": {`a"b`: ptr("v")},
+		"long key":        {strings.Repeat("k", maxMetadataKeyLength+1): ptr("v")},
+		"long value":      {"k": ptr(strings.Repeat("v", maxMetadataValueLength+1))},
+		"too many keys":   tooMany,
+		"too big":         tooBig,
+	}
+	for name, updates := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.SetMetadata(USER, convoId, updates); !errors.Is(err, ErrInvalidMetadata) {
+				t.Fatalf("the metadata should return ErrInvalidMetadata. It's: %v", err)
+			}
+		})
+	}
+	if got, err := s.GetMetadata(USER, convoId); err != nil || len(got) != 0 {
+		t.Fatalf("invalid metadata shouldn't be stored. Got %v, %v", got, err)
+	}
+
+	// removing a key brings it back within the limit
+	full := map[string]*string{}
+	for i := range maxMetadataKeys {
+		full[fmt.Sprintf("key%d", i)] = ptr("v")
+	}
+	if _, err := s.SetMetadata(USER, convoId, full); err != nil {
+		t.Fatalf("the most keys there can be should be set. Got %v", err)
+	}
+	if _, err := s.SetMetadata(USER, convoId, map[string]*string{"one_more": ptr("v")}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("a key over the limit should return ErrInvalidMetadata. It's: %v", err)
+	}
+	if _, err := s.SetMetadata(USER, convoId, map[string]*string{"key0": nil, "one_more": ptr("v")}); err != nil {
+		t.Fatalf("replacing a key should be within the limit. Got %v", err)
+	}
+}
+
+func TestListConversations_Metadata(t *testing.T) {
+	s := newTestStore(t)
+	crm := seedConversation(t, s, USER).String()
+	case123 := seedConversation(t, s, USER).String()
+	seedConversation(t, s, USER)
+	s.SetMetadata(USER, crm, map[string]*string{"source": ptr("crm")})
+	s.SetMetadata(USER, case123, map[string]*string{"source": ptr("crm"), "case.number": ptr("123")})
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     []string
+	}{
+		{"one key", map[string]string{"source": "crm"}, []string{case123, crm}},
+		{"two keys", map[string]string{"source": "crm", "case.number": "123"}, []string{case123}},
+		{"other value", map[string]string{"case.number": "456"}, nil},
+		{"missing key", map[string]string{"priority": "crm"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			convos, _, err := s.ListConversations(USER, ListOptions{Metadata: tt.metadata})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range convos {
+				got = append(got, c.ConversationId.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("conversations with metadata %v should be %v. They're: %v", tt.metadata, tt.want, got)
+			}
+		})
+	}
+
+	if _, _, err := s.ListConversations(USER, ListOptions{Metadata: map[string]string{`a"b`: "v"}}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("filtering by an invalid key should return ErrInvalidMetadata. It's: %v", err)
+	}
+}
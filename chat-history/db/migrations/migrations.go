@@ -0,0 +1,109 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned change to the schema. Versions are applied in increasing order
+// and never re-applied, so a released migration must not be edited; add a new one instead
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// SQL returns an Up func that executes the statements in order
+func SQL(statements ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// schemaMigration is a row of schema_migrations, one per applied migration
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Apply runs the migrations that haven't been applied to db yet. They're all run in one
+// transaction, so if one fails none of them are kept and the schema is left as it was
+func Apply(db *gorm.DB, migrations []Migration) error {
+	if err := validate(migrations); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+	pending, err := Pending(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range pending {
+			if err := m.Up(tx); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Pending returns the migrations that haven't been applied to db, in order.
+// All of them are pending if schema_migrations doesn't exist. It doesn't write to db
+func Pending(db *gorm.DB, migrations []Migration) ([]Migration, error) {
+	applied := []int{}
+	if db.Migrator().HasTable(&schemaMigration{}) {
+		var err error
+		if applied, err = Applied(db); err != nil {
+			return nil, err
+		}
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if !slices.Contains(applied, m.Version) {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Applied returns the versions recorded in schema_migrations, oldest first
+func Applied(db *gorm.DB) ([]int, error) {
+	versions := []int{}
+	err := db.Model(&schemaMigration{}).Order("version").Pluck("version", &versions).Error
+	return versions, err
+}
+
+// validate checks that versions are positive and strictly increasing
+func validate(migrations []Migration) error {
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d (%s) is out of order, versions must be increasing", m.Version, m.Name)
+		}
+		last = m.Version
+	}
+	return nil
+}
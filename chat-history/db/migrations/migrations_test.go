@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "test.db")
+	db, err := gorm.Open(sqlite.Open(pth), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// versions returns the versions of the migrations, in order
+func versions(ms ...Migration) []int {
+	v := make([]int, len(ms))
+	for i, m := range ms {
+		v[i] = m.Version
+	}
+	return v
+}
+
+func assertApplied(t *testing.T, db *gorm.DB, want []int) {
+	t.Helper()
+	applied, err := Applied(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(applied, want) {
+		t.Fatalf("applied migrations should be %v. They're: %v", want, applied)
+	}
+}
+
+// later schema changes, on top of the real ones
+var addPinned = Migration{Version: 100, Name: "add pinned", Up: SQL(
+	"ALTER TABLE `conversations` ADD COLUMN `pinned` numeric NOT NULL DEFAULT false",
+)}
+var addTokens = Migration{Version: 101, Name: "add message tokens", Up: SQL(
+	"ALTER TABLE `messages` ADD COLUMN `tokens` integer",
+)}
+
+func TestApply_OldSchema(t *testing.T) {
+	db := openTestDB(t)
+
+	// a file on an old version of the schema, with data in it
+	old := All[:1]
+	if err := Apply(db, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO conversations (user_id, conversation_id, name) VALUES ('sam_pull', 'c1', 'convo')").Error; err != nil {
+		t.Fatal(err)
+	}
+	assertApplied(t, db, []int{1})
+
+	// upgrade it
+	latest := append(slices.Clone(All), addPinned, addTokens)
+	if err := Apply(db, latest); err != nil {
+		t.Fatal(err)
+	}
+
+	m := db.Migrator()
+	if !m.HasColumn("conversations", "pinned") || !m.HasColumn("messages", "tokens") {
+		t.Fatal("the new columns should exist after migrating")
+	}
+	if !m.HasIndex("conversations", "idx_conversations_user_updated") {
+		t.Fatal("pending migrations from All should be applied too")
+	}
+	var name string
+	db.Raw("SELECT name FROM conversations WHERE conversation_id = 'c1'").Scan(&name)
+	if name != "convo" {
+		t.Fatalf("existing rows should be kept. The name is: %q", name)
+	}
+	assertApplied(t, db, versions(latest...))
+
+	// nothing left to do
+	if err := Apply(db, latest); err != nil {
+		t.Fatal(err)
+	}
+	assertApplied(t, db, versions(latest...))
+}
+
+func TestApply_FailureRollsBack(t *testing.T) {
+	db := openTestDB(t)
+	if err := Apply(db, All); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := Migration{Version: 102, Name: "broken", Up: SQL("ALTER TABLE `nope` ADD COLUMN `x` integer")}
+	err := Apply(db, append(slices.Clone(All), addPinned, broken))
+	if err == nil {
+		t.Fatal("a failing migration should return an error")
+	}
+
+	// the migration before the broken one is rolled back with it
+	if db.Migrator().HasColumn("conversations", "pinned") {
+		t.Fatal("migrations in a failed run should not be kept")
+	}
+	assertApplied(t, db, versions(All...))
+}
+
+func TestApply_OutOfOrder(t *testing.T) {
+	db := openTestDB(t)
+	if err := Apply(db, []Migration{addTokens, addPinned}); err == nil {
+		t.Fatal("migrations out of order should return an error")
+	}
+}
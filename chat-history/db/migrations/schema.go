@@ -0,0 +1,289 @@
+package migrations
+
+// All is every migration of the chat history schema, in order
+var All = []Migration{
+	{
+		// the tables as they were created before versioned migrations. IF NOT EXISTS lets this
+		// be recorded as applied on files created by earlier versions without changing them
+		Version: 1,
+		Name:    "create conversations and messages",
+		Up: SQL(
+			"CREATE TABLE IF NOT EXISTS `conversations` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`user_id` text NOT NULL,`conversation_id` text NOT NULL,`name` text,CONSTRAINT `uni_conversations_conversation_id` UNIQUE (`conversation_id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_conversations_deleted_at` ON `conversations`(`deleted_at`)",
+			"CREATE TABLE IF NOT EXISTS `messages` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`conversation_id` text NOT NULL,`message_id` text NOT NULL,`parent_id` text,`model_name` text,`content` text,`role` text,`response_time` real,`feedback` integer,`comment` text,CONSTRAINT `uni_messages_message_id` UNIQUE (`message_id`))",
+			"CREATE INDEX IF NOT EXISTS `idx_messages_deleted_at` ON `messages`(`deleted_at`)",
+		),
+	},
+	{
+		// ListConversations pages by user ordered by updated_at, and GetConversation looks up messages by conversation
+		Version: 2,
+		Name:    "index conversation lookups",
+		Up: SQL(
+			"CREATE INDEX IF NOT EXISTS `idx_conversations_user_updated` ON `conversations`(`user_id`, `updated_at` DESC, `id` DESC)",
+			"CREATE INDEX IF NOT EXISTS `idx_messages_conversation_id` ON `messages`(`conversation_id`)",
+		),
+	},
+	{
+		// conversation_id, tag is the primary key so a tag is only on a conversation once
+		Version: 3,
+		Name:    "create conversation tags",
+		Up: SQL(
+			"CREATE TABLE `conversation_tags` (`conversation_id` text,`tag` text,`created_at` datetime,PRIMARY KEY (`conversation_id`,`tag`))",
+			"CREATE INDEX `idx_conversation_tags_tag` ON `conversation_tags`(`tag`)",
+		),
+	},
+	{
+		// earlier contents of edited messages, numbered from 1 per message
+		Version: 4,
+		Name:    "create message revisions",
+		Up: SQL(
+			"CREATE TABLE `message_revisions` (`id` integer PRIMARY KEY AUTOINCREMENT,`message_id` text NOT NULL,`revision` integer NOT NULL,`content` text,`created_at` datetime)",
+			"CREATE UNIQUE INDEX `idx_message_revisions_message_revision` ON `message_revisions`(`message_id`,`revision`)",
+		),
+	},
+	{
+		// read-only links to conversations. Tokens are signed from the row, so only the row is stored
+		Version: 5,
+		Name:    "create share links",
+		Up: SQL(
+			"CREATE TABLE `share_links` (`share_id` text,`conversation_id` text NOT NULL,`user_id` text NOT NULL,`expires_at` datetime,`revoked_at` datetime,`created_at` datetime,PRIMARY KEY (`share_id`))",
+			"CREATE INDEX `idx_share_links_conversation_id` ON `share_links`(`conversation_id`)",
+		),
+	},
+	{
+		// metadata of the files and images on messages, the files are stored elsewhere
+		Version: 6,
+		Name:    "create attachments",
+		Up: SQL(
+			"CREATE TABLE `attachments` (`id` integer PRIMARY KEY AUTOINCREMENT,`attachment_id` text NOT NULL,`message_id` text NOT NULL,`filename` text,`content_type` text,`size` integer,`storage_url` text,`created_at` datetime,CONSTRAINT `uni_attachments_attachment_id` UNIQUE (`attachment_id`))",
+			"CREATE INDEX `idx_attachments_message_id` ON `attachments`(`message_id`)",
+		),
+	},
+	{
+		// incremented by every write to the messages, so clients can tell when their copy is out of date
+		Version: 7,
+		Name:    "add conversation versions",
+		Up:      SQL("ALTER TABLE `conversations` ADD COLUMN `version` integer NOT NULL DEFAULT 0"),
+	},
+	{
+		// the conversations created by requests with an Idempotency-Key, so retries don't create another
+		Version: 8,
+		Name:    "create idempotency keys",
+		Up: SQL(
+			"CREATE TABLE `idempotency_keys` (`user_id` text,`key` text,`conversation_id` text NOT NULL,`created_at` datetime,PRIMARY KEY (`user_id`,`key`))",
+			"CREATE INDEX `idx_idempotency_keys_created_at` ON `idempotency_keys`(`created_at`)",
+		),
+	},
+	{
+		// messages the user marked as important
+		Version: 9,
+		Name:    "add pinned messages",
+		Up:      SQL("ALTER TABLE `messages` ADD COLUMN `pinned` numeric NOT NULL DEFAULT false"),
+	},
+	{
+		// conversations can use another model than llm_config.model_name
+		Version: 10,
+		Name:    "add conversation models",
+		Up:      SQL("ALTER TABLE `conversations` ADD COLUMN `model_name` text"),
+	},
+	{
+		// users other than the owner who can read or write a conversation
+		Version: 11,
+		Name:    "create conversation access",
+		Up: SQL(
+			"CREATE TABLE `conversation_access` (`conversation_id` text,`user_id` text,`permission` text NOT NULL,`granted_by` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`conversation_id`,`user_id`))",
+			"CREATE INDEX `idx_conversation_access_user_id` ON `conversation_access`(`user_id`)",
+		),
+	},
+	{
+		// lookups of a page of conversations' messages, like ListConversations' counts, skip soft deleted ones.
+		// With only conversation_id indexed SQLite can pick idx_messages_deleted_at for them and scan every message.
+		// This covers the old index, which is dropped so writes don't update both
+		Version: 12,
+		Name:    "index messages by conversation and deleted_at",
+		Up: SQL(
+			"CREATE INDEX `idx_messages_conversation_deleted` ON `messages`(`conversation_id`, `deleted_at`)",
+			"DROP INDEX IF EXISTS `idx_messages_conversation_id`",
+		),
+	},
+	{
+		// conversations forked from another one point to it
+		Version: 13,
+		Name:    "add conversation parents",
+		Up:      SQL("ALTER TABLE `conversations` ADD COLUMN `parent_id` text"),
+	},
+	{
+		// streamed replies are saved as they arrive, and flagged until they're done
+		Version: 14,
+		Name:    "add incomplete messages",
+		Up:      SQL("ALTER TABLE `messages` ADD COLUMN `incomplete` numeric NOT NULL DEFAULT false"),
+	},
+	{
+		// the first messages of new conversations, the messages are a JSON array
+		Version: 15,
+		Name:    "create templates",
+		Up: SQL(
+			"CREATE TABLE `templates` (`template_id` text,`user_id` text NOT NULL,`title` text NOT NULL,`shared` numeric NOT NULL DEFAULT false,`messages` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`template_id`))",
+			"CREATE INDEX `idx_templates_user_id` ON `templates`(`user_id`)",
+		),
+	},
+	{
+		// vectors of message contents for RetrieveSimilar, a JSON array, with the model they're from and the
+		// updated_at of the message they were made from
+		Version: 16,
+		Name:    "create message embeddings",
+		Up: SQL(
+			"CREATE TABLE `message_embeddings` (`message_id` text,`conversation_id` text NOT NULL,`model` text NOT NULL,`vector` text,`message_updated_at` datetime,`created_at` datetime,PRIMARY KEY (`message_id`))",
+			"CREATE INDEX `idx_message_embeddings_conversation_id` ON `message_embeddings`(`conversation_id`)",
+		),
+	},
+	{
+		// the last message each user read of a conversation, for unread counts
+		Version: 17,
+		Name:    "create read markers",
+		Up: SQL(
+			"CREATE TABLE `read_markers` (`conversation_id` text,`user_id` text,`last_read_message_id` text NOT NULL,`read_up_to` datetime,`updated_at` datetime,PRIMARY KEY (`conversation_id`,`user_id`))",
+			"CREATE INDEX `idx_read_markers_user_id` ON `read_markers`(`user_id`)",
+		),
+	},
+	{
+		// the graph context of messages, a JSON object with the query and the vertices and edges it returned
+		Version: 18,
+		Name:    "create message contexts",
+		Up: SQL(
+			"CREATE TABLE `message_contexts` (`message_id` text,`conversation_id` text NOT NULL,`context` text,`created_at` datetime,PRIMARY KEY (`message_id`))",
+			"CREATE INDEX `idx_message_contexts_conversation_id` ON `message_contexts`(`conversation_id`)",
+		),
+	},
+	{
+		// each user's rating of answers, with a comment that's encrypted like the messages'
+		Version: 19,
+		Name:    "create message feedback",
+		Up: SQL(
+			"CREATE TABLE `message_feedback` (`message_id` text,`user_id` text,`conversation_id` text NOT NULL,`rating` integer NOT NULL,`comment` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`message_id`,`user_id`))",
+			"CREATE INDEX `idx_message_feedback_user_id` ON `message_feedback`(`user_id`)",
+			"CREATE INDEX `idx_message_feedback_conversation_id` ON `message_feedback`(`conversation_id`)",
+		),
+	},
+	{
+		// the language of each message, and the one most of a conversation's messages are in for filtering.
+		// Messages written before it don't have one, their conversations get one with the next message
+		Version: 20,
+		Name:    "add languages",
+		Up: SQL(
+			"ALTER TABLE `messages` ADD COLUMN `language` text",
+			"ALTER TABLE `conversations` ADD COLUMN `language` text",
+			"CREATE INDEX `idx_conversations_language` ON `conversations`(`language`)",
+		),
+	},
+	{
+		// the tool or function a tool-calling message is the result of
+		Version: 21,
+		Name:    "add tool metadata",
+		Up: SQL(
+			"ALTER TABLE `messages` ADD COLUMN `tool_name` text",
+			"ALTER TABLE `messages` ADD COLUMN `tool_call_id` text",
+		),
+	},
+	{
+		// conversations pinned to the top of their owner's list
+		Version: 22,
+		Name:    "add conversation pins",
+		Up: SQL(
+			"ALTER TABLE `conversations` ADD COLUMN `pinned_at` datetime",
+			"CREATE INDEX `idx_conversations_user_pinned` ON `conversations`(`user_id`, `pinned_at`)",
+		),
+	},
+	{
+		// the system prompt a conversation is sent with instead of llm_config.default_system_prompt
+		Version: 23,
+		Name:    "add conversation system prompts",
+		Up:      SQL("ALTER TABLE `conversations` ADD COLUMN `system_prompt` text"),
+	},
+	{
+		// the instance's conversations by activity, for GET /admin/conversations
+		Version: 24,
+		Name:    "add conversations activity index",
+		Up:      SQL("CREATE INDEX `idx_conversations_updated` ON `conversations`(`deleted_at`, `updated_at` DESC, `id` DESC)"),
+	},
+	{
+		// the ids of conversations synced from other systems, which can be created again without duplicating them.
+		// Empty for the others, which the index leaves out
+		Version: 25,
+		Name:    "add conversation external ids",
+		Up: SQL(
+			"ALTER TABLE `conversations` ADD COLUMN `external_id` text NOT NULL DEFAULT ''",
+			"CREATE UNIQUE INDEX `idx_conversations_user_external` ON `conversations`(`user_id`, `external_id`) WHERE `external_id` != ''",
+		),
+	},
+	{
+		// the tenant of every row, for deployments that serve several. The rows there were are in the empty tenant
+		// of single-tenant deployments. External ids and idempotency keys are per tenant, the same user id can be
+		// in several, and SQLite can't change a primary key so idempotency_keys is copied to a new table
+		Version: 26,
+		Name:    "add tenants",
+		Up: SQL(
+			"ALTER TABLE `conversations` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `messages` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `conversation_tags` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `message_revisions` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `share_links` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `attachments` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `conversation_access` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `templates` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `message_embeddings` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `read_markers` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `message_contexts` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"ALTER TABLE `message_feedback` ADD COLUMN `tenant_id` text NOT NULL DEFAULT ''",
+			"CREATE INDEX `idx_conversations_tenant_user` ON `conversations`(`tenant_id`, `user_id`)",
+			"DROP INDEX `idx_conversations_user_external`",
+			"CREATE UNIQUE INDEX `idx_conversations_tenant_user_external` ON `conversations`(`tenant_id`, `user_id`, `external_id`) WHERE `external_id` != ''",
+			"CREATE TABLE `idempotency_keys_new` (`tenant_id` text NOT NULL DEFAULT '',`user_id` text,`key` text,`conversation_id` text NOT NULL,`created_at` datetime,PRIMARY KEY (`tenant_id`,`user_id`,`key`))",
+			"INSERT INTO `idempotency_keys_new` (`user_id`,`key`,`conversation_id`,`created_at`) SELECT `user_id`,`key`,`conversation_id`,`created_at` FROM `idempotency_keys`",
+			"DROP TABLE `idempotency_keys`",
+			"ALTER TABLE `idempotency_keys_new` RENAME TO `idempotency_keys`",
+			"CREATE INDEX `idx_idempotency_keys_created_at` ON `idempotency_keys`(`created_at`)",
+		),
+	},
+	{
+		// the timeline of each conversation. Conversations before it start with the first change made to them
+		Version: 27,
+		Name:    "create conversation events",
+		Up: SQL(
+			"CREATE TABLE `conversation_events` (`tenant_id` text NOT NULL DEFAULT '',`id` integer PRIMARY KEY AUTOINCREMENT,`conversation_id` text NOT NULL,`type` text NOT NULL,`user_id` text,`message_id` text,`detail` text,`created_at` datetime)",
+			"CREATE INDEX `idx_conversation_events_conversation_id` ON `conversation_events`(`conversation_id`)",
+		),
+	},
+	{
+		// the provider each reply was generated by, since they can fail over to another one. Empty for the others
+		Version: 28,
+		Name:    "add message providers",
+		Up:      SQL("ALTER TABLE `messages` ADD COLUMN `provider` text"),
+	},
+	{
+		// what was sent to the LLM for replies and what it answered, with llm_config.debug_store_llm_io
+		Version: 29,
+		Name:    "create llm exchanges",
+		Up: SQL(
+			"CREATE TABLE `llm_exchanges` (`tenant_id` text NOT NULL DEFAULT '',`message_id` text,`conversation_id` text NOT NULL,`provider` text,`model` text,`request` text,`response` text,`created_at` datetime,PRIMARY KEY (`message_id`))",
+			"CREATE INDEX `idx_llm_exchanges_conversation_id` ON `llm_exchanges`(`conversation_id`)",
+			"CREATE INDEX `idx_llm_exchanges_created_at` ON `llm_exchanges`(`created_at`)",
+		),
+	},
+	{
+		// the tokens of each message and their total per conversation. Messages written before them count 0 until
+		// their conversation is recounted, see db.ConversationStore.RecountTokens
+		Version: 30,
+		Name:    "add token counts",
+		Up: SQL(
+			"ALTER TABLE `messages` ADD COLUMN `token_count` integer NOT NULL DEFAULT 0",
+			"ALTER TABLE `conversations` ADD COLUMN `token_count` integer NOT NULL DEFAULT 0",
+		),
+	},
+	{
+		// the keys and values integrations attach to conversations, as a JSON object
+		Version: 31,
+		Name:    "add conversation metadata",
+		Up:      SQL("ALTER TABLE `conversations` ADD COLUMN `metadata` text"),
+	},
+}
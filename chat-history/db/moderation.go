@@ -0,0 +1,36 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBlocked is returned, wrapped with the reason, when the store's moderator blocks a message (see Moderate)
+var ErrBlocked = errors.New("the message was blocked")
+
+// checkContent returns the content of a message that's about to be written as it's to be written: within
+// MaxMessageChars (see limitLength) and allowed by the store's moderator. It's called before the store is locked,
+// since moderators can take a while
+func (s *sqliteStore) checkContent(conversationId, messageId, content string) (string, error) {
+	content, err := s.limitLength(conversationId, messageId, content)
+	if err != nil {
+		return "", err
+	}
+	if err := s.moderate(content); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// moderate returns an error wrapping ErrBlocked if the store's moderator blocks the content, or the moderator's
+// error if it can't tell
+func (s *sqliteStore) moderate(content string) error {
+	d, err := s.moderator.Moderate(s.db.Statement.Context, content)
+	if err != nil {
+		return fmt.Errorf("failed to moderate the message: %w", err)
+	}
+	if d.Blocked {
+		return fmt.Errorf("%w: %s", ErrBlocked, d.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"chat-history/moderation"
+	"chat-history/structs"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// failingModerator can't tell, like a moderation service that's down
+type failingModerator struct{}
+
+func (failingModerator) Moderate(context.Context, string) (moderation.Decision, error) {
+	return moderation.Decision{}, errors.New("unavailable")
+}
+
+func newModeratedStore(t *testing.T, m moderation.Moderator) ConversationStore {
+	t.Helper()
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), Moderate(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestModerate(t *testing.T) {
+	blocklist, err := moderation.NewBlocklist([]string{`(?i)\bpassword\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newModeratedStore(t, blocklist)
+	convoId := seedConversation(t, s, USER)
+	before, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := func(content string) structs.Message {
+		return structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+	}
+
+	// allowed content is stored
+	allowed := message("How many accounts are flagged?")
+	if _, err := s.AppendMessage(allowed, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocked content isn't, and the conversation doesn't change
+	_, err = s.AppendMessage(message("what's the admin Password?"), AnyVersion)
+	if !errors.Is(err, ErrBlocked) || !strings.Contains(err.Error(), `"Password"`) {
+		t.Fatalf("expected ErrBlocked with the reason, got: %v", err)
+	}
+	messages, _ := s.GetConversation(USER, convoId.String())
+	if len(messages) != 2 || messages[1].MessageId != allowed.MessageId {
+		t.Fatalf("the blocked message shouldn't be stored: %+v", messages)
+	}
+	if after, _ := s.FindConversation(convoId.String()); after.Version != before.Version+1 {
+		t.Fatalf("only the allowed message should change the version. It's %d, was %d", after.Version, before.Version)
+	}
+
+	// nor can it start a conversation or replace a message's content
+	blocked := message("reset my password")
+	blocked.ConversationId = uuid.New()
+	if _, err := s.CreateConversation(USER, "convo", blocked); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected ErrBlocked, got: %v", err)
+	}
+	if _, err := s.FindConversation(blocked.ConversationId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the conversation shouldn't be created. Got %v", err)
+	}
+	if _, err := s.EditMessage(USER, convoId.String(), allowed.MessageId.String(), "the password is hunter2", AnyVersion); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected ErrBlocked, got: %v", err)
+	}
+	if revisions, _ := s.ListRevisions(USER, convoId.String(), allowed.MessageId.String()); len(revisions) != 0 {
+		t.Fatalf("the blocked edit shouldn't be stored: %+v", revisions)
+	}
+}
+
+func TestModerate_Fails(t *testing.T) {
+	s := newModeratedStore(t, failingModerator{})
+	convoId := uuid.New()
+	msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole}
+	if _, err := s.CreateConversation(USER, "convo", msg); err == nil || errors.Is(err, ErrBlocked) {
+		t.Fatalf("a message that can't be moderated shouldn't be stored. Got %v", err)
+	}
+	if _, err := s.FindConversation(convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the conversation shouldn't be created. Got %v", err)
+	}
+}
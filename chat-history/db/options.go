@@ -0,0 +1,215 @@
+package db
+
+import (
+	"chat-history/clock"
+	"chat-history/moderation"
+	"chat-history/structs"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrReadOnly is returned by every method that would write to a store opened with ReadOnly
+var ErrReadOnly = errors.New("store is read-only")
+
+// Option changes how NewSQLiteStore opens the database
+type Option func(*options)
+
+type options struct {
+	readOnly        bool
+	encryptionKey   []byte
+	busyTimeout     time.Duration
+	shareKey        []byte
+	clockSkew       time.Duration
+	archivePath     string
+	fileMode        os.FileMode
+	maxAttachments  int
+	maxPins         int
+	maxPinnedConvos int
+	maxConvos       int
+	roles           []structs.MessagengerRole
+	ids             IDGenerator
+	notify          func(Event)
+	clock           clock.Clock
+	moderator       moderation.Moderator
+	maxMessageChars int
+	lengthPolicy    LengthPolicy
+	countTokens     func(text string) int
+	pool            pool
+}
+
+// pool is the connection pool ConnPool configures, 0 leaves database/sql's default
+type pool struct {
+	maxOpen, maxIdle int
+	maxLifetime      time.Duration
+}
+
+func (p pool) apply(sqlDB *sql.DB) {
+	if p.maxOpen > 0 {
+		sqlDB.SetMaxOpenConns(p.maxOpen)
+	}
+	if p.maxIdle > 0 {
+		sqlDB.SetMaxIdleConns(p.maxIdle)
+	}
+	if p.maxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(p.maxLifetime)
+	}
+}
+
+// ReadOnly opens the database file with mode=ro, so nothing can write to it. The schema must
+// already be up to date since migrations can't be applied
+func ReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// EncryptionKey encrypts the content and comment of messages with AES-GCM before they're written,
+// and decrypts them when they're read. key must be 16, 24 or 32 bytes. An empty key leaves them
+// in plaintext. Messages written before the key was set are still read as plaintext
+func EncryptionKey(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// BusyTimeout is how long a connection waits for another one's lock before failing with
+// "database is locked". It defaults to defaultBusyTimeout
+func BusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// ShareKey signs the tokens of share links. Without it a random key is used, and links stop
+// working when the store is reopened
+func ShareKey(key []byte) Option {
+	return func(o *options) {
+		o.shareKey = key
+	}
+}
+
+// ClockSkew accepts share links for d after they expire, for replicas whose clocks are a little apart
+func ClockSkew(d time.Duration) Option {
+	return func(o *options) {
+		o.clockSkew = d
+	}
+}
+
+// ArchivePath keeps archived conversations in a second SQLite database at path, out of the way of
+// the primary one. ArchiveConversation and UnarchiveConversation return ErrNoArchive without it
+func ArchivePath(path string) Option {
+	return func(o *options) {
+		o.archivePath = path
+	}
+}
+
+// FileMode is the mode of the database file, and of the archive's (see ArchivePath). It defaults to 0600,
+// readable by the service only. A file that already exists is changed to it, unless the store is read-only
+func FileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// MaxAttachments is how many attachments a message can have, AddAttachment returns ErrTooManyAttachments
+// after that. It defaults to defaultMaxAttachments
+func MaxAttachments(n int) Option {
+	return func(o *options) {
+		o.maxAttachments = n
+	}
+}
+
+// MaxConversations is how many conversations a user can have, not counting the ones in the trash or the archive.
+// Starting another returns ErrTooManyConversations, unless it's made with the store WithoutConversationLimit returns.
+// 0, the default, is no limit
+func MaxConversations(n int) Option {
+	return func(o *options) {
+		o.maxConvos = n
+	}
+}
+
+// MaxPins is how many messages of a conversation can be pinned, PinMessage returns ErrTooManyPins
+// after that. It defaults to defaultMaxPins
+func MaxPins(n int) Option {
+	return func(o *options) {
+		o.maxPins = n
+	}
+}
+
+// MessageRoles lets messages have roles besides user, assistant, system and context, i.e., structs.ToolRole. Messages
+// with any other role are still rejected with ErrInvalidMessages
+func MessageRoles(roles ...structs.MessagengerRole) Option {
+	return func(o *options) {
+		o.roles = roles
+	}
+}
+
+// MaxPinnedConversations is how many conversations a user can pin, PinConversation returns
+// ErrTooManyPinnedConversations after that. It defaults to defaultMaxPinnedConversations
+func MaxPinnedConversations(n int) Option {
+	return func(o *options) {
+		o.maxPinnedConvos = n
+	}
+}
+
+// IDs makes the ids of conversations created without one with gen. It defaults to UUIDv7. Conversations that
+// already have an id keep it, whatever generated it
+func IDs(gen IDGenerator) Option {
+	return func(o *options) {
+		o.ids = gen
+	}
+}
+
+// Notify calls fn with each Event once its change is committed. fn is called with the store locked,
+// so it must return quickly and not use the store (see webhook.Emitter.Emit)
+func Notify(fn func(Event)) Option {
+	return func(o *options) {
+		o.notify = fn
+	}
+}
+
+// ConnPool limits the connections to the database: how many can be open and idle at once, and how long
+// one is reused for. A writable SQLite store always has a single open connection, since it only has one
+// writer, so maxOpen only applies to read-only ones
+func ConnPool(maxOpen, maxIdle int, maxLifetime time.Duration) Option {
+	return func(o *options) {
+		o.pool = pool{maxOpen: maxOpen, maxIdle: maxIdle, maxLifetime: maxLifetime}
+	}
+}
+
+// Clock sets the timestamps the store writes, and the times share links and the trash expire at, with c instead
+// of the system's clock. It's for tests, with a clock.Fake
+func Clock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Moderate checks the content of the messages CreateConversation, CreateConversationOnce, AppendMessage and
+// EditMessage write with m first. Blocked ones aren't written, and an error wrapping ErrBlocked is returned.
+// It defaults to moderation.PassThrough
+func Moderate(m moderation.Moderator) Option {
+	return func(o *options) {
+		o.moderator = m
+	}
+}
+
+// MaxMessageChars is the longest content, in characters, that CreateConversation, CreateConversationOnce,
+// AppendMessage and EditMessage write. Longer content is rejected or truncated, depending on policy, and logged.
+// 0, the default, is no limit
+func MaxMessageChars(n int, policy LengthPolicy) Option {
+	return func(o *options) {
+		o.maxMessageChars = n
+		o.lengthPolicy = policy
+	}
+}
+
+// TokenCounter counts the tokens of the messages the store writes with count, for the token counts of
+// conversations and users (see ConversationStore.TokenUsage). It defaults to about 4 characters a token
+func TokenCounter(count func(text string) int) Option {
+	return func(o *options) {
+		o.countTokens = count
+	}
+}
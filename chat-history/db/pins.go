@@ -0,0 +1,127 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// how many messages of a conversation can be pinned unless MaxPins is set, and how many conversations
+// a user can pin unless MaxPinnedConversations is
+const (
+	defaultMaxPins                = 10
+	defaultMaxPinnedConversations = 5
+)
+
+// ErrTooManyPins is returned when a conversation already has as many pinned messages as it can
+var ErrTooManyPins = errors.New("the conversation has too many pinned messages")
+
+// ErrTooManyPinnedConversations is returned when the user already has as many pinned conversations as they can
+var ErrTooManyPinnedConversations = errors.New("too many conversations are pinned, unpin one first")
+
+func (s *sqliteStore) PinMessage(userId, conversationId, messageId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, err := s.ownedMessage(userId, conversationId, messageId)
+	if err != nil {
+		return err
+	}
+	if message.Pinned {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&structs.Message{}).Where("conversation_id = ? AND pinned", message.ConversationId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(s.maxPins) {
+			return ErrTooManyPins
+		}
+		// UpdateColumn, so the message's updated_at doesn't change and it isn't taken for the latest one
+		return tx.Model(message).UpdateColumn("pinned", true).Error
+	})
+}
+
+func (s *sqliteStore) UnpinMessage(userId, conversationId, messageId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, err := s.ownedMessage(userId, conversationId, messageId)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(message).UpdateColumn("pinned", false).Error
+}
+
+func (s *sqliteStore) ListPinned(userId, conversationId string) ([]structs.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	messages := []structs.Message{}
+	if err := s.db.Where("conversation_id = ? AND pinned", convoId).Order("id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (s *sqliteStore) PinConversation(userId, conversationId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var convo structs.Conversation
+		err := tx.Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if convo.PinnedAt != nil {
+			return nil
+		}
+		var count int64
+		if err := tx.Model(&structs.Conversation{}).Where("user_id = ? AND pinned_at IS NOT NULL", userId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= int64(s.maxPinnedConvos) {
+			return ErrTooManyPinnedConversations
+		}
+		// UpdateColumn, so updated_at doesn't change and the conversation goes back to its place once it's unpinned
+		return tx.Model(&convo).UpdateColumn("pinned_at", s.now()).Error
+	})
+}
+
+func (s *sqliteStore) UnpinConversation(userId, conversationId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.db.Model(&structs.Conversation{}).Where("user_id = ? AND conversation_id = ?", userId, conversationId).UpdateColumn("pinned_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
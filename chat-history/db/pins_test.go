@@ -0,0 +1,257 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// seedReplies appends n replies to the conversation and returns their ids, oldest first
+func seedReplies(t testing.TB, s ConversationStore, convoId uuid.UUID, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range n {
+		reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: fmt.Sprintf("reply %d", i), Role: structs.AssistantRole}
+		if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = reply.MessageId.String()
+	}
+	return ids
+}
+
+func TestPinMessage(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	replies := seedReplies(t, s, convoId, 3)
+	convo, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pinned out of order, listed oldest first
+	for _, id := range []string{replies[2], replies[0], replies[2]} {
+		if err := s.PinMessage(USER, convoId.String(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pinned, err := s.ListPinned(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 2 || pinned[0].MessageId.String() != replies[0] || pinned[1].MessageId.String() != replies[2] || !pinned[0].Pinned {
+		t.Fatalf("the pinned messages should be listed once each, oldest first: %+v", pinned)
+	}
+	if pinned[0].Content != "reply 0" {
+		t.Fatalf("the pinned messages should have their content: %+v", pinned[0])
+	}
+	if after, _ := s.FindConversation(convoId.String()); after.Version != convo.Version {
+		t.Fatalf("pinning shouldn't change the version. It was %d, it's %d", convo.Version, after.Version)
+	}
+
+	if err := s.UnpinMessage(USER, convoId.String(), replies[2]); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := s.ListPinned(USER, convoId.String()); len(pinned) != 1 || pinned[0].MessageId.String() != replies[0] {
+		t.Fatalf("only the message still pinned should be listed: %+v", pinned)
+	}
+	// unpinning a message that isn't pinned does nothing
+	if err := s.UnpinMessage(USER, convoId.String(), replies[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client can't pin a message by sending it pinned
+	sent := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "pin me", Role: structs.UserRole, Pinned: true}
+	if _, err := s.AppendMessage(sent, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := s.ListPinned(USER, convoId.String()); len(pinned) != 1 {
+		t.Fatalf("new messages shouldn't be pinned: %+v", pinned)
+	}
+}
+
+func TestPinMessage_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	messageId := firstMessage(t, s, USER, convoId)
+
+	if err := s.PinMessage("Miss_Take", convoId.String(), messageId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's message should be ErrNotFound, got: %v", err)
+	}
+	if err := s.PinMessage(USER, convoId.String(), uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a message that doesn't exist should be ErrNotFound, got: %v", err)
+	}
+	if err := s.UnpinMessage("Miss_Take", convoId.String(), messageId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's message should be ErrNotFound, got: %v", err)
+	}
+	if _, err := s.ListPinned("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be ErrNotFound, got: %v", err)
+	}
+}
+
+func TestPinMessage_TooMany(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), MaxPins(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := seedConversation(t, s, USER)
+	replies := seedReplies(t, s, convoId, 3)
+
+	for _, id := range replies[:2] {
+		if err := s.PinMessage(USER, convoId.String(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PinMessage(USER, convoId.String(), replies[2]); !errors.Is(err, ErrTooManyPins) {
+		t.Fatalf("expected ErrTooManyPins, got: %v", err)
+	}
+	// already pinned, so it doesn't count against the limit
+	if err := s.PinMessage(USER, convoId.String(), replies[0]); err != nil {
+		t.Fatalf("pinning a pinned message should do nothing, got: %v", err)
+	}
+	// the limit is per conversation
+	other := seedConversation(t, s, USER)
+	if err := s.PinMessage(USER, other.String(), firstMessage(t, s, USER, other)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listIds lists all of the user's conversations a page of limit at a time and returns their ids in order
+func listIds(t *testing.T, s ConversationStore, userId string, limit int) []string {
+	t.Helper()
+	var ids []string
+	opts := ListOptions{Limit: limit}
+	for {
+		page, next, err := s.ListConversations(userId, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range page {
+			ids = append(ids, c.ConversationId.String())
+		}
+		if next == "" {
+			return ids
+		}
+		opts.Cursor = next
+	}
+}
+
+func TestPinConversation(t *testing.T) {
+	s := newTestStore(t)
+	// oldest first, so without pins the list is 5, 4, 3, 2, 1, 0
+	ids := make([]string, 6)
+	for i := range ids {
+		ids[i] = seedConversation(t, s, USER).String()
+		age(t, s.(*sqliteStore), uuid.MustParse(ids[i]), time.Duration(len(ids)-i)*time.Hour)
+	}
+	seedConversation(t, s, "Miss_Take")
+
+	// pinned ones first, most recently pinned first, the others stay in their order
+	for _, i := range []int{1, 4, 0} {
+		if err := s.PinConversation(USER, ids[i]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	want := []string{ids[0], ids[4], ids[1], ids[5], ids[3], ids[2]}
+	for _, limit := range []int{0, 1, 2, 3, 4} {
+		if got := listIds(t, s, USER, limit); !slices.Equal(got, want) {
+			t.Fatalf("with pages of %d, expected %v, got: %v", limit, want, got)
+		}
+	}
+	page, _, err := s.ListConversations(USER, ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page[0].PinnedAt == nil {
+		t.Fatal("a pinned conversation should have its pinned_ts")
+	}
+
+	// pinning again doesn't move it to the top
+	if err := s.PinConversation(USER, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := listIds(t, s, USER, 0); !slices.Equal(got, want) {
+		t.Fatalf("pinning a pinned conversation should do nothing. Expected %v, got: %v", want, got)
+	}
+
+	// unpinned, it goes back to where it was
+	if err := s.UnpinConversation(USER, ids[4]); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{ids[0], ids[1], ids[5], ids[4], ids[3], ids[2]}
+	if got := listIds(t, s, USER, 2); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+	convo, err := s.FindConversation(ids[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.PinnedAt != nil {
+		t.Fatalf("an unpinned conversation shouldn't have a pinned_ts, got: %v", convo.PinnedAt)
+	}
+}
+
+func TestPinConversation_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	if err := s.PinConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be ErrNotFound, got: %v", err)
+	}
+	if err := s.UnpinConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user's conversation should be ErrNotFound, got: %v", err)
+	}
+	if err := s.PinConversation(USER, uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestPinConversation_TooMany(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), MaxPinnedConversations(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	ids := []string{seedConversation(t, s, USER).String(), seedConversation(t, s, USER).String(), seedConversation(t, s, USER).String()}
+
+	for _, id := range ids[:2] {
+		if err := s.PinConversation(USER, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PinConversation(USER, ids[2]); !errors.Is(err, ErrTooManyPinnedConversations) {
+		t.Fatalf("expected ErrTooManyPinnedConversations, got: %v", err)
+	}
+	// already pinned, so it doesn't count against the limit
+	if err := s.PinConversation(USER, ids[0]); err != nil {
+		t.Fatalf("pinning a pinned conversation should do nothing, got: %v", err)
+	}
+	// the limit is per user
+	if err := s.PinConversation("Miss_Take", seedConversation(t, s, "Miss_Take").String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnpinConversation(USER, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PinConversation(USER, ids[2]); err != nil {
+		t.Fatalf("unpinning one should make room, got: %v", err)
+	}
+
+	// a transferred conversation isn't pinned for its new owner
+	if err := s.TransferOwnership(ids[2], "Miss_Take"); err != nil {
+		t.Fatal(err)
+	}
+	if convo, err := s.FindConversation(ids[2]); err != nil || convo.PinnedAt != nil {
+		t.Fatalf("the pin should be cleared on transfer, got: %v, %v", convo, err)
+	}
+	if err := s.PinConversation(USER, ids[1]); err != nil {
+		t.Fatalf("the transferred conversation shouldn't count for its old owner, got: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"chat-history/structs"
+
+	"gorm.io/gorm/clause"
+)
+
+// unreadMessages counts the messages joined with the reader's read_markers row that are after it
+const unreadMessages = "COALESCE(SUM(CASE WHEN read_markers.read_up_to IS NULL OR messages.created_at > read_markers.read_up_to THEN 1 ELSE 0 END), 0)"
+
+func (s *sqliteStore) MarkRead(userId, conversationId, messageId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// messages of conversations in the trash can't be read
+	message, err := s.conversationMessage(conversationId, messageId)
+	if err != nil {
+		return err
+	}
+
+	marker := structs.ReadMarker{
+		ConversationId:    message.ConversationId,
+		UserId:            userId,
+		LastReadMessageId: message.MessageId,
+		ReadUpTo:          message.CreatedAt,
+		UpdatedAt:         s.now(),
+	}
+	return s.db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "read_up_to", "updated_at"})}).
+		Create(&marker).Error
+}
+
+func (s *sqliteStore) GetUnreadCount(userId, conversationId string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var convos int64
+	if err := s.db.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).Count(&convos).Error; err != nil {
+		return 0, err
+	}
+	if convos == 0 {
+		return 0, ErrNotFound
+	}
+	var unread int
+	err := s.db.Model(&structs.Message{}).Select(unreadMessages).
+		Joins("LEFT JOIN read_markers ON read_markers.conversation_id = messages.conversation_id AND read_markers.user_id = ?", userId).
+		Where("messages.conversation_id = ?", conversationId).Scan(&unread).Error
+	return unread, err
+}
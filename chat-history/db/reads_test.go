@@ -0,0 +1,120 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMarkRead(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	replies := seedReplies(t, s, convoId, 3)
+
+	unread := func(userId string) int {
+		t.Helper()
+		n, err := s.GetUnreadCount(userId, convoId.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// nothing's been read yet
+	if n := unread(USER); n != 4 {
+		t.Fatalf("all 4 messages should be unread, got %d", n)
+	}
+
+	if err := s.MarkRead(USER, convoId.String(), replies[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(USER); n != 2 {
+		t.Fatalf("the 2 messages after the one read should be unread, got %d", n)
+	}
+	// markers are per user
+	if n := unread("Miss_Take"); n != 4 {
+		t.Fatalf("another user should still have all 4 unread, got %d", n)
+	}
+
+	// new messages are unread
+	seedReplies(t, s, convoId, 2)
+	if n := unread(USER); n != 4 {
+		t.Fatalf("the new messages should be unread too, got %d", n)
+	}
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := messages[0]
+	for _, m := range messages {
+		if m.CreatedAt.After(latest.CreatedAt) {
+			latest = m
+		}
+	}
+	if err := s.MarkRead(USER, convoId.String(), latest.MessageId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(USER); n != 0 {
+		t.Fatalf("nothing should be unread after reading the latest message, got %d", n)
+	}
+
+	// it can go back, marking the messages after it unread again
+	if err := s.MarkRead(USER, convoId.String(), replies[2]); err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(USER); n != 2 {
+		t.Fatalf("the messages after the one marked should be unread again, got %d", n)
+	}
+
+	other := seedConversation(t, s, USER)
+	for _, tt := range []struct{ name, convoId, messageId string }{
+		{"a message of another conversation", other.String(), replies[0]},
+		{"a message that doesn't exist", convoId.String(), uuid.NewString()},
+	} {
+		if err := s.MarkRead(USER, tt.convoId, tt.messageId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("marking %s read should return ErrNotFound, got %v", tt.name, err)
+		}
+	}
+	if _, err := s.GetUnreadCount(USER, uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the unread count of a conversation that doesn't exist should return ErrNotFound, got %v", err)
+	}
+
+	// conversations in the trash can't be read
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead(USER, convoId.String(), replies[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("marking a message in the trash read should return ErrNotFound, got %v", err)
+	}
+}
+
+func TestListConversations_UnreadCount(t *testing.T) {
+	s := newTestStore(t)
+	read, unread := seedConversation(t, s, USER), seedConversation(t, s, USER)
+	replies := seedReplies(t, s, read, 2)
+	others := seedReplies(t, s, unread, 2)
+	if err := s.MarkRead(USER, read.String(), replies[1]); err != nil {
+		t.Fatal(err)
+	}
+	// someone else reading it doesn't change what the user has read
+	if err := s.MarkRead("Miss_Take", unread.String(), others[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	convos, _, err := s.ListConversations(USER, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[uuid.UUID]structs.Conversation{}
+	for _, c := range convos {
+		counts[c.ConversationId] = c
+	}
+	if c := counts[read]; c.UnreadCount != 0 || c.MessageCount != 3 {
+		t.Fatalf("the conversation read to the end shouldn't have unread messages: %+v", c)
+	}
+	if c := counts[unread]; c.UnreadCount != 3 || c.MessageCount != 3 {
+		t.Fatalf("all 3 messages of the conversation the user didn't read should be unread: %+v", c)
+	}
+}
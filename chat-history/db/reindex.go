@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoSearchIndex is returned by ReindexSearch when this build of SQLite has no FTS5 (see setupSearch), searches
+// don't use an index then
+var ErrNoSearchIndex = errors.New("there is no full-text search index to rebuild")
+
+// Reindexer is implemented by stores whose full-text search index can be rebuilt while they're in use
+type Reindexer interface {
+	// ReindexSearch starts rebuilding the search index from the messages in the background and returns its
+	// progress. While a rebuild is running it returns that one's instead of starting another
+	ReindexSearch() (*ReindexProgress, error)
+	// ReindexProgress returns the progress of the latest rebuild, nil if none was started since the store was opened
+	ReindexProgress() *ReindexProgress
+}
+
+// ReindexProgress is how far a rebuild of the search index got
+type ReindexProgress struct {
+	Running bool `json:"running"`
+	// how many messages were indexed, of the ones there were when it started. Messages added meanwhile are
+	// indexed as well, so it can end up higher
+	Indexed int64 `json:"indexed"`
+	Total   int64 `json:"total"`
+	// when it started and, once it's done, finished
+	StartedAt  time.Time  `json:"started_ts"`
+	FinishedAt *time.Time `json:"finished_ts,omitempty"`
+	// why it stopped, if it failed. The index searches use is only replaced once it's rebuilt
+	Error string `json:"error,omitempty"`
+}
+
+// how many messages each step of a rebuild indexes. The store is locked for a step, reads and writes wait for it
+const reindexBatchSize = 500
+
+// the rebuild is written to messages_fts_next, and swapped in for messages_fts once it's done. indexed in
+// search_reindex is the last message id it has, the triggers keep the messages up to it in sync meanwhile
+var reindexSetup = []string{
+	`CREATE VIRTUAL TABLE messages_fts_next USING fts5(content, content='messages', content_rowid='id')`,
+	`CREATE TABLE search_reindex (indexed integer NOT NULL)`,
+	`INSERT INTO search_reindex (indexed) VALUES (0)`,
+	`CREATE TRIGGER search_reindex_ai AFTER INSERT ON messages WHEN new.id <= (SELECT indexed FROM search_reindex) BEGIN
+		INSERT INTO messages_fts_next(rowid, content) VALUES (new.id, new.content);
+	END`,
+	`CREATE TRIGGER search_reindex_ad AFTER DELETE ON messages WHEN old.id <= (SELECT indexed FROM search_reindex) BEGIN
+		INSERT INTO messages_fts_next(messages_fts_next, rowid, content) VALUES ('delete', old.id, old.content);
+	END`,
+	`CREATE TRIGGER search_reindex_au AFTER UPDATE OF content ON messages WHEN old.id <= (SELECT indexed FROM search_reindex) BEGIN
+		INSERT INTO messages_fts_next(messages_fts_next, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO messages_fts_next(rowid, content) VALUES (new.id, new.content);
+	END`,
+}
+
+// reindexCleanup drops what's left of a rebuild, one that's done or was cut off
+var reindexCleanup = []string{
+	`DROP TRIGGER IF EXISTS search_reindex_ai`,
+	`DROP TRIGGER IF EXISTS search_reindex_ad`,
+	`DROP TRIGGER IF EXISTS search_reindex_au`,
+	`DROP TABLE IF EXISTS search_reindex`,
+	`DROP TABLE IF EXISTS messages_fts_next`,
+}
+
+// searchReindex is the store's rebuild of the search index, shared like mu
+type searchReindex struct {
+	mu       sync.Mutex
+	progress *ReindexProgress
+	// cancels the running rebuild, and is closed once it stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (s *sqliteStore) ReindexSearch() (*ReindexProgress, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if !s.fts {
+		return nil, ErrNoSearchIndex
+	}
+	r := s.reindex
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress != nil && r.progress.Running {
+		progress := *r.progress
+		return &progress, nil
+	}
+
+	// it outlives the request that started it
+	db := s.db.WithContext(context.Background())
+	var total int64
+	err := s.locked(func() error {
+		if err := db.Raw("SELECT COUNT(*) FROM messages").Scan(&total).Error; err != nil {
+			return err
+		}
+		return db.Transaction(func(tx *gorm.DB) error {
+			for _, stmt := range slices.Concat(reindexCleanup, reindexSetup) {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.progress = &ReindexProgress{Running: true, Total: total, StartedAt: s.now()}
+	r.cancel, r.done = cancel, make(chan struct{})
+	go s.rebuildSearch(ctx, db, r.done)
+	progress := *r.progress
+	return &progress, nil
+}
+
+func (s *sqliteStore) ReindexProgress() *ReindexProgress {
+	r := s.reindex
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return nil
+	}
+	progress := *r.progress
+	return &progress
+}
+
+// rebuildSearch indexes the messages a batch at a time, unlocking the store between them, then swaps the new index
+// in for the one searches use
+func (s *sqliteStore) rebuildSearch(ctx context.Context, db *gorm.DB, done chan struct{}) {
+	defer close(done)
+	err := func() error {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var n int64
+			err := s.locked(func() error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					var last int64
+					// the id of the last message of the batch, 0 once there are none left
+					err := tx.Raw(`SELECT COALESCE(MAX(id), 0) FROM (SELECT id FROM messages WHERE id > (SELECT indexed FROM search_reindex) ORDER BY id LIMIT ?)`, reindexBatchSize).
+						Scan(&last).Error
+					if err != nil || last == 0 {
+						return err
+					}
+					res := tx.Exec(`INSERT INTO messages_fts_next(rowid, content) SELECT id, content FROM messages WHERE id > (SELECT indexed FROM search_reindex) AND id <= ?`, last)
+					if res.Error != nil {
+						return res.Error
+					}
+					n = res.RowsAffected
+					return tx.Exec(`UPDATE search_reindex SET indexed = ?`, last).Error
+				})
+			})
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			s.reindex.mu.Lock()
+			s.reindex.progress.Indexed += n
+			s.reindex.mu.Unlock()
+		}
+
+		return s.locked(func() error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				stmts := []string{
+					`DROP TRIGGER search_reindex_ai`,
+					`DROP TRIGGER search_reindex_ad`,
+					`DROP TRIGGER search_reindex_au`,
+					`DROP TRIGGER IF EXISTS messages_fts_ai`,
+					`DROP TRIGGER IF EXISTS messages_fts_ad`,
+					`DROP TRIGGER IF EXISTS messages_fts_au`,
+					`DROP TABLE messages_fts`,
+					`ALTER TABLE messages_fts_next RENAME TO messages_fts`,
+					`DROP TABLE search_reindex`,
+				}
+				for _, stmt := range append(stmts, searchTriggers...) {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	}()
+
+	s.reindex.mu.Lock()
+	defer s.reindex.mu.Unlock()
+	progress := s.reindex.progress
+	finished := s.now()
+	progress.Running, progress.FinishedAt = false, &finished
+	if err != nil {
+		progress.Error = err.Error()
+		slog.Error("failed to rebuild the search index", "indexed", progress.Indexed, "err", err)
+		return
+	}
+	slog.Info("rebuilt the search index", "indexed", progress.Indexed, "duration_ms", finished.Sub(progress.StartedAt).Milliseconds())
+}
+
+// locked runs fn with the store locked for writing
+func (s *sqliteStore) locked(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+// stop cancels the running rebuild, if there is one, and waits for it to stop. What it indexed is dropped
+// when the next one starts
+func (r *searchReindex) stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// waitForReindex waits for the rebuild ReindexSearch started to finish, and fails if it didn't
+func waitForReindex(t *testing.T, s Reindexer) *ReindexProgress {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if progress := s.ReindexProgress(); progress != nil && !progress.Running {
+			if progress.Error != "" || progress.FinishedAt == nil {
+				t.Fatalf("the rebuild should finish. Got %+v", progress)
+			}
+			return progress
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the rebuild didn't finish in time")
+	return nil
+}
+
+func TestReindexSearch(t *testing.T) {
+	s := newTestStore(t)
+	if !s.(*sqliteStore).fts {
+		if _, err := s.(Reindexer).ReindexSearch(); !errors.Is(err, ErrNoSearchIndex) {
+			t.Fatalf("a store without an index should have none to rebuild. Got %v", err)
+		}
+		t.Skip("the search index needs the sqlite_fts5 build tag")
+	}
+	reindexer := s.(Reindexer)
+	convoId := uuid.NewString()
+	messages := thread(2*reindexBatchSize + 100)
+	if _, err := s.BulkAppendMessages(USER, convoId, "imported", messages); err != nil {
+		t.Fatal(err)
+	}
+	// the index misses the import, like a file that was loaded without it
+	if err := s.(*sqliteStore).db.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('delete-all')`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if results, err := s.SearchMessages(USER, "message 1099"); err != nil || len(results) != 0 {
+		t.Fatalf("the imported messages shouldn't be found before the rebuild. Got %+v, %v", results, err)
+	}
+	// the ones written since are
+	written := uuid.New()
+	if _, err := s.AppendMessage(structs.Message{ConversationId: uuid.MustParse(convoId), MessageId: written, Content: "written before the rebuild", Role: structs.UserRole}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := reindexer.ReindexSearch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Running || progress.Total != int64(len(messages))+1 {
+		t.Fatalf("the rebuild should be running over the %d messages. Got %+v", len(messages)+1, progress)
+	}
+	// starting it again while it runs doesn't start another
+	if again, err := reindexer.ReindexSearch(); err != nil || !again.StartedAt.Equal(progress.StartedAt) {
+		t.Fatalf("the running rebuild should be returned. Got %+v, %v", again, err)
+	}
+	// the store is used meanwhile
+	if err := s.AddTag(USER, convoId, "imported"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.EditMessage(USER, convoId, written.String(), "edited while reindexing", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	appended := uuid.New()
+	if _, err := s.AppendMessage(structs.Message{ConversationId: uuid.MustParse(convoId), MessageId: appended, Content: "appended while reindexing", Role: structs.UserRole}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	progress = waitForReindex(t, reindexer)
+	if progress.Indexed < int64(len(messages))+1 {
+		t.Fatalf("every message should be indexed. Got %+v", progress)
+	}
+
+	for query, want := range map[string]int{"message 1099": 1, "edited while reindexing": 1, "appended while reindexing": 1, "written before the rebuild": 0} {
+		results, err := s.SearchMessages(USER, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != want {
+			t.Fatalf("%q should match %d messages after the rebuild. Got %+v", query, want, results)
+		}
+	}
+
+	// it can run again, and gets the same index
+	if _, err := reindexer.ReindexSearch(); err != nil {
+		t.Fatal(err)
+	}
+	waitForReindex(t, reindexer)
+	if results, err := s.SearchMessages(USER, "message 1099"); err != nil || len(results) != 1 {
+		t.Fatalf("a second rebuild should find the message once. Got %+v, %v", results, err)
+	}
+	// the index stays in sync after it
+	more := uuid.New()
+	if _, err := s.AppendMessage(structs.Message{ConversationId: uuid.MustParse(convoId), MessageId: more, Content: "appended after reindexing", Role: structs.UserRole}, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := s.SearchMessages(USER, "appended after reindexing"); err != nil || len(results) != 1 || results[0].MessageId != more {
+		t.Fatalf("messages added after the rebuild should be found. Got %+v, %v", results, err)
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) EditMessage(userId, conversationId, messageId, content string, expectedVersion int) (*structs.Message, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	content, err := s.checkContent(conversationId, messageId, content)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	message := structs.Message{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, convoId, expectedVersion); err != nil {
+			return err
+		}
+		res := tx.Where("conversation_id = ? AND message_id = ?", convoId, messageId).First(&message)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		} else if res.Error != nil {
+			return res.Error
+		}
+
+		// the content is kept as it's stored, encrypted or not
+		var last int
+		if err := tx.Model(&structs.MessageRevision{}).Where("message_id = ?", message.MessageId).
+			Select("COALESCE(MAX(revision), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		revision := structs.MessageRevision{MessageId: message.MessageId, Revision: last + 1, Content: message.Content}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		sealed, err := s.sealer.seal(message.MessageId, content)
+		if err != nil {
+			return err
+		}
+		edited := structs.Message{Content: content}
+		detectLanguage(&edited)
+		s.countTokens(&edited)
+		if err := tx.Model(&message).Updates(map[string]any{"content": sealed, "language": edited.Language, "token_count": edited.TokenCount}).Error; err != nil {
+			return err
+		}
+		if _, err = updateLanguage(tx, convoId); err != nil {
+			return err
+		}
+		_, err = updateTokenCount(tx, convoId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	message.Content, message.TokenCount = content, s.tokenCounter(content)
+	message.Comment, err = s.sealer.open(message.MessageId, message.Comment)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (s *sqliteStore) ListRevisions(userId, conversationId, messageId string) ([]structs.MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	message := structs.Message{}
+	tx := s.db.Where("conversation_id = ? AND message_id = ?", convoId, messageId).First(&message)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	revisions := []structs.MessageRevision{}
+	if err := s.db.Where("message_id = ?", message.MessageId).Order("revision").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		if revisions[i].Content, err = s.sealer.open(message.MessageId, revisions[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	return revisions, nil
+}
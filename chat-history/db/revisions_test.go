@@ -0,0 +1,151 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// firstMessage returns the id of the only message seedConversation stores
+func firstMessage(t *testing.T, s ConversationStore, userId string, convoId uuid.UUID) string {
+	t.Helper()
+	messages, err := s.GetConversation(userId, convoId.String())
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("the conversation should have a message. Got %v, %v", messages, err)
+	}
+	return messages[0].MessageId.String()
+}
+
+func TestEditMessage(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	other := seedConversation(t, s, USER)
+	messageId := firstMessage(t, s, USER, convoId)
+
+	// the other conversation was updated last, editing puts this one back on top
+	for i := 1; i <= 3; i++ {
+		edited, err := s.EditMessage(USER, convoId.String(), messageId, fmt.Sprintf("edit %d", i), AnyVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edited.Content != fmt.Sprintf("edit %d", i) || edited.MessageId.String() != messageId {
+			t.Fatalf("the edited message should be returned: %+v", edited)
+		}
+	}
+	convos, _, _ := s.ListConversations(USER, ListOptions{})
+	if len(convos) != 2 || convos[0].ConversationId != convoId || convos[1].ConversationId != other {
+		t.Fatalf("the edited conversation should be the most recently updated: %+v", convos)
+	}
+
+	if messages, _ := s.GetConversation(USER, convoId.String()); messages[0].Content != "edit 3" {
+		t.Fatalf("the message should have its latest content. It's: %q", messages[0].Content)
+	}
+
+	// every earlier content is kept, in the order they were replaced
+	revisions, err := s.ListRevisions(USER, convoId.String(), messageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Hello, world", "edit 1", "edit 2"}
+	if len(revisions) != len(want) {
+		t.Fatalf("there should be %d revisions. There are %d: %+v", len(want), len(revisions), revisions)
+	}
+	for i, r := range revisions {
+		if r.Revision != i+1 || r.Content != want[i] || r.MessageId.String() != messageId {
+			t.Fatalf("revision %d should be %q. It's: %+v", i+1, want[i], r)
+		}
+	}
+	if revisions[2].CreatedAt.Before(revisions[0].CreatedAt) {
+		t.Fatalf("revisions should be timestamped in order: %+v", revisions)
+	}
+
+	// a message that hasn't been edited has no revisions
+	unedited := firstMessage(t, s, USER, other)
+	if revisions, err := s.ListRevisions(USER, other.String(), unedited); err != nil || len(revisions) != 0 {
+		t.Fatalf("an unedited message should have no revisions. Got %v, %v", revisions, err)
+	}
+}
+
+func TestEditMessage_Ownership(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	other := seedConversation(t, s, USER)
+	messageId := firstMessage(t, s, USER, convoId)
+
+	tests := []struct {
+		name                   string
+		user, convo, messageId string
+	}{
+		{"another user", "Miss_Take", convoId.String(), messageId},
+		{"message of another conversation", USER, other.String(), messageId},
+		{"missing message", USER, convoId.String(), uuid.New().String()},
+		{"missing conversation", USER, uuid.New().String(), messageId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.EditMessage(tt.user, tt.convo, tt.messageId, "mine now", AnyVersion); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("EditMessage should return ErrNotFound. It returned: %v", err)
+			}
+			if _, err := s.ListRevisions(tt.user, tt.convo, tt.messageId); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("ListRevisions should return ErrNotFound. It returned: %v", err)
+			}
+		})
+	}
+	if messages, _ := s.GetConversation(USER, convoId.String()); messages[0].Content != "Hello, world" {
+		t.Fatalf("the message shouldn't change. It's: %q", messages[0].Content)
+	}
+}
+
+func TestEditMessage_Encrypted(t *testing.T) {
+	d := newEncryptedTestDB(t)
+	s := d.open(t, testKey)
+	convoId := seedConversation(t, s, USER)
+	messageId := firstMessage(t, s, USER, convoId)
+	if _, err := s.EditMessage(USER, convoId.String(), messageId, "edited", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw string
+	s.db.Raw("SELECT content FROM message_revisions").Scan(&raw)
+	if !strings.HasPrefix(raw, encryptedPrefix) {
+		t.Fatalf("revisions should be encrypted on disk. It's: %q", raw)
+	}
+	if raw := rawContent(t, s, uuid.MustParse(messageId)); !strings.HasPrefix(raw, encryptedPrefix) {
+		t.Fatalf("the edited content should be encrypted on disk. It's: %q", raw)
+	}
+	s.Close()
+
+	if _, err := Rekey(d.path, d.logPath, testKey, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	s = d.open(t, otherKey)
+	revisions, err := s.ListRevisions(USER, convoId.String(), messageId)
+	if err != nil || len(revisions) != 1 || revisions[0].Content != "Hello, world" {
+		t.Fatalf("revisions should be readable with the new key. Got %+v, %v", revisions, err)
+	}
+}
+
+func TestPurgeTrash_RemovesRevisions(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	convoId := seedConversation(t, s, USER)
+	if _, err := s.EditMessage(USER, convoId.String(), firstMessage(t, s, USER, convoId), "edited", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PurgeTrash(-time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	s.db.Model(&structs.MessageRevision{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("purging a conversation should remove the revisions of its messages. There are %d left", count)
+	}
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"chat-history/structs"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// maxToolFieldLength is the longest tool name or tool call id a message can have
+const maxToolFieldLength = 256
+
+// builtinRoles are the roles a message can always have
+var builtinRoles = []structs.MessagengerRole{structs.UserRole, structs.AssistantRole, structs.SystemRole, structs.ContextRole}
+
+// roleSet is the roles messages can have: the built-in ones and the ones MessageRoles adds, in that order
+type roleSet []structs.MessagengerRole
+
+func newRoleSet(extra []structs.MessagengerRole) roleSet {
+	roles := slices.Clone(builtinRoles)
+	for _, r := range extra {
+		if !slices.Contains(roles, r) {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+func (r roleSet) valid(role structs.MessagengerRole) bool {
+	return role != "" && slices.Contains(r, role)
+}
+
+// String lists the roles for error messages, i.e., "user, assistant, system or context"
+func (r roleSet) String() string {
+	names := make([]string, len(r))
+	for i, role := range r {
+		names[i] = string(role)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// validateTool checks the tool fields of a message. Tool results need the id of the call they answer and
+// function results the name of the function, like the LLM APIs that have them
+func validateTool(m structs.Message) error {
+	switch {
+	case utf8.RuneCountInString(m.ToolName) > maxToolFieldLength:
+		return fmt.Errorf("tool_name must be at most %d characters", maxToolFieldLength)
+	case utf8.RuneCountInString(m.ToolCallId) > maxToolFieldLength:
+		return fmt.Errorf("tool_call_id must be at most %d characters", maxToolFieldLength)
+	case m.Role == structs.ToolRole && m.ToolCallId == "":
+		return fmt.Errorf("tool_call_id is required for %s messages", structs.ToolRole)
+	case m.Role == structs.FunctionRole && m.ToolName == "":
+		return fmt.Errorf("tool_name is required for %s messages", structs.FunctionRole)
+	}
+	return nil
+}
+
+// HideContext leaves the structs.ContextRole messages out of messages, the whole conversation. The messages after
+// one have the message before it as their parent instead, so the history reads as though it wasn't there
+func HideContext(messages []structs.Message) []structs.Message {
+	var hidden []structs.Message
+	for _, m := range messages {
+		if m.Role == structs.ContextRole {
+			hidden = append(hidden, m)
+		}
+	}
+	return hideContext(messages, hidden)
+}
+
+// hideContext is HideContext for a page of the conversation's messages, hidden are all of its context messages
+func hideContext(messages, hidden []structs.Message) []structs.Message {
+	if len(hidden) == 0 {
+		return messages
+	}
+	parents := make(map[uuid.UUID]*uuid.UUID, len(hidden))
+	for _, m := range hidden {
+		parents[m.MessageId] = m.ParentId
+	}
+	visible := make([]structs.Message, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == structs.ContextRole {
+			continue
+		}
+		// context messages can follow one another
+		for seen := 0; m.ParentId != nil && seen < len(hidden); seen++ {
+			parent, ok := parents[*m.ParentId]
+			if !ok {
+				break
+			}
+			m.ParentId = parent
+		}
+		visible = append(visible, m)
+	}
+	return visible
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageRoles(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), MessageRoles(structs.ToolRole, structs.FunctionRole))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	convoId := seedConversation(t, s, USER)
+	first, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tool := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &first[0].MessageId, Content: `{"flagged": 12}`, Role: structs.ToolRole, ToolName: "count_flagged", ToolCallId: "call_42"}
+	if _, err := s.AppendMessage(tool, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("the tool message should be stored. Got %v, %v", messages, err)
+	}
+	if got := messages[1]; got.Role != structs.ToolRole || got.ToolName != "count_flagged" || got.ToolCallId != "call_42" || got.Content != tool.Content {
+		t.Fatalf("the tool message should be read back with its metadata. Got %+v", got)
+	}
+
+	// the copies of a fork keep it
+	fork, err := s.ForkConversation(USER, convoId.String(), tool.MessageId.String(), USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copies, _ := s.GetConversation(USER, fork.ConversationId.String()); len(copies) != 2 || copies[1].ToolCallId != "call_42" || copies[1].ToolName != "count_flagged" {
+		t.Fatalf("the fork should keep the tool metadata. Got %+v", copies)
+	}
+
+	invalid := []structs.Message{
+		{Content: "no call id", Role: structs.ToolRole},
+		{Content: "no name", Role: structs.FunctionRole, ToolCallId: "call_43"},
+		{Content: "too long", Role: structs.ToolRole, ToolCallId: strings.Repeat("x", maxToolFieldLength+1)},
+		{Content: "not configured", Role: "critic"},
+		{Content: "no role"},
+	}
+	for _, m := range invalid {
+		m.ConversationId, m.MessageId = convoId, uuid.New()
+		if _, err := s.AppendMessage(m, AnyVersion); !errors.Is(err, ErrInvalidMessages) {
+			t.Errorf("%q should be rejected. Got %v", m.Content, err)
+		}
+	}
+
+	// the roles have to be configured
+	tool.MessageId = uuid.New()
+	_, err = newTestStore(t).CreateConversation(USER, "tools", tool)
+	if !errors.Is(err, ErrInvalidMessages) || !strings.Contains(err.Error(), "role must be user, assistant, system or context") {
+		t.Fatalf("tool messages should be rejected unless their role is configured. Got %v", err)
+	}
+}
+
+func TestGetMessages_HidesContext(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	first, _ := s.GetConversation(USER, convoId.String())
+	question := first[0].MessageId
+
+	// two pieces of context, one after the other, and the reply to them
+	parent := question
+	var context []uuid.UUID
+	for _, content := range []string{"Account 7 is flagged", "Account 7 belongs to Bob"} {
+		m := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &parent, Content: content, Role: structs.ContextRole}
+		if _, err := s.AppendMessage(m, AnyVersion); err != nil {
+			t.Fatalf("context messages should be accepted without configuring their role: %v", err)
+		}
+		context = append(context, m.MessageId)
+		parent = m.MessageId
+	}
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), ParentId: &parent, Content: "Bob's account is flagged", Role: structs.AssistantRole}
+	if _, err := s.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, total, err := s.GetMessages(USER, convoId.String(), MessageOptions{})
+	if err != nil || total != 2 || len(messages) != 2 {
+		t.Fatalf("the context messages should be left out. Got %d of %d, %v", len(messages), total, err)
+	}
+	if got := messages[1]; got.MessageId != reply.MessageId || *got.ParentId != question {
+		t.Fatalf("the reply's parent should be the message before the context. Got %+v", got)
+	}
+	// on a page that doesn't have the context either
+	if page, _, _ := s.GetMessages(USER, convoId.String(), MessageOptions{Offset: 1}); len(page) != 1 || *page[0].ParentId != question {
+		t.Fatalf("the reply's parent should be the message before the context on every page. Got %+v", page)
+	}
+
+	all, total, err := s.GetMessages(USER, convoId.String(), MessageOptions{IncludeHidden: true})
+	if err != nil || total != 4 || all[1].MessageId != context[0] || *all[3].ParentId != context[1] {
+		t.Fatalf("IncludeHidden should return the messages as they are. Got %+v, %v", all, err)
+	}
+
+	// HideContext does the same to a whole conversation
+	stored, _ := s.GetConversation(USER, convoId.String())
+	if hidden := HideContext(stored); len(hidden) != 2 || *hidden[1].ParentId != question || len(stored) != 4 || *stored[3].ParentId != context[1] {
+		t.Fatalf("HideContext should leave the context out and not change what it's given. Got %+v", hidden)
+	}
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"chat-history/structs"
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// snippetRadius is the number of characters kept on either side of a match in a snippet
+const snippetRadius = 30
+
+// setupSearch creates the FTS5 index over message content and the triggers that keep it in sync.
+// It returns false if this build of SQLite doesn't have FTS5 (see the sqlite_fts5 build tag),
+// in which case searches fall back to LIKE.
+func setupSearch(db *gorm.DB) bool {
+	err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, content='messages', content_rowid='id')`).Error
+	if err != nil {
+		// a file indexed by an FTS5 build would otherwise fail every write to messages
+		for _, trigger := range []string{"messages_fts_ai", "messages_fts_ad", "messages_fts_au"} {
+			db.Exec("DROP TRIGGER IF EXISTS " + trigger)
+		}
+		return false
+	}
+
+	var triggers int64
+	db.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'`).Scan(&triggers)
+	if triggers == 3 {
+		return true
+	}
+
+	// the index is new, or was written without triggers. (re)create them and index what's already there
+	stmts := append(slices.Clone(searchTriggers), `INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`)
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTriggers keep messages_fts in sync with the content of messages
+var searchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_ai AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_ad AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_au AFTER UPDATE OF content ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+	END`,
+}
+
+// hasSearchIndex reports whether setupSearch already indexed the file and this build can read the index.
+// It doesn't write, for read-only stores
+func hasSearchIndex(db *gorm.DB) bool {
+	var triggers int64
+	db.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'`).Scan(&triggers)
+	if triggers != 3 {
+		return false
+	}
+	// fails without FTS5
+	return db.Exec(`SELECT rowid FROM messages_fts LIMIT 0`).Error == nil
+}
+
+func (s *sqliteStore) SearchMessages(userId, query string) ([]structs.SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []structs.SearchResult{}
+	if strings.TrimSpace(query) == "" {
+		return results, nil
+	}
+
+	// the index only has ciphertext for encrypted messages, they're searched below once decrypted
+	if s.fts && s.sealer == nil {
+		// quote the query so it's matched as a phrase instead of parsed as FTS syntax
+		phrase := `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+		// bm25 is more negative for better matches, flip it so a higher rank is more relevant
+		inTenant, args := s.rawTenantFilter("c", phrase, userId)
+		tx := s.db.Raw(`
+			SELECT m.conversation_id, m.message_id,
+				snippet(messages_fts, 0, '', '', '...', 16) AS snippet,
+				-bm25(messages_fts) AS rank
+			FROM messages_fts
+			JOIN messages m ON m.id = messages_fts.rowid
+			JOIN conversations c ON c.conversation_id = m.conversation_id
+			WHERE messages_fts MATCH ? AND c.user_id = ? AND m.deleted_at IS NULL AND c.deleted_at IS NULL`+inTenant+`
+			ORDER BY rank DESC`, args...).Scan(&results)
+		return results, tx.Error
+	}
+
+	messages, err := s.matchingMessages(userId, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		snippet, count := snippetOf(m.Content, query)
+		if count == 0 {
+			continue
+		}
+		results = append(results, structs.SearchResult{
+			ConversationId: m.ConversationId,
+			MessageId:      m.MessageId,
+			Snippet:        snippet,
+			Rank:           float64(count),
+		})
+	}
+	// stable so equally ranked results keep insertion order
+	slices.SortStableFunc(results, func(a, b structs.SearchResult) int {
+		return cmp.Compare(b.Rank, a.Rank)
+	})
+	return results, nil
+}
+
+// matchingMessages returns the user's messages that have query in their content, decrypted. Encrypted messages
+// can't be matched in SQL, so they're all returned for the caller to match
+func (s *sqliteStore) matchingMessages(userId, query string) ([]structs.Message, error) {
+	messages := []structs.Message{}
+	tx := s.db.Joins("JOIN conversations c ON c.conversation_id = messages.conversation_id AND c.deleted_at IS NULL").
+		Where("c.user_id = ?", userId)
+	if s.sealer == nil {
+		tx = tx.Where("LOWER(messages.content) LIKE ? ESCAPE '\\'", likePattern(query))
+	}
+	if err := tx.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// SearchWeights scale the ranks of Search's matches on conversation names, tags and message content.
+// Matches of a kind with a 0 weight are left out
+type SearchWeights struct {
+	Title   float64
+	Tags    float64
+	Content float64
+}
+
+// DefaultSearchWeights rank a name above a tag, and a tag above a message, that match as well
+var DefaultSearchWeights = SearchWeights{Title: 3, Tags: 2, Content: 1}
+
+func (s *sqliteStore) Search(userId, query string, weights SearchWeights) ([]structs.CombinedSearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []structs.CombinedSearchResult{}
+	if strings.TrimSpace(query) == "" {
+		return results, nil
+	}
+
+	convos := []structs.Conversation{}
+	if err := s.db.Where("user_id = ? AND LOWER(name) LIKE ? ESCAPE '\\'", userId, likePattern(query)).Find(&convos).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range convos {
+		if snippet, rank := weightedMatch(c.Name, query, weights.Title); rank > 0 {
+			results = append(results, structs.CombinedSearchResult{
+				Type:           structs.ResultTitle,
+				ConversationId: c.ConversationId,
+				Name:           c.Name,
+				Snippet:        snippet,
+				Rank:           rank,
+			})
+		}
+	}
+
+	var tags []struct {
+		ConversationId uuid.UUID
+		Name           string
+		Tag            string
+	}
+	inTenant, args := s.rawTenantFilter("c", userId, likePattern(query))
+	tx := s.db.Raw(`
+		SELECT t.conversation_id, c.name, t.tag
+		FROM conversation_tags t
+		JOIN conversations c ON c.conversation_id = t.conversation_id
+		WHERE c.user_id = ? AND c.deleted_at IS NULL AND LOWER(t.tag) LIKE ? ESCAPE '\'`+inTenant, args...).Scan(&tags)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, t := range tags {
+		if snippet, rank := weightedMatch(t.Tag, query, weights.Tags); rank > 0 {
+			results = append(results, structs.CombinedSearchResult{
+				Type:           structs.ResultTag,
+				ConversationId: t.ConversationId,
+				Name:           t.Name,
+				Snippet:        snippet,
+				Rank:           rank,
+			})
+		}
+	}
+
+	messages, err := s.matchingMessages(userId, query)
+	if err != nil {
+		return nil, err
+	}
+	names := map[uuid.UUID]string{}
+	if len(messages) > 0 {
+		ids := []uuid.UUID{}
+		for _, m := range messages {
+			if _, ok := names[m.ConversationId]; !ok {
+				names[m.ConversationId] = ""
+				ids = append(ids, m.ConversationId)
+			}
+		}
+		convos := []structs.Conversation{}
+		if err := s.db.Where("conversation_id IN ?", ids).Find(&convos).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range convos {
+			names[c.ConversationId] = c.Name
+		}
+	}
+	for _, m := range messages {
+		if snippet, rank := weightedMatch(m.Content, query, weights.Content); rank > 0 {
+			results = append(results, structs.CombinedSearchResult{
+				Type:           structs.ResultMessage,
+				ConversationId: m.ConversationId,
+				Name:           names[m.ConversationId],
+				MessageId:      &m.MessageId,
+				Snippet:        snippet,
+				Rank:           rank,
+			})
+		}
+	}
+
+	// stable so equally ranked results keep the order of names, then tags, then messages
+	slices.SortStableFunc(results, func(a, b structs.CombinedSearchResult) int {
+		return cmp.Compare(b.Rank, a.Rank)
+	})
+	return results, nil
+}
+
+// weightedMatch returns the snippet of query in text, and weight times the fraction of text that's matches of
+// query. A match of the whole text ranks weight. It's 0 if query isn't in text
+func weightedMatch(text, query string, weight float64) (string, float64) {
+	snippet, count := snippetOf(text, query)
+	if count == 0 {
+		return "", 0
+	}
+	matched := float64(count * utf8.RuneCountInString(query))
+	return snippet, weight * matched / float64(utf8.RuneCountInString(text))
+}
+
+func likePattern(query string) string {
+	return "%" + escapeLike(strings.ToLower(query)) + "%"
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// snippetOf returns the text around the first case-insensitive match of query in content,
+// and the number of times query appears
+func snippetOf(content, query string) (string, int) {
+	text := []rune(content)
+	lower := []rune(strings.Map(unicode.ToLower, content))
+	q := []rune(strings.Map(unicode.ToLower, query))
+
+	first, count := -1, 0
+	for i := 0; i+len(q) <= len(lower); i++ {
+		if string(lower[i:i+len(q)]) == string(q) {
+			if first < 0 {
+				first = i
+			}
+			count++
+			i += len(q) - 1
+		}
+	}
+	if first < 0 {
+		return "", 0
+	}
+
+	start, end := max(0, first-snippetRadius), min(len(text), first+len(q)+snippetRadius)
+	snippet := string(text[start:end])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(text) {
+		snippet += "..."
+	}
+	return snippet, count
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"chat-history/structs"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrShareExpired is returned for the token of a share link past its expiry
+	ErrShareExpired = errors.New("share link has expired")
+	// ErrShareRevoked is returned for the token of a share link the owner revoked
+	ErrShareRevoked = errors.New("share link has been revoked")
+)
+
+// a token is the share id and expiry (unix seconds), then their HMAC-SHA256, base64url encoded
+const (
+	sharePayloadSize = 16 + 8
+	shareTokenSize   = sharePayloadSize + sha256.Size
+)
+
+// newShareKey returns key, or a random one if it's empty
+func newShareKey(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("share key must be at least 16 bytes. It's %d", len(key))
+	}
+	return key, nil
+}
+
+// signShare returns the token of the link
+func (s *sqliteStore) signShare(link structs.ShareLink) string {
+	payload := make([]byte, sharePayloadSize, shareTokenSize)
+	copy(payload, link.ShareId[:])
+	binary.BigEndian.PutUint64(payload[16:], uint64(link.ExpiresAt.Unix()))
+	mac := hmac.New(sha256.New, s.shareKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload))
+}
+
+// verifyShare returns the share id and expiry in the token if it was signed with the store's key
+func (s *sqliteStore) verifyShare(token string) (uuid.UUID, time.Time, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != shareTokenSize {
+		return uuid.UUID{}, time.Time{}, false
+	}
+	payload, sig := raw[:sharePayloadSize], raw[sharePayloadSize:]
+	mac := hmac.New(sha256.New, s.shareKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return uuid.UUID{}, time.Time{}, false
+	}
+	shareId, _ := uuid.FromBytes(payload[:16])
+	return shareId, time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0), true
+}
+
+func (s *sqliteStore) CreateShareLink(userId, conversationId string, ttl time.Duration) (*structs.ShareLink, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	// the token only has whole seconds
+	link := structs.ShareLink{
+		ShareId:        uuid.New(),
+		ConversationId: convoId,
+		UserId:         userId,
+		ExpiresAt:      s.now().Add(ttl).Truncate(time.Second),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&link).Error; err != nil {
+			return err
+		}
+		return appendEvent(tx, structs.ConversationEvent{Type: EventConversationShared, ConversationId: convoId, UserId: userId, Detail: link.ShareId.String()})
+	})
+	if err != nil {
+		return nil, err
+	}
+	link.Token = s.signShare(link)
+	return &link, nil
+}
+
+func (s *sqliteStore) ListShareLinks(userId, conversationId string) ([]structs.ShareLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	links := []structs.ShareLink{}
+	if err := s.db.Where("conversation_id = ?", convoId).Order("created_at DESC, rowid DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].Token = s.signShare(links[i])
+	}
+	return links, nil
+}
+
+func (s *sqliteStore) RevokeShareLink(userId, conversationId, shareId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return err
+	}
+	link := structs.ShareLink{}
+	tx := s.db.Where("share_id = ? AND conversation_id = ?", shareId, convoId).First(&link)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	} else if tx.Error != nil {
+		return tx.Error
+	}
+	// revoking again keeps when it was first revoked
+	if link.RevokedAt != nil {
+		return nil
+	}
+	return s.db.Model(&link).Update("revoked_at", s.now()).Error
+}
+
+func (s *sqliteStore) GetSharedConversation(token string) (*structs.Conversation, []structs.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shareId, expiresAt, ok := s.verifyShare(token)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	link := structs.ShareLink{}
+	tx := s.db.Where("share_id = ?", shareId).First(&link)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+	if !link.ExpiresAt.Equal(expiresAt) {
+		return nil, nil, ErrNotFound
+	}
+	if link.RevokedAt != nil {
+		return nil, nil, ErrShareRevoked
+	}
+	if !s.now().Before(link.ExpiresAt.Add(s.clockSkew)) {
+		return nil, nil, ErrShareExpired
+	}
+
+	// links stop working while the conversation is in the trash
+	convo := structs.Conversation{}
+	tx = s.db.Where("user_id = ? AND conversation_id = ?", link.UserId, link.ConversationId).First(&convo)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+	messages := []structs.Message{}
+	if err := s.db.Where("conversation_id = ?", convo.ConversationId).Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, nil, err
+	}
+	return &convo, messages, nil
+}
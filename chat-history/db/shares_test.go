@@ -0,0 +1,237 @@
+package db
+
+import (
+	"chat-history/clock"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestShareLink(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Token == "" || link.ConversationId != convoId || link.UserId != USER || time.Until(link.ExpiresAt) > time.Hour {
+		t.Fatalf("the link should be to the conversation for an hour: %+v", link)
+	}
+
+	// anyone with the token can read the conversation
+	convo, messages, err := s.GetSharedConversation(link.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.ConversationId != convoId || len(messages) != 1 || messages[0].Content != "Hello, world" {
+		t.Fatalf("the shared conversation should be returned. Got %+v, %+v", convo, messages)
+	}
+
+	// the owner sees the link with the same token
+	links, err := s.ListShareLinks(USER, convoId.String())
+	if err != nil || len(links) != 1 || links[0].ShareId != link.ShareId || links[0].Token != link.Token {
+		t.Fatalf("the owner should see the link. Got %+v, %v", links, err)
+	}
+	if _, err := s.ListShareLinks("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't see the links. It returned: %v", err)
+	}
+	if _, err := s.CreateShareLink("Miss_Take", convoId.String(), time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't share the conversation. It returned: %v", err)
+	}
+}
+
+func TestShareLink_Expired(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetSharedConversation(link.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("an expired token should return ErrShareExpired. It returned: %v", err)
+	}
+}
+
+func TestShareLink_ExpiresOnClock(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), Clock(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fake.Now().Add(time.Hour); !link.ExpiresAt.Equal(want) {
+		t.Fatalf("the link should expire at %v, it expires at %v", want, link.ExpiresAt)
+	}
+
+	fake.Advance(59 * time.Minute)
+	if _, _, err := s.GetSharedConversation(link.Token); err != nil {
+		t.Fatalf("the link should work until it expires, got: %v", err)
+	}
+	fake.Advance(time.Minute)
+	if _, _, err := s.GetSharedConversation(link.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("the link should expire after an hour, got: %v", err)
+	}
+}
+
+func TestShareLink_ClockSkew(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), Clock(fake), ClockSkew(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the link works for the leeway past its expiry, and not after
+	fake.Advance(time.Hour + 29*time.Second)
+	if _, _, err := s.GetSharedConversation(link.Token); err != nil {
+		t.Fatalf("the link should work just inside the leeway, got: %v", err)
+	}
+	fake.Advance(time.Second)
+	if _, _, err := s.GetSharedConversation(link.Token); !errors.Is(err, ErrShareExpired) {
+		t.Fatalf("the link should expire just outside the leeway, got: %v", err)
+	}
+	// and it's still listed as it was made
+	if links, _ := s.ListShareLinks(USER, convoId.String()); len(links) != 1 || !links[0].ExpiresAt.Equal(link.ExpiresAt) {
+		t.Fatalf("the leeway shouldn't change when the link expires: %+v", links)
+	}
+}
+
+func TestShareLink_Revoked(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RevokeShareLink("Miss_Take", convoId.String(), link.ShareId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't revoke the link. It returned: %v", err)
+	}
+	if err := s.RevokeShareLink(USER, convoId.String(), uuid.New().String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a missing link should return ErrNotFound. It returned: %v", err)
+	}
+	if err := s.RevokeShareLink(USER, convoId.String(), link.ShareId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetSharedConversation(link.Token); !errors.Is(err, ErrShareRevoked) {
+		t.Fatalf("a revoked token should return ErrShareRevoked. It returned: %v", err)
+	}
+	if _, _, err := s.GetSharedConversation(kept.Token); err != nil {
+		t.Fatalf("other links should keep working. It returned: %v", err)
+	}
+
+	// revoked links are still listed, newest first
+	links, _ := s.ListShareLinks(USER, convoId.String())
+	if len(links) != 2 || links[0].ShareId != kept.ShareId || links[1].RevokedAt == nil || links[0].RevokedAt != nil {
+		t.Fatalf("both links should be listed with the revoked one marked: %+v", links)
+	}
+}
+
+func TestShareLink_InvalidToken(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flipping a character of the id, expiry or signature invalidates it
+	tampered := []string{"", "not-a-token", link.Token[:len(link.Token)-1]}
+	for _, i := range []int{0, 25, len(link.Token) - 2} {
+		c := "A"
+		if link.Token[i] == 'A' {
+			c = "B"
+		}
+		tampered = append(tampered, link.Token[:i]+c+link.Token[i+1:])
+	}
+	for _, token := range tampered {
+		if _, _, err := s.GetSharedConversation(token); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("token %q should return ErrNotFound. It returned: %v", token, err)
+		}
+	}
+
+	// another store signs with another key
+	other := newTestStore(t)
+	otherConvo := seedConversation(t, other, USER)
+	otherLink, _ := other.CreateShareLink(USER, otherConvo.String(), time.Hour)
+	if _, _, err := s.GetSharedConversation(otherLink.Token); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a token of another store should return ErrNotFound. It returned: %v", err)
+	}
+}
+
+func TestShareLink_ShareKey(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+	key := []byte(strings.Repeat("k", 32))
+
+	s, err := NewSQLiteStore(pth, logPth, ShareKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// the link works after a restart with the same key
+	s, err = NewSQLiteStore(pth, logPth, ShareKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, err := s.GetSharedConversation(link.Token); err != nil {
+		t.Fatalf("the token should be valid with the same key. It returned: %v", err)
+	}
+
+	if _, err := NewSQLiteStore(pth, logPth, ShareKey([]byte("short"))); err == nil {
+		t.Fatal("a share key under 16 bytes should be rejected")
+	}
+}
+
+func TestShareLink_Trash(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetSharedConversation(link.Token); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a link to a conversation in the trash should return ErrNotFound. It returned: %v", err)
+	}
+
+	if _, err := s.PurgeTrash(-time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	s.db.Table("share_links").Count(&count)
+	if count != 0 {
+		t.Fatalf("purging a conversation should remove its links. There are %d left", count)
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"chat-history/structs"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) UserStats(userId string) (*structs.UserStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var row struct {
+		Conversations int64
+		Messages      int64
+		// aggregates of timestamps are read as text, they have no column type
+		FirstActivity *string
+		LastActivity  *string
+	}
+	err := s.db.Model(&structs.Conversation{}).
+		Select("COUNT(DISTINCT conversations.conversation_id) AS conversations, COUNT(messages.id) AS messages, "+
+			"MIN(messages.created_at) AS first_activity, MAX(messages.updated_at) AS last_activity").
+		Joins("LEFT JOIN messages ON messages.conversation_id = conversations.conversation_id AND messages.deleted_at IS NULL").
+		Where("conversations.user_id = ? AND conversations.deleted_at IS NULL", userId).
+		Scan(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	stats := structs.UserStats{UserId: userId, Conversations: row.Conversations, Messages: row.Messages}
+	if stats.FirstActivity, err = parseTimestamp(row.FirstActivity); err != nil {
+		return nil, err
+	}
+	if stats.LastActivity, err = parseTimestamp(row.LastActivity); err != nil {
+		return nil, err
+	}
+	if row.Conversations > 0 {
+		stats.MessagesPerConversation = float64(row.Messages) / float64(row.Conversations)
+	}
+	return &stats, nil
+}
+
+// parseTimestamp reads a time the way the driver does for datetime columns. It's nil if ts is
+func parseTimestamp(ts *string) (*time.Time, error) {
+	if ts == nil {
+		return nil, nil
+	}
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, strings.TrimSuffix(*ts, "Z"), time.UTC); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("can't read timestamp %q", *ts)
+}
+
+func (s *sqliteStore) RecentConversations(limit int, cursorToken string) ([]structs.ActiveConversation, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var c *cursor
+	if cursorToken != "" {
+		decoded, err := decodeCursor(cursorToken)
+		if err != nil {
+			return nil, "", err
+		}
+		c = &decoded
+	}
+
+	var rows []activeRow
+	if err := recentConversations(s.db, c, limit).Scan(&rows).Error; err != nil {
+		return nil, "", err
+	}
+	convos := make([]structs.ActiveConversation, len(rows))
+	for i, row := range rows {
+		last, err := parseTimestamp(row.LastMessageAt)
+		if err != nil {
+			return nil, "", err
+		}
+		convos[i] = structs.ActiveConversation{
+			ConversationId: row.ConversationId,
+			UserId:         row.UserId,
+			Name:           row.Name,
+			UpdatedAt:      row.UpdatedAt,
+			LastMessageAt:  last,
+			MessageCount:   row.MessageCount,
+			ID:             row.ID,
+		}
+	}
+
+	next := ""
+	if limit > 0 && len(convos) > limit {
+		convos = convos[:limit]
+		last := convos[len(convos)-1]
+		next = encodeCursor(cursor{UpdatedAt: last.UpdatedAt, ID: last.ID})
+	}
+	return convos, next, nil
+}
+
+// activeRow is a row of recentConversations
+type activeRow struct {
+	ID             uint
+	ConversationId uuid.UUID
+	UserId         string
+	Name           string
+	UpdatedAt      time.Time
+	MessageCount   int
+	// read as text like UserStats' activity
+	LastMessageAt *string
+}
+
+// recentConversations selects the conversations after c, most recently updated first, one more than the limit
+// to know if there is another page. It's one query: the page is read from idx_conversations_updated, and each
+// conversation's messages from idx_messages_conversation_deleted
+func recentConversations(db *gorm.DB, c *cursor, limit int) *gorm.DB {
+	tx := db.Model(&structs.Conversation{}).
+		Select("conversations.id, conversations.conversation_id, conversations.user_id, conversations.name, conversations.updated_at, " +
+			"(SELECT COUNT(*) FROM messages WHERE messages.conversation_id = conversations.conversation_id AND messages.deleted_at IS NULL) AS message_count, " +
+			"(SELECT MAX(messages.created_at) FROM messages WHERE messages.conversation_id = conversations.conversation_id AND messages.deleted_at IS NULL) AS last_message_at").
+		Order("conversations.updated_at DESC").Order("conversations.id DESC")
+	if c != nil {
+		// a row value, so the index is searched from the cursor instead of scanned
+		tx = tx.Where("(conversations.updated_at, conversations.id) < (?, ?)", c.UpdatedAt, c.ID)
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit + 1)
+	}
+	return tx
+}
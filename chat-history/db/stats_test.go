@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestUserStats(t *testing.T) {
+	s := newTestStore(t)
+	first := seedConversation(t, s, USER)
+	seedReplies(t, s, first, 2)
+	second := seedConversation(t, s, USER)
+	// deleted conversations and other users' aren't counted
+	deleted := seedConversation(t, s, USER)
+	seedReplies(t, s, deleted, 3)
+	if err := s.DeleteConversation(USER, deleted.String()); err != nil {
+		t.Fatal(err)
+	}
+	seedReplies(t, s, seedConversation(t, s, "Miss_Take"), 5)
+
+	stats, err := s.UserStats(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.UserId != USER || stats.Conversations != 2 || stats.Messages != 4 || stats.MessagesPerConversation != 2 {
+		t.Fatalf("expected 2 conversations with 4 messages, 2 each on average. Got %+v", stats)
+	}
+	firstMessages, err := s.GetConversation(USER, first.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondMessages, err := s.GetConversation(USER, second.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.FirstActivity == nil || !stats.FirstActivity.Equal(firstMessages[0].CreatedAt) {
+		t.Fatalf("the first activity should be the first message, at %v. It's %v", firstMessages[0].CreatedAt, stats.FirstActivity)
+	}
+	if stats.LastActivity == nil || !stats.LastActivity.Equal(secondMessages[0].UpdatedAt) {
+		t.Fatalf("the last activity should be the latest message, at %v. It's %v", secondMessages[0].UpdatedAt, stats.LastActivity)
+	}
+
+	// a user without conversations
+	stats, err = s.UserStats("Mr_Nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Conversations != 0 || stats.Messages != 0 || stats.MessagesPerConversation != 0 || stats.FirstActivity != nil || stats.LastActivity != nil {
+		t.Fatalf("a user without conversations should have empty stats: %+v", stats)
+	}
+}
+
+func TestRecentConversations(t *testing.T) {
+	s := newTestStore(t)
+	oldest := seedConversation(t, s, USER)
+	other := seedConversation(t, s, "Miss_Take")
+	seedReplies(t, s, other, 2)
+	deleted := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, deleted.String()); err != nil {
+		t.Fatal(err)
+	}
+	latest := seedConversation(t, s, USER)
+	// a new message makes a conversation the most recently active
+	seedReplies(t, s, oldest, 1)
+
+	page, next, err := s.RecentConversations(2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ConversationId != oldest || page[1].ConversationId != latest || next == "" {
+		t.Fatalf("the most recently updated conversations should come first, with a cursor for the rest. Got %+v, %q", page, next)
+	}
+	messages, _ := s.GetConversation(USER, oldest.String())
+	if page[0].UserId != USER || page[0].MessageCount != 2 || page[0].LastMessageAt == nil || !page[0].LastMessageAt.Equal(messages[1].CreatedAt) {
+		t.Fatalf("the conversation's owner, message count and latest message should be returned: %+v", page[0])
+	}
+
+	page, next, err = s.RecentConversations(2, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deleted conversation is left out
+	if len(page) != 1 || page[0].ConversationId != other || page[0].UserId != "Miss_Take" || page[0].MessageCount != 3 || next != "" {
+		t.Fatalf("the last page should have every user's other conversation. Got %+v, %q", page, next)
+	}
+
+	if all, _, err := s.RecentConversations(0, ""); err != nil || len(all) != 3 {
+		t.Fatalf("no limit should return all of them. Got %d, %v", len(all), err)
+	}
+	if _, _, err := s.RecentConversations(2, "not a cursor"); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got: %v", err)
+	}
+}
+
+func TestRecentConversations_Plan(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	query := s.db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var rows []activeRow
+		return recentConversations(tx, &cursor{UpdatedAt: time.Now(), ID: 10}, 50).Scan(&rows)
+	})
+	var plan []struct{ Detail string }
+	if err := s.db.Raw("EXPLAIN QUERY PLAN " + query).Scan(&plan).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the page is searched in the index, not sorted
+	details := make([]string, len(plan))
+	for i, step := range plan {
+		details[i] = step.Detail
+	}
+	if !strings.Contains(details[0], "SEARCH conversations USING INDEX idx_conversations_updated") || slices.ContainsFunc(details, func(d string) bool {
+		return strings.Contains(d, "TEMP B-TREE") || strings.HasPrefix(d, "SCAN")
+	}) {
+		t.Fatalf("the page should be read from idx_conversations_updated. The plan is %q", details)
+	}
+}
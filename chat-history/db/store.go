@@ -0,0 +1,1042 @@
+package db
+
+import (
+	"chat-history/clock"
+	"chat-history/db/migrations"
+	"chat-history/moderation"
+	"chat-history/structs"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ConversationStore persists conversations and their messages.
+// Handlers depend on this interface so tests can swap in a fake.
+// Methods that write return ErrReadOnly if the store was opened with ReadOnly.
+type ConversationStore interface {
+	// CreateConversation creates a new conversation for the user with message as its first message.
+	// If message has no conversation id, the conversation gets a new one from the store's IDGenerator.
+	// message must have a valid role and content, or an error wrapping ErrInvalidMessages is returned, and an error
+	// wrapping ErrBlocked if the store's moderator blocks it (see Moderate). Content over MaxMessageChars is
+	// truncated, or rejected with an error wrapping ErrMessageTooLong
+	CreateConversation(userId, name string, message structs.Message) (*structs.Conversation, error)
+	// CreateConversationOnce is CreateConversation for a request with an idempotency key. If the user created a
+	// conversation with the same key less than window ago, and still has it, that one is returned with created
+	// false instead of creating another. Keys are the user's own, other users can use the same ones
+	CreateConversationOnce(userId, key, name string, message structs.Message, window time.Duration) (convo *structs.Conversation, created bool, err error)
+	// CreateConversationWithExternalId is CreateConversation for a conversation synced from another system, which
+	// knows it by externalId. If the user already has a conversation with that external id, it's returned with
+	// created false instead of creating another, whatever message is. If it's in the trash or the archive, an
+	// error wrapping ErrExternalIdTaken is returned. External ids are the user's own, other users can use the same ones
+	CreateConversationWithExternalId(userId, externalId, name string, message structs.Message) (convo *structs.Conversation, created bool, err error)
+	// FindConversationByExternalId returns the user's conversation with the external id, or ErrNotFound
+	FindConversationByExternalId(userId, externalId string) (*structs.Conversation, error)
+	// FindConversation returns the conversation with the id regardless of its owner, or ErrNotFound
+	FindConversation(conversationId string) (*structs.Conversation, error)
+	// GetConversation returns the messages of a conversation if it belongs to the user
+	GetConversation(userId, conversationId string) ([]structs.Message, error)
+	// GetMessages returns a page of the messages of a conversation if it belongs to the user, oldest first, and
+	// how many messages match opts' filters in all. Like GetConversation, it's empty when the user doesn't have it
+	GetMessages(userId, conversationId string, opts MessageOptions) ([]structs.Message, int64, error)
+	// ListConversations returns a page of the user's conversations, the pinned ones first, most recently pinned
+	// first, then the others most recently updated first, and the cursor for the next page. The cursor is empty when there are no more pages.
+	ListConversations(userId string, opts ListOptions) ([]structs.Conversation, string, error)
+	// UserStats returns the totals of the user's conversations that aren't deleted or archived. Activity is when
+	// their first message was written and when their latest one was written or changed, i.e., edited or rated
+	UserStats(userId string) (*structs.UserStats, error)
+	// TokenUsage returns the tokens of the user's conversations that aren't deleted or archived, in total and each
+	// one's. They're counted with TokenCounter as messages are written, so this doesn't count them again
+	TokenUsage(userId string) (*structs.TokenUsage, error)
+	// RecountTokens counts the tokens of the conversation's messages again, sets its total to theirs and returns
+	// it, or ErrNotFound. It's for messages written before their tokens were counted, or with another counter
+	RecountTokens(conversationId string) (int64, error)
+	// RecentConversations returns a page of every user's conversations, most recently updated first, with their
+	// message count and when their latest message was written, and the cursor for the next page like
+	// ListConversations. Conversations in the trash or the archive are left out
+	RecentConversations(limit int, cursorToken string) ([]structs.ActiveConversation, string, error)
+	// AppendMessage adds a message to an existing conversation, or updates its feedback if it already exists.
+	// A new message must have a valid role and content, or an error wrapping ErrInvalidMessages is returned,
+	// and it isn't written if the store's moderator blocks it, which returns an error wrapping ErrBlocked.
+	// Its content is limited to MaxMessageChars like CreateConversation's.
+	// Unless expectedVersion is AnyVersion, it returns ErrVersionConflict if the conversation isn't at that version.
+	// It's all or nothing: if any of it fails, neither the message nor the conversation's new version is kept
+	AppendMessage(message structs.Message, expectedVersion int) (*structs.Conversation, error)
+	// SaveStreamedMessage saves a reply that's being streamed as it grows: it's added to its conversation the first
+	// time, and after that only its content, response time and Incomplete change. The message must be valid as
+	// for AppendMessage, and if it was saved complete it can't be changed anymore, which returns an error wrapping
+	// ErrInvalidMessages. Its message.appended event is only sent once it's saved complete
+	SaveStreamedMessage(message structs.Message) (*structs.Conversation, error)
+	// BulkAppendMessages adds the messages to the user's conversation in order, all in one transaction.
+	// The conversation is created with name if it doesn't exist. It returns an error wrapping
+	// ErrInvalidMessages if any of them can't be added, in which case none are
+	BulkAppendMessages(userId, conversationId, name string, messages []structs.Message) (*structs.Conversation, error)
+	// EditMessage replaces the content of a message in the user's conversation and returns it.
+	// What it was is kept as a revision. It returns ErrNotFound if the user doesn't have the conversation or message,
+	// and, unless expectedVersion is AnyVersion, ErrVersionConflict if the conversation isn't at that version.
+	// The new content is limited and moderated like AppendMessage's
+	EditMessage(userId, conversationId, messageId, content string, expectedVersion int) (*structs.Message, error)
+	// ListEvents returns a page of the timeline of the user's conversation, oldest first, and the cursor for the next
+	// page, empty on the last one. limit 0 returns all of it
+	ListEvents(userId, conversationId string, limit int, cursorToken string) ([]structs.ConversationEvent, string, error)
+	// ListRevisions returns the earlier contents of a message in the user's conversation, oldest first
+	ListRevisions(userId, conversationId, messageId string) ([]structs.MessageRevision, error)
+	// CreateShareLink mints a token that gives read access to the user's conversation for ttl,
+	// or returns ErrNotFound if the user doesn't have it
+	CreateShareLink(userId, conversationId string, ttl time.Duration) (*structs.ShareLink, error)
+	// ListShareLinks returns the links to the user's conversation, newest first, expired and revoked ones included
+	ListShareLinks(userId, conversationId string) ([]structs.ShareLink, error)
+	// RevokeShareLink stops the link from giving access, or returns ErrNotFound if it isn't a link to the user's conversation
+	RevokeShareLink(userId, conversationId, shareId string) error
+	// GrantAccess gives access.UserId access.Permission on the owner's conversation, replacing what they had,
+	// and returns the grant. It returns ErrNotFound if the owner doesn't have the conversation, or ErrInvalidAccess
+	// if the permission isn't read or write or the grant is to the owner
+	GrantAccess(ownerId, conversationId string, access structs.ConversationAccess) (*structs.ConversationAccess, error)
+	// RevokeAccess takes away the user's access to the owner's conversation, or returns ErrNotFound if they had none
+	RevokeAccess(ownerId, conversationId, userId string) error
+	// ListAccess returns the users granted access to the owner's conversation, in the order they were first granted it
+	ListAccess(ownerId, conversationId string) ([]structs.ConversationAccess, error)
+	// GetAccess returns the access the user was granted to the conversation, or ErrNotFound if they weren't.
+	// It doesn't check the conversation still exists
+	GetAccess(userId, conversationId string) (*structs.ConversationAccess, error)
+	// GetSharedConversation returns the conversation the token gives access to and its messages.
+	// It returns ErrNotFound if the token isn't valid, ErrShareExpired or ErrShareRevoked if it no longer gives access
+	GetSharedConversation(token string) (*structs.Conversation, []structs.Message, error)
+	// RenameConversation sets the name of the conversation, or returns ErrNotFound
+	RenameConversation(conversationId, name string) error
+	// SetConversationModel sets the model of the user's conversation and returns it, or returns ErrNotFound if
+	// the user doesn't have it. An empty model goes back to llm_config.model_name. It doesn't check the model is allowed
+	SetConversationModel(userId, conversationId, model string) (*structs.Conversation, error)
+	// GetMetadata returns the metadata of the user's conversation, empty if it has none, or ErrNotFound if the user
+	// doesn't have it
+	GetMetadata(userId, conversationId string) (map[string]string, error)
+	// SetMetadata sets the keys of the user's conversation's metadata to the values of updates, removing the ones that
+	// are nil and leaving the rest as they are, and returns the metadata. It returns ErrNotFound if the user doesn't
+	// have the conversation, or an error wrapping ErrInvalidMetadata if a key isn't 1 to 64 letters, digits, '_', '-'
+	// or '.', a value is longer than 1024 characters, or the metadata would have more than 64 keys or 16KiB
+	SetMetadata(userId, conversationId string, updates map[string]*string) (map[string]string, error)
+	// GetSystemPrompt returns the system prompt of the user's conversation, empty if it doesn't have one, or
+	// ErrNotFound if the user doesn't have it
+	GetSystemPrompt(userId, conversationId string) (string, error)
+	// SetSystemPrompt sets the system prompt of the user's conversation, or returns ErrNotFound if the user doesn't
+	// have it. An empty prompt goes back to llm_config.default_system_prompt
+	SetSystemPrompt(userId, conversationId, prompt string) error
+	// ForkConversation copies the owner's conversation up to and including messageId into a new conversation of
+	// userId's, whose parent_id is the original, and returns it. Only the branch that leads to the message is copied,
+	// following parent_id. It returns ErrNotFound if the owner doesn't have the conversation or it doesn't have the message
+	ForkConversation(ownerId, conversationId, messageId, userId string) (*structs.Conversation, error)
+	// CloneConversation copies all of the owner's conversation, every branch of it, into a new conversation of
+	// userId's and returns it. Unlike ForkConversation it has no parent_id, it's a conversation of its own that
+	// starts with the same messages. Messages keep their timestamps unless opts.ResetTimestamps is set.
+	// It returns ErrNotFound if the owner doesn't have the conversation
+	CloneConversation(ownerId, conversationId, userId string, opts CloneOptions) (*structs.Conversation, error)
+	// MergeConversations moves the messages of the user's source conversation that target doesn't already have, with
+	// the same role, content and create_ts, to the end of target in the order they were in, and then deletes source
+	// permanently. It returns the target, ErrNotFound if the user doesn't have both, or ErrMergeSelf
+	MergeConversations(userId, targetId, sourceId string) (*structs.Conversation, error)
+	// CreateTemplate adds a template owned by its UserId and returns it. It must have a title and at least one
+	// message, each with a valid role and content, or an error wrapping ErrInvalidTemplate is returned
+	CreateTemplate(template structs.Template) (*structs.Template, error)
+	// GetTemplate returns the template if it's the user's or shared, or else ErrNotFound
+	GetTemplate(userId, templateId string) (*structs.Template, error)
+	// ListTemplates returns the user's templates and the shared ones, by title
+	ListTemplates(userId string) ([]structs.Template, error)
+	// UpdateTemplate replaces the title, messages and sharing of one of the user's templates, validated as for
+	// CreateTemplate. It returns ErrNotFound if the user doesn't own it
+	UpdateTemplate(userId string, template structs.Template) (*structs.Template, error)
+	// DeleteTemplate deletes one of the user's templates, or returns ErrNotFound if they don't own it
+	DeleteTemplate(userId, templateId string) error
+	// CreateConversationFromTemplate starts a conversation of userId's, named name or else the template's title,
+	// with the template's messages, and returns it. The template must be one GetTemplate returns for userId
+	CreateConversationFromTemplate(userId, templateId, name string) (*structs.Conversation, error)
+	// TransferOwnership gives the conversation, with its messages, share links and access grants, to newUserId, or returns
+	// ErrNotFound. It doesn't check who's asking or that newUserId exists, callers must (see routes.AdminTransferOwnership)
+	TransferOwnership(conversationId, newUserId string) error
+	// DeleteAllForUser permanently deletes all of the user's conversations, with their messages, revisions,
+	// attachments, tags, share links and grants, including the ones in the trash and the archive, and takes away the
+	// access other users granted them and what they've read of others'. Each database
+	// is cleared in one transaction. It returns how many conversations were deleted, so 0 if there's nothing left
+	DeleteAllForUser(userId string) (int64, error)
+	// AddTag tags the user's conversation, or returns ErrNotFound if the user doesn't have it.
+	// Tags are trimmed and must be 1 to 64 characters, or ErrInvalidTag is returned
+	AddTag(userId, conversationId, tag string) error
+	// AddAttachment adds the attachment's metadata to a message in the user's conversation and returns it with
+	// its id. It returns ErrNotFound if the user doesn't have the message, an error wrapping ErrInvalidAttachment
+	// if the metadata isn't valid, or ErrTooManyAttachments if the message already has as many as it can
+	AddAttachment(userId, conversationId, messageId string, attachment structs.Attachment) (*structs.Attachment, error)
+	// ListAttachments returns the attachments of a message in the user's conversation, oldest first.
+	// An empty messageId returns the attachments of every message in the conversation
+	ListAttachments(userId, conversationId, messageId string) ([]structs.Attachment, error)
+	// RemoveTag takes the tag off the user's conversation, or returns ErrNotFound if the user doesn't have it
+	RemoveTag(userId, conversationId, tag string) error
+	// PinMessage pins a message in the user's conversation. It returns ErrNotFound if the user doesn't have the
+	// message, or ErrTooManyPins if the conversation already has as many pinned messages as it can.
+	// Pinning a pinned message does nothing. Pins don't change the conversation's version
+	PinMessage(userId, conversationId, messageId string) error
+	// UnpinMessage unpins a message in the user's conversation, or returns ErrNotFound if the user doesn't have it
+	UnpinMessage(userId, conversationId, messageId string) error
+	// ListPinned returns the pinned messages of the user's conversation, oldest first
+	ListPinned(userId, conversationId string) ([]structs.Message, error)
+	// PinConversation pins the user's conversation to the top of their list, ListConversations returns the pinned
+	// ones first. It returns ErrNotFound if the user doesn't have it, or ErrTooManyPinnedConversations if they already
+	// have as many pinned as they can. Pinning a pinned conversation does nothing. Neither changes its updated_at
+	PinConversation(userId, conversationId string) error
+	// UnpinConversation puts the user's conversation back in its place in the list, or returns ErrNotFound if
+	// the user doesn't have it
+	UnpinConversation(userId, conversationId string) error
+	// MarkRead sets messageId as the last message of the conversation the user has read, earlier or later than
+	// the one before. It returns ErrNotFound if the conversation doesn't have the message. It doesn't check the user can
+	// read the conversation, callers must
+	MarkRead(userId, conversationId, messageId string) error
+	// GetUnreadCount returns how many messages of the conversation are after the last one the user read, or all
+	// of them if they haven't read any. It returns ErrNotFound if there's no such conversation
+	GetUnreadCount(userId, conversationId string) (int, error)
+	// GraphContexts returns the graph context of the conversation's messages that have one, by message id.
+	// It doesn't check the user can read the conversation, callers must
+	GraphContexts(conversationId string) (map[uuid.UUID]structs.GraphContext, error)
+	// SaveLLMExchange stores what was sent to the LLM for a reply and what it answered, replacing what was stored for
+	// the reply before, i.e., when it was resumed. The request and response are sealed like messages
+	SaveLLMExchange(exchange structs.LLMExchange) error
+	// GetLLMExchange returns what was sent to the LLM for the reply and what it answered, or ErrNotFound. It's for
+	// admins, it doesn't check who can read the conversation
+	GetLLMExchange(messageId string) (*structs.LLMExchange, error)
+	// PurgeLLMExchanges deletes the exchanges stored more than retention ago and returns how many it deleted
+	PurgeLLMExchanges(retention time.Duration) (int64, error)
+	// SetFeedback sets the user's rating of an answer in the conversation, and their comment, replacing the ones they
+	// gave before. It returns ErrNotFound if the conversation doesn't have the message, or an error wrapping
+	// ErrInvalidFeedback if the rating isn't ThumbsUp or ThumbsDown or the message is the user's. It doesn't check the
+	// user can read the conversation, callers must
+	SetFeedback(userId, conversationId, messageId string, rating structs.Feedback, comment string) (*structs.MessageFeedback, error)
+	// GetFeedback returns the user's feedback on the message, or ErrNotFound if the conversation doesn't have the
+	// message or the user hasn't rated it. It doesn't check the user can read the conversation, callers must
+	GetFeedback(userId, conversationId, messageId string) (*structs.MessageFeedback, error)
+	// AggregateFeedback returns the feedback of every user on each rated answer, in the order the answers were written.
+	// A non-zero since only returns the answers rated or re-rated since then. Conversations in the trash are left out
+	AggregateFeedback(since time.Time) ([]structs.FeedbackSummary, error)
+	// ArchiveConversation moves the user's conversation, with everything that belongs to it, to the
+	// archive database. It returns ErrNotFound if the user doesn't have it, or ErrNoArchive
+	ArchiveConversation(userId, conversationId string) error
+	// UnarchiveConversation moves the user's conversation back from the archive database. It returns
+	// ErrNotFound if the user doesn't have an archived conversation with the id, or ErrNoArchive
+	UnarchiveConversation(userId, conversationId string) error
+	// ArchiveInactive moves the conversations that haven't been updated for olderThan to the archive database, like
+	// ArchiveConversation, and returns how many it moved. Conversations in the trash aren't moved. It returns ErrNoArchive
+	// without an archive database
+	ArchiveInactive(olderThan time.Duration) (int64, error)
+	// ExportAll calls fn with each of the user's conversations, and the ones in the archive database if
+	// includeArchived is set, without reading them all into memory at once. It stops at the first error fn returns
+	ExportAll(userId string, includeArchived bool, fn func(ConversationData) error) error
+	// ExportEveryone calls fn with every user's conversations like ExportAll, ordered by their ids so an export that
+	// was cut off can carry on after the last one it got. It starts after the conversation with the id after, at the
+	// first with uuid.Nil. The ones in the archive database are among them, Archived. The ones in the trash aren't exported
+	ExportEveryone(after uuid.UUID, fn func(ConversationData) error) error
+	// ExportConversation returns the user's conversation with its tags, all of its messages oldest first, and their
+	// attachments, or ErrNotFound. ImportConversation takes it back
+	ExportConversation(userId, conversationId string) (*ConversationData, error)
+	// ImportConversation creates a conversation for the user from data, keeping its id, name, model, system
+	// prompt, metadata and creation time, its last update if it has one, its tags, and its messages and
+	// attachments, checked like BulkAppendMessages' and AddAttachment's. It returns an error wrapping ErrConversationExists if the id is used by any conversation,
+	// including the ones in the trash and the archive. Either all of it is imported or none of it is. An Archived
+	// conversation is then moved to the archive database if there is one, it's left in the primary if it can't be
+	ImportConversation(userId string, data ConversationData) (*structs.Conversation, error)
+	// GetAllMessages returns every message in the store
+	GetAllMessages() ([]structs.Message, error)
+	// DeleteConversation moves the user's conversation to the trash. It's hidden until it's restored or purged
+	DeleteConversation(userId, conversationId string) error
+	// RestoreConversation takes the user's conversation out of the trash if it was deleted less than retention ago
+	RestoreConversation(userId, conversationId string, retention time.Duration) error
+	// PurgeTrash permanently removes conversations (and their messages) deleted more than retention ago
+	PurgeTrash(retention time.Duration) (int64, error)
+	// DeleteEmptyConversations permanently deletes the conversations created more than olderThan ago that have never
+	// had a message, and returns how many it deleted. Pinned and tagged ones, and the ones in the trash, are kept
+	DeleteEmptyConversations(olderThan time.Duration) (int64, error)
+	// WithContext returns the store with its queries bound to ctx: once ctx is done, the query in progress is
+	// interrupted and the methods return ctx's error. It shares the database with the store it came from,
+	// close that one rather than it
+	WithContext(ctx context.Context) ConversationStore
+	// WithoutConversationLimit returns the store without MaxConversations, for admins. It shares the database
+	// with the store it came from, like WithContext
+	WithoutConversationLimit() ConversationStore
+	// Ping checks that the database can be queried
+	Ping() error
+	// Close closes the database. The store can't be used afterwards
+	Close() error
+	// SearchMessages does a case-insensitive full-text search over the content of the user's messages.
+	// Results are ordered by relevance, most relevant first
+	SearchMessages(userId, query string) ([]structs.SearchResult, error)
+	// Search does a case-insensitive search over the names, tags and message content of the user's conversations.
+	// Each match is ranked by how much of the name, tag or message it is, scaled by that kind's weight, and the
+	// results are ordered by rank, most relevant first
+	Search(userId, query string, weights SearchWeights) ([]structs.CombinedSearchResult, error)
+	// SaveEmbedding saves the vector of a message's content, replacing the one it had. It returns ErrNotFound
+	// if there's no message with the embedding's MessageId
+	SaveEmbedding(embedding structs.MessageEmbedding) error
+	// UnembeddedMessages returns up to limit of the messages that don't have an embedding from model of their
+	// content as it is now, oldest first (see EmbedStale)
+	UnembeddedMessages(model string, limit int) ([]structs.Message, error)
+	// RetrieveSimilar returns the k messages of the conversation whose embeddings from model are the most similar
+	// to query, most similar first. Messages without one aren't returned. k of 0 returns all of them
+	RetrieveSimilar(conversationId string, query []float32, model string, k int) ([]structs.SimilarMessage, error)
+}
+
+var ErrNotFound = errors.New("not found")
+
+// ListOptions controls which conversations ListConversations returns
+type ListOptions struct {
+	// Limit is the max number of conversations in the page. 0 means no limit
+	Limit int
+	// Cursor is the opaque token returned with the previous page. Empty starts from the beginning
+	Cursor string
+	// Tags only returns conversations that have all of them
+	Tags []string
+	// Language only returns conversations in it, see structs.Conversation.Language
+	Language string
+	// Metadata only returns conversations whose metadata has each of the keys set to its value
+	Metadata map[string]string
+	// IncludeArchived also returns the conversations in the archive database, with Archived set
+	IncludeArchived bool
+}
+
+// MessageOptions controls which messages GetMessages returns
+type MessageOptions struct {
+	// Limit is the max number of messages in the page. 0 means no limit
+	Limit int
+	// Offset is how many of the matching messages are skipped before the page
+	Offset int
+	// Roles only returns the messages with one of them. Empty returns every role
+	Roles []structs.MessagengerRole
+	// Since only returns the messages added or changed after it. Zero returns all of them
+	Since time.Time
+	// IncludeHidden returns the structs.ContextRole messages too. They're left out otherwise, and the messages
+	// after one have the message before it as their parent, see HideContext
+	IncludeHidden bool
+}
+
+// CloneOptions controls how CloneConversation copies a conversation
+type CloneOptions struct {
+	// Name is the clone's name. Empty names it like the original
+	Name string
+	// ResetTimestamps writes the copies of the messages as of now, in the order they were written, instead of
+	// with the original's create_ts and update_ts
+	ResetTimestamps bool
+}
+
+type sqliteStore struct {
+	db *gorm.DB
+	// mu is shared by the copies WithContext makes
+	mu *sync.RWMutex
+	// maintenance is held while RunMaintenance runs, it's shared like mu
+	maintenance *sync.Mutex
+	// reindex is the rebuild of the search index ReindexSearch started
+	reindex *searchReindex
+	// fts is true when messages are indexed with FTS5
+	fts      bool
+	readOnly bool
+	// sealer encrypts message contents, nil if they're stored in plaintext
+	sealer *sealer
+	// shareKey signs share link tokens
+	shareKey []byte
+	// how long share links still work after they expire, see ClockSkew
+	clockSkew time.Duration
+	// archive holds archived conversations, nil without ArchivePath
+	archive *gorm.DB
+	// maxAttachments is how many attachments a message can have
+	maxAttachments int
+	// maxPins is how many messages of a conversation can be pinned
+	maxPins int
+	// maxPinnedConvos is how many conversations a user can pin
+	maxPinnedConvos int
+	// maxConversations is how many conversations a user can have, 0 for no limit
+	maxConversations int
+	// roles are the roles messages can have
+	roles roleSet
+	// ids makes the ids of new conversations that don't have one
+	ids IDGenerator
+	// notify is called with the changes that were made, nil if no one's listening
+	notify func(Event)
+	// clock is where the timestamps the store writes come from
+	clock clock.Clock
+	// moderator checks the content of new messages before they're written
+	moderator moderation.Moderator
+	// maxMessageChars is the longest content a new message can have, 0 for no limit, and lengthPolicy what
+	// happens to longer content
+	maxMessageChars int
+	lengthPolicy    LengthPolicy
+	// tokenCounter counts the tokens of a message's content
+	tokenCounter func(text string) int
+}
+
+// now is the time on the store's clock, in the local time zone like the timestamps gorm sets
+func (s *sqliteStore) now() time.Time {
+	return s.clock.Now().Local()
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at dbPath and makes sure the schema is up to date
+func NewSQLiteStore(dbPath, logPath string, opts ...Option) (ConversationStore, error) {
+	return openSQLiteStore(dbPath, logPath, opts...)
+}
+
+func openSQLiteStore(dbPath, logPath string, opts ...Option) (*sqliteStore, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if dbPath == MemoryPath && o.readOnly {
+		return nil, errMemoryReadOnly
+	}
+
+	sealer, err := newSealer(o.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	shareKey, err := newShareKey(o.shareKey)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttachments := o.maxAttachments
+	if maxAttachments <= 0 {
+		maxAttachments = defaultMaxAttachments
+	}
+	maxPins := o.maxPins
+	if maxPins <= 0 {
+		maxPins = defaultMaxPins
+	}
+	maxPinnedConvos := o.maxPinnedConvos
+	if maxPinnedConvos <= 0 {
+		maxPinnedConvos = defaultMaxPinnedConversations
+	}
+	ids := o.ids
+	if ids == nil {
+		ids = UUIDv7{}
+	}
+	if o.clock == nil {
+		o.clock = clock.Real
+	}
+	if o.moderator == nil {
+		o.moderator = moderation.PassThrough{}
+	}
+	if o.fileMode == 0 {
+		o.fileMode = defaultFileMode
+	}
+	if o.countTokens == nil {
+		o.countTokens = estimateTokens
+	}
+
+	if !o.readOnly {
+		if err := prepareFile(dbPath, o.fileMode); err != nil {
+			return nil, err
+		}
+	}
+	chatHistDB, err := gorm.Open(sqlite.Open(dsn(dbPath, o)), gormConfig(logPath, o))
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := chatHistDB.DB()
+	if err != nil {
+		return nil, err
+	}
+	o.pool.apply(sqlDB)
+	if dbPath == MemoryPath {
+		// the database is gone once its last connection is closed
+		sqlDB.SetConnMaxLifetime(0)
+	}
+	if !o.readOnly {
+		// SQLite has a single writer, waiting for the connection is cheaper than waiting on the lock
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := registerMetrics(chatHistDB); err != nil {
+		return nil, err
+	}
+	if err := registerTenants(chatHistDB); err != nil {
+		return nil, err
+	}
+
+	var archive *gorm.DB
+	if o.archivePath != "" {
+		if archive, err = openArchive(o.archivePath, logPath, o); err != nil {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+	}
+
+	if o.readOnly {
+		if err := checkSchema(chatHistDB); err != nil {
+			return nil, err
+		}
+		return &sqliteStore{db: chatHistDB, mu: &sync.RWMutex{}, maintenance: &sync.Mutex{}, reindex: &searchReindex{}, fts: hasSearchIndex(chatHistDB), readOnly: true, sealer: sealer, shareKey: shareKey, clockSkew: o.clockSkew, archive: archive, maxAttachments: maxAttachments, maxPins: maxPins, maxPinnedConvos: maxPinnedConvos, maxConversations: o.maxConvos, roles: newRoleSet(o.roles), ids: ids, clock: o.clock, tokenCounter: o.countTokens}, nil
+	}
+	if err := ensureSchema(chatHistDB); err != nil {
+		return nil, err
+	}
+	return &sqliteStore{db: chatHistDB, mu: &sync.RWMutex{}, maintenance: &sync.Mutex{}, reindex: &searchReindex{}, fts: setupSearch(chatHistDB), sealer: sealer, shareKey: shareKey, clockSkew: o.clockSkew, archive: archive, maxAttachments: maxAttachments, maxPins: maxPins, maxPinnedConvos: maxPinnedConvos, maxConversations: o.maxConvos, roles: newRoleSet(o.roles), ids: ids, notify: o.notify, clock: o.clock, moderator: o.moderator, maxMessageChars: o.maxMessageChars, lengthPolicy: o.lengthPolicy, tokenCounter: o.countTokens}, nil
+}
+
+// gormConfig logs to logPath, and sets created_at, updated_at and deleted_at with o's clock
+func gormConfig(logPath string, o options) *gorm.Config {
+	return &gorm.Config{Logger: createLogger(logPath), NowFunc: func() time.Time { return o.clock.Now().Local() }}
+}
+
+// defaultBusyTimeout is how long a connection waits on a lock unless BusyTimeout is set
+const defaultBusyTimeout = 5 * time.Second
+
+// dsn opens dbPath with WAL journaling, so readers don't block the writer, and o's busy timeout.
+// Read-only stores can't change the journal mode, they use the one the file already has.
+// MemoryPath opens a new in-memory database
+func dsn(dbPath string, o options) string {
+	timeout := o.busyTimeout
+	if timeout <= 0 {
+		timeout = defaultBusyTimeout
+	}
+	params := url.Values{"_busy_timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}}
+	if dbPath == MemoryPath {
+		return memoryDSN(params)
+	}
+	if o.readOnly {
+		params.Set("mode", "ro")
+	} else {
+		params.Set("_journal_mode", "WAL")
+	}
+	return "file:" + dbPath + "?" + params.Encode()
+}
+
+// ensureSchema brings the tables up to date by applying any pending migrations.
+// If one fails, none are kept and the store doesn't open
+func ensureSchema(db *gorm.DB) error {
+	return migrations.Apply(db, migrations.All)
+}
+
+// checkSchema fails if the schema is behind, for read-only stores that can't migrate it
+func checkSchema(db *gorm.DB) error {
+	pending, err := migrations.Pending(db, migrations.All)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the schema is missing %d migrations, open the database read-write once to apply them", len(pending))
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateConversation(userId, name string, message structs.Message) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := s.validateMessage(message); err != nil {
+		return nil, err
+	}
+	content, err := s.checkContent(message.ConversationId.String(), message.MessageId.String(), message.Content)
+	if err != nil {
+		return nil, err
+	}
+	message.Content = content
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message.Pinned = false
+	message.Incomplete = false
+	if message.ConversationId == uuid.Nil {
+		id, err := s.ids.NewID()
+		if err != nil {
+			return nil, err
+		}
+		message.ConversationId = id
+	}
+	detectLanguage(&message)
+	s.countTokens(&message)
+	plain := message
+	if err := s.sealer.sealMessage(&message); err != nil {
+		return nil, err
+	}
+	if err := s.checkConversationLimit(s.db, userId); err != nil {
+		return nil, err
+	}
+	convo := structs.Conversation{UserId: userId, ConversationId: message.ConversationId, Name: name, Language: message.Language, TokenCount: int64(message.TokenCount)}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		return appendEvent(tx, createdEvent(convo, message))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.emit(EventConversationCreated, convo, plain, message)
+	return &convo, nil
+}
+
+// validateMessage checks a new message has a role clients can send, some content and valid tool fields.
+// The error wraps ErrInvalidMessages
+func (s *sqliteStore) validateMessage(m structs.Message) error {
+	if !s.roles.valid(m.Role) {
+		return fmt.Errorf("%w: role must be %s, not %q", ErrInvalidMessages, s.roles, m.Role)
+	}
+	if strings.TrimSpace(m.Content) == "" {
+		return fmt.Errorf("%w: content is required", ErrInvalidMessages)
+	}
+	if err := validateTool(m); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessages, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FindConversation(conversationId string) (*structs.Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	convo := structs.Conversation{}
+	tx := s.db.Where("conversation_id = ?", conversationId).First(&convo)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &convo, nil
+}
+
+func (s *sqliteStore) GetConversation(userId, conversationId string) ([]structs.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []structs.Message{}
+
+	// ensure that conversatonId is a convo for this user
+	var count int64
+	tx := s.db.Model(&structs.Conversation{}).Where("user_id = ? AND conversation_id = ?", userId, conversationId).Count(&count)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return messages, nil
+	}
+
+	// conversaton belongs to this user. get the messages
+	if err := s.db.Where("conversation_id = ?", conversationId).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (s *sqliteStore) GetMessages(userId, conversationId string, opts MessageOptions) ([]structs.Message, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []structs.Message{}
+	var count int64
+	if err := s.db.Model(&structs.Conversation{}).Where("user_id = ? AND conversation_id = ?", userId, conversationId).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if count == 0 {
+		return messages, 0, nil
+	}
+
+	tx := s.db.Model(&structs.Message{}).Where("conversation_id = ?", conversationId)
+	if len(opts.Roles) > 0 {
+		tx = tx.Where("role IN ?", opts.Roles)
+	}
+	if !opts.Since.IsZero() {
+		tx = tx.Where("updated_at > ?", opts.Since)
+	}
+	if !opts.IncludeHidden {
+		tx = tx.Where("role != ?", structs.ContextRole)
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	tx = tx.Order("id").Offset(opts.Offset)
+	if opts.Limit > 0 {
+		tx = tx.Limit(opts.Limit)
+	}
+	if err := tx.Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, 0, err
+	}
+	if !opts.IncludeHidden && len(messages) > 0 {
+		var hidden []structs.Message
+		if err := s.db.Select("message_id", "parent_id").Where("conversation_id = ? AND role = ?", conversationId, structs.ContextRole).Find(&hidden).Error; err != nil {
+			return nil, 0, err
+		}
+		messages = hideContext(messages, hidden)
+	}
+	return messages, total, nil
+}
+
+func (s *sqliteStore) ListConversations(userId string, opts ListOptions) ([]structs.Conversation, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var c *cursor
+	if opts.Cursor != "" {
+		decoded, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		c = &decoded
+	}
+	convos, err := listConversations(s.db, userId, c, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.IncludeArchived && s.archive != nil {
+		archived, err := listConversations(s.archive, userId, c, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		convos = mergeArchived(convos, archived)
+	}
+
+	next := ""
+	if opts.Limit > 0 && len(convos) > opts.Limit {
+		convos = convos[:opts.Limit]
+		last := convos[len(convos)-1]
+		next = encodeCursor(cursor{UpdatedAt: last.UpdatedAt, PinnedAt: last.PinnedAt, ID: last.ID})
+	}
+	return convos, next, nil
+}
+
+// listConversations returns the user's conversations in db after c, with their tags, message and unread counts.
+// The pinned ones come first, the latest pinned first, then the others by updated_at (see compareListed).
+// It fetches one more than the limit to know if there is another page. It's three queries however
+// many conversations there are
+func listConversations(db *gorm.DB, userId string, c *cursor, opts ListOptions) ([]structs.Conversation, error) {
+	tx := db.Where("user_id = ?", userId).
+		Order("pinned_at IS NULL").Order("pinned_at DESC").Order("CASE WHEN pinned_at IS NULL THEN updated_at END DESC").Order("id DESC")
+	if c != nil && c.PinnedAt != nil {
+		tx = tx.Where("pinned_at < ? OR (pinned_at = ? AND id < ?) OR pinned_at IS NULL", *c.PinnedAt, *c.PinnedAt, c.ID)
+	} else if c != nil {
+		tx = tx.Where("pinned_at IS NULL AND (updated_at < ? OR (updated_at = ? AND id < ?))", c.UpdatedAt, c.UpdatedAt, c.ID)
+	}
+	tx, err := withTags(tx, opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Language != "" {
+		tx = tx.Where("language = ?", opts.Language)
+	}
+	tx, err = withMetadata(tx, opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit > 0 {
+		tx = tx.Limit(opts.Limit + 1)
+	}
+
+	convos := []structs.Conversation{}
+	if err := tx.Find(&convos).Error; err != nil {
+		return nil, err
+	}
+	if err := loadTags(db, convos); err != nil {
+		return nil, err
+	}
+	if err := loadMessageCounts(db, userId, convos); err != nil {
+		return nil, err
+	}
+	return convos, nil
+}
+
+// loadMessageCounts sets the MessageCount of each of the conversations, and their UnreadCount for userId, in one query
+func loadMessageCounts(db *gorm.DB, userId string, convos []structs.Conversation) error {
+	if len(convos) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(convos))
+	for i, c := range convos {
+		ids[i] = c.ConversationId
+	}
+	var counts []struct {
+		ConversationId uuid.UUID
+		Count          int
+		Unread         int
+	}
+	err := db.Model(&structs.Message{}).
+		Select("messages.conversation_id, COUNT(*) AS count, "+unreadMessages+" AS unread").
+		Joins("LEFT JOIN read_markers ON read_markers.conversation_id = messages.conversation_id AND read_markers.user_id = ?", userId).
+		Where("messages.conversation_id IN ?", ids).Group("messages.conversation_id").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	byConvo := make(map[uuid.UUID]int, len(counts))
+	unread := make(map[uuid.UUID]int, len(counts))
+	for _, c := range counts {
+		byConvo[c.ConversationId] = c.Count
+		unread[c.ConversationId] = c.Unread
+	}
+	for i := range convos {
+		convos[i].MessageCount = byConvo[convos[i].ConversationId]
+		convos[i].UnreadCount = unread[convos[i].ConversationId]
+	}
+	return nil
+}
+
+// mergeArchived merges the archived conversations into the primary ones, keeping them ordered the
+// same way. Moved conversations keep their ids, so ids are unique across both databases and the
+// cursor works over the merged list. A conversation in both, left by a move that didn't finish,
+// is only returned from the primary
+func mergeArchived(primary, archived []structs.Conversation) []structs.Conversation {
+	seen := make(map[uuid.UUID]bool, len(primary))
+	for _, c := range primary {
+		seen[c.ConversationId] = true
+	}
+	for _, c := range archived {
+		if !seen[c.ConversationId] {
+			c.Archived = true
+			primary = append(primary, c)
+		}
+	}
+	slices.SortFunc(primary, compareListed)
+	return primary
+}
+
+// compareListed orders conversations the way listConversations does: the pinned ones first, the latest
+// pinned first, then the others with the latest updated first. Ties go to the latest created
+func compareListed(a, b structs.Conversation) int {
+	switch {
+	case a.PinnedAt != nil && b.PinnedAt == nil:
+		return -1
+	case a.PinnedAt == nil && b.PinnedAt != nil:
+		return 1
+	case a.PinnedAt != nil:
+		if n := b.PinnedAt.Compare(*a.PinnedAt); n != 0 {
+			return n
+		}
+	default:
+		if n := b.UpdatedAt.Compare(a.UpdatedAt); n != 0 {
+			return n
+		}
+	}
+	return cmp.Compare(b.ID, a.ID)
+}
+
+func (s *sqliteStore) AppendMessage(message structs.Message, expectedVersion int) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	// only checked if it's a new message, feedback updates don't need the role and content
+	invalid := s.validateMessage(message)
+	if invalid == nil {
+		var content string
+		if content, invalid = s.checkContent(message.ConversationId.String(), message.MessageId.String(), message.Content); invalid == nil {
+			message.Content = content
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message.Pinned = false
+	message.Incomplete = false
+	detectLanguage(&message)
+	s.countTokens(&message)
+	plain := message
+	if err := s.sealer.sealMessage(&message); err != nil {
+		return nil, err
+	}
+
+	appended := false
+	write := func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, message.ConversationId, expectedVersion); err != nil {
+			return err
+		}
+
+		// Find the existing message by conversation ID and message ID
+		var existingMessage structs.Message
+		res := tx.Where("conversation_id = ? AND message_id = ? ", message.ConversationId, message.MessageId).First(&existingMessage)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			if invalid != nil {
+				return invalid
+			}
+			appended = true
+			if err := tx.Create(&message).Error; err != nil {
+				return err
+			}
+			if _, err := updateLanguage(tx, message.ConversationId); err != nil {
+				return err
+			}
+			// the total is kept as messages are added, instead of adding up all of them again
+			err := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", message.ConversationId).
+				UpdateColumn("token_count", gorm.Expr("token_count + ?", message.TokenCount)).Error
+			if err != nil {
+				return err
+			}
+			if message.GraphContext == nil {
+				return nil
+			}
+			return tx.Create(&structs.MessageContext{MessageId: message.MessageId, ConversationId: message.ConversationId, Context: *message.GraphContext}).Error
+		} else if res.Error != nil {
+			return res.Error
+		}
+		// Update only the feedback and comments fields if the message exists
+		return tx.Model(&existingMessage).Select("Feedback", "Comment").Updates(
+			structs.Message{
+				Feedback: message.Feedback,
+				Comment:  message.Comment,
+			}).Error
+	}
+
+	// the message, the conversation's version and updated_at, and what's derived from the message are written
+	// together or not at all. The conversation is read back in the same transaction, so it's what was committed
+	convo := structs.Conversation{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", message.ConversationId).Find(&convo).Error; err != nil {
+			return err
+		}
+		if !appended {
+			return nil
+		}
+		return appendEvent(tx, appendedEvent(convo.UserId, message))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if appended {
+		s.emit(EventMessageAppended, convo, plain, message)
+	}
+	return &convo, nil
+}
+
+func (s *sqliteStore) RenameConversation(conversationId, name string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// UpdateColumn so renaming doesn't change updated_at and reorder the list
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		convo := structs.Conversation{}
+		if err := tx.Where("conversation_id = ?", conversationId).First(&convo).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if err := tx.Model(&convo).UpdateColumn("name", name).Error; err != nil {
+			return err
+		}
+		return appendEvent(tx, structs.ConversationEvent{Type: EventConversationRenamed, ConversationId: convo.ConversationId, Detail: name})
+	})
+}
+
+func (s *sqliteStore) SetConversationModel(userId, conversationId, model string) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// UpdateColumn like RenameConversation, it's a setting rather than activity
+	tx := s.db.Model(&structs.Conversation{}).Where("user_id = ? AND conversation_id = ?", userId, conversationId).UpdateColumn("model_name", model)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	convo := structs.Conversation{}
+	if err := s.db.Where("conversation_id = ?", conversationId).First(&convo).Error; err != nil {
+		return nil, err
+	}
+	return &convo, nil
+}
+
+func (s *sqliteStore) GetSystemPrompt(userId, conversationId string) (string, error) {
+	convo := structs.Conversation{}
+	err := s.db.Select("system_prompt").Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return convo.SystemPrompt, nil
+}
+
+func (s *sqliteStore) SetSystemPrompt(userId, conversationId, prompt string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// UpdateColumn like SetConversationModel
+	tx := s.db.Model(&structs.Conversation{}).Where("user_id = ? AND conversation_id = ?", userId, conversationId).UpdateColumn("system_prompt", prompt)
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) WithContext(ctx context.Context) ConversationStore {
+	c := *s
+	c.db = s.db.WithContext(ctx)
+	if s.archive != nil {
+		c.archive = s.archive.WithContext(ctx)
+	}
+	return &c
+}
+
+func (s *sqliteStore) Ping() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return err
+	}
+	return s.db.Exec("SELECT 1").Error
+}
+
+func (s *sqliteStore) Close() error {
+	s.reindex.stop()
+	// wait for any write in progress
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.archive != nil {
+		if sqlDB, err := s.archive.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (s *sqliteStore) GetAllMessages() ([]structs.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []structs.Message
+	if err := s.db.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := s.sealer.openMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
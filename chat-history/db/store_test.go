@@ -0,0 +1,993 @@
+package db
+
+import (
+	"bytes"
+	"chat-history/db/migrations"
+	"chat-history/structs"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNewSQLiteStore(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+
+	s, err := NewSQLiteStore(pth, logPth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := uuid.New()
+	msg := structs.Message{
+		ConversationId: convoId,
+		MessageId:      uuid.New(),
+		Content:        "Hello, world",
+		Role:           structs.UserRole,
+	}
+	if _, err := s.CreateConversation(USER, "convo", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening an existing file must not recreate the schema or lose data
+	s, err = NewSQLiteStore(pth, logPth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convos, _, err := s.ListConversations(USER, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(convos); l != 1 {
+		t.Fatalf("len of convos should be 1. It's: %d", l)
+	}
+}
+
+// legacyConversation is a conversation as it was stored before versioned migrations
+type legacyConversation struct {
+	structs.Model
+	UserId         string    `gorm:"not null"`
+	ConversationId uuid.UUID `gorm:"unique;not null"`
+	Name           string
+}
+
+func (legacyConversation) TableName() string { return "conversations" }
+
+// legacyMessage is a message as it was stored before messages could be pinned
+type legacyMessage struct {
+	structs.Model
+	ConversationId uuid.UUID `gorm:"not null"`
+	MessageId      uuid.UUID `gorm:"unique;not null"`
+	ParentId       *uuid.UUID
+	ModelName      string
+	Content        string
+	Role           structs.MessagengerRole
+	ResponseTime   float64
+	Feedback       structs.Feedback
+	Comment        string
+}
+
+func (legacyMessage) TableName() string { return "messages" }
+
+func TestNewSQLiteStore_LegacyFile(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+
+	// a file created before versioned migrations has the tables but no schema_migrations
+	legacy, err := gorm.Open(sqlite.Open(pth), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.AutoMigrate(&legacyConversation{}, &legacyMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	convoId := uuid.New()
+	if err := legacy.Create(&legacyConversation{UserId: USER, ConversationId: convoId, Name: "legacy"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := legacy.DB()
+	sqlDB.Close()
+
+	s, err := openSQLiteStore(pth, fmt.Sprintf("%s/test.log", tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if c, err := s.FindConversation(convoId.String()); err != nil || c.Name != "legacy" {
+		t.Fatalf("existing conversations should be kept. Got %v, %v", c, err)
+	}
+	applied, err := migrations.Applied(s.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations.All) {
+		t.Fatalf("every migration should be recorded. Applied: %v", applied)
+	}
+}
+
+// the migrations have to create every column the models use
+func TestMigrations_MatchModels(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	m := s.db.Migrator()
+	for _, model := range []any{&structs.Conversation{}, &structs.Message{}, &structs.ConversationTag{}, &structs.MessageRevision{}, &structs.MessageFeedback{}, &structs.ConversationEvent{}, &structs.LLMExchange{}, &idempotencyKey{}} {
+		stmt := &gorm.Statement{DB: s.db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !m.HasColumn(model, field.DBName) {
+				t.Fatalf("%s.%s is in the model but no migration creates it", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s := newTestStore(t)
+
+	convoId := uuid.New()
+	first := structs.Message{
+		ConversationId: convoId,
+		MessageId:      uuid.New(),
+		Content:        "first",
+		Role:           structs.UserRole,
+	}
+	convo, err := s.CreateConversation(USER, "convo", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.UserId != USER || convo.ConversationId != convoId {
+		t.Fatalf("conversation invalid: %v", convo)
+	}
+
+	second := structs.Message{
+		ConversationId: convoId,
+		MessageId:      uuid.New(),
+		ParentId:       &first.MessageId,
+		Content:        "second",
+		Role:           structs.SystemRole,
+	}
+	if _, err := s.AppendMessage(second, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(messages); l != 2 {
+		t.Fatalf("len of messages should be 2. It's: %d", l)
+	}
+
+	// another user can't read the conversation
+	messages, err = s.GetConversation("Miss_Take", convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(messages); l != 0 {
+		t.Fatalf("Messages should be empty. Found %d messages", l)
+	}
+}
+
+func TestListConversations_Pagination(t *testing.T) {
+	s := newTestStore(t)
+
+	// 5 conversations, the last one created is the most recently updated
+	ids := []uuid.UUID{}
+	for i := range 5 {
+		convoId := uuid.New()
+		ids = append([]uuid.UUID{convoId}, ids...)
+		msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: fmt.Sprintf("message %d", i), Role: structs.UserRole}
+		if _, err := s.CreateConversation(USER, fmt.Sprintf("convo %d", i), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		limit int
+		pages []int // expected page sizes
+	}{
+		{limit: 2, pages: []int{2, 2, 1}},
+		{limit: 5, pages: []int{5}}, // exactly one full page
+		{limit: 6, pages: []int{5}},
+		{limit: 1, pages: []int{1, 1, 1, 1, 1}},
+		{limit: 0, pages: []int{5}}, // no limit
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			var seen []uuid.UUID
+			cursor := ""
+			for i, size := range tt.pages {
+				page, next, err := s.ListConversations(USER, ListOptions{Limit: tt.limit, Cursor: cursor})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) != size {
+					t.Fatalf("page %d should have %d conversations. It has: %d", i, size, len(page))
+				}
+				last := i == len(tt.pages)-1
+				if last && next != "" {
+					t.Fatalf("cursor should be empty on the final page. It's: %s", next)
+				} else if !last && next == "" {
+					t.Fatalf("cursor should not be empty on page %d", i)
+				}
+				for _, c := range page {
+					seen = append(seen, c.ConversationId)
+				}
+				cursor = next
+			}
+
+			// every conversation exactly once, most recent first
+			if len(seen) != len(ids) {
+				t.Fatalf("should have seen %d conversations. Saw: %d", len(ids), len(seen))
+			}
+			for i := range ids {
+				if seen[i] != ids[i] {
+					t.Fatalf("conversations are out of order at %d: %v != %v", i, seen[i], ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestListConversations_StableUnderInserts(t *testing.T) {
+	s := newTestStore(t)
+	for i := range 4 {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "hi", Role: structs.UserRole}
+		if _, err := s.CreateConversation(USER, fmt.Sprintf("convo %d", i), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, next, err := s.ListConversations(USER, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new conversation sorts before the cursor, so it must not shift the following page
+	time.Sleep(time.Millisecond)
+	msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "new", Role: structs.UserRole}
+	if _, err := s.CreateConversation(USER, "new", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	second, next, err := s.ListConversations(USER, ListOptions{Limit: 2, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 2 || next != "" {
+		t.Fatalf("second page should have the 2 remaining conversations and no cursor. It has %d, cursor %q", len(second), next)
+	}
+	for _, a := range first {
+		for _, b := range second {
+			if a.ID == b.ID {
+				t.Fatalf("conversation %d was returned on both pages", a.ID)
+			}
+		}
+	}
+}
+
+func TestListConversations_InvalidCursor(t *testing.T) {
+	s := newTestStore(t)
+	if _, _, err := s.ListConversations(USER, ListOptions{Limit: 2, Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got: %v", err)
+	}
+}
+
+func TestListConversations_MessageCounts(t *testing.T) {
+	s := newArchiveStore(t).(*sqliteStore)
+	want := map[uuid.UUID]int{}
+	for i, id := range seedConversations(t, s, USER, 30, 1) {
+		// 1 to 4 messages
+		seedReplies(t, s, id, i%4)
+		want[id] = i%4 + 1
+	}
+	seedConversations(t, s, "Miss_Take", 5, 3)
+	archived := seedConversation(t, s, USER)
+	seedReplies(t, s, archived, 2)
+	want[archived] = 3
+	if err := s.ArchiveConversation(USER, archived.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []ListOptions{{Limit: 7, IncludeArchived: true}, {Tags: []string{"even"}}, {}} {
+		seen := 0
+		for {
+			page, next, err := s.ListConversations(USER, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range page {
+				if c.MessageCount != want[c.ConversationId] {
+					t.Fatalf("conversation %s should have %d messages. It has %d", c.ConversationId, want[c.ConversationId], c.MessageCount)
+				}
+				seen++
+			}
+			if next == "" {
+				break
+			}
+			opts.Cursor = next
+		}
+		if seen == 0 {
+			t.Fatalf("%+v should list conversations", opts)
+		}
+	}
+
+	// the counts are one query whatever the page size
+	queries := countQueries(t, s.db)
+	if _, _, err := s.ListConversations(USER, ListOptions{Limit: 5}); err != nil {
+		t.Fatal(err)
+	}
+	small := queries.Swap(0)
+	if _, _, err := s.ListConversations(USER, ListOptions{Limit: 25}); err != nil {
+		t.Fatal(err)
+	}
+	if large := queries.Load(); large != small {
+		t.Fatalf("listing more conversations shouldn't take more queries. It took %d for 5 and %d for 25", small, large)
+	}
+}
+
+func TestRenameConversation(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	before, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RenameConversation(convoId.String(), "Trip to Japan"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Name != "Trip to Japan" {
+		t.Fatalf("name should be `Trip to Japan`. It's: %s", after.Name)
+	}
+	if !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Fatalf("renaming should not change updated_at. It went from %v to %v", before.UpdatedAt, after.UpdatedAt)
+	}
+
+	if err := s.RenameConversation(uuid.NewString(), "nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestGetMessages(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	replies := seedReplies(t, s, convoId, 5)
+	all, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(messages []structs.Message) []string {
+		out := make([]string, len(messages))
+		for i, m := range messages {
+			out[i] = m.MessageId.String()
+		}
+		return out
+	}
+
+	// paging forward goes through every message once, oldest first
+	var paged []string
+	for offset := 0; ; offset += 2 {
+		page, total, err := s.GetMessages(USER, convoId.String(), MessageOptions{Limit: 2, Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 6 {
+			t.Fatalf("the total should be 6, got: %d", total)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, ids(page)...)
+	}
+	if want := ids(all); !slices.Equal(paged, want) {
+		t.Fatalf("expected %v, got: %v", want, paged)
+	}
+	if page, _, err := s.GetMessages(USER, convoId.String(), MessageOptions{Offset: 4}); err != nil || len(page) != 2 {
+		t.Fatalf("without a limit the rest should be returned, got %d messages, %v", len(page), err)
+	}
+
+	// the replies are the assistant's, the first message the user's
+	page, total, err := s.GetMessages(USER, convoId.String(), MessageOptions{Limit: 2, Offset: 1, Roles: []structs.MessagengerRole{structs.AssistantRole}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 || !slices.Equal(ids(page), replies[1:3]) {
+		t.Fatalf("expected replies %v of 5, got %v of %d", replies[1:3], ids(page), total)
+	}
+	page, total, err = s.GetMessages(USER, convoId.String(), MessageOptions{Roles: []structs.MessagengerRole{structs.UserRole, structs.SystemRole}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(page) != 1 || page[0].Role != structs.UserRole {
+		t.Fatalf("only the user's message should be returned, got %+v of %d", page, total)
+	}
+
+	// since leaves out what didn't change after it
+	page, total, err = s.GetMessages(USER, convoId.String(), MessageOptions{Since: all[3].UpdatedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || !slices.Equal(ids(page), replies[3:]) {
+		t.Fatalf("expected %v, got %v of %d", replies[3:], ids(page), total)
+	}
+
+	if page, total, err := s.GetMessages("Miss_Take", convoId.String(), MessageOptions{}); err != nil || len(page) != 0 || total != 0 {
+		t.Fatalf("another user's conversation should be empty, got %v of %d, %v", page, total, err)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := s.WithContext(ctx)
+	if messages, err := bound.GetConversation(USER, convoId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("the bound store should work until ctx is done. Got %d, %v", len(messages), err)
+	}
+	cancel()
+	if _, err := bound.GetConversation(USER, convoId.String()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "too late", Role: structs.AssistantRole}
+	if _, err := bound.AppendMessage(reply, AnyVersion); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	// the store it came from isn't affected, and nothing was written
+	if messages, err := s.GetConversation(USER, convoId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("the conversation should still have its one message. Got %d, %v", len(messages), err)
+	}
+
+	// a query in progress is interrupted at the deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	slow := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n"
+	var count int64
+	err := s.WithContext(ctx).(*sqliteStore).db.Raw(slow).Scan(&count).Error
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Fatalf("the query should stop at the deadline. It returned %v after %s", err, time.Since(start))
+	}
+}
+
+func TestSetConversationModel(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+
+	convo, err := s.SetConversationModel(USER, convoId.String(), "gpt-4o-mini")
+	if err != nil || convo.ModelName != "gpt-4o-mini" {
+		t.Fatalf("the conversation should be returned with its model. Got %+v, %v", convo, err)
+	}
+	if found, err := s.FindConversation(convoId.String()); err != nil || found.ModelName != "gpt-4o-mini" || found.Version != 0 {
+		t.Fatalf("the model should be stored without changing the version. Got %+v, %v", found, err)
+	}
+	if convo, err := s.SetConversationModel(USER, convoId.String(), ""); err != nil || convo.ModelName != "" {
+		t.Fatalf("the model should be cleared. Got %+v, %v", convo, err)
+	}
+
+	if _, err := s.SetConversationModel("Miss_Take", convoId.String(), "gpt-4o"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestSystemPrompt(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	before, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt, err := s.GetSystemPrompt(USER, convoId.String()); err != nil || prompt != "" {
+		t.Fatalf("a new conversation shouldn't have a system prompt. Got %q, %v", prompt, err)
+	}
+
+	if err := s.SetSystemPrompt(USER, convoId.String(), "Answer in French"); err != nil {
+		t.Fatal(err)
+	}
+	if prompt, err := s.GetSystemPrompt(USER, convoId.String()); err != nil || prompt != "Answer in French" {
+		t.Fatalf("the system prompt should be stored. Got %q, %v", prompt, err)
+	}
+	// setting it again replaces it, without counting as activity
+	if err := s.SetSystemPrompt(USER, convoId.String(), "Answer in German"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := s.FindConversation(convoId.String())
+	if err != nil || found.SystemPrompt != "Answer in German" || found.Version != before.Version || !found.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Fatalf("the system prompt should be replaced without changing the version or updated_at. Got %+v, %v", found, err)
+	}
+	if err := s.SetSystemPrompt(USER, convoId.String(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if prompt, err := s.GetSystemPrompt(USER, convoId.String()); err != nil || prompt != "" {
+		t.Fatalf("the system prompt should be cleared. Got %q, %v", prompt, err)
+	}
+
+	if err := s.SetSystemPrompt("Miss_Take", convoId.String(), "Be rude"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	if _, err := s.GetSystemPrompt("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestCreateConversation_InvalidMessage(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	for name, msg := range map[string]structs.Message{
+		"unknown role": {Content: "Hi", Role: "bot"},
+		"no role":      {Content: "Hi"},
+		"no content":   {Content: " \n", Role: structs.UserRole},
+	} {
+		msg.MessageId = uuid.New()
+		if _, err := s.CreateConversation(USER, "new", msg); !errors.Is(err, ErrInvalidMessages) {
+			t.Fatalf("%s: CreateConversation should return ErrInvalidMessages. It returned: %v", name, err)
+		}
+		msg.ConversationId = convoId
+		if _, err := s.AppendMessage(msg, AnyVersion); !errors.Is(err, ErrInvalidMessages) {
+			t.Fatalf("%s: AppendMessage should return ErrInvalidMessages. It returned: %v", name, err)
+		}
+	}
+	if convos, _, err := s.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 1 {
+		t.Fatalf("no conversations should be created. Got %+v, %v", convos, err)
+	}
+	if messages, err := s.GetConversation(USER, convoId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("no messages should be added. Got %+v, %v", messages, err)
+	}
+}
+
+// newTestStore opens an empty store in a temp dir, or in memory when CHAT_HISTORY_TEST_MEMORY is set
+// to run the tests against NewMemoryStore
+func newTestStore(t testing.TB) ConversationStore {
+	tmp := t.TempDir()
+	if os.Getenv("CHAT_HISTORY_TEST_MEMORY") != "" {
+		s, err := NewMemoryStore(fmt.Sprintf("%s/test.log", tmp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSearchMessages(t *testing.T) {
+	s := newTestStore(t)
+
+	mine, other := uuid.New(), uuid.New()
+	seed := []struct {
+		user, content string
+		convo         uuid.UUID
+	}{
+		{USER, "How many Transactions were flagged as fraud?", mine},
+		{USER, "transactions, transactions and more transactions", mine},
+		{USER, "Nothing to see here", mine},
+		{"Miss_Take", "my transactions are private", other},
+	}
+	for _, m := range seed {
+		msg := structs.Message{ConversationId: m.convo, MessageId: uuid.New(), Content: m.content, Role: structs.UserRole}
+		if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for user, convo := range map[string]uuid.UUID{USER: mine, "Miss_Take": other} {
+		if err := s.(*sqliteStore).db.Create(&structs.Conversation{UserId: user, ConversationId: convo}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.SearchMessages(USER, "TRANSACTIONS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(results); l != 2 {
+		t.Fatalf("should find 2 messages. Found: %d", l)
+	}
+	for _, r := range results {
+		if r.ConversationId != mine {
+			t.Fatalf("found a message from another user's conversation: %v", r)
+		}
+		if !strings.Contains(strings.ToLower(r.Snippet), "transactions") {
+			t.Fatalf("snippet should contain the match: %q", r.Snippet)
+		}
+	}
+	// the message mentioning it most is the most relevant
+	if !strings.HasPrefix(results[0].Snippet, "transactions, transactions") || results[0].Rank < results[1].Rank {
+		t.Fatalf("results are not ranked by relevance: %v", results)
+	}
+
+	results, err = s.SearchMessages(USER, "not in any message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(results); l != 0 {
+		t.Fatalf("should find 0 messages. Found: %d", l)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestStore(t)
+
+	question := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "How do I find rings?", Role: structs.UserRole}
+	titled, err := s.CreateConversation(USER, "Fraud rings", question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a long reply that mentions it once, well past the start
+	deep := structs.Message{
+		ConversationId: uuid.New(),
+		MessageId:      uuid.New(),
+		Content:        strings.Repeat("Accounts share devices and addresses. ", 20) + "Those are fraud rings.",
+		Role:           structs.UserRole,
+	}
+	body, err := s.CreateConversation(USER, "Shared devices", deep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := s.CreateConversation(USER, "Weekly review", structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "hi", Role: structs.UserRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag(USER, tagged.ConversationId.String(), "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateConversation("Miss_Take", "My fraud rings", structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "fraud", Role: structs.UserRole}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := s.Search(USER, "FRAUD", DefaultSearchWeights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("should find the title, the tag and the message. Found: %v", results)
+	}
+	want := []struct {
+		kind  string
+		convo uuid.UUID
+	}{
+		{structs.ResultTag, tagged.ConversationId},
+		{structs.ResultTitle, titled.ConversationId},
+		{structs.ResultMessage, body.ConversationId},
+	}
+	for i, w := range want {
+		if results[i].Type != w.kind || results[i].ConversationId != w.convo {
+			t.Fatalf("result %d should be the %s match in %s. Got %+v", i, w.kind, w.convo, results[i])
+		}
+	}
+	if results[0].Name != "Weekly review" || results[2].Name != "Shared devices" {
+		t.Fatalf("results should have their conversation's name. Got %+v", results)
+	}
+	if results[2].MessageId == nil || *results[2].MessageId != deep.MessageId || !strings.Contains(results[2].Snippet, "fraud rings") {
+		t.Fatalf("the message result should have its id and snippet. Got %+v", results[2])
+	}
+	if results[1].MessageId != nil {
+		t.Fatalf("a title result shouldn't have a message id. Got %+v", results[1])
+	}
+
+	// the weights change the order
+	results, err = s.Search(USER, "fraud", SearchWeights{Title: 1, Tags: 0, Content: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Type != structs.ResultMessage {
+		t.Fatalf("a heavily weighted message should rank first, and a 0 weight drop the tag. Got %v", results)
+	}
+
+	if results, err := s.Search(USER, "not anywhere", DefaultSearchWeights); err != nil || len(results) != 0 {
+		t.Fatalf("should find nothing. Got %v, %v", results, err)
+	}
+}
+
+func TestSnippetOf(t *testing.T) {
+	content := strings.Repeat("a", 50) + "Needle" + strings.Repeat("b", 50)
+	snippet, count := snippetOf(content, "needle")
+	want := "..." + strings.Repeat("a", snippetRadius) + "Needle" + strings.Repeat("b", snippetRadius) + "..."
+	if snippet != want || count != 1 {
+		t.Fatalf("snippet is wrong: %q, %d", snippet, count)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+
+	rw, err := NewSQLiteStore(pth, logPth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := seedConversation(t, rw, USER)
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSQLiteStore(pth, logPth, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads work
+	if convos, _, err := s.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 1 {
+		t.Fatalf("ListConversations should return the conversation. Got %v, %v", convos, err)
+	}
+	if messages, err := s.GetConversation(USER, convoId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("GetConversation should return the message. Got %v, %v", messages, err)
+	}
+	if results, err := s.SearchMessages(USER, "hello"); err != nil || len(results) != 1 {
+		t.Fatalf("SearchMessages should find the message. Got %v, %v", results, err)
+	}
+
+	// writes are rejected
+	msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "again", Role: structs.UserRole}
+	writes := map[string]error{}
+	_, writes["CreateConversation"] = s.CreateConversation(USER, "new", structs.Message{ConversationId: uuid.New(), MessageId: uuid.New()})
+	_, _, writes["CreateConversationOnce"] = s.CreateConversationOnce(USER, "key", "new", msg, time.Hour)
+	_, _, writes["CreateConversationWithExternalId"] = s.CreateConversationWithExternalId(USER, "crm-1", "new", msg)
+	_, writes["AppendMessage"] = s.AppendMessage(msg, AnyVersion)
+	_, writes["SaveStreamedMessage"] = s.SaveStreamedMessage(msg)
+	_, writes["BulkAppendMessages"] = s.BulkAppendMessages(USER, convoId.String(), "", []structs.Message{msg})
+	_, writes["ImportConversation"] = s.ImportConversation(USER, ConversationData{Conversation: structs.Conversation{ConversationId: uuid.New()}, Messages: []structs.Message{msg}})
+	_, writes["EditMessage"] = s.EditMessage(USER, convoId.String(), msg.MessageId.String(), "edited", AnyVersion)
+	writes["RenameConversation"] = s.RenameConversation(convoId.String(), "renamed")
+	_, writes["SetConversationModel"] = s.SetConversationModel(USER, convoId.String(), "gpt-4o")
+	writes["SetSystemPrompt"] = s.SetSystemPrompt(USER, convoId.String(), "Be brief")
+	_, writes["AddAttachment"] = s.AddAttachment(USER, convoId.String(), msg.MessageId.String(), structs.Attachment{})
+	_, writes["GrantAccess"] = s.GrantAccess(USER, convoId.String(), structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead})
+	writes["RevokeAccess"] = s.RevokeAccess(USER, convoId.String(), "Miss_Take")
+	writes["PinMessage"] = s.PinMessage(USER, convoId.String(), msg.MessageId.String())
+	writes["UnpinMessage"] = s.UnpinMessage(USER, convoId.String(), msg.MessageId.String())
+	writes["PinConversation"] = s.PinConversation(USER, convoId.String())
+	writes["UnpinConversation"] = s.UnpinConversation(USER, convoId.String())
+	writes["MarkRead"] = s.MarkRead(USER, convoId.String(), msg.MessageId.String())
+	_, writes["SetFeedback"] = s.SetFeedback(USER, convoId.String(), msg.MessageId.String(), structs.ThumbsUp, "")
+	_, writes["ForkConversation"] = s.ForkConversation(USER, convoId.String(), msg.MessageId.String(), USER)
+	_, writes["CloneConversation"] = s.CloneConversation(USER, convoId.String(), USER, CloneOptions{})
+	_, writes["MergeConversations"] = s.MergeConversations(USER, convoId.String(), uuid.NewString())
+	_, writes["CreateTemplate"] = s.CreateTemplate(structs.Template{UserId: USER, Title: "t", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}})
+	_, writes["UpdateTemplate"] = s.UpdateTemplate(USER, structs.Template{TemplateId: uuid.New(), Title: "t", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}})
+	writes["DeleteTemplate"] = s.DeleteTemplate(USER, uuid.NewString())
+	writes["SaveEmbedding"] = s.SaveEmbedding(structs.MessageEmbedding{MessageId: uuid.New(), Model: "m", Vector: []float32{1}})
+	_, writes["CreateConversationFromTemplate"] = s.CreateConversationFromTemplate(USER, uuid.NewString(), "")
+	writes["TransferOwnership"] = s.TransferOwnership(convoId.String(), "Miss_Take")
+	writes["DeleteConversation"] = s.DeleteConversation(USER, convoId.String())
+	writes["RestoreConversation"] = s.RestoreConversation(USER, convoId.String(), time.Hour)
+	writes["ArchiveConversation"] = s.ArchiveConversation(USER, convoId.String())
+	writes["UnarchiveConversation"] = s.UnarchiveConversation(USER, convoId.String())
+	_, writes["ArchiveInactive"] = s.ArchiveInactive(time.Hour)
+	_, writes["PurgeTrash"] = s.PurgeTrash(0)
+	writes["SaveLLMExchange"] = s.SaveLLMExchange(structs.LLMExchange{MessageId: uuid.New(), ConversationId: convoId})
+	_, writes["PurgeLLMExchanges"] = s.PurgeLLMExchanges(0)
+	_, writes["RecountTokens"] = s.RecountTokens(convoId.String())
+	_, writes["DeleteEmptyConversations"] = s.DeleteEmptyConversations(0)
+	_, writes["SetMetadata"] = s.SetMetadata(USER, convoId.String(), map[string]*string{"source": nil})
+	_, writes["DeleteAllForUser"] = s.DeleteAllForUser(USER)
+	for method, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("%s should return ErrReadOnly. It returned: %v", method, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("the file should not change while it's open read-only")
+	}
+}
+
+func TestReadOnly_RequiresCurrentSchema(t *testing.T) {
+	tmp := t.TempDir()
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+
+	if _, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, "missing.db"), logPth, ReadOnly()); err == nil {
+		t.Fatal("a missing file should not be created in read-only mode")
+	}
+
+	// a file that predates migrations
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	legacy, err := gorm.Open(sqlite.Open(pth), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.AutoMigrate(&legacyConversation{}, &legacyMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := legacy.DB()
+	sqlDB.Close()
+
+	if _, err := NewSQLiteStore(pth, logPth, ReadOnly()); err == nil || !strings.Contains(err.Error(), "migrations") {
+		t.Fatalf("a file with pending migrations should not open read-only. It returned: %v", err)
+	}
+}
+
+func TestConnPool(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+
+	s, err := openSQLiteStore(pth, logPth, ConnPool(4, 2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := s.db.DB()
+	if open := sqlDB.Stats().MaxOpenConnections; open != 1 {
+		t.Fatalf("a writable store should have a single connection. It has %d", open)
+	}
+	s.Close()
+
+	ro, err := openSQLiteStore(pth, logPth, ReadOnly(), ConnPool(4, 2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	sqlDB, _ = ro.db.DB()
+	if open := sqlDB.Stats().MaxOpenConnections; open != 4 {
+		t.Fatalf("a read-only store should have the pool's connections. It has %d", open)
+	}
+}
+
+func TestAppendMessage_Atomic(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	before, err := s.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fail the write of a table once the message was inserted and the conversation bumped, like a crash would
+	errInjected := errors.New("injected failure")
+	var failOn any
+	err = s.(*sqliteStore).db.Callback().Create().After("gorm:create").Register("test:fail", func(tx *gorm.DB) {
+		if failOn != nil && reflect.TypeOf(tx.Statement.Dest) == reflect.TypeOf(failOn) {
+			tx.AddError(errInjected)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		failOn any
+	}{
+		{"message insert", &structs.Message{}},
+		{"graph context insert", &structs.MessageContext{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			failOn = tt.failOn
+			defer func() { failOn = nil }()
+			msg := structs.Message{
+				ConversationId: convoId, MessageId: uuid.New(), Content: "Bonjour, comment allez-vous aujourd'hui ?", Role: structs.AssistantRole,
+				GraphContext: &structs.GraphContext{Query: "answer_question"},
+			}
+			if _, err := s.AppendMessage(msg, before.Version); !errors.Is(err, errInjected) {
+				t.Fatalf("the append should fail, got: %v", err)
+			}
+
+			after, err := s.FindConversation(convoId.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Version != before.Version || !after.UpdatedAt.Equal(before.UpdatedAt) || after.Language != before.Language {
+				t.Fatalf("the conversation shouldn't change. It went from %+v to %+v", before, after)
+			}
+			if messages, err := s.GetConversation(USER, convoId.String()); err != nil || len(messages) != 1 {
+				t.Fatalf("the message shouldn't be kept, got %d messages, %v", len(messages), err)
+			}
+			if contexts, err := s.GraphContexts(convoId.String()); err != nil || len(contexts) != 0 {
+				t.Fatalf("the graph context shouldn't be kept, got %v, %v", contexts, err)
+			}
+		})
+	}
+
+	// with nothing failing, it's all written
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "the answer", Role: structs.AssistantRole}
+	convo, err := s.AppendMessage(reply, before.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.Version != before.Version+1 {
+		t.Fatalf("the version should be bumped once, it's %d", convo.Version)
+	}
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, DB_NAME)
+	logPth := fmt.Sprintf("%s/test.log", tmp)
+
+	// two stores on the same file contend for its lock like two instances of the service would
+	stores := make([]*sqliteStore, 2)
+	for i := range stores {
+		s, err := openSQLiteStore(pth, logPth, BusyTimeout(10*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[i] = s
+	}
+	var mode string
+	stores[0].db.Raw("PRAGMA journal_mode").Scan(&mode)
+	if mode != "wal" {
+		t.Fatalf("the database should use WAL journaling. It uses %q", mode)
+	}
+
+	const workers, appends = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*appends+1)
+	// the other store holds the write lock for a while, appends have to wait it out
+	held := seedConversation(t, stores[1], USER)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- stores[1].db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", held).Update("name", "locked").Error; err != nil {
+				return err
+			}
+			time.Sleep(200 * time.Millisecond)
+			return nil
+		})
+	}()
+	for w := range workers {
+		s := stores[w%len(stores)]
+		convoId := seedConversation(t, s, USER)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range appends {
+				msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hello again", Role: structs.UserRole}
+				if _, err := s.AppendMessage(msg, AnyVersion); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("write failed: %v", err)
+		}
+	}
+
+	var count int64
+	stores[0].db.Model(&structs.Message{}).Count(&count)
+	if want := int64(workers*(appends+1) + 1); count != want {
+		t.Fatalf("every message should be written. There are %d of %d", count, want)
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) SaveStreamedMessage(message structs.Message) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validateMessage(message); err != nil {
+		return nil, err
+	}
+	message.Pinned = false
+	detectLanguage(&message)
+	s.countTokens(&message)
+	plain := message
+	if err := s.sealer.sealMessage(&message); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := bumpVersion(tx, message.ConversationId, AnyVersion); err != nil {
+			return err
+		}
+		var existing structs.Message
+		res := tx.Where("conversation_id = ? AND message_id = ?", message.ConversationId, message.MessageId).First(&existing)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			if err := tx.Create(&message).Error; err != nil {
+				return err
+			}
+			// streamed messages are the LLM's replies
+			if err := appendEvent(tx, appendedEvent("", message)); err != nil {
+				return err
+			}
+		} else if res.Error != nil {
+			return res.Error
+		} else if !existing.Incomplete {
+			return fmt.Errorf("%w: message %s is complete", ErrInvalidMessages, message.MessageId)
+		} else {
+			// a resumed reply is the model's that continued it
+			err := tx.Model(&existing).Select("ModelName", "Provider", "Content", "ResponseTime", "Incomplete", "Language", "TokenCount").Updates(structs.Message{
+				ModelName:    message.ModelName,
+				Provider:     message.Provider,
+				Content:      message.Content,
+				ResponseTime: message.ResponseTime,
+				Incomplete:   message.Incomplete,
+				Language:     message.Language,
+				TokenCount:   message.TokenCount,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := updateLanguage(tx, message.ConversationId); err != nil {
+			return err
+		}
+		_, err := updateTokenCount(tx, message.ConversationId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	convo := structs.Conversation{}
+	if err := s.db.Where("conversation_id = ?", message.ConversationId).Find(&convo).Error; err != nil {
+		return nil, err
+	}
+	if !message.Incomplete {
+		s.emit(EventMessageAppended, convo, plain, message)
+	}
+	return &convo, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSaveStreamedMessage(t *testing.T) {
+	var events []Event
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp),
+		EncryptionKey([]byte("0123456789abcdef")), Notify(func(ev Event) { events = append(events, ev) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	convoId := seedConversation(t, s, USER)
+	events = nil
+
+	// the reply is added with the first part, and grows
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "There are", Role: structs.SystemRole, Incomplete: true}
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	reply.Content, reply.ResponseTime = "There are 100", 1.5
+	convo, err := s.SaveStreamedMessage(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.Version != 2 {
+		t.Fatalf("each save should change the conversation's version. It's %d", convo.Version)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[1].Content != "There are 100" || !messages[1].Incomplete || messages[1].ResponseTime != 1.5 {
+		t.Fatalf("the reply should be saved once, incomplete, with what it has so far: %+v", messages)
+	}
+	if len(events) != 0 {
+		t.Fatalf("there shouldn't be events for an incomplete reply: %+v", events)
+	}
+
+	// until it's done
+	reply.Content, reply.Incomplete = "There are 100 transactions", false
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	messages, err = s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages[1].Content != "There are 100 transactions" || messages[1].Incomplete {
+		t.Fatalf("the reply should be complete: %+v", messages[1])
+	}
+	if len(events) != 1 || events[0].Type != EventMessageAppended || events[0].Message.Content != "There are 100 transactions" {
+		t.Fatalf("the complete reply should be sent as appended: %+v", events)
+	}
+
+	// after which it can't be changed
+	reply.Content = "changed"
+	if _, err := s.SaveStreamedMessage(reply); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("expected ErrInvalidMessages, got: %v", err)
+	}
+	// and neither can other complete messages, or be saved empty
+	if _, err := s.SaveStreamedMessage(structs.Message{ConversationId: convoId, MessageId: messages[0].MessageId, Content: "x", Role: structs.UserRole}); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("expected ErrInvalidMessages, got: %v", err)
+	}
+	if _, err := s.SaveStreamedMessage(structs.Message{ConversationId: convoId, MessageId: uuid.New(), Role: structs.SystemRole, Incomplete: true}); !errors.Is(err, ErrInvalidMessages) {
+		t.Fatalf("expected ErrInvalidMessages, got: %v", err)
+	}
+}
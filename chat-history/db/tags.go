@@ -0,0 +1,126 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the longest tag, in characters
+const maxTagLength = 64
+
+var ErrInvalidTag = errors.New("tags must be 1 to 64 characters")
+
+// normalizeTag trims the tag, tags are otherwise kept as they're written
+func normalizeTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return "", ErrInvalidTag
+	}
+	return tag, nil
+}
+
+func (s *sqliteStore) AddTag(userId, conversationId, tag string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return err
+	}
+	// adding a tag that's already there does nothing
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&structs.ConversationTag{ConversationId: convoId, Tag: tag}).Error
+}
+
+func (s *sqliteStore) RemoveTag(userId, conversationId, tag string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	convoId, err := s.ownedConversation(userId, conversationId)
+	if err != nil {
+		return err
+	}
+	// removing a tag that isn't there does nothing
+	return s.db.Where("conversation_id = ? AND tag = ?", convoId, tag).Delete(&structs.ConversationTag{}).Error
+}
+
+// ownedConversation returns the id of the conversation if it belongs to the user and isn't in the trash, or ErrNotFound
+func (s *sqliteStore) ownedConversation(userId, conversationId string) (uuid.UUID, error) {
+	convo := structs.Conversation{}
+	tx := s.db.Where("user_id = ? AND conversation_id = ?", userId, conversationId).First(&convo)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return uuid.UUID{}, ErrNotFound
+	} else if tx.Error != nil {
+		return uuid.UUID{}, tx.Error
+	}
+	return convo.ConversationId, nil
+}
+
+// withTags filters the query to conversations that have every one of the tags
+func withTags(tx *gorm.DB, tags []string) (*gorm.DB, error) {
+	if len(tags) == 0 {
+		return tx, nil
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+	// a tag asked for twice would never match the count
+	slices.Sort(normalized)
+	tags = slices.Compact(normalized)
+
+	tagged := tx.Session(&gorm.Session{NewDB: true}).Model(&structs.ConversationTag{}).
+		Select("conversation_id").
+		Where("tag IN ?", tags).
+		Group("conversation_id").
+		Having("COUNT(*) = ?", len(tags))
+	return tx.Where("conversation_id IN (?)", tagged), nil
+}
+
+// loadTags fills in the tags of the conversations in db, sorted by name
+func loadTags(db *gorm.DB, convos []structs.Conversation) error {
+	if len(convos) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(convos))
+	for i, c := range convos {
+		ids[i] = c.ConversationId
+	}
+	var tags []structs.ConversationTag
+	if err := db.Where("conversation_id IN ?", ids).Order("tag").Find(&tags).Error; err != nil {
+		return err
+	}
+	byConvo := map[uuid.UUID][]string{}
+	for _, t := range tags {
+		byConvo[t.ConversationId] = append(byConvo[t.ConversationId], t.Tag)
+	}
+	for i := range convos {
+		convos[i].Tags = byConvo[convos[i].ConversationId]
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTags(t *testing.T) {
+	s := newTestStore(t)
+	both := seedConversation(t, s, USER).String()
+	work := seedConversation(t, s, USER).String()
+	untagged := seedConversation(t, s, USER).String()
+
+	for _, tag := range []string{"work", " urgent "} {
+		if err := s.AddTag(USER, both, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// adding it twice is the same as adding it once
+	for range 2 {
+		if err := s.AddTag(USER, work, "work"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{untagged, work, both}},
+		{[]string{"work"}, []string{work, both}},
+		{[]string{"work", "urgent"}, []string{both}},
+		{[]string{"urgent", "work", "work"}, []string{both}},
+		{[]string{"work", "missing"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.tags, ","), func(t *testing.T) {
+			convos, _, err := s.ListConversations(USER, ListOptions{Tags: tt.tags})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range convos {
+				got = append(got, c.ConversationId.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("conversations tagged %v should be %v. They're: %v", tt.tags, tt.want, got)
+			}
+		})
+	}
+
+	convos, _, _ := s.ListConversations(USER, ListOptions{})
+	for _, c := range convos {
+		want := map[string][]string{both: {"urgent", "work"}, work: {"work"}, untagged: nil}[c.ConversationId.String()]
+		if !slices.Equal(c.Tags, want) {
+			t.Fatalf("conversation %s should have tags %v. It has: %v", c.ConversationId, want, c.Tags)
+		}
+	}
+
+	if err := s.RemoveTag(USER, both, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if convos, _, _ := s.ListConversations(USER, ListOptions{Tags: []string{"work"}}); len(convos) != 1 {
+		t.Fatalf("only 1 conversation should still be tagged work. There are: %d", len(convos))
+	}
+	// removing a tag that isn't there does nothing
+	if err := s.RemoveTag(USER, untagged, "work"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTags_Ownership(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER).String()
+
+	if err := s.AddTag("someone-else", convoId, "mine"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("tagging another user's conversation should return ErrNotFound. It's: %v", err)
+	}
+	if err := s.AddTag(USER, convoId, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveTag("someone-else", convoId, "work"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("untagging another user's conversation should return ErrNotFound. It's: %v", err)
+	}
+	if err := s.AddTag(USER, uuid.NewString(), "work"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("tagging a missing conversation should return ErrNotFound. It's: %v", err)
+	}
+	if convos, _, _ := s.ListConversations("someone-else", ListOptions{Tags: []string{"work"}}); len(convos) != 0 {
+		t.Fatalf("filtering by tag shouldn't return other users' conversations: %v", convos)
+	}
+}
+
+func TestTags_Invalid(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER).String()
+
+	for _, tag := range []string{"", "   ", strings.Repeat("x", 65)} {
+		if err := s.AddTag(USER, convoId, tag); !errors.Is(err, ErrInvalidTag) {
+			t.Fatalf("tag %q should return ErrInvalidTag. It's: %v", tag, err)
+		}
+	}
+	if _, _, err := s.ListConversations(USER, ListOptions{Tags: []string{""}}); !errors.Is(err, ErrInvalidTag) {
+		t.Fatalf("filtering by an empty tag should return ErrInvalidTag. It's: %v", err)
+	}
+}
+
+func TestPurgeTrash_RemovesTags(t *testing.T) {
+	s := newTestStore(t).(*sqliteStore)
+	convoId := seedConversation(t, s, USER).String()
+	if err := s.AddTag(USER, convoId, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteConversation(USER, convoId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PurgeTrash(-time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	s.db.Model(&structs.ConversationTag{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("purging a conversation should remove its tags. There are %d left", count)
+	}
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxTemplateTitleLength = 255
+
+// ErrInvalidTemplate is returned, wrapped with what's wrong, when a template can't be saved
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// validateTemplate trims the template's title, and checks it has one and messages conversations can start with
+func (s *sqliteStore) validateTemplate(t *structs.Template) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidTemplate, fmt.Sprintf(format, args...))
+	}
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" || utf8.RuneCountInString(t.Title) > maxTemplateTitleLength {
+		return invalid("title must be 1 to %d characters", maxTemplateTitleLength)
+	}
+	if len(t.Messages) == 0 {
+		return invalid("there are no messages")
+	}
+	for i, m := range t.Messages {
+		if !s.roles.valid(m.Role) {
+			return invalid("message %d: role must be %s, not %q", i, s.roles, m.Role)
+		}
+		if strings.TrimSpace(m.Content) == "" {
+			return invalid("message %d: content is required", i)
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateTemplate(template structs.Template) (*structs.Template, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validateTemplate(&template); err != nil {
+		return nil, err
+	}
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	template.TemplateId = id
+	if err := s.db.Create(&template).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (s *sqliteStore) GetTemplate(userId, templateId string) (*structs.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.template(userId, templateId)
+}
+
+// template returns the template if it's the user's or shared
+func (s *sqliteStore) template(userId, templateId string) (*structs.Template, error) {
+	template := structs.Template{}
+	tx := s.db.Where("template_id = ? AND (user_id = ? OR shared)", templateId, userId).First(&template)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &template, nil
+}
+
+func (s *sqliteStore) ListTemplates(userId string) ([]structs.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := []structs.Template{}
+	if err := s.db.Where("user_id = ? OR shared", userId).Order("title, created_at").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *sqliteStore) UpdateTemplate(userId string, template structs.Template) (*structs.Template, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validateTemplate(&template); err != nil {
+		return nil, err
+	}
+	existing := structs.Template{}
+	tx := s.db.Where("template_id = ? AND user_id = ?", template.TemplateId, userId).First(&existing)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+	existing.Title, existing.Shared, existing.Messages = template.Title, template.Shared, template.Messages
+	if err := s.db.Save(&existing).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s *sqliteStore) DeleteTemplate(userId, templateId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.db.Where("template_id = ? AND user_id = ?", templateId, userId).Delete(&structs.Template{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) CreateConversationFromTemplate(userId, templateId, name string) (*structs.Conversation, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, err := s.template(userId, templateId)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(name) == "" {
+		name = template.Title
+	}
+	convo := structs.Conversation{UserId: userId, ConversationId: id, Name: name}
+	messages := make([]structs.Message, len(template.Messages))
+	var parent *uuid.UUID
+	for i, m := range template.Messages {
+		// written in order, so the last one is the latest
+		messages[i] = structs.Message{
+			ConversationId: id,
+			MessageId:      uuid.New(),
+			ParentId:       parent,
+			Content:        m.Content,
+			Role:           m.Role,
+		}
+		detectLanguage(&messages[i])
+		s.countTokens(&messages[i])
+		parent = &messages[i].MessageId
+	}
+	first := messages[0]
+	for i := range messages {
+		if err := s.sealer.sealMessage(&messages[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkConversationLimit(tx, userId); err != nil {
+			return err
+		}
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(messages, 100).Error; err != nil {
+			return err
+		}
+		if err := appendEvent(tx, createdEvent(convo, messages[0])); err != nil {
+			return err
+		}
+		if convo.Language, err = updateLanguage(tx, id); err != nil {
+			return err
+		}
+		convo.TokenCount, err = updateTokenCount(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.emit(EventConversationCreated, convo, first, messages[0])
+	return &convo, nil
+}
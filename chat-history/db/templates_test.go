@@ -0,0 +1,129 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateConversationFromTemplate(t *testing.T) {
+	s := newArchiveStore(t, EncryptionKey([]byte("0123456789abcdef")))
+	template, err := s.CreateTemplate(structs.Template{
+		UserId: USER,
+		Title:  " Fraud triage ",
+		Messages: []structs.TemplateMessage{
+			{Role: structs.SystemRole, Content: "You help analysts triage fraud alerts."},
+			{Role: structs.UserRole, Content: "Which accounts were flagged today?"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.TemplateId == uuid.Nil || template.Title != "Fraud triage" {
+		t.Fatalf("the template should get an id and a trimmed title: %+v", template)
+	}
+
+	convo, err := s.CreateConversationFromTemplate(USER, template.TemplateId.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.UserId != USER || convo.Name != "Fraud triage" {
+		t.Fatalf("the conversation should be the user's, named after the template: %+v", convo)
+	}
+	messages, err := s.GetConversation(USER, convo.ConversationId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("the conversation should have the template's 2 messages. It has %d", len(messages))
+	}
+	for i, m := range messages {
+		if m.Role != template.Messages[i].Role || m.Content != template.Messages[i].Content {
+			t.Fatalf("message %d should be the template's: %+v", i, m)
+		}
+	}
+	if messages[0].ParentId != nil || messages[1].ParentId == nil || *messages[1].ParentId != messages[0].MessageId {
+		t.Fatalf("the messages should be a branch in order: %v", messages)
+	}
+	// each one gets its own conversation and message ids
+	again, err := s.CreateConversationFromTemplate(USER, template.TemplateId.String(), "Tuesday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ConversationId == convo.ConversationId || again.Name != "Tuesday" {
+		t.Fatalf("a second conversation should be new, with the name given: %+v", again)
+	}
+}
+
+func TestTemplates_Sharing(t *testing.T) {
+	s := newTestStore(t)
+	message := []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}
+	private, err := s.CreateTemplate(structs.Template{UserId: USER, Title: "b private", Messages: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := s.CreateTemplate(structs.Template{UserId: USER, Title: "a shared", Shared: true, Messages: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if templates, err := s.ListTemplates(USER); err != nil || len(templates) != 2 || templates[0].TemplateId != shared.TemplateId {
+		t.Fatalf("the owner should list both templates by title. Got %v, %v", templates, err)
+	}
+	templates, err := s.ListTemplates("Miss_Take")
+	if err != nil || len(templates) != 1 || templates[0].TemplateId != shared.TemplateId {
+		t.Fatalf("other users should only list the shared template. Got %v, %v", templates, err)
+	}
+	if _, err := s.GetTemplate("Miss_Take", private.TemplateId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't get a private template. Got %v", err)
+	}
+	if _, err := s.CreateConversationFromTemplate("Miss_Take", private.TemplateId.String(), ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't start conversations from a private template. Got %v", err)
+	}
+	convo, err := s.CreateConversationFromTemplate("Miss_Take", shared.TemplateId.String(), "")
+	if err != nil || convo.UserId != "Miss_Take" {
+		t.Fatalf("other users should start their own conversations from a shared template. Got %+v, %v", convo, err)
+	}
+
+	// only the owner changes and deletes them
+	shared.Title = "taken"
+	if _, err := s.UpdateTemplate("Miss_Take", *shared); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't update a shared template. Got %v", err)
+	}
+	if err := s.DeleteTemplate("Miss_Take", shared.TemplateId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other users shouldn't delete a shared template. Got %v", err)
+	}
+	shared.Shared = false
+	updated, err := s.UpdateTemplate(USER, *shared)
+	if err != nil || updated.Title != "taken" || updated.Shared {
+		t.Fatalf("the owner should update the template. Got %+v, %v", updated, err)
+	}
+	if _, err := s.GetTemplate("Miss_Take", shared.TemplateId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a template that's no longer shared shouldn't be found. Got %v", err)
+	}
+	if err := s.DeleteTemplate(USER, private.TemplateId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTemplate(USER, private.TemplateId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a deleted template shouldn't be found. Got %v", err)
+	}
+}
+
+func TestCreateTemplate_Invalid(t *testing.T) {
+	s := newTestStore(t)
+	tests := map[string]structs.Template{
+		"no title":      {UserId: USER, Title: " ", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: "hi"}}},
+		"no messages":   {UserId: USER, Title: "t"},
+		"invalid role":  {UserId: USER, Title: "t", Messages: []structs.TemplateMessage{{Role: "tool", Content: "hi"}}},
+		"empty content": {UserId: USER, Title: "t", Messages: []structs.TemplateMessage{{Role: structs.UserRole, Content: " "}}},
+	}
+	for name, template := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.CreateTemplate(template); !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("should return ErrInvalidTemplate. Got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"chat-history/tenant"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the column of structs.Tenant
+const tenantColumn = "tenant_id"
+
+// ErrNoTenant is returned for the queries of a request that has to be for a tenant and isn't, see tenant.Require
+var ErrNoTenant = errors.New("the request has no tenant")
+
+// registerTenants keeps the store's queries to the tenant of their context, see tenant.FromContext: the rows
+// that are created are the tenant's, and every query, update and delete of a table with a tenant only
+// reaches the tenant's rows. It covers the subqueries and the rows of the model of a query, not the tables
+// it joins, and not raw SQL, which has to add rawTenantFilter itself. Queries without a tenant, like the
+// store's own work, reach every tenant's rows, unless their context requires one (see tenant.Require): those,
+// raw SQL included, fail with ErrNoTenant
+func registerTenants(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("tenants:create", setTenant),
+		cb.Query().Before("gorm:query").Register("tenants:query", filterTenant),
+		cb.Update().Before("gorm:update").Register("tenants:update", filterTenant),
+		cb.Delete().Before("gorm:delete").Register("tenants:delete", filterTenant),
+		cb.Row().Before("gorm:row").Register("tenants:row", filterTenant),
+		cb.Raw().Before("gorm:raw").Register("tenants:raw", requireTenant),
+	}
+	return errors.Join(errs...)
+}
+
+// tenantOf is the tenant db's queries are for. ok is false if they're for every tenant. A query that has to be
+// for one and isn't gets ErrNoTenant, so it isn't run
+func tenantOf(db *gorm.DB) (id string, ok bool) {
+	id, ok = tenant.FromContext(db.Statement.Context)
+	if !ok && tenant.Required(db.Statement.Context) {
+		db.AddError(ErrNoTenant)
+	}
+	return id, ok
+}
+
+// requireTenant fails the raw SQL of a request that has no tenant but needs one
+func requireTenant(tx *gorm.DB) {
+	tenantOf(tx)
+}
+
+// rawTenantFilter is the condition raw SQL adds to its WHERE for the rows of table, which can be an alias, to
+// be the tenant's, and args with the tenant after them. Both are as they are when the queries are for every tenant
+func (s *sqliteStore) rawTenantFilter(table string, args ...any) (string, []any) {
+	id, ok := tenantOf(s.db)
+	if !ok {
+		return "", args
+	}
+	return " AND " + table + "." + tenantColumn + " = ?", append(args, id)
+}
+
+func filterTenant(tx *gorm.DB) {
+	id, ok := tenantOf(tx)
+	if !ok || tx.Statement.Schema == nil || tx.Statement.Schema.LookUpField(tenantColumn) == nil {
+		return
+	}
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: tenantColumn}, Value: id},
+	}})
+}
+
+// setTenant puts the rows being created in the tenant. Without one they keep the tenant they were given, i.e.,
+// rows moved to the archive or made from another row of the tenant
+func setTenant(tx *gorm.DB) {
+	id, ok := tenantOf(tx)
+	if !ok || tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.LookUpField(tenantColumn)
+	if field == nil {
+		return
+	}
+	ctx, rv := tx.Statement.Context, tx.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := field.Set(ctx, reflect.Indirect(rv.Index(i)), id); err != nil {
+				tx.AddError(err)
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(ctx, rv, id); err != nil {
+			tx.AddError(err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"chat-history/structs"
+	"chat-history/tenant"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTenants(t *testing.T) {
+	root := newTestStore(t)
+	acme := root.WithContext(tenant.NewContext(context.Background(), "acme"))
+	globex := root.WithContext(tenant.NewContext(context.Background(), "globex"))
+
+	first := newMessage()
+	first.Content = "Which accounts share a phone number?"
+	convo, err := acme.CreateConversation(USER, "fraud rings", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := convo.ConversationId.String()
+	if err := acme.AddTag(USER, convoId, "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.AddAttachment(USER, convoId, first.MessageId.String(), structs.Attachment{Filename: "accounts.csv", ContentType: "text/csv", Size: 512, StorageURL: "https://files.example.com/accounts.csv"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same user in another tenant sees none of it
+	if _, err := globex.FindConversation(convoId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another tenant shouldn't find the conversation, got %v", err)
+	}
+	if messages, err := globex.GetConversation(USER, convoId); err != nil || len(messages) != 0 {
+		t.Fatalf("another tenant shouldn't read the messages. Got %v, %v", messages, err)
+	}
+	if convos, _, err := globex.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 0 {
+		t.Fatalf("another tenant shouldn't list the conversation. Got %v, %v", convos, err)
+	}
+	if results, err := globex.Search(USER, "phone", DefaultSearchWeights); err != nil || len(results) != 0 {
+		t.Fatalf("another tenant shouldn't find the messages. Got %v, %v", results, err)
+	}
+	if results, err := globex.SearchMessages(USER, "phone"); err != nil || len(results) != 0 {
+		t.Fatalf("another tenant shouldn't find the messages. Got %v, %v", results, err)
+	}
+	if attachments, err := globex.ListAttachments(USER, convoId, first.MessageId.String()); len(attachments) != 0 {
+		t.Fatalf("another tenant shouldn't list the attachments. Got %v, %v", attachments, err)
+	}
+	if stats, err := globex.UserStats(USER); err != nil || stats.Conversations != 0 {
+		t.Fatalf("another tenant shouldn't count the conversation. Got %+v, %v", stats, err)
+	}
+
+	// nor change it
+	if err := globex.RenameConversation(convoId, "mine now"); err != nil && !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+	if err := globex.DeleteConversation(USER, convoId); err != nil && !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+	// a message it writes to the conversation's id is its own, the tenant doesn't see it
+	reply := newMessage()
+	reply.ConversationId = convo.ConversationId
+	if _, err := globex.AppendMessage(reply, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	got, err := acme.FindConversation(convoId)
+	if err != nil || got.Name != "fraud rings" {
+		t.Fatalf("the conversation should be left as it was. Got %+v, %v", got, err)
+	}
+	if messages, err := acme.GetConversation(USER, convoId); err != nil || len(messages) != 1 || messages[0].TenantId != "acme" {
+		t.Fatalf("the tenant should read its messages. Got %+v, %v", messages, err)
+	}
+	if convos, _, err := acme.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 1 || convos[0].TenantId != "acme" {
+		t.Fatalf("the tenant should list its conversation. Got %+v, %v", convos, err)
+	}
+	if results, err := acme.Search(USER, "phone", DefaultSearchWeights); err != nil || len(results) != 1 {
+		t.Fatalf("the tenant should find its messages. Got %v, %v", results, err)
+	}
+
+	// the store without a tenant sees every tenant's
+	if _, err := globex.CreateConversation(USER, "globex", newMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if convos, _, err := root.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 2 {
+		t.Fatalf("the store without a tenant should list both tenants' conversations. Got %v, %v", convos, err)
+	}
+}
+
+func TestTenants_Keys(t *testing.T) {
+	root := newTestStore(t)
+	acme := root.WithContext(tenant.NewContext(context.Background(), "acme"))
+	globex := root.WithContext(tenant.NewContext(context.Background(), "globex"))
+
+	// external ids and idempotency keys are the tenant's own
+	a, _, err := acme.CreateConversationWithExternalId(USER, "ticket-1", "convo", newMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, created, err := globex.CreateConversationWithExternalId(USER, "ticket-1", "convo", newMessage())
+	if err != nil || !created || g.ConversationId == a.ConversationId {
+		t.Fatalf("another tenant should get a conversation of its own for the external id. Got %+v, %v, %v", g, created, err)
+	}
+	if found, err := globex.FindConversationByExternalId(USER, "ticket-1"); err != nil || found.ConversationId != g.ConversationId {
+		t.Fatalf("each tenant should find its own conversation. Got %+v, %v", found, err)
+	}
+
+	a, _, err = acme.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, created, err = globex.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour)
+	if err != nil || !created || g.ConversationId == a.ConversationId {
+		t.Fatalf("another tenant should get a conversation of its own for the key. Got %+v, %v, %v", g, created, err)
+	}
+	if again, created, err := acme.CreateConversationOnce(USER, "retry-1", "convo", keyedMessage(), time.Hour); err != nil || created || again.ConversationId != a.ConversationId {
+		t.Fatalf("a retry should still get the tenant's conversation. Got %+v, %v, %v", again, created, err)
+	}
+}
+
+func TestTenants_Required(t *testing.T) {
+	root := newTestStore(t)
+	acme := root.WithContext(tenant.NewContext(context.Background(), "acme"))
+	convo, err := acme.CreateConversation(USER, "fraud rings", newMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	convoId := convo.ConversationId.String()
+
+	// a request without the tenant it needs reaches nothing, raw SQL included
+	required := root.WithContext(tenant.Require(context.Background()))
+	if _, _, err := required.ListConversations(USER, ListOptions{}); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("listing without a tenant should fail with ErrNoTenant, got %v", err)
+	}
+	if _, err := required.GetAllMessages(); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("reading every message without a tenant should fail with ErrNoTenant, got %v", err)
+	}
+	if _, err := required.Search(USER, "phone", DefaultSearchWeights); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("searching without a tenant should fail with ErrNoTenant, got %v", err)
+	}
+	if _, err := required.CreateConversation(USER, "no tenant", newMessage()); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("creating without a tenant should fail with ErrNoTenant, got %v", err)
+	}
+	if err := required.RenameConversation(convoId, "anyone's"); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("renaming without a tenant should fail with ErrNoTenant, got %v", err)
+	}
+
+	// with one, or once the request can reach any tenant, it works as usual
+	withTenant := root.WithContext(tenant.NewContext(tenant.Require(context.Background()), "acme"))
+	if convos, _, err := withTenant.ListConversations(USER, ListOptions{}); err != nil || len(convos) != 1 {
+		t.Fatalf("the tenant should list its conversation. Got %v, %v", convos, err)
+	}
+	anyone := root.WithContext(tenant.AnyTenant(tenant.Require(context.Background())))
+	if got, err := anyone.FindConversation(convoId); err != nil || got.Name != "fraud rings" {
+		t.Fatalf("a request for any tenant should find the conversation. Got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// estimateTokens is about 4 characters a token, like llm.CountTokens for models without a tokenizer
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// countTokens sets the token count of m from its content. Call it before m is sealed
+func (s *sqliteStore) countTokens(m *structs.Message) {
+	m.TokenCount = s.tokenCounter(m.Content)
+}
+
+// updateTokenCount sets the token count of the conversation to the total of its messages', and returns it. Writes
+// that add a single message add its count instead
+func updateTokenCount(tx *gorm.DB, conversationId any) (int64, error) {
+	var total int64
+	err := tx.Model(&structs.Message{}).Select("COALESCE(SUM(token_count), 0)").
+		Where("conversation_id = ?", conversationId).Scan(&total).Error
+	if err != nil {
+		return 0, err
+	}
+	// it's not a change of the conversation, its updated_at stays
+	err = tx.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).UpdateColumn("token_count", total).Error
+	return total, err
+}
+
+func (s *sqliteStore) TokenUsage(userId string) (*structs.TokenUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := structs.TokenUsage{UserId: userId, Conversations: []structs.ConversationTokens{}}
+	err := s.db.Model(&structs.Conversation{}).Select("conversation_id, name, token_count").
+		Where("user_id = ?", userId).Order("updated_at DESC").Order("id DESC").
+		Scan(&usage.Conversations).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range usage.Conversations {
+		usage.TokenCount += c.TokenCount
+	}
+	return &usage, nil
+}
+
+func (s *sqliteStore) RecountTokens(conversationId string) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		convo := structs.Conversation{}
+		if err := tx.Where("conversation_id = ?", conversationId).First(&convo).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		var messages []structs.Message
+		if err := tx.Where("conversation_id = ?", convo.ConversationId).Find(&messages).Error; err != nil {
+			return err
+		}
+		if err := s.sealer.openMessages(messages); err != nil {
+			return err
+		}
+		for _, m := range messages {
+			count := s.tokenCounter(m.Content)
+			if count == m.TokenCount {
+				continue
+			}
+			// UpdateColumn so recounting doesn't change updated_at
+			if err := tx.Model(&structs.Message{}).Where("id = ?", m.ID).UpdateColumn("token_count", count).Error; err != nil {
+				return err
+			}
+		}
+		var err error
+		total, err = updateTokenCount(tx, convo.ConversationId)
+		return err
+	})
+	return total, err
+}
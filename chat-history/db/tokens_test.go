@@ -0,0 +1,139 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// a token a word, so the counts are easy to tell
+func countWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+func TestTokenCounts(t *testing.T) {
+	// counted before they're sealed
+	s := newArchiveStore(t, TokenCounter(countWords), EncryptionKey([]byte("0123456789abcdef")))
+	convoId := seedConversation(t, s, USER)
+	tokens := func() int64 {
+		t.Helper()
+		convo, err := s.FindConversation(convoId.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return convo.TokenCount
+	}
+	// "Hello, world"
+	want := int64(2)
+	if got := tokens(); got != want {
+		t.Fatalf("a new conversation should have its message's tokens, %d. Got %d", want, got)
+	}
+
+	var last structs.Message
+	for _, content := range []string{"How many transactions?", "There are 100 transactions", "Which were flagged as fraud?"} {
+		last = structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+		if _, err := s.AppendMessage(last, AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		want += int64(countWords(content))
+		if got := tokens(); got != want {
+			t.Fatalf("the total should be %d once %q is added. Got %d", want, content, got)
+		}
+	}
+	// rating a message doesn't add it again
+	last.Feedback = structs.Feedback(1)
+	if _, err := s.AppendMessage(last, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens(); got != want {
+		t.Fatalf("the total should stay %d when a message is rated. Got %d", want, got)
+	}
+
+	// edits and streamed replies replace the message's tokens
+	if _, err := s.EditMessage(USER, convoId.String(), last.MessageId.String(), "Which were fraud?", AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	want -= 2
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Accounts 12", Role: structs.AssistantRole, Incomplete: true}
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	reply.Content, reply.Incomplete = "Accounts 12 and 42", false
+	if _, err := s.SaveStreamedMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	want += 4
+	if got := tokens(); got != want {
+		t.Fatalf("the total should be %d after the edit and the reply. Got %d", want, got)
+	}
+
+	// the incremental total is what counting every message again gets
+	recounted, err := s.RecountTokens(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recounted != want {
+		t.Fatalf("a recount should get the same total, %d. Got %d", want, recounted)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int64
+	for _, m := range messages {
+		if m.TokenCount != countWords(m.Content) {
+			t.Fatalf("message %q should have %d tokens. It has %d", m.Content, countWords(m.Content), m.TokenCount)
+		}
+		sum += int64(m.TokenCount)
+	}
+	if sum != want {
+		t.Fatalf("the messages should add up to %d. They're %d", want, sum)
+	}
+
+	// messages from before they were counted
+	if err := s.(*sqliteStore).db.Exec("UPDATE messages SET token_count = 0").Error; err != nil {
+		t.Fatal(err)
+	}
+	if recounted, err := s.RecountTokens(convoId.String()); err != nil || recounted != want || tokens() != want {
+		t.Fatalf("a recount should count the messages again, %d. Got %d, %v", want, recounted, err)
+	}
+	if _, err := s.RecountTokens(uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("recounting a conversation that doesn't exist should be ErrNotFound. Got %v", err)
+	}
+}
+
+func TestTokenUsage(t *testing.T) {
+	s := newArchiveStore(t, TokenCounter(countWords))
+	first := seedConversation(t, s, USER)
+	// "reply 0" and "reply 1"
+	seedReplies(t, s, first, 2)
+	second := seedConversation(t, s, USER)
+	// deleted conversations and other users' aren't counted
+	deleted := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, deleted.String()); err != nil {
+		t.Fatal(err)
+	}
+	seedReplies(t, s, seedConversation(t, s, "Miss_Take"), 5)
+
+	usage, err := s.TokenUsage(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.UserId != USER || usage.TokenCount != 8 || len(usage.Conversations) != 2 {
+		t.Fatalf("expected 8 tokens in 2 conversations. Got %+v", usage)
+	}
+	// most recently updated first
+	if c := usage.Conversations[0]; c.ConversationId != second || c.TokenCount != 2 || c.Name != "convo" {
+		t.Fatalf("the newest conversation should have 2 tokens. Got %+v", c)
+	}
+	if c := usage.Conversations[1]; c.ConversationId != first || c.TokenCount != 6 {
+		t.Fatalf("the first conversation should have 6 tokens. Got %+v", c)
+	}
+
+	if usage, err := s.TokenUsage("Mr_Nobody"); err != nil || usage.TokenCount != 0 || len(usage.Conversations) != 0 {
+		t.Fatalf("a user without conversations should have no tokens. Got %+v, %v", usage, err)
+	}
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"chat-history/structs"
+
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) TransferOwnership(conversationId, newUserId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// UpdateColumns so the conversation keeps its place in the list. The pin was the old owner's, it would
+		// count against the new owner's limit
+		res := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).UpdateColumns(map[string]any{"user_id": newUserId, "pinned_at": nil})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		// the share links move too, so they keep working and the new owner can revoke them
+		if err := tx.Model(&structs.ShareLink{}).Where("conversation_id = ?", conversationId).UpdateColumn("user_id", newUserId).Error; err != nil {
+			return err
+		}
+		// the grants stay, except the new owner's own, which ownership replaces
+		return tx.Where("conversation_id = ? AND user_id = ?", conversationId, newUserId).Delete(&structs.ConversationAccess{}).Error
+	})
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	link, err := s.CreateShareLink(USER, convoId.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.TransferOwnership(convoId.String(), "Miss_Take"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new owner has the conversation and its messages, the old one doesn't
+	if messages, err := s.GetConversation("Miss_Take", convoId.String()); err != nil || len(messages) != 1 {
+		t.Fatalf("the new owner should have the messages. Got %+v, %v", messages, err)
+	}
+	if messages, err := s.GetConversation(USER, convoId.String()); err != nil || len(messages) != 0 {
+		t.Fatalf("the old owner shouldn't have the messages anymore. Got %+v, %v", messages, err)
+	}
+	if err := s.AddTag(USER, convoId.String(), "work"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the old owner shouldn't be able to change the conversation. It returned: %v", err)
+	}
+
+	// the share link still works and belongs to the new owner
+	if _, _, err := s.GetSharedConversation(link.Token); err != nil {
+		t.Fatalf("the share link should keep working. It returned: %v", err)
+	}
+	if links, err := s.ListShareLinks("Miss_Take", convoId.String()); err != nil || len(links) != 1 || links[0].UserId != "Miss_Take" {
+		t.Fatalf("the new owner should have the share link. Got %+v, %v", links, err)
+	}
+}
+
+func TestTransferOwnership_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.TransferOwnership(uuid.NewString(), "Miss_Take"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	// trashed conversations aren't transferred
+	convoId := seedConversation(t, s, USER)
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TransferOwnership(convoId.String(), "Miss_Take"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a trashed conversation, got: %v", err)
+	}
+}
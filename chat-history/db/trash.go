@@ -0,0 +1,133 @@
+package db
+
+import (
+	"chat-history/structs"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func (s *sqliteStore) DeleteConversation(userId, conversationId string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Conversation has a DeletedAt field, so gorm only sets deleted_at
+	tx := s.db.Where("user_id = ? AND conversation_id = ?", userId, conversationId).Delete(&structs.Conversation{})
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) RestoreConversation(userId, conversationId string, retention time.Duration) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.db.Unscoped().Model(&structs.Conversation{}).
+		Where("user_id = ? AND conversation_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", userId, conversationId, s.now().Add(-retention)).
+		Update("deleted_at", nil)
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if tx.RowsAffected == 0 {
+		// not in the trash, or it's been there too long
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) PurgeTrash(retention time.Duration) (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-retention)
+	var purged int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Unscoped().Model(&structs.Conversation{}).
+			Select("conversation_id").
+			Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff)
+
+		expiredMessages := tx.Unscoped().Model(&structs.Message{}).
+			Select("message_id").
+			Where("conversation_id IN (?)", expired)
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.MessageRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.MessageEmbedding{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.MessageContext{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.MessageFeedback{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN (?)", expiredMessages).Delete(&structs.LLMExchange{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("conversation_id IN (?)", expired).Delete(&structs.Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&structs.ConversationTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&structs.ShareLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&structs.ConversationAccess{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&structs.ReadMarker{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&structs.ConversationEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id IN (?)", expired).Delete(&idempotencyKey{}).Error; err != nil {
+			return err
+		}
+		res := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).Delete(&structs.Conversation{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
+}
+
+// StartTrashSweeper purges expired trash every interval until the returned stop func is called
+func StartTrashSweeper(store ConversationStore, retention, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := store.PurgeTrash(retention)
+				if err != nil {
+					slog.Error("failed to purge trash", "err", err)
+				} else if n > 0 {
+					slog.Info("purged conversations from the trash", "count", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
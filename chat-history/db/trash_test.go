@@ -0,0 +1,183 @@
+package db
+
+import (
+	"chat-history/clock"
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func seedConversation(t testing.TB, s ConversationStore, userId string) uuid.UUID {
+	convoId := uuid.New()
+	msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole}
+	if _, err := s.CreateConversation(userId, "convo", msg); err != nil {
+		t.Fatal(err)
+	}
+	return convoId
+}
+
+func TestDeleteConversation(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	seedConversation(t, s, USER)
+
+	// another user can't delete it
+	if err := s.DeleteConversation("Miss_Take", convoId.String()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	convos, _, err := s.ListConversations(USER, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(convos); l != 1 || convos[0].ConversationId == convoId {
+		t.Fatalf("deleted conversation should be hidden. Found %d conversations", l)
+	}
+	if messages, _ := s.GetConversation(USER, convoId.String()); len(messages) != 0 {
+		t.Fatalf("deleted conversation's messages should be hidden. Found %d messages", len(messages))
+	}
+
+	// the rows are still there
+	var count int64
+	s.(*sqliteStore).db.Unscoped().Model(&structs.Conversation{}).Where("conversation_id = ?", convoId).Count(&count)
+	if count != 1 {
+		t.Fatal("deleted conversation should be soft deleted")
+	}
+}
+
+func TestRestoreConversation_BeforeExpiry(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+
+	// not in the trash yet
+	if err := s.RestoreConversation(USER, convoId.String(), time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestoreConversation("Miss_Take", convoId.String(), time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another user should not be able to restore. Got: %v", err)
+	}
+	if err := s.RestoreConversation(USER, convoId.String(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(messages); l != 1 {
+		t.Fatalf("restored conversation should have 1 message. It has: %d", l)
+	}
+}
+
+func TestPurgeTrash_AfterExpiry(t *testing.T) {
+	s := newTestStore(t)
+	expired := seedConversation(t, s, USER)
+	recent := seedConversation(t, s, USER)
+	kept := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, expired.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAttachment(USER, expired.String(), messages[0].MessageId.String(), testAttachment("graph.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEmbedding(structs.MessageEmbedding{MessageId: messages[0].MessageId, Model: "embed", Vector: []float32{1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkRead(USER, expired.String(), messages[0].MessageId.String()); err != nil {
+		t.Fatal(err)
+	}
+	reply := appendWithContext(t, s, expired)
+	if _, err := s.SetFeedback("Miss_Take", expired.String(), reply.String(), structs.ThumbsDown, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []uuid.UUID{expired, recent} {
+		if err := s.DeleteConversation(USER, c.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deleted 2 days ago
+	gdb := s.(*sqliteStore).db
+	gdb.Unscoped().Model(&structs.Conversation{}).Where("conversation_id = ?", expired).Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+	// past the window, it can't be restored
+	if err := s.RestoreConversation(USER, expired.String(), 24*time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	n, err := s.PurgeTrash(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("should purge 1 conversation. Purged: %d", n)
+	}
+
+	var attachments, embeddings, markers, contexts, feedback int64
+	gdb.Model(&structs.Attachment{}).Where("message_id = ?", messages[0].MessageId).Count(&attachments)
+	gdb.Model(&structs.MessageEmbedding{}).Where("message_id = ?", messages[0].MessageId).Count(&embeddings)
+	gdb.Model(&structs.ReadMarker{}).Where("conversation_id = ?", expired).Count(&markers)
+	gdb.Model(&structs.MessageContext{}).Where("message_id = ?", reply).Count(&contexts)
+	gdb.Model(&structs.MessageFeedback{}).Where("message_id = ?", reply).Count(&feedback)
+	if attachments != 0 || embeddings != 0 || markers != 0 || contexts != 0 || feedback != 0 {
+		t.Fatalf("the purged conversation's attachments, embeddings, read markers, graph contexts and feedback should be removed. %d, %d, %d, %d and %d are left", attachments, embeddings, markers, contexts, feedback)
+	}
+
+	for c, want := range map[uuid.UUID]int64{expired: 0, recent: 1, kept: 1} {
+		var convos, messages int64
+		gdb.Unscoped().Model(&structs.Conversation{}).Where("conversation_id = ?", c).Count(&convos)
+		gdb.Unscoped().Model(&structs.Message{}).Where("conversation_id = ?", c).Count(&messages)
+		if convos != want || messages != want {
+			t.Fatalf("conversation %s should have %d rows. Has %d conversations, %d messages", c, want, convos, messages)
+		}
+	}
+
+	// the recently deleted one can still be restored
+	if err := s.RestoreConversation(USER, recent.String(), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeTrash_Clock(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	tmp := t.TempDir()
+	s, err := NewSQLiteStore(fmt.Sprintf("%s/%s", tmp, DB_NAME), fmt.Sprintf("%s/test.log", tmp), Clock(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	convoId := seedConversation(t, s, USER)
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !messages[0].CreatedAt.Equal(fake.Now()) {
+		t.Fatalf("the message should be created at %v, it was at %v", fake.Now(), messages[0].CreatedAt)
+	}
+
+	if err := s.DeleteConversation(USER, convoId.String()); err != nil {
+		t.Fatal(err)
+	}
+	fake.Advance(23 * time.Hour)
+	if n, err := s.PurgeTrash(24 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("nothing should be purged before the retention is up, got %d, %v", n, err)
+	}
+	fake.Advance(2 * time.Hour)
+	if err := s.RestoreConversation(USER, convoId.String(), 24*time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("past the retention it shouldn't be restored, got: %v", err)
+	}
+	if n, err := s.PurgeTrash(24 * time.Hour); err != nil || n != 1 {
+		t.Fatalf("the conversation should be purged once the retention is up, got %d, %v", n, err)
+	}
+}
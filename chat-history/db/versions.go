@@ -0,0 +1,42 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrVersionConflict is returned by writes given an expected version when the conversation has
+// changed since the caller read it. The caller should read it again and retry
+var ErrVersionConflict = errors.New("the conversation was changed by another request")
+
+// AnyVersion is the expected version of writes that change the conversation whatever its version is
+const AnyVersion = -1
+
+// bumpVersion increments the version of the conversation and bumps it so it sorts as the most recently
+// updated. If expected isn't AnyVersion, the conversation is only changed if it's at that version,
+// otherwise ErrVersionConflict is returned. Call it first in the transaction of the write, so a
+// conflicting write changes nothing
+func bumpVersion(tx *gorm.DB, conversationId any, expected int) error {
+	q := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId)
+	if expected != AnyVersion {
+		q = q.Where("version = ?", expected)
+	}
+	res := q.Updates(map[string]any{"version": gorm.Expr("version + 1"), "updated_at": tx.NowFunc()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 || expected == AnyVersion {
+		return nil
+	}
+
+	var n int64
+	if err := tx.Model(&structs.Conversation{}).Where("conversation_id = ?", conversationId).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"chat-history/structs"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationVersions(t *testing.T) {
+	s := newTestStore(t)
+	convoId := seedConversation(t, s, USER)
+	messageId := firstMessage(t, s, USER, convoId)
+
+	convo, err := s.FindConversation(convoId.String())
+	if err != nil || convo.Version != 0 {
+		t.Fatalf("a new conversation should be at version 0. Got %+v, %v", convo, err)
+	}
+
+	// writes at the current version go through and increment it
+	reply := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hi", Role: structs.SystemRole}
+	convo, err = s.AppendMessage(reply, 0)
+	if err != nil || convo.Version != 1 {
+		t.Fatalf("the append should bump the version to 1. Got %+v, %v", convo, err)
+	}
+	if _, err := s.EditMessage(USER, convoId.String(), messageId, "edited", 1); err != nil {
+		t.Fatal(err)
+	}
+	// so do unconditional ones
+	reply.Feedback = structs.ThumbsUp
+	if convo, err = s.AppendMessage(reply, AnyVersion); err != nil || convo.Version != 3 {
+		t.Fatalf("the feedback update should bump the version to 3. Got %+v, %v", convo, err)
+	}
+
+	// writes at an earlier version conflict and change nothing
+	stale := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "too late", Role: structs.UserRole}
+	if _, err := s.AppendMessage(stale, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got: %v", err)
+	}
+	if _, err := s.EditMessage(USER, convoId.String(), messageId, "overwritten", 2); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got: %v", err)
+	}
+	messages, err := s.GetConversation(USER, convoId.String())
+	if err != nil || len(messages) != 2 || messages[0].Content != "edited" {
+		t.Fatalf("the conflicting writes shouldn't have changed the messages. Got %+v, %v", messages, err)
+	}
+	if revisions, err := s.ListRevisions(USER, convoId.String(), messageId); err != nil || len(revisions) != 1 {
+		t.Fatalf("the conflicting edit shouldn't add a revision. Got %+v, %v", revisions, err)
+	}
+	if convo, err := s.FindConversation(convoId.String()); err != nil || convo.Version != 3 {
+		t.Fatalf("the conflicting writes shouldn't change the version. Got %+v, %v", convo, err)
+	}
+
+	// a missing conversation isn't a conflict
+	missing := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Hi"}
+	if _, err := s.AppendMessage(missing, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
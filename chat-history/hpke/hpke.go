@@ -0,0 +1,164 @@
+// Package hpke encrypts messages to a recipient's public key with HPKE (RFC 9180) in base mode, with
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-256-GCM. It only needs the standard library,
+// and what it seals can be opened by any HPKE implementation with the same suite
+package hpke
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Suite names the algorithms, for anyone opening what's sealed
+const Suite = "DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-256-GCM"
+
+// the ids of the suite's algorithms, from RFC 9180 section 7
+const (
+	kemId  = 0x0020
+	kdfId  = 0x0001
+	aeadId = 0x0002
+
+	// lengths of the shared secret, the key and the nonce
+	nSecret = 32
+	nKey    = 32
+	nNonce  = 12
+
+	modeBase = 0x00
+)
+
+// ErrOpen is returned when a sealed message can't be opened with the private key
+var ErrOpen = errors.New("the message can't be opened, the key is wrong or it was changed")
+
+var (
+	kemSuite = binary.BigEndian.AppendUint16([]byte("KEM"), kemId)
+	suite    = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16([]byte("HPKE"), kemId), kdfId), aeadId)
+)
+
+// GenerateKey returns a new X25519 private key and its public key
+func GenerateKey() (privateKey, publicKey []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Bytes(), key.PublicKey().Bytes(), nil
+}
+
+// Seal encrypts plaintext to the recipient's 32 byte X25519 public key. enc is the encapsulated key, which the
+// recipient needs with the ciphertext to open it. info and aad are authenticated and must be the same to open it
+func Seal(recipient, info, aad, plaintext []byte) (enc, ciphertext []byte, err error) {
+	skE, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return seal(skE, recipient, info, aad, plaintext)
+}
+
+// seal is Seal with the ephemeral key skE
+func seal(skE *ecdh.PrivateKey, recipient, info, aad, plaintext []byte) (enc, ciphertext []byte, err error) {
+	pkR, err := ecdh.X25519().NewPublicKey(recipient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = skE.PublicKey().Bytes()
+	aead, nonce, err := keySchedule(sharedSecret(dh, enc, pkR.Bytes()), info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// Open decrypts what Seal encrypted to the public key of privateKey, or returns ErrOpen
+func Open(privateKey, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	skR, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	aead, nonce, err := keySchedule(sharedSecret(dh, enc, skR.PublicKey().Bytes()), info)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
+
+// sharedSecret is ExtractAndExpand of DHKEM, section 4.1
+func sharedSecret(dh, enc, pkR []byte) []byte {
+	kemContext := append(append([]byte{}, enc...), pkR...)
+	prk := labeledExtract(kemSuite, nil, "eae_prk", dh)
+	return labeledExpand(kemSuite, prk, "shared_secret", kemContext, nSecret)
+}
+
+// keySchedule derives the AEAD and the nonce of the first and only message in base mode, section 5.1
+func keySchedule(shared, info []byte) (cipher.AEAD, []byte, error) {
+	pskIdHash := labeledExtract(suite, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(suite, nil, "info_hash", info)
+	context := append(append([]byte{modeBase}, pskIdHash...), infoHash...)
+	secret := labeledExtract(suite, shared, "secret", nil)
+
+	key := labeledExpand(suite, secret, "key", context, nKey)
+	nonce := labeledExpand(suite, secret, "base_nonce", context, nNonce)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
+
+func labeledExtract(suiteId, salt []byte, label string, ikm []byte) []byte {
+	labeled := append(append(append([]byte("HPKE-v1"), suiteId...), label...), ikm...)
+	return extract(salt, labeled)
+}
+
+func labeledExpand(suiteId, prk []byte, label string, info []byte, length int) []byte {
+	labeled := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled = append(append(append(append(labeled, "HPKE-v1"...), suiteId...), label...), info...)
+	return expand(prk, labeled, length)
+}
+
+// extract and expand are HKDF (RFC 5869) with SHA-256
+func extract(salt, ikm []byte) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+func expand(prk, info []byte, length int) []byte {
+	var out, block []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,113 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSealOpen(t *testing.T) {
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, aad, plaintext := []byte("export"), []byte("convo"), []byte("the conversation")
+	enc, ciphertext, err := Seal(pub, info, aad, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Fatal("the ciphertext shouldn't have the plaintext in it")
+	}
+	opened, err := Open(priv, enc, info, aad, ciphertext)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("the recipient should open it. Got %q, %v", opened, err)
+	}
+
+	// a new ephemeral key every time
+	if again, _, _ := Seal(pub, info, aad, plaintext); bytes.Equal(again, enc) {
+		t.Fatal("every message should have its own encapsulated key")
+	}
+
+	other, _, _ := GenerateKey()
+	tampered := bytes.Clone(ciphertext)
+	tampered[0] ^= 1
+	for name, open := range map[string]func() ([]byte, error){
+		"another key":  func() ([]byte, error) { return Open(other, enc, info, aad, ciphertext) },
+		"another info": func() ([]byte, error) { return Open(priv, enc, []byte("other"), aad, ciphertext) },
+		"another aad":  func() ([]byte, error) { return Open(priv, enc, info, []byte("other"), ciphertext) },
+		"a change":     func() ([]byte, error) { return Open(priv, enc, info, aad, tampered) },
+	} {
+		if _, err := open(); !errors.Is(err, ErrOpen) {
+			t.Fatalf("opening it with %s should return ErrOpen, got %v", name, err)
+		}
+	}
+
+	if _, _, err := Seal([]byte("short"), info, aad, plaintext); err == nil {
+		t.Fatal("a public key that isn't 32 bytes should be rejected")
+	}
+}
+
+// the test vector of RFC 9180 A.1.1, DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM. It's the suite's
+// but with AES-128-GCM, so the key schedule is checked with that suite's id and key length
+func TestRFC9180Vector(t *testing.T) {
+	skE, err := ecdh.X25519().NewPrivateKey(unhex(t, "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skR, err := ecdh.X25519().NewPrivateKey(unhex(t, "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _, err := seal(skE, skR.PublicKey().Bytes(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431"); !bytes.Equal(enc, want) {
+		t.Fatalf("enc should be %x, got %x", want, enc)
+	}
+
+	dh, err := skE.ECDH(skR.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := sharedSecret(dh, enc, skR.PublicKey().Bytes())
+	if want := unhex(t, "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc"); !bytes.Equal(shared, want) {
+		t.Fatalf("the shared secret should be %x, got %x", want, shared)
+	}
+
+	aes128 := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16([]byte("HPKE"), kemId), kdfId), 0x0001)
+	info := unhex(t, "4f6465206f6e2061204772656369616e2055726e")
+	context := append(append([]byte{modeBase}, labeledExtract(aes128, nil, "psk_id_hash", nil)...), labeledExtract(aes128, nil, "info_hash", info)...)
+	secret := labeledExtract(aes128, shared, "secret", nil)
+	if key, want := labeledExpand(aes128, secret, "key", context, 16), unhex(t, "4531685d41d65f03dc48f6b8302c05b0"); !bytes.Equal(key, want) {
+		t.Fatalf("the key should be %x, got %x", want, key)
+	}
+	if nonce, want := labeledExpand(aes128, secret, "base_nonce", context, nNonce), unhex(t, "56d890e5accaaf011cff4b7d"); !bytes.Equal(nonce, want) {
+		t.Fatalf("the nonce should be %x, got %x", want, nonce)
+	}
+}
+
+// RFC 5869 A.1
+func TestHKDF(t *testing.T) {
+	prk := extract(unhex(t, "000102030405060708090a0b0c"), bytes.Repeat([]byte{0x0b}, 22))
+	if want := unhex(t, "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5"); !bytes.Equal(prk, want) {
+		t.Fatalf("the PRK should be %x, got %x", want, prk)
+	}
+	okm := expand(prk, unhex(t, "f0f1f2f3f4f5f6f7f8f9"), 42)
+	if want := unhex(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"); !bytes.Equal(okm, want) {
+		t.Fatalf("the OKM should be %x, got %x", want, okm)
+	}
+}
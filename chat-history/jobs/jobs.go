@@ -0,0 +1,134 @@
+// Package jobs runs work that doesn't have to finish before the request that started it does, i.e., asking the
+// LLM for a conversation's title, on a fixed number of workers
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// jobs waiting for a worker. Once it's full new ones are dropped, so a slow LLM can't hold up requests
+	queueSize = 1000
+	// times a job that fails is run again. The wait doubles every time
+	maxRetries = 3
+)
+
+// Job is one piece of work. Name and Attrs are what it's logged as if it still fails after the retries
+type Job struct {
+	Name  string
+	Attrs []any
+	Run   func(ctx context.Context) error
+}
+
+// Pool runs the jobs it's given on its workers, each job on one worker until it succeeds or runs out of retries.
+// A nil Pool drops them, so callers don't have to check whether there's anything to run them
+type Pool struct {
+	// how long to wait before the first retry
+	retryBase time.Duration
+	// cancels the jobs that are still running when Close gives up on them
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	queue   chan Job
+	workers sync.WaitGroup
+}
+
+// New starts a pool with n workers, at least one
+func New(n int) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		retryBase: time.Second,
+		ctx:       ctx,
+		cancel:    cancel,
+		queue:     make(chan Job, queueSize),
+	}
+	for range max(n, 1) {
+		p.workers.Add(1)
+		go p.run()
+	}
+	return p
+}
+
+// Submit queues the job and returns right away. It's false if the job was dropped because the queue is
+// full or the pool is closed
+func (p *Pool) Submit(job Job) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queue <- job:
+		return true
+	default:
+		slog.Warn("the job queue is full, dropping the job", append([]any{"job", job.Name}, job.Attrs...)...)
+		return false
+	}
+}
+
+// Close stops taking jobs and waits until the ones already queued are done, or ctx is done, which cancels them
+func (p *Pool) Close(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		pending := len(p.queue)
+		p.cancel()
+		return fmt.Errorf("%d jobs weren't run: %w", pending, ctx.Err())
+	}
+}
+
+func (p *Pool) run() {
+	defer p.workers.Done()
+	for job := range p.queue {
+		if p.ctx.Err() != nil {
+			// Close gave up, the rest are counted in its error
+			return
+		}
+		if err := p.do(job); err != nil {
+			slog.Error("job failed", append([]any{"job", job.Name, "err", err}, job.Attrs...)...)
+		}
+	}
+}
+
+// do runs job, retrying with backoff while it fails
+func (p *Pool) do(job Job) error {
+	wait := p.retryBase
+	for attempt := 0; ; attempt++ {
+		err := job.Run(p.ctx)
+		if err == nil || attempt == maxRetries || p.ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-p.ctx.Done():
+			return err
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,163 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPool(n int) *Pool {
+	p := New(n)
+	p.retryBase = time.Millisecond
+	return p
+}
+
+func closePool(t *testing.T, p *Pool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := newPool(4)
+	var mu sync.Mutex
+	done := map[int]bool{}
+	for i := range 20 {
+		ok := p.Submit(Job{Name: "test", Run: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			done[i] = true
+			return nil
+		}})
+		if !ok {
+			t.Fatalf("job %d should be queued", i)
+		}
+	}
+	closePool(t, p)
+
+	if len(done) != 20 {
+		t.Fatalf("every job should run. %d of 20 did", len(done))
+	}
+	if p.Submit(Job{Name: "test", Run: func(ctx context.Context) error { return nil }}) {
+		t.Fatal("a closed pool shouldn't take jobs")
+	}
+}
+
+func TestPool_Concurrency(t *testing.T) {
+	p := newPool(2)
+	var running, most atomic.Int32
+	for range 10 {
+		p.Submit(Job{Name: "test", Run: func(ctx context.Context) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		}})
+	}
+	closePool(t, p)
+
+	if most.Load() > 2 {
+		t.Fatalf("at most 2 jobs should run at once. %d did", most.Load())
+	}
+}
+
+func TestPool_Retries(t *testing.T) {
+	p := newPool(1)
+	var flaky, failing atomic.Int32
+	p.Submit(Job{Name: "flaky", Run: func(ctx context.Context) error {
+		if flaky.Add(1) < 3 {
+			return errors.New("llm is down")
+		}
+		return nil
+	}})
+	p.Submit(Job{Name: "failing", Run: func(ctx context.Context) error {
+		failing.Add(1)
+		return errors.New("llm is down")
+	}})
+	closePool(t, p)
+
+	if flaky.Load() != 3 {
+		t.Fatalf("a job should be retried until it succeeds. It ran %d times", flaky.Load())
+	}
+	if failing.Load() != maxRetries+1 {
+		t.Fatalf("a job that keeps failing should be retried %d times. It ran %d times", maxRetries, failing.Load())
+	}
+}
+
+func TestPool_CloseDrains(t *testing.T) {
+	p := newPool(1)
+	release := make(chan struct{})
+	var ran atomic.Int32
+	// the worker is busy while the rest wait in the queue
+	p.Submit(Job{Name: "slow", Run: func(ctx context.Context) error {
+		<-release
+		ran.Add(1)
+		return nil
+	}})
+	for range 5 {
+		p.Submit(Job{Name: "test", Run: func(ctx context.Context) error {
+			ran.Add(1)
+			return nil
+		}})
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- p.Close(context.Background())
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close should wait for the queued jobs")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if ran.Load() != 6 {
+		t.Fatalf("the queued jobs should run before Close returns. %d of 6 did", ran.Load())
+	}
+}
+
+func TestPool_CloseTimeout(t *testing.T) {
+	p := newPool(1)
+	cancelled := make(chan struct{})
+	p.Submit(Job{Name: "stuck", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}})
+	p.Submit(Job{Name: "queued", Run: func(ctx context.Context) error {
+		t.Error("jobs still queued when Close gives up shouldn't run")
+		return nil
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close should give up when ctx is done. Got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the running job should be cancelled")
+	}
+}
+
+func TestPool_Nil(t *testing.T) {
+	var p *Pool
+	if p.Submit(Job{Name: "test", Run: func(ctx context.Context) error { return nil }}) {
+		t.Fatal("a nil pool should drop jobs")
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package language tells which language a text is written in, without the LLM. Languages with a script of their
+// own are told by the script, the ones written in the Latin alphabet by their most common words. It only knows the
+// languages conversations are most likely to be in, anything else is undetermined
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Undetermined is the code of a text whose language Detect can't tell, i.e., because it's too short
+const Undetermined = ""
+
+const (
+	// texts with fewer letters than this are too short to tell
+	minLetters = 8
+	// only the start of longer texts is looked at, it's enough to tell
+	maxBytes = 4096
+	// a Latin text needs at least this many of a language's common words to be in it
+	minWords = 2
+)
+
+// scripts are the ones a language can be told by, with the language they're written in
+var scripts = []struct {
+	table *unicode.RangeTable
+	code  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// words are the most common words of the languages written in the Latin alphabet. Words that are common in
+// several of them count for each
+var words = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "was", "for", "with", "what", "how", "many", "this", "you", "have", "be", "not", "on", "which", "there", "do", "does", "can", "my"},
+	"es": {"el", "la", "los", "las", "de", "que", "y", "en", "un", "una", "es", "por", "con", "para", "cuántos", "cuántas", "cómo", "qué", "del", "se", "no", "son", "está", "hay", "mi", "como", "fue", "fueron", "su", "al", "más", "pero", "este", "esta"},
+	"fr": {"le", "la", "les", "des", "de", "et", "est", "un", "une", "que", "qui", "dans", "pour", "pas", "sur", "combien", "sont", "du", "au", "avec", "ce", "il", "nous", "vous", "mes", "y"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "mit", "wie", "viele", "sind", "von", "den", "dem", "auf", "für", "ich", "es", "sie", "auch", "wir", "gibt", "meine"},
+	"it": {"il", "lo", "la", "gli", "le", "di", "che", "e", "è", "un", "una", "per", "non", "sono", "con", "quanti", "quante", "come", "del", "della", "in", "ci", "mi", "ha"},
+	"pt": {"o", "a", "os", "as", "de", "que", "e", "do", "da", "em", "um", "uma", "não", "são", "com", "para", "quantos", "quantas", "como", "dos", "das", "é", "por", "está", "há"},
+	"nl": {"de", "het", "een", "en", "is", "van", "dat", "niet", "zijn", "met", "hoeveel", "hoe", "op", "voor", "ik", "je", "wat", "er", "te", "mijn"},
+}
+
+// languagesOf is words by word
+var languagesOf = func() map[string][]string {
+	out := map[string][]string{}
+	for code, list := range words {
+		for _, w := range list {
+			out[w] = append(out[w], code)
+		}
+	}
+	return out
+}()
+
+// Detect returns the ISO 639-1 code of the language text is written in, or Undetermined
+func Detect(text string) string {
+	if len(text) > maxBytes {
+		text = text[:maxBytes]
+	}
+	letters, latin := 0, 0
+	counts := map[string]int{}
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.code]++
+				break
+			}
+		}
+	}
+	if letters < minLetters {
+		return Undetermined
+	}
+	if code, n := most(counts); n > latin {
+		return refine(code, text, counts)
+	}
+	return latinLanguage(text)
+}
+
+// refine tells apart the languages that share a script
+func refine(code, text string, counts map[string]int) string {
+	switch code {
+	case "zh":
+		// Japanese is written with kanji too, with kana between them
+		if counts["ja"] > 0 {
+			return "ja"
+		}
+	case "ru":
+		if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+			return "uk"
+		}
+	case "ar":
+		if strings.ContainsAny(text, "پچژگ") {
+			return "fa"
+		}
+	}
+	return code
+}
+
+// latinLanguage is the language whose common words are the most frequent in text, Undetermined if there
+// are too few of them or two languages are as likely
+func latinLanguage(text string) string {
+	scores := map[string]int{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' }) {
+		for _, code := range languagesOf[w] {
+			scores[code]++
+		}
+	}
+	best, n := most(scores)
+	if n < minWords {
+		return Undetermined
+	}
+	for code, score := range scores {
+		if code != best && score == n {
+			return Undetermined
+		}
+	}
+	return best
+}
+
+// most is the key with the highest count, the first in alphabetical order if there's a tie
+func most(counts map[string]int) (string, int) {
+	best, n := "", 0
+	for code, count := range counts {
+		if count > n || (count == n && code < best) {
+			best, n = code, count
+		}
+	}
+	return best, n
+}
@@ -0,0 +1,34 @@
+package language
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"How many transactions were flagged as fraud last month?", "en"},
+		{"¿Cuántas transacciones fueron marcadas como fraude el mes pasado?", "es"},
+		{"¿Cuántas transacciones fueron marcadas como fraude?", "es"},
+		{"Combien de transactions ont été signalées comme frauduleuses le mois dernier ?", "fr"},
+		{"Wie viele Transaktionen wurden im letzten Monat als Betrug markiert? Das ist nicht gut", "de"},
+		{"Quante transazioni sono state segnalate come frode il mese scorso?", "it"},
+		{"Quantas transações foram marcadas como fraude no mês passado? Não sei", "pt"},
+		{"Hoeveel transacties zijn vorige maand als fraude gemarkeerd? Ik weet het niet", "nl"},
+		{"先月、不正として報告された取引はいくつありますか？", "ja"},
+		{"上个月有多少笔交易被标记为欺诈？", "zh"},
+		{"지난달 사기로 표시된 거래는 몇 건입니까?", "ko"},
+		{"Сколько транзакций было отмечено как мошенничество в прошлом месяце?", "ru"},
+		{"Скільки транзакцій було позначено як шахрайство минулого місяця?", "uk"},
+		{"كم عدد المعاملات التي تم الإبلاغ عنها كاحتيال الشهر الماضي؟", "ar"},
+		{"Πόσες συναλλαγές επισημάνθηκαν ως απάτη τον περασμένο μήνα;", "el"},
+		// too short, or nothing to tell it by
+		{"Hi", Undetermined},
+		{"12345 67890 !!!", Undetermined},
+		{"SELECT COUNT FROM Transaction", Undetermined},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
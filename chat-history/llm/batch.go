@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultBatchFlush is how long a batch waits for more texts unless NewBatchEmbedder is given a flush interval
+const defaultBatchFlush = 20 * time.Millisecond
+
+// NewBatchEmbedder returns an Embedder that coalesces the Embed calls made at about the same time into one call
+// to e, so concurrent requests use one of the provider's requests instead of one each. A batch is sent once it
+// has maxBatch texts or flush after its first call, whichever comes first. Calls with maxBatch texts or more
+// are sent on their own.
+// If the provider rejects a batch as a bad request, each call in it is retried on its own, so only the caller
+// whose texts it doesn't take gets the error. Other failures, e.g., rate limits, are the same for every caller.
+// Chats aren't batched: none of the providers have a batch call that answers while the request waits
+func NewBatchEmbedder(e Embedder, maxBatch int, flush time.Duration) Embedder {
+	if flush <= 0 {
+		flush = defaultBatchFlush
+	}
+	return &batchEmbedder{Embedder: e, maxBatch: maxBatch, flush: flush}
+}
+
+type batchEmbedder struct {
+	Embedder
+	maxBatch int
+	flush    time.Duration
+
+	mu      sync.Mutex
+	pending *embedBatch // the batch calls are added to, nil until the next call
+}
+
+// embedBatch is the calls that are sent to the provider together
+type embedBatch struct {
+	calls []*embedCall
+	size  int // the number of texts of all the calls
+	timer *time.Timer
+}
+
+type embedCall struct {
+	ctx   context.Context
+	texts []string
+	done  chan embedResult
+}
+
+type embedResult struct {
+	vectors [][]float32
+	err     error
+}
+
+func (b *batchEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) >= b.maxBatch {
+		return b.Embedder.Embed(ctx, texts)
+	}
+	call := &embedCall{ctx: ctx, texts: texts, done: make(chan embedResult, 1)}
+	b.add(call)
+	select {
+	case res := <-call.done:
+		return res.vectors, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// add puts the call in the pending batch, and sends the batch if it's full
+func (b *batchEmbedder) add(call *embedCall) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending != nil && b.pending.size+len(call.texts) > b.maxBatch {
+		b.send(b.pending)
+	}
+	if b.pending == nil {
+		batch := &embedBatch{}
+		batch.timer = time.AfterFunc(b.flush, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			// it may have been sent full already
+			if b.pending == batch {
+				b.send(batch)
+			}
+		})
+		b.pending = batch
+	}
+	b.pending.calls = append(b.pending.calls, call)
+	b.pending.size += len(call.texts)
+	if b.pending.size == b.maxBatch {
+		b.send(b.pending)
+	}
+}
+
+// send takes the batch out of pending and embeds it in the background. b.mu must be held
+func (b *batchEmbedder) send(batch *embedBatch) {
+	batch.timer.Stop()
+	b.pending = nil
+	go b.run(batch)
+}
+
+// run embeds the texts of the batch's calls in one call to the provider, and gives each call its vectors
+func (b *batchEmbedder) run(batch *embedBatch) {
+	// callers that gave up while the batch waited aren't sent
+	calls := make([]*embedCall, 0, len(batch.calls))
+	var texts []string
+	for _, c := range batch.calls {
+		if c.ctx.Err() == nil {
+			calls = append(calls, c)
+			texts = append(texts, c.texts...)
+		}
+	}
+	if len(calls) == 0 {
+		return
+	}
+	if len(calls) == 1 {
+		vectors, err := b.Embedder.Embed(calls[0].ctx, calls[0].texts)
+		calls[0].done <- embedResult{vectors, err}
+		return
+	}
+
+	// one caller giving up doesn't cancel the others' texts
+	vectors, err := b.Embedder.Embed(context.WithoutCancel(calls[0].ctx), texts)
+	if err == nil && len(vectors) != len(texts) {
+		err = fmt.Errorf("llm response has %d embeddings for %d inputs", len(vectors), len(texts))
+	}
+	var respErr *responseError
+	if errors.As(err, &respErr) && respErr.status == http.StatusBadRequest {
+		for _, c := range calls {
+			vectors, err := b.Embedder.Embed(c.ctx, c.texts)
+			c.done <- embedResult{vectors, err}
+		}
+		return
+	}
+	for _, c := range calls {
+		if err != nil {
+			c.done <- embedResult{err: err}
+			continue
+		}
+		c.done <- embedResult{vectors: vectors[:len(c.texts):len(c.texts)]}
+		vectors = vectors[len(c.texts):]
+	}
+}
@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchStub embeds each text as its length, and records the texts of each call. Texts that are "bad" make the
+// call a bad request, like a provider rejecting an input that's too long
+type batchStub struct {
+	mu    sync.Mutex
+	calls [][]string
+	err   error
+}
+
+func (s *batchStub) Model() string {
+	return "stub"
+}
+
+func (s *batchStub) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	s.mu.Lock()
+	s.calls = append(s.calls, texts)
+	s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if slices.Contains(texts, "bad") {
+		return nil, &responseError{status: http.StatusBadRequest, msg: "llm request failed: 400 Bad Request"}
+	}
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		vectors[i] = []float32{float32(len(t))}
+	}
+	return vectors, nil
+}
+
+// embedAll embeds each of the inputs in a call of its own, all at the same time
+func embedAll(e Embedder, inputs [][]string) ([][][]float32, []error) {
+	vectors := make([][][]float32, len(inputs))
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	for i, texts := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vectors[i], errs[i] = e.Embed(context.Background(), texts)
+		}()
+	}
+	wg.Wait()
+	return vectors, errs
+}
+
+func TestBatchEmbedder(t *testing.T) {
+	stub := &batchStub{}
+	e := NewBatchEmbedder(stub, 100, 50*time.Millisecond)
+	inputs := [][]string{{"a"}, {"bb", "ccc"}, {"dddd"}, {"eeeee", "ffffff"}}
+	vectors, errs := embedAll(e, inputs)
+
+	if len(stub.calls) != 1 || len(stub.calls[0]) != 6 {
+		t.Fatalf("the calls should be coalesced into one batch, got: %v", stub.calls)
+	}
+	for i, texts := range inputs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if len(vectors[i]) != len(texts) {
+			t.Fatalf("call %d should get a vector for each of its texts, got: %v", i, vectors[i])
+		}
+		for j, text := range texts {
+			if vectors[i][j][0] != float32(len(text)) {
+				t.Fatalf("call %d should get the vectors of its own texts, got: %v", i, vectors[i])
+			}
+		}
+	}
+	if e.Model() != "stub" {
+		t.Fatalf("the model should be the embedder's, got: %s", e.Model())
+	}
+}
+
+func TestBatchEmbedder_MaxBatch(t *testing.T) {
+	stub := &batchStub{}
+	e := NewBatchEmbedder(stub, 3, time.Minute)
+	// a minute is longer than the test waits, so batches only go out full
+	_, errs := embedAll(e, [][]string{{"a"}, {"b"}, {"c"}, {"d", "e"}, {"f"}})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	texts := 0
+	for _, call := range stub.calls {
+		if len(call) > 3 {
+			t.Fatalf("a batch should have at most 3 texts, got: %v", call)
+		}
+		texts += len(call)
+	}
+	if texts != 6 || len(stub.calls) < 2 {
+		t.Fatalf("every text should be sent once in full batches, got: %v", stub.calls)
+	}
+
+	// as many texts as a batch holds don't wait for others
+	stub.calls = nil
+	if _, err := e.Embed(context.Background(), []string{"x", "y", "z"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.calls) != 1 {
+		t.Fatalf("a full call should be sent on its own, got: %v", stub.calls)
+	}
+}
+
+func TestBatchEmbedder_Errors(t *testing.T) {
+	stub := &batchStub{}
+	e := NewBatchEmbedder(stub, 100, 50*time.Millisecond)
+
+	// only the caller with the input the provider rejects gets the error
+	vectors, errs := embedAll(e, [][]string{{"good"}, {"bad"}, {"fine", "ok"}})
+	if errs[0] != nil || errs[2] != nil || len(vectors[0]) != 1 || len(vectors[2]) != 2 {
+		t.Fatalf("the other callers should get their vectors, got: %v, %v", vectors, errs)
+	}
+	var respErr *responseError
+	if !errors.As(errs[1], &respErr) || respErr.status != http.StatusBadRequest {
+		t.Fatalf("the caller with the bad input should get the bad request, got: %v", errs[1])
+	}
+
+	// everyone gets the other failures
+	stub.err = fmt.Errorf("llm request failed: 429 Too Many Requests")
+	stub.calls = nil
+	_, errs = embedAll(e, [][]string{{"a"}, {"b"}})
+	for _, err := range errs {
+		if !errors.Is(err, stub.err) {
+			t.Fatalf("every caller should get the error, got: %v", errs)
+		}
+	}
+	if len(stub.calls) != 1 {
+		t.Fatalf("a failed batch should only be sent again when it's a bad request, got: %v", stub.calls)
+	}
+}
+
+func TestBatchEmbedder_Canceled(t *testing.T) {
+	stub := &batchStub{}
+	e := NewBatchEmbedder(stub, 100, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Embed(ctx, []string{"a"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("a canceled caller should get its context's error, got: %v", err)
+	}
+	// the batch it was in isn't sent for it
+	time.Sleep(100 * time.Millisecond)
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.calls) != 0 {
+		t.Fatalf("nothing should be sent for a canceled caller, got: %v", stub.calls)
+	}
+}
@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// bedrockClient uses the Bedrock Converse API, authenticated with a Bedrock API key
+type bedrockClient struct {
+	client *http.Client
+	// the converse URL of the model, which is in the path rather than the body
+	url    func(model string) string
+	model  string
+	apiKey string
+}
+
+type bedrockContent struct {
+	Text string `json:"text"`
+}
+
+type bedrockMessage struct {
+	Role    string           `json:"role"`
+	Content []bedrockContent `json:"content"`
+}
+
+type bedrockRequest struct {
+	Messages []bedrockMessage `json:"messages"`
+	System   []bedrockContent `json:"system,omitempty"`
+}
+
+type bedrockResponse struct {
+	Output struct {
+		Message bedrockMessage `json:"message"`
+	} `json:"output"`
+}
+
+func (c *bedrockClient) preconnect(ctx context.Context) error {
+	return preconnect(ctx, c.client, c.url(c.model))
+}
+
+func (c *bedrockClient) Chat(ctx context.Context, messages []Message) (string, error) {
+	// converse takes system prompts separately from the conversation
+	in := bedrockRequest{}
+	for _, m := range messages {
+		if m.Role == "system" {
+			in.System = append(in.System, bedrockContent{Text: m.Content})
+			continue
+		}
+		in.Messages = append(in.Messages, bedrockMessage{Role: m.Role, Content: []bedrockContent{{Text: m.Content}}})
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	recordRequest(ctx, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(modelOf(ctx, c.model)), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+	respBody, recorded := recordResponse(ctx, resp.Body)
+	defer recorded()
+
+	var out bedrockResponse
+	if err := json.NewDecoder(respBody).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.Output.Message.Content) == 0 {
+		return "", errors.New("llm response has no content")
+	}
+	var sb strings.Builder
+	for _, c := range out.Output.Message.Content {
+		sb.WriteString(c.Text)
+	}
+	return sb.String(), nil
+}
+
+// ChatStream sends the whole reply as a single chunk. ConverseStream replies use AWS's binary
+// event stream encoding, which isn't worth decoding by hand for the few deployments on bedrock
+func (c *bedrockClient) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	reply, err := c.Chat(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	if err := onChunk(reply); err != nil {
+		return "", err
+	}
+	return reply, nil
+}
@@ -0,0 +1,237 @@
+package llm
+
+import (
+	"chat-history/config"
+	"chat-history/metrics"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the LLM while the circuit breaker is open, see WithBreaker
+var ErrCircuitOpen = errors.New("the LLM is failing, calls to it are paused")
+
+// the states of the circuit breaker, the values of llm_circuit_state
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// the longest wait between retries, however many there have been
+const maxBackoff = 10 * time.Second
+
+// how a call went, as far as the breaker is concerned
+type outcome int
+
+const (
+	// the provider answered, even if it was to refuse the chat
+	answered outcome = iota
+	// the provider couldn't be reached or failed, see transient
+	failed
+	// the caller gave up, which says nothing about the provider
+	abandoned
+)
+
+// breaker is a Client that sends the chats of the one it wraps again when they fail with transient errors,
+// and stops sending them for a while after too many failed in a row
+type breaker struct {
+	next      Client
+	retries   int
+	retryBase time.Duration
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	state int
+	// chats that failed in a row
+	failures int
+	openedAt time.Time
+	// a chat is let through to find out if the provider is back, the others are rejected until it's done
+	probing bool
+}
+
+// WithBreaker wraps client so chats that fail with transient errors, i.e., the provider can't be reached or
+// answers 429 or a 5xx, are sent again up to cfg.MaxRetries times. Once cfg.BreakerThreshold chats in a row
+// failed that way, the next ones return ErrCircuitOpen without being sent for cfg.BreakerOpenSeconds. Then one
+// is let through: the breaker closes if it succeeds and opens again if it fails. The state is exported as
+// llm_circuit_state. client is returned as it is if neither retries nor the breaker are configured
+func WithBreaker(client Client, cfg config.LLMConfig) Client {
+	if cfg.MaxRetries <= 0 && cfg.BreakerThreshold <= 0 {
+		return client
+	}
+	metrics.LLMCircuitState.Set(circuitClosed)
+	return &breaker{
+		next:      client,
+		retries:   cfg.MaxRetries,
+		retryBase: time.Duration(cfg.RetryBaseMillis) * time.Millisecond,
+		threshold: cfg.BreakerThreshold,
+		openFor:   time.Duration(cfg.BreakerOpenSeconds) * time.Second,
+		now:       time.Now,
+		sleep:     sleep,
+	}
+}
+
+func (b *breaker) Chat(ctx context.Context, messages []Message) (string, error) {
+	var reply string
+	err := b.call(ctx, func() (bool, error) {
+		var err error
+		reply, err = b.next.Chat(ctx, messages)
+		return true, err
+	})
+	return reply, err
+}
+
+func (b *breaker) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	var reply string
+	err := b.call(ctx, func() (bool, error) {
+		// the caller has part of a reply once a chunk arrived, sending the chat again would repeat it
+		streamed := false
+		var err error
+		reply, err = b.next.ChatStream(ctx, messages, func(chunk string) error {
+			streamed = true
+			return onChunk(chunk)
+		})
+		return !streamed, err
+	})
+	return reply, err
+}
+
+func (b *breaker) preconnect(ctx context.Context) error {
+	return Warmup(ctx, b.next)
+}
+
+// call runs attempt, again after a backoff while it fails with a transient error and can be repeated,
+// unless the breaker is open
+func (b *breaker) call(ctx context.Context, attempt func() (repeatable bool, err error)) error {
+	probe, ok := b.allow()
+	if !ok {
+		metrics.LLMCircuitRejected.Inc()
+		return ErrCircuitOpen
+	}
+	for i := 0; ; i++ {
+		repeatable, err := attempt()
+		if err == nil {
+			b.done(probe, answered)
+			return nil
+		}
+		if ctx.Err() != nil {
+			b.done(probe, abandoned)
+			return err
+		}
+		failure, retry := transient(err)
+		if !failure {
+			b.done(probe, answered)
+			return err
+		}
+		if !retry || !repeatable || i >= b.retries {
+			b.done(probe, failed)
+			return err
+		}
+		metrics.LLMRetries.Inc()
+		if b.sleep(ctx, b.backoff(i)) != nil {
+			b.done(probe, abandoned)
+			return err
+		}
+	}
+}
+
+// allow reports whether a chat can be sent, and if it's the one that probes the provider while half-open
+func (b *breaker) allow() (probe, ok bool) {
+	if b.threshold <= 0 {
+		return false, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen {
+		if b.now().Sub(b.openedAt) < b.openFor {
+			return false, false
+		}
+		b.setState(circuitHalfOpen)
+	}
+	if b.state == circuitHalfOpen {
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+	return false, true
+}
+
+// done records how a chat that allow let through went
+func (b *breaker) done(probe bool, o outcome) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch o {
+	case answered:
+		b.failures = 0
+		b.setState(circuitClosed)
+	case failed:
+		b.failures++
+		// the chats that were sent before it opened don't keep it open longer
+		if b.state != circuitOpen && (probe || b.failures >= b.threshold) {
+			b.openedAt = b.now()
+			b.setState(circuitOpen)
+		}
+	}
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	metrics.LLMCircuitState.Set(float64(state))
+}
+
+// transient reports whether err is the provider failing in a way that may pass: it couldn't be reached, or
+// answered 429 or a 5xx. Those count toward opening the breaker. retry is whether sending the chat again may
+// help, which it doesn't after a timeout, the chat already took as long as it's allowed to
+func transient(err error) (failure, retry bool) {
+	var respErr *responseError
+	if errors.As(err, &respErr) {
+		failure = respErr.status == http.StatusTooManyRequests || respErr.status >= 500
+		return failure, failure
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true, !urlErr.Timeout()
+	}
+	return false, false
+}
+
+// backoff is how long to wait before retry attempt+1. It doubles from retryBase with every attempt, and is
+// jittered between half and all of that so clients don't retry in lockstep
+func (b *breaker) backoff(attempt int) time.Duration {
+	d := b.retryBase
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleep waits for d, or returns ctx's error if it's cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"chat-history/config"
+	"chat-history/metrics"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scriptedLLM fails its calls with errs, one after the other, and succeeds once they run out
+type scriptedLLM struct {
+	errs  []error
+	calls int
+	// chunks are sent to ChatStream's onChunk before it fails or succeeds
+	chunks []string
+	// during is called in the middle of every call
+	during func()
+}
+
+func (s *scriptedLLM) Chat(ctx context.Context, messages []Message) (string, error) {
+	return s.ChatStream(ctx, messages, func(string) error { return nil })
+}
+
+func (s *scriptedLLM) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	s.calls++
+	if s.during != nil {
+		s.during()
+	}
+	for _, chunk := range s.chunks {
+		onChunk(chunk)
+	}
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return "", err
+	}
+	return "OK", nil
+}
+
+func unavailable() error {
+	return &responseError{status: http.StatusServiceUnavailable, msg: "llm request failed: 503 Service Unavailable"}
+}
+
+// newTestBreaker wraps next with a clock the test moves, and waits between retries that are only recorded
+func newTestBreaker(next Client, cfg config.LLMConfig) (*breaker, *time.Time, *[]time.Duration) {
+	b := WithBreaker(next, cfg).(*breaker)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var waits []time.Duration
+	b.now = func() time.Time { return now }
+	b.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return b, &now, &waits
+}
+
+func chat(b Client) error {
+	_, err := b.Chat(context.Background(), []Message{{Role: "user", Content: "hello"}})
+	return err
+}
+
+func TestBreaker(t *testing.T) {
+	next := &scriptedLLM{errs: []error{unavailable(), unavailable(), unavailable()}}
+	b, now, _ := newTestBreaker(next, config.LLMConfig{BreakerThreshold: 3, BreakerOpenSeconds: 30})
+
+	// it opens after 3 failures in a row
+	for i := 0; i < 3; i++ {
+		if err := chat(b); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d should get the provider's error, got %v", i, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.LLMCircuitState); got != circuitOpen {
+		t.Fatalf("llm_circuit_state should be open (2), it's %v", got)
+	}
+
+	// and fails fast while open
+	rejected := testutil.ToFloat64(metrics.LLMCircuitRejected)
+	if err := chat(b); !errors.Is(err, ErrCircuitOpen) || next.calls != 3 {
+		t.Fatalf("an open breaker should fail without calling the LLM. Got %v after %d calls", err, next.calls)
+	}
+	if got := testutil.ToFloat64(metrics.LLMCircuitRejected); got != rejected+1 {
+		t.Fatalf("the rejected call should be counted, llm_circuit_rejected_total went from %v to %v", rejected, got)
+	}
+	*now = now.Add(29 * time.Second)
+	if err := chat(b); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("the breaker should stay open for breaker_open_seconds, got %v", err)
+	}
+
+	// then one call probes the provider, the others are still rejected meanwhile. It fails, and it opens again
+	*now = now.Add(time.Second)
+	next.errs = []error{unavailable()}
+	var during error
+	next.during = func() {
+		if got := testutil.ToFloat64(metrics.LLMCircuitState); got != circuitHalfOpen {
+			t.Errorf("llm_circuit_state should be half-open (1) during the probe, it's %v", got)
+		}
+		during = chat(b)
+	}
+	if err := chat(b); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("the probe should be sent, got %v", err)
+	}
+	if !errors.Is(during, ErrCircuitOpen) {
+		t.Fatalf("calls during the probe should be rejected, got %v", during)
+	}
+	next.during = nil
+	if err := chat(b); !errors.Is(err, ErrCircuitOpen) || next.calls != 4 {
+		t.Fatalf("a failed probe should open the breaker again. Got %v after %d calls", err, next.calls)
+	}
+
+	// a probe that succeeds closes it
+	*now = now.Add(30 * time.Second)
+	for i := 0; i < 3; i++ {
+		if err := chat(b); err != nil {
+			t.Fatalf("call %d should go through once the provider is back, got %v", i, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.LLMCircuitState); got != circuitClosed || next.calls != 7 {
+		t.Fatalf("llm_circuit_state should be closed (0), it's %v after %d calls", got, next.calls)
+	}
+}
+
+func TestBreaker_NotFailures(t *testing.T) {
+	next := &scriptedLLM{}
+	b, _, _ := newTestBreaker(next, config.LLMConfig{BreakerThreshold: 2, BreakerOpenSeconds: 30})
+
+	// the provider refusing a chat means it's up, and a caller that gives up says nothing about it
+	ctx, cancel := context.WithCancel(context.Background())
+	next.errs = []error{unavailable(), &responseError{status: http.StatusBadRequest, msg: "400"}, unavailable(), context.Canceled, unavailable()}
+	chat(b)
+	chat(b)
+	chat(b)
+	next.during = cancel
+	b.Chat(ctx, []Message{{Role: "user", Content: "hello"}})
+	next.during = nil
+	if err := chat(b); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("only failures in a row should open the breaker")
+	}
+	if err := chat(b); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("the second failure in a row should open the breaker, got %v", err)
+	}
+}
+
+func TestBreaker_Retries(t *testing.T) {
+	next := &scriptedLLM{errs: []error{unavailable(), &responseError{status: http.StatusTooManyRequests, msg: "429"}}}
+	b, _, waits := newTestBreaker(next, config.LLMConfig{MaxRetries: 2, RetryBaseMillis: 100})
+	retries := testutil.ToFloat64(metrics.LLMRetries)
+	if err := chat(b); err != nil || next.calls != 3 {
+		t.Fatalf("transient errors should be retried. Got %v after %d calls", err, next.calls)
+	}
+	if len(*waits) != 2 || (*waits)[0] < 50*time.Millisecond || (*waits)[0] > 100*time.Millisecond ||
+		(*waits)[1] < 100*time.Millisecond || (*waits)[1] > 200*time.Millisecond {
+		t.Fatalf("the waits should double from retry_base_millis, they were %v", *waits)
+	}
+	if got := testutil.ToFloat64(metrics.LLMRetries); got != retries+2 {
+		t.Fatalf("llm_retries_total should count the retries, it went from %v to %v", retries, got)
+	}
+
+	// up to max_retries
+	next.calls, next.errs = 0, []error{unavailable(), unavailable(), unavailable(), unavailable()}
+	if err := chat(b); err == nil || next.calls != 3 {
+		t.Fatalf("a chat should be sent at most max_retries+1 times. Got %v after %d calls", err, next.calls)
+	}
+
+	// and not what sending again can't fix
+	timeout := &url.Error{Op: "Post", URL: "http://llm", Err: context.DeadlineExceeded}
+	for name, err := range map[string]error{
+		"bad request": &responseError{status: http.StatusBadRequest, msg: "400"},
+		"timeout":     timeout,
+	} {
+		next.calls, next.errs = 0, []error{err}
+		if got := chat(b); !errors.Is(got, err) || next.calls != 1 {
+			t.Fatalf("%s shouldn't be retried. Got %v after %d calls", name, got, next.calls)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	next.calls, next.errs, next.during = 0, []error{unavailable()}, cancel
+	if _, err := b.Chat(ctx, []Message{{Role: "user", Content: "hello"}}); err == nil || next.calls != 1 {
+		t.Fatalf("a cancelled chat shouldn't be retried. Got %v after %d calls", err, next.calls)
+	}
+}
+
+func TestBreaker_StreamRetries(t *testing.T) {
+	next := &scriptedLLM{errs: []error{unavailable()}}
+	b, _, _ := newTestBreaker(next, config.LLMConfig{MaxRetries: 2})
+	var chunks []string
+	onChunk := func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}
+	if reply, err := b.ChatStream(context.Background(), nil, onChunk); err != nil || reply != "OK" || next.calls != 2 {
+		t.Fatalf("a stream that failed before sending anything should be retried. Got %q, %v after %d calls", reply, err, next.calls)
+	}
+
+	// once the caller got part of the reply, sending it again would repeat it
+	next.calls, next.errs, next.chunks = 0, []error{unavailable()}, []string{"There are"}
+	if _, err := b.ChatStream(context.Background(), nil, onChunk); err == nil || next.calls != 1 || len(chunks) != 1 {
+		t.Fatalf("a stream that failed partway shouldn't be retried. Got %v after %d calls, chunks %q", err, next.calls, chunks)
+	}
+}
+
+func TestWithBreaker_Disabled(t *testing.T) {
+	next := &scriptedLLM{}
+	if got := WithBreaker(next, config.LLMConfig{}); got != Client(next) {
+		t.Fatalf("without retries or a breaker the client should be returned as it is, got %T", got)
+	}
+}
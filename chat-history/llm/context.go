@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"chat-history/config"
+	"strings"
+)
+
+// context windows of the models, matched against the start of the model name. Bedrock model ids
+// start with the provider. The longest match wins, so gpt-4o isn't taken as gpt-4
+var contextWindows = map[string]int{
+	"gpt-4o":           128000,
+	"gpt-4-turbo":      128000,
+	"gpt-4":            8192,
+	"gpt-3.5-turbo":    16385,
+	"o1":               128000,
+	"claude-3":         200000,
+	"claude-2":         100000,
+	"llama3":           8192,
+	"llama3.1":         128000,
+	"meta.llama3":      8192,
+	"meta.llama3-1":    128000,
+	"mistral":          32768,
+	"mixtral":          32768,
+	"amazon.titan":     8192,
+	"anthropic.claude": 200000,
+}
+
+// used for models that aren't in contextWindows
+const defaultContextWindow = 8192
+
+// every message costs a few tokens on top of its content for the role and separators
+const messageOverhead = 4
+
+// ContextTokens returns how many tokens of messages can be sent to the model in cfg:
+// cfg.MaxContextTokens if it's set, or else the model's context window
+func ContextTokens(cfg config.LLMConfig) int {
+	if cfg.MaxContextTokens > 0 {
+		return cfg.MaxContextTokens
+	}
+	model := strings.ToLower(cfg.ModelName)
+	tokens, longest := defaultContextWindow, 0
+	for prefix, window := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			tokens, longest = window, len(prefix)
+		}
+	}
+	return tokens
+}
+
+// estimateTokens approximates the tokens the message takes up, see heuristicTokens
+func estimateTokens(m Message) int {
+	return heuristicTokens(m.Content) + messageOverhead
+}
+
+// TruncateHistory drops the oldest messages until the rest fit in ContextTokens(cfg).
+// System prompts and the latest user message are always kept, even if they don't fit on their own.
+// Messages are dropped from the start so what's kept is the most recent part of the conversation,
+// in the same order
+func TruncateHistory(cfg config.LLMConfig, messages []Message) []Message {
+	budget := ContextTokens(cfg)
+
+	keep := make([]bool, len(messages))
+	latestUser := -1
+	for i, m := range messages {
+		if m.Role == "user" {
+			latestUser = i
+		}
+	}
+	for i, m := range messages {
+		if m.Role == "system" || i == latestUser {
+			keep[i] = true
+			budget -= estimateTokens(m)
+		}
+	}
+
+	// fill what's left of the budget from the newest message back
+	for i := len(messages) - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		tokens := estimateTokens(messages[i])
+		if tokens > budget {
+			// everything before this is older, so it's dropped too
+			break
+		}
+		keep[i] = true
+		budget -= tokens
+	}
+
+	kept := make([]Message, 0, len(messages))
+	for i, m := range messages {
+		if keep[i] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"chat-history/config"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestContextTokens(t *testing.T) {
+	tests := []struct {
+		cfg  config.LLMConfig
+		want int
+	}{
+		{config.LLMConfig{ModelName: "gpt-4o-mini"}, 128000},
+		{config.LLMConfig{ModelName: "gpt-4"}, 8192},
+		{config.LLMConfig{ModelName: "anthropic.claude-3-haiku-20240307-v1:0"}, 200000},
+		{config.LLMConfig{ModelName: "Llama3.1:70b"}, 128000},
+		{config.LLMConfig{ModelName: "some-new-model"}, defaultContextWindow},
+		{config.LLMConfig{ModelName: "gpt-4o", MaxContextTokens: 1000}, 1000},
+	}
+	for _, tt := range tests {
+		if got := ContextTokens(tt.cfg); got != tt.want {
+			t.Errorf("ContextTokens(%+v) should be %d. It's: %d", tt.cfg, tt.want, got)
+		}
+	}
+}
+
+// words returns a message that's n tokens long, including the overhead
+func words(role, name string, n int) Message {
+	content := name + strings.Repeat(" ", 4*(n-messageOverhead)-len(name))
+	return Message{Role: role, Content: content}
+}
+
+func contents(messages []Message) []string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, strings.TrimSpace(m.Content))
+	}
+	return out
+}
+
+func TestTruncateHistory(t *testing.T) {
+	history := []Message{
+		words("system", "prompt", 20),
+		words("user", "q1", 20),
+		words("assistant", "a1", 20),
+		words("user", "q2", 20),
+		words("assistant", "a2", 20),
+		words("user", "q3", 20),
+	}
+
+	tests := []struct {
+		budget int
+		want   []string
+	}{
+		// everything fits
+		{120, []string{"prompt", "q1", "a1", "q2", "a2", "q3"}},
+		{1000, []string{"prompt", "q1", "a1", "q2", "a2", "q3"}},
+		// the oldest turns go first
+		{119, []string{"prompt", "a1", "q2", "a2", "q3"}},
+		{80, []string{"prompt", "q2", "a2", "q3"}},
+		{79, []string{"prompt", "a2", "q3"}},
+		// the system prompt and the latest question are kept even when they don't fit
+		{40, []string{"prompt", "q3"}},
+		{10, []string{"prompt", "q3"}},
+	}
+	for _, tt := range tests {
+		cfg := config.LLMConfig{ModelName: "gpt-4o", MaxContextTokens: tt.budget}
+		if got := contents(TruncateHistory(cfg, history)); !slices.Equal(got, tt.want) {
+			t.Errorf("with a budget of %d the history should be %v. It's: %v", tt.budget, tt.want, got)
+		}
+	}
+}
+
+func TestTruncateHistory_StopsAtFirstMessageThatDoesntFit(t *testing.T) {
+	history := []Message{
+		words("user", "short", 10),
+		words("assistant", "long", 50),
+		words("user", "latest", 10),
+	}
+	cfg := config.LLMConfig{MaxContextTokens: 30}
+	// short would fit on its own, but not without the reply to it
+	if got := contents(TruncateHistory(cfg, history)); !slices.Equal(got, []string{"latest"}) {
+		t.Fatalf("the history should only have the latest message. It's: %v", got)
+	}
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"bytes"
+	"chat-history/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Embedder turns texts into vectors, which are close together when the texts mean similar things
+type Embedder interface {
+	// Embed returns a vector for each of the texts, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model is the name of the model the vectors are from. Vectors from different models can't be compared
+	Model() string
+}
+
+// ErrNoEmbeddingModel is returned by NewEmbedder when llm_config has no embedding_model
+var ErrNoEmbeddingModel = errors.New("llm_config.embedding_model is not set")
+
+// NewEmbedder returns the Embedder for cfg.Provider with cfg.EmbeddingModel. cfg is expected to have passed cfg.Validate()
+func NewEmbedder(cfg config.LLMConfig) (Embedder, error) {
+	if cfg.Provider == "" {
+		return nil, ErrNotConfigured
+	}
+	if cfg.EmbeddingModel == "" {
+		return nil, ErrNoEmbeddingModel
+	}
+	e := &openAIEmbedder{client: &http.Client{Timeout: 60 * time.Second}, model: cfg.EmbeddingModel}
+	switch cfg.Provider {
+	case config.ProviderOpenAI:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		e.url = baseURL + "/embeddings"
+		e.header = http.Header{"Authorization": {"Bearer " + cfg.APIKey()}}
+	case config.ProviderAzure:
+		e.url = fmt.Sprintf("%s/openai/deployments/%s/embeddings?api-version=%s", cfg.BaseURL, cfg.EmbeddingModel, azureAPIVersion)
+		e.header = http.Header{"Api-Key": {cfg.APIKey()}}
+	case config.ProviderOllama:
+		e.url = cfg.BaseURL + "/v1/embeddings"
+		e.header = http.Header{}
+		if key := cfg.APIKey(); key != "" {
+			e.header.Set("Authorization", "Bearer "+key)
+		}
+	default:
+		return nil, fmt.Errorf("llm provider %q doesn't support embeddings", cfg.Provider)
+	}
+	return e, nil
+}
+
+// openAIEmbedder talks to the embeddings API, which OpenAI, Azure OpenAI and ollama all serve
+type openAIEmbedder struct {
+	client *http.Client
+	url    string
+	model  string
+	header http.Header
+}
+
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *openAIEmbedder) Model() string {
+	return e.model
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(openAIEmbeddingRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range e.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var out openAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("llm response has an embedding for input %d of %d", d.Index, len(texts))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("llm response has no embedding for input %d", i)
+		}
+	}
+	return vectors, nil
+}
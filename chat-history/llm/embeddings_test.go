@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"chat-history/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestNewEmbedder(t *testing.T) {
+	t.Setenv("TEST_LLM_KEY", "sk-test")
+	var in openAIEmbeddingRequest
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&in)
+		path = r.URL.Path
+		// out of order, the index says which input each is for
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		provider string
+		path     string
+	}{
+		{config.ProviderOpenAI, "/embeddings"},
+		{config.ProviderAzure, "/openai/deployments/embed-model/embeddings"},
+		{config.ProviderOllama, "/v1/embeddings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			e, err := NewEmbedder(config.LLMConfig{Provider: tt.provider, ModelName: "test-model", BaseURL: srv.URL, APIKeyEnv: "TEST_LLM_KEY", EmbeddingModel: "embed-model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			vectors, err := e.Embed(context.Background(), []string{"hello", "world"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vectors) != 2 || !slices.Equal(vectors[0], []float32{1, 0}) || !slices.Equal(vectors[1], []float32{0, 1}) {
+				t.Fatalf("the vectors should be in the order of the texts. They're: %v", vectors)
+			}
+			if path != tt.path || in.Model != "embed-model" || !slices.Equal(in.Input, []string{"hello", "world"}) {
+				t.Fatalf("the texts should be sent to the embedding model. Got %+v at %s", in, path)
+			}
+			if e.Model() != "embed-model" {
+				t.Fatalf("model should be embed-model. It's: %s", e.Model())
+			}
+		})
+	}
+}
+
+func TestNewEmbedder_NotConfigured(t *testing.T) {
+	if _, err := NewEmbedder(config.LLMConfig{}); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("error should be ErrNotConfigured. It's: %v", err)
+	}
+	if _, err := NewEmbedder(config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3"}); !errors.Is(err, ErrNoEmbeddingModel) {
+		t.Fatalf("error should be ErrNoEmbeddingModel. It's: %v", err)
+	}
+}
+
+func TestEmbed_MissingVector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer srv.Close()
+
+	e, _ := NewEmbedder(config.LLMConfig{Provider: config.ProviderOllama, BaseURL: srv.URL, EmbeddingModel: "embed-model"})
+	if _, err := e.Embed(context.Background(), []string{"hello", "world"}); err == nil {
+		t.Fatal("a response without a vector for each text should be an error")
+	}
+}
@@ -0,0 +1,41 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// Exchange is the request a chat sent to the provider and the response it got, the bodies as they were sent, see
+// RecordExchange
+type Exchange struct {
+	Request  string
+	Response string
+}
+
+type exchangeKey struct{}
+
+// RecordExchange returns a context that has the Chat and ChatStream calls made with it set exchange to what they
+// sent and got, the server-sent events of a streamed reply included. A chat that failed over to another provider or
+// was retried has the last request's
+func RecordExchange(ctx context.Context, exchange *Exchange) context.Context {
+	return context.WithValue(ctx, exchangeKey{}, exchange)
+}
+
+// recordRequest records the body of the request about to be sent with ctx, if it's recorded
+func recordRequest(ctx context.Context, body []byte) {
+	if e, ok := ctx.Value(exchangeKey{}).(*Exchange); ok {
+		*e = Exchange{Request: string(body)}
+	}
+}
+
+// recordResponse returns body, which is read into the exchange of ctx as it's read if it's recorded. done records
+// what was read
+func recordResponse(ctx context.Context, body io.Reader) (_ io.Reader, done func()) {
+	e, ok := ctx.Value(exchangeKey{}).(*Exchange)
+	if !ok {
+		return body, func() {}
+	}
+	var read bytes.Buffer
+	return io.TeeReader(body, &read), func() { e.Response = read.String() }
+}
@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"chat-history/config"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordExchange(t *testing.T) {
+	body := `{"choices":[{"message":{"role":"assistant","content":"hi"}}],"output":{"message":{"content":[{"text":"hi"}]}}}`
+	srv, _ := fakeLLM(t, body)
+	for _, provider := range []string{config.ProviderOllama, config.ProviderBedrock} {
+		t.Run(provider, func(t *testing.T) {
+			client, err := NewClient(config.LLMConfig{Provider: provider, ModelName: "llama3", BaseURL: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exchange Exchange
+			if _, err := client.Chat(RecordExchange(context.Background(), &exchange), []Message{{Role: "user", Content: "hello"}}); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(exchange.Request, `"hello"`) || exchange.Response != body {
+				t.Fatalf("the exchange should have the request and the response as they were sent. Got %+v", exchange)
+			}
+
+			// without it nothing is recorded
+			if _, err := client.Chat(context.Background(), []Message{{Role: "user", Content: "bye"}}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(exchange.Request, "bye") {
+				t.Fatalf("a chat without an exchange shouldn't be recorded. Got %+v", exchange)
+			}
+		})
+	}
+}
+
+func TestRecordExchange_Stream(t *testing.T) {
+	var events strings.Builder
+	for _, chunk := range []string{`{"choices":[{"delta":{"content":"hi "}}]}`, `{"choices":[{"delta":{"content":"there"}}]}`, `[DONE]`} {
+		fmt.Fprintf(&events, "data: %s\n\n", chunk)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(events.String()))
+	}))
+	defer srv.Close()
+
+	client, _ := NewClient(config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: srv.URL})
+	var exchange Exchange
+	if _, err := client.ChatStream(RecordExchange(context.Background(), &exchange), []Message{{Role: "user", Content: "hello"}}, func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// the response is the events, not the reply they add up to
+	if !strings.Contains(exchange.Request, `"stream":true`) || exchange.Response != events.String() {
+		t.Fatalf("the exchange should have the request and every event of the response. Got %+v", exchange)
+	}
+}
@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"chat-history/config"
+	"chat-history/metrics"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Served is the provider and model a chat was answered by, see RecordServed
+type Served struct {
+	Provider string
+	Model    string
+}
+
+type servedKey struct{}
+
+// RecordServed returns a context that has the Chat and ChatStream calls made with it set served to the provider
+// and model that answered them, or that a streamed reply came from when it failed part of the way. Only a client
+// with fallbacks sets it, so served is expected to start out as the primary provider
+func RecordServed(ctx context.Context, served *Served) context.Context {
+	return context.WithValue(ctx, servedKey{}, served)
+}
+
+// fallbackProvider is one of the providers of a fallback client
+type fallbackProvider struct {
+	name    string
+	model   string
+	timeout time.Duration
+	client  Client
+}
+
+// fallback is a Client that sends a chat to the next of its providers when one fails
+type fallback struct {
+	providers []fallbackProvider
+}
+
+// withFallbacks returns a Client that sends chats to the first of clients and, when one fails with a transient
+// error (see transient) or takes longer than its provider's timeout, to the next. clients are those of providers,
+// in the same order. The primary provider uses the model set with WithModel, the others their own model_name.
+// A streamed reply isn't sent to the next provider once a piece of it arrived. Every fallback is counted in
+// llm_fallbacks_total
+func withFallbacks(providers []config.LLMConfig, clients []Client) Client {
+	f := &fallback{}
+	for i, p := range providers {
+		f.providers = append(f.providers, fallbackProvider{name: p.Provider, model: p.ModelName, timeout: p.Timeout(), client: clients[i]})
+	}
+	return f
+}
+
+func (f *fallback) Chat(ctx context.Context, messages []Message) (string, error) {
+	var reply string
+	err := f.call(ctx, func(ctx context.Context, p fallbackProvider) (bool, error) {
+		var err error
+		reply, err = p.client.Chat(ctx, messages)
+		return true, err
+	})
+	return reply, err
+}
+
+func (f *fallback) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	var reply string
+	err := f.call(ctx, func(pctx context.Context, p fallbackProvider) (bool, error) {
+		// the caller has part of the provider's reply once a chunk arrived, the next one would start it over
+		streamed := false
+		var err error
+		reply, err = p.client.ChatStream(pctx, messages, func(chunk string) error {
+			if !streamed {
+				streamed = true
+				served(ctx, p, pctx)
+			}
+			return onChunk(chunk)
+		})
+		return !streamed, err
+	})
+	return reply, err
+}
+
+func (f *fallback) preconnect(ctx context.Context) error {
+	var errs []error
+	for _, p := range f.providers {
+		errs = append(errs, Warmup(ctx, p.client))
+	}
+	return errors.Join(errs...)
+}
+
+// call runs attempt with each provider in turn, until one succeeds or fails in a way the next wouldn't help with
+func (f *fallback) call(ctx context.Context, attempt func(ctx context.Context, p fallbackProvider) (repeatable bool, err error)) error {
+	var err error
+	for i, p := range f.providers {
+		pctx := ctx
+		if i > 0 {
+			metrics.LLMFallbacks.WithLabelValues(p.name).Inc()
+			slog.Warn("the LLM provider failed, trying the next one", "failed", f.providers[i-1].name, "next", p.name, "err", err)
+			// the conversation's model is one of the primary provider's
+			pctx = WithModel(ctx, p.model)
+		}
+		pctx, cancel := context.WithTimeout(pctx, p.timeout)
+		var repeatable bool
+		repeatable, err = attempt(pctx, p)
+		timedOut := pctx.Err() != nil
+		cancel()
+		if err == nil {
+			served(ctx, p, pctx)
+			return nil
+		}
+		// the caller gave up, which says nothing about the provider
+		if ctx.Err() != nil || !repeatable {
+			return err
+		}
+		if failure, _ := transient(err); !failure && !timedOut {
+			return err
+		}
+	}
+	return err
+}
+
+// served records that p answered the chat made with ctx, with the model of pctx, if it's recorded (see RecordServed)
+func served(ctx context.Context, p fallbackProvider, pctx context.Context) {
+	if s, ok := ctx.Value(servedKey{}).(*Served); ok {
+		*s = Served{Provider: p.name, Model: modelOf(pctx, p.model)}
+	}
+}
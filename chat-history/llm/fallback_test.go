@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"chat-history/config"
+	"chat-history/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// hangingLLM answers once its context is done
+type hangingLLM struct{}
+
+func (hangingLLM) Chat(ctx context.Context, messages []Message) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (h hangingLLM) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	return h.Chat(ctx, messages)
+}
+
+func testFallback(clients ...Client) *fallback {
+	f := &fallback{}
+	for i, client := range clients {
+		name := []string{"primary", "secondary", "tertiary"}[i]
+		f.providers = append(f.providers, fallbackProvider{name: name, model: name + "-model", timeout: time.Second, client: client})
+	}
+	return f
+}
+
+func TestFallback(t *testing.T) {
+	primary, secondary := &scriptedLLM{errs: []error{unavailable()}}, &scriptedLLM{}
+	f := testFallback(primary, secondary)
+	before := testutil.ToFloat64(metrics.LLMFallbacks.WithLabelValues("secondary"))
+
+	served := Served{Provider: "primary", Model: "gpt-4o"}
+	reply, err := f.Chat(RecordServed(WithModel(context.Background(), "gpt-4o"), &served), []Message{{Role: "user", Content: "hello"}})
+	if err != nil || reply != "OK" {
+		t.Fatalf("the secondary should answer when the primary fails. Got %q, %v", reply, err)
+	}
+	if primary.calls != 1 || secondary.calls != 1 {
+		t.Fatalf("each provider should be called once, they were called %d and %d times", primary.calls, secondary.calls)
+	}
+	if served != (Served{Provider: "secondary", Model: "secondary-model"}) {
+		t.Fatalf("the secondary should be recorded with its own model. Got %+v", served)
+	}
+	if got := testutil.ToFloat64(metrics.LLMFallbacks.WithLabelValues("secondary")) - before; got != 1 {
+		t.Fatalf("llm_fallbacks_total should count the fallback, it went up by %v", got)
+	}
+
+	// the primary answers with the conversation's model while it's up
+	if _, err := f.Chat(RecordServed(WithModel(context.Background(), "gpt-4o"), &served), nil); err != nil {
+		t.Fatal(err)
+	}
+	if primary.calls != 2 || secondary.calls != 1 || served != (Served{Provider: "primary", Model: "gpt-4o"}) {
+		t.Fatalf("the primary should answer. It was called %d times, recorded %+v", primary.calls, served)
+	}
+}
+
+func TestFallback_Timeout(t *testing.T) {
+	secondary := &scriptedLLM{}
+	f := testFallback(hangingLLM{}, secondary)
+	f.providers[0].timeout = 10 * time.Millisecond
+
+	served := Served{}
+	start := time.Now()
+	if reply, err := f.Chat(RecordServed(context.Background(), &served), nil); err != nil || reply != "OK" {
+		t.Fatalf("the secondary should answer when the primary times out. Got %q, %v", reply, err)
+	}
+	if time.Since(start) > 500*time.Millisecond || served.Provider != "secondary" {
+		t.Fatalf("the primary should get its own timeout. It took %v, recorded %+v", time.Since(start), served)
+	}
+
+	// a caller that gives up doesn't fail over
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.providers[0].timeout = time.Second
+	if _, err := f.Chat(ctx, nil); !errors.Is(err, context.Canceled) || secondary.calls != 1 {
+		t.Fatalf("a cancelled chat should return its error without the secondary. Got %v, %d calls", err, secondary.calls)
+	}
+}
+
+func TestFallback_NotRepeated(t *testing.T) {
+	rejected := &responseError{status: http.StatusBadRequest, msg: "llm request failed: 400 Bad Request"}
+	primary, secondary := &scriptedLLM{errs: []error{rejected}}, &scriptedLLM{}
+	f := testFallback(primary, secondary)
+	// the next provider would refuse the same chat
+	if _, err := f.Chat(context.Background(), nil); !errors.Is(err, rejected) || secondary.calls != 0 {
+		t.Fatalf("a refused chat should return the primary's error. Got %v, %d calls to the secondary", err, secondary.calls)
+	}
+
+	// nor a stream that's partly sent
+	primary = &scriptedLLM{errs: []error{unavailable()}, chunks: []string{"Accounts"}}
+	f = testFallback(primary, secondary)
+	served := Served{}
+	var chunks []string
+	_, err := f.ChatStream(RecordServed(context.Background(), &served), nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err == nil || secondary.calls != 0 || len(chunks) != 1 || served.Provider != "primary" {
+		t.Fatalf("a stream should fail once a chunk arrived, from the primary. Got %v, %v, %+v", err, chunks, served)
+	}
+
+	// one that failed before a chunk arrived is
+	primary = &scriptedLLM{errs: []error{unavailable()}}
+	secondary.chunks = []string{"Accounts", " 12"}
+	f = testFallback(primary, secondary)
+	chunks = nil
+	_, err = f.ChatStream(RecordServed(context.Background(), &served), nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || len(chunks) != 2 || served.Provider != "secondary" {
+		t.Fatalf("the secondary should stream the reply. Got %v, %v, %+v", err, chunks, served)
+	}
+}
+
+func TestNewClient_Fallbacks(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	var model string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in openAIRequest
+		json.NewDecoder(r.Body).Decode(&in)
+		model = in.Model
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi there"}}]}`))
+	}))
+	defer up.Close()
+
+	cfg := config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: down.URL,
+		Fallbacks: []config.LLMProvider{{Provider: config.ProviderOllama, ModelName: "mistral", BaseURL: up.URL, TimeoutSeconds: 5}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := Served{Provider: cfg.Provider, Model: cfg.ModelName}
+	reply, err := client.Chat(RecordServed(context.Background(), &served), []Message{{Role: "user", Content: "hello"}})
+	if err != nil || reply != "hi there" {
+		t.Fatalf("the fallback should answer. Got %q, %v", reply, err)
+	}
+	if model != "mistral" || served != (Served{Provider: config.ProviderOllama, Model: "mistral"}) {
+		t.Fatalf("the fallback's model should be asked and recorded. Asked %q, recorded %+v", model, served)
+	}
+}
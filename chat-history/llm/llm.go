@@ -1,6 +1,173 @@
 package llm
 
-// for automatically naming conversations
-func todo() {
-	// TODO: read LLM config to use the same one as graphrag
+import (
+	"chat-history/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Message is one turn of a chat sent to the LLM
+type Message struct {
+	Role    string `json:"role"` // system, user or assistant
+	Content string `json:"content"`
+}
+
+// Client sends chat completions to an LLM provider
+type Client interface {
+	// Chat sends the messages and returns the model's reply
+	Chat(ctx context.Context, messages []Message) (string, error)
+	// ChatStream sends the messages and calls onChunk with each piece of the reply as it arrives.
+	// It returns the whole reply. If onChunk returns an error the request is cancelled and the
+	// error returned; cancelling ctx does the same
+	ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error)
+}
+
+type modelKey struct{}
+
+// WithModel returns a context that makes the Chat and ChatStream calls made with it use the model
+// instead of llm_config.model_name. An empty model leaves it as it is
+func WithModel(ctx context.Context, model string) context.Context {
+	if model == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// modelOf is the model set on ctx with WithModel, or else fallback
+func modelOf(ctx context.Context, fallback string) string {
+	if model, ok := ctx.Value(modelKey{}).(string); ok {
+		return model
+	}
+	return fallback
+}
+
+// ErrNotConfigured is returned by NewClient when llm_config has no provider
+var ErrNotConfigured = errors.New("llm_config.provider is not set")
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// NewClient returns the Client for cfg.Provider, which fails over to cfg.Fallbacks if there are any (see
+// withFallbacks). cfg is expected to have passed cfg.Validate()
+func NewClient(cfg config.LLMConfig) (Client, error) {
+	providers := cfg.Providers()
+	if len(providers) == 1 {
+		return newClient(cfg)
+	}
+	clients := make([]Client, 0, len(providers))
+	for _, p := range providers {
+		client, err := newClient(p)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return withFallbacks(providers, clients), nil
+}
+
+// newClient returns the Client for cfg.Provider, ignoring its fallbacks
+func newClient(cfg config.LLMConfig) (Client, error) {
+	httpClient := &http.Client{Timeout: cfg.Timeout()}
+
+	switch cfg.Provider {
+	case "":
+		return nil, ErrNotConfigured
+	case config.ProviderOpenAI:
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		return &openAIClient{
+			client: httpClient,
+			url:    func(string) string { return baseURL + "/chat/completions" },
+			model:  cfg.ModelName,
+			header: http.Header{"Authorization": {"Bearer " + cfg.APIKey()}},
+		}, nil
+	case config.ProviderAzure:
+		// azure routes by deployment name instead of taking the model in the body
+		return &openAIClient{
+			client: httpClient,
+			url: func(model string) string {
+				return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", cfg.BaseURL, model, azureAPIVersion)
+			},
+			model:  cfg.ModelName,
+			header: http.Header{"Api-Key": {cfg.APIKey()}},
+		}, nil
+	case config.ProviderOllama:
+		// ollama serves an OpenAI compatible API
+		header := http.Header{}
+		if key := cfg.APIKey(); key != "" {
+			header.Set("Authorization", "Bearer "+key)
+		}
+		return &openAIClient{
+			client: httpClient,
+			url:    func(string) string { return cfg.BaseURL + "/v1/chat/completions" },
+			model:  cfg.ModelName,
+			header: header,
+		}, nil
+	case config.ProviderBedrock:
+		return &bedrockClient{
+			client: httpClient,
+			url:    func(model string) string { return fmt.Sprintf("%s/model/%s/converse", cfg.BaseURL, model) },
+			model:  cfg.ModelName,
+			apiKey: cfg.APIKey(),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown llm provider %q", cfg.Provider)
+}
+
+// CheckCredentials sends the LLM a chat that's as short as it gets, to find out if it takes the key and the model
+// the client was made with before a caller does
+func CheckCredentials(ctx context.Context, client Client) error {
+	_, err := client.Chat(ctx, []Message{{Role: "user", Content: "Reply with OK"}})
+	return err
+}
+
+// preconnecter is implemented by the clients that can connect to their endpoint without chatting
+type preconnecter interface {
+	preconnect(ctx context.Context) error
+}
+
+// Warmup connects to the LLM's endpoint and leaves the connection in the client's pool, so the first chat
+// doesn't wait for the TCP and TLS handshakes. Nothing is sent to the model, what the endpoint answers is ignored
+func Warmup(ctx context.Context, client Client) error {
+	if p, ok := client.(preconnecter); ok {
+		return p.preconnect(ctx)
+	}
+	return nil
+}
+
+// preconnect sends url a HEAD request and reads the response, so its connection can be reused
+func preconnect(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// responseError is a non-2xx response from the LLM
+type responseError struct {
+	status int
+	msg    string
+}
+
+func (e *responseError) Error() string {
+	return e.msg
+}
+
+// checkResponse returns an error with the body of a non-2xx response
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &responseError{status: resp.StatusCode, msg: fmt.Sprintf("llm request failed: %s: %s", resp.Status, body)}
 }
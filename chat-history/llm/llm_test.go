@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"chat-history/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeLLM records the last request and replies with body
+func fakeLLM(t *testing.T, body string) (*httptest.Server, *http.Request) {
+	t.Helper()
+	last := &http.Request{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = *r.Clone(context.Background())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, last
+}
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("TEST_LLM_KEY", "sk-test")
+	openAIReply := `{"choices":[{"message":{"role":"assistant","content":"hi there"}}]}`
+	bedrockReply := `{"output":{"message":{"role":"assistant","content":[{"text":"hi there"}]}}}`
+
+	tests := []struct {
+		provider   string
+		reply      string
+		path       string
+		authHeader string
+		authValue  string
+	}{
+		{config.ProviderOpenAI, openAIReply, "/chat/completions", "Authorization", "Bearer sk-test"},
+		{config.ProviderAzure, openAIReply, "/openai/deployments/test-model/chat/completions", "Api-Key", "sk-test"},
+		{config.ProviderOllama, openAIReply, "/v1/chat/completions", "Authorization", "Bearer sk-test"},
+		{config.ProviderBedrock, bedrockReply, "/model/test-model/converse", "Authorization", "Bearer sk-test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, last := fakeLLM(t, tt.reply)
+			cfg := config.LLMConfig{Provider: tt.provider, ModelName: "test-model", BaseURL: srv.URL, APIKeyEnv: "TEST_LLM_KEY"}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := client.Chat(context.Background(), []Message{{Role: "system", Content: "be nice"}, {Role: "user", Content: "hello"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply != "hi there" {
+				t.Fatalf("reply should be `hi there`. It's: %s", reply)
+			}
+			if last.URL.Path != tt.path {
+				t.Fatalf("request path should be %s. It's: %s", tt.path, last.URL.Path)
+			}
+			if v := last.Header.Get(tt.authHeader); v != tt.authValue {
+				t.Fatalf("%s header should be %s. It's: %s", tt.authHeader, tt.authValue, v)
+			}
+		})
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	t.Setenv("TEST_LLM_KEY", "sk-test")
+	srv, _ := fakeLLM(t, `{"choices":[{"message":{"role":"assistant","content":"OK"}}]}`)
+	client, _ := NewClient(config.LLMConfig{Provider: config.ProviderOpenAI, ModelName: "test-model", BaseURL: srv.URL, APIKeyEnv: "TEST_LLM_KEY"})
+	if err := CheckCredentials(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Incorrect API key provided"}}`))
+	}))
+	defer rejected.Close()
+	client, _ = NewClient(config.LLMConfig{Provider: config.ProviderOpenAI, ModelName: "test-model", BaseURL: rejected.URL, APIKeyEnv: "TEST_LLM_KEY"})
+	if err := CheckCredentials(context.Background(), client); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("a rejected key should fail the check with the status, got %v", err)
+	}
+}
+
+func TestNewClient_NotConfigured(t *testing.T) {
+	if _, err := NewClient(config.LLMConfig{}); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("error should be ErrNotConfigured. It's: %v", err)
+	}
+	if _, err := NewClient(config.LLMConfig{Provider: "skynet"}); err == nil {
+		t.Fatal("unknown provider should return an error")
+	}
+}
+
+func TestWithModel(t *testing.T) {
+	var model, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in openAIRequest
+		json.NewDecoder(r.Body).Decode(&in)
+		model, path = in.Model, r.URL.Path
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi"}}],"output":{"message":{"content":[{"text":"hi"}]}}}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		provider string
+		// where the model is in the request
+		model, path string
+	}{
+		{config.ProviderOllama, "mistral", "/v1/chat/completions"},
+		{config.ProviderAzure, "mistral", "/openai/deployments/mistral/chat/completions"},
+		{config.ProviderBedrock, "", "/model/mistral/converse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			client, err := NewClient(config.LLMConfig{Provider: tt.provider, ModelName: "llama3", BaseURL: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Chat(WithModel(context.Background(), "mistral"), []Message{{Role: "user", Content: "hello"}}); err != nil {
+				t.Fatal(err)
+			}
+			if model != tt.model || path != tt.path {
+				t.Fatalf("the request should be for the context's model. It was for %q at %s", model, path)
+			}
+			// without one it's model_name
+			if _, err := client.Chat(WithModel(context.Background(), ""), []Message{{Role: "user", Content: "hello"}}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(path, "mistral") || model == "mistral" {
+				t.Fatalf("the request should be for model_name. It was for %q at %s", model, path)
+			}
+		})
+	}
+}
+
+func TestChat_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "bad key"})
+	}))
+	defer srv.Close()
+
+	client, _ := NewClient(config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: srv.URL})
+	if _, err := client.Chat(context.Background(), []Message{{Role: "user", Content: "hello"}}); err == nil {
+		t.Fatal("a 401 from the provider should return an error")
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	var stream bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in openAIRequest
+		json.NewDecoder(r.Body).Decode(&in)
+		stream = in.Stream
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{`{"choices":[{"delta":{"role":"assistant"}}]}`, `{"choices":[{"delta":{"content":"hi "}}]}`,
+			`{"choices":[{"delta":{"content":"there"}}]}`, `[DONE]`} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer srv.Close()
+
+	client, _ := NewClient(config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: srv.URL})
+	var chunks []string
+	reply, err := client.ChatStream(context.Background(), []Message{{Role: "user", Content: "hello"}}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stream {
+		t.Fatal("request should ask for a streamed reply")
+	}
+	if reply != "hi there" || strings.Join(chunks, "|") != "hi |there" {
+		t.Fatalf("reply should be `hi there` in 2 chunks. Got %q in %q", reply, chunks)
+	}
+
+	// an error from onChunk stops the stream
+	stop := errors.New("client went away")
+	if _, err := client.ChatStream(context.Background(), nil, func(string) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("error should be the one returned by onChunk. It's: %v", err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	t.Setenv("TEST_LLM_KEY", "sk-test")
+	for _, provider := range []string{config.ProviderOpenAI, config.ProviderBedrock} {
+		var conns, heads, chats atomic.Int32
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				heads.Add(1)
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			chats.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi there"}}]}`))
+		}))
+		srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		srv.Start()
+		t.Cleanup(srv.Close)
+
+		client, err := NewClient(config.LLMConfig{Provider: provider, ModelName: "test-model", BaseURL: srv.URL, APIKeyEnv: "TEST_LLM_KEY"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// what the endpoint answers doesn't matter, only that it's connected to
+		if err := Warmup(context.Background(), client); err != nil {
+			t.Fatalf("%s: %v", provider, err)
+		}
+		if heads.Load() != 1 || chats.Load() != 0 {
+			t.Fatalf("%s: warming up shouldn't chat with the model. It sent %d HEADs and %d chats", provider, heads.Load(), chats.Load())
+		}
+		client.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}})
+		if n := conns.Load(); n != 1 {
+			t.Fatalf("%s: the first chat should use the warm-up's connection. There were %d", provider, n)
+		}
+	}
+
+	down, _ := NewClient(config.LLMConfig{Provider: config.ProviderOpenAI, ModelName: "test-model", BaseURL: "http://127.0.0.1:1", APIKeyEnv: "TEST_LLM_KEY"})
+	if err := Warmup(context.Background(), down); err == nil {
+		t.Fatal("warming up an LLM that can't be reached should fail")
+	}
+}
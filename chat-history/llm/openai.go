@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const azureAPIVersion = "2024-06-01"
+
+// openAIClient talks to the chat completions API. OpenAI, Azure OpenAI and ollama
+// all serve it, they only differ in the URL and how the key is sent
+type openAIClient struct {
+	client *http.Client
+	// the URL to send a request for the model to. Only azure has the model (its deployment) in it
+	url    func(model string) string
+	model  string
+	header http.Header
+}
+
+type openAIRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream,omitempty"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+}
+
+// streamed replies are sent as server-sent events with a delta of the reply in each
+type openAIChunk struct {
+	Choices []struct {
+		Delta Message `json:"delta"`
+	} `json:"choices"`
+}
+
+func (c *openAIClient) post(ctx context.Context, in openAIRequest) (*http.Response, error) {
+	in.Model = modelOf(ctx, c.model)
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	recordRequest(ctx, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(in.Model), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *openAIClient) preconnect(ctx context.Context) error {
+	return preconnect(ctx, c.client, c.url(c.model))
+}
+
+func (c *openAIClient) Chat(ctx context.Context, messages []Message) (string, error) {
+	resp, err := c.post(ctx, openAIRequest{Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, recorded := recordResponse(ctx, resp.Body)
+	defer recorded()
+
+	var out openAIResponse
+	if err := json.NewDecoder(body).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("llm response has no choices")
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+func (c *openAIClient) ChatStream(ctx context.Context, messages []Message, onChunk func(chunk string) error) (string, error) {
+	// cancelling stops the upstream request if onChunk fails, i.e., the client went away
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := c.post(ctx, openAIRequest{Messages: messages, Stream: true})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, recorded := recordResponse(ctx, resp.Body)
+	defer recorded()
+
+	var reply strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", err
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		content := chunk.Choices[0].Delta.Content
+		reply.WriteString(content)
+		if err := onChunk(content); err != nil {
+			return "", err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return reply.String(), nil
+}
@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"chat-history/config"
+	"context"
+	"errors"
+	"strings"
+)
+
+const summaryPrompt = "Summarize the conversation so far for someone coming back to it. " +
+	"Use a few sentences covering what was asked and what was answered. Reply with only the summary."
+
+// Summarize asks the LLM for a short recap of the conversation history.
+// The oldest messages are left out if the history doesn't fit in the model's context window
+func Summarize(ctx context.Context, client Client, cfg config.LLMConfig, history []Message) (string, error) {
+	messages := make([]Message, 0, len(history)+1)
+	messages = append(messages, Message{Role: "system", Content: summaryPrompt})
+	messages = append(messages, history...)
+
+	reply, err := client.Chat(ctx, TruncateHistory(cfg, messages))
+	if err != nil {
+		return "", err
+	}
+	summary := strings.TrimSpace(reply)
+	if summary == "" {
+		return "", errors.New("llm returned an empty summary")
+	}
+	return summary, nil
+}
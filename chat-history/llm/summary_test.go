@@ -0,0 +1,36 @@
+package llm
+
+import (
+	"chat-history/config"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	client := &stubClient{reply: "  The user asked about trips to Japan.\n"}
+	history := []Message{
+		{Role: "user", Content: "Plan a trip to Japan"},
+		{Role: "assistant", Content: "Sure, here's a plan"},
+	}
+	summary, err := Summarize(context.Background(), client, config.LLMConfig{}, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != "The user asked about trips to Japan." {
+		t.Fatalf("summary should be trimmed. It's: %q", summary)
+	}
+	if len(client.messages) != 3 || client.messages[0].Role != "system" || client.messages[2] != history[1] {
+		t.Fatalf("LLM should be sent the prompt and then the history: %+v", client.messages)
+	}
+}
+
+func TestSummarize_Errors(t *testing.T) {
+	history := []Message{{Role: "user", Content: "hi"}}
+	if _, err := Summarize(context.Background(), &stubClient{err: errors.New("timeout")}, config.LLMConfig{}, history); err == nil {
+		t.Fatal("LLM errors should be returned")
+	}
+	if _, err := Summarize(context.Background(), &stubClient{reply: " \n"}, config.LLMConfig{}, history); err == nil {
+		t.Fatal("an empty summary should be an error")
+	}
+}
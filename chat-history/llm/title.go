@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// fallback titles are the start of the message
+const maxTitleLength = 40
+
+const titlePrompt = "Summarize the user's message as a title for the conversation. " +
+	"Use at most 6 words. Reply with only the title, without quotes or punctuation at the end."
+
+// GenerateTitle asks the LLM to summarize the first message of a conversation into a short title.
+// If client is nil or the LLM call fails, the first 40 characters of the message are used instead
+func GenerateTitle(ctx context.Context, client Client, message string) string {
+	if client == nil {
+		return FallbackTitle(message)
+	}
+	title, err := Title(ctx, client, message)
+	if err != nil {
+		return FallbackTitle(message)
+	}
+	return title
+}
+
+// Title is GenerateTitle without the fallback, for callers that retry when the LLM fails
+func Title(ctx context.Context, client Client, message string) (string, error) {
+	reply, err := client.Chat(ctx, []Message{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: message},
+	})
+	if err != nil {
+		return "", err
+	}
+	title := strings.Trim(strings.TrimSpace(reply), `"'`)
+	if title == "" {
+		return "", errors.New("llm returned an empty title")
+	}
+	return title, nil
+}
+
+// FallbackTitle is the first 40 characters of the message, on a single line
+func FallbackTitle(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	if utf8.RuneCountInString(title) <= maxTitleLength {
+		return title
+	}
+	return strings.TrimSpace(string([]rune(title)[:maxTitleLength]))
+}
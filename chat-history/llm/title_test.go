@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stubClient replies with reply, or fails with err
+type stubClient struct {
+	reply    string
+	err      error
+	messages []Message
+}
+
+func (c *stubClient) Chat(ctx context.Context, messages []Message) (string, error) {
+	c.messages = messages
+	return c.reply, c.err
+}
+
+func (c *stubClient) ChatStream(ctx context.Context, messages []Message, onChunk func(string) error) (string, error) {
+	reply, err := c.Chat(ctx, messages)
+	if err == nil {
+		err = onChunk(reply)
+	}
+	return reply, err
+}
+
+func TestGenerateTitle(t *testing.T) {
+	client := &stubClient{reply: ` "Planning a trip to Japan" `}
+	msg := "I'm going to Tokyo and Kyoto in April for two weeks, what should I see?"
+
+	title := GenerateTitle(context.Background(), client, msg)
+	if title != "Planning a trip to Japan" {
+		t.Fatalf("title should be `Planning a trip to Japan`. It's: %q", title)
+	}
+	if len(client.messages) != 2 || client.messages[1].Role != "user" || client.messages[1].Content != msg {
+		t.Fatalf("the first message should be sent to the LLM. It sent: %v", client.messages)
+	}
+}
+
+func TestGenerateTitle_Fallback(t *testing.T) {
+	msg := "I'm going to Tokyo and Kyoto in April for two weeks, what should I see?"
+	want := "I'm going to Tokyo and Kyoto in April fo"
+
+	tests := []struct {
+		name   string
+		client Client
+	}{
+		{"no llm", nil},
+		{"llm error", &stubClient{err: errors.New("connection refused")}},
+		{"empty reply", &stubClient{reply: "  "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if title := GenerateTitle(context.Background(), tt.client, msg); title != want {
+				t.Fatalf("title should be %q. It's: %q", want, title)
+			}
+		})
+	}
+}
+
+func TestTitle_Errors(t *testing.T) {
+	for _, client := range []*stubClient{{err: errors.New("connection refused")}, {reply: "  "}} {
+		if title, err := Title(context.Background(), client, "Hello, world"); err == nil {
+			t.Fatalf("a failed LLM call or empty reply should be an error, for the caller to retry. Got %q", title)
+		}
+	}
+}
+
+func TestFallbackTitle(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Hello, world", "Hello, world"},
+		{"  multi\nline\tmessage  ", "multi line message"},
+		{"ñññññññññññññññññññññññññññññññññññññññññññ", "ññññññññññññññññññññññññññññññññññññññññ"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := FallbackTitle(tt.message); got != tt.want {
+			t.Fatalf("FallbackTitle(%q) should be %q. It's: %q", tt.message, tt.want, got)
+		}
+	}
+}
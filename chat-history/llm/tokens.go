@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"chat-history/config"
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenizer counts the tokens of text the way a family of models does
+type tokenizer struct {
+	name  string
+	count func(text string) int
+}
+
+// OpenAI's models split text into pieces with the same rules before encoding them, see splitPieces
+var bpe = tokenizer{name: "bpe", count: bpeTokens}
+
+// used for models without a tokenizer
+var heuristic = tokenizer{name: "heuristic", count: heuristicTokens}
+
+// tokenizers of the models, matched against the start of the model name like contextWindows
+var tokenizers = map[string]tokenizer{
+	"gpt-4o":                 bpe,
+	"gpt-4":                  bpe,
+	"gpt-3.5-turbo":          bpe,
+	"o1":                     bpe,
+	"text-embedding-3":       bpe,
+	"text-embedding-ada-002": bpe,
+}
+
+// how many texts' counts CountTokens remembers
+const tokenCacheSize = 4096
+
+var tokenCounts = newTokenCache(tokenCacheSize)
+
+// CountTokens returns how many tokens text is for cfg.ModelName, without calling the LLM.
+// OpenAI's models count with a local tokenizer, anything else at about 4 characters a token.
+// Counts are cached by a hash of the text, so counting the same text again is free
+func CountTokens(cfg config.LLMConfig, text string) int {
+	return tokenCounts.count(tokenizerFor(cfg.ModelName), text)
+}
+
+func tokenizerFor(modelName string) tokenizer {
+	model := strings.ToLower(modelName)
+	tok, longest := heuristic, 0
+	for prefix, t := range tokenizers {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			tok, longest = t, len(prefix)
+		}
+	}
+	return tok
+}
+
+// heuristicTokens approximates the tokens of text at about 4 characters a token.
+// It's close enough for English text without depending on each model's tokenizer
+func heuristicTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// bpeTokens counts the pieces splitPieces splits text into. Most pieces of English text are a token of their
+// own, longer ones are counted as a token for every 8 characters, and other scripts a token a character
+func bpeTokens(text string) int {
+	tokens := 0
+	for _, piece := range splitPieces(text) {
+		n := utf8.RuneCountInString(piece)
+		if n == len(piece) {
+			tokens += 1 + (n-1)/8
+		} else {
+			tokens += n
+		}
+	}
+	return tokens
+}
+
+// splitPieces splits text the way OpenAI's tokenizers do before encoding it, following the pattern of cl100k_base:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Go's regexp can't look ahead, so it's matched by hand
+func splitPieces(text string) []string {
+	runes := []rune(text)
+	var pieces []string
+	for i := 0; i < len(runes); {
+		n := matchPiece(runes[i:])
+		pieces = append(pieces, string(runes[i:i+n]))
+		i += n
+	}
+	return pieces
+}
+
+var contractions = []string{"s", "t", "re", "ve", "m", "ll", "d"}
+
+// matchPiece returns the length of the piece runes starts with, which is at least 1
+func matchPiece(runes []rune) int {
+	at := func(i int) rune {
+		if i < len(runes) {
+			return runes[i]
+		}
+		return 0
+	}
+	// how many of the runes from i on are in a row for which is true
+	span := func(i int, is func(rune) bool) int {
+		n := 0
+		for i+n < len(runes) && is(runes[i+n]) {
+			n++
+		}
+		return n
+	}
+	isNewline := func(r rune) bool { return r == '\r' || r == '\n' }
+	isOther := func(r rune) bool { return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r) }
+
+	r := runes[0]
+	if r == '\'' {
+		for _, c := range contractions {
+			if len(runes) > len(c) && strings.EqualFold(string(runes[1:1+len(c)]), c) {
+				return 1 + len(c)
+			}
+		}
+	}
+	if unicode.IsLetter(r) {
+		return span(0, unicode.IsLetter)
+	}
+	if !isNewline(r) && !unicode.IsNumber(r) && unicode.IsLetter(at(1)) {
+		return 1 + span(1, unicode.IsLetter)
+	}
+	if unicode.IsNumber(r) {
+		return min(span(0, unicode.IsNumber), 3)
+	}
+	start := 0
+	if r == ' ' && isOther(at(1)) {
+		start = 1
+	}
+	if isOther(at(start)) {
+		n := start + span(start, isOther)
+		return n + span(n, isNewline)
+	}
+
+	// whitespace: up to the last newline in it, or else all but the last space before anything else
+	ws := span(0, unicode.IsSpace)
+	for i := ws - 1; i >= 0; i-- {
+		if isNewline(runes[i]) {
+			return i + 1
+		}
+	}
+	if ws > 1 && ws < len(runes) {
+		return ws - 1
+	}
+	return ws
+}
+
+// tokenCache remembers the token counts of the texts counted most recently, by tokenizer and the text's hash
+type tokenCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[tokenKey]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+type tokenKey struct {
+	tokenizer string
+	hash      [sha256.Size]byte
+}
+
+type tokenEntry struct {
+	key    tokenKey
+	tokens int
+}
+
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{size: size, entries: map[tokenKey]*list.Element{}, order: list.New()}
+}
+
+// count returns the tokens of text counted by tok, counting them only if they aren't cached
+func (c *tokenCache) count(tok tokenizer, text string) int {
+	key := tokenKey{tokenizer: tok.name, hash: sha256.Sum256([]byte(text))}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		tokens := e.Value.(*tokenEntry).tokens
+		c.mu.Unlock()
+		return tokens
+	}
+	c.mu.Unlock()
+
+	// counted without the lock, so the same text counted twice at once is counted twice, and cached once
+	tokens := tok.count(text)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return tokens
+	}
+	c.entries[key] = c.order.PushFront(&tokenEntry{key: key, tokens: tokens})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenEntry).key)
+	}
+	return tokens
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"chat-history/config"
+	"slices"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		model, text string
+		want        int
+	}{
+		// what OpenAI's tokenizer counts
+		{"gpt-4o", "Hello, world!", 4},
+		{"gpt-4", "The quick brown fox jumps over the lazy dog.", 10},
+		{"gpt-3.5-turbo", "I'm here", 3},
+		{"GPT-4o-mini", "1234567", 3},
+		{"gpt-4o", "", 0},
+		// without a tokenizer, 4 characters a token
+		{"llama3", "Hello, world!", 4},
+		{"anthropic.claude-3-haiku-20240307-v1:0", "The quick brown fox", 5},
+		{"", "abcd", 1},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(config.LLMConfig{ModelName: tt.model}, tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q, %q) should be %d. It's: %d", tt.model, tt.text, tt.want, got)
+		}
+	}
+}
+
+func TestSplitPieces(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{"I'M here", []string{"I", "'M", " here"}},
+		{"pi is 3.14159", []string{"pi", " is", " ", "3", ".", "141", "59"}},
+		{"one  two", []string{"one", " ", " two"}},
+		{"done.\n\nNext", []string{"done", ".\n\n", "Next"}},
+		{"a \n b", []string{"a", " \n", " b"}},
+		{"trailing   ", []string{"trailing", "   "}},
+	}
+	for _, tt := range tests {
+		if got := splitPieces(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("splitPieces(%q) should be %q. It's: %q", tt.text, tt.want, got)
+		}
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	calls := 0
+	counting := tokenizer{name: "counting", count: func(text string) int {
+		calls++
+		return len(text)
+	}}
+	cache := newTokenCache(2)
+
+	if n := cache.count(counting, "first"); n != 5 || calls != 1 {
+		t.Fatalf("the first count should be counted, got %d after %d calls", n, calls)
+	}
+	if n := cache.count(counting, "first"); n != 5 || calls != 1 {
+		t.Fatalf("the same text should be cached, got %d after %d calls", n, calls)
+	}
+
+	// the least recently used is evicted
+	cache.count(counting, "second")
+	cache.count(counting, "first")
+	cache.count(counting, "third")
+	if calls != 3 {
+		t.Fatalf("only the new texts should be counted, after %d calls", calls)
+	}
+	cache.count(counting, "first")
+	if calls != 3 {
+		t.Fatalf("the recently used text should still be cached, after %d calls", calls)
+	}
+	cache.count(counting, "second")
+	if calls != 4 {
+		t.Fatalf("the least recently used text should have been evicted, after %d calls", calls)
+	}
+
+	// another tokenizer's counts are its own
+	if n := cache.count(tokenizer{name: "other", count: func(string) int { return 1 }}, "first"); n != 1 {
+		t.Fatalf("the other tokenizer should count the text itself, got %d", n)
+	}
+}
@@ -1,26 +1,229 @@
 package main
 
 import (
+	"chat-history/audit"
+	"chat-history/authn"
 	"chat-history/config"
 	"chat-history/db"
+	"chat-history/jobs"
+	"chat-history/llm"
+	"chat-history/metrics"
 	"chat-history/middleware"
 	"chat-history/routes"
+	"chat-history/server"
+	"chat-history/startup"
+	"chat-history/structs"
+	"chat-history/tigergraph"
+	"chat-history/webhook"
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	skipLLMCheck := flag.Bool("skip-llm-check", false, "don't check that the LLM provider takes the key in llm_config at startup")
+	probeTigerGraph := flag.Bool("probe-tigergraph", false, "exit if TigerGraph can't be reached at startup")
+	flag.Parse()
+
 	configPath := os.Getenv("CONFIG_FILES")
+	paths := map[string]string{
+		"tgconfig": configPath,
+	}
+	// chat settings can be kept in a separate file (i.e., a ConfigMap next to the credentials secret)
+	if chatConfigPath := os.Getenv("CHAT_CONFIG_FILE"); chatConfigPath != "" {
+		paths["chatconfig"] = chatConfigPath
+	}
+
+	// what the service needs is checked before it takes requests, and it exits with a code per thing
+	// that's missing, see startup
+	checks := startup.New(nil, os.Exit, 30*time.Second)
+	var cfg config.Config
+	var err error
+	checks.Check("config", startup.ExitConfig, func(context.Context) error {
+		cfg, err = config.LoadConfig(paths)
+		return err
+	})
+	// the level is reloaded on SIGHUP with the rest of the config, see live.OnReload.
+	// Anything still written with the log package goes through it at info
+	level, _ := cfg.ChatDbConfig.Level()
+	middleware.SetLogLevel(level)
+	// the service's own log goes to stderr unless chat_config.appLogOutput says otherwise
+	appLog, closeAppLog := io.Writer(os.Stderr), func() error { return nil }
+	if output := cfg.ChatDbConfig.AppLogOutput; output != "" {
+		checks.Check("app log", startup.ExitIO, func(context.Context) error {
+			appLog, closeAppLog, err = openLog(output, cfg.ChatDbConfig.AppLogPath)
+			return err
+		})
+	}
+	slog.SetDefault(middleware.NewLogger(appLog))
+	// trust the CA in db_config for every request to TigerGraph
+	checks.Check("tigergraph tls", startup.ExitConfig, func(context.Context) error {
+		return tigergraph.Configure(cfg.TgDbConfig)
+	})
+	// share links and bearer tokens are checked with the same leeway
+	clockSkew := time.Duration(cfg.ChatDbConfig.ClockSkewSeconds) * time.Second
+	dbOpts := []db.Option{
+		db.ClockSkew(clockSkew),
+		db.BusyTimeout(time.Duration(cfg.ChatDbConfig.BusyTimeoutMillis) * time.Millisecond),
+		db.MaxAttachments(cfg.ChatDbConfig.MaxAttachmentsPerMessage),
+		db.MaxPins(cfg.ChatDbConfig.MaxPinsPerConversation),
+		db.MaxPinnedConversations(cfg.ChatDbConfig.MaxPinnedConversations),
+		db.MaxConversations(cfg.ChatDbConfig.MaxConversationsPerUser),
+		db.ConnPool(cfg.ChatDbConfig.MaxOpenConns, cfg.ChatDbConfig.MaxIdleConns, time.Duration(cfg.ChatDbConfig.ConnMaxLifetimeSeconds)*time.Second),
+	}
+	roles := make([]structs.MessagengerRole, len(cfg.ChatDbConfig.MessageRoles))
+	for i, r := range cfg.ChatDbConfig.MessageRoles {
+		roles[i] = structs.MessagengerRole(r)
+	}
+	dbOpts = append(dbOpts, db.MessageRoles(roles...))
+	if cfg.ChatDbConfig.ReadOnly {
+		dbOpts = append(dbOpts, db.ReadOnly())
+	}
+	// already validated by LoadConfig
+	fileMode, _ := cfg.ChatDbConfig.FileMode()
+	dbOpts = append(dbOpts, db.FileMode(fileMode))
+	key, _ := cfg.ChatDbConfig.EncryptionKey()
+	if key != nil {
+		dbOpts = append(dbOpts, db.EncryptionKey(key))
+	} else if cfg.ChatDbConfig.EncryptionKeyEnv != "" {
+		slog.Warn("the encryption key is empty, messages are stored in plaintext", "env", cfg.ChatDbConfig.EncryptionKeyEnv)
+	}
+	shareKey, _ := cfg.ChatDbConfig.ShareKey()
+	if shareKey != nil {
+		dbOpts = append(dbOpts, db.ShareKey(shareKey))
+	} else {
+		slog.Warn("no share key is set, share links stop working when the service restarts")
+	}
+	if cfg.ChatDbConfig.ArchiveDbPath != "" {
+		dbOpts = append(dbOpts, db.ArchivePath(cfg.ChatDbConfig.ArchiveDbPath))
+	}
+	lengthPolicy := db.RejectLong
+	if cfg.ChatDbConfig.MessageLengthPolicy == config.LengthPolicyTruncate {
+		lengthPolicy = db.TruncateLong
+	}
+	dbOpts = append(dbOpts, db.MaxMessageChars(cfg.ChatDbConfig.MaxMessageChars, lengthPolicy))
+	moderator, _ := cfg.ChatDbConfig.Moderator()
+	dbOpts = append(dbOpts, db.Moderate(moderator))
+	// messages' tokens are counted as they're written, the way llm_config's model does
+	llmCfg := cfg.LLMConfig
+	dbOpts = append(dbOpts, db.TokenCounter(func(text string) int { return llm.CountTokens(llmCfg, text) }))
+	// conversations are named and messages embedded by the LLM after the requests that add them are done
+	pool := jobs.New(cfg.ChatDbConfig.AsyncWorkers)
+
+	// the changes the store makes are passed on to the listeners, the ones that use the store are added once it's open
+	var listeners []func(db.Event)
+	dbOpts = append(dbOpts, db.Notify(func(ev db.Event) {
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}))
+	// tell other services when conversations are created and messages added
+	var hooks *webhook.Emitter
+	if cfg.ChatDbConfig.WebhookURL != "" {
+		secret := cfg.ChatDbConfig.WebhookSecret()
+		if secret == nil {
+			slog.Warn("no webhook secret is set, webhook deliveries aren't signed")
+		}
+		hooks = webhook.New(cfg.ChatDbConfig.WebhookURL, secret)
+		listeners = append(listeners, hooks.Emit)
+	}
+	var store db.ConversationStore
+	checks.Check("database", startup.ExitDatabase, func(context.Context) error {
+		if cfg.ChatDbConfig.EphemeralMode {
+			slog.Warn("ephemeral mode is on, conversations are kept in memory and lost when the service stops")
+			store, err = db.OpenDB(db.MemoryPath, cfg.ChatDbConfig.DbLogPath, dbOpts...)
+		} else {
+			store, err = db.OpenDB(cfg.ChatDbConfig.DbPath, cfg.ChatDbConfig.DbLogPath, dbOpts...)
+		}
+		if err != nil {
+			return err
+		}
+		return store.Ping()
+	})
+	// TigerGraph may be down while the service starts, it's monitored once it runs. It's only
+	// required to be up when asked to
+	if *probeTigerGraph {
+		checks.Check("tigergraph", startup.ExitTigerGraph, func(ctx context.Context) error {
+			return tigergraph.Ping(ctx, cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort)
+		})
+	}
 
-	cfg, err := config.LoadConfig(map[string]string{
-		"tgconfig":   configPath,
+	// the LLM is optional, it's only used to name, summarize, reply to and embed conversations
+	var llmClient llm.Client
+	var embedder llm.Embedder
+	checks.Check("llm", startup.ExitLLM, func(context.Context) error {
+		if cfg.LLMConfig.EmbeddingModel != "" && cfg.ChatDbConfig.Enabled(config.FeatureSearch) {
+			if embedder, err = llm.NewEmbedder(cfg.LLMConfig); err != nil {
+				return err
+			}
+			if cfg.LLMConfig.BatchSize > 1 {
+				embedder = llm.NewBatchEmbedder(embedder, cfg.LLMConfig.BatchSize, time.Duration(cfg.LLMConfig.BatchFlushMillis)*time.Millisecond)
+			}
+		}
+		if cfg.LLMConfig.Enabled() {
+			if llmClient, err = llm.NewClient(cfg.LLMConfig); err == nil {
+				llmClient = llm.WithBreaker(llmClient, cfg.LLMConfig)
+			}
+		}
+		return err
 	})
-	if err != nil {
-		panic(err)
+	if llmClient != nil && *skipLLMCheck {
+		checks.Skip("llm credentials", "-skip-llm-check is set")
+	} else if llmClient != nil {
+		checks.Check("llm credentials", startup.ExitLLM, func(ctx context.Context) error {
+			return llm.CheckCredentials(ctx, llmClient)
+		})
+	}
+
+	// embed new messages as they're added, and every minute the ones that were missed or changed since
+	stopEmbedder := func() {}
+	if embedder != nil && !cfg.ChatDbConfig.ReadOnly {
+		listeners = append(listeners, routes.EmbedNewMessages(store, embedder, pool))
+		stopEmbedder = db.StartEmbedder(store, embedder, time.Minute)
+	}
+
+	// permanently remove conversations that have been in the trash too long
+	trashRetention := time.Duration(cfg.ChatDbConfig.TrashRetentionDays) * 24 * time.Hour
+	idempotencyWindow := time.Duration(cfg.ChatDbConfig.IdempotencyKeyHours) * time.Hour
+	stopSweeper := func() {}
+	if !cfg.ChatDbConfig.ReadOnly {
+		stopSweeper = db.StartTrashSweeper(store, trashRetention, time.Hour)
+	}
+
+	// remove the LLM requests and responses stored for debugging once they're past their retention
+	stopExchangeSweeper := func() {}
+	if cfg.LLMConfig.DebugStoreLLMIO && !cfg.ChatDbConfig.ReadOnly {
+		stopExchangeSweeper = db.StartLLMExchangeSweeper(store, cfg.LLMConfig.DebugRetention(), time.Hour)
+	}
+
+	// delete the conversations that were started and never had a message
+	stopEmptySweeper := func() {}
+	if ttl := cfg.ChatDbConfig.EmptyConversationTTL(); ttl > 0 && !cfg.ChatDbConfig.ReadOnly {
+		stopEmptySweeper = db.StartEmptyConversationSweeper(store, ttl, time.Hour)
+	}
+
+	// move conversations no one has touched in a while out of the primary DB
+	stopAutoArchive := func() {}
+	if days := cfg.ChatDbConfig.AutoArchiveAfterDays; days > 0 && !cfg.ChatDbConfig.ReadOnly {
+		stopAutoArchive = db.StartAutoArchive(store, time.Duration(days)*24*time.Hour, time.Hour)
+	}
+
+	// snapshot the DB while it's running
+	stopBackups := func() {}
+	if hours := cfg.ChatDbConfig.BackupIntervalHours; hours > 0 {
+		if b, ok := store.(db.Backuper); ok {
+			stopBackups = db.StartBackups(b, cfg.ChatDbConfig.BackupDir, time.Duration(hours)*time.Hour, cfg.ChatDbConfig.BackupRetention)
+		}
 	}
-	db.InitDB(cfg.ChatDbConfig.DbPath, cfg.ChatDbConfig.DbLogPath)
 
 	// make router
 	router := http.NewServeMux()
@@ -29,11 +232,186 @@ func main() {
 	router.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"status":"OK"}`))
 	})
+	// Prometheus metrics
+	router.Handle("GET /metrics", metrics.Handler())
+	// Readiness check. Without TigerGraph the chat history can still be read, the features that need it are degraded
+	healthTimeout := time.Duration(cfg.ChatDbConfig.HealthCheckTimeoutSeconds) * time.Second
+	tgFeatures := []string{"get_feedback", "admin_transfer", "graph_schema"}
+	if cfg.AuthConfig.Provider == config.AuthTigerGraph {
+		tgFeatures = append(tgFeatures, "writes")
+	}
+	router.HandleFunc("GET /healthz", routes.Healthz(store, cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort, healthTimeout, tgFeatures))
+	// the build and configuration that's running, for bug reports
+	router.HandleFunc("GET /version", routes.Version(cfg))
+
+	// roles, rate limits and the log level are reloaded on SIGHUP
+	live := config.NewLive(cfg, paths)
+	accessRoles := func() []string { return live.Get().ChatDbConfig.ConversationAccessRoles }
 
-	router.HandleFunc("GET /user/{userId}", routes.GetUserConversations)
-	router.HandleFunc("GET /conversation/{conversationId}", routes.GetConversation)
-	router.HandleFunc("POST /conversation", routes.UpdateConversation)
-	router.HandleFunc("GET /get_feedback", routes.GetFeedback(cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort, cfg.ChatDbConfig.ConversationAccessRoles))
+	// TigerGraph may be down, even while the server starts. It's checked in the background, and while
+	// it's down callers it recently signed in can still read their conversations, but writes return 503
+	tgMonitor := tigergraph.NewMonitor(func(ctx context.Context) error {
+		return tigergraph.Ping(ctx, cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort)
+	}, healthTimeout)
+	stopMonitor := tgMonitor.Start(10 * time.Second)
+
+	// callers sign in with their TigerGraph credentials, or with a token from an OIDC provider.
+	// Their TigerGraph roles are cached for a while, instead of running SHOW USER on every request
+	roleCache := authn.NewRoleCache(routes.TigerGraphRoles(cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort), time.Duration(cfg.AuthConfig.RoleCacheTTLSeconds)*time.Second)
+	var authenticator authn.Authenticator = authn.NewFallback(roleCache.Roles, tgMonitor, 15*time.Minute)
+	userExists := routes.TigerGraphUsers(cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort)
+	if cfg.AuthConfig.Provider == config.AuthOIDC {
+		checks.Check("oidc keys", startup.ExitConfig, func(context.Context) error {
+			authenticator, err = authn.NewJWT(cfg.AuthConfig, clockSkew)
+			return err
+		})
+		// callers don't have TigerGraph credentials to look users up with, use the service's
+		lookup := userExists
+		userExists = func(ctx context.Context, _, _, userId string) (bool, error) {
+			return lookup(ctx, cfg.TgDbConfig.Username, cfg.TgDbConfig.Password, userId)
+		}
+	}
+
+	// running locally, LoadConfig refuses it in production
+	if cfg.ChatDbConfig.DevMode {
+		slog.Warn("dev mode is on, requests aren't authenticated and every caller has the dev roles", "roles", cfg.ChatDbConfig.DevRoles)
+		authenticator = authn.NewDev(cfg.ChatDbConfig.DevRoles)
+	}
+
+	// every request is for a tenant, the store only reads and writes its rows
+	if cfg.ChatDbConfig.MultiTenant {
+		authenticator = authn.NewTenants(authenticator)
+	}
+
+	// conversation endpoints require one of the conversationAccessRoles
+	requireRoles := routes.RequireRolesFunc(accessRoles, authenticator)
+	// endpoints that change conversations are rate limited per user
+	// and rejected outright when the DB is read-only
+	rateLimiter := middleware.NewRateLimiter(cfg.ChatDbConfig.WriteRatePerSec, cfg.ChatDbConfig.WriteBurst)
+	limiter := middleware.RateLimit(rateLimiter)
+	live.OnReload(func(cfg config.Config) {
+		rateLimiter.SetLimits(cfg.ChatDbConfig.WriteRatePerSec, cfg.ChatDbConfig.WriteBurst)
+		level, _ := cfg.ChatDbConfig.Level()
+		middleware.SetLogLevel(level)
+	})
+	stopReload := live.ReloadOnSIGHUP()
+	limitWrites := func(h http.Handler) http.Handler {
+		if cfg.ChatDbConfig.ReadOnly {
+			return routes.RejectWrites()
+		}
+		return limiter(h)
+	}
+	// admin accesses, and the changes to who can read a conversation or whether it exists, are audited
+	var auditLog *audit.Log
+	checks.Check("audit log", startup.ExitIO, func(context.Context) error {
+		auditLog, err = audit.Open(cfg.ChatDbConfig.AuditLogPath)
+		return err
+	})
+	// the endpoints of disabled features answer 501
+	feature := routes.Features(cfg.ChatDbConfig)
+	router.Handle("GET /user/{userId}", requireRoles(routes.GetUserConversations(store)))
+	router.Handle("GET /conversation/{conversationId}", requireRoles(routes.GetConversation(store)))
+	router.Handle("POST /conversation", requireRoles(limitWrites(routes.UpdateConversation(store, llmClient, cfg.LLMConfig, idempotencyWindow, pool))))
+	router.Handle("DELETE /conversation/{conversationId}", requireRoles(limitWrites(routes.DeleteConversation(store, auditLog))))
+	router.Handle("POST /conversation/{conversationId}/restore", requireRoles(limitWrites(routes.RestoreConversation(store, trashRetention))))
+	router.Handle("POST /conversation/{conversationId}/archive", requireRoles(limitWrites(routes.ArchiveConversation(store))))
+	router.Handle("POST /conversation/{conversationId}/unarchive", requireRoles(limitWrites(routes.UnarchiveConversation(store))))
+	router.Handle("POST /conversation/{conversationId}/fork", requireRoles(limitWrites(routes.ForkConversation(store))))
+	router.Handle("POST /conversation/{conversationId}/clone", requireRoles(limitWrites(routes.CloneConversation(store))))
+	router.Handle("POST /conversation/{conversationId}/merge", requireRoles(limitWrites(routes.MergeConversations(store))))
+	router.Handle("POST /templates", requireRoles(limitWrites(routes.CreateTemplate(store))))
+	router.Handle("GET /templates", requireRoles(routes.ListTemplates(store)))
+	router.Handle("GET /templates/{templateId}", requireRoles(routes.GetTemplate(store)))
+	router.Handle("PUT /templates/{templateId}", requireRoles(limitWrites(routes.UpdateTemplate(store))))
+	router.Handle("DELETE /templates/{templateId}", requireRoles(limitWrites(routes.DeleteTemplate(store))))
+	router.Handle("POST /templates/{templateId}/conversations", requireRoles(limitWrites(routes.CreateConversationFromTemplate(store))))
+	router.Handle("PUT /conversation/{conversationId}/tags/{tag}", requireRoles(limitWrites(routes.AddTag(store))))
+	router.Handle("DELETE /conversation/{conversationId}/tags/{tag}", requireRoles(limitWrites(routes.RemoveTag(store))))
+	router.Handle("PUT /conversation/{conversationId}/messages/{messageId}", requireRoles(limitWrites(routes.EditMessage(store))))
+	router.Handle("GET /conversation/{conversationId}/messages/{messageId}/revisions", requireRoles(routes.ListRevisions(store)))
+	router.Handle("GET /conversation/{conversationId}/events", requireRoles(routes.ListEvents(store)))
+	router.Handle("PUT /conversation/{conversationId}/messages/{messageId}/pin", requireRoles(limitWrites(routes.PinMessage(store))))
+	router.Handle("DELETE /conversation/{conversationId}/messages/{messageId}/pin", requireRoles(limitWrites(routes.UnpinMessage(store))))
+	router.Handle("GET /conversation/{conversationId}/pinned", requireRoles(routes.ListPinned(store)))
+	router.Handle("PUT /conversation/{conversationId}/pin", requireRoles(limitWrites(routes.PinConversation(store))))
+	router.Handle("DELETE /conversation/{conversationId}/pin", requireRoles(limitWrites(routes.UnpinConversation(store))))
+	router.Handle("PUT /conversation/{conversationId}/messages/{messageId}/feedback", requireRoles(limitWrites(routes.SetMessageFeedback(store))))
+	router.Handle("GET /conversation/{conversationId}/messages/{messageId}/feedback", requireRoles(routes.GetMessageFeedback(store)))
+	router.Handle("PUT /conversation/{conversationId}/read", requireRoles(limitWrites(routes.MarkRead(store))))
+	router.Handle("PUT /conversation/{conversationId}/model", requireRoles(limitWrites(routes.SetConversationModel(store, cfg.LLMConfig))))
+	router.Handle("GET /conversation/{conversationId}/metadata", requireRoles(routes.GetMetadata(store)))
+	router.Handle("PATCH /conversation/{conversationId}/metadata", requireRoles(limitWrites(routes.SetMetadata(store))))
+	router.Handle("GET /conversation/{conversationId}/system_prompt", requireRoles(routes.GetSystemPrompt(store, cfg.LLMConfig)))
+	router.Handle("PUT /conversation/{conversationId}/system_prompt", requireRoles(limitWrites(routes.SetSystemPrompt(store, cfg.LLMConfig))))
+	router.Handle("POST /conversation/{conversationId}/messages/{messageId}/attachments", requireRoles(limitWrites(routes.AddAttachment(store))))
+	router.Handle("GET /conversation/{conversationId}/messages/{messageId}/attachments", requireRoles(routes.ListAttachments(store)))
+	router.Handle("GET /conversation/{conversationId}/attachments", requireRoles(routes.ListAttachments(store)))
+	router.Handle("POST /conversation/{conversationId}/shares", feature(config.FeatureShare, requireRoles(limitWrites(routes.CreateShareLink(store, auditLog)))))
+	router.Handle("GET /conversation/{conversationId}/shares", feature(config.FeatureShare, requireRoles(routes.ListShareLinks(store))))
+	router.Handle("DELETE /conversation/{conversationId}/shares/{shareId}", feature(config.FeatureShare, requireRoles(limitWrites(routes.RevokeShareLink(store, auditLog)))))
+	router.Handle("PUT /conversation/{conversationId}/access/{userId}", requireRoles(limitWrites(routes.GrantAccess(store, auditLog))))
+	router.Handle("DELETE /conversation/{conversationId}/access/{userId}", requireRoles(limitWrites(routes.RevokeAccess(store, auditLog))))
+	router.Handle("GET /conversation/{conversationId}/access", requireRoles(routes.ListAccess(store)))
+	// the token is the access, there are no role checks
+	router.Handle("GET /shared/{token}", feature(config.FeatureShare, routes.GetSharedConversation(store)))
+	router.Handle("GET /conversations/{conversationId}/export", feature(config.FeatureExport, requireRoles(routes.ExportConversation(store))))
+	router.Handle("GET /user/{userId}/export", feature(config.FeatureExport, requireRoles(routes.ExportUserData(store))))
+	router.Handle("GET /user/{userId}/stats", requireRoles(routes.GetUserStats(store)))
+	router.Handle("GET /user/{userId}/tokens", requireRoles(routes.GetTokenUsage(store)))
+	router.Handle("POST /conversations/{conversationId}/import", feature(config.FeatureImport, requireRoles(limitWrites(routes.ImportMessages(store)))))
+	router.Handle("POST /import/chatgpt", feature(config.FeatureImport, requireRoles(limitWrites(routes.ImportChatGPT(store)))))
+	router.Handle("POST /import/portable", feature(config.FeatureImport, requireRoles(limitWrites(routes.ImportPortable(store)))))
+	router.Handle("POST /conversations/{conversationId}/stream", feature(config.FeatureStream, requireRoles(limitWrites(routes.StreamConversation(store, llmClient, cfg.LLMConfig)))))
+	router.Handle("POST /conversations/{conversationId}/messages/{messageId}/resume", feature(config.FeatureStream, requireRoles(limitWrites(routes.ResumeStream(store, llmClient, cfg.LLMConfig)))))
+	// the sockets are closed on shutdown, http.Server.Shutdown doesn't wait for them
+	sockets := routes.NewSockets()
+	router.Handle("GET /conversations/{conversationId}/ws", feature(config.FeatureStream, requireRoles(limitWrites(routes.ConversationSocket(store, llmClient, cfg.LLMConfig, time.Duration(cfg.ChatDbConfig.HandlerTimeoutSeconds)*time.Second, time.Duration(cfg.ChatDbConfig.ReconnectDedupSeconds)*time.Second, cfg.ChatDbConfig.AllowedOrigins, rateLimiter, sockets)))))
+	router.Handle("POST /conversations/{conversationId}/continue", feature(config.FeatureStream, requireRoles(limitWrites(routes.ContinueFromContext(store, llmClient, cfg.LLMConfig)))))
+	router.Handle("POST /conversations/{conversationId}/regenerate", feature(config.FeatureStream, requireRoles(limitWrites(routes.RegenerateReply(store, llmClient, cfg.LLMConfig)))))
+	router.Handle("GET /conversations/{conversationId}/summary", feature(config.FeatureSummary, requireRoles(routes.SummarizeConversation(store, llmClient, cfg.LLMConfig))))
+	router.Handle("GET /conversations/{conversationId}/similar", feature(config.FeatureSearch, requireRoles(routes.RetrieveSimilar(store, embedder))))
+	router.Handle("GET /search", feature(config.FeatureSearch, requireRoles(routes.SearchMessages(store))))
+	searchWeights := db.SearchWeights{
+		Title:   cfg.ChatDbConfig.SearchTitleWeight,
+		Tags:    cfg.ChatDbConfig.SearchTagWeight,
+		Content: cfg.ChatDbConfig.SearchContentWeight,
+	}
+	router.Handle("GET /search/all", feature(config.FeatureSearch, requireRoles(routes.Search(store, searchWeights))))
+
+	// the types in the graph, fetched as the service user
+	var tgClient *tigergraph.TgClient
+	checks.Check("tigergraph client", startup.ExitConfig, func(context.Context) error {
+		tgClient, err = tigergraph.NewTgClient(cfg.TgDbConfig)
+		return err
+	})
+	schemas := tigergraph.NewSchemaCache(tgClient.Schema, time.Minute)
+	if cfg.ChatDbConfig.WarmupOnStart {
+		steps := map[string]func(context.Context) error{
+			"tigergraph token": func(context.Context) error { return tgClient.Warmup() },
+		}
+		if llmClient != nil {
+			steps["llm connection"] = func(ctx context.Context) error { return llm.Warmup(ctx, llmClient) }
+		}
+		checks.Warmup(steps)
+	}
+	router.Handle("GET /graph/schema", requireRoles(routes.GraphSchema(schemas.Get)))
+
+	// support staff can read every user's conversations and give them to other users, each access is audited
+	requireAdmin := routes.RequireAdmin(func() []string { return live.Get().ChatDbConfig.AdminRoles }, authenticator)
+	router.Handle("GET /admin/user/{userId}", requireAdmin(routes.AdminListConversations(store, auditLog)))
+	router.Handle("DELETE /admin/user/{userId}", requireAdmin(limitWrites(routes.AdminDeleteUserData(store, auditLog))))
+	router.Handle("GET /admin/conversations", requireAdmin(routes.AdminRecentConversations(store, auditLog)))
+	router.Handle("GET /admin/conversation/{conversationId}", requireAdmin(routes.AdminGetConversation(store, auditLog)))
+	router.Handle("GET /admin/feedback", requireAdmin(routes.AdminExportFeedback(store, auditLog)))
+	router.Handle("GET /admin/export", requireAdmin(routes.AdminExportEveryone(store, auditLog)))
+	router.Handle("POST /admin/import", requireAdmin(limitWrites(routes.AdminImportEveryone(store, auditLog))))
+	router.Handle("GET /admin/message/{messageId}/llm-io", requireAdmin(routes.AdminGetLLMExchange(store, auditLog)))
+	router.Handle("POST /admin/user/{userId}/roles/refresh", requireAdmin(routes.AdminRefreshRoles(roleCache, auditLog)))
+	router.Handle("POST /admin/maintenance", requireAdmin(limitWrites(routes.AdminRunMaintenance(store, auditLog))))
+	router.Handle("POST /admin/search/reindex", requireAdmin(limitWrites(routes.AdminReindexSearch(store, auditLog))))
+	router.Handle("GET /admin/search/reindex", requireAdmin(routes.AdminReindexProgress(store)))
+	router.Handle("POST /admin/conversation/{conversationId}/transfer", requireAdmin(limitWrites(routes.AdminTransferOwnership(store, auditLog, userExists))))
+	router.Handle("GET /get_feedback", requireRoles(routes.GetFeedback(store, cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort, accessRoles)))
 
 	// create server with middleware
 	dev := strings.ToLower(os.Getenv("DEV")) == "true"
@@ -44,12 +422,145 @@ func main() {
 		port = fmt.Sprintf(":%s", cfg.ChatDbConfig.Port)
 	}
 
-	handler := middleware.ChainMiddleware(router,
-		middleware.Logger(), // recoverer already included from RequestLogger by default
+	// the request logs go to the JSONL file and/or stdout (see chat_config.requestLogOutput), to the OTLP
+	// collector, or both
+	var requestSinks []middleware.RequestSink
+	var requestLog *middleware.RequestLog
+	requestOutput := cfg.ChatDbConfig.RequestLogOutput
+	if !cfg.ChatDbConfig.OtlpOnly && config.ToFile(requestOutput) {
+		checks.Check("request log", startup.ExitIO, func(context.Context) error {
+			requestLog, err = middleware.OpenRequestLog(cfg.ChatDbConfig.LogPath, middleware.LogRotation{
+				MaxSizeMB:  cfg.ChatDbConfig.MaxLogSizeMB,
+				MaxBackups: cfg.ChatDbConfig.MaxLogBackups,
+				MaxAgeDays: cfg.ChatDbConfig.MaxLogAgeDays,
+				Compress:   cfg.ChatDbConfig.CompressLogBackups,
+			})
+			return err
+		})
+		requestSinks = append(requestSinks, requestLog)
+	}
+	if !cfg.ChatDbConfig.OtlpOnly && config.ToStdout(requestOutput) {
+		requestSinks = append(requestSinks, middleware.NewStreamLog(os.Stdout))
+	}
+	// the concise HTTP logs go the same way, to logs.jsonl rather than logPath
+	var httpLog io.Writer
+	var closeHTTPLog func() error
+	checks.Check("http log", startup.ExitIO, func(context.Context) error {
+		httpLog, closeHTTPLog, err = openLog(requestOutput, "logs.jsonl")
+		return err
+	})
+	var otlp *middleware.OTLPExporter
+	if cfg.ChatDbConfig.OtlpEndpoint != "" {
+		otlp = middleware.NewOTLPExporter(cfg.ChatDbConfig.OtlpEndpoint, "chat-history")
+		requestSinks = append(requestSinks, otlp)
+	}
+
+	// validated by LoadConfig
+	proxies, _ := cfg.ChatDbConfig.Proxies()
+	jsonStyle := routes.JSONStyle{Indent: cfg.ChatDbConfig.JSONIndent, CamelCase: cfg.ChatDbConfig.JSONCase == config.JSONCamelCase}
+	var app http.Handler = routes.WithBasePath(cfg.ChatDbConfig.BasePath, routes.WithJSONStyle(jsonStyle, router))
+	if cfg.ChatDbConfig.MultiTenant {
+		// a route that doesn't resolve its caller's tenant fails rather than reading every tenant's rows
+		app = middleware.RequireTenant()(app)
+	}
+	handler := middleware.ChainMiddleware(app,
+		// innermost, so the 500s of panics and the 504s are logged and counted
+		middleware.Recover(slog.Default()),
+		middleware.Timeout(time.Duration(cfg.ChatDbConfig.HandlerTimeoutSeconds)*time.Second),
+		middleware.Metrics(),
+		middleware.RequestLogger(requestSinks...),
+		middleware.MaxBodyBytes(cfg.ChatDbConfig.MaxRequestBodyBytes),
+		middleware.Logger(httpLog), // its recoverer only sees http.ErrAbortHandler, Recover handles the other panics
+		// answers preflight requests before they reach the router, which has no OPTIONS routes
+		middleware.CORS(cfg.ChatDbConfig.AllowedOrigins, cfg.ChatDbConfig.AllowCredentials),
+		// before the loggers, so they have the client's IP rather than the load balancer's
+		middleware.ClientIP(proxies),
+		// outermost, so every log line has the request's id
+		middleware.RequestID(),
 		// middleware.Auth, // TODO: need auth server. --> go-chi/oauth can make server
 	)
 	s := http.Server{Addr: port, Handler: handler}
+	s.RegisterOnShutdown(sockets.Shutdown)
+
+	var ln net.Listener
+	checks.Check("listen", startup.ExitIO, func(context.Context) error {
+		ln, err = net.Listen("tcp", port)
+		return err
+	})
+
+	// on SIGTERM, stop taking requests and let the in-flight ones finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	slog.Info("server running", "port", port)
+	grace := time.Duration(cfg.ChatDbConfig.ShutdownTimeoutSeconds) * time.Second
+	if err := server.Serve(ctx, &s, ln, grace); err != nil {
+		slog.Error("server stopped", "err", err)
+	}
+	// the replies being streamed on sockets are saved before the store is closed. They're closed already unless
+	// the server stopped on its own
+	sockets.Shutdown()
+	socketsCtx, cancelSockets := context.WithTimeout(context.Background(), grace)
+	if err := sockets.Wait(socketsCtx); err != nil {
+		slog.Error("failed to close the WebSockets", "err", err)
+	}
+	cancelSockets()
+
+	// let the queued jobs finish, they write to the store
+	stopEmbedder()
+	poolCtx, cancelPool := context.WithTimeout(context.Background(), grace)
+	if err := pool.Close(poolCtx); err != nil {
+		slog.Error("failed to finish the remaining jobs", "err", err)
+	}
+	cancelPool()
+
+	// nothing is writing anymore, close everything that has to be flushed
+	stopReload()
+	stopMonitor()
+	stopSweeper()
+	stopExchangeSweeper()
+	stopEmptySweeper()
+	stopAutoArchive()
+	stopBackups()
+	if err := store.Close(); err != nil {
+		slog.Error("failed to close the DB", "err", err)
+	}
+	hooksCtx, cancelHooks := context.WithTimeout(context.Background(), grace)
+	if err := hooks.Close(hooksCtx); err != nil {
+		slog.Error("failed to deliver the remaining webhook events", "err", err)
+	}
+	cancelHooks()
+	otlpCtx, cancelOTLP := context.WithTimeout(context.Background(), grace)
+	if err := otlp.Close(otlpCtx); err != nil {
+		slog.Error("failed to export the remaining request logs", "err", err)
+	}
+	cancelOTLP()
+	if requestLog != nil {
+		if err := requestLog.Close(); err != nil {
+			slog.Error("failed to close the request log", "err", err)
+		}
+	}
+	if err := auditLog.Close(); err != nil {
+		slog.Error("failed to close the audit log", "err", err)
+	}
+	if err := closeHTTPLog(); err != nil {
+		slog.Error("failed to close the HTTP log", "err", err)
+	}
+	// last, the errors above are written to it
+	slog.SetDefault(middleware.NewLogger(os.Stderr))
+	if err := closeAppLog(); err != nil {
+		slog.Error("failed to close the service log", "err", err)
+	}
+}
 
-	fmt.Printf("Server running on port %s\n", port)
-	s.ListenAndServe()
+// openLog opens where a log with the output goes: stdout, the file at path, or both
+func openLog(output, path string) (io.Writer, func() error, error) {
+	var stdout io.Writer
+	if config.ToStdout(output) {
+		stdout = os.Stdout
+	}
+	if !config.ToFile(output) {
+		path = ""
+	}
+	return middleware.OpenLog(path, stdout)
 }
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric the service exports. It's separate from the prometheus
+// default registry so only our metrics (and the go/process ones) show up on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by route and status code.",
+	}, []string{"method", "path", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to handle HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time spent in SQLite queries, by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	Panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "panics_total",
+		Help: "Number of handler panics recovered.",
+	})
+
+	TigerGraphRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tigergraph_request_duration_seconds",
+		Help:    "Time for requests to TigerGraph, by endpoint and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path", "status"})
+
+	LLMCircuitState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "llm_circuit_state",
+		Help: "State of the circuit breaker around LLM calls: 0 closed, 1 half-open, 2 open.",
+	})
+
+	LLMCircuitRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "llm_circuit_rejected_total",
+		Help: "Number of LLM calls failed without sending them, while the circuit breaker was open.",
+	})
+
+	LLMRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "llm_retries_total",
+		Help: "Number of LLM calls sent again after a transient error.",
+	})
+
+	LLMFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_fallbacks_total",
+		Help: "Number of LLM calls sent to a fallback provider after the one before it failed, by provider.",
+	}, []string{"provider"})
+)
+
+func init() {
+	Registry.MustRegister(
+		HTTPRequests,
+		HTTPRequestDuration,
+		DBQueryDuration,
+		Panics,
+		TigerGraphRequestDuration,
+		LLMCircuitState,
+		LLMCircuitRejected,
+		LLMRetries,
+		LLMFallbacks,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+// "GET /metrics"
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// roundTripper records the duration of every request in TigerGraphRequestDuration
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+// InstrumentTigerGraph wraps next (http.DefaultTransport if nil) so requests made with it are
+// recorded in tigergraph_request_duration_seconds
+func InstrumentTigerGraph(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper{next: next}
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	status := "error" // no response, i.e., connection refused or timed out
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	TigerGraphRequestDuration.WithLabelValues(req.URL.Path, status).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"chat-history/apierror"
+	"net/http"
+)
+
+// MaxBodyBytes limits request bodies to n bytes. Requests that say they're bigger get a 413 right away,
+// the rest are cut off at n and reading more fails with an *http.MaxBytesError, which handlers answer
+// with a 413 too. It does nothing if n is 0
+func MaxBodyBytes(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				apierror.Write(w, apierror.TooLarge(n))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodyBytes(t *testing.T) {
+	var readErr error
+	handler := ChainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}), MaxBodyBytes(10))
+
+	// a Content-Length over the limit is rejected before the handler
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 11))))
+	if resp.Code != http.StatusRequestEntityTooLarge || !strings.Contains(resp.Body.String(), `"request_too_large"`) {
+		t.Fatalf("Response code should be 413. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// a body without a Content-Length is cut off at the limit
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 11)))
+	req.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	var tooLarge *http.MaxBytesError
+	if !errors.As(readErr, &tooLarge) || tooLarge.Limit != 10 {
+		t.Fatalf("reading past the limit should fail with a MaxBytesError. It returned: %v", readErr)
+	}
+
+	// bodies up to the limit are read
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 10))))
+	if readErr != nil {
+		t.Fatalf("a body at the limit should be read. It returned: %v", readErr)
+	}
+
+	// 0 is no limit
+	unlimited := MaxBodyBytes(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	resp = httptest.NewRecorder()
+	unlimited.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 11))))
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP sets r.RemoteAddr to the IP of the client that made the request, without a port, so the logs and
+// RateLimit have it instead of the load balancer's. Requests from one of the trusted proxies are from the
+// address in X-Forwarded-For (or else X-Real-IP) that no trusted proxy added. Requests from any other peer are
+// from the peer, whatever the headers say, since anyone can send them
+func ClientIP(trusted []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := clientIP(r, trusted); ip.IsValid() {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP resolves the client's IP, the zero Addr if r.RemoteAddr isn't one
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	peer := remoteIP(r)
+	if !peer.IsValid() || !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	// each proxy appends the address it got the request from, so going right to left the client
+	// is the first address that's not a trusted proxy's
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// what's left of it can't be trusted either
+				break
+			}
+			client = hop.Unmap()
+			if !isTrusted(client, trusted) {
+				break
+			}
+		}
+		return client
+	}
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap()
+	}
+	return peer
+}
+
+// remoteIP is the IP of r.RemoteAddr, which has a port unless ClientIP already replaced it
+func remoteIP(r *http.Request) netip.Addr {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.5/32")}
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.7:4321", nil, "203.0.113.7"},
+		{"untrusted peer's headers are ignored", "203.0.113.7:4321", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.7"},
+		{"trusted peer without headers", "10.1.2.3:80", nil, "10.1.2.3"},
+		{"trusted peer", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.168.1.5, 10.9.9.9"}, "198.51.100.1"},
+		{"spoofed hops before the client are ignored", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"every hop trusted", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "10.5.5.5, 10.6.6.6"}, "10.5.5.5"},
+		{"malformed hop", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "not-an-ip, 10.6.6.6"}, "10.6.6.6"},
+		{"X-Real-IP", "192.168.1.5:80", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"X-Forwarded-For wins over X-Real-IP", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "198.51.100.1"},
+		{"IPv6", "[2001:db8::1]:443", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "2001:db8::1"},
+		{"IPv4-mapped peer", "[::ffff:10.1.2.3]:80", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("the client should be %s, got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClientIP_NoProxies(t *testing.T) {
+	var got string
+	h := ClientIP(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "10.1.2.3" {
+		t.Fatalf("without trusted proxies the peer is the client, got: %s", got)
+	}
+}
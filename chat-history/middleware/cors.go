@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"chat-history/apierror"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// methods and headers the API is called with from a browser
+var (
+	corsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions}
+	corsHeaders = []string{"Authorization", "Content-Type", "X-Request-ID"}
+)
+
+// CORS lets browsers on allowedOrigins call the API. "*" allows any origin, it can't be used with
+// allowCredentials (see config.Config.Validate). Preflight requests are answered here, before
+// they reach the router. Requests from other origins get no CORS headers, so the browser blocks them.
+// It does nothing if allowedOrigins is empty
+func CORS(allowedOrigins []string, allowCredentials bool) Middleware {
+	anyOrigin := slices.Contains(allowedOrigins, "*")
+	return func(next http.Handler) http.Handler {
+		if len(allowedOrigins) == 0 {
+			return next
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !AllowedOrigin(allowedOrigins, origin) {
+				if preflight {
+					apierror.Write(w, apierror.Forbidden(fmt.Sprintf("origin %s is not allowed", origin)))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				// so the frontend can read the id of its request
+				w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// AllowedOrigin reports whether origin is one of allowedOrigins, as CORS matches them
+func AllowedOrigin(allowedOrigins []string, origin string) bool {
+	return slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin)
+}
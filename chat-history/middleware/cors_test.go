@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func corsRequest(h http.Handler, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/conversation", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	}
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	return resp
+}
+
+func newCORSHandler(origins []string, credentials bool) (http.Handler, *int) {
+	calls := 0
+	h := CORS(origins, credentials)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	return h, &calls
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	h, calls := newCORSHandler([]string{"https://ui.example.com"}, true)
+
+	resp := corsRequest(h, http.MethodOptions, "https://ui.example.com", true)
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("preflight should be answered with 204. Got %d", resp.Code)
+	}
+	if *calls != 0 {
+		t.Fatal("preflight shouldn't reach the router")
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://ui.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST, PUT, DELETE, OPTIONS",
+		"Access-Control-Allow-Headers":     "Authorization, Content-Type, X-Request-ID",
+		"Vary":                             "Origin",
+	}
+	for k, v := range want {
+		if got := resp.Header().Get(k); got != v {
+			t.Fatalf("%s should be %q. It's %q", k, v, got)
+		}
+	}
+
+	// the actual request goes through with the origin allowed
+	resp = corsRequest(h, http.MethodPost, "https://ui.example.com", false)
+	if resp.Code != http.StatusOK || *calls != 1 {
+		t.Fatalf("the request should reach the router. Got %d", resp.Code)
+	}
+	if resp.Header().Get("Access-Control-Allow-Origin") != "https://ui.example.com" {
+		t.Fatalf("the response should allow the origin. Headers: %v", resp.Header())
+	}
+}
+
+func TestCORS_DisallowedOrigin(t *testing.T) {
+	h, calls := newCORSHandler([]string{"https://ui.example.com"}, true)
+
+	resp := corsRequest(h, http.MethodOptions, "https://evil.example.com", true)
+	if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), `"code":"forbidden"`) {
+		t.Fatalf("preflight from another origin should be rejected. Got %d: %s", resp.Code, resp.Body)
+	}
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" || resp.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Fatalf("no CORS headers should be sent to another origin. Headers: %v", resp.Header())
+	}
+
+	// the browser blocks the response without CORS headers, the request itself is handled as usual
+	resp = corsRequest(h, http.MethodGet, "https://evil.example.com", false)
+	if resp.Code != http.StatusOK || *calls != 1 {
+		t.Fatalf("the request should reach the router. Got %d", resp.Code)
+	}
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" || resp.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("no CORS headers should be sent to another origin. Headers: %v", resp.Header())
+	}
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	h, _ := newCORSHandler([]string{"*"}, false)
+	resp := corsRequest(h, http.MethodOptions, "https://anywhere.example.com", true)
+	if resp.Code != http.StatusNoContent || resp.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("any origin should be allowed. Got %d: %v", resp.Code, resp.Header())
+	}
+	if resp.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatal("credentials shouldn't be allowed")
+	}
+}
+
+func TestCORS_NoOrigin(t *testing.T) {
+	h, calls := newCORSHandler([]string{"https://ui.example.com"}, false)
+	resp := corsRequest(h, http.MethodOptions, "", false)
+	if *calls != 1 || resp.Header().Get("Vary") != "" {
+		t.Fatalf("requests that aren't from a browser should pass through untouched. Headers: %v", resp.Header())
+	}
+
+	// CORS is off without allowed origins
+	h, calls = newCORSHandler(nil, false)
+	resp = corsRequest(h, http.MethodOptions, "https://ui.example.com", true)
+	if *calls != 1 || resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("preflight should reach the router without allowed origins. Headers: %v", resp.Header())
+	}
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LogRotation is when the request log is moved aside and a new one started. The rotated files are
+// named after the log and the time they were rotated, i.e., requestLogs-20261014T054138.123456Z.jsonl
+type LogRotation struct {
+	// the log is rotated before a line would take it past this. It's never rotated if it's 0
+	MaxSizeMB int
+	// rotated files kept, the oldest are removed first. 0 keeps them all
+	MaxBackups int
+	// rotated files older than this are removed. 0 keeps them however old they are
+	MaxAgeDays int
+	// gzip the rotated files
+	Compress bool
+}
+
+// rotated files sort oldest first by name, the same as the backups of the DB
+const rotatedTimeFormat = "20060102T150405.000000Z"
+
+// rotatedName is the name the log at path is rotated to at t
+func rotatedName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), t.UTC().Format(rotatedTimeFormat), ext)
+}
+
+// rotate moves the log aside and starts a new one. The old file is only closed once the new one is
+// open, so if anything fails the lines keep going to it. Call it with l.mu held
+func (l *RequestLog) rotate() error {
+	now := time.Now()
+	dest := rotatedName(l.path, now)
+	// two rotations in the same microsecond would overwrite each other
+	for {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Microsecond)
+		dest = rotatedName(l.path, now)
+	}
+	if err := os.Rename(l.path, dest); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	old := l.f
+	l.f, l.size = f, 0
+	old.Close()
+
+	// compressing can take a while, don't hold up requests for it
+	l.mill.Add(1)
+	go func() {
+		defer l.mill.Done()
+		l.millMu.Lock()
+		defer l.millMu.Unlock()
+		if l.rotation.Compress {
+			if err := compressLog(dest); err != nil {
+				os.Stderr.WriteString("failed to compress rotated request log: " + err.Error() + "\n")
+			}
+		}
+		if err := pruneLogs(l.path, l.rotation, time.Now()); err != nil {
+			os.Stderr.WriteString("failed to remove old request logs: " + err.Error() + "\n")
+		}
+	}()
+	return nil
+}
+
+// compressLog replaces the file at path with path.gz
+func compressLog(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// write to a temp file and rename it, so a .gz is never partial
+	tmp := path + ".gz.tmp"
+	dest, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dest)
+	if _, err := io.Copy(zw, src); err != nil {
+		dest.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dest.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneLogs removes the rotated files of the log at path that are past rotation's limits
+func pruneLogs(path string, rotation LogRotation, now time.Time) error {
+	if rotation.MaxBackups <= 0 && rotation.MaxAgeDays <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type rotated struct {
+		name string
+		at   time.Time
+	}
+	logs := []rotated{}
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if e.IsDir() || !ok {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if !ok {
+			continue
+		}
+		// skips other files that happen to start with the prefix
+		at, err := time.Parse(rotatedTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		logs = append(logs, rotated{name: e.Name(), at: at})
+	}
+	// newest first
+	slices.SortFunc(logs, func(a, b rotated) int { return b.at.Compare(a.at) })
+
+	oldest := now.AddDate(0, 0, -rotation.MaxAgeDays)
+	for i, log := range logs {
+		tooMany := rotation.MaxBackups > 0 && i >= rotation.MaxBackups
+		tooOld := rotation.MaxAgeDays > 0 && log.at.Before(oldest)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, log.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
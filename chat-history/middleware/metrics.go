@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"chat-history/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// path values replaced by their name in the path label, so every conversation doesn't get its own series
+var routeParams = []string{"userId", "conversationId"}
+
+// route is the path of the request with its path values replaced by their names,
+// i.e., /conversation/{conversationId}
+func route(r *http.Request) string {
+	path := r.URL.Path
+	for _, name := range routeParams {
+		// path values are set on r by the mux while routing
+		if v := r.PathValue(name); v != "" {
+			path = strings.Replace(path, "/"+v, "/{"+name+"}", 1)
+		}
+	}
+	return path
+}
+
+// Metrics records the count and duration of every request
+func Metrics() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			path := route(r)
+			metrics.HTTPRequests.WithLabelValues(r.Method, path, strconv.Itoa(rec.status)).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -41,11 +43,61 @@ func (l FileLogger) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// init logger middleware
-func Logger() func(http.Handler) http.Handler {
-	fname := "logs.jsonl"
-	l := InitLogger(fname)
+// logLevel is the minimum level of the HTTP logs. It can be changed while the server is running
+var logLevel = func() *slog.LevelVar {
+	v := &slog.LevelVar{}
+	v.Set(slog.LevelDebug)
+	return v
+}()
 
+// NewLogger returns the service's own log, i.e., for errors outside of a request, as JSON lines to w.
+// It's at the same level as the HTTP logs, which go to their own file (see Logger and RequestLogger)
+func NewLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// SetLogLevel changes the minimum level of the HTTP logs and those of NewLogger
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// leveled drops records below level before they reach the handler
+type leveled struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h leveled) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h leveled) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return leveled{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h leveled) WithGroup(name string) slog.Handler {
+	return leveled{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// OpenLog returns where a log that goes to stdout, to the file at path, or to both is written, see
+// config.ToStdout and config.ToFile. It's only stdout if path is empty, and only the file if stdout is nil.
+// The file is appended to, and closed by close
+func OpenLog(path string, stdout io.Writer) (w io.Writer, close func() error, err error) {
+	if path == "" {
+		return stdout, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stdout == nil {
+		return f, f.Close, nil
+	}
+	return io.MultiWriter(stdout, f), f.Close, nil
+}
+
+// init logger middleware, it writes the concise HTTP logs to w
+func Logger(w io.Writer) func(http.Handler) http.Handler {
 	logger := httplog.NewLogger("httplog", httplog.Options{
 		JSON:             true,
 		LogLevel:         slog.LevelDebug,
@@ -62,8 +114,10 @@ func Logger() func(http.Handler) http.Handler {
 		},
 		QuietDownPeriod: 10 * time.Second,
 		// SourceFieldName: "source",
-		Writer: l,
+		Writer: w,
 	})
+	// httplog fixes the level when it's created, logLevel is checked on every record instead
+	logger.Logger = slog.New(leveled{Handler: logger.Logger.Handler(), level: logLevel})
 
 	return httplog.RequestLogger(logger)
 }
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Cleanup(func() { SetLogLevel(slog.LevelDebug) })
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+
+	SetLogLevel(slog.LevelInfo)
+	logger.Debug("too detailed")
+	if buf.Len() != 0 {
+		t.Fatalf("debug lines should be dropped at info. Got: %s", buf.String())
+	}
+	logger.Info("purged conversations from the trash", "count", 2)
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("lines should be JSON. Got %s: %v", buf.String(), err)
+	}
+	if line["level"] != "INFO" || line["msg"] != "purged conversations from the trash" || line["count"] != 2.0 {
+		t.Fatalf("the line should have the level, message and attributes. Got: %v", line)
+	}
+
+	// the level can change while the logger is in use, i.e., on SIGHUP
+	buf.Reset()
+	SetLogLevel(slog.LevelDebug)
+	logger.Debug("too detailed")
+	if buf.Len() == 0 {
+		t.Fatal("debug lines should be written once the level is debug")
+	}
+}
+
+func TestOpenLog(t *testing.T) {
+	for name, tt := range map[string]struct {
+		stdout, file bool
+	}{
+		"stdout": {stdout: true},
+		"file":   {file: true},
+		"both":   {stdout: true, file: true},
+	} {
+		var stdout bytes.Buffer
+		var out io.Writer
+		if tt.stdout {
+			out = &stdout
+		}
+		pth := ""
+		if tt.file {
+			pth = filepath.Join(t.TempDir(), "app.jsonl")
+		}
+		w, closeLog, err := OpenLog(pth, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewLogger(w).Info("server running", "port", ":8002")
+		if err := closeLog(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.Contains(stdout.String(), "server running"); got != tt.stdout {
+			t.Fatalf("%s: the line should be on stdout: %v. It is: %v", name, tt.stdout, got)
+		}
+		if tt.file {
+			b, err := os.ReadFile(pth)
+			if err != nil || !strings.Contains(string(b), "server running") || strings.Count(string(b), "\n") != 1 {
+				t.Fatalf("%s: the line should be in the file. It has %q, %v", name, b, err)
+			}
+		}
+	}
+
+	// the file is appended to
+	pth := filepath.Join(t.TempDir(), "app.jsonl")
+	os.WriteFile(pth, []byte("{}\n"), 0644)
+	w, closeLog, _ := OpenLog(pth, nil)
+	w.Write([]byte("{}\n"))
+	closeLog()
+	if b, _ := os.ReadFile(pth); string(b) != "{}\n{}\n" {
+		t.Fatalf("the existing lines should be kept: %q", b)
+	}
+}
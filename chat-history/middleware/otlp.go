@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// entries waiting to be exported. Once it's full new ones are dropped, so a slow collector can't hold up requests
+	otlpQueueSize = 10000
+	// most log records sent in one export
+	otlpBatchSize = 512
+	// how often what's queued is exported, when there's less than a batch
+	otlpInterval = time.Second
+)
+
+var errOTLPQueueFull = errors.New("the OTLP export queue is full, dropping the entry")
+
+// OTLPExporter sends the request log entries to an OpenTelemetry collector as log records, over OTLP/HTTP with
+// the JSON encoding. They're exported in batches in the background. A nil OTLPExporter drops them
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	// how long to wait for a batch to fill up
+	interval time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan requestLogEntry
+	done   chan struct{}
+}
+
+// NewOTLPExporter starts exporting the entries it's given to the collector at endpoint, i.e., http://collector:4318,
+// as the logs of service. They're POSTed to its /v1/logs unless endpoint already ends with it
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/logs") {
+		url += "/v1/logs"
+	}
+	e := &OTLPExporter{
+		url:      url,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: otlpInterval,
+		queue:    make(chan requestLogEntry, otlpQueueSize),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// write queues the entry to be exported and returns right away
+func (e *OTLPExporter) write(entry requestLogEntry) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	select {
+	case e.queue <- entry:
+		return nil
+	default:
+		return errOTLPQueueFull
+	}
+}
+
+// Close stops taking entries and waits until the ones already queued are exported, or ctx is done
+func (e *OTLPExporter) Close(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d request log entries weren't exported: %w", len(e.queue), ctx.Err())
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var batch []requestLogEntry
+	for {
+		open := true
+		select {
+		case entry, ok := <-e.queue:
+			if open = ok; ok {
+				batch = append(batch, entry)
+				if len(batch) < otlpBatchSize {
+					continue
+				}
+			}
+		case <-ticker.C:
+		}
+		if len(batch) > 0 {
+			if err := e.export(batch); err != nil {
+				// the entries are lost, the requests themselves went through
+				slog.Error("failed to export the request logs", "records", len(batch), "err", err)
+			}
+			batch = nil
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// export POSTs one batch. The collector's partial success is ignored, what it rejected isn't sent again
+func (e *OTLPExporter) export(batch []requestLogEntry) error {
+	body, err := json.Marshal(e.logs(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: %s", resp.Status, msg)
+}
+
+// The ExportLogsServiceRequest of OTLP, in its JSON encoding: ids are hex, 64 bit integers are strings
+type (
+	otlpLogs struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano         string          `json:"timeUnixNano"`
+		ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+		SeverityNumber       int             `json:"severityNumber"`
+		SeverityText         string          `json:"severityText"`
+		Body                 otlpValue       `json:"body"`
+		Attributes           []otlpAttribute `json:"attributes"`
+		TraceId              string          `json:"traceId,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func stringValue(s string) otlpValue { return otlpValue{StringValue: &s} }
+
+func intValue(n int64) otlpValue {
+	s := strconv.FormatInt(n, 10)
+	return otlpValue{IntValue: &s}
+}
+
+func doubleValue(f float64) otlpValue { return otlpValue{DoubleValue: &f} }
+
+// the severity numbers of OpenTelemetry's log data model
+const (
+	severityInfo  = 9
+	severityWarn  = 13
+	severityError = 17
+)
+
+func (e *OTLPExporter) logs(batch []requestLogEntry) otlpLogs {
+	observed := strconv.FormatInt(time.Now().UnixNano(), 10)
+	records := make([]otlpLogRecord, 0, len(batch))
+	for _, entry := range batch {
+		severity, text := severityInfo, "INFO"
+		if entry.Status >= 500 {
+			severity, text = severityError, "ERROR"
+		} else if entry.Status >= 400 {
+			severity, text = severityWarn, "WARN"
+		}
+		// the attribute names are OpenTelemetry's semantic conventions where there's one
+		attributes := []otlpAttribute{
+			{"http.request.method", stringValue(entry.Method)},
+			{"url.path", stringValue(entry.Path)},
+			{"http.response.status_code", intValue(int64(entry.Status))},
+			{"latency_ms", doubleValue(entry.LatencyMs)},
+		}
+		if entry.RequestId != "" {
+			attributes = append(attributes, otlpAttribute{"request_id", stringValue(entry.RequestId)})
+		}
+		if entry.UserId != "" {
+			attributes = append(attributes, otlpAttribute{"enduser.id", stringValue(entry.UserId)})
+		}
+		if entry.ClientIP != "" {
+			attributes = append(attributes, otlpAttribute{"client.address", stringValue(entry.ClientIP)})
+		}
+		if entry.ConversationId != "" {
+			attributes = append(attributes, otlpAttribute{"conversation_id", stringValue(entry.ConversationId)})
+		}
+		records = append(records, otlpLogRecord{
+			TimeUnixNano:         strconv.FormatInt(entry.Timestamp.UnixNano(), 10),
+			ObservedTimeUnixNano: observed,
+			SeverityNumber:       severity,
+			SeverityText:         text,
+			Body:                 stringValue(fmt.Sprintf("%s %s %d", entry.Method, entry.Path, entry.Status)),
+			Attributes:           attributes,
+			TraceId:              entry.TraceId,
+		})
+	}
+	return otlpLogs{ResourceLogs: []otlpResourceLogs{{
+		Resource:  otlpResource{Attributes: []otlpAttribute{{"service.name", stringValue(e.service)}}},
+		ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: "chat-history/middleware"}, LogRecords: records}},
+	}}}
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubCollector is an OTLP/HTTP collector that keeps the log records it's sent
+type stubCollector struct {
+	mu      sync.Mutex
+	exports []otlpLogs
+	status  int
+}
+
+func (c *stubCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "not an OTLP/HTTP JSON export", http.StatusBadRequest)
+		return
+	}
+	var logs otlpLogs
+	if err := json.NewDecoder(r.Body).Decode(&logs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exports = append(c.exports, logs)
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func (c *stubCollector) records() []otlpLogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var records []otlpLogRecord
+	for _, logs := range c.exports {
+		for _, rl := range logs.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				records = append(records, sl.LogRecords...)
+			}
+		}
+	}
+	return records
+}
+
+func attribute(record otlpLogRecord, key string) (otlpValue, bool) {
+	for _, a := range record.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return otlpValue{}, false
+}
+
+func TestOTLPExporter(t *testing.T) {
+	collector := &stubCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exporter := NewOTLPExporter(srv.URL, "chat-history")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := ChainMiddleware(mux, RequestLogger(exporter), RequestID())
+
+	req := httptest.NewRequest(http.MethodGet, "/conversation/601529eb-4927-4e24-b285-bd6b9519a951", nil)
+	req.SetBasicAuth("sam_pull", "sam_pull")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	// without a traceparent, the trace is the request id
+	req = httptest.NewRequest(http.MethodGet, "/conversation/601529eb-4927-4e24-b285-bd6b9519a951", nil)
+	req.Header.Set("X-Request-ID", "0192f0a0-0000-7000-8000-000000000001")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the queued entries are exported on Close
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(collector.exports) == 0 {
+		t.Fatal("the collector should get the request logs")
+	}
+	resource := collector.exports[0].ResourceLogs[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || *resource[0].Value.StringValue != "chat-history" {
+		t.Fatalf("the logs should be from the chat-history service. Got %+v", resource)
+	}
+	records := collector.records()
+	if len(records) != 2 {
+		t.Fatalf("there should be a log record per request. Got %d", len(records))
+	}
+	first := records[0]
+	if first.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || first.SeverityText != "WARN" || first.TimeUnixNano == "" {
+		t.Fatalf("the record should have the trace id of the traceparent and the 4xx severity. Got %+v", first)
+	}
+	wants := map[string]string{
+		"http.request.method": "GET",
+		"url.path":            "/conversation/601529eb-4927-4e24-b285-bd6b9519a951",
+		"request_id":          "req-1",
+		"enduser.id":          "sam_pull",
+		"conversation_id":     "601529eb-4927-4e24-b285-bd6b9519a951",
+	}
+	for key, want := range wants {
+		if v, ok := attribute(first, key); !ok || v.StringValue == nil || *v.StringValue != want {
+			t.Fatalf("the record's %s should be %q. Got %+v", key, want, first.Attributes)
+		}
+	}
+	if v, ok := attribute(first, "http.response.status_code"); !ok || v.IntValue == nil || *v.IntValue != "418" {
+		t.Fatalf("the record should have the status. Got %+v", first.Attributes)
+	}
+	if v, ok := attribute(first, "latency_ms"); !ok || v.DoubleValue == nil || *v.DoubleValue < 0 {
+		t.Fatalf("the record should have the latency. Got %+v", first.Attributes)
+	}
+	if records[1].TraceId != "0192f0a0000070008000000000000001" {
+		t.Fatalf("without a traceparent the trace id should be the request id. Got %q", records[1].TraceId)
+	}
+}
+
+func TestOTLPExporter_Batches(t *testing.T) {
+	collector := &stubCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exporter := NewOTLPExporter(srv.URL+"/v1/logs", "chat-history")
+
+	// a full batch is exported right away, the rest once it's waited for the interval
+	for range otlpBatchSize + 1 {
+		if err := exporter.write(requestLogEntry{Timestamp: time.Now(), Method: "GET", Path: "/conversations", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(collector.records()) < otlpBatchSize+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(collector.records()); n != otlpBatchSize+1 {
+		t.Fatalf("every entry should be exported. Got %d", n)
+	}
+	collector.mu.Lock()
+	for _, logs := range collector.exports {
+		if n := len(logs.ResourceLogs[0].ScopeLogs[0].LogRecords); n > otlpBatchSize {
+			t.Fatalf("an export should have at most %d records. Got %d", otlpBatchSize, n)
+		}
+	}
+	collector.mu.Unlock()
+
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// it's closed, the entry is dropped
+	if err := exporter.write(requestLogEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	var none *OTLPExporter
+	if err := none.write(requestLogEntry{}); err != nil || none.Close(context.Background()) != nil {
+		t.Fatal("a nil exporter should drop the entries")
+	}
+}
+
+func TestOTLPExporter_CollectorFails(t *testing.T) {
+	collector := &stubCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	exporter := NewOTLPExporter(srv.URL, "chat-history")
+
+	// the entries are dropped, the requests aren't held up
+	handler := ChainMiddleware(http.NotFoundHandler(), RequestLogger(exporter))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("the request should go through. Got %d", rec.Code)
+	}
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.records()) != 1 {
+		t.Fatal("the collector should have been sent the entry")
+	}
+}
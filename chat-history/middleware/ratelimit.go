@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"chat-history/apierror"
+	"chat-history/authn"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per user. Each user can make burst requests at once,
+// and gets rate more per second after that. A rate of 0 doesn't limit them
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets that have been idle this long are full again, so they're dropped
+const bucketIdle = 10 * time.Minute
+
+func NewRateLimiter(ratePerSec float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    ratePerSec,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// SetLimits changes the rate and burst for every user. Requests already counted against a bucket
+// still count, but it never holds more than the new burst
+func (l *RateLimiter) SetLimits(ratePerSec float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = ratePerSec
+	l.burst = float64(burst)
+}
+
+// allow takes a token from the user's bucket. If it's empty, it returns false and how long
+// until the next token is available
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	// refill for the time since the last request
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops idle buckets so the map doesn't grow with every user that ever wrote
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdle {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= bucketIdle {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limitKey is who r is limited as, see RateLimit, and the key of their bucket
+func limitKey(r *http.Request) (user, key string) {
+	user, _, _ = r.BasicAuth()
+	if id, ok := authn.FromContext(r.Context()); ok {
+		user = id.UserId
+	}
+	if user == "" {
+		// the prefix keeps them apart from a user named like an IP
+		user = clientAddr(r)
+		return user, "ip:" + user
+	}
+	return user, user
+}
+
+// AllowRequest takes a token for r's user from the bucket RateLimit would, for each of the writes of a request
+// that makes several, i.e., a WebSocket's messages. If it's empty, it returns false and how long until the next
+// token is available
+func (l *RateLimiter) AllowRequest(r *http.Request) (bool, time.Duration) {
+	_, key := limitKey(r)
+	return l.allow(key)
+}
+
+// RateLimit rejects requests over the user's limit with 429 and a Retry-After header.
+// Users are identified by the identity authn put in the request context, or else their basic auth
+// username; requests without either are limited per client IP (see ClientIP)
+func RateLimit(l *RateLimiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, key := limitKey(r)
+			if ok, wait := l.allow(key); !ok {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+				apierror.Write(w, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, fmt.Sprintf("too many requests from %s", user)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
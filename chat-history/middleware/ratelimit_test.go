@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a time source the test moves forward by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(ratePerSec float64, burst int) (http.Handler, *fakeClock) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewRateLimiter(ratePerSec, burst)
+	l.now = clock.now
+	handler := RateLimit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	return handler, clock
+}
+
+func write(h http.Handler, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/conversation", nil)
+	req.SetBasicAuth(user, "pass")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestRateLimit_ExhaustBucket(t *testing.T) {
+	h, _ := newTestLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if resp := write(h, "sam_pull"); resp.Code != http.StatusOK {
+			t.Fatalf("request %d should be allowed. It got: %d", i, resp.Code)
+		}
+	}
+
+	resp := write(h, "sam_pull")
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("Response code should be 429 once the burst is used. It is: %v", resp.Code)
+	}
+	if ra := resp.Header().Get("Retry-After"); ra != "1" {
+		t.Fatalf("Retry-After should be 1. It's: %q", ra)
+	}
+	if !strings.Contains(resp.Body.String(), `"code":"rate_limited"`) {
+		t.Fatalf("the body should have the rate_limited code. It's: %s", resp.Body)
+	}
+
+	// other users have their own bucket
+	if resp := write(h, "Miss_Take"); resp.Code != http.StatusOK {
+		t.Fatalf("another user should not be limited. It got: %d", resp.Code)
+	}
+}
+
+func TestRateLimit_Off(t *testing.T) {
+	h, _ := newTestLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if resp := write(h, "sam_pull"); resp.Code != http.StatusOK {
+			t.Fatalf("a rate of 0 shouldn't limit. Request %d got: %d", i, resp.Code)
+		}
+	}
+}
+
+func TestRateLimit_Refill(t *testing.T) {
+	h, clock := newTestLimiter(2, 2)
+
+	write(h, "sam_pull")
+	write(h, "sam_pull")
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("Response code should be 429. It is: %v", resp.Code)
+	}
+
+	// 2 tokens per second, so 1 is back after half a second
+	clock.advance(500 * time.Millisecond)
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusOK {
+		t.Fatalf("a token should have refilled. It got: %d", resp.Code)
+	}
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("only one token should have refilled. It got: %d", resp.Code)
+	}
+
+	// refilling stops at the burst
+	clock.advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		if resp := write(h, "sam_pull"); resp.Code != http.StatusOK {
+			t.Fatalf("request %d should be allowed after a full refill. It got: %d", i, resp.Code)
+		}
+	}
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("the bucket should not hold more than the burst. It got: %d", resp.Code)
+	}
+}
+
+func TestRateLimit_SetLimits(t *testing.T) {
+	l := NewRateLimiter(1, 3)
+	l.now = (&fakeClock{t: time.Now()}).now
+	h := RateLimit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	write(h, "sam_pull")
+	// the bucket has 2 left, more than the new burst
+	l.SetLimits(1, 1)
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusOK {
+		t.Fatalf("request should be allowed. It got: %d", resp.Code)
+	}
+	if resp := write(h, "sam_pull"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("the bucket should be capped at the new burst. Response code is: %v", resp.Code)
+	}
+	if resp := write(h, "Miss_Take"); resp.Code != http.StatusOK {
+		t.Fatalf("new users should get the new burst. It got: %d", resp.Code)
+	}
+	if resp := write(h, "Miss_Take"); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("new users should get the new burst. Response code is: %v", resp.Code)
+	}
+}
+
+func TestRateLimit_PerClientIP(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	h := ClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})(RateLimit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	anonymous := func(client string) int {
+		req := httptest.NewRequest(http.MethodGet, "/shared/token", nil)
+		req.RemoteAddr = "10.0.0.1:8080"
+		req.Header.Set("X-Forwarded-For", client)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := anonymous("198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("the first request should be allowed. It got: %d", code)
+	}
+	if code := anonymous("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("the client's second request should be limited. It got: %d", code)
+	}
+	// behind the same load balancer, other clients have their own bucket
+	if code := anonymous("198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("another client should not be limited. It got: %d", code)
+	}
+}
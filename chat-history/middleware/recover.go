@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"chat-history/apierror"
+	"chat-history/metrics"
+	"chat-history/requestid"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic in a handler into a 500, instead of the connection being dropped. The panic and its
+// stack are logged to logger with the request's id and counted in panics_total, the client only gets a generic
+// error. The 500 can't be sent once the response has started, i.e., a stream, that's only logged.
+// http.ErrAbortHandler is panicked again, it's how handlers abort a response on purpose
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				metrics.Panics.Inc()
+				logger.Error("handler panicked",
+					"request_id", requestid.FromContext(r.Context()),
+					"method", r.Method,
+					"path", r.URL.Path,
+					"panic", fmt.Sprint(v),
+					"stack", string(debug.Stack()),
+				)
+				if rec.status == 0 {
+					apierror.Write(w, apierror.Internal("internal server error"))
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
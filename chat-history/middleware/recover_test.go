@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"chat-history/metrics"
+	"chat-history/requestid"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	panics := testutil.ToFloat64(metrics.Panics)
+
+	handler := ChainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("secret connection string")
+	}), Recover(logger), RequestID())
+	req := httptest.NewRequest(http.MethodGet, "/conversation/1", nil)
+	req.Header.Set(requestid.Header, "req-123")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusInternalServerError || !strings.Contains(resp.Body.String(), `"code":"internal_error"`) {
+		t.Fatalf("Response code should be a structured 500. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if strings.Contains(resp.Body.String(), "secret") {
+		t.Fatalf("the panic shouldn't be sent to the client: %s", resp.Body)
+	}
+	if got := testutil.ToFloat64(metrics.Panics); got != panics+1 {
+		t.Fatalf("panics_total should be incremented. It's %v", got)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("the panic should be logged: %v: %s", err, logs.String())
+	}
+	if entry["request_id"] != "req-123" || entry["panic"] != "secret connection string" {
+		t.Fatalf("the log should have the request id and the panic: %s", logs.String())
+	}
+	if stack, _ := entry["stack"].(string); !strings.Contains(stack, "TestRecover") {
+		t.Fatalf("the log should have the stack: %s", logs.String())
+	}
+}
+
+func TestRecover_StartedResponse(t *testing.T) {
+	resp := httptest.NewRecorder()
+	Recover(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("event: chunk\n"))
+		panic("mid-stream")
+	})).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Code != http.StatusOK || resp.Body.String() != "event: chunk\n" {
+		t.Fatalf("a started response should be left as it is. It's %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestRecover_ErrAbortHandler(t *testing.T) {
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("http.ErrAbortHandler should be panicked again. Got %v", v)
+		}
+	}()
+	panics := testutil.ToFloat64(metrics.Panics)
+	defer func() {
+		if got := testutil.ToFloat64(metrics.Panics); got != panics {
+			t.Fatalf("http.ErrAbortHandler shouldn't be counted. panics_total is %v", got)
+		}
+	}()
+	Recover(slog.Default())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"chat-history/requestid"
+	"net/http"
+)
+
+// RequestID gives every request an id, the caller's X-Request-ID if it sent a valid one.
+// The id is stored in the request context and returned in the response's X-Request-ID header.
+// It should wrap the other middleware so their log lines have the id
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestid.Header)
+			if !requestid.Valid(id) {
+				id = requestid.New()
+			}
+			// httplog reads the id from the header
+			r.Header.Set(requestid.Header, id)
+			w.Header().Set(requestid.Header, id)
+			next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"bufio"
+	"chat-history/authn"
+	"chat-history/requestid"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestLog is an append-only JSONL file with one line per request. It's rotated as configured by LogRotation
+type RequestLog struct {
+	path     string
+	rotation LogRotation
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	// compressing and removing rotated files, which happens in the background
+	mill   sync.WaitGroup
+	millMu sync.Mutex
+}
+
+type requestLogEntry struct {
+	Timestamp      time.Time `json:"timestamp"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Status         int       `json:"status"`
+	LatencyMs      float64   `json:"latency_ms"`
+	UserId         string    `json:"user_id,omitempty"`
+	ClientIP       string    `json:"client_ip,omitempty"`
+	ConversationId string    `json:"conversation_id,omitempty"`
+	RequestId      string    `json:"request_id,omitempty"`
+	TraceId        string    `json:"trace_id,omitempty"`
+}
+
+// RequestSink is where RequestLogger writes the entries: a RequestLog, a StreamLog or an OTLPExporter
+type RequestSink interface {
+	write(entry requestLogEntry) error
+}
+
+func OpenRequestLog(path string, rotation LogRotation) (*RequestLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &RequestLog{path: path, rotation: rotation, f: f, size: info.Size()}, nil
+}
+
+// write appends a single line. Each line is written with one call under the lock,
+// so lines from concurrent requests never interleave, and never end up split across two files
+func (l *RequestLog) write(entry requestLogEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	maxSize := int64(l.rotation.MaxSizeMB) * 1024 * 1024
+	if maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > maxSize {
+		if err := l.rotate(); err != nil {
+			// keep writing to the current file rather than lose the line
+			os.Stderr.WriteString("failed to rotate request log: " + err.Error() + "\n")
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// Close waits for rotated files to be compressed, then flushes the log to disk and closes it
+func (l *RequestLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// nothing can rotate while the lock is held
+	l.mill.Wait()
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// StreamLog writes the same lines as RequestLog to a stream instead of a file, i.e., stdout for a container's
+// log collector
+type StreamLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStreamLog(w io.Writer) *StreamLog {
+	return &StreamLog{w: w}
+}
+
+// write writes a single line with one call under the lock, so lines from concurrent requests never interleave
+func (l *StreamLog) write(entry requestLogEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	return err
+}
+
+// statusRecorder captures the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, it's logged as switching protocols
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// traceId is the W3C trace id of the request from its traceparent header, so its entry can be found with the
+// caller's trace. Without one it's the request id, if that's a UUID
+func traceId(r *http.Request, requestId string) string {
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 && parts[1] != strings.Repeat("0", 32) {
+		if _, err := hex.DecodeString(parts[1]); err == nil {
+			return strings.ToLower(parts[1])
+		}
+	}
+	if id, err := uuid.Parse(requestId); err == nil {
+		return hex.EncodeToString(id[:])
+	}
+	return ""
+}
+
+// clientAddr is the client's IP for the log, "" if r.RemoteAddr isn't one
+func clientAddr(r *http.Request) string {
+	if ip := remoteIP(r); ip.IsValid() {
+		return ip.String()
+	}
+	return ""
+}
+
+// RequestLogger writes an entry to each of the sinks for every request. The user is the one authn identified
+// (see authn.WithCaller), or else the basic auth username of a request that wasn't authenticated, or the userId
+// path value
+func RequestLogger(sinks ...RequestSink) Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			caller := &authn.Caller{}
+			r = r.WithContext(authn.WithCaller(r.Context(), caller))
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			user, _, ok := r.BasicAuth()
+			if id, authenticated := caller.Identity(); authenticated {
+				user = id.UserId
+			} else if !ok {
+				user = r.PathValue("userId")
+			}
+			entry := requestLogEntry{
+				Timestamp: start.UTC(),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    rec.status,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				UserId:    user,
+				ClientIP:  clientAddr(r),
+				// path values are set on r by the mux while routing
+				ConversationId: r.PathValue("conversationId"),
+				RequestId:      requestid.FromContext(r.Context()),
+			}
+			entry.TraceId = traceId(r, entry.RequestId)
+			for _, sink := range sinks {
+				if err := sink.write(entry); err != nil {
+					// don't fail the request over a log line
+					os.Stderr.WriteString("failed to write request log: " + err.Error() + "\n")
+				}
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestLogger(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	l, err := OpenRequestLog(pth, LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := ChainMiddleware(mux, RequestLogger(l))
+
+	req := httptest.NewRequest(http.MethodGet, "/conversation/601529eb-4927-4e24-b285-bd6b9519a951", nil)
+	req.SetBasicAuth("sam_pull", "sam_pull")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(b, &entry); err != nil {
+		t.Fatalf("log line is not valid JSON: %s", b)
+	}
+	for _, key := range []string{"timestamp", "method", "path", "status", "latency_ms", "user_id", "conversation_id"} {
+		if _, ok := entry[key]; !ok {
+			t.Fatalf("log line is missing %s: %s", key, b)
+		}
+	}
+	if entry["method"] != "GET" ||
+		entry["path"] != "/conversation/601529eb-4927-4e24-b285-bd6b9519a951" ||
+		entry["status"] != float64(http.StatusTeapot) ||
+		entry["user_id"] != "sam_pull" ||
+		entry["client_ip"] != "192.0.2.1" ||
+		entry["conversation_id"] != "601529eb-4927-4e24-b285-bd6b9519a951" {
+		t.Fatalf("log line is wrong: %s", b)
+	}
+}
+
+func TestStreamLog(t *testing.T) {
+	var stdout bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", func(w http.ResponseWriter, r *http.Request) {})
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	file, err := OpenRequestLog(pth, LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both, like requestLogOutput "both"
+	handler := ChainMiddleware(mux, RequestLogger(NewStreamLog(&stdout), file))
+
+	for range 2 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/conversation/601529eb-4927-4e24-b285-bd6b9519a951", nil))
+	}
+	file.Close()
+	b, _ := os.ReadFile(pth)
+	if stdout.String() != string(b) || strings.Count(stdout.String(), "\n") != 2 {
+		t.Fatalf("the stream should get the same lines as the file. It got %q, the file %q", stdout.String(), b)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(strings.Split(stdout.String(), "\n")[0]), &entry); err != nil || entry["status"] != float64(http.StatusOK) {
+		t.Fatalf("each line should be an entry: %s", stdout.String())
+	}
+}
+
+func TestRequestLogger_Concurrent(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	l, err := OpenRequestLog(pth, LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := RequestLogger(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	n := 50
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+	l.Close()
+
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry requestLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d is not valid JSON: %s", lines, scanner.Text())
+		}
+		if entry.Status != 200 {
+			t.Fatalf("status should be 200. It's: %d", entry.Status)
+		}
+		lines++
+	}
+	if lines != n {
+		t.Fatalf("expected %d lines, got %d", n, lines)
+	}
+}
+
+// rotatedLogs are the names of the files pth was rotated to
+func rotatedLogs(t *testing.T, pth string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(strings.TrimSuffix(pth, ".jsonl") + "-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestRequestLog_Rotation(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	l, err := OpenRequestLog(pth, LogRotation{MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each line is a bit over 100KB, so 10 fit in 1MB
+	long := "/" + strings.Repeat("a", 100*1024)
+	write := func(n int) {
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for range n {
+			go func() {
+				defer wg.Done()
+				if err := l.write(requestLogEntry{Method: http.MethodGet, Path: long, Status: 200}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	write(10)
+	if rotated := rotatedLogs(t, pth); len(rotated) != 0 {
+		t.Fatalf("the log shouldn't rotate under 1MB. Got %v", rotated)
+	}
+	write(1)
+	l.mu.Lock()
+	l.mill.Wait()
+	l.mu.Unlock()
+	rotated := rotatedLogs(t, pth)
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".jsonl") {
+		t.Fatalf("the log should have rotated to one timestamped file. Got %v", rotated)
+	}
+	if info, err := os.Stat(rotated[0]); err != nil || info.Size() > 1024*1024 {
+		t.Fatalf("the rotated file should be under 1MB. Got %v, %v", info, err)
+	}
+
+	// every line is kept whole in one of the files, and only the newest 2 rotated files are kept
+	write(30)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated = rotatedLogs(t, pth)
+	if len(rotated) != 2 {
+		t.Fatalf("only 2 rotated files should be kept. Got %v", rotated)
+	}
+	for _, name := range append(rotated, pth) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			var entry requestLogEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Path != long {
+				t.Fatalf("line %d of %s isn't a whole entry", i, name)
+			}
+		}
+	}
+}
+
+func TestRequestLog_RotationCompress(t *testing.T) {
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	l, err := OpenRequestLog(pth, LogRotation{MaxSizeMB: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := "/" + strings.Repeat("a", 1024*1024)
+	for range 2 {
+		if err := l.write(requestLogEntry{Method: http.MethodGet, Path: long, Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := rotatedLogs(t, pth)
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".jsonl.gz") {
+		t.Fatalf("the rotated file should be gzipped. Got %v", rotated)
+	}
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry requestLogEntry
+	if err := json.NewDecoder(zr).Decode(&entry); err != nil || entry.Path != long {
+		t.Fatalf("the rotated file should have the first line. Got %v", err)
+	}
+}
+
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	pth := filepath.Join(dir, "requestLogs.jsonl")
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for _, days := range []int{1, 2, 10, 20} {
+		name := rotatedName(pth, now.AddDate(0, 0, -days))
+		if days == 2 {
+			name += ".gz"
+		}
+		names = append(names, name)
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not rotated logs, they're left alone. rotatedLogs matches requestLogs-notes.jsonl too
+	for _, name := range []string{"requestLogs-notes.jsonl", "requestLogs.jsonl", "db.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneLogs(pth, LogRotation{MaxBackups: 3, MaxAgeDays: 15}, now); err != nil {
+		t.Fatal(err)
+	}
+	rotated := rotatedLogs(t, pth)
+	if len(rotated) != 4 || !slices.Contains(rotated, names[0]) || !slices.Contains(rotated, names[1]) || !slices.Contains(rotated, names[2]) {
+		t.Fatalf("the 3 newest rotated files should be kept and the one older than 15 days removed. Got %v", rotated)
+	}
+	if err := pruneLogs(pth, LogRotation{MaxBackups: 3, MaxAgeDays: 5}, now); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := rotatedLogs(t, pth); len(rotated) != 3 || slices.Contains(rotated, names[2]) {
+		t.Fatalf("the file older than 5 days should be removed. Got %v", rotated)
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"chat-history/tenant"
+	"net/http"
+)
+
+// RequireTenant makes every request's store queries need a tenant, see tenant.Require. It's for deployments that
+// serve several tenants, where RequireRoles sets the caller's
+func RequireTenant() Middleware {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(tenant.Require(r.Context())))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
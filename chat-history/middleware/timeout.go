@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bufio"
+	"chat-history/apierror"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeout gives handlers d to respond. The request's context is done after d, which aborts the store queries
+// and the TigerGraph and LLM calls made with it, and whatever the handler responds with after that is replaced
+// by a 504. Responses it had already started, i.e., streams, are left as they are. It does nothing if d is 0
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, limit: d}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			if !tw.wroteHeader && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.timeout()
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// timeoutWriter responds with a 504 instead of what the handler writes once ctx is past its deadline
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	limit       time.Duration
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) timeout() {
+	w.wroteHeader, w.timedOut = true, true
+	apierror.Write(w.ResponseWriter, apierror.Timeout(fmt.Sprintf("the request didn't finish within %s", w.limit)))
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		if !w.timedOut {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	if errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timeout()
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.timedOut {
+		// the handler's response is dropped, it doesn't need to know
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	if w.timedOut {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, i.e., for a WebSocket. What happens on it is up to the handler
+// and there's no 504 once it times out
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
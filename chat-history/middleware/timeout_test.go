@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	var deadline time.Time
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		<-r.Context().Done()
+		// what a handler writes once its store call fails
+		http.Error(w, "failed to retrieve conversation", http.StatusInternalServerError)
+	})
+	resp := httptest.NewRecorder()
+	start := time.Now()
+	Timeout(20*time.Millisecond)(slow).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Code != http.StatusGatewayTimeout || !strings.Contains(resp.Body.String(), `"code":"timeout"`) {
+		t.Fatalf("Response code should be 504. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if strings.Contains(resp.Body.String(), "failed to retrieve") || resp.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("the handler's response should be replaced: %s", resp.Body)
+	}
+	if deadline.IsZero() || deadline.Sub(start) > time.Second {
+		t.Fatalf("the request's context should have the deadline. It has %v", deadline)
+	}
+
+	// handlers that return without writing anything in time
+	resp = httptest.NewRecorder()
+	Timeout(20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Response code should be 504. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// responses in time, and those started in time, are left alone
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("started"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		w.Write([]byte(", stopped"))
+	})
+	resp = httptest.NewRecorder()
+	Timeout(20*time.Millisecond)(stream).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Code != http.StatusOK || resp.Body.String() != "started, stopped" || !resp.Flushed {
+		t.Fatalf("a started response should be kept. Got %v: %s", resp.Code, resp.Body)
+	}
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	resp = httptest.NewRecorder()
+	Timeout(time.Minute)(fast).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// 0 is no timeout
+	Timeout(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Fatal("the context shouldn't have a deadline")
+		}
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// hijacker is a ResponseRecorder that can hand over its connection, like the server's writer
+type hijacker struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	server, _ := net.Pipe()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestTimeout_Hijacked(t *testing.T) {
+	resp := &hijacker{ResponseRecorder: httptest.NewRecorder()}
+	Timeout(20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		<-r.Context().Done()
+	})).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !resp.hijacked || resp.Body.Len() != 0 {
+		t.Fatalf("a hijacked connection should be left to the handler, without a 504. Got %v: %s", resp.Code, resp.Body)
+	}
+}
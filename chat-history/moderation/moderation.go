@@ -0,0 +1,151 @@
+// Package moderation decides whether a message's content can be stored, before the store writes it (see db.Moderate)
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Decision is what a Moderator made of a message
+type Decision struct {
+	Blocked bool
+	// why it's blocked, it's shown to whoever sent it
+	Reason string
+}
+
+// Moderator checks the content of messages before they're stored. An error means it couldn't tell, and the
+// message isn't stored either
+type Moderator interface {
+	Moderate(ctx context.Context, content string) (Decision, error)
+}
+
+// PassThrough allows every message. It's the default
+type PassThrough struct{}
+
+func (PassThrough) Moderate(context.Context, string) (Decision, error) {
+	return Decision{}, nil
+}
+
+// All blocks what any of the moderators blocks. They're asked in order, until one blocks the message or fails
+func All(moderators ...Moderator) Moderator {
+	return all(moderators)
+}
+
+type all []Moderator
+
+func (ms all) Moderate(ctx context.Context, content string) (Decision, error) {
+	for _, m := range ms {
+		if d, err := m.Moderate(ctx, content); err != nil || d.Blocked {
+			return d, err
+		}
+	}
+	return Decision{}, nil
+}
+
+// Blocklist blocks messages that match any of its regular expressions
+type Blocklist struct {
+	patterns []*regexp.Regexp
+}
+
+// NewBlocklist compiles the patterns, with Go's regexp syntax. They're case-sensitive unless they start with (?i)
+func NewBlocklist(patterns []string) (*Blocklist, error) {
+	b := &Blocklist{patterns: make([]*regexp.Regexp, len(patterns))}
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d: %w", i, err)
+		}
+		b.patterns[i] = re
+	}
+	return b, nil
+}
+
+func (b *Blocklist) Moderate(_ context.Context, content string) (Decision, error) {
+	for _, re := range b.patterns {
+		// the match rather than the pattern, the user knows what they wrote
+		if loc := re.FindStringIndex(content); loc != nil {
+			return Decision{Blocked: true, Reason: fmt.Sprintf("it contains %q, which isn't allowed", content[loc[0]:loc[1]])}, nil
+		}
+	}
+	return Decision{}, nil
+}
+
+// API asks a moderation service, with OpenAI's moderation API (https://api.openai.com/v1/moderations): the
+// content is POSTed as {"input": "..."}, and it's blocked if the service flags any of the results
+type API struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewAPI moderates with the service at url, sending apiKey as a bearer token if it isn't empty
+func NewAPI(url, apiKey string) *API {
+	return &API{url: url, apiKey: apiKey, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type apiResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+func (a *API) Moderate(ctx context.Context, content string) (Decision, error) {
+	body, err := json.Marshal(map[string]string{"input": content})
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Decision{}, fmt.Errorf("moderation service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Decision{}, fmt.Errorf("moderation service sent an invalid response: %w", err)
+	}
+	var flagged []string
+	blocked := false
+	for _, r := range out.Results {
+		if !r.Flagged {
+			continue
+		}
+		blocked = true
+		for category, ok := range r.Categories {
+			if ok {
+				flagged = append(flagged, category)
+			}
+		}
+	}
+	if !blocked {
+		return Decision{}, nil
+	}
+	if len(flagged) == 0 {
+		return Decision{Blocked: true, Reason: "it was flagged by moderation"}, nil
+	}
+	// each result has them all, in no particular order
+	slices.Sort(flagged)
+	flagged = slices.Compact(flagged)
+	return Decision{Blocked: true, Reason: "it was flagged for " + strings.Join(flagged, ", ")}, nil
+}
@@ -0,0 +1,87 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	b, err := NewBlocklist([]string{`(?i)\bpassword\b`, `\d{3}-\d{2}-\d{4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for content, blocked := range map[string]string{
+		"How many accounts are flagged?":  "",
+		"what's the admin PASSWORD":       "PASSWORD",
+		"my SSN is 123-45-6789, is it ok": "123-45-6789",
+		"passwords are hashed":            "",
+	} {
+		d, err := b.Moderate(context.Background(), content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Blocked != (blocked != "") {
+			t.Fatalf("%q: expected blocked %v. Got %+v", content, blocked != "", d)
+		}
+		if blocked != "" && !strings.Contains(d.Reason, blocked) {
+			t.Fatalf("%q: the reason should say what matched. Got %q", content, d.Reason)
+		}
+	}
+
+	if _, err := NewBlocklist([]string{"fine", "(unclosed"}); err == nil || !strings.Contains(err.Error(), "pattern 1") {
+		t.Fatalf("an invalid pattern should be an error saying which one. Got %v", err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var in struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		switch in.Input {
+		case "fail":
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		case "flagged":
+			w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true,"harassment":true,"hate":false}}]}`))
+		default:
+			w.Write([]byte(`{"results":[{"flagged":false,"categories":{"violence":false}}]}`))
+		}
+	}))
+	defer srv.Close()
+	api := NewAPI(srv.URL, "secret")
+
+	if d, err := api.Moderate(context.Background(), "How many accounts?"); err != nil || d.Blocked {
+		t.Fatalf("content that isn't flagged should be allowed. Got %+v, %v", d, err)
+	}
+	if auth != "Bearer secret" {
+		t.Fatalf("the API key should be sent as a bearer token. Got %q", auth)
+	}
+	if d, err := api.Moderate(context.Background(), "flagged"); err != nil || !d.Blocked || d.Reason != "it was flagged for harassment, violence" {
+		t.Fatalf("flagged content should be blocked with its categories. Got %+v, %v", d, err)
+	}
+	if _, err := api.Moderate(context.Background(), "fail"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("a failed request should be an error. Got %v", err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	words, _ := NewBlocklist([]string{"secret"})
+	numbers, _ := NewBlocklist([]string{`\d+`})
+	m := All(PassThrough{}, words, numbers)
+	if d, _ := m.Moderate(context.Background(), "hello"); d.Blocked {
+		t.Fatalf("content none of them block should be allowed. Got %+v", d)
+	}
+	if d, _ := m.Moderate(context.Background(), "the secret is 42"); !d.Blocked || !strings.Contains(d.Reason, "secret") {
+		t.Fatalf("the first that blocks should say why. Got %+v", d)
+	}
+	if d, _ := m.Moderate(context.Background(), "it's 42"); !d.Blocked {
+		t.Fatalf("any of them should block. Got %+v", d)
+	}
+}
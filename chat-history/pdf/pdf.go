@@ -0,0 +1,319 @@
+// Package pdf lays out simple text documents, like exported conversations, and writes them as PDF.
+// Text is set in the standard Helvetica fonts, which every viewer has, so no font is embedded. They cover
+// the Windows-1252 characters (Latin scripts, curly quotes, dashes, €, …), anything else is written as "?"
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A4, in points
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+	// the footer with the page number is below the bottom margin
+	footerY = 30.0
+)
+
+// Color is RGB, each from 0 to 1
+type Color [3]float64
+
+var (
+	Black = Color{0, 0, 0}
+	Gray  = Color{0.45, 0.45, 0.45}
+)
+
+// Style is how a paragraph is set
+type Style struct {
+	// font size in points, 10 if it's 0
+	Size  float64
+	Bold  bool
+	Color Color
+	// from the left margin, in points
+	Indent float64
+	// a line of this color is drawn left of the paragraph, i.e., to tell turns apart. None if it's zero
+	Bar Color
+}
+
+// Document is laid out one paragraph after another, starting new pages as they fill up
+type Document struct {
+	title string
+	pages []*bytes.Buffer
+	// where the next line's top is on the current page
+	y float64
+}
+
+// New returns an empty document. title is its metadata and the footer of every page
+func New(title string) *Document {
+	d := &Document{title: title}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Pages is how many pages the document has so far
+func (d *Document) Pages() int {
+	return len(d.pages)
+}
+
+// Space leaves h points before the next paragraph, or starts a new page if that fills this one
+func (d *Document) Space(h float64) {
+	d.y -= h
+	if d.y < margin {
+		d.newPage()
+	}
+}
+
+// Rule draws a horizontal line across the page
+func (d *Document) Rule(c Color) {
+	d.Space(4)
+	fmt.Fprintf(d.page(), "%s RG 0.5 w %s %s m %s %s l S\n", rgb(c), num(margin), num(d.y), num(pageWidth-margin), num(d.y))
+	d.Space(8)
+}
+
+// Paragraph writes text wrapped to the width of the page. Lines in text are kept, blank ones too
+func (d *Document) Paragraph(text string, s Style) {
+	size := s.Size
+	if size == 0 {
+		size = 10
+	}
+	leading := size * 1.35
+	font := "F1"
+	if s.Bold {
+		font = "F2"
+	}
+	x := margin + s.Indent
+	width := pageWidth - margin - x
+
+	for _, line := range wrap(text, width, size, s.Bold) {
+		if d.y-leading < margin {
+			d.newPage()
+		}
+		top := d.y
+		d.y -= leading
+		baseline := d.y + (leading-size)/2 + size*0.2
+		p := d.page()
+		if s.Bar != (Color{}) {
+			fmt.Fprintf(p, "%s RG 2 w %s %s m %s %s l S\n", rgb(s.Bar), num(x-8), num(top), num(x-8), num(d.y))
+		}
+		if line != "" {
+			fmt.Fprintf(p, "BT /%s %s Tf %s rg %s %s Td %s Tj ET\n", font, num(size), rgb(s.Color), num(x), num(baseline), literal(line))
+		}
+	}
+}
+
+// WriteTo writes the document as a PDF, with "title, page n of N" at the foot of each page
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(format string, args ...any) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&out, format, args...)
+		out.WriteString("\nendobj\n")
+		return len(offsets)
+	}
+	// the pages and their contents come after these, so their numbers are known up front
+	const catalog, pagesObj, regular, bold, info = 1, 2, 3, 4, 5
+	first := info + 1
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages %d 0 R >>", pagesObj)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", first+2*i)
+	}
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Title %s /Producer (chat-history) >>", utf16(d.title))
+
+	for i, content := range d.pages {
+		footer := fmt.Sprintf("%s, page %d of %d", d.title, i+1, len(d.pages))
+		fmt.Fprintf(content, "BT /F1 8 Tf %s rg %s %s Td %s Tj ET\n", rgb(Gray), num(margin), num(footerY), literal(fit(footer, pageWidth-2*margin, 8)))
+
+		var stream bytes.Buffer
+		zw := zlib.NewWriter(&stream)
+		zw.Write(content.Bytes())
+		zw.Close()
+		object("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, num(pageWidth), num(pageHeight), regular, bold, first+2*i+1)
+		object("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, catalog, info, xref)
+	return out.WriteTo(w)
+}
+
+// wrap splits text into the lines that fit in width. The indentation of each line of text is kept, and
+// words longer than a line are broken up
+func wrap(text string, width, size float64, bold bool) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		para = strings.ReplaceAll(para, "\t", "    ")
+		indent := para[:len(para)-len(strings.TrimLeft(para, " "))]
+		line, empty := indent, true
+		for _, word := range strings.Fields(para) {
+			candidate := line + word
+			if !empty {
+				candidate = line + " " + word
+			}
+			if measure(candidate, size, bold) <= width {
+				line, empty = candidate, false
+				continue
+			}
+			if !empty {
+				lines = append(lines, line)
+			}
+			for measure(word, size, bold) > width {
+				n := fits(word, width, size, bold)
+				lines = append(lines, word[:n])
+				word = word[n:]
+			}
+			line, empty = word, false
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fits is how many bytes of s, at least one rune, fit in width
+func fits(s string, width, size float64, bold bool) int {
+	n, w := 0, 0.0
+	for i, r := range s {
+		w += measure(string(r), size, bold)
+		if n > 0 && w > width {
+			break
+		}
+		n = i + utf8.RuneLen(r)
+	}
+	return n
+}
+
+// fit is s cut short with "…" if it's wider than width
+func fit(s string, width, size float64) string {
+	if measure(s, size, false) <= width {
+		return s
+	}
+	return s[:fits(s, width-measure("…", size, false), size, false)] + "…"
+}
+
+// measure is the width of s in points
+func measure(s string, size float64, bold bool) float64 {
+	units := 0
+	for _, r := range s {
+		units += advance(r)
+	}
+	w := float64(units) * size / 1000
+	if bold {
+		// Helvetica-Bold is a little wider
+		w *= 1.1
+	}
+	return w
+}
+
+// advance is the width of r in Helvetica, in thousandths of the font size
+func advance(r rune) int {
+	switch {
+	case r >= ' ' && r <= '~':
+		return helvetica[r-' ']
+	case r == '…' || r == '—' || r == '‰' || r == '™' || r == 'Œ' || r == 'œ':
+		return 1000
+	}
+	return 667
+}
+
+// helvetica are the widths of ' ' to '~'
+var helvetica = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// winAnsi are the characters of Windows-1252 from 0x80 to 0x9f that aren't at their code point
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// literal is s as a PDF string in WinAnsiEncoding. Characters it doesn't have are "?"
+func literal(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			c = byte(r)
+		case r >= ' ' && r <= '~':
+			c = byte(r)
+		case r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		case winAnsi[r] != 0:
+			c = winAnsi[r]
+		case unicode.IsControl(r):
+			continue
+		default:
+			c = '?'
+		}
+		if c > '~' {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// utf16 is s as a PDF text string, for the metadata, which can have any character
+func utf16(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range s {
+		if r >= 0x10000 {
+			r -= 0x10000
+			fmt.Fprintf(&b, "%04X%04X", 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		} else {
+			fmt.Fprintf(&b, "%04X", r)
+		}
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+func rgb(c Color) string {
+	return fmt.Sprintf("%s %s %s", num(c[0]), num(c[1]), num(c[2]))
+}
+
+// num is f with at most 2 decimals, the precision PDF readers expect
+func num(f float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", f), "0")
+	return strings.TrimSuffix(s, ".")
+}
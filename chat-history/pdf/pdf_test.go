@@ -0,0 +1,129 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parse checks that b is a PDF whose cross-reference table points at each of its objects, and returns
+// the number of pages and their contents
+func parse(t *testing.T, b []byte) (int, []string) {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte("%PDF-1.")) || !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q", b[:min(len(b), 20)])
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(b[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref doesn't point at the xref table: %q", b[xref:xref+10])
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj", i+1); !bytes.HasPrefix(b[off:], []byte(want)) {
+			t.Fatalf("xref entry %d points at %q, not %q", i+1, b[off:off+10], want)
+		}
+	}
+
+	count := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(b)
+	if count == nil {
+		t.Fatal("no page tree")
+	}
+	pages, _ := strconv.Atoi(string(count[1]))
+	if n := len(regexp.MustCompile(`/Type /Page /`).FindAll(b, -1)); n != pages {
+		t.Fatalf("the page tree counts %d pages, there are %d", pages, n)
+	}
+	var contents []string
+	for _, s := range regexp.MustCompile(`(?s)/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(b, -1) {
+		n, _ := strconv.Atoi(string(b[s[2]:s[3]]))
+		zr, err := zlib.NewReader(bytes.NewReader(b[s[1] : s[1]+n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(content))
+	}
+	if len(contents) != pages {
+		t.Fatalf("there should be a content stream per page. There are %d for %d pages", len(contents), pages)
+	}
+	return pages, contents
+}
+
+func TestDocument(t *testing.T) {
+	doc := New("Fraud rings")
+	doc.Paragraph("Fraud rings", Style{Size: 18, Bold: true})
+	doc.Rule(Gray)
+	doc.Paragraph("Which accounts (if any) share a device?\n\n    indented", Style{Bar: Color{0, 0, 1}})
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	pages, contents := parse(t, out.Bytes())
+	if pages != 1 {
+		t.Fatalf("a short document should be a page. It's %d", pages)
+	}
+	for _, want := range []string{`(Fraud rings) Tj`, `(Which accounts \(if any\) share a device?) Tj`, `(    indented) Tj`, `(Fraud rings, page 1 of 1) Tj`} {
+		if !strings.Contains(contents[0], want) {
+			t.Fatalf("the page should have %s. It's:\n%s", want, contents[0])
+		}
+	}
+}
+
+func TestDocument_Pages(t *testing.T) {
+	doc := New("long")
+	for i := 0; i < 500; i++ {
+		doc.Paragraph(fmt.Sprintf("line %d", i), Style{})
+	}
+	// a word too long for a line is broken up
+	doc.Paragraph(strings.Repeat("x", 2000), Style{})
+	var out bytes.Buffer
+	doc.WriteTo(&out)
+	pages, contents := parse(t, out.Bytes())
+	// 10pt lines are 13.5pt apart, there's room for 55 on a page
+	if pages < 9 || pages > 11 || pages != doc.Pages() {
+		t.Fatalf("500 lines and a long word should be 9 to 11 pages. They're %d", pages)
+	}
+	if !strings.Contains(contents[0], "(long, page 1 of "+strconv.Itoa(pages)+")") || !strings.Contains(contents[pages-1], "(line 499)") {
+		t.Fatal("every page should have its number and the lines should be in order")
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	for s, want := range map[string]string{
+		`a (b) \c`:       `(a \(b\) \\c)`,
+		"café – “ok” €5": `(caf\351 \226 \223ok\224 \2005)`,
+		"日本 ok":          `(?? ok)`,
+		"tab\x00":        `(tab)`,
+	} {
+		if got := literal(s); got != want {
+			t.Fatalf("%q should be %s. It's %s", s, want, got)
+		}
+	}
+	if got := utf16("é😀"); got != "<FEFF00E9D83DDE00>" {
+		t.Fatalf("the title should be UTF-16 with a BOM. It's %s", got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	lines := wrap("the quick brown fox jumps over the lazy dog", measure("the quick brown fox", 10, false), 10, false)
+	if len(lines) != 3 || lines[0] != "the quick brown fox" || lines[2] != "dog" {
+		t.Fatalf("words should wrap at the width. Got %q", lines)
+	}
+	for _, line := range wrap(strings.Repeat("ü", 300), 100, 10, false) {
+		if measure(line, 10, false) > 100 || !strings.HasPrefix(line, "ü") {
+			t.Fatalf("long words should be broken between runes. Got %q", line)
+		}
+	}
+}
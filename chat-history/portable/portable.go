@@ -0,0 +1,271 @@
+// Package portable is the file a conversation is exported to so it can be imported again, into the same
+// deployment or another: the conversation with its tags, messages and their attachments. The file has a schema
+// version, and files of older versions are upgraded when they're read
+package portable
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is the version Marshal writes.
+//   - 1 is the JSON export of GET /conversations/{conversationId}/export, which has no schema_version. Its
+//     attachments are on their messages, and it has no tags or system prompt
+//   - 2 adds the tags, the system prompt, and the messages' feedback and response time
+//   - 3 adds the conversation's metadata, when it was last updated, and whether it was archived
+const SchemaVersion = 3
+
+// ErrUnsupportedVersion is returned for files of a schema version newer than SchemaVersion
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// ConversationExport is a conversation as it's exported, see New, and imported, see ConversationExport.Data
+type ConversationExport struct {
+	SchemaVersion int          `json:"schema_version"`
+	Conversation  Conversation `json:"conversation"`
+	Tags          []string     `json:"tags"`
+	// oldest first, the order the conversation happened in, with the context messages the LLM was given
+	Messages []Message `json:"messages"`
+	// metadata only, the files aren't part of the export
+	Attachments []Attachment `json:"attachments"`
+}
+
+type Conversation struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	Name           string    `json:"name"`
+	// who it belonged to. It belongs to whoever imports it
+	UserId       string    `json:"user_id"`
+	ModelName    string    `json:"model_name,omitempty"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	CreatedAt    time.Time `json:"create_ts"`
+	// zero in older versions, it's updated when it's imported then
+	UpdatedAt time.Time         `json:"update_ts"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// it was in the archive, it's imported there
+	Archived bool `json:"archived,omitempty"`
+}
+
+type Message struct {
+	MessageId    uuid.UUID               `json:"message_id"`
+	ParentId     *uuid.UUID              `json:"parent_id"`
+	Role         structs.MessagengerRole `json:"role"`
+	Model        string                  `json:"model,omitempty"`
+	Content      string                  `json:"content"`
+	ResponseTime float64                 `json:"response_time,omitempty"`
+	Feedback     structs.Feedback        `json:"feedback,omitempty"`
+	Comment      string                  `json:"comment,omitempty"`
+	ToolName     string                  `json:"tool_name,omitempty"`
+	ToolCallId   string                  `json:"tool_call_id,omitempty"`
+	CreatedAt    time.Time               `json:"create_ts"`
+}
+
+type Attachment struct {
+	// the message it's on
+	MessageId   uuid.UUID `json:"message_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	StorageURL  string    `json:"storage_url"`
+	CreatedAt   time.Time `json:"create_ts"`
+}
+
+// New is the export of convo, with its Tags, and its messages and their attachments in the order they're given
+func New(convo structs.Conversation, messages []structs.Message, attachments []structs.Attachment) ConversationExport {
+	out := ConversationExport{
+		SchemaVersion: SchemaVersion,
+		Conversation: Conversation{
+			ConversationId: convo.ConversationId,
+			Name:           convo.Name,
+			UserId:         convo.UserId,
+			ModelName:      convo.ModelName,
+			SystemPrompt:   convo.SystemPrompt,
+			CreatedAt:      convo.CreatedAt,
+			UpdatedAt:      convo.UpdatedAt,
+			Metadata:       convo.Metadata,
+			Archived:       convo.Archived,
+		},
+		Tags:        append([]string{}, convo.Tags...),
+		Messages:    make([]Message, len(messages)),
+		Attachments: make([]Attachment, len(attachments)),
+	}
+	for i, m := range messages {
+		out.Messages[i] = Message{
+			MessageId:    m.MessageId,
+			ParentId:     m.ParentId,
+			Role:         m.Role,
+			Model:        m.ModelName,
+			Content:      m.Content,
+			ResponseTime: m.ResponseTime,
+			Feedback:     m.Feedback,
+			Comment:      m.Comment,
+			ToolName:     m.ToolName,
+			ToolCallId:   m.ToolCallId,
+			CreatedAt:    m.CreatedAt,
+		}
+	}
+	for i, a := range attachments {
+		out.Attachments[i] = Attachment{
+			MessageId:   a.MessageId,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			StorageURL:  a.StorageURL,
+			CreatedAt:   a.CreatedAt,
+		}
+	}
+	return out
+}
+
+// Data is what the export is made of, to import: the conversation with its Tags, its messages and their attachments
+func (e ConversationExport) Data() (structs.Conversation, []structs.Message, []structs.Attachment) {
+	convo := structs.Conversation{
+		UserId:         e.Conversation.UserId,
+		ConversationId: e.Conversation.ConversationId,
+		Name:           e.Conversation.Name,
+		ModelName:      e.Conversation.ModelName,
+		SystemPrompt:   e.Conversation.SystemPrompt,
+		Metadata:       e.Conversation.Metadata,
+		Archived:       e.Conversation.Archived,
+		Tags:           append([]string{}, e.Tags...),
+	}
+	convo.CreatedAt = e.Conversation.CreatedAt
+	convo.UpdatedAt = e.Conversation.UpdatedAt
+	messages := make([]structs.Message, len(e.Messages))
+	for i, m := range e.Messages {
+		messages[i] = structs.Message{
+			ConversationId: e.Conversation.ConversationId,
+			MessageId:      m.MessageId,
+			ParentId:       m.ParentId,
+			ModelName:      m.Model,
+			Content:        m.Content,
+			Role:           m.Role,
+			ResponseTime:   m.ResponseTime,
+			Feedback:       m.Feedback,
+			Comment:        m.Comment,
+			ToolName:       m.ToolName,
+			ToolCallId:     m.ToolCallId,
+		}
+		messages[i].CreatedAt = m.CreatedAt
+	}
+	attachments := make([]structs.Attachment, len(e.Attachments))
+	for i, a := range e.Attachments {
+		attachments[i] = structs.Attachment{
+			MessageId:   a.MessageId,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			StorageURL:  a.StorageURL,
+			CreatedAt:   a.CreatedAt,
+		}
+	}
+	return convo, messages, attachments
+}
+
+// Marshal is the export's JSON, indented
+func (e ConversationExport) Marshal() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// Unmarshal reads an export of any schema version up to SchemaVersion, upgrading older ones to it
+func Unmarshal(data []byte) (ConversationExport, error) {
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return ConversationExport{}, err
+	}
+	switch {
+	case version.SchemaVersion < 0 || version.SchemaVersion > SchemaVersion:
+		return ConversationExport{}, fmt.Errorf("%w %d, this server reads up to %d", ErrUnsupportedVersion, version.SchemaVersion, SchemaVersion)
+	// version 1 has no schema_version
+	case version.SchemaVersion <= 1:
+		var v1 exportV1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return ConversationExport{}, err
+		}
+		return v1.upgrade(), nil
+	}
+	var e ConversationExport
+	if err := json.Unmarshal(data, &e); err != nil {
+		return ConversationExport{}, err
+	}
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	if e.Messages == nil {
+		e.Messages = []Message{}
+	}
+	if e.Attachments == nil {
+		e.Attachments = []Attachment{}
+	}
+	return e, nil
+}
+
+// exportV1 is schema version 1
+type exportV1 struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	Name           string    `json:"name"`
+	UserId         string    `json:"user_id"`
+	CreatedAt      time.Time `json:"create_ts"`
+	ModelName      string    `json:"model_name"`
+	Messages       []struct {
+		MessageId   uuid.UUID               `json:"message_id"`
+		ParentId    *uuid.UUID              `json:"parent_id"`
+		Role        structs.MessagengerRole `json:"role"`
+		Model       string                  `json:"model"`
+		Content     string                  `json:"content"`
+		CreatedAt   time.Time               `json:"create_ts"`
+		ToolName    string                  `json:"tool_name"`
+		ToolCallId  string                  `json:"tool_call_id"`
+		Attachments []struct {
+			Filename    string `json:"filename"`
+			ContentType string `json:"content_type"`
+			Size        int64  `json:"size"`
+			StorageURL  string `json:"storage_url"`
+		} `json:"attachments"`
+	} `json:"messages"`
+}
+
+// upgrade is the version 2 export of e. Attachments weren't timestamped, they're dated from their message
+func (e exportV1) upgrade() ConversationExport {
+	out := ConversationExport{
+		SchemaVersion: SchemaVersion,
+		Conversation: Conversation{
+			ConversationId: e.ConversationId,
+			Name:           e.Name,
+			UserId:         e.UserId,
+			ModelName:      e.ModelName,
+			CreatedAt:      e.CreatedAt,
+		},
+		Tags:        []string{},
+		Messages:    make([]Message, len(e.Messages)),
+		Attachments: []Attachment{},
+	}
+	for i, m := range e.Messages {
+		out.Messages[i] = Message{
+			MessageId:  m.MessageId,
+			ParentId:   m.ParentId,
+			Role:       m.Role,
+			Model:      m.Model,
+			Content:    m.Content,
+			ToolName:   m.ToolName,
+			ToolCallId: m.ToolCallId,
+			CreatedAt:  m.CreatedAt,
+		}
+		for _, a := range m.Attachments {
+			out.Attachments = append(out.Attachments, Attachment{
+				MessageId:   m.MessageId,
+				Filename:    a.Filename,
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				StorageURL:  a.StorageURL,
+				CreatedAt:   m.CreatedAt,
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package portable
+
+import (
+	"chat-history/structs"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMarshal(t *testing.T) {
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	convo := structs.Conversation{UserId: "sam", ConversationId: uuid.New(), Name: "Fraud rings", ModelName: "gpt-4o", SystemPrompt: "Be brief", Tags: []string{"fraud", "q2"}, Metadata: map[string]string{"source": "crm"}}
+	convo.CreatedAt = created
+	convo.UpdatedAt = created.Add(time.Hour)
+	question := structs.Message{ConversationId: convo.ConversationId, MessageId: uuid.New(), Role: structs.UserRole, Content: "Which accounts share a phone number?"}
+	question.CreatedAt = created
+	answer := structs.Message{
+		ConversationId: convo.ConversationId, MessageId: uuid.New(), ParentId: &question.MessageId, Role: structs.SystemRole,
+		ModelName: "gpt-4o", Content: "Accounts 12 and 42 do.", ResponseTime: 1.5, Feedback: structs.ThumbsUp, Comment: "right",
+	}
+	answer.CreatedAt = created.Add(time.Second)
+	attachment := structs.Attachment{MessageId: question.MessageId, Filename: "accounts.csv", ContentType: "text/csv", Size: 512, StorageURL: "https://files.example.com/accounts.csv", CreatedAt: created}
+
+	data, err := New(convo, []structs.Message{question, answer}, []structs.Attachment{attachment}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SchemaVersion != SchemaVersion {
+		t.Fatalf("the export should be of version %d, it's %d", SchemaVersion, e.SchemaVersion)
+	}
+	gotConvo, gotMessages, gotAttachments := e.Data()
+	if !reflect.DeepEqual(gotConvo, convo) {
+		t.Fatalf("the conversation should be read back as it was.\nGot:  %+v\nWant: %+v", gotConvo, convo)
+	}
+	if !reflect.DeepEqual(gotMessages, []structs.Message{question, answer}) {
+		t.Fatalf("the messages should be read back as they were: %+v", gotMessages)
+	}
+	if !reflect.DeepEqual(gotAttachments, []structs.Attachment{attachment}) {
+		t.Fatalf("the attachments should be read back as they were: %+v", gotAttachments)
+	}
+}
+
+func TestUnmarshal_V1(t *testing.T) {
+	data, err := os.ReadFile("testdata/v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SchemaVersion != SchemaVersion || e.Conversation.Name != "Fraud rings" || e.Conversation.ModelName != "gpt-4o" || e.Tags == nil {
+		t.Fatalf("the export should be upgraded to version %d: %+v", SchemaVersion, e)
+	}
+	if len(e.Messages) != 2 || e.Messages[1].ParentId == nil || *e.Messages[1].ParentId != e.Messages[0].MessageId || e.Messages[1].Model != "gpt-4o" {
+		t.Fatalf("the messages should be kept with their parents: %+v", e.Messages)
+	}
+	// attachments are on their messages in version 1, and dated from them
+	want := []Attachment{{
+		MessageId: e.Messages[0].MessageId, Filename: "accounts.csv", ContentType: "text/csv", Size: 512,
+		StorageURL: "https://files.example.com/accounts.csv", CreatedAt: e.Messages[0].CreatedAt,
+	}}
+	if !reflect.DeepEqual(e.Attachments, want) {
+		t.Fatalf("the attachments should be moved out of their messages: %+v", e.Attachments)
+	}
+}
+
+func TestUnmarshal_Errors(t *testing.T) {
+	if _, err := Unmarshal([]byte(fmt.Sprintf(`{"schema_version": %d}`, SchemaVersion+1))); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("a newer version should be ErrUnsupportedVersion, got %v", err)
+	}
+	if _, err := Unmarshal([]byte(`[{"content": "hi"}]`)); err == nil || errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("what isn't an export should be a JSON error, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+// Package requestid carries the id of the request being handled, so log lines and the
+// requests made to TigerGraph while handling it can be matched up
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header is the header the id is read from and sent in
+const Header = "X-Request-ID"
+
+// the longest id accepted from a caller
+const maxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id in ctx, or "" if there isn't one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// New returns a random id
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether an id sent by a caller can be used as is. Ids are written to the logs
+// and sent on to TigerGraph, so they're limited to printable ASCII without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
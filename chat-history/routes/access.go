@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"chat-history/audit"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+)
+
+// ownerOnly is the permission of the handlers only the owner and superusers can use. No grant allows it
+const ownerOnly = ""
+
+type accessRequest struct {
+	Permission string `json:"permission"`
+}
+
+// Give another user read or write access to a conversation, replacing the access they had
+// "PUT /conversation/{conversationId}/access/{userId}" with {"permission": "read" | "write"}
+// Only the owner and superusers manage who has access. Callers still need the conversation access roles.
+// Every grant is written to the audit log
+func GrantAccess(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		ownerId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+		grantedBy, _ := caller(r)
+
+		var req accessRequest
+		if apiErr := decodeBody(r, &req, `body must be {"permission": "read" | "write"}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		access := structs.ConversationAccess{UserId: r.PathValue("userId"), Permission: req.Permission, GrantedBy: grantedBy}
+		entry := audit.Entry{Action: "grant_access", UserId: ownerId, ConversationId: r.PathValue("conversationId"), NewUserId: access.UserId, Permission: access.Permission}
+		if !recordAccess(w, r, auditLog, entry) {
+			return
+		}
+		grant, err := store.GrantAccess(ownerId, r.PathValue("conversationId"), access)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to grant access"))
+			return
+		}
+		if out, err := marshal(r, grant); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Take away a user's access to a conversation
+// "DELETE /conversation/{conversationId}/access/{userId}"
+// Every revocation is written to the audit log
+func RevokeAccess(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		ownerId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+
+		userId := r.PathValue("userId")
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "revoke_access", UserId: ownerId, ConversationId: r.PathValue("conversationId"), NewUserId: userId}) {
+			return
+		}
+		if err := store.RevokeAccess(ownerId, r.PathValue("conversationId"), userId); err != nil {
+			notFound := fmt.Sprintf("%s has no access to conversation %s", userId, r.PathValue("conversationId"))
+			writeError(w, storeError(err, notFound, "failed to revoke access"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// List the users granted access to a conversation, in the order they were first granted it
+// "GET /conversation/{conversationId}/access"
+func ListAccess(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		ownerId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+
+		grants, err := store.ListAccess(ownerId, r.PathValue("conversationId"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve access"))
+			return
+		}
+		if out, err := marshal(r, grants); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// allowed reports whether the caller, userId, can use convo with permission: they own it, are a superuser,
+// or were granted it
+func allowed(r *http.Request, store db.ConversationStore, userId string, convo *structs.Conversation, permission string) bool {
+	if convo.UserId == userId || isSuperuser(r) {
+		return true
+	}
+	if permission == ownerOnly {
+		return false
+	}
+	grant, err := store.GetAccess(userId, convo.ConversationId.String())
+	return err == nil && grant.Allows(permission)
+}
+
+// actAs returns the user to act as on the conversation: its owner if the caller, userId, is allowed
+// permission on it, or else the caller, so the store only finds their own conversations
+func actAs(r *http.Request, store db.ConversationStore, userId, conversationId, permission string) string {
+	if permission == ownerOnly && !isSuperuser(r) {
+		return userId
+	}
+	if c, err := store.FindConversation(conversationId); err == nil && allowed(r, store, userId, c, permission) {
+		return c.UserId
+	}
+	return userId
+}
@@ -0,0 +1,156 @@
+package routes
+
+import (
+	"chat-history/config"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationAccess(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{
+		"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}, "Mr_Nobody": {"globaldesigner"},
+	})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/access/{userId}", withRoles(GrantAccess(store, testAuditLog(t))))
+	mux.Handle("DELETE /conversation/{conversationId}/access/{userId}", withRoles(RevokeAccess(store, testAuditLog(t))))
+	mux.Handle("GET /conversation/{conversationId}/access", withRoles(ListAccess(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+	mux.Handle("DELETE /conversation/{conversationId}", withRoles(DeleteConversation(store, testAuditLog(t))))
+	mux.Handle("POST /conversation", withRoles(UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil)))
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}", withRoles(EditMessage(store)))
+	mux.Handle("POST /conversation/{conversationId}/shares", withRoles(CreateShareLink(store, testAuditLog(t))))
+
+	do := func(method, path, user string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	accessPath := fmt.Sprintf("/conversation/%s/access", CONVO_ID)
+	grant := func(by, user, permission string) *httptest.ResponseRecorder {
+		return do(http.MethodPut, accessPath+"/"+user, by, fmt.Sprintf(`{"permission": %q}`, permission))
+	}
+	read := func(user string) []structs.Message {
+		t.Helper()
+		resp := do(http.MethodGet, "/conversation/"+CONVO_ID, user, "")
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var messages []structs.Message
+		json.Unmarshal(resp.Body.Bytes(), &messages)
+		return messages
+	}
+	reply := func(user string) *httptest.ResponseRecorder {
+		msg, _ := json.Marshal(structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "a reply", Role: structs.UserRole})
+		return do(http.MethodPost, "/conversation", user, string(msg))
+	}
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("the conversation should have messages. Got %d, %v", len(messages), err)
+	}
+	editPath := fmt.Sprintf("/conversation/%s/messages/%s", CONVO_ID, messages[0].MessageId)
+	edit := func(user string) *httptest.ResponseRecorder {
+		return do(http.MethodPut, editPath, user, `{"content": "edited"}`)
+	}
+
+	// without a grant, other users get nothing
+	if got := read("Miss_Take"); len(got) != 0 {
+		t.Fatalf("the conversation shouldn't be readable without access: %+v", got)
+	}
+
+	// read-only access
+	resp := grant(USER, "Miss_Take", structs.PermissionRead)
+	var access structs.ConversationAccess
+	json.Unmarshal(resp.Body.Bytes(), &access)
+	if resp.Code != 200 || access.UserId != "Miss_Take" || access.Permission != structs.PermissionRead || access.GrantedBy != USER {
+		t.Fatalf("the grant should be returned. Got %v: %s", resp.Code, resp.Body)
+	}
+	if got := read("Miss_Take"); len(got) != len(messages) {
+		t.Fatalf("the grantee should read the conversation's %d messages. Got %d", len(messages), len(got))
+	}
+	if resp := reply("Miss_Take"); resp.Code != http.StatusForbidden {
+		t.Fatalf("read access shouldn't allow adding messages. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := edit("Miss_Take"); resp.Code != http.StatusNotFound {
+		t.Fatalf("read access shouldn't allow editing messages. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// grantees can't escalate: give themselves or others access, see who has it, or do what only the owner can
+	escalations := []struct {
+		name string
+		resp *httptest.ResponseRecorder
+	}{
+		{"upgrade own access", grant("Miss_Take", "Miss_Take", structs.PermissionWrite)},
+		{"grant others", grant("Miss_Take", "Mr_Nobody", structs.PermissionRead)},
+		{"list access", do(http.MethodGet, accessPath, "Miss_Take", "")},
+		{"revoke a grant", do(http.MethodDelete, accessPath+"/Mr_Nobody", "Miss_Take", "")},
+		{"delete", do(http.MethodDelete, "/conversation/"+CONVO_ID, "Miss_Take", "")},
+		{"share", do(http.MethodPost, fmt.Sprintf("/conversation/%s/shares", CONVO_ID), "Miss_Take", "")},
+	}
+	for _, tt := range escalations {
+		if tt.resp.Code != http.StatusNotFound {
+			t.Fatalf("%s: the grantee should get 404. Got %v: %s", tt.name, tt.resp.Code, tt.resp.Body)
+		}
+	}
+	if got := read(USER); len(got) != len(messages) {
+		t.Fatalf("the conversation should be left as it was. It has %d messages", len(got))
+	}
+
+	// write access, which still doesn't let them manage access
+	if resp := grant(USER, "Miss_Take", structs.PermissionWrite); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := reply("Miss_Take"); resp.Code != 200 {
+		t.Fatalf("write access should allow adding messages. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := edit("Miss_Take"); resp.Code != 200 {
+		t.Fatalf("write access should allow editing messages. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := grant("Miss_Take", "Mr_Nobody", structs.PermissionRead); resp.Code != http.StatusNotFound {
+		t.Fatalf("write access shouldn't allow granting access. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// admins manage access to any conversation
+	if resp := grant("admin", "Mr_Nobody", structs.PermissionRead); resp.Code != 200 || !strings.Contains(resp.Body.String(), `"granted_by":"admin"`) {
+		t.Fatalf("the admin's grant should be returned. Got %v: %s", resp.Code, resp.Body)
+	}
+	resp = do(http.MethodGet, accessPath, "admin", "")
+	var grants []structs.ConversationAccess
+	json.Unmarshal(resp.Body.Bytes(), &grants)
+	if resp.Code != 200 || len(grants) != 2 || grants[0].UserId != "Miss_Take" || grants[1].UserId != "Mr_Nobody" {
+		t.Fatalf("both grants should be listed. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// grants that can't be made
+	if resp := grant(USER, "Mr_Nobody", "admin"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("an unknown permission should be rejected. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := grant(USER, USER, structs.PermissionRead); resp.Code != http.StatusBadRequest {
+		t.Fatalf("the owner can't be granted access. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// revoked
+	if resp := do(http.MethodDelete, accessPath+"/Miss_Take", USER, ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if got := read("Miss_Take"); len(got) != 0 {
+		t.Fatalf("the conversation shouldn't be readable after the grant is revoked: %+v", got)
+	}
+	if resp := reply("Miss_Take"); resp.Code != http.StatusForbidden {
+		t.Fatalf("revoked access shouldn't allow adding messages. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodDelete, accessPath+"/Miss_Take", USER, ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("revoking again should be not found. Got %v: %s", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,343 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/audit"
+	"chat-history/db"
+	"chat-history/requestid"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// List any user's conversations, for support staff. Callers need one of the admin roles (see RequireAdmin)
+// "GET /admin/user/{userId}?limit=int&cursor=string&tag=string&language=string&archived=bool"
+// It takes the same parameters as GET /user/{userId}. Every access is written to the audit log
+func AdminListConversations(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "list_conversations", UserId: userId}) {
+			return
+		}
+		listConversations(w, r, store, userId)
+	}
+}
+
+// Read any user's conversation, for support staff. Callers need one of the admin roles (see RequireAdmin)
+// "GET /admin/conversation/{conversationId}?merge=bool"
+// Every access is written to the audit log
+func AdminGetConversation(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		merge := strings.ToLower(r.URL.Query().Get("merge")) == "true"
+
+		convo, err := store.FindConversation(conversationId)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "read_conversation", UserId: convo.UserId, ConversationId: conversationId}) {
+			return
+		}
+
+		messages, err := store.GetConversation(convo.UserId, conversationId)
+		if err != nil {
+			writeError(w, apierror.Internal("failed to retrieve conversation"))
+			return
+		}
+		if merge {
+			messages = mergeConversationHistory(messages)
+		}
+		if out, err := marshal(r, messages); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// how many conversations GET /admin/conversations returns unless it's given a limit, and the most it returns
+const (
+	defaultRecentPageSize = 50
+	maxRecentPageSize     = 500
+)
+
+// List the most recently active conversations of every user, i.e., for a dashboard. Callers need one of the
+// admin roles (see RequireAdmin)
+// "GET /admin/conversations?limit=int&cursor=string"
+// They're most recently updated first, with their owner, message count and when their latest message was written.
+// limit is 50 unless it's set, at most 500, and the cursor for the next page is returned in the X-Next-Cursor
+// header (empty on the last page). Every listing is written to the audit log
+func AdminRecentConversations(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		limit := defaultRecentPageSize
+		if l := r.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxRecentPageSize {
+				writeError(w, apierror.InvalidRequest(fmt.Sprintf("limit must be an integer from 1 to %d", maxRecentPageSize)))
+				return
+			}
+			limit = n
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "list_recent_conversations"}) {
+			return
+		}
+
+		conversations, next, err := store.RecentConversations(limit, r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to retrieve conversations"))
+			return
+		}
+		if out, err := marshal(r, conversations); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Header().Set("X-Next-Cursor", next)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// UserExists reports whether there's a TigerGraph user named userId, looked up with the caller's credentials
+type UserExists func(ctx context.Context, username, password, userId string) (bool, error)
+
+// TigerGraphUsers looks the user up by running SHOW USER on TigerGraph with the caller's credentials
+func TigerGraphUsers(hostname, gsPort string) UserExists {
+	return func(ctx context.Context, username, password, userId string) (bool, error) {
+		userInfo, err := executeGSQL(ctx, hostname, username, password, "SHOW USER", gsPort)
+		if err != nil {
+			return false, err
+		}
+		return hasUser(userInfo, userId), nil
+	}
+}
+
+// hasUser checks if there's a section for the user in the SHOW USER output
+func hasUser(userInfo, userId string) bool {
+	for _, line := range strings.Split(userInfo, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "- Name:"); ok && strings.TrimSpace(name) == userId {
+			return true
+		}
+	}
+	return false
+}
+
+type transferRequest struct {
+	UserId string `json:"user_id"`
+}
+
+// Give a conversation to another user, i.e., when its owner leaves. Callers need one of the admin roles (see RequireAdmin)
+// "POST /admin/conversation/{conversationId}/transfer"
+// The body is {"user_id": "new owner"}, who must be a TigerGraph user. Every transfer is written to the audit log
+func AdminTransferOwnership(store db.ConversationStore, auditLog *audit.Log, userExists UserExists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+
+		var body transferRequest
+		if apiErr := decodeBody(r, &body, `body must be {"user_id": "new owner"}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		newUserId := strings.TrimSpace(body.UserId)
+		if newUserId == "" {
+			writeError(w, apierror.InvalidRequest("user_id is required"))
+			return
+		}
+
+		convo, err := store.FindConversation(conversationId)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+			return
+		}
+		usr, pass, _ := r.BasicAuth()
+		exists, err := userExists(r.Context(), usr, pass, newUserId)
+		if err != nil {
+			slog.Error("failed to look up user", "user_id", newUserId, "err", err)
+			writeError(w, apierror.Internal("failed to look up the user"))
+			return
+		}
+		if !exists {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("user %s does not exist", newUserId)))
+			return
+		}
+
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "transfer_ownership", UserId: convo.UserId, ConversationId: conversationId, NewUserId: newUserId}) {
+			return
+		}
+		if err := store.TransferOwnership(conversationId, newUserId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to transfer conversation"))
+			return
+		}
+
+		convo.UserId = newUserId
+		if out, err := marshal(r, convo); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+type deleteUserDataResponse struct {
+	UserId               string `json:"user_id"`
+	DeletedConversations int64  `json:"deleted_conversations"`
+}
+
+// Permanently delete all of a user's conversations, i.e., for a data deletion request. Callers need one of
+// the admin roles (see RequireAdmin)
+// "DELETE /admin/user/{userId}"
+// The trash and the archive are cleared too. It can be repeated, there's nothing left to delete the second time.
+// Every deletion is written to the audit log, with who asked and whose data it was but none of the data
+func AdminDeleteUserData(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "delete_user_data", UserId: userId}) {
+			return
+		}
+		n, err := store.DeleteAllForUser(userId)
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to delete the user's conversations"))
+			return
+		}
+
+		if out, err := marshal(r, deleteUserDataResponse{UserId: userId, DeletedConversations: n}); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Compact the database and refresh its statistics, i.e., after a lot of conversations were purged. Callers need
+// one of the admin roles (see RequireAdmin)
+// "POST /admin/maintenance"
+// It responds with what it freed (see db.MaintenanceReport) once it's done, 409 if a run is already going.
+// Requests are served meanwhile, though they can wait while the file is rebuilt. Every run is written to the audit log
+func AdminRunMaintenance(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, ok := store.WithContext(r.Context()).(db.Maintainer)
+		if !ok {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "the store can't be compacted"))
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "run_maintenance"}) {
+			return
+		}
+		report, err := m.RunMaintenance()
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to compact the database"))
+			return
+		}
+		slog.Info("compacted the database", "freed_bytes", report.Freed, "duration_ms", report.DurationMs)
+
+		if out, err := marshal(r, report); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Rebuild the full-text search index from the messages, i.e., when searches miss messages after a large import.
+// Callers need one of the admin roles (see RequireAdmin)
+// "POST /admin/search/reindex"
+// It responds with 202 and the progress (see db.ReindexProgress) once the rebuild started in the background, or
+// the progress of the one already running. Searches use the old index until the new one is done, and requests are
+// served meanwhile. Every run is written to the audit log
+func AdminReindexSearch(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reindexer, ok := store.WithContext(r.Context()).(db.Reindexer)
+		if !ok {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "the store can't rebuild its search index"))
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "reindex_search"}) {
+			return
+		}
+		progress, err := reindexer.ReindexSearch()
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to rebuild the search index"))
+			return
+		}
+
+		if out, err := marshal(r, progress); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write(out)
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get the progress of the latest rebuild of the search index. Callers need one of the admin roles (see RequireAdmin)
+// "GET /admin/search/reindex"
+// It's 404 if none was started since the service started
+func AdminReindexProgress(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reindexer, ok := store.WithContext(r.Context()).(db.Reindexer)
+		if !ok {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "the store can't rebuild its search index"))
+			return
+		}
+		progress := reindexer.ReindexProgress()
+		if progress == nil {
+			writeError(w, apierror.NotFound("the search index wasn't rebuilt since the service started"))
+			return
+		}
+		if out, err := marshal(r, progress); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(out)
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// RoleInvalidator forgets the roles remembered for a user, so they're looked up again on their next request.
+// authn.RoleCache implements it
+type RoleInvalidator interface {
+	Invalidate(userId string)
+}
+
+// Look a user's roles up again on their next request, i.e., right after they're changed in TigerGraph. Callers
+// need one of the admin roles (see RequireAdmin)
+// "POST /admin/user/{userId}/roles/refresh"
+// Until then the roles are remembered for auth_config.role_cache_ttl_seconds. It responds with 204, whether
+// or not they were remembered. Every refresh is written to the audit log
+func AdminRefreshRoles(roles RoleInvalidator, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.PathValue("userId")
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "refresh_roles", UserId: userId}) {
+			return
+		}
+		roles.Invalidate(userId)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// recordAccess writes the caller's access to the audit log before any data is sent or changed.
+// If it can't be written the request fails, so there's no access or change that isn't audited
+func recordAccess(w http.ResponseWriter, r *http.Request, auditLog *audit.Log, entry audit.Entry) bool {
+	entry.Actor, _ = caller(r)
+	entry.Roles = RolesFromContext(r.Context())
+	entry.RequestId = requestid.FromContext(r.Context())
+	if err := auditLog.Record(entry); err != nil {
+		slog.Error("failed to write the audit log", "err", err)
+		writeError(w, apierror.Internal("failed to write the audit log"))
+		return false
+	}
+	return true
+}
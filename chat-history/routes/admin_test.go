@@ -0,0 +1,578 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"chat-history/audit"
+	"chat-history/authn"
+	"chat-history/db"
+	"chat-history/middleware"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func setupAdmin(t *testing.T, adminRoles []string) (http.Handler, string) {
+	handler, _, pth := setupAdminStore(t, adminRoles)
+	return handler, pth
+}
+
+// fakeUsers is a UserExists that knows the users
+func fakeUsers(users ...string) UserExists {
+	return func(ctx context.Context, username, password, userId string) (bool, error) {
+		if userId == "broken" {
+			return false, errors.New("tigergraph is down")
+		}
+		return slices.Contains(users, userId), nil
+	}
+}
+
+// openAuditLog opens an audit log in a temp dir, it's closed when the test ends
+func openAuditLog(t *testing.T) (*audit.Log, string) {
+	t.Helper()
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+	return auditLog, pth
+}
+
+// testAuditLog is an audit log for the tests that don't read it
+func testAuditLog(t *testing.T) *audit.Log {
+	t.Helper()
+	auditLog, _ := openAuditLog(t)
+	return auditLog
+}
+
+func setupAdminStore(t *testing.T, adminRoles []string) (http.Handler, db.ConversationStore, string) {
+	store := setupDB(t, true)
+	auditLog, pth := openAuditLog(t)
+
+	resolve := fakeRoles(map[string][]string{"support": {"supportstaff"}, USER: {"globaldesigner"}, "broken": nil})
+	requireAdmin := RequireAdmin(func() []string { return adminRoles }, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/user/{userId}", requireAdmin(AdminListConversations(store, auditLog)))
+	mux.Handle("GET /admin/conversations", requireAdmin(AdminRecentConversations(store, auditLog)))
+	mux.Handle("GET /admin/conversation/{conversationId}", requireAdmin(AdminGetConversation(store, auditLog)))
+	mux.Handle("POST /admin/conversation/{conversationId}/transfer", requireAdmin(AdminTransferOwnership(store, auditLog, fakeUsers(USER, "new_hire"))))
+	mux.Handle("DELETE /admin/user/{userId}", requireAdmin(AdminDeleteUserData(store, auditLog)))
+	mux.Handle("GET /admin/feedback", requireAdmin(AdminExportFeedback(store, auditLog)))
+	mux.Handle("GET /admin/message/{messageId}/llm-io", requireAdmin(AdminGetLLMExchange(store, auditLog)))
+	mux.Handle("POST /admin/maintenance", requireAdmin(AdminRunMaintenance(store, auditLog)))
+	mux.Handle("POST /admin/search/reindex", requireAdmin(AdminReindexSearch(store, auditLog)))
+	mux.Handle("GET /admin/search/reindex", requireAdmin(AdminReindexProgress(store)))
+	return middleware.ChainMiddleware(mux, middleware.RequestID()), store, pth
+}
+
+func adminRequest(handler http.Handler, path, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func readAudit(t *testing.T, pth string) []audit.Entry {
+	t.Helper()
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("audit line is not valid JSON: %s", scanner.Text())
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAdmin(t *testing.T) {
+	handler, pth := setupAdmin(t, []string{"supportstaff"})
+
+	// support reads USER's conversations without owning them
+	resp := adminRequest(handler, fmt.Sprintf("/admin/user/%s", USER), "support")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var convos []structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &convos)
+	if len(convos) == 0 || convos[0].UserId != USER {
+		t.Fatalf("the user's conversations should be listed: %s", resp.Body)
+	}
+	listId := resp.Header().Get("X-Request-ID")
+
+	resp = adminRequest(handler, fmt.Sprintf("/admin/conversation/%s?merge=true", CONVO_ID), "support")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var messages []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if len(messages) == 0 || messages[0].ConversationId.String() != CONVO_ID {
+		t.Fatalf("the conversation's messages should be returned: %s", resp.Body)
+	}
+	readId := resp.Header().Get("X-Request-ID")
+
+	if resp := adminRequest(handler, "/admin/conversation/4f6c5ef9-1b49-4a57-a0a5-10e0f1498c4d", "support"); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+
+	// both accesses are audited, with who did it and whose data it was
+	entries := readAudit(t, pth)
+	if len(entries) != 2 {
+		t.Fatalf("there should be an audit entry per access. There are %d: %+v", len(entries), entries)
+	}
+	list, read := entries[0], entries[1]
+	if list.Actor != "support" || list.Action != "list_conversations" || list.UserId != USER || list.RequestId != listId || list.Timestamp.IsZero() {
+		t.Fatalf("listing should be audited: %+v", list)
+	}
+	if read.Actor != "support" || read.Action != "read_conversation" || read.UserId != USER ||
+		read.ConversationId != CONVO_ID || read.RequestId != readId || len(read.Roles) != 1 || read.Roles[0] != "supportstaff" {
+		t.Fatalf("reading should be audited: %+v", read)
+	}
+}
+
+func TestAdmin_NonAdmin(t *testing.T) {
+	handler, pth := setupAdmin(t, []string{"supportstaff"})
+
+	// a regular user can't use it, even for their own conversations
+	for _, path := range []string{fmt.Sprintf("/admin/user/%s", USER), fmt.Sprintf("/admin/conversation/%s", CONVO_ID)} {
+		if resp := adminRequest(handler, path, USER); resp.Code != 403 {
+			t.Fatalf("Response code for %s should be 403. It is: %v", path, resp.Code)
+		}
+	}
+	if resp := adminRequest(handler, fmt.Sprintf("/admin/user/%s", USER), "broken"); resp.Code != 500 {
+		t.Fatalf("Response code should be 500 when roles can't be looked up. It is: %v", resp.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/admin/user/%s", USER), nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != 401 {
+		t.Fatalf("Response code without credentials should be 401. It is: %v", resp.Code)
+	}
+
+	if entries := readAudit(t, pth); len(entries) != 0 {
+		t.Fatalf("rejected requests didn't access anything and shouldn't be audited: %+v", entries)
+	}
+}
+
+func TestAdminRecentConversations(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+	for _, user := range []string{"Miss_Take", "new_hire"} {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "hi", Role: structs.UserRole}
+		if _, err := store.CreateConversation(user, "convo", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := adminRequest(handler, "/admin/conversations", "support")
+	if resp.Code != 200 || resp.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("Response code should be 200 with every conversation. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var all []structs.ActiveConversation
+	json.Unmarshal(resp.Body.Bytes(), &all)
+	if len(all) < 3 || all[0].UserId != "new_hire" || all[1].UserId != "Miss_Take" || all[0].MessageCount != 1 || all[0].LastMessageAt == nil {
+		t.Fatalf("every user's conversations should be listed, the most recently updated first: %s", resp.Body)
+	}
+	if !slices.IsSortedFunc(all, func(a, b structs.ActiveConversation) int { return b.UpdatedAt.Compare(a.UpdatedAt) }) {
+		t.Fatalf("the conversations should be sorted by when they were updated: %s", resp.Body)
+	}
+
+	// a page at a time, the same conversations
+	var paged []structs.ActiveConversation
+	path := "/admin/conversations?limit=2"
+	for path != "" {
+		resp := adminRequest(handler, path, "support")
+		var page []structs.ActiveConversation
+		json.Unmarshal(resp.Body.Bytes(), &page)
+		if resp.Code != 200 || len(page) > 2 {
+			t.Fatalf("a page should have at most 2 conversations. Got %v: %s", resp.Code, resp.Body)
+		}
+		paged = append(paged, page...)
+		path = ""
+		if next := resp.Header().Get("X-Next-Cursor"); next != "" {
+			path = "/admin/conversations?limit=2&cursor=" + next
+		}
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("paging should return all %d conversations once. Got %d", len(all), len(paged))
+	}
+	for i := range all {
+		if paged[i].ConversationId != all[i].ConversationId {
+			t.Fatalf("the pages should be in the same order: %+v", paged)
+		}
+	}
+
+	for _, path := range []string{"/admin/conversations?limit=0", "/admin/conversations?limit=501", "/admin/conversations?cursor=nope"} {
+		if resp := adminRequest(handler, path, "support"); resp.Code != 400 {
+			t.Fatalf("Response code for %s should be 400. It is: %v", path, resp.Code)
+		}
+	}
+	if resp := adminRequest(handler, "/admin/conversations", USER); resp.Code != 403 {
+		t.Fatalf("only admins can list every user's conversations. Response code should be 403. It is: %v", resp.Code)
+	}
+	// the listing with a bad cursor was audited before it failed
+	entries := readAudit(t, pth)
+	if len(entries) != 2+(len(all)+1)/2 || entries[0].Action != "list_recent_conversations" || entries[0].Actor != "support" {
+		t.Fatalf("each listing should be audited: %+v", entries)
+	}
+}
+
+func TestAdmin_NoAdminRoles(t *testing.T) {
+	handler, _ := setupAdmin(t, nil)
+	if resp := adminRequest(handler, fmt.Sprintf("/admin/user/%s", USER), "support"); resp.Code != 403 {
+		t.Fatalf("nobody should be an admin without adminRoles. Response code is: %v", resp.Code)
+	}
+}
+
+func TestAdmin_AuditFails(t *testing.T) {
+	store := setupDB(t, true)
+	auditLog, err := audit.Open(fmt.Sprintf("%s/%s", t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/conversation/{conversationId}", AdminGetConversation(store, auditLog))
+
+	// nothing is returned if the access can't be audited
+	resp := adminRequest(mux, fmt.Sprintf("/admin/conversation/%s", CONVO_ID), "support")
+	if resp.Code != 500 || bytes.Contains(resp.Body.Bytes(), []byte("message_id")) {
+		t.Fatalf("Response code should be 500 without the conversation. It is: %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func postTransfer(handler http.Handler, conversationId, user, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/conversation/%s/transfer", conversationId), strings.NewReader(body))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestAdminTransferOwnership(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+
+	resp := postTransfer(handler, CONVO_ID, "support", `{"user_id":"new_hire"}`)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var convo structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &convo)
+	if convo.UserId != "new_hire" || convo.ConversationId.String() != CONVO_ID {
+		t.Fatalf("the transferred conversation should be returned: %s", resp.Body)
+	}
+	if messages, err := store.GetConversation("new_hire", CONVO_ID); err != nil || len(messages) == 0 {
+		t.Fatalf("the new owner should have the messages. Got %d, %v", len(messages), err)
+	}
+	if messages, _ := store.GetConversation(USER, CONVO_ID); len(messages) != 0 {
+		t.Fatal("the old owner shouldn't have the messages anymore")
+	}
+
+	entries := readAudit(t, pth)
+	if len(entries) != 1 {
+		t.Fatalf("the transfer should be audited. There are %d entries", len(entries))
+	}
+	e := entries[0]
+	if e.Actor != "support" || e.Action != "transfer_ownership" || e.UserId != USER || e.NewUserId != "new_hire" || e.ConversationId != CONVO_ID {
+		t.Fatalf("audit entry is wrong: %+v", e)
+	}
+}
+
+func TestAdminTransferOwnership_Errors(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+
+	tests := []struct {
+		name, conversationId, user, body string
+		status                           int
+	}{
+		{"nonexistent target", CONVO_ID, "support", `{"user_id":"nobody"}`, 400},
+		{"no target", CONVO_ID, "support", `{"user_id":" "}`, 400},
+		{"invalid body", CONVO_ID, "support", `nope`, 400},
+		{"users can't be looked up", CONVO_ID, "support", `{"user_id":"broken"}`, 500},
+		{"missing conversation", "4f6c5ef9-1b49-4a57-a0a5-10e0f1498c4d", "support", `{"user_id":"new_hire"}`, 404},
+		{"non-admin caller", CONVO_ID, USER, `{"user_id":"new_hire"}`, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := postTransfer(handler, tt.conversationId, tt.user, tt.body); resp.Code != tt.status {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.status, resp.Code, resp.Body)
+			}
+		})
+	}
+
+	// nothing moved and nothing was audited
+	if convo, err := store.FindConversation(CONVO_ID); err != nil || convo.UserId != USER {
+		t.Fatalf("the conversation should still be the owner's. Got %+v, %v", convo, err)
+	}
+	if entries := readAudit(t, pth); len(entries) != 0 {
+		t.Fatalf("failed transfers shouldn't be audited: %+v", entries)
+	}
+}
+
+func TestAdminDeleteUserData(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("the conversation should have messages. Got %v, %v", messages, err)
+	}
+	deleteData := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/user/"+USER, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// users can't erase themselves through /admin
+	if resp := deleteData(USER); resp.Code != 403 {
+		t.Fatalf("Response code should be 403. It is: %v", resp.Code)
+	}
+
+	for i, want := range []int64{1, 0} {
+		resp := deleteData("support")
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var body deleteUserDataResponse
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if body.UserId != USER || body.DeletedConversations != want {
+			t.Fatalf("deletion %d should delete %d conversations: %s", i+1, want, resp.Body)
+		}
+	}
+	if convos, _, err := store.ListConversations(USER, db.ListOptions{}); err != nil || len(convos) != 0 {
+		t.Fatalf("the user shouldn't have any conversations left. Got %+v, %v", convos, err)
+	}
+	if messages, _ := store.GetConversation(USER, CONVO_ID); len(messages) != 0 {
+		t.Fatalf("the messages should be deleted. Got %+v", messages)
+	}
+
+	// both deletions are audited, without any of what was deleted
+	entries := readAudit(t, pth)
+	if len(entries) != 2 {
+		t.Fatalf("both deletions should be audited. There are %d entries", len(entries))
+	}
+	for _, e := range entries {
+		if e.Actor != "support" || e.Action != "delete_user_data" || e.UserId != USER || e.ConversationId != "" {
+			t.Fatalf("audit entry is wrong: %+v", e)
+		}
+	}
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(messages[0].Content)) {
+		t.Fatalf("the audit log shouldn't have the deleted content: %s", b)
+	}
+}
+
+func TestHasUser(t *testing.T) {
+	userInfo := `
+  - Name: tigergraph
+    - Global Roles: superuser
+  - Name: Lu Zhou
+    - Global Roles: globalobserver
+	`
+	if !hasUser(userInfo, "tigergraph") || !hasUser(userInfo, "Lu Zhou") {
+		t.Fatal("the listed users should be found")
+	}
+	if hasUser(userInfo, "Lu") || hasUser(userInfo, "superuser") {
+		t.Fatal("only whole user names should match")
+	}
+}
+
+func TestAdminRunMaintenance(t *testing.T) {
+	handler, _, pth := setupAdminStore(t, []string{"supportstaff"})
+
+	maintain := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/maintenance", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := maintain("support")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var report db.MaintenanceReport
+	if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil || report.SizeAfter == 0 {
+		t.Fatalf("the response should be the report: %s", resp.Body)
+	}
+	if entries := readAudit(t, pth); len(entries) != 1 || entries[0].Action != "run_maintenance" || entries[0].Actor != "support" {
+		t.Fatalf("the run should be audited: %+v", entries)
+	}
+	if resp := maintain(USER); resp.Code != 403 {
+		t.Fatalf("only admins can run maintenance. Response code should be 403. It is: %v", resp.Code)
+	}
+}
+
+func TestAdminReindexSearch(t *testing.T) {
+	handler, _, pth := setupAdminStore(t, []string{"supportstaff"})
+
+	reindex := func(method, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/search/reindex", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	if resp := reindex(http.MethodGet, "support"); resp.Code != http.StatusNotFound {
+		t.Fatalf("there's no progress before a rebuild. Response code should be 404. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp := reindex(http.MethodPost, "support")
+	if entries := readAudit(t, pth); len(entries) != 1 || entries[0].Action != "reindex_search" || entries[0].Actor != "support" {
+		t.Fatalf("the rebuild should be audited: %+v", entries)
+	}
+	if resp := reindex(http.MethodPost, USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("only admins can rebuild the index. Response code should be 403. It is: %v", resp.Code)
+	}
+	if resp.Code == http.StatusNotImplemented {
+		// built without the sqlite_fts5 tag, searches don't use an index
+		return
+	}
+	var progress db.ReindexProgress
+	if err := json.Unmarshal(resp.Body.Bytes(), &progress); resp.Code != http.StatusAccepted || err != nil || progress.StartedAt.IsZero() {
+		t.Fatalf("the response should be 202 with the progress. Got %v: %s", resp.Code, resp.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for progress.Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		resp := reindex(http.MethodGet, "support")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		json.Unmarshal(resp.Body.Bytes(), &progress)
+	}
+	if progress.Running || progress.FinishedAt == nil || progress.Error != "" || progress.Indexed != progress.Total {
+		t.Fatalf("the rebuild should finish with every message indexed. Got %+v", progress)
+	}
+}
+
+func TestAuditedChanges(t *testing.T) {
+	store := setupDB(t, true)
+	auditLog, pth := openAuditLog(t)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{"globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/access/{userId}", withRoles(GrantAccess(store, auditLog)))
+	mux.Handle("DELETE /conversation/{conversationId}/access/{userId}", withRoles(RevokeAccess(store, auditLog)))
+	mux.Handle("POST /conversation/{conversationId}/shares", withRoles(CreateShareLink(store, auditLog)))
+	mux.Handle("DELETE /conversation/{conversationId}/shares/{shareId}", withRoles(RevokeShareLink(store, auditLog)))
+	mux.Handle("DELETE /conversation/{conversationId}", withRoles(DeleteConversation(store, auditLog)))
+	handler := middleware.ChainMiddleware(mux, middleware.RequestID())
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	convoPath := "/conversation/" + CONVO_ID
+	if resp := do(http.MethodPut, convoPath+"/access/Miss_Take", `{"permission": "read"}`); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodDelete, convoPath+"/access/Miss_Take", ""); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp := do(http.MethodPost, convoPath+"/shares", "")
+	if resp.Code != 201 {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var link structs.ShareLink
+	json.Unmarshal(resp.Body.Bytes(), &link)
+	if resp := do(http.MethodDelete, convoPath+"/shares/"+link.ShareId.String(), ""); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodDelete, convoPath, ""); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	want := []audit.Entry{
+		{Action: "grant_access", NewUserId: "Miss_Take", Permission: structs.PermissionRead},
+		{Action: "revoke_access", NewUserId: "Miss_Take"},
+		{Action: "create_share_link"},
+		{Action: "revoke_share_link", ShareId: link.ShareId.String()},
+		{Action: "delete_conversation"},
+	}
+	entries := readAudit(t, pth)
+	if len(entries) != len(want) {
+		t.Fatalf("each change should be audited, want %d entries: %+v", len(want), entries)
+	}
+	for i, e := range entries {
+		w := want[i]
+		if e.Action != w.Action || e.Actor != USER || e.UserId != USER || e.ConversationId != CONVO_ID || e.NewUserId != w.NewUserId ||
+			e.Permission != w.Permission || e.ShareId != w.ShareId || e.RequestId == "" || e.Timestamp.IsZero() {
+			t.Fatalf("entry %d should be %+v by %s. Got %+v", i, w, USER, e)
+		}
+	}
+
+	// a change that can't be audited isn't made
+	store.RestoreConversation(USER, CONVO_ID, time.Hour)
+	auditLog.Close()
+	if resp := do(http.MethodPut, convoPath+"/access/Miss_Take", `{"permission": "read"}`); resp.Code != 500 {
+		t.Fatalf("Response code should be 500. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if grants, err := store.ListAccess(USER, CONVO_ID); err != nil || len(grants) != 0 {
+		t.Fatalf("access shouldn't be granted without an audit entry. Got %+v, %v", grants, err)
+	}
+}
+
+func TestAdminRefreshRoles(t *testing.T) {
+	auditLog, pth := openAuditLog(t)
+	roles := map[string][]string{"support": {"supportstaff"}, USER: {"globalobserver"}}
+	cache := authn.NewRoleCache(fakeRoles(roles), time.Hour)
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/user/{userId}/roles/refresh", RequireAdmin(func() []string { return []string{"supportstaff"} }, cache)(AdminRefreshRoles(cache, auditLog)))
+	mux.Handle("GET /whoami", RequireRoles([]string{"globalobserver", "globaldesigner"}, cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Join(RolesFromContext(r.Context()), ",")))
+	})))
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	refresh := fmt.Sprintf("/admin/user/%s/roles/refresh", USER)
+
+	if resp := do(http.MethodGet, "/whoami", USER); resp.Body.String() != "globalobserver" {
+		t.Fatalf("the caller should have their roles. Got %v: %s", resp.Code, resp.Body)
+	}
+	// the change isn't seen while the roles are cached
+	roles[USER] = []string{"globaldesigner"}
+	if resp := do(http.MethodGet, "/whoami", USER); resp.Body.String() != "globalobserver" {
+		t.Fatalf("the cached roles should be used. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodPost, refresh, USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("only admins can refresh roles. Response code should be 403. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodPost, refresh, "support"); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodGet, "/whoami", USER); resp.Body.String() != "globaldesigner" {
+		t.Fatalf("the roles should be looked up again after the refresh. Got %v: %s", resp.Code, resp.Body)
+	}
+	if entries := readAudit(t, pth); len(entries) != 1 || entries[0].Action != "refresh_roles" || entries[0].UserId != USER || entries[0].Actor != "support" {
+		t.Fatalf("the refresh should be audited: %+v", entries)
+	}
+}
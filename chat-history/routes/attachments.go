@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+)
+
+type attachmentRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	StorageURL  string `json:"storage_url"`
+}
+
+// Attach a file or image to a message. Only its metadata is stored, the file stays at storage_url
+// "POST /conversation/{conversationId}/messages/{messageId}/attachments"
+// with {"filename": "...", "content_type": "...", "size": int, "storage_url": "..."}
+func AddAttachment(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+
+		var req attachmentRequest
+		if apiErr := decodeBody(r, &req, "body must be a JSON object with the attachment's metadata"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		attachment, err := store.AddAttachment(userId, r.PathValue("conversationId"), r.PathValue("messageId"), structs.Attachment{
+			Filename:    req.Filename,
+			ContentType: req.ContentType,
+			Size:        req.Size,
+			StorageURL:  req.StorageURL,
+		})
+		if err != nil {
+			writeError(w, storeError(err, messageNotFound(r), "failed to add attachment"))
+			return
+		}
+		if out, err := marshal(r, attachment); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get the attachments of a message, or of every message in the conversation, oldest first
+// "GET /conversation/{conversationId}/messages/{messageId}/attachments"
+// "GET /conversation/{conversationId}/attachments"
+func ListAttachments(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+
+		notFound := conversationNotFound(r)
+		if r.PathValue("messageId") != "" {
+			notFound = messageNotFound(r)
+		}
+		attachments, err := store.ListAttachments(userId, r.PathValue("conversationId"), r.PathValue("messageId"))
+		if err != nil {
+			writeError(w, storeError(err, notFound, "failed to retrieve attachments"))
+			return
+		}
+		if out, err := marshal(r, attachments); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
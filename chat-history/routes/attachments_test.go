@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/messages/{messageId}/attachments", withRoles(AddAttachment(store)))
+	mux.Handle("GET /conversation/{conversationId}/messages/{messageId}/attachments", withRoles(ListAttachments(store)))
+	mux.Handle("GET /conversation/{conversationId}/attachments", withRoles(ListAttachments(store)))
+
+	do := func(method, path, user string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("the conversation should have messages. Got %v, %v", messages, err)
+	}
+	attachPath := fmt.Sprintf("/conversation/%s/messages/%s/attachments", CONVO_ID, messages[0].MessageId)
+	body := `{"filename":"fraud.png","content_type":"image/png","size":2048,"storage_url":"https://files.example.com/fraud.png"}`
+
+	resp := do(http.MethodPost, attachPath, USER, strings.NewReader(body))
+	if resp.Code != 201 {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var added structs.Attachment
+	json.Unmarshal(resp.Body.Bytes(), &added)
+	if added.Filename != "fraud.png" || added.MessageId != messages[0].MessageId || added.Size != 2048 {
+		t.Fatalf("the attachment should be returned: %s", resp.Body)
+	}
+	// superusers can attach to any conversation
+	if resp := do(http.MethodPost, attachPath, "admin", strings.NewReader(body)); resp.Code != 201 {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	for _, path := range []string{attachPath, fmt.Sprintf("/conversation/%s/attachments", CONVO_ID)} {
+		resp = do(http.MethodGet, path, USER, nil)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var attachments []structs.Attachment
+		json.Unmarshal(resp.Body.Bytes(), &attachments)
+		if len(attachments) != 2 || attachments[0].AttachmentId != added.AttachmentId {
+			t.Fatalf("both attachments should be listed, oldest first: %s", resp.Body)
+		}
+	}
+
+	// other users can't attach or list, and can't tell the conversation exists
+	if resp := do(http.MethodPost, attachPath, "Miss_Take", strings.NewReader(body)); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodGet, attachPath, "Miss_Take", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type basePathKey struct{}
+
+// WithBasePath serves next under basePath, which must not end in /. Requests outside it are 404s, and the
+// rest get to next without it, so routes are registered as if there were none. An empty basePath returns next
+func WithBasePath(basePath string, next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, basePath)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			http.NotFound(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+
+		// like http.StripPrefix, which can't tell /chat from /chatter
+		r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath))
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// link is path under the base path r was served under
+func link(r *http.Request, path string) string {
+	basePath, _ := r.Context().Value(basePathKey{}).(string)
+	return basePath + path
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithBasePath(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+	mux.Handle("POST /conversation/{conversationId}/shares", withRoles(CreateShareLink(store, testAuditLog(t))))
+	mux.Handle("GET /conversation/{conversationId}/shares", withRoles(ListShareLinks(store)))
+	mux.HandleFunc("GET /shared/{token}", GetSharedConversation(store))
+	handler := WithBasePath("/chat-history", mux)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	convoPath := "/conversation/" + CONVO_ID
+	if resp := do(http.MethodGet, "/chat-history"+convoPath); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	for _, path := range []string{convoPath, "/chat-historyx" + convoPath, "/chat" + convoPath} {
+		if resp := do(http.MethodGet, path); resp.Code != 404 {
+			t.Fatalf("Response code of %s should be 404. It is: %v", path, resp.Code)
+		}
+	}
+
+	// the links are under the base path, and work
+	resp := do(http.MethodPost, "/chat-history"+convoPath+"/shares")
+	if resp.Code != 201 {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var link shareLink
+	json.Unmarshal(resp.Body.Bytes(), &link)
+	if want := "/chat-history/shared/" + link.Token; link.Token == "" || link.Path != want {
+		t.Fatalf("the path should be %s. It is: %s", want, link.Path)
+	}
+	if resp := do(http.MethodGet, link.Path); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp = do(http.MethodGet, "/chat-history"+convoPath+"/shares")
+	var links []shareLink
+	json.Unmarshal(resp.Body.Bytes(), &links)
+	if len(links) != 1 || links[0].Path != link.Path {
+		t.Fatalf("the listed link should have the path %s: %s", link.Path, resp.Body)
+	}
+}
+
+func TestWithBasePath_Empty(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/shares", RequireRoles([]string{"globaldesigner"}, resolve)(CreateShareLink(store, testAuditLog(t))))
+
+	req := httptest.NewRequest(http.MethodPost, "/conversation/"+CONVO_ID+"/shares", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	resp := httptest.NewRecorder()
+	WithBasePath("", mux).ServeHTTP(resp, req)
+	if resp.Code != 201 {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var link shareLink
+	json.Unmarshal(resp.Body.Bytes(), &link)
+	if link.Path != "/shared/"+link.Token {
+		t.Fatalf("the path should have no prefix. It is: %s", link.Path)
+	}
+}
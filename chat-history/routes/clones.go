@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+	"strings"
+)
+
+type cloneRequest struct {
+	Name            string `json:"name"`
+	ResetTimestamps bool   `json:"reset_timestamps"`
+}
+
+// Start a new conversation with a copy of all the messages of another one, i.e., to use it as a template
+// "POST /conversation/{conversationId}/clone" with an optional {"name": "...", "reset_timestamps": true}
+// Every branch is copied, under new ids. The clone belongs to the caller, who needs read access to the original,
+// and unlike a fork (see ForkConversation) it doesn't have a parent_id. It's named like the original unless the
+// body has a name. The messages keep their create_ts and update_ts unless reset_timestamps is set, which makes
+// them now. Feedback, comments and pins aren't copied
+func CloneConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		ownerId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+		userId, _ := caller(r)
+
+		var req cloneRequest
+		if apiErr := decodeOptionalBody(r, &req, `body must be {"name": "...", "reset_timestamps": true|false}, or left out`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		opts := db.CloneOptions{Name: strings.TrimSpace(req.Name), ResetTimestamps: req.ResetTimestamps}
+		clone, err := store.CloneConversation(ownerId, r.PathValue("conversationId"), userId, opts)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to clone the conversation"))
+			return
+		}
+		if out, err := marshal(r, clone); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCloneConversation(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/clone", withRoles(CloneConversation(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	clonePath := fmt.Sprintf("/conversation/%s/clone", CONVO_ID)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other users need read access
+	if resp := do(http.MethodPost, clonePath, "Miss_Take", ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("the conversation shouldn't be cloned without access. Got %v: %s", resp.Code, resp.Body)
+	}
+	if _, err := store.GrantAccess(USER, CONVO_ID, structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the body can be left out
+	resp := do(http.MethodPost, clonePath, "Miss_Take", "")
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var clone structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &clone)
+	if clone.UserId != "Miss_Take" || clone.ParentId != nil || clone.ConversationId.String() == CONVO_ID {
+		t.Fatalf("the clone should be a new conversation of the caller's without a parent: %s", resp.Body)
+	}
+	resp = do(http.MethodGet, "/conversation/"+clone.ConversationId.String(), "Miss_Take", "")
+	var copied []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &copied)
+	if resp.Code != 200 || len(copied) != len(messages) {
+		t.Fatalf("the clone should have all %d messages. Got %v: %s", len(messages), resp.Code, resp.Body)
+	}
+
+	resp = do(http.MethodPost, clonePath, USER, `{"name": "template", "reset_timestamps": true}`)
+	json.Unmarshal(resp.Body.Bytes(), &clone)
+	if resp.Code != http.StatusCreated || clone.Name != "template" {
+		t.Fatalf("the clone should have the name it was given. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodPost, clonePath, USER, `{"title": "template"}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("an unknown field should be rejected. Got %v: %s", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// decodeBody decodes r's body into v, which must be all of it: one JSON value of v's type, with none of the
+// fields v doesn't have. The error says what's wrong with the body, followed by usage, what the endpoint takes.
+// A body over the limit of middleware.MaxBodyBytes is a 413
+func decodeBody(r *http.Request, v any, usage string) *apierror.APIError {
+	return decode(r, v, usage, false)
+}
+
+// decodeOptionalBody is decodeBody for endpoints whose body can be left out, which leaves v as it is
+func decodeOptionalBody(r *http.Request, v any, usage string) *apierror.APIError {
+	return decode(r, v, usage, true)
+}
+
+func decode(r *http.Request, v any, usage string, optional bool) *apierror.APIError {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if errors.Is(err, io.EOF) && optional {
+		return nil
+	}
+	if err == nil {
+		// anything but whitespace after the value
+		if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+			return bodyError(err, "the body has more after the JSON value. "+usage)
+		}
+		return nil
+	}
+	return bodyError(err, decodeError(err)+". "+usage)
+}
+
+// decodeError describes what's wrong with a body json couldn't decode
+func decodeError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "the body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "the body ends in the middle of the JSON"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("the body is not valid JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		got, _, _ := strings.Cut(typeErr.Value, " ")
+		if got == "bool" {
+			got = "boolean"
+		}
+		if typeErr.Field == "" {
+			return fmt.Sprintf("the body must be %s, not %s", article(jsonType(typeErr.Type)), got)
+		}
+		return fmt.Sprintf("field %q must be %s, not %s", typeErr.Field, article(jsonType(typeErr.Type)), got)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// json doesn't have a type for it
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	// i.e., a uuid or a timestamp that doesn't parse
+	return "the body is not valid: " + err.Error()
+}
+
+var textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// jsonType is the JSON type that decodes into t
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshaler) {
+		// uuids and timestamps
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
+
+func article(jsonType string) string {
+	if strings.IndexAny(jsonType[:1], "aeiou") == 0 {
+		return "an " + jsonType
+	}
+	return "a " + jsonType
+}
@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type decodeTarget struct {
+	Name     string    `json:"name"`
+	Count    int       `json:"count"`
+	Id       uuid.UUID `json:"id"`
+	Messages []struct {
+		Pinned bool `json:"pinned"`
+	} `json:"messages"`
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name, body string
+		// the start of the message, before the usage
+		want string
+	}{
+		{"empty", "", "the body is empty."},
+		{"syntax", `{"name": "a",}`, "the body is not valid JSON at byte 14."},
+		{"truncated", `{"name": "a"`, "the body ends in the middle of the JSON."},
+		{"type", `{"count": "3"}`, `field "count" must be a number, not string.`},
+		{"nested type", `{"messages": [{"pinned": 1}]}`, `field "messages.0.pinned" must be a boolean, not number.`},
+		{"not an object", `["a"]`, "the body must be an object, not array."},
+		{"unknown field", `{"name": "a", "nmae": "b"}`, `unknown field "nmae".`},
+		{"trailing data", `{"name": "a"} {"name": "b"}`, "the body has more after the JSON value."},
+		{"invalid value", `{"id": "not-a-uuid"}`, "the body is not valid: invalid UUID length: 10."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v decodeTarget
+			apiErr := decodeBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)), &v, "body must be a thing")
+			if apiErr == nil || apiErr.Status != http.StatusBadRequest || apiErr.Code != apierror.CodeInvalidRequest {
+				t.Fatalf("should be a 400. Got %v", apiErr)
+			}
+			if want := tt.want + " body must be a thing"; apiErr.Message != want {
+				t.Fatalf("message should be %q. It's %q", want, apiErr.Message)
+			}
+		})
+	}
+
+	var v decodeTarget
+	body := `{"name": "a", "count": 3, "messages": [{"pinned": true}]}` + "\n"
+	if apiErr := decodeBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), &v, ""); apiErr != nil {
+		t.Fatalf("a valid body should decode. Got %v", apiErr)
+	}
+	if v.Name != "a" || v.Count != 3 || len(v.Messages) != 1 || !v.Messages[0].Pinned {
+		t.Fatalf("the body should be decoded into v: %+v", v)
+	}
+}
+
+func TestDecodeOptionalBody(t *testing.T) {
+	v := decodeTarget{Name: "default"}
+	if apiErr := decodeOptionalBody(httptest.NewRequest(http.MethodPost, "/", nil), &v, ""); apiErr != nil || v.Name != "default" {
+		t.Fatalf("an empty body should leave v as it is. Got %v, %+v", apiErr, v)
+	}
+	if apiErr := decodeOptionalBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"nope": 1}`)), &v, ""); apiErr == nil {
+		t.Fatal("a body that's there should still be checked")
+	}
+}
+
+func TestDecodeBody_TooLarge(t *testing.T) {
+	var apiErr *apierror.APIError
+	handler := middleware.MaxBodyBytes(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v decodeTarget
+		apiErr = decodeBody(r, &v, "")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "far too long"}`))
+	// not knowing the length, it gets past the check up front and is cut off while decoding
+	r.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if apiErr == nil || apiErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("a body over the limit should be a 413. Got %v", apiErr)
+	}
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// dedupKey is a user's message to a conversation, by the hash of its content
+type dedupKey struct {
+	conversationId uuid.UUID
+	userId         string
+	content        [sha256.Size]byte
+}
+
+func newDedupKey(conversationId uuid.UUID, userId, content string) dedupKey {
+	return dedupKey{conversationId: conversationId, userId: userId, content: sha256.Sum256([]byte(content))}
+}
+
+// replyResult is what the reply to a message got to: the conversation and message once it was added, and the
+// reply as it was last saved, which is nil if none of it was and incomplete if it was cut off
+type replyResult struct {
+	conversation *structs.Conversation
+	message      structs.Message
+	reply        *structs.Message
+}
+
+// dedupEntry is the reply to a message being generated, or generated within the window
+type dedupEntry struct {
+	// closed once the reply is done, result is set then
+	done     chan struct{}
+	result   *replyResult
+	finished time.Time
+}
+
+// replyDedup are the messages replied to within a window. A client that reconnects sends the message it didn't get
+// the reply to again, which would add it and generate another reply. The messages are only kept in this process
+type replyDedup struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}
+
+// newReplyDedup returns the dedup of the messages replied to within window. A window of 0 or less keeps none
+func newReplyDedup(window time.Duration) *replyDedup {
+	return &replyDedup{window: window, now: time.Now, entries: map[dedupKey]*dedupEntry{}}
+}
+
+// start returns the entry of the reply to the message with key, and whether it's new: the caller generates the
+// reply and calls finish with it. Otherwise the reply is being generated or was within the window, and the caller
+// waits for entry.done to get its result
+func (d *replyDedup) start(key dedupKey) (_ *dedupEntry, first bool) {
+	entry := &dedupEntry{done: make(chan struct{})}
+	if d.window <= 0 {
+		return entry, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for k, e := range d.entries {
+		if !e.finished.IsZero() && now.Sub(e.finished) >= d.window {
+			delete(d.entries, k)
+		}
+	}
+	if e, ok := d.entries[key]; ok {
+		return e, false
+	}
+	d.entries[key] = entry
+	return entry, true
+}
+
+// finish records the result of the reply to the message with key, started with start. A nil result, a message that
+// wasn't added, isn't kept, sending it again adds it
+func (d *replyDedup) finish(key dedupKey, entry *dedupEntry, result *replyResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry.result, entry.finished = result, d.now()
+	if result == nil && d.entries[key] == entry {
+		delete(d.entries, key)
+	}
+	close(entry.done)
+}
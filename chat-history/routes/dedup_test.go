@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReplyDedup(t *testing.T) {
+	d := newReplyDedup(time.Minute)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	convoId := uuid.New()
+	key := newDedupKey(convoId, USER, "How many accounts?")
+
+	entry, first := d.start(key)
+	if !first {
+		t.Fatal("the first message should be replied to")
+	}
+	// a message that wasn't added isn't kept
+	d.finish(key, entry, nil)
+	if entry, first = d.start(key); !first {
+		t.Fatal("a message sent again after the first failed should be replied to")
+	}
+
+	// the same content from another user or to another conversation is another message
+	for _, other := range []dedupKey{newDedupKey(convoId, "Miss_Take", "How many accounts?"), newDedupKey(uuid.New(), USER, "How many accounts?"), newDedupKey(convoId, USER, "How many accounts? ")} {
+		if _, first := d.start(other); !first {
+			t.Fatalf("%+v should be another message", other)
+		}
+	}
+
+	result := &replyResult{}
+	d.finish(key, entry, result)
+	now = now.Add(59 * time.Second)
+	if again, first := d.start(key); first || again.result != result {
+		t.Fatal("a message sent again within the window should get the first result")
+	}
+	now = now.Add(time.Second)
+	if _, first := d.start(key); !first {
+		t.Fatal("a message sent again after the window should be replied to")
+	}
+
+	// without a window every message is replied to
+	d = newReplyDedup(0)
+	entry, _ = d.start(key)
+	d.finish(key, entry, result)
+	if _, first := d.start(key); !first {
+		t.Fatal("messages shouldn't be kept without a window")
+	}
+}
@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/jobs"
+	"chat-history/structs"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// messages RetrieveSimilar returns without k
+	defaultSimilarMessages = 5
+	maxSimilarMessages     = 50
+)
+
+// Get the messages of a conversation that mean the most similar things to a query, for retrieval
+// "GET /conversations/{conversationId}/similar?q=string&k=int"
+// The query is embedded with llm_config.embedding_model and compared with the conversation's embedded messages,
+// most similar first. Messages are embedded shortly after they're added, until then they aren't returned
+func RetrieveSimilar(store db.ConversationStore, embedder db.Embedder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		if embedder == nil {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "no embedding model is configured"))
+			return
+		}
+		query := r.URL.Query().Get("q")
+		if strings.TrimSpace(query) == "" {
+			writeError(w, apierror.InvalidRequest("missing query q"))
+			return
+		}
+		k := defaultSimilarMessages
+		if v := r.URL.Query().Get("k"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSimilarMessages {
+				writeError(w, apierror.InvalidRequest(fmt.Sprintf("k must be an integer from 1 to %d", maxSimilarMessages)))
+				return
+			}
+			k = n
+		}
+
+		convo, err := store.FindConversation(conversationId)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+			return
+		}
+		if !allowed(r, store, userId, convo, structs.PermissionRead) {
+			writeError(w, apierror.Forbidden(fmt.Sprintf("%s is not authorized to read conversation %s", userId, conversationId)))
+			return
+		}
+
+		vectors, err := embedder.Embed(r.Context(), []string{query})
+		if err != nil {
+			writeError(w, apierror.Internal("failed to embed the query"))
+			return
+		}
+		similar, err := store.RetrieveSimilar(conversationId, vectors[0], embedder.Model(), k)
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to retrieve similar messages"))
+			return
+		}
+		out, err := marshal(r, similar)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// EmbedNewMessages is the func for db.Notify that embeds each message that's added, on pool. The ones it
+// misses, like streamed replies and edits, are embedded by db.StartEmbedder
+func EmbedNewMessages(store db.ConversationStore, embedder db.Embedder, pool *jobs.Pool) func(db.Event) {
+	return func(ev db.Event) {
+		message := ev.Message
+		pool.Submit(jobs.Job{
+			Name:  "embed message",
+			Attrs: []any{"message_id", message.MessageId},
+			Run: func(ctx context.Context) error {
+				return db.EmbedMessages(ctx, store, embedder, []structs.Message{message})
+			},
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/jobs"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wordEmbedder counts the words of vocabulary in each text
+type wordEmbedder []string
+
+func (e wordEmbedder) Model() string {
+	return "words"
+}
+
+func (e wordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, v := range e {
+				if strings.Trim(word, "?.") == v {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func getSimilar(t *testing.T, handler http.HandlerFunc, user, query string) (*httptest.ResponseRecorder, []structs.SimilarMessage) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversations/{conversationId}/similar", handler)
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversations/%s/similar?%s", CONVO_ID, query), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	var similar []structs.SimilarMessage
+	if resp.Code == http.StatusOK {
+		if err := json.Unmarshal(resp.Body.Bytes(), &similar); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, similar
+}
+
+func TestRetrieveSimilar(t *testing.T) {
+	store := setupStreamDB(t)
+	embedder := wordEmbedder{"many", "transactions", "100"}
+	if _, err := db.EmbedStale(context.Background(), store, embedder); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, similar := getSimilar(t, RetrieveSimilar(store, embedder), USER, "q=100&k=1")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if len(similar) != 1 || similar[0].Content != "There are 100 transactions" {
+		t.Fatalf("the nearest message should be returned: %+v", similar)
+	}
+	if _, similar := getSimilar(t, RetrieveSimilar(store, embedder), USER, "q=how+many"); len(similar) != 2 || similar[0].Content != "How many transactions?" {
+		t.Fatalf("both messages should be returned, nearest first: %+v", similar)
+	}
+}
+
+func TestRetrieveSimilar_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	embedder := wordEmbedder{"transactions"}
+	tests := []struct {
+		name     string
+		embedder db.Embedder
+		user     string
+		query    string
+		code     int
+	}{
+		{"no embedding model", nil, USER, "q=transactions", http.StatusNotImplemented},
+		{"no query", embedder, USER, "q=+", http.StatusBadRequest},
+		{"k too big", embedder, USER, "q=transactions&k=51", http.StatusBadRequest},
+		{"someone else's conversation", embedder, "Miss_Take", "q=transactions", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, _ := getSimilar(t, RetrieveSimilar(store, tt.embedder), tt.user, tt.query); resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.code, resp.Code, resp.Body)
+			}
+		})
+	}
+}
+
+func TestEmbedNewMessages(t *testing.T) {
+	store := setupStreamDB(t)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedder := wordEmbedder{"transactions"}
+	pool := jobs.New(1)
+	notify := EmbedNewMessages(store, embedder, pool)
+	for _, m := range messages {
+		notify(db.Event{Type: db.EventMessageAppended, Message: m})
+	}
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if stale, err := store.UnembeddedMessages(embedder.Model(), 10); err != nil || len(stale) != 0 {
+		t.Fatalf("the messages should be embedded on the pool. %d aren't, %v", len(stale), err)
+	}
+}
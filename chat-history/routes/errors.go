@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/llm"
+	"errors"
+	"net/http"
+)
+
+var errReadOnly = apierror.New(http.StatusMethodNotAllowed, apierror.CodeReadOnly, "the chat history is read-only")
+
+// storeError is the response for an error from the store. notFound is the message if what the request
+// refers to doesn't exist (or belongs to another user), failed the message if the store itself failed
+func storeError(err error, notFound, failed string) *apierror.APIError {
+	switch {
+	case errors.Is(err, db.ErrReadOnly):
+		return errReadOnly
+	case errors.Is(err, db.ErrNotFound):
+		return apierror.NotFound(notFound)
+	case errors.Is(err, db.ErrInvalidTag), errors.Is(err, db.ErrInvalidCursor), errors.Is(err, db.ErrInvalidMessages),
+		errors.Is(err, db.ErrInvalidAttachment), errors.Is(err, db.ErrTooManyAttachments), errors.Is(err, db.ErrTooManyPins),
+		errors.Is(err, db.ErrTooManyPinnedConversations),
+		errors.Is(err, db.ErrInvalidAccess), errors.Is(err, db.ErrInvalidTemplate), errors.Is(err, db.ErrInvalidFeedback),
+		errors.Is(err, db.ErrInvalidExternalId), errors.Is(err, db.ErrInvalidMetadata), errors.Is(err, db.ErrNoTenant):
+		return apierror.InvalidRequest(err.Error())
+	case errors.Is(err, db.ErrBlocked):
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeContentBlocked, err.Error())
+	case errors.Is(err, db.ErrMessageTooLong):
+		return apierror.New(http.StatusUnprocessableEntity, apierror.CodeMessageTooLong, err.Error())
+	case errors.Is(err, db.ErrShareExpired):
+		return apierror.New(http.StatusGone, apierror.CodeShareExpired, err.Error())
+	case errors.Is(err, db.ErrShareRevoked):
+		return apierror.New(http.StatusGone, apierror.CodeShareRevoked, err.Error())
+	case errors.Is(err, db.ErrVersionConflict), errors.Is(err, db.ErrExternalIdTaken), errors.Is(err, db.ErrConversationExists):
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, err.Error())
+	case errors.Is(err, db.ErrTooManyConversations):
+		return apierror.New(http.StatusConflict, apierror.CodeTooManyConversations, err.Error())
+	case errors.Is(err, db.ErrMaintenanceRunning):
+		return apierror.New(http.StatusConflict, apierror.CodeMaintenanceRunning, err.Error())
+	case errors.Is(err, db.ErrNoArchive), errors.Is(err, db.ErrNoSearchIndex):
+		return apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, err.Error())
+	}
+	return apierror.Internal(failed)
+}
+
+// llmError is the response for an error from the LLM. failed is the message if it isn't that the LLM is
+// failing so much that calls to it are paused, see llm.WithBreaker
+func llmError(err error, failed string) *apierror.APIError {
+	if errors.Is(err, llm.ErrCircuitOpen) {
+		return apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "the LLM is unavailable, try again later")
+	}
+	return apierror.Internal(failed)
+}
+
+// bodyError is the response for a body that can't be read or decoded. invalid is the message if it
+// isn't what the endpoint takes, a body over the limit of middleware.MaxBodyBytes is a 413
+func bodyError(err error, invalid string) *apierror.APIError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apierror.TooLarge(tooLarge.Limit)
+	}
+	return apierror.InvalidRequest(invalid)
+}
+
+// writeError responds with err. Read-only errors have an empty Allow header since the endpoint takes no methods in this mode
+func writeError(w http.ResponseWriter, err *apierror.APIError) {
+	if err.Code == apierror.CodeReadOnly {
+		w.Header().Set("Allow", "")
+	}
+	apierror.Write(w, err)
+}
@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorResponses(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}, "observer": {"globalobserver"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+	mux.Handle("POST /conversation", withRoles(UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil)))
+	mux.Handle("DELETE /conversation/{conversationId}", withRoles(DeleteConversation(store, testAuditLog(t))))
+	mux.Handle("POST /conversation/{conversationId}/restore", withRoles(RestoreConversation(store, time.Hour)))
+	mux.Handle("POST /conversation/{conversationId}/archive", withRoles(ArchiveConversation(store)))
+	mux.Handle("POST /conversation/{conversationId}/unarchive", withRoles(UnarchiveConversation(store)))
+	mux.Handle("PUT /conversation/{conversationId}/tags/{tag}", withRoles(AddTag(store)))
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}", withRoles(EditMessage(store)))
+	mux.Handle("POST /conversation/{conversationId}/messages/{messageId}/attachments", withRoles(AddAttachment(store)))
+	mux.Handle("GET /conversation/{conversationId}/attachments", withRoles(ListAttachments(store)))
+	mux.Handle("POST /conversation/{conversationId}/shares", withRoles(CreateShareLink(store, testAuditLog(t))))
+	mux.Handle("GET /conversations/{conversationId}/export", withRoles(ExportConversation(store)))
+	mux.Handle("POST /conversations/{conversationId}/import", withRoles(ImportMessages(store)))
+	mux.Handle("POST /conversations/{conversationId}/stream", withRoles(StreamConversation(store, nil, config.LLMConfig{})))
+	mux.Handle("GET /conversations/{conversationId}/summary", withRoles(SummarizeConversation(store, nil, config.LLMConfig{})))
+	mux.Handle("POST /admin/conversation/{conversationId}/transfer", withRoles(AdminTransferOwnership(store, nil, fakeUsers(USER))))
+	mux.Handle("GET /search", withRoles(SearchMessages(store)))
+	mux.HandleFunc("GET /shared/{token}", GetSharedConversation(store))
+	mux.Handle("POST /readonly", RejectWrites())
+
+	revoked, err := store.CreateShareLink(USER, CONVO_ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.RevokeShareLink(USER, CONVO_ID, revoked.ShareId.String())
+	expired, err := store.CreateShareLink(USER, CONVO_ID, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "4f6c5ef9-1b49-4a57-a0a5-10e0f1498c4d"
+	tests := []struct {
+		name         string
+		method, path string
+		user, body   string
+		status       int
+		code         string
+	}{
+		{"no credentials", http.MethodGet, "/user/" + USER, "", "", 401, apierror.CodeUnauthorized},
+		{"no access role", http.MethodGet, "/user/" + USER, "observer", "", 403, apierror.CodeForbidden},
+		{"roles can't be looked up", http.MethodGet, "/user/" + USER, "broken", "", 500, apierror.CodeInternal},
+		{"another user's conversations", http.MethodGet, "/user/" + USER, "Miss_Take", "", 403, apierror.CodeForbidden},
+		{"invalid limit", http.MethodGet, "/user/" + USER + "?limit=-1", USER, "", 400, apierror.CodeInvalidRequest},
+		{"invalid cursor", http.MethodGet, "/user/" + USER + "?cursor=nope", USER, "", 400, apierror.CodeInvalidRequest},
+		{"invalid tag filter", http.MethodGet, "/user/" + USER + "?tag=%20", USER, "", 400, apierror.CodeInvalidRequest},
+		{"update with invalid body", http.MethodPost, "/conversation", USER, "not json", 400, apierror.CodeInvalidRequest},
+		{"update with an unknown role", http.MethodPost, "/conversation", USER,
+			fmt.Sprintf(`{"conversation_id":%q,"message_id":%q,"role":"bot","content":"hi"}`, missing, missing), 400, apierror.CodeInvalidRequest},
+		{"update another user's conversation", http.MethodPost, "/conversation", "Miss_Take",
+			fmt.Sprintf(`{"conversation_id":%q,"message_id":%q,"content":"hi"}`, CONVO_ID, missing), 403, apierror.CodeForbidden},
+		{"delete missing conversation", http.MethodDelete, "/conversation/" + missing, USER, "", 404, apierror.CodeNotFound},
+		{"delete another user's conversation", http.MethodDelete, "/conversation/" + CONVO_ID, "Miss_Take", "", 404, apierror.CodeNotFound},
+		{"restore conversation not in the trash", http.MethodPost, "/conversation/" + CONVO_ID + "/restore", USER, "", 404, apierror.CodeNotFound},
+		{"archive without an archive database", http.MethodPost, "/conversation/" + CONVO_ID + "/archive", USER, "", 501, apierror.CodeNotImplemented},
+		{"unarchive without an archive database", http.MethodPost, "/conversation/" + CONVO_ID + "/unarchive", USER, "", 501, apierror.CodeNotImplemented},
+		{"invalid tag", http.MethodPut, "/conversation/" + CONVO_ID + "/tags/" + strings.Repeat("t", 65), USER, "", 400, apierror.CodeInvalidRequest},
+		{"tag missing conversation", http.MethodPut, "/conversation/" + missing + "/tags/work", USER, "", 404, apierror.CodeNotFound},
+		{"edit with invalid body", http.MethodPut, "/conversation/" + CONVO_ID + "/messages/" + missing, USER, "[]", 400, apierror.CodeInvalidRequest},
+		{"edit missing message", http.MethodPut, "/conversation/" + CONVO_ID + "/messages/" + missing, USER, `{"content":"x"}`, 404, apierror.CodeNotFound},
+		{"attach with invalid body", http.MethodPost, "/conversation/" + CONVO_ID + "/messages/" + missing + "/attachments", USER, "[]", 400, apierror.CodeInvalidRequest},
+		{"attach invalid metadata", http.MethodPost, "/conversation/" + CONVO_ID + "/messages/" + missing + "/attachments", USER,
+			`{"filename":"x.png","content_type":"image/png","storage_url":"javascript:alert(1)"}`, 400, apierror.CodeInvalidRequest},
+		{"attach to missing message", http.MethodPost, "/conversation/" + CONVO_ID + "/messages/" + missing + "/attachments", USER,
+			`{"filename":"x.png","content_type":"image/png","storage_url":"https://files.example.com/x.png"}`, 404, apierror.CodeNotFound},
+		{"attachments of another user's conversation", http.MethodGet, "/conversation/" + CONVO_ID + "/attachments", "Miss_Take", "", 404, apierror.CodeNotFound},
+		{"share for too long", http.MethodPost, "/conversation/" + CONVO_ID + "/shares", USER, `{"expires_in_hours":100000}`, 400, apierror.CodeInvalidRequest},
+		{"share missing conversation", http.MethodPost, "/conversation/" + missing + "/shares", USER, "", 404, apierror.CodeNotFound},
+		{"unknown share token", http.MethodGet, "/shared/nope", "", "", 404, apierror.CodeNotFound},
+		{"revoked share", http.MethodGet, "/shared/" + revoked.Token, "", "", 410, apierror.CodeShareRevoked},
+		{"expired share", http.MethodGet, "/shared/" + expired.Token, "", "", 410, apierror.CodeShareExpired},
+		{"unsupported export format", http.MethodGet, "/conversations/" + CONVO_ID + "/export?format=docx", USER, "", 400, apierror.CodeInvalidRequest},
+		{"export missing conversation", http.MethodGet, "/conversations/" + missing + "/export", USER, "", 404, apierror.CodeNotFound},
+		{"export another user's conversation", http.MethodGet, "/conversations/" + CONVO_ID + "/export", "Miss_Take", "", 403, apierror.CodeForbidden},
+		{"import invalid body", http.MethodPost, "/conversations/" + CONVO_ID + "/import", USER, "{}", 400, apierror.CodeInvalidRequest},
+		{"import invalid messages", http.MethodPost, "/conversations/" + CONVO_ID + "/import", USER, `[{"content":"no id"}]`, 400, apierror.CodeInvalidRequest},
+		{"import into another user's conversation", http.MethodPost, "/conversations/" + CONVO_ID + "/import", "Miss_Take",
+			fmt.Sprintf(`[{"message_id":%q,"content":"hi","role":"user"}]`, missing), 404, apierror.CodeNotFound},
+		{"stream without an LLM", http.MethodPost, "/conversations/" + CONVO_ID + "/stream", USER, "", 501, apierror.CodeNotImplemented},
+		{"summary without an LLM", http.MethodGet, "/conversations/" + CONVO_ID + "/summary", USER, "", 501, apierror.CodeNotImplemented},
+		{"transfer to a nonexistent user", http.MethodPost, "/admin/conversation/" + CONVO_ID + "/transfer", USER, `{"user_id":"nobody"}`, 400, apierror.CodeInvalidRequest},
+		{"transfer missing conversation", http.MethodPost, "/admin/conversation/" + missing + "/transfer", USER, `{"user_id":"Miss_Take"}`, 404, apierror.CodeNotFound},
+		{"search without a query", http.MethodGet, "/search", USER, "", 400, apierror.CodeInvalidRequest},
+		{"read-only", http.MethodPost, "/readonly", USER, "", 405, apierror.CodeReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			if tt.user != "" {
+				req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(tt.user, PASS)))
+			}
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+
+			if resp.Code != tt.status {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.status, resp.Code, resp.Body)
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type should be application/json. It's: %s", ct)
+			}
+			var apiErr map[string]string
+			if err := json.Unmarshal(resp.Body.Bytes(), &apiErr); err != nil {
+				t.Fatalf("the body should be a JSON error: %s", resp.Body)
+			}
+			if apiErr["code"] != tt.code || apiErr["message"] == "" || len(apiErr) != 2 {
+				t.Fatalf(`the body should be {"code":%q,"message":...}. It's: %s`, tt.code, resp.Body)
+			}
+		})
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{db.ErrNotFound, 404, apierror.CodeNotFound},
+		{fmt.Errorf("message 1: %w", db.ErrNotFound), 404, apierror.CodeNotFound},
+		{db.ErrReadOnly, 405, apierror.CodeReadOnly},
+		{db.ErrInvalidTag, 400, apierror.CodeInvalidRequest},
+		{db.ErrInvalidCursor, 400, apierror.CodeInvalidRequest},
+		{db.ErrInvalidMessages, 400, apierror.CodeInvalidRequest},
+		{db.ErrTooManyPins, 400, apierror.CodeInvalidRequest},
+		{db.ErrShareExpired, 410, apierror.CodeShareExpired},
+		{db.ErrShareRevoked, 410, apierror.CodeShareRevoked},
+		{db.ErrVersionConflict, 409, apierror.CodeConflict},
+		{db.ErrInvalidExternalId, 400, apierror.CodeInvalidRequest},
+		{fmt.Errorf("%w: 601529eb-4927-4e24-b285-bd6b9519a951", db.ErrExternalIdTaken), 409, apierror.CodeConflict},
+		{db.ErrMaintenanceRunning, 409, apierror.CodeMaintenanceRunning},
+		{fmt.Errorf("%w: it's 5000 characters", db.ErrMessageTooLong), 422, apierror.CodeMessageTooLong},
+		{errors.New("disk I/O error"), 500, apierror.CodeInternal},
+	}
+	for _, tt := range tests {
+		apiErr := storeError(tt.err, "thing not found", "failed to do the thing")
+		if apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Fatalf("%v should be %d %s. It's %d %s", tt.err, tt.status, tt.code, apiErr.Status, apiErr.Code)
+		}
+	}
+	// internal errors aren't shown to the client
+	if apiErr := storeError(errors.New("disk I/O error"), "", "failed to do the thing"); apiErr.Message != "failed to do the thing" {
+		t.Fatalf("the message should be the failed one. It's: %q", apiErr.Message)
+	}
+}
+
+func TestLLMError(t *testing.T) {
+	if apiErr := llmError(fmt.Errorf("summarize: %w", llm.ErrCircuitOpen), "failed to get a summary"); apiErr.Status != 503 || apiErr.Code != apierror.CodeUnavailable {
+		t.Fatalf("an open circuit breaker should be a 503. It's %d %s", apiErr.Status, apiErr.Code)
+	}
+	if apiErr := llmError(errors.New("llm request failed: 401 Unauthorized"), "failed to get a summary"); apiErr.Status != 500 || apiErr.Message != "failed to get a summary" {
+		t.Fatalf("other errors should be internal with the failed message. It's %d %q", apiErr.Status, apiErr.Message)
+	}
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// how many events GET /conversation/{conversationId}/events returns unless it's given a limit, and the most it returns
+const (
+	defaultEventPageSize = 100
+	maxEventPageSize     = 500
+)
+
+// Get the timeline of a conversation: when it was created, had messages added, was renamed and was shared, oldest first
+// "GET /conversation/{conversationId}/events?limit=int&cursor=string"
+// limit is 100 unless it's set, at most 500, and the cursor for the next page is returned in the X-Next-Cursor
+// header (empty on the last page)
+func ListEvents(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		limit := defaultEventPageSize
+		if l := r.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxEventPageSize {
+				writeError(w, apierror.InvalidRequest(fmt.Sprintf("limit must be an integer from 1 to %d", maxEventPageSize)))
+				return
+			}
+			limit = n
+		}
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+
+		events, next, err := store.ListEvents(userId, r.PathValue("conversationId"), limit, r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve the events"))
+			return
+		}
+		if out, err := marshal(r, events); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Header().Set("X-Next-Cursor", next)
+			w.Write(out)
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListEvents(t *testing.T) {
+	store := setupDB(t, false)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}/events", RequireRoles([]string{"globaldesigner"}, resolve)(ListEvents(store)))
+
+	get := func(path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	first := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Which accounts share a phone number?", Role: structs.UserRole}
+	convo, err := store.CreateConversation(USER, "fraud rings", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := structs.Message{ConversationId: convo.ConversationId, MessageId: uuid.New(), ParentId: &first.MessageId, Content: "Accounts 12 and 42", Role: structs.SystemRole}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RenameConversation(convo.ConversationId.String(), "Shared phone numbers"); err != nil {
+		t.Fatal(err)
+	}
+	eventsPath := fmt.Sprintf("/conversation/%s/events", convo.ConversationId)
+
+	resp := get(eventsPath, USER)
+	if resp.Code != http.StatusOK || resp.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("Response code should be 200 with the whole timeline. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var events []structs.ConversationEvent
+	json.Unmarshal(resp.Body.Bytes(), &events)
+	if len(events) != 3 || events[0].Type != db.EventConversationCreated || events[1].Type != db.EventMessageAppended ||
+		events[2].Type != db.EventConversationRenamed || events[2].Detail != "Shared phone numbers" {
+		t.Fatalf("the timeline should be created, appended and renamed. Got %s", resp.Body)
+	}
+
+	// a page at a time
+	resp = get(eventsPath+"?limit=2", USER)
+	next := resp.Header().Get("X-Next-Cursor")
+	json.Unmarshal(resp.Body.Bytes(), &events)
+	if resp.Code != http.StatusOK || len(events) != 2 || next == "" {
+		t.Fatalf("the first page should be 2 events and a cursor. Got %v, %q: %s", resp.Code, next, resp.Body)
+	}
+	resp = get(eventsPath+"?limit=2&cursor="+next, USER)
+	json.Unmarshal(resp.Body.Bytes(), &events)
+	if resp.Code != http.StatusOK || len(events) != 1 || events[0].Type != db.EventConversationRenamed || resp.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("the last page should be the rename. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=501", "?limit=ten", "?cursor=nope"} {
+		if resp := get(eventsPath+query, USER); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s should be a 400. Got %v: %s", query, resp.Code, resp.Body)
+		}
+	}
+	if resp := get(eventsPath, "Miss_Take"); resp.Code != http.StatusNotFound {
+		t.Fatalf("another user shouldn't get the timeline. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get(fmt.Sprintf("/conversation/%s/events", uuid.New()), USER); resp.Code != http.StatusNotFound {
+		t.Fatalf("a conversation that doesn't exist should be a 404. Got %v: %s", resp.Code, resp.Body)
+	}
+}
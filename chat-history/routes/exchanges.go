@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"chat-history/audit"
+	"chat-history/db"
+	"fmt"
+	"net/http"
+)
+
+// Read what was sent to the LLM for a reply and what it sent back, as they were sent, for support staff to debug
+// the reply with. Callers need one of the admin roles (see RequireAdmin)
+// "GET /admin/message/{messageId}/llm-io"
+// They're only stored with llm_config.debug_store_llm_io, for debug_retention_hours. Every access is written to the
+// audit log
+func AdminGetLLMExchange(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		messageId := r.PathValue("messageId")
+		notFound := fmt.Sprintf("no LLM request and response are stored for message %s", messageId)
+
+		exchange, err := store.GetLLMExchange(messageId)
+		if err != nil {
+			writeError(w, storeError(err, notFound, "failed to retrieve the LLM request and response"))
+			return
+		}
+		convo, err := store.FindConversation(exchange.ConversationId.String())
+		if err != nil {
+			// the conversation was deleted since
+			writeError(w, storeError(err, notFound, "failed to retrieve the LLM request and response"))
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "read_llm_io", UserId: convo.UserId, ConversationId: convo.ConversationId.String()}) {
+			return
+		}
+		if out, err := marshal(r, exchange); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(out)
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"bufio"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStreamConversation_DebugStoreLLMIO(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{`{"choices":[{"delta":{"content":"100 "}}]}`, `{"choices":[{"delta":{"content":"transactions"}}]}`, `[DONE]`} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer srv.Close()
+
+	for _, debug := range []bool{true, false} {
+		t.Run(fmt.Sprint(debug), func(t *testing.T) {
+			store := setupStreamDB(t)
+			llmCfg := config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: srv.URL, DebugStoreLLMIO: debug}
+			client, err := llm.NewClient(llmCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := bufio.NewReader(startStream(t, StreamConversation(store, client, llmCfg), context.Background(), USER, CONVO_ID).Body)
+			var event, data string
+			for event != "done" && event != "error" {
+				event, data = readEvent(t, events)
+			}
+			var reply structs.Message
+			json.Unmarshal([]byte(data), &reply)
+			if event != "done" {
+				t.Fatalf("expected a done event. Got %s: %s", event, data)
+			}
+
+			exchange, err := store.GetLLMExchange(reply.MessageId.String())
+			if !debug {
+				// it's off by default, nothing the user sent or got is kept
+				if !errors.Is(err, db.ErrNotFound) {
+					t.Fatalf("the LLM request and response shouldn't be stored. Got %+v, %v", exchange, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(exchange.Request, "How many transactions?") || !strings.Contains(exchange.Response, `"content":"transactions"`) ||
+				exchange.ConversationId.String() != CONVO_ID || exchange.Provider != config.ProviderOllama || exchange.Model != "llama3" {
+				t.Fatalf("the request and response should be stored with the reply. Got %+v", exchange)
+			}
+		})
+	}
+}
+
+func TestAdminGetLLMExchange(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) == 0 {
+		t.Fatal(err)
+	}
+	messageId := messages[len(messages)-1].MessageId
+	if err := store.SaveLLMExchange(structs.LLMExchange{MessageId: messageId, ConversationId: uuid.MustParse(CONVO_ID), Request: `{"messages":[]}`, Response: `{"choices":[]}`}); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/admin/message/%s/llm-io", messageId)
+
+	resp := adminRequest(handler, path, "support")
+	var exchange structs.LLMExchange
+	json.Unmarshal(resp.Body.Bytes(), &exchange)
+	if resp.Code != http.StatusOK || exchange.Request != `{"messages":[]}` || exchange.Response != `{"choices":[]}` {
+		t.Fatalf("support should read the request and response. Got %v: %s", resp.Code, resp.Body)
+	}
+	entries := readAudit(t, pth)
+	if last := entries[len(entries)-1]; last.Action != "read_llm_io" || last.Actor != "support" || last.UserId != USER || last.ConversationId != CONVO_ID {
+		t.Fatalf("the read should be audited. Got %+v", last)
+	}
+
+	// not even the conversation's owner can read them
+	if resp := adminRequest(handler, path, USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("only admins should read the request and response. Got %v: %s", resp.Code, resp.Body)
+	}
+	for _, id := range []string{uuid.NewString(), "not-a-uuid"} {
+		if resp := adminRequest(handler, fmt.Sprintf("/admin/message/%s/llm-io", id), "support"); resp.Code != http.StatusNotFound {
+			t.Fatalf("a message without them should be a 404. Got %v: %s", resp.Code, resp.Body)
+		}
+	}
+}
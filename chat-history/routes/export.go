@@ -0,0 +1,403 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/hpke"
+	"chat-history/pdf"
+	"chat-history/portable"
+	"chat-history/structs"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type exportedMessage struct {
+	MessageId uuid.UUID               `json:"message_id"`
+	ParentId  *uuid.UUID              `json:"parent_id"`
+	Role      structs.MessagengerRole `json:"role"`
+	Model     string                  `json:"model,omitempty"`
+	Content   string                  `json:"content"`
+	CreatedAt time.Time               `json:"create_ts"`
+	// for tool and function results
+	ToolName   string `json:"tool_name,omitempty"`
+	ToolCallId string `json:"tool_call_id,omitempty"`
+	// metadata only, the files aren't part of the export
+	Attachments []exportedAttachment `json:"attachments,omitempty"`
+}
+
+type exportedAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	StorageURL  string `json:"storage_url"`
+}
+
+type exportedConversation struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	Name           string    `json:"name"`
+	UserId         string    `json:"user_id"`
+	CreatedAt      time.Time `json:"create_ts"`
+	ModelName      string    `json:"model_name,omitempty"`
+	// only set in exports of everything, for conversations that were in the archive
+	Archived bool              `json:"archived,omitempty"`
+	Messages []exportedMessage `json:"messages"`
+}
+
+// info of the HPKE context exports are sealed with, see encryptedExport
+const exportInfo = "chat-history conversation export"
+
+// encryptedExport is an export sealed to its recipient's public key with hpke, with exportInfo as the info and no aad
+type encryptedExport struct {
+	Suite string `json:"suite"`
+	// the encapsulated key and the sealed export, base64
+	Enc        []byte `json:"enc"`
+	Ciphertext []byte `json:"ciphertext"`
+	// of the export once it's opened
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+}
+
+// Download a conversation with its full message history
+// "GET /conversations/{conversationId}/export?format=json|markdown|pdf|portable&recipient=...&include_hidden=bool"
+// format defaults to json. The context messages GraphRAG added for the LLM are left out unless include_hidden=true. The PDF is for printing, see pdf for the characters it can show.
+// portable is a portable.ConversationExport, with the tags and every message, that POST /import/portable imports as it was. With recipient, a base64 X25519 public key, the export is encrypted so only the
+// holder of its private key can read it, and the response is an encryptedExport
+func ExportConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "markdown" && format != "pdf" && format != "portable" {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("unsupported format %q, must be json, markdown, pdf or portable", format)))
+			return
+		}
+		var recipient []byte
+		if param := r.URL.Query().Get("recipient"); param != "" {
+			var ok bool
+			if recipient, ok = decodePublicKey(param); !ok {
+				writeError(w, apierror.InvalidRequest("recipient must be a base64 X25519 public key of 32 bytes"))
+				return
+			}
+		}
+
+		convo, err := store.FindConversation(conversationId)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+			return
+		}
+		// superusers and users granted access can export the conversation
+		if !allowed(r, store, userId, convo, structs.PermissionRead) {
+			writeError(w, apierror.Forbidden(fmt.Sprintf("%s is not authorized to read conversation %s", userId, conversationId)))
+			return
+		}
+
+		var body bytes.Buffer
+		contentType, filename := "application/json", conversationId+".json"
+		var apiErr *apierror.APIError
+		if format == "portable" {
+			apiErr = writePortable(&body, store, convo)
+		} else {
+			contentType, filename, apiErr = writeTranscript(&body, store, convo, format, strings.ToLower(r.URL.Query().Get("include_hidden")) == "true")
+		}
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		if recipient != nil {
+			enc, ciphertext, err := hpke.Seal(recipient, []byte(exportInfo), nil, body.Bytes())
+			if err != nil {
+				writeError(w, apierror.InvalidRequest("recipient must be a base64 X25519 public key of 32 bytes"))
+				return
+			}
+			out, err := marshal(r, encryptedExport{Suite: hpke.Suite, Enc: enc, Ciphertext: ciphertext, ContentType: contentType, Filename: filename})
+			if err != nil {
+				panic(err)
+			}
+			body.Reset()
+			body.Write(out)
+			contentType, filename = "application/json", conversationId+".encrypted.json"
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.Write(body.Bytes())
+	}
+}
+
+// writeTranscript writes the conversation's messages to body in format, json, markdown or pdf, and returns the
+// export's content type and filename
+func writeTranscript(body *bytes.Buffer, store db.ConversationStore, convo *structs.Conversation, format string, includeHidden bool) (contentType, filename string, apiErr *apierror.APIError) {
+	conversationId := convo.ConversationId.String()
+	messages, err := store.GetConversation(convo.UserId, conversationId)
+	if err != nil {
+		return "", "", apierror.Internal("failed to retrieve conversation")
+	}
+	if !includeHidden {
+		messages = db.HideContext(messages)
+	}
+	// oldest first, the order the conversation happened in
+	slices.SortStableFunc(messages, func(a, b structs.Message) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return int(a.ID) - int(b.ID)
+	})
+	attachments, err := store.ListAttachments(convo.UserId, conversationId, "")
+	if err != nil {
+		return "", "", apierror.Internal("failed to retrieve attachments")
+	}
+	byMessage := map[uuid.UUID][]structs.Attachment{}
+	for _, a := range attachments {
+		byMessage[a.MessageId] = append(byMessage[a.MessageId], a)
+	}
+
+	contentType, filename = "application/json", conversationId+".json"
+	switch format {
+	case "markdown":
+		contentType, filename = "text/markdown; charset=utf-8", conversationId+".md"
+		writeMarkdown(body, convo, messages, byMessage)
+	case "pdf":
+		contentType, filename = "application/pdf", conversationId+".pdf"
+		writePDF(body, convo, messages, byMessage)
+	default:
+		enc := json.NewEncoder(body)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(exportConversation(*convo, messages, byMessage)); err != nil {
+			panic(err)
+		}
+	}
+	return contentType, filename, nil
+}
+
+// writePortable writes the conversation to body as a portable.ConversationExport, with every message
+func writePortable(body *bytes.Buffer, store db.ConversationStore, convo *structs.Conversation) *apierror.APIError {
+	data, err := store.ExportConversation(convo.UserId, convo.ConversationId.String())
+	if err != nil {
+		return storeError(err, fmt.Sprintf("conversation %s not found", convo.ConversationId), "failed to retrieve conversation")
+	}
+	out, err := portable.New(data.Conversation, data.Messages, data.Attachments).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	body.Write(out)
+	return nil
+}
+
+// decodePublicKey decodes a 32 byte key in standard or URL-safe base64, padded or not
+func decodePublicKey(s string) ([]byte, bool) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == 32 {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// exportConversation is the conversation as it's exported, with its messages in the order they're given
+func exportConversation(convo structs.Conversation, messages []structs.Message, attachments map[uuid.UUID][]structs.Attachment) exportedConversation {
+	out := exportedConversation{
+		ConversationId: convo.ConversationId,
+		Name:           convo.Name,
+		UserId:         convo.UserId,
+		CreatedAt:      convo.CreatedAt,
+		ModelName:      convo.ModelName,
+		Archived:       convo.Archived,
+		Messages:       make([]exportedMessage, 0, len(messages)),
+	}
+	for _, m := range messages {
+		exported := exportedMessage{
+			MessageId:  m.MessageId,
+			ParentId:   m.ParentId,
+			Role:       m.Role,
+			Model:      m.ModelName,
+			Content:    m.Content,
+			CreatedAt:  m.CreatedAt,
+			ToolName:   m.ToolName,
+			ToolCallId: m.ToolCallId,
+		}
+		for _, a := range attachments[m.MessageId] {
+			exported.Attachments = append(exported.Attachments, exportedAttachment{
+				Filename:    a.Filename,
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				StorageURL:  a.StorageURL,
+			})
+		}
+		out.Messages = append(out.Messages, exported)
+	}
+	return out
+}
+
+// Download all of a user's conversations, for "download my data"
+// "GET /user/{userId}/export?archived=bool&include_hidden=bool"
+// The response is NDJSON, one exported conversation (as in /conversations/{conversationId}/export) per line,
+// oldest first. It's written as the conversations are read, so it starts right away however many there are.
+// With archived=true the conversations in the archive come after the rest. The context messages GraphRAG added
+// for the LLM are left out unless include_hidden=true. If reading fails partway the
+// response is cut off, so a download that doesn't end with a newline is incomplete
+func ExportUserData(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if _, err := auth(userId, r); err != nil {
+			writeError(w, err)
+			return
+		}
+		includeArchived := strings.ToLower(r.URL.Query().Get("archived")) == "true"
+		includeHidden := strings.ToLower(r.URL.Query().Get("include_hidden")) == "true"
+
+		// the headers are set with the first line, so an error before it can still be a JSON error
+		started := false
+		start := func() {
+			w.Header().Add("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, url.PathEscape(userId)))
+			started = true
+		}
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		err := store.ExportAll(userId, includeArchived, func(c db.ConversationData) error {
+			if !started {
+				start()
+			}
+			byMessage := map[uuid.UUID][]structs.Attachment{}
+			for _, a := range c.Attachments {
+				byMessage[a.MessageId] = append(byMessage[a.MessageId], a)
+			}
+			messages := c.Messages
+			if !includeHidden {
+				messages = db.HideContext(messages)
+			}
+			if err := enc.Encode(exportConversation(c.Conversation, messages, byMessage)); err != nil {
+				return err
+			}
+			// errors are for writers that can't flush, the line is still sent
+			rc.Flush()
+			return r.Context().Err()
+		})
+		if err != nil {
+			if !started {
+				writeError(w, storeError(err, "", "failed to export conversations"))
+				return
+			}
+			slog.Error("failed to export conversations", "user_id", userId, "err", err)
+			// the status is already sent, aborting is the only way to tell the client it's incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if !started {
+			// no conversations, the download is empty
+			start()
+		}
+	}
+}
+
+// writeMarkdown writes the conversation one message at a time, with a header for each turn
+// and a list of the message's attachments after its content
+func writeMarkdown(w io.Writer, convo *structs.Conversation, messages []structs.Message, attachments map[uuid.UUID][]structs.Attachment) {
+	name := convo.Name
+	if name == "" {
+		name = "Untitled conversation"
+	}
+	fmt.Fprintf(w, "# %s\n\n", name)
+	fmt.Fprintf(w, "_Exported %s_\n\n", time.Now().UTC().Format(time.RFC1123))
+
+	if len(messages) == 0 {
+		fmt.Fprint(w, "_No messages_\n")
+		return
+	}
+	for _, m := range messages {
+		fmt.Fprintf(w, "## %s\n\n", speaker(m))
+		fmt.Fprintf(w, "_%s_\n\n", m.CreatedAt.UTC().Format(time.RFC1123))
+		fmt.Fprintf(w, "%s\n\n", m.Content)
+		if len(attachments[m.MessageId]) > 0 {
+			fmt.Fprint(w, "Attachments:\n\n")
+			for _, a := range attachments[m.MessageId] {
+				fmt.Fprintf(w, "- %s (%s, %d bytes): %s\n", a.Filename, a.ContentType, a.Size, a.StorageURL)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	}
+}
+
+// turnColors tell the speakers apart in a PDF, replies are in the assistant's
+var turnColors = map[structs.MessagengerRole]pdf.Color{
+	structs.UserRole:      {0.13, 0.36, 0.72},
+	structs.AssistantRole: {0.12, 0.55, 0.32},
+	structs.SystemRole:    {0.12, 0.55, 0.32},
+	structs.ToolRole:      {0.78, 0.45, 0.1},
+	structs.FunctionRole:  {0.78, 0.45, 0.1},
+}
+
+// writePDF is writeMarkdown as a printable document: a header with the conversation's name, then each turn
+// with its speaker and time, marked with the speaker's color down the side
+func writePDF(w io.Writer, convo *structs.Conversation, messages []structs.Message, attachments map[uuid.UUID][]structs.Attachment) {
+	name := convo.Name
+	if name == "" {
+		name = "Untitled conversation"
+	}
+	doc := pdf.New(name)
+	doc.Paragraph(name, pdf.Style{Size: 18, Bold: true})
+	doc.Paragraph(fmt.Sprintf("%d messages, exported %s", len(messages), time.Now().UTC().Format(time.RFC1123)), pdf.Style{Size: 9, Color: pdf.Gray})
+	doc.Rule(pdf.Gray)
+
+	if len(messages) == 0 {
+		doc.Paragraph("No messages", pdf.Style{Color: pdf.Gray})
+	}
+	for _, m := range messages {
+		color, ok := turnColors[m.Role]
+		if !ok {
+			color = pdf.Gray
+		}
+		doc.Paragraph(speaker(m), pdf.Style{Size: 11, Bold: true, Color: color, Indent: 12, Bar: color})
+		doc.Paragraph(m.CreatedAt.UTC().Format(time.RFC1123), pdf.Style{Size: 8, Color: pdf.Gray, Indent: 12, Bar: color})
+		doc.Paragraph(m.Content, pdf.Style{Color: pdf.Black, Indent: 12, Bar: color})
+		for _, a := range attachments[m.MessageId] {
+			doc.Paragraph(fmt.Sprintf("Attachment: %s (%s, %d bytes) %s", a.Filename, a.ContentType, a.Size, a.StorageURL), pdf.Style{Size: 8, Color: pdf.Gray, Indent: 12, Bar: color})
+		}
+		doc.Space(12)
+	}
+	if _, err := doc.WriteTo(w); err != nil {
+		panic(err)
+	}
+}
+
+// speaker is the header for a message. Replies are stored with the system or assistant role
+func speaker(m structs.Message) string {
+	switch m.Role {
+	case structs.UserRole:
+		return "User"
+	case structs.SystemRole, structs.AssistantRole:
+		if m.ModelName != "" {
+			return fmt.Sprintf("Assistant (%s)", m.ModelName)
+		}
+		return "Assistant"
+	case structs.ToolRole, structs.FunctionRole:
+		if m.ToolName != "" {
+			return fmt.Sprintf("Tool (%s)", m.ToolName)
+		}
+		return "Tool"
+	}
+	if m.Role == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(string(m.Role[:1])) + string(m.Role[1:])
+}
@@ -0,0 +1,373 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/authn"
+	"chat-history/db"
+	"chat-history/hpke"
+	"chat-history/structs"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func export(t *testing.T, handler http.HandlerFunc, user, conversationId, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversations/{conversationId}/export", handler)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversations/%s/export?format=%s", conversationId, format), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestExportConversation_JSON(t *testing.T) {
+	store := setupDB(t, true)
+	resp := export(t, ExportConversation(store), USER, CONVO_ID, "json")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type should be application/json. It's: %s", ct)
+	}
+
+	var out exportedConversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ConversationId.String() != CONVO_ID || out.Name != "conv1" || out.UserId != USER {
+		t.Fatalf("exported conversation is wrong: %+v", out)
+	}
+	if len(out.Messages) != 2 {
+		t.Fatalf("export should have 2 messages. It has: %d", len(out.Messages))
+	}
+	first, second := out.Messages[0], out.Messages[1]
+	if first.Role != structs.UserRole || first.Content != "This is the first message, there is no parent" ||
+		second.Role != structs.SystemRole || second.Content != "Hello, how may I help you?" {
+		t.Fatalf("messages should be in the order they were sent with their roles: %+v", out.Messages)
+	}
+	if first.CreatedAt.IsZero() || second.CreatedAt.Before(first.CreatedAt) {
+		t.Fatalf("messages should have timestamps, oldest first: %v %v", first.CreatedAt, second.CreatedAt)
+	}
+}
+
+func TestExportConversation_Markdown(t *testing.T) {
+	store := setupDB(t, true)
+	resp := export(t, ExportConversation(store), USER, CONVO_ID, "markdown")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/markdown") {
+		t.Fatalf("Content-Type should be text/markdown. It's: %s", ct)
+	}
+
+	md := resp.Body.String()
+	user := strings.Index(md, "## User\n\n")
+	assistant := strings.Index(md, "## Assistant (GPT-4o)\n\n")
+	if !strings.HasPrefix(md, "# conv1\n") || user < 0 || assistant < user {
+		t.Fatalf("markdown should have the title and a header per turn, in order. It's:\n%s", md)
+	}
+	if !strings.Contains(md, "This is the first message, there is no parent") || !strings.Contains(md, "Hello, how may I help you?") {
+		t.Fatalf("markdown should have the message contents. It's:\n%s", md)
+	}
+}
+
+func TestExportConversation_Attachments(t *testing.T) {
+	store := setupDB(t, true)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := messages[0]
+	if _, err := store.AddAttachment(USER, CONVO_ID, first.MessageId.String(), structs.Attachment{
+		Filename: "fraud.png", ContentType: "image/png", Size: 2048, StorageURL: "https://files.example.com/fraud.png",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out exportedConversation
+	if err := json.Unmarshal(export(t, ExportConversation(store), USER, CONVO_ID, "json").Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := exportedAttachment{Filename: "fraud.png", ContentType: "image/png", Size: 2048, StorageURL: "https://files.example.com/fraud.png"}
+	for _, m := range out.Messages {
+		if m.MessageId == first.MessageId && (len(m.Attachments) != 1 || m.Attachments[0] != want) {
+			t.Fatalf("the message should have its attachment: %+v", m)
+		} else if m.MessageId != first.MessageId && len(m.Attachments) != 0 {
+			t.Fatalf("only the first message has an attachment: %+v", m)
+		}
+	}
+
+	md := export(t, ExportConversation(store), USER, CONVO_ID, "markdown").Body.String()
+	if !strings.Contains(md, "- fraud.png (image/png, 2048 bytes): https://files.example.com/fraud.png\n") {
+		t.Fatalf("markdown should list the attachment. It's:\n%s", md)
+	}
+}
+
+func TestExportConversation_Empty(t *testing.T) {
+	convoId := uuid.New()
+	store := fakeStore{convos: map[string][]structs.Conversation{
+		USER: {{UserId: USER, ConversationId: convoId, Name: "empty"}},
+	}}
+
+	resp := export(t, ExportConversation(store), USER, convoId.String(), "json")
+	var out exportedConversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 200 || out.Messages == nil || len(out.Messages) != 0 {
+		t.Fatalf("an empty conversation should export with an empty list of messages. Got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	resp = export(t, ExportConversation(store), USER, convoId.String(), "markdown")
+	if md := resp.Body.String(); resp.Code != 200 || !strings.HasPrefix(md, "# empty\n") || !strings.Contains(md, "_No messages_") {
+		t.Fatalf("an empty conversation should export as just its title. Got %d:\n%s", resp.Code, md)
+	}
+}
+
+func TestExportConversation_PDF(t *testing.T) {
+	tmp := t.TempDir()
+	store := db.InitDB(tmp+"/test.db", tmp+"/test.log")
+	question := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Qu'est-ce qu'un « anneau de fraude » ?", Role: structs.UserRole}
+	if _, err := store.CreateConversation(USER, "Fraude – café", question); err != nil {
+		t.Fatal(err)
+	}
+	parent := question
+	for i := 0; i < 100; i++ {
+		m := structs.Message{ConversationId: question.ConversationId, MessageId: uuid.New(), ParentId: &parent.MessageId, Content: fmt.Sprintf("Réponse %d: %s", i, strings.Repeat("un groupe de comptes liés ", 20)), Role: structs.AssistantRole}
+		if i%2 == 1 {
+			m.Content, m.Role = fmt.Sprintf("Question %d, 日本語?", i), structs.UserRole
+		}
+		if _, err := store.AppendMessage(m, db.AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		parent = m
+	}
+
+	resp := export(t, ExportConversation(store), USER, question.ConversationId.String(), "pdf")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("Content-Type should be application/pdf. It's: %s", ct)
+	}
+	if cd := resp.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, `.pdf"`) {
+		t.Fatalf("the file should be a .pdf. It's: %s", cd)
+	}
+	body := resp.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.HasSuffix(body, []byte("%%EOF\n")) {
+		t.Fatalf("the export should be a PDF. It starts with %q", body[:min(len(body), 20)])
+	}
+	count := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(body)
+	if count == nil {
+		t.Fatal("the PDF should have a page tree")
+	}
+	// 50 long replies of 4 lines and 50 short questions, each with a header
+	pages, _ := strconv.Atoi(string(count[1]))
+	if pages < 10 || pages > 20 || bytes.Count(body, []byte("/Type /Page /")) != pages {
+		t.Fatalf("101 messages should be 10 to 20 pages. They're %d", pages)
+	}
+}
+
+func TestExportConversation_Encrypted(t *testing.T) {
+	store := setupDB(t, true)
+	priv, pub, err := hpke.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		format, recipient, contentType, filename string
+	}{
+		{"json", base64.StdEncoding.EncodeToString(pub), "application/json", CONVO_ID + ".json"},
+		{"markdown", base64.RawURLEncoding.EncodeToString(pub), "text/markdown; charset=utf-8", CONVO_ID + ".md"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			resp := export(t, ExportConversation(store), USER, CONVO_ID, tt.format+"&recipient="+url.QueryEscape(tt.recipient))
+			if resp.Code != 200 {
+				t.Fatalf("Response code should be 200. It is: %v %s", resp.Code, resp.Body.String())
+			}
+			if cd := resp.Header().Get("Content-Disposition"); !strings.Contains(cd, CONVO_ID+".encrypted.json") {
+				t.Fatalf("encrypted exports should be named for what they are, got %s", cd)
+			}
+			if strings.Contains(resp.Body.String(), "Hello, how may I help you?") {
+				t.Fatal("the export shouldn't be readable without the private key")
+			}
+
+			var out encryptedExport
+			if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Suite != hpke.Suite || out.ContentType != tt.contentType || out.Filename != tt.filename {
+				t.Fatalf("the envelope should describe the export: %+v", out)
+			}
+			plaintext, err := hpke.Open(priv, out.Enc, []byte(exportInfo), nil, out.Ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(plaintext), "Hello, how may I help you?") {
+				t.Fatalf("the opened export should have the conversation's messages, got %s", plaintext)
+			}
+
+			// the same export without a recipient
+			plain := export(t, ExportConversation(store), USER, CONVO_ID, tt.format)
+			if tt.format == "json" && string(plaintext) != plain.Body.String() {
+				t.Fatalf("the opened export should be the unencrypted one:\n%s\n%s", plaintext, plain.Body.String())
+			}
+
+			other, _, _ := hpke.GenerateKey()
+			if _, err := hpke.Open(other, out.Enc, []byte(exportInfo), nil, out.Ciphertext); err == nil {
+				t.Fatal("only the recipient should be able to open the export")
+			}
+		})
+	}
+}
+
+func TestExportConversation_Errors(t *testing.T) {
+	store := setupDB(t, true)
+	tests := []struct {
+		name           string
+		user           string
+		conversationId string
+		format         string
+		code           int
+	}{
+		{"other user", "Miss_Take", CONVO_ID, "json", http.StatusForbidden},
+		{"not found", USER, uuid.NewString(), "json", http.StatusNotFound},
+		{"bad format", USER, CONVO_ID, "docx", http.StatusBadRequest},
+		{"bad recipient", USER, CONVO_ID, "json&recipient=bm90LWEta2V5", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := export(t, ExportConversation(store), tt.user, tt.conversationId, tt.format); resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.Code)
+			}
+		})
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	store := setupDB(t, true)
+	convos, _, err := store.ListConversations(USER, db.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}/export", ExportUserData(store))
+	do := func(user, userId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/export", userId), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := do(USER, USER)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type should be application/x-ndjson. It's: %s", ct)
+	}
+	if cd := resp.Header().Get("Content-Disposition"); !strings.Contains(cd, USER+".ndjson") {
+		t.Fatalf("Content-Disposition should name the download after the user. It's: %s", cd)
+	}
+	lines := strings.Split(strings.TrimSuffix(resp.Body.String(), "\n"), "\n")
+	if len(lines) != len(convos) {
+		t.Fatalf("there should be a line for each of the user's %d conversations. There are %d", len(convos), len(lines))
+	}
+	for _, line := range lines {
+		var out exportedConversation
+		if err := json.Unmarshal([]byte(line), &out); err != nil {
+			t.Fatalf("each line should be a conversation: %v: %s", err, line)
+		}
+		if out.UserId != USER || len(out.Messages) == 0 {
+			t.Fatalf("only the user's conversations should be exported, with their messages: %+v", out)
+		}
+	}
+
+	// someone else's data
+	if resp := do("Miss_Take", USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("Response code should be 403. It is: %v", resp.Code)
+	}
+	// no conversations is an empty download
+	if resp := do("Mr_Nobody", "Mr_Nobody"); resp.Code != 200 || resp.Body.Len() != 0 {
+		t.Fatalf("a user without conversations should get an empty download. Got %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestExportUserData_Hidden(t *testing.T) {
+	store := setupDB(t, true)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := mergeConversationHistory(messages)
+	retrieved := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), ParentId: &last[len(last)-1].MessageId, Role: structs.ContextRole, Content: "Account 42 is flagged"}
+	if _, err := store.AppendMessage(retrieved, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	do := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/user/"+USER+"/export"+query, nil)
+		req.SetPathValue("userId", USER)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		ExportUserData(store)(resp, req)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		return resp.Body.String()
+	}
+
+	if body := do(""); strings.Contains(body, retrieved.MessageId.String()) || strings.Contains(body, retrieved.Content) {
+		t.Fatalf("the context messages should be left out of the export. It's: %s", body)
+	}
+	if body := do("?include_hidden=true"); !strings.Contains(body, retrieved.MessageId.String()) {
+		t.Fatalf("the context messages should be exported with include_hidden=true. It's: %s", body)
+	}
+
+	// nor are they the user's feedback
+	req := httptest.NewRequest(http.MethodGet, "/get_feedback", nil)
+	req = req.WithContext(authn.NewContext(req.Context(), authn.Identity{UserId: USER, Roles: []string{"globaldesigner"}}))
+	resp := httptest.NewRecorder()
+	GetFeedback(store, "", "", func() []string { return []string{"superuser"} })(resp, req)
+	if resp.Code != 200 || strings.Contains(resp.Body.String(), retrieved.MessageId.String()) {
+		t.Fatalf("the context messages should be left out of the user's feedback. Got %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestExportConversation_Tool(t *testing.T) {
+	tmp := t.TempDir()
+	store := db.InitDB(tmp+"/test.db", tmp+"/test.log", db.MessageRoles(structs.ToolRole))
+	question := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "How many accounts are flagged?", Role: structs.UserRole}
+	if _, err := store.CreateConversation(USER, "tools", question); err != nil {
+		t.Fatal(err)
+	}
+	result := structs.Message{ConversationId: question.ConversationId, MessageId: uuid.New(), ParentId: &question.MessageId, Content: `{"flagged": 12}`, Role: structs.ToolRole, ToolName: "count_flagged", ToolCallId: "call_42"}
+	if _, err := store.AppendMessage(result, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := export(t, ExportConversation(store), USER, question.ConversationId.String(), "json")
+	var out exportedConversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 2 || out.Messages[1].Role != structs.ToolRole || out.Messages[1].ToolName != "count_flagged" || out.Messages[1].ToolCallId != "call_42" {
+		t.Fatalf("the tool message should be exported with its metadata: %s", resp.Body)
+	}
+	if md := export(t, ExportConversation(store), USER, question.ConversationId.String(), "markdown").Body.String(); !strings.Contains(md, "## Tool (count_flagged)\n\n") {
+		t.Fatalf("markdown should have a header for the tool's result. It's:\n%s", md)
+	}
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"fmt"
+	"net/http"
+)
+
+// Features returns what the handlers of a feature in config.Features are wrapped with. Handlers of the
+// features in cfg.DisabledFeatures are replaced with one that answers 501, i.e., the summary feature is disabled
+func Features(cfg config.ChatDbConfig) func(feature string, h http.Handler) http.Handler {
+	return func(feature string, h http.Handler) http.Handler {
+		if cfg.Enabled(feature) {
+			return h
+		}
+		return FeatureDisabled(feature)
+	}
+}
+
+// FeatureDisabled answers every request with 501, saying the feature is disabled on this instance
+func FeatureDisabled(feature string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented,
+			fmt.Sprintf("the %s feature is disabled on this instance", feature)))
+	}
+}
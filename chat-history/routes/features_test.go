@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	store := setupDB(t, true)
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, fakeRoles(map[string][]string{USER: {"globaldesigner"}}))
+	feature := Features(config.ChatDbConfig{DisabledFeatures: []string{config.FeatureSummary, config.FeatureShare}})
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversations/{conversationId}/summary", feature(config.FeatureSummary, withRoles(SummarizeConversation(store, nil, config.LLMConfig{}))))
+	mux.Handle("GET /shared/{token}", feature(config.FeatureShare, GetSharedConversation(store)))
+	mux.Handle("GET /conversations/{conversationId}/export", feature(config.FeatureExport, withRoles(ExportConversation(store))))
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for path, name := range map[string]string{"/conversations/" + CONVO_ID + "/summary": "summary", "/shared/token": "share"} {
+		resp := do(path)
+		var body apierror.APIError
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != http.StatusNotImplemented || body.Code != apierror.CodeNotImplemented || !strings.Contains(body.Message, "the "+name+" feature is disabled") {
+			t.Fatalf("%s should be disabled. Got %d: %s", path, resp.Code, resp.Body)
+		}
+	}
+	// the ones that aren't disabled still work
+	if resp := do("/conversations/" + CONVO_ID + "/export?format=json"); resp.Code != http.StatusOK {
+		t.Fatalf("export should work. Got %d: %s", resp.Code, resp.Body)
+	}
+}
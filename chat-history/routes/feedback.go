@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/audit"
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+	"time"
+)
+
+type feedbackRequest struct {
+	Rating  structs.Feedback `json:"rating"`
+	Comment string           `json:"comment"`
+}
+
+// Rate an answer, thumbs up (1) or thumbs down (2), with an optional comment. Rating it again replaces the rating
+// "PUT /conversation/{conversationId}/messages/{messageId}/feedback" with {"rating": int, "comment": "..."}
+// Anyone who can read the conversation has their own feedback
+func SetMessageFeedback(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationReader(w, r, store)
+		if !ok {
+			return
+		}
+		var req feedbackRequest
+		if apiErr := decodeBody(r, &req, `body must be {"rating": int, "comment": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		feedback, err := store.SetFeedback(userId, r.PathValue("conversationId"), r.PathValue("messageId"), req.Rating, req.Comment)
+		if err != nil {
+			writeError(w, storeError(err, messageNotFound(r), "failed to save feedback"))
+			return
+		}
+		if out, err := marshal(r, feedback); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get the caller's feedback on an answer
+// "GET /conversation/{conversationId}/messages/{messageId}/feedback"
+func GetMessageFeedback(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationReader(w, r, store)
+		if !ok {
+			return
+		}
+
+		feedback, err := store.GetFeedback(userId, r.PathValue("conversationId"), r.PathValue("messageId"))
+		if err != nil {
+			writeError(w, storeError(err, "no feedback on "+messageNotFound(r), "failed to retrieve feedback"))
+			return
+		}
+		if out, err := marshal(r, feedback); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Export the feedback on every rated answer, with the counts of each rating and the comments. Callers need one
+// of the admin roles (see RequireAdmin)
+// "GET /admin/feedback?since=RFC3339"
+// since only exports the answers rated since then. Every export is written to the audit log
+func AdminExportFeedback(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, apierror.InvalidRequest("since must be an RFC 3339 timestamp"))
+				return
+			}
+			since = t
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "export_feedback"}) {
+			return
+		}
+
+		summaries, err := store.AggregateFeedback(since)
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to retrieve feedback"))
+			return
+		}
+		if out, err := marshal(r, summaries); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// conversationReader returns the caller if they can read the conversation in r's path. Conversations they
+// can't read are reported as not found, so their ids aren't revealed. It responds with the error if they can't
+func conversationReader(w http.ResponseWriter, r *http.Request, store db.ConversationStore) (string, bool) {
+	userId, authErr := auth("", r)
+	if authErr != nil {
+		writeError(w, authErr)
+		return "", false
+	}
+	convo, err := store.FindConversation(r.PathValue("conversationId"))
+	if err == nil && !allowed(r, store, userId, convo, structs.PermissionRead) {
+		err = db.ErrNotFound
+	}
+	if err != nil {
+		writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve conversation"))
+		return "", false
+	}
+	return userId, true
+}
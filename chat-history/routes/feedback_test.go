@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageFeedback(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}, "Mr_Nobody": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{"globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}/feedback", withRoles(SetMessageFeedback(store)))
+	mux.Handle("GET /conversation/{conversationId}/messages/{messageId}/feedback", withRoles(GetMessageFeedback(store)))
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first uuid.UUID
+	for _, m := range messages {
+		if m.Role == structs.UserRole {
+			first = m.MessageId
+			break
+		}
+	}
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "the answer", Role: structs.AssistantRole}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	feedbackPath := fmt.Sprintf("/conversation/%s/messages/%s/feedback", CONVO_ID, reply.MessageId)
+	get := func(user string) structs.MessageFeedback {
+		t.Helper()
+		resp := do(http.MethodGet, feedbackPath, user, "")
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var feedback structs.MessageFeedback
+		json.Unmarshal(resp.Body.Bytes(), &feedback)
+		return feedback
+	}
+
+	if resp := do(http.MethodGet, feedbackPath, USER, ""); resp.Code != 404 {
+		t.Fatalf("there's no feedback before it's set. Response code should be 404. It is: %v", resp.Code)
+	}
+	resp := do(http.MethodPut, feedbackPath, USER, `{"rating": 2, "comment": "wrong graph"}`)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if got := get(USER); got.Rating != structs.ThumbsDown || got.Comment != "wrong graph" || got.UserId != USER {
+		t.Fatalf("the feedback should be read back. Got: %+v", got)
+	}
+	// overwritten
+	if resp := do(http.MethodPut, feedbackPath, USER, `{"rating": 1}`); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if got := get(USER); got.Rating != structs.ThumbsUp || got.Comment != "" {
+		t.Fatalf("the feedback should be replaced. Got: %+v", got)
+	}
+
+	// users the conversation is shared with rate it too, others can't see it
+	if _, err := store.GrantAccess(USER, CONVO_ID, structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := do(http.MethodPut, feedbackPath, "Miss_Take", `{"rating": 2}`); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if got := get("Miss_Take"); got.Rating != structs.ThumbsDown || got.UserId != "Miss_Take" {
+		t.Fatalf("the reader should have their own feedback. Got: %+v", got)
+	}
+	if got := get(USER); got.Rating != structs.ThumbsUp {
+		t.Fatalf("the owner's feedback shouldn't change. Got: %+v", got)
+	}
+	if resp := do(http.MethodPut, feedbackPath, "Mr_Nobody", `{"rating": 1}`); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+
+	for _, tt := range []struct{ name, path, body string }{
+		{"no rating", feedbackPath, `{"comment": "meh"}`},
+		{"unknown rating", feedbackPath, `{"rating": 7}`},
+		{"not json", feedbackPath, `thumbs up`},
+		{"user's message", fmt.Sprintf("/conversation/%s/messages/%s/feedback", CONVO_ID, first), `{"rating": 1}`},
+	} {
+		if resp := do(http.MethodPut, tt.path, USER, tt.body); resp.Code != 400 {
+			t.Fatalf("%s: response code should be 400. It is: %v: %s", tt.name, resp.Code, resp.Body)
+		}
+	}
+	if resp := do(http.MethodPut, fmt.Sprintf("/conversation/%s/messages/%s/feedback", CONVO_ID, uuid.New()), USER, `{"rating": 1}`); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+}
+
+func TestAdminExportFeedback(t *testing.T) {
+	handler, store, pth := setupAdminStore(t, []string{"supportstaff"})
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "the answer", Role: structs.AssistantRole, ModelName: "gpt-4o"}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetFeedback(USER, CONVO_ID, reply.MessageId.String(), structs.ThumbsUp, "spot on"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := adminRequest(handler, "/admin/feedback", "support")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var summaries []structs.FeedbackSummary
+	json.Unmarshal(resp.Body.Bytes(), &summaries)
+	if len(summaries) != 1 || summaries[0].MessageId != reply.MessageId || summaries[0].ModelName != "gpt-4o" ||
+		summaries[0].ThumbsUp != 1 || len(summaries[0].Comments) != 1 || summaries[0].Comments[0] != "spot on" {
+		t.Fatalf("the answer's feedback should be exported: %s", resp.Body)
+	}
+	if entries := readAudit(t, pth); len(entries) != 1 || entries[0].Action != "export_feedback" || entries[0].Actor != "support" {
+		t.Fatalf("the export should be audited: %+v", entries)
+	}
+
+	if resp := adminRequest(handler, "/admin/feedback?since=yesterday", "support"); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+	if resp := adminRequest(handler, "/admin/feedback", USER); resp.Code != 403 {
+		t.Fatalf("only admins can export feedback. Response code should be 403. It is: %v", resp.Code)
+	}
+}
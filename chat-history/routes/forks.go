@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type forkRequest struct {
+	MessageId string `json:"message_id"`
+}
+
+// Start a new conversation from the messages of another one up to and including message_id, to take it somewhere
+// else without changing the original
+// "POST /conversation/{conversationId}/fork" with {"message_id": "..."}
+// Only the branch that leads to the message is copied. The fork belongs to the caller, who needs read access
+// to the original, and its parent_id is the original's id
+func ForkConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		ownerId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+		userId, _ := caller(r)
+
+		var req forkRequest
+		if apiErr := decodeBody(r, &req, `body must be {"message_id": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		messageId := strings.TrimSpace(req.MessageId)
+		if messageId == "" {
+			writeError(w, apierror.InvalidRequest("message_id is required"))
+			return
+		}
+
+		fork, err := store.ForkConversation(ownerId, r.PathValue("conversationId"), messageId, userId)
+		if err != nil {
+			notFound := fmt.Sprintf("message %s not found in conversation %s", messageId, r.PathValue("conversationId"))
+			writeError(w, storeError(err, notFound, "failed to fork the conversation"))
+			return
+		}
+		if out, err := marshal(r, fork); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestForkConversation(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/fork", withRoles(ForkConversation(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	forkPath := fmt.Sprintf("/conversation/%s/fork", CONVO_ID)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) < 2 {
+		t.Fatalf("the conversation should have messages. Got %d, %v", len(messages), err)
+	}
+	upto := fmt.Sprintf(`{"message_id": %q}`, messages[1].MessageId)
+
+	// other users need read access
+	if resp := do(http.MethodPost, forkPath, "Miss_Take", upto); resp.Code != http.StatusNotFound {
+		t.Fatalf("the conversation shouldn't be forked without access. Got %v: %s", resp.Code, resp.Body)
+	}
+	if _, err := store.GrantAccess(USER, CONVO_ID, structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := do(http.MethodPost, forkPath, "Miss_Take", upto)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var fork structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &fork)
+	if fork.UserId != "Miss_Take" || fork.ParentId == nil || fork.ParentId.String() != CONVO_ID {
+		t.Fatalf("the fork should be the caller's with the original as its parent: %s", resp.Body)
+	}
+	resp = do(http.MethodGet, "/conversation/"+fork.ConversationId.String(), "Miss_Take", "")
+	var copied []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &copied)
+	if resp.Code != 200 || len(copied) != 2 || copied[0].Content != messages[0].Content || copied[1].Content != messages[1].Content {
+		t.Fatalf("the fork should have the first two messages. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// requests that can't be forked
+	tests := []struct {
+		name, body string
+		code       int
+	}{
+		{"no message", `{}`, http.StatusBadRequest},
+		{"not JSON", `nope`, http.StatusBadRequest},
+		{"unknown message", fmt.Sprintf(`{"message_id": %q}`, uuid.New()), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := do(http.MethodPost, forkPath, USER, tt.body); resp.Code != tt.code {
+			t.Fatalf("%s: response code should be %d. It is: %v: %s", tt.name, tt.code, resp.Code, resp.Body)
+		}
+	}
+}
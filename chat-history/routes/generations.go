@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxGeneration is how long a reply can hold its conversation's lock when the request has no deadline
+// (see chat_config.handlerTimeoutSeconds), in case the LLM never ends it
+const maxGeneration = 10 * time.Minute
+
+// generationLocks are the conversations an LLM reply is being generated for. Only one reply to a conversation is
+// generated at a time, two would be added to the same branch with their messages interleaved. The locks are only
+// held in this process
+type generationLocks struct {
+	mu   sync.Mutex
+	held map[uuid.UUID]generationLock
+	// last is the id of the last lock taken, so a lock that expired and was taken again isn't released by its first holder
+	last uint64
+}
+
+type generationLock struct {
+	id      uint64
+	expires time.Time
+}
+
+// generating is shared by the handlers that stream replies, so a reply and a resumed one exclude each other too
+var generating = &generationLocks{held: map[uuid.UUID]generationLock{}}
+
+// lock takes the conversation's lock until unlock is called or until expires, whichever is first. It returns
+// false if a reply is already being generated for it
+func (l *generationLocks) lock(conversationId uuid.UUID, expires time.Time) (unlock func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held, ok := l.held[conversationId]; ok && time.Now().Before(held.expires) {
+		return nil, false
+	}
+	l.last++
+	id := l.last
+	l.held[conversationId] = generationLock{id: id, expires: expires}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.held[conversationId].id == id {
+			delete(l.held, conversationId)
+		}
+	}, true
+}
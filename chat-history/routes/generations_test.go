@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"bufio"
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStreamConversation_OneAtATime(t *testing.T) {
+	store := setupStreamDB(t)
+	history, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "How many accounts?", Role: structs.UserRole}
+	if _, err := store.CreateConversation(USER, "conv2", other); err != nil {
+		t.Fatal(err)
+	}
+	client := newStreamingLLM()
+	handler := StreamConversation(store, client, config.LLMConfig{ModelName: "GPT-4o"})
+
+	first := startStream(t, handler, context.Background(), USER, CONVO_ID)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v", first.StatusCode)
+	}
+	events := bufio.NewReader(first.Body)
+	client.chunks <- "Sure, "
+	readEvent(t, events)
+
+	// the second generation for the conversation is refused while the first is streamed, resuming included
+	second := startStream(t, handler, context.Background(), USER, CONVO_ID)
+	var body apierror.APIError
+	json.NewDecoder(second.Body).Decode(&body)
+	if second.StatusCode != http.StatusConflict || body.Code != apierror.CodeGenerationInProgress {
+		t.Fatalf("a second generation should be a 409 %s. Got %d %s", apierror.CodeGenerationInProgress, second.StatusCode, body.Code)
+	}
+	resume := startResume(t, ResumeStream(store, newStreamingLLM(), config.LLMConfig{}), USER, CONVO_ID, history[1].MessageId.String())
+	if resume.StatusCode != http.StatusConflict {
+		t.Fatalf("resuming while a reply is generated should be a 409. Got %d", resume.StatusCode)
+	}
+	// other conversations aren't locked
+	otherClient := newStreamingLLM()
+	close(otherClient.chunks)
+	if resp := startStream(t, StreamConversation(store, otherClient, config.LLMConfig{}), context.Background(), USER, other.ConversationId.String()); resp.StatusCode != http.StatusOK {
+		t.Fatalf("another conversation should be streamed. Got %d", resp.StatusCode)
+	}
+
+	close(client.chunks)
+	if event, data := readEvent(t, events); event != "done" {
+		t.Fatalf("expected a done event. Got %s: %s", event, data)
+	}
+	first.Body.Close()
+
+	// the lock is released once the reply is done
+	client = newStreamingLLM()
+	close(client.chunks)
+	var third *http.Response
+	for deadline := time.Now().Add(time.Second); ; {
+		third = startStream(t, StreamConversation(store, client, config.LLMConfig{}), context.Background(), USER, CONVO_ID)
+		if third.StatusCode != http.StatusConflict || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if third.StatusCode != http.StatusOK {
+		t.Fatalf("the conversation should be unlocked once the reply is done. Got %d", third.StatusCode)
+	}
+}
+
+func TestGenerationLocks(t *testing.T) {
+	locks := &generationLocks{held: map[uuid.UUID]generationLock{}}
+	convoId := uuid.New()
+
+	unlock, ok := locks.lock(convoId, time.Now().Add(time.Hour))
+	if !ok {
+		t.Fatal("the conversation should be locked")
+	}
+	if _, ok := locks.lock(convoId, time.Now().Add(time.Hour)); ok {
+		t.Fatal("a locked conversation shouldn't be locked again")
+	}
+	unlock()
+	if _, ok := locks.lock(convoId, time.Now().Add(-time.Second)); !ok {
+		t.Fatal("the conversation should be unlocked")
+	}
+
+	// a lock that expired can be taken, and its first holder doesn't release the new one
+	stale, ok := locks.lock(convoId, time.Now().Add(time.Hour))
+	if !ok {
+		t.Fatal("an expired lock should be taken")
+	}
+	locks.held[convoId] = generationLock{id: locks.held[convoId].id, expires: time.Now().Add(-time.Second)}
+	if _, ok := locks.lock(convoId, time.Now().Add(time.Hour)); !ok {
+		t.Fatal("an expired lock should be taken")
+	}
+	stale()
+	if _, ok := locks.lock(convoId, time.Now().Add(time.Hour)); ok {
+		t.Fatal("the expired lock's holder shouldn't release the new one")
+	}
+}
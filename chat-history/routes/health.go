@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/tigergraph"
+	"context"
+	"net/http"
+	"time"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+	Failed []string          `json:"failed,omitempty"`
+	// features that don't work while a dependency is down
+	Degraded []string `json:"degraded,omitempty"`
+}
+
+// Readiness check for the chat DB and TigerGraph
+// Returns 200 when both are reachable, and 503 naming the failed dependencies if the DB isn't.
+// Chat history can still be read without TigerGraph, so if only it's down the status is degraded,
+// with a 200, and tgFeatures (the features that need it) are listed as degraded
+// "GET /healthz"
+func Healthz(store db.ConversationStore, hostname, gsPort string, tgTimeout time.Duration, tgFeatures []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{Status: "OK", Checks: map[string]string{}}
+		check := func(name string, err error) {
+			if err != nil {
+				resp.Checks[name] = err.Error()
+				resp.Failed = append(resp.Failed, name)
+				return
+			}
+			resp.Checks[name] = "OK"
+		}
+
+		dbErr := store.Ping()
+		check("db", dbErr)
+		tgErr := pingTigerGraph(r.Context(), hostname, gsPort, tgTimeout)
+		check("tigergraph", tgErr)
+
+		w.Header().Add("Content-Type", "application/json")
+		if dbErr != nil {
+			resp.Status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if tgErr != nil {
+			resp.Status = "degraded"
+			resp.Degraded = tgFeatures
+		}
+		out, _ := marshal(r, resp)
+		w.Write(out)
+	}
+}
+
+// pingTigerGraph pings TigerGraph, giving up after timeout
+func pingTigerGraph(ctx context.Context, hostname, gsPort string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return tigergraph.Ping(ctx, hostname, gsPort)
+}
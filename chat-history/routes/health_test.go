@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeTigerGraph starts a test server and returns the hostname and gsPort to reach it
+func fakeTigerGraph(t *testing.T, handler http.HandlerFunc) (string, string) {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Scheme + "://" + u.Hostname(), u.Port()
+}
+
+func TestHealthz(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/restpp/echo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"error":false, "message":"Hello GSQL"}`))
+	}
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}
+
+	tests := []struct {
+		name    string
+		tg      http.HandlerFunc
+		dbErr   error
+		code    int
+		status  string
+		failed  []string
+		maxTime time.Duration
+	}{
+		{name: "healthy", tg: ok, code: 200, status: "OK"},
+		// chat history can still be read
+		{name: "tigergraph down", tg: failing, code: 200, status: "degraded", failed: []string{"tigergraph"}},
+		{name: "tigergraph slow", tg: slow, code: 200, status: "degraded", failed: []string{"tigergraph"}, maxTime: time.Second},
+		{name: "db down", tg: ok, dbErr: errors.New("disk I/O error"), code: 503, status: "unavailable", failed: []string{"db"}},
+		{name: "both down", tg: failing, dbErr: errors.New("disk I/O error"), code: 503, status: "unavailable", failed: []string{"db", "tigergraph"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostname, gsPort := fakeTigerGraph(t, tt.tg)
+			store := fakeStore{pingErr: tt.dbErr}
+			handler := Healthz(store, hostname, gsPort, 100*time.Millisecond, []string{"feedback"})
+
+			req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			resp := httptest.NewRecorder()
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+
+			if tt.maxTime > 0 && time.Since(start) > tt.maxTime {
+				t.Fatalf("health check should time out quickly. It took: %v", time.Since(start))
+			}
+			if resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.Code)
+			}
+			var body healthResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(body.Failed, tt.failed) {
+				t.Fatalf("failed dependencies should be %v. They are: %v", tt.failed, body.Failed)
+			}
+			if body.Status != tt.status {
+				t.Fatalf("status should be %s. It is: %s", tt.status, body.Status)
+			}
+			if degraded := body.Status == "degraded"; degraded != slices.Equal(body.Degraded, []string{"feedback"}) {
+				t.Fatalf("the features that need TigerGraph should only be degraded while just it is down. They are: %v", body.Degraded)
+			}
+		})
+	}
+}
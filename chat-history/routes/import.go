@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/chatgpt"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/portable"
+	"chat-history/structs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Import messages from another system into a conversation, creating it if it doesn't exist
+// "POST /conversations/{conversationId}/import"
+// The body is a JSON array of messages in the order they were sent. Either all of them are imported or none are.
+// Like every body, it can't be over chat_config.maxRequestBodyBytes
+func ImportMessages(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		var messages []structs.Message
+		if apiErr := decodeBody(r, &messages, "body must be a JSON array of messages"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		// superusers and users granted write access can import into the conversation, import it as its owner
+		userId = actAs(r, store, userId, conversationId, structs.PermissionWrite)
+		name := ""
+		if len(messages) > 0 {
+			name = llm.FallbackTitle(messages[0].Content)
+		}
+
+		// conversations of other users are reported as not found too, so their ids aren't revealed
+		convo, err := store.BulkAppendMessages(userId, conversationId, name, messages)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to import messages"))
+			return
+		}
+
+		out, err := marshal(r, convo)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// Import a conversation exported with GET /conversations/{conversationId}/export?format=portable, as the caller's
+// "POST /import/portable"
+// It's imported as it was exported, with its id, tags, messages and attachments, so it can be moved between
+// deployments. Files of older schema versions are upgraded, see portable.SchemaVersion, which includes the JSON
+// export. A conversation with the id that's already there, even in the trash, is a 409. Like every body, it can't
+// be over chat_config.maxRequestBodyBytes
+func ImportPortable(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		const usage = "The body must be a conversation exported with format=portable"
+		var body json.RawMessage
+		if apiErr := decodeBody(r, &body, usage); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		export, err := portable.Unmarshal(body)
+		if errors.Is(err, portable.ErrUnsupportedVersion) {
+			writeError(w, apierror.InvalidRequest(err.Error()))
+			return
+		} else if err != nil {
+			writeError(w, apierror.InvalidRequest(decodeError(err)+". "+usage))
+			return
+		}
+
+		convo, messages, attachments := export.Data()
+		imported, err := store.ImportConversation(userId, db.ConversationData{Conversation: convo, Messages: messages, Attachments: attachments})
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to import the conversation"))
+			return
+		}
+
+		out, err := marshal(r, imported)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+type chatGPTImportError struct {
+	// the position of the conversation in the export
+	Index int    `json:"index"`
+	Title string `json:"title,omitempty"`
+	Error string `json:"error"`
+}
+
+type chatGPTImport struct {
+	Imported []*structs.Conversation `json:"imported"`
+	Errors   []chatGPTImportError    `json:"errors"`
+}
+
+// Import the caller's conversations from the conversations.json of a ChatGPT data export
+// "POST /import/chatgpt"
+// Each conversation is imported on its own, the ones that can't be are reported in errors with why, which
+// includes the ones that were imported before. Like every body, it can't be over chat_config.maxRequestBodyBytes
+func ImportChatGPT(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		convos, err := chatgpt.Parse(r.Body, userId)
+		if err != nil {
+			writeError(w, bodyError(err, decodeError(err)+". The body must be the conversations.json of a ChatGPT export"))
+			return
+		}
+
+		result := chatGPTImport{Imported: []*structs.Conversation{}, Errors: []chatGPTImportError{}}
+		for _, c := range convos {
+			if c.Err == nil {
+				c.Err = importChatGPTConversation(store, userId, c, &result)
+			}
+			if c.Err != nil {
+				result.Errors = append(result.Errors, chatGPTImportError{Index: c.Index, Title: c.Title, Error: c.Err.Error()})
+			}
+		}
+
+		out, err := marshal(r, result)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// importChatGPTConversation imports c for userId, adding it to result. The error is why it wasn't, to report
+func importChatGPTConversation(store db.ConversationStore, userId string, c chatgpt.Conversation, result *chatGPTImport) error {
+	conversationId := c.ConversationId.String()
+	// its id is the same every time it's imported by the user
+	if _, err := store.FindConversation(conversationId); err == nil {
+		return fmt.Errorf("it was already imported, as conversation %s", conversationId)
+	} else if !errors.Is(err, db.ErrNotFound) {
+		slog.Error("failed to import a ChatGPT conversation", "conversation_id", conversationId, "err", err)
+		return errors.New("failed to import the conversation")
+	}
+
+	name := c.Title
+	if name == "" {
+		name = llm.FallbackTitle(c.Messages[0].Content)
+	}
+	convo, err := store.BulkAppendMessages(userId, conversationId, name, c.Messages)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return fmt.Errorf("it was already imported, as conversation %s which was deleted", conversationId)
+	case errors.Is(err, db.ErrInvalidMessages), errors.Is(err, db.ErrTooManyConversations):
+		return err
+	case err != nil:
+		slog.Error("failed to import a ChatGPT conversation", "conversation_id", conversationId, "err", err)
+		return errors.New("failed to import the conversation")
+	}
+	result.Imported = append(result.Imported, convo)
+	return nil
+}
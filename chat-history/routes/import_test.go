@@ -0,0 +1,239 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestImportMessages(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversations/{conversationId}/import", withRoles(ImportMessages(store)))
+
+	do := func(conversationId, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/conversations/%s/import", conversationId), strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	// n messages of a conversation in another system, each replying to the one before
+	export := func(n int) string {
+		messages := make([]structs.Message, n)
+		var parent *uuid.UUID
+		for i := range messages {
+			role := structs.UserRole
+			if i%2 == 1 {
+				role = structs.SystemRole
+			}
+			messages[i] = structs.Message{MessageId: uuid.New(), ParentId: parent, Role: role, Content: fmt.Sprintf("message %d", i)}
+			parent = &messages[i].MessageId
+		}
+		b, _ := json.Marshal(messages)
+		return string(b)
+	}
+
+	convoId := uuid.New().String()
+	resp := do(convoId, USER, export(1000))
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var convo structs.Conversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &convo); err != nil {
+		t.Fatal(err)
+	}
+	if convo.ConversationId.String() != convoId || convo.UserId != USER || convo.Name != "message 0" {
+		t.Fatalf("the conversation should be created for the user, named after its first message: %+v", convo)
+	}
+	if messages, _ := store.GetConversation(USER, convoId); len(messages) != 1000 {
+		t.Fatalf("all 1000 messages should be imported. There are %d", len(messages))
+	}
+
+	// superusers import into the owner's conversation
+	if resp := do(convoId, "admin", export(2)); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	// other users can't, and can't tell the conversation exists
+	if resp := do(convoId, "Miss_Take", export(2)); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+
+	// a malformed message fails the whole import
+	malformed := strings.Replace(export(10), `"role":"system"`, `"role":"admin"`, 1)
+	resp = do(convoId, USER, malformed)
+	if resp.Code != 400 || !bytes.Contains(resp.Body.Bytes(), []byte("message 1: role must be")) {
+		t.Fatalf("Response code should be 400 and name the message. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if messages, _ := store.GetConversation(USER, convoId); len(messages) != 1002 {
+		t.Fatalf("nothing from a failed import should be kept. There are %d messages", len(messages))
+	}
+	for _, body := range []string{`{"content":"not an array"}`, `[]`} {
+		if resp := do(uuid.New().String(), USER, body); resp.Code != 400 {
+			t.Fatalf("Response code for %s should be 400. It is: %v", body, resp.Code)
+		}
+	}
+}
+
+func TestImportChatGPT(t *testing.T) {
+	store := setupDB(t, true)
+	withRoles := RequireRoles([]string{"globaldesigner"}, fakeRoles(map[string][]string{USER: {"globaldesigner"}}))
+	mux := http.NewServeMux()
+	mux.Handle("POST /import/chatgpt", withRoles(ImportChatGPT(store)))
+
+	export, err := os.ReadFile("../chatgpt/testdata/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(body []byte) (*httptest.ResponseRecorder, chatGPTImport) {
+		req := httptest.NewRequest(http.MethodPost, "/import/chatgpt", bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var result chatGPTImport
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, result
+	}
+
+	resp, result := do(export)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if len(result.Imported) != 2 {
+		t.Fatalf("the 2 conversations that can be read should be imported, got %+v", result.Imported)
+	}
+	if c := result.Imported[0]; c.UserId != USER || c.Name != "Graph databases" {
+		t.Fatalf("the conversation should be the user's, named after the export's title: %+v", c)
+	}
+	if c := result.Imported[1]; c.Name != "An untitled question" {
+		t.Fatalf("the untitled conversation should be named after its first message: %+v", c)
+	}
+	messages, err := store.GetConversation(USER, result.Imported[0].ConversationId.String())
+	if err != nil || len(messages) != 4 {
+		t.Fatalf("the branch the conversation was left at should be imported, got %d messages: %v", len(messages), err)
+	}
+	if got := errorIndexes(result.Errors); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("the conversations that can't be read should be reported, got %+v", result.Errors)
+	}
+	if result.Errors[2].Title != "Empty" || result.Errors[2].Error != "it has no messages" {
+		t.Fatalf("the error should say which conversation and why, got %+v", result.Errors[2])
+	}
+
+	// importing it again doesn't duplicate anything
+	before, _, _ := store.ListConversations(USER, db.ListOptions{})
+	_, again := do(export)
+	if len(again.Imported) != 0 || !slices.Equal(errorIndexes(again.Errors), []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("nothing should be imported again, got %+v", again)
+	}
+	if !strings.Contains(again.Errors[0].Error, "already imported") {
+		t.Fatalf("the conversations should be reported as already imported, got %q", again.Errors[0].Error)
+	}
+	if after, _, _ := store.ListConversations(USER, db.ListOptions{}); len(after) != len(before) {
+		t.Fatalf("the user should still have %d conversations, has %d", len(before), len(after))
+	}
+
+	if resp, _ := do([]byte(`{"title": "not an export"}`)); resp.Code != http.StatusBadRequest {
+		t.Fatalf("a body that isn't an export should be rejected with 400, got %d: %s", resp.Code, resp.Body)
+	}
+}
+
+func errorIndexes(errs []chatGPTImportError) []int {
+	var indexes []int
+	for _, e := range errs {
+		indexes = append(indexes, e.Index)
+	}
+	return indexes
+}
+
+func TestImportPortable(t *testing.T) {
+	store := setupDB(t, true)
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := mergeConversationHistory(messages)
+	retrieved := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), ParentId: &last[len(last)-1].MessageId, Role: structs.ContextRole, Content: "Account 42 is flagged"}
+	if _, err := store.AppendMessage(retrieved, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddTag(USER, CONVO_ID, "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetSystemPrompt(USER, CONVO_ID, "Answer in one sentence"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddAttachment(USER, CONVO_ID, messages[0].MessageId.String(), structs.Attachment{
+		Filename: "fraud.png", ContentType: "image/png", Size: 2048, StorageURL: "https://files.example.com/fraud.png",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	exported := export(t, ExportConversation(store), USER, CONVO_ID, "portable").Body.Bytes()
+
+	other := setupDB(t, false)
+	withRoles := RequireRoles([]string{"globaldesigner"}, fakeRoles(map[string][]string{USER: {"globaldesigner"}}))
+	mux := http.NewServeMux()
+	mux.Handle("POST /import/portable", withRoles(ImportPortable(other)))
+	do := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/import/portable", bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := do(exported)
+	var convo structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &convo)
+	if resp.Code != 200 || convo.ConversationId.String() != CONVO_ID || convo.UserId != USER || !slices.Equal(convo.Tags, []string{"fraud"}) {
+		t.Fatalf("the conversation should be imported with its id and tags. Got %v: %s", resp.Code, resp.Body)
+	}
+	// exporting it again gives the same file, the import is the export's inverse
+	if again := export(t, ExportConversation(other), USER, CONVO_ID, "portable").Body.Bytes(); !bytes.Equal(again, exported) {
+		t.Fatalf("the imported conversation should be exported as it was.\nExported:\n%s\nAfter importing:\n%s", exported, again)
+	}
+	if resp := do(exported); resp.Code != http.StatusConflict {
+		t.Fatalf("importing a conversation that's already there should be a 409. It's %v: %s", resp.Code, resp.Body)
+	}
+
+	// the JSON export is schema version 1
+	v1 := export(t, ExportConversation(store), USER, CONVO_ID, "json").Body.Bytes()
+	upgraded := setupDB(t, false)
+	mux = http.NewServeMux()
+	mux.Handle("POST /import/portable", withRoles(ImportPortable(upgraded)))
+	if resp := do(v1); resp.Code != 200 {
+		t.Fatalf("a JSON export should be upgraded and imported. Got %v: %s", resp.Code, resp.Body)
+	}
+	imported, err := upgraded.GetConversation(USER, CONVO_ID)
+	if err != nil || len(imported) != len(messages) {
+		t.Fatalf("the JSON export's messages should be imported, without the context it leaves out. Got %d of %d: %v", len(imported), len(messages), err)
+	}
+	if attachments, _ := upgraded.ListAttachments(USER, CONVO_ID, ""); len(attachments) != 1 || attachments[0].MessageId != messages[0].MessageId {
+		t.Fatalf("the attachment should be on its message: %+v", attachments)
+	}
+
+	for name, body := range map[string]string{
+		"newer version": `{"schema_version": 99, "conversation": {"conversation_id": "` + uuid.NewString() + `"}}`,
+		"not an object": `[]`,
+		"no messages":   `{"schema_version": 2, "conversation": {"conversation_id": "` + uuid.NewString() + `"}}`,
+	} {
+		if resp := do([]byte(body)); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s should be a 400. It's %v: %s", name, resp.Code, resp.Body)
+		}
+	}
+}
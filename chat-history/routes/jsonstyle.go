@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// JSONStyle is how the handlers write the JSON of their responses, see config.ChatDbConfig.JSONCase
+type JSONStyle struct {
+	// indent responses with two spaces. Streamed events are one line either way
+	Indent bool
+	// name the fields in camelCase, i.e., conversationId, instead of the snake_case they're declared with
+	CamelCase bool
+}
+
+type jsonStyleKey struct{}
+
+// WithJSONStyle has the handlers of next write their responses' JSON in style. The zero style, compact
+// snake_case, returns next
+func WithJSONStyle(style JSONStyle, next http.Handler) http.Handler {
+	if style == (JSONStyle{}) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jsonStyleKey{}, style)))
+	})
+}
+
+// marshal is v's JSON in the style r is served with, for a response body
+func marshal(r *http.Request, v any) ([]byte, error) {
+	out, err := marshalLine(r, v)
+	if err != nil {
+		return nil, err
+	}
+	if style, _ := r.Context().Value(jsonStyleKey{}).(JSONStyle); !style.Indent {
+		return out, nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out, "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// marshalLine is marshal without indenting, for the events of a stream, which are one line each
+func marshalLine(r *http.Request, v any) ([]byte, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if style, _ := r.Context().Value(jsonStyleKey{}).(JSONStyle); !style.CamelCase {
+		return out, nil
+	}
+	return camelKeys(out, v)
+}
+
+// camelKeys renames the keys of the objects in data, v's JSON, to camelCase, keeping their order and values. Only
+// the fields of structs are renamed: the keys of maps, i.e., a conversation's metadata, are the user's and kept as
+// they are, and so is the JSON of values that marshal themselves, like json.RawMessage
+func camelKeys(data []byte, v any) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	// the objects and arrays the token is in, innermost last, with the value they're the JSON of, how many tokens
+	// of theirs were written, and the last key
+	type level struct {
+		object bool
+		value  reflect.Value
+		n      int
+		key    string
+	}
+	var levels []level
+	var out bytes.Buffer
+	root := jsonValue(reflect.ValueOf(v))
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return out.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			levels = levels[:len(levels)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		// the value tok starts
+		value := root
+		if len(levels) > 0 {
+			in := &levels[len(levels)-1]
+			switch {
+			// a key
+			case in.object && in.n%2 == 0:
+				if in.n > 0 {
+					out.WriteByte(',')
+				}
+				in.n++
+				in.key = tok.(string)
+				name := in.key
+				if in.value.Kind() == reflect.Struct {
+					name = camelCase(name)
+				}
+				key, _ := json.Marshal(name)
+				out.Write(key)
+				out.WriteByte(':')
+				continue
+			case !in.object && in.n > 0:
+				out.WriteByte(',')
+			}
+			if in.object {
+				value = jsonMember(in.value, in.key)
+			} else {
+				value = jsonElem(in.value, in.n)
+			}
+			in.n++
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			levels = append(levels, level{object: tok == '{', value: value})
+			out.WriteRune(rune(tok))
+		case json.Number:
+			out.WriteString(tok.String())
+		default:
+			// strings, booleans and null
+			value, err := json.Marshal(tok)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+	}
+}
+
+var (
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// jsonValue is the struct, map, slice or array v is marshaled as, past its pointers and interfaces. It's invalid
+// when v marshals itself, its JSON isn't renamed
+func jsonValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.Kind() == reflect.Pointer && (v.Type().Implements(jsonMarshaler) || v.Type().Implements(textMarshaler)) {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type().Implements(jsonMarshaler) || v.Type().Implements(textMarshaler) {
+		return reflect.Value{}
+	}
+	if v.CanAddr() && (v.Addr().Type().Implements(jsonMarshaler) || v.Addr().Type().Implements(textMarshaler)) {
+		return reflect.Value{}
+	}
+	return v
+}
+
+// jsonMember is the value of the struct or map v that's marshaled with key
+func jsonMember(v reflect.Value, key string) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		if index, ok := jsonFields(v.Type())[key]; ok {
+			if field, err := v.FieldByIndexErr(index); err == nil {
+				return jsonValue(field)
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			return jsonValue(v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())))
+		}
+	}
+	return reflect.Value{}
+}
+
+// jsonElem is the i-th element of the slice or array v
+func jsonElem(v reflect.Value, i int) reflect.Value {
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && i < v.Len() {
+		return jsonValue(v.Index(i))
+	}
+	return reflect.Value{}
+}
+
+// the fields of the struct types by the names they're marshaled with, see jsonFields
+var fieldNames sync.Map
+
+// jsonFields is the index of t's fields by their JSON names, the ones of embedded structs included
+func jsonFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldNames.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := map[string][]int{}
+	var add func(t reflect.Type, index []int)
+	add = func(t reflect.Type, index []int) {
+		for _, f := range reflect.VisibleFields(t) {
+			if len(f.Index) > 1 {
+				// reached through the embedded struct, which is added itself below
+				continue
+			}
+			tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			path := append(append([]int{}, index...), f.Index...)
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if f.Anonymous && tag == "" && embedded.Kind() == reflect.Struct {
+				add(embedded, path)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if tag == "" {
+				tag = f.Name
+			}
+			// the shallower field wins, as it does for encoding/json
+			if _, ok := fields[tag]; !ok || len(fields[tag]) > len(path) {
+				fields[tag] = path
+			}
+		}
+	}
+	add(t, nil)
+	fieldNames.Store(t, fields)
+	return fields
+}
+
+// camelCase is the snake_case name in camelCase, i.e., conversation_id is conversationId. Other names are kept
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	if len(parts) == 1 || parts[0] == "" {
+		return name
+	}
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithJSONStyle(t *testing.T) {
+	store := setupDB(t, true)
+	tests := []struct {
+		name  string
+		style JSONStyle
+		// the start of the response
+		want string
+	}{
+		{"compact snake_case", JSONStyle{}, `[{"id":1,"create_ts":"`},
+		{"indented snake_case", JSONStyle{Indent: true}, "[\n  {\n    \"id\": 1,\n    \"create_ts\": \""},
+		{"compact camelCase", JSONStyle{CamelCase: true}, `[{"id":1,"createTs":"`},
+		{"indented camelCase", JSONStyle{Indent: true, CamelCase: true}, "[\n  {\n    \"id\": 1,\n    \"createTs\": \""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("GET /conversation/{conversationId}", RequireRoles([]string{"globaldesigner"}, fakeRoles(map[string][]string{USER: {"globaldesigner"}}))(GetConversation(store)))
+			req := httptest.NewRequest(http.MethodGet, "/conversation/"+CONVO_ID, nil)
+			req.SetBasicAuth(USER, USER)
+			resp := httptest.NewRecorder()
+			WithJSONStyle(tt.style, mux).ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Body.String(), tt.want) {
+				t.Fatalf("the response should start with %s. Got %v: %s", tt.want, resp.Code, resp.Body)
+			}
+			if body := resp.Body.String(); tt.style.CamelCase == strings.Contains(body, "_id") || tt.style.Indent != strings.Contains(body, "\n") {
+				t.Fatalf("every field should be in the style. Got %s", body)
+			}
+		})
+	}
+}
+
+func TestWithJSONStyle_Events(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	resp := httptest.NewRecorder()
+	WithJSONStyle(JSONStyle{Indent: true, CamelCase: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeEvent(w, r, "chunk", struct {
+			Content   string `json:"content"`
+			MessageId string `json:"message_id"`
+		}{"Accounts 12\nand 42", "1"})
+	})).ServeHTTP(resp, req)
+	// the data of an event is one line
+	if want := "event: chunk\ndata: {\"content\":\"Accounts 12\\nand 42\",\"messageId\":\"1\"}\n\n"; resp.Body.String() != want {
+		t.Fatalf("the event should be %q. Got %q", want, resp.Body)
+	}
+}
+
+func TestCamelKeys(t *testing.T) {
+	type graphContext struct {
+		VertexIds  []any          `json:"vertex_ids"`
+		Score      *float64       `json:"_score"`
+		Attributes map[string]any `json:"attributes"`
+	}
+	type message struct {
+		ParentId    *string         `json:"parent_id"`
+		IsPinned    bool            `json:"is_pinned"`
+		ToolPayload json.RawMessage `json:"tool_payload,omitempty"`
+	}
+	v := struct {
+		UserId       string             `json:"user_id"`
+		GraphContext graphContext       `json:"graph_context"`
+		Messages     []message          `json:"messages"`
+		Metadata     map[string]string  `json:"metadata"`
+		ByUser       map[string]message `json:"by_user"`
+		X            struct{}           `json:"x"`
+	}{
+		UserId:       "sam_pull",
+		GraphContext: graphContext{VertexIds: []any{"a_b", json.Number("1.50"), json.Number("12345678901234567890")}, Attributes: map[string]any{"first_name": map[string]string{"last_name": "Pull"}}},
+		Messages:     []message{{IsPinned: true, ToolPayload: json.RawMessage(`{"call_id":"1"}`)}},
+		Metadata:     map[string]string{"case_number": "42"},
+		ByUser:       map[string]message{"sam_pull": {}},
+	}
+	want := `{"userId":"sam_pull","graphContext":{"vertexIds":["a_b",1.50,12345678901234567890],"_score":null,"attributes":{"first_name":{"last_name":"Pull"}}},"messages":[{"parentId":null,"isPinned":true,"toolPayload":{"call_id":"1"}}],"metadata":{"case_number":"42"},"byUser":{"sam_pull":{"parentId":null,"isPinned":false}},"x":{}}`
+	in, _ := json.Marshal(v)
+	out, err := camelKeys(in, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fields are renamed, in the same order, and the values are kept as they are, the keys of maps and
+	// the JSON of values that marshal themselves included
+	if string(out) != want {
+		t.Fatalf("the fields should be in camelCase.\nGot:  %s\nWant: %s", out, want)
+	}
+	for name, want := range map[string]string{"create_ts": "createTs", "id": "id", "_score": "_score", "a__b_": "aB"} {
+		if got := camelCase(name); got != want {
+			t.Fatalf("%s should be %s in camelCase. It's %s", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type mergeRequest struct {
+	SourceId string `json:"source_id"`
+}
+
+// Merge a conversation into another, i.e., when the same export was imported twice
+// "POST /conversation/{conversationId}/merge" with {"source_id": "..."}
+// The messages of the source that the conversation doesn't have, with the same role, content and create_ts, are moved
+// to its end and the source is deleted. Both must be the caller's, superusers merge the conversation's owner's
+func MergeConversations(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		if isSuperuser(r) {
+			if c, err := store.FindConversation(conversationId); err == nil {
+				userId = c.UserId
+			}
+		}
+
+		var req mergeRequest
+		if apiErr := decodeBody(r, &req, `body must be {"source_id": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		sourceId := strings.TrimSpace(req.SourceId)
+		if sourceId == "" {
+			writeError(w, apierror.InvalidRequest("source_id is required"))
+			return
+		}
+
+		merged, err := store.MergeConversations(userId, conversationId, sourceId)
+		if errors.Is(err, db.ErrMergeSelf) {
+			writeError(w, apierror.InvalidRequest(err.Error()))
+			return
+		} else if err != nil {
+			notFound := fmt.Sprintf("conversations %s and %s not found", conversationId, sourceId)
+			writeError(w, storeError(err, notFound, "failed to merge the conversations"))
+			return
+		}
+		if out, err := marshal(r, merged); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMergeConversations(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/merge", withRoles(MergeConversations(store)))
+
+	do := func(user, conversationId, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/conversation/%s/merge", conversationId), strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the conversation imported again with a new message
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := uuid.New()
+	var parent *uuid.UUID
+	for i, m := range append(messages, structs.Message{Role: structs.UserRole, Content: "one more thing"}) {
+		copied := structs.Message{ConversationId: source, MessageId: uuid.New(), ParentId: parent, Role: m.Role, Content: m.Content}
+		copied.CreatedAt = m.CreatedAt
+		if i == 0 {
+			_, err = store.CreateConversation(USER, "imported", copied)
+		} else {
+			_, err = store.AppendMessage(copied, db.AnyVersion)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = &copied.MessageId
+	}
+	body := fmt.Sprintf(`{"source_id": %q}`, source)
+
+	for _, tt := range []struct {
+		name, user, conversationId, body string
+		code                             int
+	}{
+		{"other user", "Miss_Take", CONVO_ID, body, http.StatusNotFound},
+		{"no source", USER, CONVO_ID, `{}`, http.StatusBadRequest},
+		{"itself", USER, CONVO_ID, fmt.Sprintf(`{"source_id": %q}`, CONVO_ID), http.StatusBadRequest},
+	} {
+		if resp := do(tt.user, tt.conversationId, tt.body); resp.Code != tt.code {
+			t.Fatalf("%s: Response code should be %d. It is: %v: %s", tt.name, tt.code, resp.Code, resp.Body)
+		}
+	}
+
+	resp := do(USER, CONVO_ID, body)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var merged structs.Conversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &merged); err != nil || merged.ConversationId.String() != CONVO_ID {
+		t.Fatalf("the merged conversation should be returned: %s", resp.Body)
+	}
+	after, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(after) != len(messages)+1 || after[len(after)-1].Content != "one more thing" {
+		t.Fatalf("only the new message should be added. Got %+v, %v", after, err)
+	}
+	if _, err := store.FindConversation(source.String()); err == nil {
+		t.Fatal("the source should be deleted")
+	}
+}
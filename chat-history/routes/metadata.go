@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+)
+
+// Get the metadata integrations attached to a conversation
+// "GET /conversation/{conversationId}/metadata"
+func GetMetadata(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+
+		metadata, err := store.GetMetadata(userId, r.PathValue("conversationId"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve the metadata"))
+			return
+		}
+		if out, err := marshal(r, metadata); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Set keys of a conversation's metadata, i.e., the app it came from or a case number
+// "PATCH /conversation/{conversationId}/metadata" with {"key": "value", "other": null}
+// Only the keys in the body change, a null value removes the key. It responds with the metadata as it is then
+func SetMetadata(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+
+		var updates map[string]*string
+		if apiErr := decodeBody(r, &updates, "body must be a JSON object of string or null values"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		metadata, err := store.SetMetadata(userId, r.PathValue("conversationId"), updates)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to set the metadata"))
+			return
+		}
+		if out, err := marshal(r, metadata); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
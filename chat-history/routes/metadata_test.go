@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConversationMetadata(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}/metadata", GetMetadata(store))
+	mux.Handle("PATCH /conversation/{conversationId}/metadata", SetMetadata(store))
+	mux.Handle("GET /user/{userId}", GetUserConversations(store))
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	metadataOf := func(resp *httptest.ResponseRecorder) map[string]string {
+		t.Helper()
+		var metadata map[string]string
+		if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &metadata) != nil {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		return metadata
+	}
+	path := "/conversation/" + CONVO_ID + "/metadata"
+
+	if got := metadataOf(do(USER, http.MethodGet, path, "")); len(got) != 0 {
+		t.Fatalf("the conversation should have no metadata yet. It has %v", got)
+	}
+	metadataOf(do(USER, http.MethodPatch, path, `{"source": "crm", "case_number": "123"}`))
+	got := metadataOf(do(USER, http.MethodPatch, path, `{"case_number": null, "priority": "high"}`))
+	want := map[string]string{"source": "crm", "priority": "high"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("the metadata should be %v after the partial update. It's %v", want, got)
+	}
+	if got := metadataOf(do(USER, http.MethodGet, path, "")); !maps.Equal(got, want) {
+		t.Fatalf("the metadata should be %v. It's %v", want, got)
+	}
+
+	list := func(query string) []structs.Conversation {
+		t.Helper()
+		resp := do(USER, http.MethodGet, "/user/"+USER+"?"+query, "")
+		var convos []structs.Conversation
+		if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &convos) != nil {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		return convos
+	}
+	convos := list("metadata=source:crm&metadata=priority:high")
+	if len(convos) != 1 || convos[0].ConversationId.String() != CONVO_ID || !maps.Equal(convos[0].Metadata, want) {
+		t.Fatalf("only the conversation with the metadata should be listed, with it. Got %+v", convos)
+	}
+	if convos := list("metadata=source:email"); len(convos) != 0 {
+		t.Fatalf("no conversation should have source email. Got %+v", convos)
+	}
+
+	tests := []struct {
+		name, user, method, path, body string
+		code                           int
+	}{
+		{"invalid key", USER, http.MethodPatch, path, `{"case number": "123"}`, http.StatusBadRequest},
+		{"not strings", USER, http.MethodPatch, path, `{"case_number": 123}`, http.StatusBadRequest},
+		{"long value", USER, http.MethodPatch, path, fmt.Sprintf(`{"note": %q}`, strings.Repeat("x", 1025)), http.StatusBadRequest},
+		{"filter without a value", USER, http.MethodGet, "/user/" + USER + "?metadata=source", "", http.StatusBadRequest},
+		{"another user's", "Miss_Take", http.MethodGet, path, "", http.StatusNotFound},
+		{"another user's update", "Miss_Take", http.MethodPatch, path, `{"source": "x"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := do(tt.user, tt.method, tt.path, tt.body); resp.Code != tt.code {
+				t.Fatalf("Response code should be %v. It is: %v: %s", tt.code, resp.Code, resp.Body)
+			}
+		})
+	}
+	if got := metadataOf(do(USER, http.MethodGet, path, "")); !maps.Equal(got, want) {
+		t.Fatalf("rejected updates shouldn't change the metadata. It's %v", got)
+	}
+}
+
+func TestConversationMetadata_CamelCase(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}/metadata", GetMetadata(store))
+	mux.Handle("PATCH /conversation/{conversationId}/metadata", SetMetadata(store))
+	mux.Handle("GET /user/{userId}", GetUserConversations(store))
+	handler := WithJSONStyle(JSONStyle{CamelCase: true}, mux)
+	do := func(method, path, body string) string {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		return resp.Body.String()
+	}
+	path := "/conversation/" + CONVO_ID + "/metadata"
+
+	// the keys are the integration's, they're kept as they were set
+	do(http.MethodPatch, path, `{"case_number": "42"}`)
+	if body := do(http.MethodGet, path, ""); body != `{"case_number":"42"}` {
+		t.Fatalf("the metadata's keys shouldn't be renamed. Got %s", body)
+	}
+	body := do(http.MethodGet, "/user/"+USER, "")
+	if !strings.Contains(body, `"conversationId":`) || !strings.Contains(body, `"metadata":{"case_number":"42"}`) {
+		t.Fatalf("the conversations' fields should be in camelCase and their metadata as it was set. Got %s", body)
+	}
+}
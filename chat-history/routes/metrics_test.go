@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"chat-history/metrics"
+	"chat-history/middleware"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	store := setupDB(t, true)
+	hostname, gsPort := fakeTigerGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":false, "message":"Hello GSQL"}`))
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", Healthz(store, hostname, gsPort, time.Second, nil))
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	handler := middleware.ChainMiddleware(mux, middleware.Metrics())
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// traffic that touches the db and TigerGraph
+	do("/healthz")
+	do(fmt.Sprintf("/conversation/%s", CONVO_ID))
+
+	resp := do("/metrics")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	scrape := string(body)
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",path="/conversation/{conversationId}",status="200"}`,
+		`http_requests_total{method="GET",path="/healthz",status="200"}`,
+		`http_request_duration_seconds_bucket{method="GET",path="/conversation/{conversationId}"`,
+		`db_query_duration_seconds_bucket{operation="query"`,
+		`db_query_duration_seconds_bucket{operation="raw"`,
+		`tigergraph_request_duration_seconds_bucket{path="/restpp/echo",status="200"`,
+	} {
+		if !strings.Contains(scrape, want) {
+			t.Fatalf("/metrics should have %s. It's:\n%s", want, scrape)
+		}
+	}
+	// conversation ids aren't labels
+	if strings.Contains(scrape, CONVO_ID) {
+		t.Fatalf("/metrics should not have path values in labels")
+	}
+}
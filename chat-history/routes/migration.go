@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/audit"
+	"chat-history/db"
+	"chat-history/portable"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// afterParam is the after query parameter of r, the id of the conversation to carry on after, or uuid.Nil
+func afterParam(r *http.Request) (uuid.UUID, *apierror.APIError) {
+	v := r.URL.Query().Get("after")
+	if v == "" {
+		return uuid.Nil, nil
+	}
+	after, err := uuid.Parse(v)
+	if err != nil {
+		return uuid.Nil, apierror.InvalidRequest("after must be a conversation id")
+	}
+	return after, nil
+}
+
+// Download every user's conversations, to move them to another deployment with POST /admin/import. Callers need
+// one of the admin roles (see RequireAdmin)
+// "GET /admin/export?after=conversationId"
+// The response is NDJSON, one portable.ConversationExport per line with its owner in conversation.user_id,
+// ordered by conversation id. It's written as the conversations are read, so however many there are they're
+// never all in memory. A download that doesn't end with a newline was cut off, after=the id of its last full
+// line carries on from there. The archived conversations are among them, with conversation.archived, the trash isn't
+// exported. Every export is written to the audit log
+func AdminExportEveryone(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		after, apiErr := afterParam(r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "export_everyone"}) {
+			return
+		}
+
+		// the headers are set with the first line, so an error before it can still be a JSON error
+		started := false
+		start := func() {
+			w.Header().Add("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="conversations.ndjson"`)
+			started = true
+		}
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		err := store.ExportEveryone(after, func(c db.ConversationData) error {
+			if !started {
+				start()
+			}
+			if err := enc.Encode(portable.New(c.Conversation, c.Messages, c.Attachments)); err != nil {
+				return err
+			}
+			// errors are for writers that can't flush, the line is still sent
+			rc.Flush()
+			return r.Context().Err()
+		})
+		if err != nil {
+			if !started {
+				writeError(w, storeError(err, "", "failed to export conversations"))
+				return
+			}
+			slog.Error("failed to export every conversation", "err", err)
+			// the status is already sent, aborting is the only way to tell the client it's incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if !started {
+			start()
+		}
+	}
+}
+
+type importEveryoneResult struct {
+	Imported int `json:"imported"`
+}
+
+// Import the conversations downloaded with GET /admin/export, each for the user it belonged to. Callers need one of
+// the admin roles (see RequireAdmin)
+// "POST /admin/import?after=conversationId"
+// The conversations keep their ids and timestamps, their messages' too, the archived ones go to the archive, and the
+// users' conversation limits don't apply. They're imported one line at a time, each on its own. With after, the lines up to and including the
+// conversation with that id are skipped. If a line can't be imported the response is its error, and the
+// X-Last-Conversation-Id header is the id of the last conversation that was, to carry on after. Like every body,
+// it can't be over chat_config.maxRequestBodyBytes, a bigger export is imported a part at a time that way. Every
+// import is written to the audit log
+func AdminImportEveryone(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context()).WithoutConversationLimit()
+		after, apiErr := afterParam(r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "import_everyone"}) {
+			return
+		}
+
+		const usage = "Each line must be a conversation exported with GET /admin/export"
+		var result importEveryoneResult
+		dec := json.NewDecoder(r.Body)
+		fail := func(line int, apiErr *apierror.APIError) {
+			// a copy, storeError's can be shared
+			e := *apiErr
+			e.Message = fmt.Sprintf("line %d: %s", line, e.Message)
+			writeError(w, &e)
+		}
+		for line := 1; ; line++ {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				fail(line, bodyError(err, decodeError(err)+". "+usage))
+				return
+			}
+			export, err := portable.Unmarshal(raw)
+			if errors.Is(err, portable.ErrUnsupportedVersion) {
+				fail(line, apierror.InvalidRequest(err.Error()))
+				return
+			} else if err != nil {
+				fail(line, apierror.InvalidRequest(decodeError(err)+". "+usage))
+				return
+			}
+			convo, messages, attachments := export.Data()
+			if after != uuid.Nil && convo.ConversationId.String() <= after.String() {
+				continue
+			}
+			if convo.UserId == "" {
+				fail(line, apierror.InvalidRequest("the conversation has no user_id. "+usage))
+				return
+			}
+			_, err = store.ImportConversation(convo.UserId, db.ConversationData{Conversation: convo, Messages: messages, Attachments: attachments})
+			if err != nil {
+				fail(line, storeError(err, "", "failed to import the conversation"))
+				return
+			}
+			result.Imported++
+			w.Header().Set("X-Last-Conversation-Id", convo.ConversationId.String())
+		}
+
+		if out, err := marshal(r, result); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
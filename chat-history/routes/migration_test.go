@@ -0,0 +1,192 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// migrationServer serves GET /admin/export and POST /admin/import of store, to support, and returns its audit log
+func migrationServer(t *testing.T, store db.ConversationStore) (http.Handler, string) {
+	auditLog, pth := openAuditLog(t)
+	requireAdmin := RequireAdmin(func() []string { return []string{"supportstaff"} }, fakeRoles(map[string][]string{"support": {"supportstaff"}}))
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/export", requireAdmin(AdminExportEveryone(store, auditLog)))
+	mux.Handle("POST /admin/import", requireAdmin(AdminImportEveryone(store, auditLog)))
+	return mux, pth
+}
+
+// archiveDB is an empty store with an archive database
+func archiveDB(t *testing.T) db.ConversationStore {
+	tmp := t.TempDir()
+	os.Setenv("DEV", "")
+	return db.InitDB(fmt.Sprintf("%s/test.db", tmp), fmt.Sprintf("%s/test.log", tmp), db.ArchivePath(fmt.Sprintf("%s/archive.db", tmp)))
+}
+
+func TestAdminExportImportEveryone(t *testing.T) {
+	store := archiveDB(t)
+	source, pth := migrationServer(t, store)
+	// a few users' conversations, one with everything a conversation can have and one in the archive
+	var convoId, archivedId string
+	for i, user := range []string{USER, "Miss_Take", "new_hire", USER} {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Which accounts share a phone number?", Role: structs.UserRole}
+		if _, err := store.CreateConversation(user, fmt.Sprintf("convo %d", i), msg); err != nil {
+			t.Fatal(err)
+		}
+		reply := structs.Message{ConversationId: msg.ConversationId, MessageId: uuid.New(), ParentId: &msg.MessageId, Content: "Accounts 12 and 42 do.", Role: structs.SystemRole}
+		if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		convoId = msg.ConversationId.String()
+		if user == "new_hire" {
+			archivedId = convoId
+		}
+	}
+	if err := store.ArchiveConversation("new_hire", archivedId); err != nil {
+		t.Fatal(err)
+	}
+	user := USER
+	if err := store.AddTag(user, convoId, "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetMetadata(user, convoId, map[string]*string{"source": &[]string{"crm"}[0]}); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := store.GetConversation(user, convoId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddAttachment(user, convoId, messages[0].MessageId.String(), structs.Attachment{
+		Filename: "accounts.csv", ContentType: "text/csv", Size: 512, StorageURL: "https://files.example.com/accounts.csv",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(handler http.Handler, method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup("support", PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	export := func(handler http.Handler, query string) []byte {
+		t.Helper()
+		resp := do(handler, http.MethodGet, "/admin/export"+query, nil)
+		if resp.Code != 200 || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("the export should be NDJSON. Got %v: %s", resp.Code, resp.Body)
+		}
+		return resp.Body.Bytes()
+	}
+	exported := export(source, "")
+	// each line ends with a newline, there's nothing after the last
+	lines := strings.SplitAfter(string(exported), "\n")
+	lines = lines[:len(lines)-1]
+	users := map[string]bool{}
+	var ids []string
+	for _, line := range lines {
+		var c struct {
+			Conversation struct {
+				ConversationId string `json:"conversation_id"`
+				UserId         string `json:"user_id"`
+				Archived       bool   `json:"archived"`
+			} `json:"conversation"`
+		}
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			t.Fatalf("each line should be a conversation: %s", line)
+		}
+		if c.Conversation.Archived != (c.Conversation.ConversationId == archivedId) {
+			t.Fatalf("only the archived conversation should be exported as archived: %s", line)
+		}
+		users[c.Conversation.UserId] = true
+		ids = append(ids, c.Conversation.ConversationId)
+	}
+	if len(lines) != 4 || len(users) != 3 {
+		t.Fatalf("every user's conversations should be exported, the archived one too. Got %s", exported)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			t.Fatalf("the conversations should be in the order of their ids. Got %v", ids)
+		}
+	}
+	if rest := export(source, "?after="+ids[0]); string(rest) != strings.Join(lines[1:], "") {
+		t.Fatalf("after should carry on from the conversation after it. Got %s", rest)
+	}
+	if entries := readAudit(t, pth); len(entries) != 2 || entries[0].Action != "export_everyone" || entries[0].Actor != "support" {
+		t.Fatalf("the exports should be audited: %+v", entries)
+	}
+
+	// another deployment, that the export is imported into a part at a time
+	target := archiveDB(t)
+	imports, _ := migrationServer(t, target)
+	importLines := func(query string, lines []string) *httptest.ResponseRecorder {
+		return do(imports, http.MethodPost, "/admin/import"+query, []byte(strings.Join(lines, "")))
+	}
+
+	resp := importLines("", lines[:2])
+	if resp.Code != 200 || resp.Body.String() != `{"imported":2}` || resp.Header().Get("X-Last-Conversation-Id") != ids[1] {
+		t.Fatalf("the first part should be imported. Got %v %v: %s", resp.Code, resp.Header(), resp.Body)
+	}
+	// sending it all again fails at the first conversation that's already there
+	resp = importLines("", lines)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "line 1: ") || resp.Header().Get("X-Last-Conversation-Id") != "" {
+		t.Fatalf("a conversation that's already there should be a 409. Got %v %v: %s", resp.Code, resp.Header(), resp.Body)
+	}
+	resp = importLines("?after="+ids[1], lines)
+	if want := fmt.Sprintf(`{"imported":%d}`, len(lines)-2); resp.Code != 200 || resp.Body.String() != want || resp.Header().Get("X-Last-Conversation-Id") != ids[len(ids)-1] {
+		t.Fatalf("the rest should be imported. Got %v %v: %s", resp.Code, resp.Header(), resp.Body)
+	}
+
+	// ids, timestamps, tags, metadata and attachments are as they were, and the archived conversation is in the
+	// archive, so it exports the same
+	if got := export(imports, ""); !bytes.Equal(got, exported) {
+		t.Fatalf("the import should export as the original did.\nGot:  %s\nWant: %s", got, exported)
+	}
+	convo, err := target.FindConversation(convoId)
+	if err != nil || convo.UserId != user || convo.Metadata["source"] != "crm" {
+		t.Fatalf("the conversation should be imported for its user, with its metadata. Got %+v, %v", convo, err)
+	}
+	imported, _, err := target.ListConversations(user, db.ListOptions{Tags: []string{"fraud"}})
+	if err != nil || len(imported) != 1 {
+		t.Fatalf("the conversation's tags should be imported. Got %+v, %v", imported, err)
+	}
+	if _, err := target.FindConversation(archivedId); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("the archived conversation shouldn't be imported into the primary. Got %v", err)
+	}
+	if err := target.UnarchiveConversation("new_hire", archivedId); err != nil {
+		t.Fatalf("the archived conversation should be imported into the archive. Got %v", err)
+	}
+
+	tests := []struct {
+		name, query, body string
+		code              int
+	}{
+		{"invalid after", "?after=yesterday", "", http.StatusBadRequest},
+		{"not JSON", "", "{\n", http.StatusBadRequest},
+		{"no user", "", `{"schema_version": 3, "conversation": {"conversation_id": "` + uuid.NewString() + `"}, "messages": [{"message_id": "` + uuid.NewString() + `", "role": "user", "content": "hi"}]}`, http.StatusBadRequest},
+		{"newer version", "", `{"schema_version": 99}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := do(imports, http.MethodPost, "/admin/import"+tt.query, []byte(tt.body)); resp.Code != tt.code {
+				t.Fatalf("Response code should be %v. It is: %v: %s", tt.code, resp.Code, resp.Body)
+			}
+		})
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	resp = httptest.NewRecorder()
+	source.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("only admins can export everyone's conversations. Response code should be 403. It is: %v", resp.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type modelRequest struct {
+	ModelName string `json:"model_name"`
+}
+
+// Set the model a conversation's replies, titles and summaries come from
+// "PUT /conversation/{conversationId}/model" with {"model_name": "..."}
+// The model must be llm_config.model_name or in llm_config.allowed_models. An empty model_name goes back to model_name
+func SetConversationModel(store db.ConversationStore, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+
+		var req modelRequest
+		if apiErr := decodeBody(r, &req, "body must be a JSON object with the model_name"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if apiErr := checkModel(llmCfg, req.ModelName); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		convo, err := store.SetConversationModel(userId, r.PathValue("conversationId"), req.ModelName)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to set the model"))
+			return
+		}
+		if out, err := marshal(r, convo); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// checkModel returns the error to respond with if a conversation can't use the model. Any conversation can go
+// back to llm_config.model_name with an empty one
+func checkModel(llmCfg config.LLMConfig, model string) *apierror.APIError {
+	if model == "" || llmCfg.AllowsModel(model) {
+		return nil
+	}
+	if !llmCfg.Enabled() {
+		return apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "no LLM is configured")
+	}
+	allowed := append([]string{llmCfg.ModelName}, llmCfg.AllowedModels...)
+	return apierror.InvalidRequest(fmt.Sprintf("model %q is not allowed, it must be one of: %s", model, strings.Join(allowed, ", ")))
+}
+
+// conversationModel is llmCfg with the conversation's model in place of model_name, if it has one
+func conversationModel(llmCfg config.LLMConfig, convo *structs.Conversation) config.LLMConfig {
+	if convo.ModelName != "" {
+		llmCfg.ModelName = convo.ModelName
+	}
+	return llmCfg
+}
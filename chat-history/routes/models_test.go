@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"chat-history/config"
+	"chat-history/llm"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationModel(t *testing.T) {
+	// an ollama that records the model each request was for
+	var models []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		models = append(models, in.Model)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"a summary"}}]}`))
+	}))
+	defer srv.Close()
+	llmCfg := config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: srv.URL, AllowedModels: []string{"mistral"}}
+	client, err := llm.NewClient(llmCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/model", SetConversationModel(store, llmCfg))
+	mux.Handle("POST /conversation", UpdateConversation(store, nil, llmCfg, time.Hour, nil))
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	setModel := func(user, model string) *httptest.ResponseRecorder {
+		return do(user, http.MethodPut, "/conversation/"+CONVO_ID+"/model", fmt.Sprintf(`{"model_name": %q}`, model))
+	}
+	// summaries are cached, so each one is from a new handler
+	summarize := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/conversations/"+CONVO_ID+"/summary", nil)
+		req.SetPathValue("conversationId", CONVO_ID)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		SummarizeConversation(store, client, llmCfg).ServeHTTP(resp, req)
+		if resp.Code != 200 || len(models) == 0 {
+			t.Fatalf("the conversation should be summarized. Got %v: %s", resp.Code, resp.Body)
+		}
+		return models[len(models)-1]
+	}
+
+	// without a model of its own, the conversation uses model_name
+	if model := summarize(); model != "llama3" {
+		t.Fatalf("the summary should be from model_name. It's from %s", model)
+	}
+	resp := setModel(USER, "mistral")
+	var convo structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &convo)
+	if resp.Code != 200 || convo.ModelName != "mistral" {
+		t.Fatalf("the conversation should have the model it was set to. Got %v: %s", resp.Code, resp.Body)
+	}
+	if model := summarize(); model != "mistral" {
+		t.Fatalf("the summary should be from the conversation's model. It's from %s", model)
+	}
+
+	// models that aren't allowed, and other users' conversations, are rejected
+	if resp := setModel(USER, "gpt-4o"); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "llama3, mistral") {
+		t.Fatalf("a model that isn't allowed should be rejected with the allowed ones. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := setModel("Miss_Take", "llama3"); resp.Code != http.StatusNotFound {
+		t.Fatalf("another user's conversation should be not found. Got %v", resp.Code)
+	}
+	if model := summarize(); model != "mistral" {
+		t.Fatalf("rejected models shouldn't change the conversation's. It's %s", model)
+	}
+
+	// an empty model goes back to model_name
+	if resp := setModel(USER, ""); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if model := summarize(); model != "llama3" {
+		t.Fatalf("the summary should be from model_name again. It's from %s", model)
+	}
+
+	// the model can be set when the conversation starts
+	create := func(model string) *httptest.ResponseRecorder {
+		msg, _ := json.Marshal(structs.Message{MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+		return do(USER, http.MethodPost, "/conversation?model_name="+model, string(msg))
+	}
+	resp = create("mistral")
+	body, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(body, &convo)
+	if resp.Code != 200 || convo.ModelName != "mistral" {
+		t.Fatalf("the new conversation should have its model. Got %v: %s", resp.Code, body)
+	}
+	if found, err := store.FindConversation(convo.ConversationId.String()); err != nil || found.ModelName != "mistral" {
+		t.Fatalf("the new conversation's model should be stored. Got %+v, %v", found, err)
+	}
+	if resp := create("gpt-4o"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("a conversation can't start with a model that isn't allowed. Got %v: %s", resp.Code, resp.Body)
+	}
+}
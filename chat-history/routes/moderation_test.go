@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/moderation"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateConversation_Moderated(t *testing.T) {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, "test.db")
+	os.Setenv("DEV", "true") // populate db
+	db.InitDB(pth, fmt.Sprintf("%s/test.log", tmp))
+	blocklist, err := moderation.NewBlocklist([]string{`(?i)\bpassword\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.NewSQLiteStore(pth, fmt.Sprintf("%s/moderated.log", tmp), db.Moderate(blocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	handler := UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil)
+	post := func(msg structs.Message) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(msg)
+		req := httptest.NewRequest(http.MethodPost, "/conversation", bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	before, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := post(structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "How many accounts?", Role: structs.UserRole}); resp.Code != http.StatusOK {
+		t.Fatalf("allowed content should be stored. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	for name, msg := range map[string]structs.Message{
+		"append": {ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "what's the password?", Role: structs.UserRole},
+		"create": {ConversationId: uuid.New(), MessageId: uuid.New(), Content: "what's the password?", Role: structs.UserRole},
+	} {
+		resp := post(msg)
+		var apiErr apierror.APIError
+		json.Unmarshal(resp.Body.Bytes(), &apiErr)
+		if resp.Code != http.StatusUnprocessableEntity || apiErr.Code != apierror.CodeContentBlocked || apiErr.Message == "" {
+			t.Fatalf("%s: blocked content should be a 422 with the reason. Got %v: %s", name, resp.Code, resp.Body)
+		}
+	}
+	if after, _ := store.GetConversation(USER, CONVO_ID); len(after) != len(before)+1 {
+		t.Fatalf("only the allowed message should be stored. There were %d messages, now %d", len(before), len(after))
+	}
+}
@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+)
+
+// Pin a message, i.e., an answer worth coming back to
+// "PUT /conversation/{conversationId}/messages/{messageId}/pin"
+func PinMessage(store db.ConversationStore) http.HandlerFunc {
+	return pinHandler(store, db.ConversationStore.PinMessage)
+}
+
+// Unpin a message
+// "DELETE /conversation/{conversationId}/messages/{messageId}/pin"
+func UnpinMessage(store db.ConversationStore) http.HandlerFunc {
+	return pinHandler(store, db.ConversationStore.UnpinMessage)
+}
+
+// pinHandler checks the caller owns the conversation before pinning or unpinning the message with update
+func pinHandler(store db.ConversationStore, update func(store db.ConversationStore, userId, conversationId, messageId string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+		if err := update(store, userId, r.PathValue("conversationId"), r.PathValue("messageId")); err != nil {
+			writeError(w, storeError(err, messageNotFound(r), "failed to update pins"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Get the pinned messages of a conversation, oldest first
+// "GET /conversation/{conversationId}/pinned"
+func ListPinned(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+		messages, err := store.ListPinned(userId, r.PathValue("conversationId"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve pinned messages"))
+			return
+		}
+		if out, err := marshal(r, messages); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Pin a conversation to the top of the owner's list, GET /user/{userId} returns the pinned ones first
+// "PUT /conversation/{conversationId}/pin"
+// A user can pin up to chat_config.maxPinnedConversations, after that it's a 400
+func PinConversation(store db.ConversationStore) http.HandlerFunc {
+	return conversationPinHandler(store, db.ConversationStore.PinConversation)
+}
+
+// Put a pinned conversation back in its place in the list
+// "DELETE /conversation/{conversationId}/pin"
+func UnpinConversation(store db.ConversationStore) http.HandlerFunc {
+	return conversationPinHandler(store, db.ConversationStore.UnpinConversation)
+}
+
+// conversationPinHandler pins or unpins the conversation with update. The pin is on the owner's list, so only
+// they and superusers can change it
+func conversationPinHandler(store db.ConversationStore, update func(store db.ConversationStore, userId, conversationId string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+		if err := update(store, userId, r.PathValue("conversationId")); err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to update pins"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPins(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}/pin", withRoles(PinMessage(store)))
+	mux.Handle("DELETE /conversation/{conversationId}/messages/{messageId}/pin", withRoles(UnpinMessage(store)))
+	mux.Handle("GET /conversation/{conversationId}/pinned", withRoles(ListPinned(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	messages := func(resp *httptest.ResponseRecorder) []structs.Message {
+		t.Helper()
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var m []structs.Message
+		if err := json.Unmarshal(resp.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "the answer", Role: structs.AssistantRole}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	pinPath := fmt.Sprintf("/conversation/%s/messages/%s/pin", CONVO_ID, reply.MessageId)
+
+	if resp := do(http.MethodPut, pinPath, USER); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	pinned := messages(do(http.MethodGet, fmt.Sprintf("/conversation/%s/pinned", CONVO_ID), USER))
+	if len(pinned) != 1 || pinned[0].MessageId != reply.MessageId || !pinned[0].Pinned {
+		t.Fatalf("the pinned message should be listed: %+v", pinned)
+	}
+
+	// the pinned reply comes first, the rest keep their order
+	all := messages(do(http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), USER))
+	first := messages(do(http.MethodGet, fmt.Sprintf("/conversation/%s?pinned_first=true", CONVO_ID), USER))
+	if len(first) != len(all) || first[0].MessageId != reply.MessageId {
+		t.Fatalf("the pinned message should be first: %+v", first)
+	}
+	rest := []uuid.UUID{}
+	for _, m := range all {
+		if m.MessageId != reply.MessageId {
+			rest = append(rest, m.MessageId)
+		}
+	}
+	for i, id := range rest {
+		if first[i+1].MessageId != id {
+			t.Fatalf("the unpinned messages should keep their order. Expected %v, got %+v", rest, first[1:])
+		}
+	}
+
+	// other users can't see or change the pins
+	if resp := do(http.MethodDelete, pinPath, "Miss_Take"); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404 for another user's message. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodGet, fmt.Sprintf("/conversation/%s/pinned", CONVO_ID), "Miss_Take"); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404 for another user's conversation. It is: %v", resp.Code)
+	}
+
+	// superusers can unpin any message
+	if resp := do(http.MethodDelete, pinPath, "admin"); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if pinned := messages(do(http.MethodGet, fmt.Sprintf("/conversation/%s/pinned", CONVO_ID), USER)); len(pinned) != 0 {
+		t.Fatalf("nothing should be pinned: %+v", pinned)
+	}
+}
+
+func TestPinConversation(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/pin", withRoles(PinConversation(store)))
+	mux.Handle("DELETE /conversation/{conversationId}/pin", withRoles(UnpinConversation(store)))
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	first := func() structs.Conversation {
+		t.Helper()
+		resp := do(http.MethodGet, "/user/"+USER, USER)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var convos []structs.Conversation
+		if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil {
+			t.Fatal(err)
+		}
+		return convos[0]
+	}
+
+	// a newer conversation is listed first until the older one is pinned
+	newer := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Hello again", Role: structs.UserRole}
+	if _, err := store.CreateConversation(USER, "newer", newer); err != nil {
+		t.Fatal(err)
+	}
+	pinPath := fmt.Sprintf("/conversation/%s/pin", CONVO_ID)
+	if resp := do(http.MethodPut, pinPath, USER); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if convo := first(); convo.ConversationId.String() != CONVO_ID || convo.PinnedAt == nil {
+		t.Fatalf("the pinned conversation should be first: %+v", convo)
+	}
+
+	if resp := do(http.MethodDelete, pinPath, "Miss_Take"); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404 for another user's conversation. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodDelete, pinPath, USER); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if convo := first(); convo.ConversationId != newer.ConversationId || convo.PinnedAt != nil {
+		t.Fatalf("the newer conversation should be first once the other is unpinned: %+v", convo)
+	}
+}
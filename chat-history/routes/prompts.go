@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+)
+
+// longest system prompt a conversation can have, so it leaves most of the context window to the messages
+const maxSystemPromptLen = 16 * 1024
+
+type systemPromptRequest struct {
+	SystemPrompt string `json:"system_prompt"`
+}
+
+type systemPromptResponse struct {
+	// the prompt replies are generated with
+	SystemPrompt string `json:"system_prompt"`
+	// whether it's llm_config.default_system_prompt, because the conversation doesn't have one
+	Default bool `json:"default"`
+}
+
+// Get the system prompt a conversation's replies are generated with
+// "GET /conversation/{conversationId}/system_prompt"
+// It's the conversation's own if it has one and otherwise llm_config.default_system_prompt, with "default": true
+func GetSystemPrompt(store db.ConversationStore, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+
+		prompt, err := store.GetSystemPrompt(userId, r.PathValue("conversationId"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to get the system prompt"))
+			return
+		}
+		writeSystemPrompt(w, r, llmCfg, prompt)
+	}
+}
+
+// Set the system prompt a conversation's replies are generated with
+// "PUT /conversation/{conversationId}/system_prompt" with {"system_prompt": "..."}
+// It's sent to the LLM ahead of the conversation's messages whenever a reply is streamed or resumed, however many
+// of the messages fit. An empty system_prompt goes back to llm_config.default_system_prompt.
+// Summaries and titles have instructions of their own, they aren't given it
+func SetSystemPrompt(store db.ConversationStore, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+
+		var req systemPromptRequest
+		if apiErr := decodeBody(r, &req, "body must be a JSON object with the system_prompt"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if len(req.SystemPrompt) > maxSystemPromptLen {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("system_prompt must be at most %d characters", maxSystemPromptLen)))
+			return
+		}
+
+		if err := store.SetSystemPrompt(userId, r.PathValue("conversationId"), req.SystemPrompt); err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to set the system prompt"))
+			return
+		}
+		writeSystemPrompt(w, r, llmCfg, req.SystemPrompt)
+	}
+}
+
+func writeSystemPrompt(w http.ResponseWriter, r *http.Request, llmCfg config.LLMConfig, prompt string) {
+	resp := systemPromptResponse{SystemPrompt: prompt}
+	if prompt == "" {
+		resp = systemPromptResponse{SystemPrompt: llmCfg.DefaultSystemPrompt, Default: true}
+	}
+	if out, err := marshal(r, resp); err == nil {
+		w.Header().Add("Content-Type", "application/json")
+		w.Write([]byte(out))
+	} else {
+		panic(err)
+	}
+}
+
+// withSystemPrompt puts the conversation's system prompt, or else llm_config.default_system_prompt, ahead of its
+// messages. It's a separate message from the history, rather than one of its messages, so it's there however the
+// history is cut down, and TruncateHistory always keeps it
+func withSystemPrompt(llmCfg config.LLMConfig, convo *structs.Conversation, messages []llm.Message) []llm.Message {
+	prompt := convo.SystemPrompt
+	if prompt == "" {
+		prompt = llmCfg.DefaultSystemPrompt
+	}
+	if prompt == "" {
+		return messages
+	}
+	return append([]llm.Message{{Role: "system", Content: prompt}}, messages...)
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"bufio"
+	"chat-history/config"
+	"chat-history/llm"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSystemPrompt(t *testing.T) {
+	store := setupStreamDB(t)
+	llmCfg := config.LLMConfig{ModelName: "GPT-4o", DefaultSystemPrompt: "You answer questions about the graph"}
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}/system_prompt", GetSystemPrompt(store, llmCfg))
+	mux.Handle("PUT /conversation/{conversationId}/system_prompt", SetSystemPrompt(store, llmCfg))
+	do := func(user, method, body string) (*httptest.ResponseRecorder, systemPromptResponse) {
+		req := httptest.NewRequest(method, "/conversation/"+CONVO_ID+"/system_prompt", strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var out systemPromptResponse
+		json.Unmarshal(resp.Body.Bytes(), &out)
+		return resp, out
+	}
+	setPrompt := func(prompt string) {
+		t.Helper()
+		if resp, _ := do(USER, http.MethodPut, fmt.Sprintf(`{"system_prompt": %q}`, prompt)); resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+	}
+	// what the LLM is sent when the conversation's next reply is streamed. The replies are empty so they aren't
+	// saved, and each one is sent the same history
+	sent := func() []llm.Message {
+		t.Helper()
+		client := newStreamingLLM()
+		close(client.chunks)
+		resp := startStream(t, StreamConversation(store, client, llmCfg), context.Background(), USER, CONVO_ID)
+		readEvent(t, bufio.NewReader(resp.Body))
+		return client.messages
+	}
+
+	// without a prompt of its own, the conversation falls back to default_system_prompt
+	if resp, out := do(USER, http.MethodGet, ""); resp.Code != 200 || out.SystemPrompt != llmCfg.DefaultSystemPrompt || !out.Default {
+		t.Fatalf("the default system prompt should be returned. Got %v: %s", resp.Code, resp.Body)
+	}
+	if messages := sent(); len(messages) != 3 || messages[0].Role != "system" || messages[0].Content != llmCfg.DefaultSystemPrompt {
+		t.Fatalf("the LLM should be sent the default system prompt ahead of the history: %+v", messages)
+	}
+
+	// the conversation's own overrides it
+	setPrompt("Answer in French")
+	if resp, out := do(USER, http.MethodGet, ""); resp.Code != 200 || out.SystemPrompt != "Answer in French" || out.Default {
+		t.Fatalf("the conversation's system prompt should be returned. Got %v: %s", resp.Code, resp.Body)
+	}
+	messages := sent()
+	if messages[0].Role != "system" || messages[0].Content != "Answer in French" {
+		t.Fatalf("the LLM should be sent the conversation's system prompt: %+v", messages)
+	}
+	for _, m := range messages[1:] {
+		if m.Role == "system" {
+			t.Fatalf("only one system prompt should be sent: %+v", messages)
+		}
+	}
+
+	// it's kept even when the history doesn't fit
+	tight := llmCfg
+	tight.MaxContextTokens = 20
+	client := newStreamingLLM()
+	close(client.chunks)
+	resp := startStream(t, StreamConversation(store, client, tight), context.Background(), USER, CONVO_ID)
+	readEvent(t, bufio.NewReader(resp.Body))
+	if len(client.messages) != 2 || client.messages[0].Content != "Answer in French" {
+		t.Fatalf("the system prompt should be kept when the history is truncated: %+v", client.messages)
+	}
+
+	// clearing it goes back to the default, and without a default there's no system message at all
+	setPrompt("")
+	if messages := sent(); messages[0].Content != llmCfg.DefaultSystemPrompt {
+		t.Fatalf("the LLM should be sent the default system prompt again: %+v", messages)
+	}
+	llmCfg.DefaultSystemPrompt = ""
+	for _, m := range sent() {
+		if m.Role == "system" {
+			t.Fatalf("no system prompt should be sent without one: %+v", m)
+		}
+	}
+
+	if resp, _ := do("Miss_Take", http.MethodPut, `{"system_prompt": "Be rude"}`); resp.Code != http.StatusNotFound {
+		t.Fatalf("another user's conversation should be not found. Got %v", resp.Code)
+	}
+	if resp, _ := do(USER, http.MethodPut, fmt.Sprintf(`{"system_prompt": %q}`, strings.Repeat("a", maxSystemPromptLen+1))); resp.Code != http.StatusBadRequest {
+		t.Fatalf("a system prompt that's too long should be rejected. Got %v: %s", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,10 @@
+package routes
+
+import "net/http"
+
+// RejectWrites stands in for write endpoints when the store is read-only
+func RejectWrites() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, errReadOnly)
+	}
+}
@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// setupReadOnlyDB populates a db file and opens it again read-only
+func setupReadOnlyDB(t *testing.T) db.ConversationStore {
+	tmp := t.TempDir()
+	pth := fmt.Sprintf("%s/%s", tmp, "test.db")
+	os.Setenv("DEV", "true") // populate db
+	db.InitDB(pth, fmt.Sprintf("%s/test.log", tmp))
+
+	store, err := db.NewSQLiteStore(pth, fmt.Sprintf("%s/ro.log", tmp), db.ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestReadOnlyStore(t *testing.T) {
+	store := setupReadOnlyDB(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	mux.HandleFunc("DELETE /conversation/{conversationId}", DeleteConversation(store, testAuditLog(t)))
+	mux.HandleFunc("POST /conversation/{conversationId}/restore", RestoreConversation(store, time.Hour))
+	mux.HandleFunc("POST /conversation/{conversationId}/archive", ArchiveConversation(store))
+
+	// an existing conversation and a new one
+	appendMsg, _ := json.Marshal(structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "more", Role: structs.UserRole})
+	createMsg, _ := json.Marshal(structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "new", Role: structs.UserRole})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   []byte
+		code   int
+	}{
+		{"read", http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), nil, http.StatusOK},
+		{"append", http.MethodPost, "/conversation", appendMsg, http.StatusMethodNotAllowed},
+		{"create", http.MethodPost, "/conversation", createMsg, http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, fmt.Sprintf("/conversation/%s", CONVO_ID), nil, http.StatusMethodNotAllowed},
+		{"restore", http.MethodPost, fmt.Sprintf("/conversation/%s/restore", CONVO_ID), nil, http.StatusMethodNotAllowed},
+		{"archive", http.MethodPost, fmt.Sprintf("/conversation/%s/archive", CONVO_ID), nil, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v %s", tt.code, resp.Code, resp.Body.String())
+			}
+		})
+	}
+
+	// nothing was written
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("the conversation should still have 2 messages. It has: %d", len(messages))
+	}
+}
+
+func TestRejectWrites(t *testing.T) {
+	resp := httptest.NewRecorder()
+	RejectWrites()(resp, httptest.NewRequest(http.MethodPost, "/conversation", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Response code should be 405. It is: %v", resp.Code)
+	}
+	if _, ok := resp.Header()["Allow"]; !ok {
+		t.Fatal("405 responses should have an Allow header")
+	}
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+)
+
+type markReadRequest struct {
+	MessageId string `json:"message_id"`
+}
+
+type unreadResponse struct {
+	UnreadCount int `json:"unread_count"`
+}
+
+// Mark the messages of a conversation up to and including one as read by the caller, and get how many are unread
+// "PUT /conversation/{conversationId}/read" with {"message_id": "..."}
+// Marking an earlier message makes the ones after it unread again. Anyone who can read the conversation has their own
+func MarkRead(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		var req markReadRequest
+		if apiErr := decodeBody(r, &req, `body must be {"message_id": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		// conversations the caller can't read are reported as not found too, so their ids aren't revealed
+		convo, err := store.FindConversation(conversationId)
+		if err == nil && !allowed(r, store, userId, convo, structs.PermissionRead) {
+			err = db.ErrNotFound
+		}
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to mark the conversation read"))
+			return
+		}
+		if req.MessageId == "" {
+			writeError(w, apierror.InvalidRequest("message_id is required"))
+			return
+		}
+
+		if err := store.MarkRead(userId, conversationId, req.MessageId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("message %s not found in conversation %s", req.MessageId, conversationId), "failed to mark the conversation read"))
+			return
+		}
+		unread, err := store.GetUnreadCount(userId, conversationId)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to count unread messages"))
+			return
+		}
+		out, err := marshal(r, unreadResponse{UnreadCount: unread})
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
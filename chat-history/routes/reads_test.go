@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMarkRead(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{"globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/read", withRoles(MarkRead(store)))
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	readPath := fmt.Sprintf("/conversation/%s/read", CONVO_ID)
+	markRead := func(user string, messageId uuid.UUID) int {
+		t.Helper()
+		resp := do(http.MethodPut, readPath, user, fmt.Sprintf(`{"message_id": %q}`, messageId))
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var unread unreadResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &unread); err != nil {
+			t.Fatal(err)
+		}
+		return unread.UnreadCount
+	}
+	unreadOf := func(user string) int {
+		t.Helper()
+		resp := do(http.MethodGet, "/user/"+user, user, "")
+		var convos []structs.Conversation
+		if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range convos {
+			if c.ConversationId.String() == CONVO_ID {
+				return c.UnreadCount
+			}
+		}
+		t.Fatalf("the conversation should be listed: %s", resp.Body)
+		return 0
+	}
+
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "the answer", Role: structs.AssistantRole}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if n := unreadOf(USER); n == 0 {
+		t.Fatal("the conversation should have unread messages before it's read")
+	}
+	if n := markRead(USER, reply.MessageId); n != 0 {
+		t.Fatalf("nothing should be unread after the latest message, got %d", n)
+	}
+	if n := unreadOf(USER); n != 0 {
+		t.Fatalf("the listed conversation shouldn't have unread messages, got %d", n)
+	}
+
+	// a new message is unread
+	next := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), ParentId: &reply.MessageId, Content: "and another", Role: structs.UserRole}
+	if _, err := store.AppendMessage(next, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if n := unreadOf(USER); n != 1 {
+		t.Fatalf("the new message should be unread, got %d", n)
+	}
+
+	// other users can't mark it until they can read it, and then it's their own
+	if resp := do(http.MethodPut, readPath, "Miss_Take", fmt.Sprintf(`{"message_id": %q}`, next.MessageId)); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404 for another user's conversation. It is: %v", resp.Code)
+	}
+	if _, err := store.GrantAccess(USER, CONVO_ID, structs.ConversationAccess{UserId: "Miss_Take", Permission: structs.PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	if n := markRead("Miss_Take", next.MessageId); n != 0 {
+		t.Fatalf("nothing should be unread for the user granted access after the latest message, got %d", n)
+	}
+	if n := unreadOf(USER); n != 1 {
+		t.Fatalf("the owner's unread count shouldn't change, got %d", n)
+	}
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"message_id": "` + uuid.NewString() + `"}`, http.StatusNotFound},
+	} {
+		if resp := do(http.MethodPut, readPath, USER, tt.body); resp.Code != tt.code {
+			t.Fatalf("%s: response code should be %d. It is: %v: %s", tt.body, tt.code, resp.Code, resp.Body)
+		}
+	}
+}
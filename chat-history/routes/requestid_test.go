@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"chat-history/middleware"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	store := setupDB(t, true)
+	// the id of every SHOW USER TigerGraph gets
+	downstream := make(chan string, 1)
+	hostname, gsPort := fakeTigerGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		downstream <- r.Header.Get("X-Request-ID")
+		w.Write([]byte(fmt.Sprintf("  - Name: %s\n    - Global Roles: globalobserver\n", USER)))
+	})
+
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	requestLog, err := middleware.OpenRequestLog(pth, middleware.LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireRoles := RequireRoles([]string{"globalobserver"}, TigerGraphRoles(hostname, gsPort))
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}", requireRoles(GetConversation(store)))
+	handler := middleware.ChainMiddleware(mux, middleware.RequestLogger(requestLog), middleware.RequestID())
+
+	do := func(id string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("response code should be 200. It's: %d: %s", resp.Code, resp.Body)
+		}
+		got := resp.Header().Get("X-Request-ID")
+		if sent := <-downstream; sent != got {
+			t.Fatalf("TigerGraph should get the request's id %q. It got: %q", got, sent)
+		}
+		return got
+	}
+
+	generated := do("")
+	if generated == "" {
+		t.Fatal("a request without an X-Request-ID should be given one")
+	}
+	if kept := do("import-7f3a"); kept != "import-7f3a" {
+		t.Fatalf("the caller's X-Request-ID should be kept. It's: %q", kept)
+	}
+	// ids that can't go in a log line or header are replaced
+	if replaced := do(strings.Repeat("x", 200)); replaced == strings.Repeat("x", 200) || replaced == "" {
+		t.Fatalf("an id that's too long should be replaced. It's: %q", replaced)
+	}
+	if err := requestLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("there should be a log line per request. There are %d:\n%s", len(lines), b)
+	}
+	for i, want := range []string{generated, "import-7f3a"} {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("log line is not valid JSON: %s", lines[i])
+		}
+		if entry["request_id"] != want {
+			t.Fatalf("log line should have the request id %q: %s", want, lines[i])
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+)
+
+type editRequest struct {
+	Content string `json:"content"`
+}
+
+// Correct a message. What it said before is kept as a revision
+// "PUT /conversation/{conversationId}/messages/{messageId}" with {"content": "..."}
+// With If-Match set to the conversation's ETag, it's only edited if no one has changed the conversation since,
+// otherwise it responds 409
+
+func EditMessage(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, ok := conversationOwner(w, r, store, structs.PermissionWrite)
+		if !ok {
+			return
+		}
+
+		var edit editRequest
+		if apiErr := decodeBody(r, &edit, "body must be a JSON object with the new content"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		version, apiErr := expectedVersion(r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		message, err := store.EditMessage(userId, conversationId, r.PathValue("messageId"), edit.Content, version)
+		if err != nil {
+			writeError(w, storeError(err, messageNotFound(r), "failed to edit message"))
+			return
+		}
+		if version != db.AnyVersion {
+			// the edit is the only change since the version it expected
+			w.Header().Set("ETag", etag(version+1))
+		}
+		if out, err := marshal(r, message); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get what a message said before each time it was edited, oldest first
+// "GET /conversation/{conversationId}/messages/{messageId}/revisions"
+func ListRevisions(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, structs.PermissionRead)
+		if !ok {
+			return
+		}
+
+		revisions, err := store.ListRevisions(userId, r.PathValue("conversationId"), r.PathValue("messageId"))
+		if err != nil {
+			writeError(w, storeError(err, messageNotFound(r), "failed to retrieve revisions"))
+			return
+		}
+		if out, err := marshal(r, revisions); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// conversationOwner returns the user to act as on the conversation in r's path: the caller, or the
+// conversation's owner for superusers and callers granted permission on it (see GrantAccess).
+// It responds with the error if the caller isn't authenticated
+func conversationOwner(w http.ResponseWriter, r *http.Request, store db.ConversationStore, permission string) (string, bool) {
+	userId, authErr := auth("", r)
+	if authErr != nil {
+		writeError(w, authErr)
+		return "", false
+	}
+	return actAs(r, store, userId, r.PathValue("conversationId"), permission), true
+}
+
+// messageNotFound is the message for the message in r's path not being found.
+// It also covers conversations of other users, so their ids aren't revealed
+func messageNotFound(r *http.Request) string {
+	return fmt.Sprintf("message %s not found in conversation %s", r.PathValue("messageId"), r.PathValue("conversationId"))
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditMessage(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}", withRoles(EditMessage(store)))
+	mux.Handle("GET /conversation/{conversationId}/messages/{messageId}/revisions", withRoles(ListRevisions(store)))
+
+	do := func(method, path, user string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("the conversation should have messages. Got %v, %v", messages, err)
+	}
+	original := messages[0]
+	msgPath := fmt.Sprintf("/conversation/%s/messages/%s", CONVO_ID, original.MessageId)
+
+	resp := do(http.MethodPut, msgPath, USER, strings.NewReader(`{"content":"fixed typo"}`))
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var edited structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &edited)
+	if edited.Content != "fixed typo" || edited.MessageId != original.MessageId {
+		t.Fatalf("the edited message should be returned: %s", resp.Body)
+	}
+	// superusers can edit any conversation
+	if resp := do(http.MethodPut, msgPath, "admin", strings.NewReader(`{"content":"fixed again"}`)); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	resp = do(http.MethodGet, msgPath+"/revisions", USER, nil)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var revisions []structs.MessageRevision
+	json.Unmarshal(resp.Body.Bytes(), &revisions)
+	if len(revisions) != 2 || revisions[0].Content != original.Content || revisions[1].Content != "fixed typo" {
+		t.Fatalf("both earlier contents should be listed, oldest first: %s", resp.Body)
+	}
+
+	// other users can't edit or read revisions, and can't tell the conversation exists
+	if resp := do(http.MethodPut, msgPath, "Miss_Take", strings.NewReader(`{"content":"mine"}`)); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodGet, msgPath+"/revisions", "Miss_Take", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	missing := fmt.Sprintf("/conversation/%s/messages/4f6c5ef9-1b49-4a57-a0a5-10e0f1498c4d", CONVO_ID)
+	if resp := do(http.MethodPut, missing, USER, strings.NewReader(`{"content":"?"}`)); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodPut, msgPath, USER, strings.NewReader(`not json`)); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, msgPath+"/revisions", nil)
+	unauthenticated := httptest.NewRecorder()
+	mux.ServeHTTP(unauthenticated, req)
+	if unauthenticated.Code != 401 {
+		t.Fatalf("Response code without credentials should be 401. It is: %v", unauthenticated.Code)
+	}
+}
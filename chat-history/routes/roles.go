@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/authn"
+	"chat-history/db"
+	"chat-history/tenant"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// SuperuserRole bypasses all per-conversation ownership checks
+const SuperuserRole = "superuser"
+
+// RoleResolver looks up the roles of the user with the given credentials.
+// ctx is the context of the request being authorized. It's an authn.Authenticator of basic auth callers
+type RoleResolver = authn.BasicAuth
+
+// TigerGraphRoles resolves a user's global roles by running SHOW USER on TigerGraph with their credentials
+func TigerGraphRoles(hostname, gsPort string) RoleResolver {
+	return func(ctx context.Context, username, password string) ([]string, error) {
+		userInfo, err := executeGSQL(ctx, hostname, username, password, "SHOW USER", gsPort)
+		if err != nil {
+			return nil, err
+		}
+		return parseUserRoles(userInfo, username), nil
+	}
+}
+
+// RequireRoles rejects requests from callers that don't have at least one of the allowed roles.
+// The caller's identity is stored in the request context for the handlers, and so is their tenant if they have one
+func RequireRoles(allowed []string, authenticator authn.Authenticator) func(http.Handler) http.Handler {
+	return RequireRolesFunc(func() []string { return allowed }, authenticator)
+}
+
+// RequireRolesFunc is RequireRoles with the allowed roles looked up on every request,
+// so they can change while the server is running
+func RequireRolesFunc(allowed func() []string, authenticator authn.Authenticator) func(http.Handler) http.Handler {
+	return requireRoles(allowed, authenticator, "user does not have a role with access to conversations")
+}
+
+// RequireAdmin rejects requests from callers that don't have at least one of the admin roles,
+// looked up on every request. Nobody gets through while there aren't any
+func RequireAdmin(adminRoles func() []string, authenticator authn.Authenticator) func(http.Handler) http.Handler {
+	return requireRoles(adminRoles, authenticator, "user does not have an admin role")
+}
+
+// requireRoles is RequireRolesFunc, responding with forbidden to callers without an allowed role
+func requireRoles(allowed func() []string, authenticator authn.Authenticator, forbidden string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			id, err := authenticator.Authenticate(r)
+			if errors.Is(err, authn.ErrUnauthenticated) {
+				writeError(w, apierror.Unauthorized(err.Error()))
+				return
+			} else if errors.Is(err, authn.ErrNoTenant) || errors.Is(err, authn.ErrInvalidTenant) {
+				writeError(w, apierror.InvalidRequest(err.Error()))
+				return
+			} else if errors.Is(err, authn.ErrWrongTenant) {
+				writeError(w, apierror.Forbidden(err.Error()))
+				return
+			} else if errors.Is(err, authn.ErrUnavailable) {
+				slog.Error("failed to authenticate the request", "err", err)
+				writeError(w, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "user roles can't be checked right now, try again later"))
+				return
+			} else if err != nil {
+				slog.Error("failed to authenticate the request", "err", err)
+				writeError(w, apierror.Internal("failed to retrieve user roles"))
+				return
+			}
+			if !hasAdminAccess(id.Roles, allowed()) {
+				writeError(w, apierror.Forbidden(forbidden))
+				return
+			}
+
+			ctx := authn.NewContext(r.Context(), id)
+			if id.Tenant != "" {
+				// the store only reads and writes the tenant's rows
+				ctx = tenant.NewContext(ctx, id.Tenant)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RolesFromContext returns the caller's roles set by RequireRoles
+func RolesFromContext(ctx context.Context) []string {
+	id, _ := authn.FromContext(ctx)
+	return id.Roles
+}
+
+// caller is the user id of whoever made the request: the one RequireRoles authenticated,
+// or else the basic auth username. ok is false if there's neither
+func caller(r *http.Request) (userId string, ok bool) {
+	if id, ok := authn.FromContext(r.Context()); ok {
+		return id.UserId, true
+	}
+	usr, _, ok := r.BasicAuth()
+	return usr, ok
+}
+
+func isSuperuser(r *http.Request) bool {
+	return slices.Contains(RolesFromContext(r.Context()), SuperuserRole)
+}
+
+// conversationLimit is store with the limit on how many conversations r's caller can have, which superusers
+// don't have (see db.MaxConversations)
+func conversationLimit(r *http.Request, store db.ConversationStore) db.ConversationStore {
+	if isSuperuser(r) {
+		return store.WithoutConversationLimit()
+	}
+	return store
+}
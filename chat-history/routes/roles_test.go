@@ -0,0 +1,390 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/apierror"
+	"chat-history/authn"
+	"chat-history/config"
+	"chat-history/middleware"
+	"chat-history/structs"
+	"chat-history/tigergraph"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func fakeRoles(roles map[string][]string) RoleResolver {
+	return func(ctx context.Context, username, password string) ([]string, error) {
+		if username == "broken" {
+			return nil, errors.New("tigergraph is down")
+		}
+		return roles[username], nil
+	}
+}
+
+func TestRequireRoles(t *testing.T) {
+	resolve := fakeRoles(map[string][]string{
+		"allowed":    {"globalobserver", "globaldesigner"},
+		"disallowed": {"globalobserver"},
+		"noroles":    nil,
+	})
+	var gotRoles []string
+	handler := RequireRoles([]string{"superuser", "globaldesigner"}, resolve)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRoles = RolesFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		user string
+		code int
+	}{
+		{"allowed", 200},
+		{"disallowed", 403},
+		{"noroles", 403},
+		{"broken", 500},
+		{"", 401}, // no auth
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			gotRoles = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, PASS)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.Code)
+			}
+			if tt.code == 200 && len(gotRoles) != 2 {
+				t.Fatalf("roles should be passed to the handler. Got: %v", gotRoles)
+			}
+			if tt.code != 200 && gotRoles != nil {
+				t.Fatal("handler should not be called")
+			}
+		})
+	}
+}
+
+func TestRequireRolesFunc(t *testing.T) {
+	resolve := fakeRoles(map[string][]string{"analyst": {"analyst"}})
+	allowed := []string{"superuser"}
+	handler := RequireRolesFunc(func() []string { return allowed }, resolve)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("analyst", PASS)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	if code := get(); code != 403 {
+		t.Fatalf("Response code should be 403. It is: %v", code)
+	}
+	// i.e., conversationAccessRoles was reloaded
+	allowed = []string{"superuser", "analyst"}
+	if code := get(); code != 200 {
+		t.Fatalf("the new roles should be used on the next request. Response code is: %v", code)
+	}
+}
+
+func TestRequireRoles_DevMode(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	withRoles := RequireRoles([]string{"globaldesigner"}, authn.NewDev([]string{"globaldesigner"}))
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+	mux.Handle("GET /admin/user/{userId}", RequireAdmin(func() []string { return []string{"support"} }, authn.NewDev([]string{"globaldesigner"}))(GetUserConversations(store)))
+
+	get := func(path string, setAuth func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		setAuth(req)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	// the password isn't checked, the user gets the dev roles
+	resp := get("/user/"+USER, func(r *http.Request) { r.SetBasicAuth(USER, "not the password") })
+	if resp.Code != 200 || !strings.Contains(resp.Body.String(), CONVO_ID) {
+		t.Fatalf("the caller should get their conversations. Got %v: %s", resp.Code, resp.Body)
+	}
+	// without credentials it's the dev user
+	if resp := get("/user/"+authn.DevUser, func(*http.Request) {}); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get("/user/"+USER, func(*http.Request) {}); resp.Code != http.StatusForbidden {
+		t.Fatalf("the dev user shouldn't get other users' conversations. Got %v: %s", resp.Code, resp.Body)
+	}
+	// the roles still have to be the ones the endpoint needs
+	if resp := get("/admin/user/"+USER, func(*http.Request) {}); resp.Code != http.StatusForbidden {
+		t.Fatalf("the dev roles don't include an admin role. Got %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestRequireRoles_SuperuserBypassesOwnership(t *testing.T) {
+	// setup
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{
+		"admin":     {SuperuserRole},
+		"Miss_Take": {"globaldesigner"},
+	})
+	requireRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", requireRoles(GetUserConversations(store)))
+	mux.Handle("GET /conversation/{conversationId}", requireRoles(GetConversation(store)))
+	mux.Handle("POST /conversation", requireRoles(UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil)))
+
+	get := func(user, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth(user, PASS)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// superuser can list and read sam_pull's conversations
+	if resp := get("admin", fmt.Sprintf("/user/%s", USER)); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	resp := get("admin", fmt.Sprintf("/conversation/%s", CONVO_ID))
+	var messages []structs.Message
+	if err := json.Unmarshal(resp.Body.Bytes(), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("superuser should see the 2 messages in %s. Saw: %d", CONVO_ID, len(messages))
+	}
+
+	// a user with an allowed role is still bound by ownership
+	if resp := get("Miss_Take", fmt.Sprintf("/user/%s", USER)); resp.Code != 403 {
+		t.Fatalf("Response code should be 403. It is: %v", resp.Code)
+	}
+	resp = get("Miss_Take", fmt.Sprintf("/conversation/%s", CONVO_ID))
+	if err := json.Unmarshal(resp.Body.Bytes(), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Fatalf("Miss_Take should not see messages in %s. Saw: %d", CONVO_ID, len(messages))
+	}
+
+	// only the owner or a superuser can write to the conversation
+	post := func(user string) int {
+		msg := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "hi", Role: structs.UserRole}
+		b, _ := json.Marshal(msg)
+		req := httptest.NewRequest(http.MethodPost, "/conversation", bytes.NewReader(b))
+		req.SetBasicAuth(user, PASS)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	if code := post("Miss_Take"); code != 403 {
+		t.Fatalf("Response code should be 403. It is: %v", code)
+	}
+	if code := post("admin"); code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", code)
+	}
+}
+
+// hs256 signs a JWT with the claims
+func hs256(secret []byte, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	body, _ := json.Marshal(claims)
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestRequireRoles_JWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("TEST_JWT_SECRET", base64.StdEncoding.EncodeToString(secret))
+	jwt, err := authn.NewJWT(config.AuthConfig{
+		Provider:   config.AuthOIDC,
+		Issuer:     "https://login.example.com",
+		Audience:   "chat-history",
+		SecretEnv:  "TEST_JWT_SECRET",
+		UserClaim:  "sub",
+		RolesClaim: "roles",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", RequireRoles([]string{SuperuserRole, "globaldesigner"}, jwt)(GetUserConversations(store)))
+
+	token := func(user string, roles []string, exp time.Duration) string {
+		return hs256(secret, map[string]any{
+			"iss":   "https://login.example.com",
+			"aud":   "chat-history",
+			"sub":   user,
+			"roles": roles,
+			"exp":   time.Now().Add(exp).Unix(),
+		})
+	}
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"owner", token(USER, []string{"globaldesigner"}, time.Hour), 200},
+		{"superuser", token("admin", []string{SuperuserRole}, time.Hour), 200},
+		{"another user", token("Miss_Take", []string{"globaldesigner"}, time.Hour), 403},
+		{"no access role", token(USER, []string{"globalobserver"}, time.Hour), 403},
+		{"expired", token(USER, []string{"globaldesigner"}, -time.Hour), 401},
+		{"signed with another secret", hs256([]byte("fedcba9876543210fedcba9876543210"), map[string]any{"sub": USER}), 401},
+		{"no token", "", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.code, resp.Code, resp.Body)
+			}
+			if tt.code != 200 {
+				return
+			}
+			// the handlers see the token's user, there's no basic auth
+			var convos []structs.Conversation
+			if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil || len(convos) == 0 || convos[0].UserId != USER {
+				t.Fatalf("the user's conversations should be listed: %s", resp.Body)
+			}
+		})
+	}
+}
+
+func TestRequireRoles_JWTRequestLog(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("TEST_JWT_SECRET", base64.StdEncoding.EncodeToString(secret))
+	jwt, err := authn.NewJWT(config.AuthConfig{
+		Provider:   config.AuthOIDC,
+		Issuer:     "https://login.example.com",
+		Audience:   "chat-history",
+		SecretEnv:  "TEST_JWT_SECRET",
+		UserClaim:  "sub",
+		RolesClaim: "roles",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pth := fmt.Sprintf("%s/%s", t.TempDir(), "requestLogs.jsonl")
+	requestLog, err := middleware.OpenRequestLog(pth, middleware.LogRotation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", RequireRoles([]string{SuperuserRole}, jwt)(GetUserConversations(store)))
+	handler := middleware.ChainMiddleware(mux, middleware.RequestLogger(requestLog))
+
+	// a superuser reading another user's conversations is logged as themselves
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
+	req.Header.Set("Authorization", "Bearer "+hs256(secret, map[string]any{
+		"iss":   "https://login.example.com",
+		"aud":   "chat-history",
+		"sub":   "admin",
+		"roles": []string{SuperuserRole},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if err := requestLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry struct {
+		UserId string `json:"user_id"`
+	}
+	if err := json.Unmarshal(b, &entry); err != nil || entry.UserId != "admin" {
+		t.Fatalf("the token's subject should be logged as the user: %s", b)
+	}
+}
+
+func TestRequireRoles_TigerGraphDownThenUp(t *testing.T) {
+	var up atomic.Bool
+	hostname, gsPort := fakeTigerGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/restpp/echo":
+			w.Write([]byte(`{"error":false, "message":"Hello GSQL"}`))
+		case "/gsqlserver/gsql/file":
+			w.Write([]byte("  - Name: sam_pull\n  - Global Roles: globaldesigner\n"))
+		}
+	})
+	monitor := tigergraph.NewMonitor(func(ctx context.Context) error { return tigergraph.Ping(ctx, hostname, gsPort) }, time.Second)
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, authn.NewFallback(TigerGraphRoles(hostname, gsPort), monitor, time.Hour))
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+	mux.Handle("DELETE /conversation/{conversationId}", withRoles(DeleteConversation(store, testAuditLog(t))))
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	read, write := fmt.Sprintf("/user/%s", USER), fmt.Sprintf("/conversation/%s", CONVO_ID)
+
+	// down when the server starts: nobody can be authenticated yet
+	monitor.Check(context.Background())
+	if resp := do(http.MethodGet, read); resp.Code != 503 {
+		t.Fatalf("Response code should be 503. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodDelete, write); resp.Code != 503 {
+		t.Fatalf("Response code should be 503. It is: %v", resp.Code)
+	}
+
+	// up: the caller signs in
+	up.Store(true)
+	monitor.Check(context.Background())
+	if resp := do(http.MethodGet, read); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// down again: they can still read, but not write
+	up.Store(false)
+	monitor.Check(context.Background())
+	if resp := do(http.MethodGet, read); resp.Code != 200 {
+		t.Fatalf("reads should still work. Response code: %v: %s", resp.Code, resp.Body)
+	}
+	resp := do(http.MethodDelete, write)
+	var body apierror.APIError
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if resp.Code != 503 || body.Code != apierror.CodeUnavailable {
+		t.Fatalf("writes should be unavailable. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// and back up
+	up.Store(true)
+	monitor.Check(context.Background())
+	if resp := do(http.MethodDelete, write); resp.Code != 204 {
+		t.Fatalf("writes should work again. Response code: %v: %s", resp.Code, resp.Body)
+	}
+}
@@ -1,32 +1,78 @@
 package routes
 
 import (
+	"chat-history/apierror"
+	"chat-history/audit"
+	"chat-history/authn"
+	"chat-history/config"
 	"chat-history/db"
+	"chat-history/jobs"
+	"chat-history/llm"
 	"chat-history/structs"
+	"chat-history/tigergraph"
+	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Get all of the conversations for a user
-// "GET /user/{userId}"
-func GetUserConversations(w http.ResponseWriter, r *http.Request) {
-	userId := r.PathValue("userId")
-	if _, code, reason, ok := auth(userId, r); !ok {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(code)
-		w.Write(reason)
-		return
+// Get the conversations for a user, the pinned ones first (latest pinned first), then the rest most recently updated first
+// "GET /user/{userId}?limit=int&cursor=string&tag=string&language=string&metadata=key:value&archived=bool"
+// When limit is set, the cursor for the next page is returned in the X-Next-Cursor header (empty on the last page).
+// tag can be repeated, only conversations with every tag are returned. language is an ISO 639-1 code like "en",
+// only conversations most of whose messages are in it are returned. metadata can be repeated too, only
+// conversations whose metadata has each key set to its value are returned
+func GetUserConversations(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if _, err := auth(userId, r); err != nil {
+			writeError(w, err)
+			return
+		}
+		listConversations(w, r, store, userId)
+	}
+}
+
+// listConversations responds with a page of the user's conversations, as asked for by r's query
+func listConversations(w http.ResponseWriter, r *http.Request, store db.ConversationStore, userId string) {
+	opts := db.ListOptions{Cursor: r.URL.Query().Get("cursor"), Tags: r.URL.Query()["tag"], Language: strings.ToLower(r.URL.Query().Get("language"))}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			writeError(w, apierror.InvalidRequest("limit must be a non-negative integer"))
+			return
+		}
+		opts.Limit = limit
+	}
+	for _, m := range r.URL.Query()["metadata"] {
+		key, value, ok := strings.Cut(m, ":")
+		if !ok {
+			writeError(w, apierror.InvalidRequest("metadata must be key:value"))
+			return
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]string{}
+		}
+		opts.Metadata[key] = value
 	}
+	opts.IncludeArchived = strings.ToLower(r.URL.Query().Get("archived")) == "true"
 
-	conversations := db.GetUserConversations(userId)
-	if out, err := json.MarshalIndent(conversations, "", "  "); err == nil {
+	conversations, next, err := store.ListConversations(userId, opts)
+	if err != nil {
+		writeError(w, storeError(err, "", "failed to retrieve conversations"))
+		return
+	}
+	if out, err := marshal(r, conversations); err == nil {
 		w.Header().Add("Content-Type", "application/json")
+		w.Header().Set("X-Next-Cursor", next)
 		w.Write([]byte(out))
 	} else {
 		panic(err)
@@ -34,25 +80,282 @@ func GetUserConversations(w http.ResponseWriter, r *http.Request) {
 }
 
 // Get the contents of a conversation (list of messages)
-// "GET /conversation/{conversationId}?merge=bool"
-func GetConversation(w http.ResponseWriter, r *http.Request) {
-	conversationId := r.PathValue("conversationId")
-	merge := strings.ToLower(r.URL.Query().Get("merge")) == "true"
-	if userId, code, reason, ok := auth("", r); ok {
-		conversation := db.GetUserConversationById(userId, conversationId)
-		if merge {
-			conversation = mergeConversationHistory(conversation)
-		}
-		if out, err := json.MarshalIndent(conversation, "", "  "); err == nil {
+// "GET /conversation/{conversationId}?limit=int&offset=int&role=string&merge=bool&pinned_first=bool&include_context=bool&include_hidden=bool&since=RFC3339"
+// Messages are oldest first. Without limit and offset they're all returned, with them a page at a time: limit of them
+// (0 is all of them) after skipping offset. X-Total-Count is how many match the filters in all. With merge=true the
+// latest branch is merged from all of the messages, and then paged. With role, repeatable, only the messages with one
+// of the roles are returned
+// With pinned_first=true the pinned messages of the page come before the rest
+// With include_context=true messages have the graph_context GraphRAG answered them with, if they were appended with one
+// With include_hidden=true the context messages GraphRAG added for the LLM are returned too (see ContinueFromContext)
+// With since only the messages added or changed after it are returned, for polling with the update_ts of the last one
+// The ETag is the conversation's version, for If-Match on writes to it, and with Last-Modified for conditional
+// GETs: If-None-Match or If-Modified-Since an unchanged conversation is a 304. Pinning doesn't change the version
+func GetConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		merge := strings.ToLower(r.URL.Query().Get("merge")) == "true"
+		pinnedFirst := strings.ToLower(r.URL.Query().Get("pinned_first")) == "true"
+		includeContext := strings.ToLower(r.URL.Query().Get("include_context")) == "true"
+		opts := db.MessageOptions{IncludeHidden: strings.ToLower(r.URL.Query().Get("include_hidden")) == "true"}
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, apierror.InvalidRequest("since must be an RFC 3339 timestamp"))
+				return
+			}
+			opts.Since = t.Local()
+		}
+		if l := r.URL.Query().Get("limit"); l != "" {
+			limit, err := strconv.Atoi(l)
+			if err != nil || limit < 0 {
+				writeError(w, apierror.InvalidRequest("limit must be a non-negative integer"))
+				return
+			}
+			opts.Limit = limit
+		}
+		if o := r.URL.Query().Get("offset"); o != "" {
+			offset, err := strconv.Atoi(o)
+			if err != nil || offset < 0 {
+				writeError(w, apierror.InvalidRequest("offset must be a non-negative integer"))
+				return
+			}
+			opts.Offset = offset
+		}
+		for _, role := range r.URL.Query()["role"] {
+			if role == "" {
+				writeError(w, apierror.InvalidRequest("role must not be empty"))
+				return
+			}
+			opts.Roles = append(opts.Roles, structs.MessagengerRole(role))
+		}
+		if userId, authErr := auth("", r); authErr == nil {
+			found, findErr := store.FindConversation(conversationId)
+			// superusers and users granted access can read the conversation, read it as its owner
+			if findErr == nil && allowed(r, store, userId, found, structs.PermissionRead) {
+				userId = found.UserId
+			}
+			if findErr == nil && found.UserId == userId {
+				// for If-Match on writes. It's read before the messages, so it can only be older than them
+				w.Header().Set("ETag", etag(found.Version))
+				w.Header().Set("Last-Modified", found.UpdatedAt.UTC().Format(http.TimeFormat))
+				if notModified(r, found) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			// the branch is merged from every message, the page is taken from it
+			page := opts
+			if merge {
+				opts.Limit, opts.Offset = 0, 0
+			}
+			conversation, total, err := store.GetMessages(userId, conversationId, opts)
+			if err != nil {
+				writeError(w, apierror.Internal("failed to retrieve conversation"))
+				return
+			}
+			if merge {
+				conversation = mergeConversationHistory(conversation)
+				total = int64(len(conversation))
+				conversation = conversation[min(page.Offset, len(conversation)):]
+				if page.Limit > 0 {
+					conversation = conversation[:min(page.Limit, len(conversation))]
+				}
+			}
+			w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+			if includeContext {
+				contexts, err := store.GraphContexts(conversationId)
+				if err != nil {
+					writeError(w, apierror.Internal("failed to retrieve the graph context"))
+					return
+				}
+				for i, m := range conversation {
+					if c, ok := contexts[m.MessageId]; ok {
+						conversation[i].GraphContext = &c
+					}
+				}
+			}
+			if pinnedFirst {
+				// stable, so pinned and unpinned messages each keep their order
+				slices.SortStableFunc(conversation, func(a, b structs.Message) int {
+					switch {
+					case a.Pinned == b.Pinned:
+						return 0
+					case a.Pinned:
+						return -1
+					}
+					return 1
+				})
+			}
+			if out, err := marshal(r, conversation); err == nil {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(out))
+			} else {
+				panic(err)
+			}
+		} else {
+			writeError(w, authErr)
+		}
+	}
+}
+
+// Move a conversation to the trash
+// "DELETE /conversation/{conversationId}"
+// Every deletion is written to the audit log
+func DeleteConversation(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		// superusers can delete any conversation, delete it as its owner
+		if isSuperuser(r) {
+			if c, err := store.FindConversation(conversationId); err == nil {
+				userId = c.UserId
+			}
+		}
+
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "delete_conversation", UserId: userId, ConversationId: conversationId}) {
+			return
+		}
+		if err := store.DeleteConversation(userId, conversationId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to delete conversation"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Take a conversation out of the trash, if it was deleted within the retention window
+// "POST /conversation/{conversationId}/restore"
+func RestoreConversation(store db.ConversationStore, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		if err := store.RestoreConversation(userId, conversationId, retention); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s is not in the trash", conversationId), "failed to restore conversation"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Move a conversation to the archive database
+// "POST /conversation/{conversationId}/archive"
+func ArchiveConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		// superusers can archive any conversation, archive it as its owner
+		if isSuperuser(r) {
+			if c, err := store.FindConversation(conversationId); err == nil {
+				userId = c.UserId
+			}
+		}
+
+		if err := store.ArchiveConversation(userId, conversationId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to archive conversation"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Move a conversation back from the archive database
+// "POST /conversation/{conversationId}/unarchive"
+func UnarchiveConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		if err := store.UnarchiveConversation(userId, conversationId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s is not archived", conversationId), "failed to unarchive conversation"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Search the content of the caller's messages
+// "GET /search?q=string"
+func SearchMessages(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		query := r.URL.Query().Get("q")
+		if strings.TrimSpace(query) == "" {
+			writeError(w, apierror.InvalidRequest("missing search query q"))
+			return
+		}
+
+		results, err := store.SearchMessages(userId, query)
+		if err != nil {
+			writeError(w, apierror.Internal("failed to search messages"))
+			return
+		}
+		if out, err := marshal(r, results); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Search the names, tags and message content of the caller's conversations together, ranked with weights
+// "GET /search/all?q=string"
+// Each result's type is title, tag or message, and message results have the message_id
+func Search(store db.ConversationStore, weights db.SearchWeights) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		query := r.URL.Query().Get("q")
+		if strings.TrimSpace(query) == "" {
+			writeError(w, apierror.InvalidRequest("missing search query q"))
+			return
+		}
+
+		results, err := store.Search(userId, query, weights)
+		if err != nil {
+			writeError(w, apierror.Internal("failed to search conversations"))
+			return
+		}
+		if out, err := marshal(r, results); err == nil {
 			w.Header().Add("Content-Type", "application/json")
 			w.Write([]byte(out))
 		} else {
 			panic(err)
 		}
-	} else {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(code)
-		w.Write(reason)
 	}
 }
 
@@ -63,8 +366,9 @@ func mergeConversationHistory(convo []structs.Message) []structs.Message {
 	var latest *structs.Message
 	for _, m := range convo {
 		lookup[m.MessageId.String()] = &m
-		// if m is more recent than latest
-		if latest == nil || latest.UpdatedAt.UnixMilli() < m.UpdatedAt.UnixMilli() {
+		// if m is more recent than latest. Messages written in the same millisecond are ordered by insertion
+		if latest == nil || latest.UpdatedAt.UnixMilli() < m.UpdatedAt.UnixMilli() ||
+			(latest.UpdatedAt.UnixMilli() == m.UpdatedAt.UnixMilli() && latest.ID < m.ID) {
 			latest = &m
 		}
 	}
@@ -91,88 +395,173 @@ func mergeConversationHistory(convo []structs.Message) []structs.Message {
 
 // Update the contents of a conversation (i.e., add a message, or update it's feedback)
 // "POST /conversation"
-func UpdateConversation(w http.ResponseWriter, r *http.Request) {
-	// extract the body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		panic(err)
-	}
-	message := structs.Message{}
-	err = json.Unmarshal(body, &message)
-	if err != nil {
-		panic(err)
-	}
+// With If-Match set to the conversation's ETag, an existing conversation is only updated if no one has
+// changed it since, otherwise it responds 409
+// New conversations are named after the first 40 characters of the first message, then renamed
+// on pool with a title from llmClient if it isn't nil. A message without a conversation_id
+// starts a new conversation, which gets its id from the store (see db.IDGenerator)
+// If a request that starts a conversation has an Idempotency-Key, retries of it with the same key within
+// idempotencyWindow get the conversation the first one created, with Idempotent-Replayed: true
+// A request that starts a conversation can pick its model with ?model_name= (see SetConversationModel)
+// A request that starts a conversation synced from another system can give the id that system knows it by with
+// ?external_id=. If the user already has a conversation with it, that one is returned with Idempotent-Replayed: true
+// and the message isn't added, so syncing the same conversation again doesn't duplicate it
+func UpdateConversation(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig, idempotencyWindow time.Duration, pool *jobs.Pool) http.HandlerFunc {
+	// conversations are named after the request is done, without its context
+	background := store
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		message := structs.Message{}
+		if apiErr := decodeBody(r, &message, "body must be a JSON message"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		version, apiErr := expectedVersion(r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		key := r.Header.Get("Idempotency-Key")
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLen)))
+			return
+		}
+
+		externalId := r.URL.Query().Get("external_id")
+		if externalId != "" && key != "" {
+			writeError(w, apierror.InvalidRequest("external_id and Idempotency-Key can't both be used, the external id already makes the request idempotent"))
+			return
+		}
 
-	// check if the conversation trying to be written to belongs to the user
-	if user, code, reason, ok := auth("", r); ok {
-		userConvos := db.GetUserConversations(user)
+		// check if the conversation trying to be written to belongs to the user
+		user, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
 		var conversation *structs.Conversation
-		for _, c := range userConvos {
-			if c.ConversationId == message.ConversationId {
-				// write message to conversation
-				conversation, err = db.UpdateConversationById(message)
+		existing, err := store.FindConversation(message.ConversationId.String())
+		switch {
+		case err == nil && externalId != "":
+			// a sync that already created the conversation with the id it chose
+			if existing.UserId != user || existing.ExternalId != externalId {
+				writeError(w, apierror.InvalidRequest(fmt.Sprintf("external_id only starts new conversations, conversation %s already exists", message.ConversationId)))
+				return
+			}
+			conversation = existing
+			w.Header().Set("Idempotent-Replayed", "true")
+		case errors.Is(err, db.ErrNotFound):
+			// no convsersation with that ID was found
+			// create a new convo and write message to it
+			model := r.URL.Query().Get("model_name")
+			if apiErr := checkModel(llmCfg, model); apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+			name := llm.FallbackTitle(message.Content)
+			created := true
+			if key != "" {
+				conversation, created, err = store.CreateConversationOnce(user, key, name, message, idempotencyWindow)
+			} else if externalId != "" {
+				conversation, created, err = store.CreateConversationWithExternalId(user, externalId, name, message)
+			} else {
+				conversation, err = store.CreateConversation(user, name, message)
+			}
+			if err != nil {
+				writeError(w, storeError(err, "", "failed to create conversation"))
+				return
+			}
+			if !created {
+				// a retry or a sync, the first request already named it and set its model
+				w.Header().Set("Idempotent-Replayed", "true")
+				break
+			}
+			if model != "" {
+				conversation, err = store.SetConversationModel(user, conversation.ConversationId.String(), model)
 				if err != nil {
-					panic(err)
+					writeError(w, storeError(err, "", "failed to set the model"))
+					return
 				}
-				break
 			}
-		}
-
-		// no convsersation with that ID was found
-		if conversation == nil {
-			// create a new convo and write message to it
-			// TODO: use an LLM to get the Name
-			name := ""
-			conversation, err = db.NewConversation(user, name, message)
+			if llmClient != nil {
+				pool.Submit(nameConversation(background, llmClient, conversation.ConversationId.String(), model, message.Content))
+			}
+		case err != nil:
+			writeError(w, apierror.Internal("failed to retrieve conversation"))
+			return
+		case allowed(r, store, user, existing, structs.PermissionWrite):
+			// write message to conversation
+			conversation, err = store.AppendMessage(message, version)
 			if err != nil {
-				panic(err)
+				writeError(w, storeError(err, "", "failed to update conversation"))
+				return
 			}
+		default:
+			writeError(w, apierror.Forbidden(fmt.Sprintf("%s is not authorized to update conversation %s", user, message.ConversationId)))
+			return
 		}
 
-		if out, err := json.MarshalIndent(conversation, "", "  "); err == nil {
+		if out, err := marshal(r, conversation); err == nil {
 			// return the conversation metadata
 			w.Header().Add("Content-Type", "application/json")
+			w.Header().Set("ETag", etag(conversation.Version))
 			w.Write([]byte(out))
 		} else {
 			panic(err)
 		}
-	} else {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(code)
-		w.Write(reason)
 	}
 }
 
-// Basic auth helper
-func auth(userId string, r *http.Request) (string, int, []byte, bool) {
-	usr, _, ok := r.BasicAuth()
+// longest Idempotency-Key accepted, enough for a UUID or a hash with some room to spare
+const maxIdempotencyKeyLen = 255
+
+// how long to wait for the LLM to come up with a title
+const titleTimeout = 30 * time.Second
+
+// nameConversation is the job that renames the conversation with a title generated from its first message by
+// the model, or llm_config.model_name if it's empty. If the LLM keeps failing it keeps the name it has
+func nameConversation(store db.ConversationStore, llmClient llm.Client, conversationId, model, content string) jobs.Job {
+	return jobs.Job{
+		Name:  "name conversation",
+		Attrs: []any{"conversation_id", conversationId},
+		Run: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(llm.WithModel(ctx, model), titleTimeout)
+			defer cancel()
+
+			title, err := llm.Title(ctx, llmClient, content)
+			if err != nil {
+				return err
+			}
+			return store.WithContext(ctx).RenameConversation(conversationId, title)
+		},
+	}
+}
+
+var errMissingAuth = apierror.Unauthorized("missing Authorization header")
+
+// Auth helper. It returns the caller, or the error to respond with if they aren't authenticated
+// or (when userId is set) aren't userId or a superuser
+func auth(userId string, r *http.Request) (string, *apierror.APIError) {
+	usr, ok := caller(r)
 	if !ok {
-		reason := []byte(`{"reason":"missing Authorization header"}`)
-		return usr, 401, reason, false
-	} else if userId != "" && userId != usr {
-		reason := []byte(fmt.Sprintf(`{"reason":"%s is not authorized to retrieve conversations for user %s"}`, usr, userId))
-		return usr, 403, reason, false
+		return usr, errMissingAuth
+	} else if userId != "" && userId != usr && !isSuperuser(r) {
+		return usr, apierror.Forbidden(fmt.Sprintf("%s is not authorized to retrieve conversations for user %s", usr, userId))
 	}
 
-	return usr, 0, nil, true
+	return usr, nil
 }
 
 // executeGSQL sends a GSQL query to TigerGraph with basic authentication and returns the response
-func executeGSQL(hostname, username, password, query, gsPort string) (string, error) {
-	var requestURL string
-	tgcloud := strings.Contains(hostname, "tgcloud")
+func executeGSQL(ctx context.Context, hostname, username, password, query, gsPort string) (string, error) {
 	// Construct the URL for the GSQL query endpoint
-	if tgcloud {
-		requestURL = fmt.Sprintf("%s:443/gsqlserver/gsql/file", hostname)
-	} else {
-		requestURL = fmt.Sprintf("%s:%s/gsqlserver/gsql/file", hostname, gsPort)
-	}
+	requestURL := tigergraph.BaseURL(hostname, gsPort) + "/gsqlserver/gsql/file"
 	// Prepare the query data
 	data := url.QueryEscape(query) // Encode query using URL encoding
 	reqBody := strings.NewReader(data)
 
 	// Create the HTTP request
-	req, err := http.NewRequest("POST", requestURL, reqBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -185,7 +574,7 @@ func executeGSQL(hostname, username, password, query, gsPort string) (string, er
 	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
 
 	// Execute the request
-	client := &http.Client{}
+	client := tigergraph.HTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
@@ -236,73 +625,79 @@ func parseUserRoles(userInfo string, userName string) []string {
 
 // GetFeedback retrieves feedback data for conversations
 // "Get /get_feedback"
-func GetFeedback(hostname, gsPort string, conversationAccessRoles []string) http.HandlerFunc {
+// conversationAccessRoles is called on every request for the roles that can see every user's feedback, of their
+// tenant if they have one. The caller is the one RequireRoles authenticated, or else their roles are looked up
+// with their basic auth credentials
+func GetFeedback(store db.ConversationStore, hostname, gsPort string, conversationAccessRoles func() []string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		usr, pass, ok := r.BasicAuth()
-		if !ok {
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"reason":"missing Authorization header"}`))
-			return
-		}
+		store := store.WithContext(r.Context())
+		var usr string
+		var userRoles []string
+		if id, ok := authn.FromContext(r.Context()); ok {
+			usr, userRoles = id.UserId, id.Roles
+		} else {
+			var pass string
+			usr, pass, ok = r.BasicAuth()
+			if !ok {
+				writeError(w, errMissingAuth)
+				return
+			}
 
-		// Verify if the user has the required role
-		userInfo, err := executeGSQL(hostname, usr, pass, "SHOW USER", gsPort)
-		if err != nil {
-			reason := []byte(`{"reason":"failed to retrieve feedback data"}`)
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(reason)
-			return
+			// Verify if the user has the required role
+			userInfo, err := executeGSQL(r.Context(), hostname, usr, pass, "SHOW USER", gsPort)
+			if err != nil {
+				writeError(w, apierror.Internal("failed to retrieve feedback data"))
+				return
+			}
+			userRoles = parseUserRoles(userInfo, usr)
 		}
 
 		// Parse and check roles
-		userRoles := parseUserRoles(userInfo, usr)
-		if !hasAdminAccess(userRoles, conversationAccessRoles) {
+		if !hasAdminAccess(userRoles, conversationAccessRoles()) {
 			// Fetch chat history messages for this specific user
-			conversations := db.GetUserConversations(usr)
+			conversations, _, err := store.ListConversations(usr, db.ListOptions{})
+			if err != nil {
+				writeError(w, apierror.Internal("failed to retrieve feedback data"))
+				return
+			}
 
 			var allMessages []structs.Message
 
 			for _, convo := range conversations {
-				messages := db.GetUserConversationById(usr, convo.ConversationId.String())
-				allMessages = append(allMessages, messages...)
+				messages, err := store.GetConversation(usr, convo.ConversationId.String())
+				if err != nil {
+					writeError(w, apierror.Internal("failed to retrieve feedback data"))
+					return
+				}
+				// the context messages GraphRAG added for the LLM aren't the user's
+				allMessages = append(allMessages, db.HideContext(messages)...)
 			}
 			// Marshal and write the response
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			response, err := json.Marshal(allMessages)
+			response, err := marshal(r, allMessages)
 			if err != nil {
-				reason := []byte(`{"reason":"failed to marshal messages"}`)
-				w.Header().Add("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(reason)
+				writeError(w, apierror.Internal("failed to marshal messages"))
 				return
 			}
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
 			w.Write(response)
 			return
 		}
 
 		// If the user has admin access, fetch all messages
-		messages, err := db.GetAllMessages()
+		messages, err := store.GetAllMessages()
 		if err != nil {
-			reason := []byte(`{"reason":"failed to retrieve feedback data"}`)
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(reason)
+			writeError(w, apierror.Internal("failed to retrieve feedback data"))
 			return
 		}
 
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		response, err := json.Marshal(messages)
+		response, err := marshal(r, messages)
 		if err != nil {
-			reason := []byte(`{"reason":"failed to marshal messages"}`)
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(reason)
+			writeError(w, apierror.Internal("failed to marshal messages"))
 			return
 		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		w.Write(response)
 	}
 }
@@ -2,19 +2,29 @@ package routes
 
 import (
 	"bytes"
+	"chat-history/apierror"
 	"chat-history/config"
 	"chat-history/db"
+	"chat-history/jobs"
+	"chat-history/llm"
+	"chat-history/middleware"
 	"chat-history/structs"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -28,9 +38,9 @@ const (
 // GetUserConversations
 func TestGetUserConversations(t *testing.T) {
 	// setup
-	setupDB(t, false)
+	store := setupDB(t, false)
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /user/{userId}", GetUserConversations)
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
@@ -51,9 +61,9 @@ func TestGetUserConversations(t *testing.T) {
 
 func TestGetUserConversations_401(t *testing.T) {
 	// setup
-	setupDB(t, false)
+	store := setupDB(t, false)
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /user/{userId}", GetUserConversations)
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
@@ -73,9 +83,9 @@ func TestGetUserConversations_401(t *testing.T) {
 
 func TestGetUserConversations_403(t *testing.T) {
 	// setup
-	setupDB(t, false)
+	store := setupDB(t, false)
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /user/{userId}", GetUserConversations)
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
@@ -94,12 +104,87 @@ func TestGetUserConversations_403(t *testing.T) {
 	}
 }
 
+func TestGetUserConversations_Paginated(t *testing.T) {
+	// setup
+	store := setupDB(t, false)
+	for i := range 3 {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: fmt.Sprintf("message %d", i), Role: structs.UserRole}
+		if _, err := store.CreateConversation(USER, fmt.Sprintf("convo %d", i), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+
+	cursor := ""
+	total := 0
+	for _, size := range []int{2, 1} {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s?limit=2&cursor=%s", USER, cursor), nil)
+		resp := httptest.NewRecorder()
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		mux.ServeHTTP(resp, req)
+
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+		}
+		var convos []structs.Conversation
+		if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil {
+			t.Fatal(err)
+		}
+		if len(convos) != size {
+			t.Fatalf("page should have %d conversations. It has: %d", size, len(convos))
+		}
+		total += len(convos)
+		cursor = resp.Header().Get("X-Next-Cursor")
+	}
+	if cursor != "" || total != 3 {
+		t.Fatalf("expected 3 conversations and an empty final cursor. Got %d, %q", total, cursor)
+	}
+
+	// bad cursor
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s?limit=2&cursor=bogus", USER), nil)
+	resp := httptest.NewRecorder()
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	mux.ServeHTTP(resp, req)
+	if resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+}
+
+func TestGetUserConversations_Language(t *testing.T) {
+	store := setupDB(t, false)
+	for _, content := range []string{"How many accounts are flagged as fraud?", "Combien de comptes sont signalés comme frauduleux ?"} {
+		msg := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: content, Role: structs.UserRole}
+		if _, err := store.CreateConversation(USER, content, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+
+	// the code is case-insensitive
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s?language=FR", USER), nil)
+	resp := httptest.NewRecorder()
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	mux.ServeHTTP(resp, req)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	var convos []structs.Conversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil {
+		t.Fatal(err)
+	}
+	if len(convos) != 1 || convos[0].Language != "fr" {
+		t.Fatalf("only the conversation in French should be listed. Got %+v", convos)
+	}
+}
+
 // GetConversation
 func TestGetConversation(t *testing.T) {
 	// setup
-	setupDB(t, true)
+	store := setupDB(t, true)
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation)
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), nil)
@@ -120,11 +205,188 @@ func TestGetConversation(t *testing.T) {
 	}
 }
 
+func TestGetConversation_MergeLong(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	// a branch that was left after its first reply, and the latest one, over 200 messages long
+	first := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "turn 0", Role: structs.UserRole}
+	if _, err := store.CreateConversation(USER, "long", first); err != nil {
+		t.Fatal(err)
+	}
+	stale := structs.Message{ConversationId: first.ConversationId, MessageId: uuid.New(), ParentId: &first.MessageId, Content: "stale", Role: structs.AssistantRole}
+	if _, err := store.AppendMessage(stale, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	branch := []uuid.UUID{first.MessageId}
+	for i := 1; i < 250; i++ {
+		m := structs.Message{ConversationId: first.ConversationId, MessageId: uuid.New(), ParentId: &branch[len(branch)-1], Content: fmt.Sprintf("turn %d", i), Role: structs.UserRole}
+		if _, err := store.AppendMessage(m, db.AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, m.MessageId)
+	}
+	get := func(query string) ([]structs.Message, *httptest.ResponseRecorder) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s%s", first.ConversationId, query), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var messages []structs.Message
+		if err := json.Unmarshal(resp.Body.Bytes(), &messages); resp.Code != 200 || err != nil {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		return messages, resp
+	}
+
+	// without paging every message is returned
+	if all, resp := get(""); len(all) != len(branch)+1 || resp.Header().Get("X-Total-Count") != strconv.Itoa(len(branch)+1) {
+		t.Fatalf("every message should be returned without limit and offset. Got %d, X-Total-Count %q", len(all), resp.Header().Get("X-Total-Count"))
+	}
+	// the latest branch, up to its newest message
+	merged, resp := get("?merge=true")
+	if len(merged) != len(branch) || merged[len(merged)-1].MessageId != branch[len(branch)-1] || resp.Header().Get("X-Total-Count") != strconv.Itoa(len(branch)) {
+		t.Fatalf("the whole latest branch should be merged. Got %d messages, X-Total-Count %q", len(merged), resp.Header().Get("X-Total-Count"))
+	}
+	// its pages are of the merged branch
+	page, resp := get("?merge=true&limit=20&offset=240")
+	if len(page) != 10 || page[0].MessageId != branch[240] || page[9].MessageId != branch[249] || resp.Header().Get("X-Total-Count") != strconv.Itoa(len(branch)) {
+		t.Fatalf("the page should be the end of the merged branch. Got %d messages, X-Total-Count %q", len(page), resp.Header().Get("X-Total-Count"))
+	}
+	if page, _ := get("?merge=true&offset=300"); len(page) != 0 {
+		t.Fatalf("a page after the end of the branch should be empty. Got %d messages", len(page))
+	}
+}
+
+func TestGetConversation_Pages(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	for i := range 4 {
+		reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: fmt.Sprintf("reply %d", i), Role: structs.AssistantRole}
+		if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) ([]structs.Message, *httptest.ResponseRecorder) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s%s", CONVO_ID, query), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var messages []structs.Message
+		if resp.Code == 200 {
+			if err := json.Unmarshal(resp.Body.Bytes(), &messages); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return messages, resp
+	}
+
+	// forward a page at a time, the total is in the header
+	var paged []uuid.UUID
+	for offset := 0; offset < len(all); offset += 3 {
+		page, resp := get(fmt.Sprintf("?limit=3&offset=%d", offset))
+		if resp.Code != 200 || resp.Header().Get("X-Total-Count") != strconv.Itoa(len(all)) {
+			t.Fatalf("expected a page of %d messages. Got %d with X-Total-Count %q: %s", len(all), resp.Code, resp.Header().Get("X-Total-Count"), resp.Body)
+		}
+		if len(page) > 3 {
+			t.Fatalf("a page should have at most 3 messages, got %d", len(page))
+		}
+		for _, m := range page {
+			paged = append(paged, m.MessageId)
+		}
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("every message should be in a page once, got %v", paged)
+	}
+	for i, m := range all {
+		if paged[i] != m.MessageId {
+			t.Fatalf("the pages should be in order. Expected %v at %d, got %v", m.MessageId, i, paged[i])
+		}
+	}
+
+	// only the assistant's replies
+	page, resp := get("?role=assistant&limit=2")
+	if resp.Code != 200 || resp.Header().Get("X-Total-Count") != "4" || len(page) != 2 {
+		t.Fatalf("expected 2 of the 4 replies. Got %d with X-Total-Count %q: %+v", resp.Code, resp.Header().Get("X-Total-Count"), page)
+	}
+	for _, m := range page {
+		if m.Role != structs.AssistantRole {
+			t.Fatalf("only assistant messages should be returned, got: %+v", m)
+		}
+	}
+
+	for _, query := range []string{"?limit=-1", "?limit=ten", "?offset=-1", "?role="} {
+		if _, resp := get(query); resp.Code != 400 {
+			t.Fatalf("%s should be a 400. It is: %d", query, resp.Code)
+		}
+	}
+}
+
+func TestGetConversation_IncludeContext(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	graphContext := structs.GraphContext{
+		Query:    "answer_question",
+		Vertices: []structs.GraphVertex{{Type: "Entity", Id: "TigerGraph"}},
+		Edges:    []structs.GraphEdge{{Type: "RELATES_TO", FromType: "Entity", FromId: "TigerGraph", ToType: "Entity", ToId: "GSQL"}},
+	}
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "GSQL is TigerGraph's query language", Role: structs.SystemRole, GraphContext: &graphContext}
+	body, _ := json.Marshal(reply)
+	if resp := do(http.MethodPost, "/conversation", body); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	for _, tt := range []struct {
+		query       string
+		withContext bool
+	}{
+		{"", false},
+		{"?include_context=false", false},
+		{"?include_context=true", true},
+	} {
+		resp := do(http.MethodGet, fmt.Sprintf("/conversation/%s%s", CONVO_ID, tt.query), nil)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		if !tt.withContext && strings.Contains(resp.Body.String(), "graph_context") {
+			t.Fatalf("%q shouldn't return the graph context: %s", tt.query, resp.Body)
+		}
+		var messages []structs.Message
+		if err := json.Unmarshal(resp.Body.Bytes(), &messages); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range messages {
+			if tt.withContext && m.MessageId == reply.MessageId && !reflect.DeepEqual(m.GraphContext, &graphContext) {
+				t.Fatalf("the reply should have the graph context it was appended with, got %+v", m.GraphContext)
+			}
+			if m.MessageId != reply.MessageId && m.GraphContext != nil {
+				t.Fatalf("messages appended without a graph context shouldn't have one, %s has %+v", m.MessageId, m.GraphContext)
+			}
+		}
+	}
+}
+
 func TestGetConversation_401(t *testing.T) {
 	// setup
-	setupDB(t, false)
+	store := setupDB(t, false)
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation)
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), nil)
@@ -176,7 +438,7 @@ func TestMergeMessageHistory(t *testing.T) {
 func TestGetConversation_SplitMessageHistory(t *testing.T) {
 	// setup
 	splitConvo := createSplitConvo()
-	setupDB(t, false)
+	store := setupDB(t, false)
 	db.NewConversation(USER, "split convo", splitConvo[0])
 	for _, m := range splitConvo[1:] {
 		_, err := db.UpdateConversationById(m)
@@ -185,7 +447,7 @@ func TestGetConversation_SplitMessageHistory(t *testing.T) {
 		}
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation)
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s", CONVO_ID), nil)
@@ -209,7 +471,7 @@ func TestGetConversation_SplitMessageHistory(t *testing.T) {
 func TestGetConversation_SplitMessageHistory_Merged(t *testing.T) {
 	// setup
 	splitConvo := createSplitConvo()
-	setupDB(t, false)
+	store := setupDB(t, false)
 	db.NewConversation(USER, "split convo", splitConvo[0])
 	for _, m := range splitConvo[1:] {
 		_, err := db.UpdateConversationById(m)
@@ -219,7 +481,7 @@ func TestGetConversation_SplitMessageHistory_Merged(t *testing.T) {
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation)
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
 
 	// setup request
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversation/%s?merge=true", CONVO_ID), nil)
@@ -255,9 +517,9 @@ func TestGetConversation_SplitMessageHistory_Merged(t *testing.T) {
 // UpdateConversation
 func TestUpdateConversation_FirstMessage(t *testing.T) {
 	// setup
-	setupDB(t, false)
+	store := setupDB(t, false)
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /conversation/", UpdateConversation)
+	mux.HandleFunc("POST /conversation/", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
 
 	// setup request
 	convoId := uuid.New()
@@ -304,11 +566,270 @@ func TestUpdateConversation_FirstMessage(t *testing.T) {
 	}
 }
 
+func TestUpdateConversation_NoConversationId(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+
+	// two new conversations, neither with an id
+	var ids []uuid.UUID
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(fmt.Sprintf(`{"message_id":%q,"content":"Hello","role":"user"}`, uuid.New())))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var c structs.Conversation
+		json.Unmarshal(resp.Body.Bytes(), &c)
+		if c.ConversationId.Version() != 7 {
+			t.Fatalf("the conversation should get a generated id: %s", resp.Body)
+		}
+		if messages := db.GetUserConversationById(USER, c.ConversationId.String()); len(messages) != 1 {
+			t.Fatalf("the message should be in the new conversation. Got %+v", messages)
+		}
+		ids = append(ids, c.ConversationId)
+	}
+	if ids[0] == ids[1] {
+		t.Fatalf("each conversation should get its own id. Got %s twice", ids[0])
+	}
+}
+
+func TestUpdateConversation_IdempotencyKey(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	post := func(key string) (*httptest.ResponseRecorder, structs.Conversation) {
+		body := fmt.Sprintf(`{"message_id":%q,"content":"Hello","role":"user"}`, uuid.New())
+		req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		req.Header.Set("Idempotency-Key", key)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var c structs.Conversation
+		json.Unmarshal(resp.Body.Bytes(), &c)
+		return resp, c
+	}
+
+	resp, first := post("retry-1")
+	if resp.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("the first request isn't a replay")
+	}
+
+	// the retry gets the conversation the first request created
+	resp, again := post("retry-1")
+	if again.ConversationId != first.ConversationId || resp.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("a retry should return conversation %s as a replay. Got %s: %s", first.ConversationId, resp.Header(), resp.Body)
+	}
+	if messages := db.GetUserConversationById(USER, first.ConversationId.String()); len(messages) != 1 {
+		t.Fatalf("a retry shouldn't add its message. Got %+v", messages)
+	}
+
+	// another key is another conversation
+	if _, other := post("retry-2"); other.ConversationId == first.ConversationId {
+		t.Fatal("a different key should create a new conversation")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(`{"content":"Hello","role":"user"}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	req.Header.Set("Idempotency-Key", strings.Repeat("k", 256))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Response code should be 400 for a key that's too long. It is: %v", rec.Code)
+	}
+}
+
+func TestUpdateConversation_ExternalId(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	post := func(query, body string) (*httptest.ResponseRecorder, structs.Conversation) {
+		req := httptest.NewRequest(http.MethodPost, "/conversation?"+query, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		var c structs.Conversation
+		json.Unmarshal(resp.Body.Bytes(), &c)
+		return resp, c
+	}
+	message := func() string {
+		return fmt.Sprintf(`{"message_id":%q,"content":"Hello","role":"user"}`, uuid.New())
+	}
+
+	resp, first := post("external_id=crm-1", message())
+	if resp.Code != 200 || first.ExternalId != "crm-1" || resp.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("the first sync should create the conversation. Got %v: %s", resp.Code, resp.Body)
+	}
+	// syncing it again returns it, without adding the message
+	resp, again := post("external_id=crm-1", message())
+	if resp.Code != 200 || again.ConversationId != first.ConversationId || resp.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("a re-sync should return conversation %s as a replay. Got %v: %s", first.ConversationId, resp.Code, resp.Body)
+	}
+	// and so does a sync that sends the conversation's id
+	body := fmt.Sprintf(`{"conversation_id":%q,"message_id":%q,"content":"Hello","role":"user"}`, first.ConversationId, uuid.New())
+	if resp, again := post("external_id=crm-1", body); resp.Code != 200 || again.ConversationId != first.ConversationId || resp.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("a re-sync with the conversation's id should return it as a replay. Got %v: %s", resp.Code, resp.Body)
+	}
+	if messages := db.GetUserConversationById(USER, first.ConversationId.String()); len(messages) != 1 {
+		t.Fatalf("a re-sync shouldn't add its message. Got %+v", messages)
+	}
+	if _, other := post("external_id=crm-2", message()); other.ConversationId == first.ConversationId {
+		t.Fatal("a different external id should create a new conversation")
+	}
+
+	// an existing conversation can't be given one
+	if resp, _ := post("external_id=crm-3", body); resp.Code != http.StatusBadRequest {
+		t.Fatalf("Response code should be 400 for an external id of an existing conversation. It is: %v", resp.Code)
+	}
+	if resp, _ := post("external_id="+strings.Repeat("x", 256), message()); resp.Code != http.StatusBadRequest {
+		t.Fatalf("Response code should be 400 for an external id that's too long. It is: %v", resp.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/conversation?external_id=crm-4", strings.NewReader(message()))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	req.Header.Set("Idempotency-Key", "retry-1")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Response code should be 400 with both an external id and an Idempotency-Key. It is: %v", rec.Code)
+	}
+}
+
+func TestUpdateConversation_InvalidBody(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	handler := middleware.ChainMiddleware(mux, middleware.MaxBodyBytes(1024))
+	post := func(body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	message := func(convoId, role, content string) string {
+		return fmt.Sprintf(`{"conversation_id":%q,"message_id":%q,"role":%q,"content":%q}`, convoId, uuid.New(), role, content)
+	}
+
+	huge := message(uuid.NewString(), "user", strings.Repeat("a", 2048))
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{"oversized body", huge, false, http.StatusRequestEntityTooLarge},
+		{"oversized body without a length", huge, true, http.StatusRequestEntityTooLarge},
+		{"unknown role in a new conversation", message(uuid.NewString(), "bot", "Hi"), false, http.StatusBadRequest},
+		{"unknown role in a new message", message(CONVO_ID, "bot", "Hi"), false, http.StatusBadRequest},
+		{"empty content", message(CONVO_ID, "user", " "), false, http.StatusBadRequest},
+		{"assistant reply", message(CONVO_ID, "assistant", "Hello"), false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := post(tt.body, tt.chunked); resp.Code != tt.status {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.status, resp.Code, resp.Body)
+			}
+		})
+	}
+
+	// feedback on an existing message doesn't need the role or content
+	messages := db.GetUserConversationById(USER, CONVO_ID)
+	feedback := fmt.Sprintf(`{"conversation_id":%q,"message_id":%q,"feedback":1}`, CONVO_ID, messages[0].MessageId)
+	if resp := post(feedback, false); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+}
+
+// titleClient is an llm.Client that always replies with the title
+type titleClient string
+
+func (c titleClient) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return string(c), nil
+}
+
+func (c titleClient) ChatStream(ctx context.Context, messages []llm.Message, onChunk func(string) error) (string, error) {
+	return string(c), onChunk(string(c))
+}
+
+func TestUpdateConversation_GeneratesTitle(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	pool := jobs.New(1)
+	mux.HandleFunc("POST /conversation/", UpdateConversation(store, titleClient("Greeting the world"), config.LLMConfig{}, time.Hour, pool))
+
+	convoId := uuid.New()
+	msg := structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole}
+	bmsg, _ := json.Marshal(msg)
+	req := httptest.NewRequest(http.MethodPost, "/conversation/", bytes.NewReader(bmsg))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	var c structs.Conversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	// named after the message until the title is generated
+	if c.Name != "Hello, world" && c.Name != "Greeting the world" {
+		t.Fatalf("name should start as the message. It's: %s", c.Name)
+	}
+
+	// the title is generated on the pool, closing it waits for it
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	convo, err := store.FindConversation(convoId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convo.Name != "Greeting the world" {
+		t.Fatalf("name should be `Greeting the world`. It's: %s", convo.Name)
+	}
+}
+
+func TestUpdateConversation_MaxConversations(t *testing.T) {
+	tmp := t.TempDir()
+	store := db.InitDB(fmt.Sprintf("%s/test.db", tmp), fmt.Sprintf("%s/test.log", tmp), db.MaxConversations(1))
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}}))
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation", withRoles(UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil)))
+
+	start := func(user string) *httptest.ResponseRecorder {
+		msg, _ := json.Marshal(structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole})
+		req := httptest.NewRequest(http.MethodPost, "/conversation", bytes.NewReader(msg))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	if resp := start(USER); resp.Code != http.StatusOK {
+		t.Fatalf("Response code should be 200 at the limit. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp := start(USER)
+	if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), apierror.CodeTooManyConversations) {
+		t.Fatalf("Response code should be 409 one over the limit. It is: %v: %s", resp.Code, resp.Body)
+	}
+	// superusers are exempt
+	for range 2 {
+		if resp := start("admin"); resp.Code != http.StatusOK {
+			t.Fatalf("Response code should be 200 for a superuser. It is: %v: %s", resp.Code, resp.Body)
+		}
+	}
+}
+
 func TestUpdateConversation_nthMessage(t *testing.T) {
 	// setup
-	setupDB(t, true)
+	store := setupDB(t, true)
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /conversation/", UpdateConversation)
+	mux.HandleFunc("POST /conversation/", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
 
 	// setup request
 	// get last message in convo
@@ -410,7 +931,7 @@ func TestExecuteGSQL(t *testing.T) {
 	}
 	query := "SHOW USER"
 
-	response, err := executeGSQL(cfg.TgDbConfig.Hostname, cfg.TgDbConfig.Username, cfg.TgDbConfig.Password, query, cfg.TgDbConfig.GsPort)
+	response, err := executeGSQL(context.Background(), cfg.TgDbConfig.Hostname, cfg.TgDbConfig.Username, cfg.TgDbConfig.Password, query, cfg.TgDbConfig.GsPort)
 	if err != nil {
 		t.Fatalf("Failed to execute GSQL query: %v", err)
 	}
@@ -491,7 +1012,7 @@ func TestGetFeedback(t *testing.T) {
 
 	os.Setenv("CONFIG_FILES", "../server_config.json")
 
-	setupDB(t, true)
+	store := setupDB(t, true)
 
 	configPath := os.Getenv("CONFIG_FILES")
 	cfg, err := config.LoadConfig(map[string]string{
@@ -510,7 +1031,7 @@ func TestGetFeedback(t *testing.T) {
 
 		// Record the response
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(GetFeedback(cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort, cfg.ChatDbConfig.ConversationAccessRoles))
+		handler := http.HandlerFunc(GetFeedback(store, cfg.TgDbConfig.Hostname, cfg.TgDbConfig.GsPort, func() []string { return cfg.ChatDbConfig.ConversationAccessRoles }))
 
 		// Serve the request
 		handler.ServeHTTP(rr, req)
@@ -553,11 +1074,331 @@ func TestGetFeedback(t *testing.T) {
 	testFeedback(t, "nonexistentuser", "password", http.StatusUnauthorized, 0, "")
 }
 
+func TestGetUserConversations_FakeStore(t *testing.T) {
+	// setup
+	store := fakeStore{convos: map[string][]structs.Conversation{
+		USER: {{UserId: USER, ConversationId: uuid.MustParse(CONVO_ID), Name: "fake"}},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+
+	// setup request
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
+	resp := httptest.NewRecorder()
+	auth := basicAuthSetup(USER, PASS)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", auth))
+
+	// call
+	mux.ServeHTTP(resp, req)
+
+	// assert results
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	var convos []structs.Conversation
+	if err := json.Unmarshal(resp.Body.Bytes(), &convos); err != nil {
+		t.Fatal(err)
+	}
+	if len(convos) != 1 || convos[0].Name != "fake" {
+		t.Fatalf("conversations should come from the fake store: %v", convos)
+	}
+}
+
+func TestGetUserConversations_Timeout(t *testing.T) {
+	// setup
+	store := slowStore{queried: make(chan error, 1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+	handler := middleware.Timeout(20 * time.Millisecond)(mux)
+
+	// setup request
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s", USER), nil)
+	resp := httptest.NewRecorder()
+	auth := basicAuthSetup(USER, PASS)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", auth))
+
+	// call
+	handler.ServeHTTP(resp, req)
+
+	// assert results
+	var apiErr apierror.APIError
+	json.Unmarshal(resp.Body.Bytes(), &apiErr)
+	if resp.Code != http.StatusGatewayTimeout || apiErr.Code != apierror.CodeTimeout {
+		t.Fatalf("Response code should be 504. It is: %v: %s", resp.Code, resp.Body)
+	}
+	select {
+	case err := <-store.queried:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("the query's context should be past its deadline. It's: %v", err)
+		}
+	default:
+		t.Fatal("the store should have been queried with the request's context")
+	}
+}
+
+func TestDeleteAndRestoreConversation(t *testing.T) {
+	// setup
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+	mux.HandleFunc("DELETE /conversation/{conversationId}", DeleteConversation(store, testAuditLog(t)))
+	mux.HandleFunc("POST /conversation/{conversationId}/restore", RestoreConversation(store, time.Hour))
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	count := func() int {
+		var convos []structs.Conversation
+		json.Unmarshal(do(http.MethodGet, fmt.Sprintf("/user/%s", USER), USER).Body.Bytes(), &convos)
+		return len(convos)
+	}
+
+	if resp := do(http.MethodDelete, fmt.Sprintf("/conversation/%s", CONVO_ID), "Miss_Take"); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodDelete, fmt.Sprintf("/conversation/%s", CONVO_ID), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	if c := count(); c != 0 {
+		t.Fatalf("deleted conversation should not be listed. Found %d", c)
+	}
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/restore", CONVO_ID), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	if c := count(); c != 1 {
+		t.Fatalf("restored conversation should be listed. Found %d", c)
+	}
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/restore", CONVO_ID), USER); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+}
+
+func TestArchiveAndUnarchiveConversation(t *testing.T) {
+	// setup
+	tmp := t.TempDir()
+	os.Setenv("DEV", "true") // populate db
+	store := db.InitDB(fmt.Sprintf("%s/%s", tmp, "test.db"), fmt.Sprintf("%s/test.log", tmp), db.ArchivePath(fmt.Sprintf("%s/%s", tmp, "archive.db")))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+	mux.HandleFunc("POST /conversation/{conversationId}/archive", ArchiveConversation(store))
+	mux.HandleFunc("POST /conversation/{conversationId}/unarchive", UnarchiveConversation(store))
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	list := func(query string) []structs.Conversation {
+		var convos []structs.Conversation
+		json.Unmarshal(do(http.MethodGet, fmt.Sprintf("/user/%s%s", USER, query), USER).Body.Bytes(), &convos)
+		return convos
+	}
+	before := len(list(""))
+
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/archive", CONVO_ID), "Miss_Take"); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/archive", CONVO_ID), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	if c := len(list("")); c != before-1 {
+		t.Fatalf("archived conversation should not be listed. Found %d", c)
+	}
+	archived := 0
+	for _, c := range list("?archived=true") {
+		if c.Archived {
+			archived++
+			if c.ConversationId.String() != CONVO_ID {
+				t.Fatalf("only %s should be archived. Got: %s", CONVO_ID, c.ConversationId)
+			}
+		}
+	}
+	if archived != 1 {
+		t.Fatalf("archived conversation should be listed with archived=true. Found %d", archived)
+	}
+
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/unarchive", CONVO_ID), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	if c := len(list("")); c != before {
+		t.Fatalf("unarchived conversation should be listed. Found %d", c)
+	}
+	if resp := do(http.MethodPost, fmt.Sprintf("/conversation/%s/unarchive", CONVO_ID), USER); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	// setup
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", SearchMessages(store))
+
+	search := func(user, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/search?q="+url.QueryEscape(query), nil)
+		if user != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := search(USER, "how may I help")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	var results []structs.SearchResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ConversationId.String() != CONVO_ID {
+		t.Fatalf("expected 1 result in %s: %v", CONVO_ID, results)
+	}
+
+	// Miss_Take can't see sam_pull's messages
+	resp = search("Miss_Take", "how may I help")
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no results: %v", results)
+	}
+
+	if resp := search("", "help"); resp.Code != 401 {
+		t.Fatalf("Response code should be 401. It is: %v", resp.Code)
+	}
+	if resp := search(USER, ""); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search/all", Search(store, db.DefaultSearchWeights))
+	if err := store.AddTag(USER, CONVO_ID, "help desk"); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(user, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/search/all?q="+url.QueryEscape(query), nil)
+		if user != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := search(USER, "help")
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+	}
+	var results []structs.CombinedSearchResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) < 2 || results[0].Type != structs.ResultTag || results[0].ConversationId.String() != CONVO_ID {
+		t.Fatalf("expected the tag of %s first, then its messages: %v", CONVO_ID, results)
+	}
+	for _, r := range results[1:] {
+		if r.Type != structs.ResultMessage || r.MessageId == nil {
+			t.Fatalf("expected a message result: %+v", r)
+		}
+	}
+
+	// Miss_Take can't see sam_pull's conversations
+	resp = search("Miss_Take", "help")
+	if err := json.Unmarshal(resp.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no results: %v", results)
+	}
+
+	if resp := search("", "help"); resp.Code != 401 {
+		t.Fatalf("Response code should be 401. It is: %v", resp.Code)
+	}
+	if resp := search(USER, " "); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+}
+
 // helpers
+
+// fakeStore is an in-memory db.ConversationStore. Methods that aren't overridden panic.
+type fakeStore struct {
+	db.ConversationStore
+	convos   map[string][]structs.Conversation
+	messages map[string][]structs.Message // by conversation id
+	pingErr  error
+}
+
+func (f fakeStore) FindConversation(conversationId string) (*structs.Conversation, error) {
+	for _, convos := range f.convos {
+		for _, c := range convos {
+			if c.ConversationId.String() == conversationId {
+				return &c, nil
+			}
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (f fakeStore) GetConversation(userId, conversationId string) ([]structs.Message, error) {
+	return f.messages[conversationId], nil
+}
+
+func (f fakeStore) ListAttachments(userId, conversationId, messageId string) ([]structs.Attachment, error) {
+	return nil, nil
+}
+
+func (f fakeStore) WithContext(context.Context) db.ConversationStore {
+	return f
+}
+
+func (f fakeStore) WithoutConversationLimit() db.ConversationStore {
+	return f
+}
+
+func (f fakeStore) Ping() error {
+	return f.pingErr
+}
+
+func (f fakeStore) ListConversations(userId string, opts db.ListOptions) ([]structs.Conversation, string, error) {
+	return f.convos[userId], "", nil
+}
+
+// slowStore is a fakeStore whose ListConversations takes until its context is done, like a query stuck
+// behind a lock. queried gets the context's error
+type slowStore struct {
+	fakeStore
+	ctx     context.Context
+	queried chan error
+}
+
+func (s slowStore) WithContext(ctx context.Context) db.ConversationStore {
+	s.ctx = ctx
+	return s
+}
+
+func (s slowStore) ListConversations(userId string, opts db.ListOptions) ([]structs.Conversation, string, error) {
+	<-s.ctx.Done()
+	s.queried <- s.ctx.Err()
+	return nil, "", s.ctx.Err()
+}
+
 func basicAuthSetup(user, pass string) string {
 	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, pass)))
 }
-func setupDB(t *testing.T, populateDB bool) {
+func setupDB(t *testing.T, populateDB bool) db.ConversationStore {
 	tmp := t.TempDir()
 	pth := fmt.Sprintf("%s/%s", tmp, "test.db")
 	if populateDB {
@@ -566,7 +1407,7 @@ func setupDB(t *testing.T, populateDB bool) {
 		os.Setenv("DEV", "")
 	}
 	log := fmt.Sprintf("%s/test.log", tmp)
-	db.InitDB(pth, log)
+	return db.InitDB(pth, log)
 }
 
 func createSplitConvo() []structs.Message {
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/tigergraph"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Get the vertex and edge types of the graph, with their attributes, for the frontend
+// "GET /graph/schema"
+// It's cached for a short while, so changes to the schema can take that long to show up
+func GraphSchema(schema func() (*tigergraph.Schema, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := schema()
+		if errors.Is(err, tigergraph.ErrNoGraph) {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "no graph is configured"))
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get the graph schema", "err", err)
+			writeError(w, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "failed to get the graph schema from TigerGraph"))
+			return
+		}
+		if out, err := marshal(r, s); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/tigergraph"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGraphSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema func() (*tigergraph.Schema, error)
+		status int
+		code   string
+	}{
+		{"schema", func() (*tigergraph.Schema, error) {
+			return &tigergraph.Schema{Graph: "g", VertexTypes: []tigergraph.VertexType{{Name: "Person"}}}, nil
+		}, 200, ""},
+		{"no graph", func() (*tigergraph.Schema, error) { return nil, tigergraph.ErrNoGraph }, 501, apierror.CodeNotImplemented},
+		{"TigerGraph is down", func() (*tigergraph.Schema, error) { return nil, errors.New("connection refused") }, 503, apierror.CodeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			GraphSchema(tt.schema)(resp, httptest.NewRequest(http.MethodGet, "/graph/schema", nil))
+			if resp.Code != tt.status {
+				t.Fatalf("Response code should be %d. It is: %v: %s", tt.status, resp.Code, resp.Body)
+			}
+			if tt.code != "" {
+				var body apierror.APIError
+				json.Unmarshal(resp.Body.Bytes(), &body)
+				if body.Code != tt.code {
+					t.Fatalf("the error code should be %s. It's: %s", tt.code, resp.Body)
+				}
+				return
+			}
+			var schema tigergraph.Schema
+			if err := json.Unmarshal(resp.Body.Bytes(), &schema); err != nil || schema.Graph != "g" || schema.VertexTypes[0].Name != "Person" {
+				t.Fatalf("the schema should be returned. Got %s: %v", resp.Body, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/audit"
+	"chat-history/db"
+	"chat-history/structs"
+	"chat-history/tenant"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how long share links last unless the request says otherwise, and the longest they can last
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 90 * 24 * time.Hour
+)
+
+type shareRequest struct {
+	ExpiresInHours int `json:"expires_in_hours"`
+}
+
+// shareLink is a share link with the path that reads it, under the base path
+type shareLink struct {
+	structs.ShareLink
+	Path string `json:"path"`
+}
+
+func withPath(r *http.Request, share structs.ShareLink) shareLink {
+	return shareLink{ShareLink: share, Path: link(r, "/shared/"+share.Token)}
+}
+
+// sharedConversation is what a share link gives access to
+type sharedConversation struct {
+	Conversation *structs.Conversation `json:"conversation"`
+	Messages     []structs.Message     `json:"messages"`
+}
+
+// Create a link that gives anyone with its token read access to the conversation
+// "POST /conversation/{conversationId}/shares" with an optional {"expires_in_hours": int}
+// Links last 7 days by default, and at most 90. Every link created is written to the audit log
+func CreateShareLink(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+
+		var share shareRequest
+		if apiErr := decodeOptionalBody(r, &share, "body must be a JSON object"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		ttl := defaultShareTTL
+		if share.ExpiresInHours != 0 {
+			ttl = time.Duration(share.ExpiresInHours) * time.Hour
+		}
+		if ttl <= 0 || ttl > maxShareTTL {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("expires_in_hours must be between 1 and %d", int(maxShareTTL.Hours()))))
+			return
+		}
+
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "create_share_link", UserId: userId, ConversationId: r.PathValue("conversationId")}) {
+			return
+		}
+		link, err := store.CreateShareLink(userId, r.PathValue("conversationId"), ttl)
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to create share link"))
+			return
+		}
+		if out, err := marshal(r, withPath(r, *link)); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// List the share links of the conversation, newest first, expired and revoked ones included
+// "GET /conversation/{conversationId}/shares"
+func ListShareLinks(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+
+		links, err := store.ListShareLinks(userId, r.PathValue("conversationId"))
+		if err != nil {
+			writeError(w, storeError(err, conversationNotFound(r), "failed to retrieve share links"))
+			return
+		}
+		withPaths := make([]shareLink, len(links))
+		for i, l := range links {
+			withPaths[i] = withPath(r, l)
+		}
+		if out, err := marshal(r, withPaths); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Revoke a share link, its token stops giving access
+// "DELETE /conversation/{conversationId}/shares/{shareId}"
+// Every revocation is written to the audit log
+func RevokeShareLink(store db.ConversationStore, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, ok := conversationOwner(w, r, store, ownerOnly)
+		if !ok {
+			return
+		}
+
+		shareId := r.PathValue("shareId")
+		if !recordAccess(w, r, auditLog, audit.Entry{Action: "revoke_share_link", UserId: userId, ConversationId: r.PathValue("conversationId"), ShareId: shareId}) {
+			return
+		}
+		if err := store.RevokeShareLink(userId, r.PathValue("conversationId"), shareId); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("share link %s not found", shareId), "failed to revoke share link"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Read a shared conversation. The token is all the access needed, there are no role checks
+// "GET /shared/{token}?merge=bool"
+func GetSharedConversation(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the token says which conversation, of whichever tenant
+		store := store.WithContext(tenant.AnyTenant(r.Context()))
+		convo, messages, err := store.GetSharedConversation(r.PathValue("token"))
+		if err != nil {
+			// expired and revoked links are 410 Gone
+			writeError(w, storeError(err, "share link not found", "failed to retrieve conversation"))
+			return
+		}
+		// the context messages are for the LLM, not who the conversation is shared with
+		messages = db.HideContext(messages)
+		if strings.ToLower(r.URL.Query().Get("merge")) == "true" {
+			messages = mergeConversationHistory(messages)
+		}
+		if out, err := marshal(r, sharedConversation{Conversation: convo, Messages: messages}); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// conversationNotFound is the message for the conversation in r's path not being found.
+// It also covers conversations of other users, so their ids aren't revealed
+func conversationNotFound(r *http.Request) string {
+	return fmt.Sprintf("conversation %s not found", r.PathValue("conversationId"))
+}
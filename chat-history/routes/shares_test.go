@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShareLinks(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /conversation/{conversationId}/shares", withRoles(CreateShareLink(store, testAuditLog(t))))
+	mux.Handle("GET /conversation/{conversationId}/shares", withRoles(ListShareLinks(store)))
+	mux.Handle("DELETE /conversation/{conversationId}/shares/{shareId}", withRoles(RevokeShareLink(store, testAuditLog(t))))
+	mux.HandleFunc("GET /shared/{token}", GetSharedConversation(store))
+
+	do := func(method, path, user string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		if user != "" {
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	sharesPath := fmt.Sprintf("/conversation/%s/shares", CONVO_ID)
+	create := func(body string) structs.ShareLink {
+		t.Helper()
+		resp := do(http.MethodPost, sharesPath, USER, strings.NewReader(body))
+		if resp.Code != 201 {
+			t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var link structs.ShareLink
+		json.Unmarshal(resp.Body.Bytes(), &link)
+		return link
+	}
+
+	// the link is read without credentials
+	link := create("")
+	resp := do(http.MethodGet, "/shared/"+link.Token, "", nil)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var shared sharedConversation
+	json.Unmarshal(resp.Body.Bytes(), &shared)
+	if shared.Conversation == nil || shared.Conversation.ConversationId.String() != CONVO_ID || len(shared.Messages) == 0 {
+		t.Fatalf("the shared conversation should be returned: %s", resp.Body)
+	}
+
+	// other users can't share, list or revoke it
+	if resp := do(http.MethodPost, sharesPath, "Miss_Take", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodGet, sharesPath, "Miss_Take", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodDelete, fmt.Sprintf("%s/%s", sharesPath, link.ShareId), "Miss_Take", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+
+	// revoked
+	if resp := do(http.MethodDelete, fmt.Sprintf("%s/%s", sharesPath, link.ShareId), USER, nil); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodGet, "/shared/"+link.Token, "", nil); resp.Code != 410 || !strings.Contains(resp.Body.String(), "revoked") {
+		t.Fatalf("Response code should be 410 for a revoked link. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// expired
+	expired := create(`{"expires_in_hours": 1}`)
+	if resp := do(http.MethodGet, "/shared/"+expired.Token, "", nil); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if _, err := store.CreateShareLink(USER, CONVO_ID, -1); err != nil {
+		t.Fatal(err)
+	}
+	links := []structs.ShareLink{}
+	resp = do(http.MethodGet, sharesPath, USER, nil)
+	json.Unmarshal(resp.Body.Bytes(), &links)
+	if resp.Code != 200 || len(links) != 3 {
+		t.Fatalf("every link should be listed. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodGet, "/shared/"+links[0].Token, "", nil); resp.Code != 410 || !strings.Contains(resp.Body.String(), "expired") {
+		t.Fatalf("Response code should be 410 for an expired link. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	if resp := do(http.MethodGet, "/shared/not-a-token", "", nil); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	for _, body := range []string{`{"expires_in_hours": -1}`, `{"expires_in_hours": 100000}`, `not json`} {
+		if resp := do(http.MethodPost, sharesPath, USER, strings.NewReader(body)); resp.Code != 400 {
+			t.Fatalf("Response code for %s should be 400. It is: %v", body, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errShuttingDown is the cause of the cancellation of the WebSockets that are open when the server shuts down
+var errShuttingDown = errors.New("the server is shutting down")
+
+// Sockets keeps track of the open WebSockets of ConversationSocket. http.Server.Shutdown doesn't wait for the
+// connections that were taken over, Shutdown closes them and Wait waits for their handlers
+type Sockets struct {
+	mu      sync.Mutex
+	closing bool
+	next    int
+	cancels map[int]context.CancelCauseFunc
+	// the handlers of the open sockets
+	handlers sync.WaitGroup
+}
+
+// NewSockets returns an empty set of sockets
+func NewSockets() *Sockets {
+	return &Sockets{cancels: map[int]context.CancelCauseFunc{}}
+}
+
+// open adds a socket that's closed with cancel, done is called once its handler returns.
+// It's false once the server is shutting down. A nil Sockets takes every socket and keeps none
+func (s *Sockets) open(cancel context.CancelCauseFunc) (done func(), ok bool) {
+	if s == nil {
+		return func() {}, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return nil, false
+	}
+	id := s.next
+	s.next++
+	s.cancels[id] = cancel
+	s.handlers.Add(1)
+	return func() {
+		s.mu.Lock()
+		delete(s.cancels, id)
+		s.mu.Unlock()
+		s.handlers.Done()
+	}, true
+}
+
+// Shutdown cancels every open socket with errShuttingDown, their replies are cut off and saved, and the
+// clients are told to go away. Sockets opened afterwards are refused. It's for http.Server.RegisterOnShutdown
+func (s *Sockets) Shutdown() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for _, cancel := range s.cancels {
+		cancel(errShuttingDown)
+	}
+}
+
+// Wait waits for the handlers of the sockets to return, or for ctx to be done
+func (s *Sockets) Wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
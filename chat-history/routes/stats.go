@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"chat-history/db"
+	"net/http"
+)
+
+// Get the totals of a user's conversations: how many there are, their messages, and when the user was first and
+// last active. Deleted and archived conversations aren't counted
+// "GET /user/{userId}/stats"
+// Users get their own, superusers anyone's
+func GetUserStats(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if _, err := auth(userId, r); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		stats, err := store.UserStats(userId)
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to retrieve stats"))
+			return
+		}
+		if out, err := marshal(r, stats); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get how many tokens a user's conversations are, in total and each one's, i.e., for metered plans. Deleted and
+// archived conversations aren't counted
+// "GET /user/{userId}/tokens"
+// Users get their own, superusers anyone's
+func GetTokenUsage(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId := r.PathValue("userId")
+		if _, err := auth(userId, r); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		usage, err := store.TokenUsage(userId)
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to retrieve token usage"))
+			return
+		}
+		if out, err := marshal(r, usage); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(out)
+		} else {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetUserStats(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}/stats", withRoles(GetUserStats(store)))
+	do := func(user, userId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/stats", userId), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	convos, _, err := store.ListConversations(USER, db.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := 0
+	for _, c := range convos {
+		messages += c.MessageCount
+	}
+
+	// users get their own, superusers anyone's
+	for _, user := range []string{USER, "admin"} {
+		resp := do(user, USER)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var stats structs.UserStats
+		json.Unmarshal(resp.Body.Bytes(), &stats)
+		if stats.UserId != USER || stats.Conversations != int64(len(convos)) || stats.Messages != int64(messages) {
+			t.Fatalf("expected %d conversations with %d messages. Got %s", len(convos), messages, resp.Body)
+		}
+		if stats.MessagesPerConversation != float64(messages)/float64(len(convos)) || stats.FirstActivity == nil || stats.LastActivity == nil {
+			t.Fatalf("the average and activity should be set: %s", resp.Body)
+		}
+	}
+
+	if resp := do("Miss_Take", USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("other users shouldn't get the stats. Got %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestGetTokenUsage(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}/tokens", withRoles(GetTokenUsage(store)))
+	// counted as it's added
+	question := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "How many transactions?", Role: structs.UserRole}
+	if _, err := store.AppendMessage(question, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	do := func(user, userId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/tokens", userId), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	convos, _, err := store.ListConversations(USER, db.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens int64
+	for _, c := range convos {
+		tokens += c.TokenCount
+	}
+
+	// users get their own, superusers anyone's
+	for _, user := range []string{USER, "admin"} {
+		resp := do(user, USER)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+		}
+		var usage structs.TokenUsage
+		json.Unmarshal(resp.Body.Bytes(), &usage)
+		if usage.UserId != USER || tokens == 0 || usage.TokenCount != tokens || len(usage.Conversations) != len(convos) {
+			t.Fatalf("expected %d tokens in %d conversations. Got %s", tokens, len(convos), resp.Body)
+		}
+	}
+
+	if resp := do("Miss_Take", USER); resp.Code != http.StatusForbidden {
+		t.Fatalf("other users shouldn't get the token usage. Got %v: %s", resp.Code, resp.Body)
+	}
+}
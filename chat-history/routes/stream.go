@@ -0,0 +1,436 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stream the assistant's reply to the latest message of a conversation as server-sent events
+// "POST /conversations/{conversationId}/stream"
+// Each piece of the reply is sent as a "chunk" event with {"content": "..."} as it arrives from the LLM.
+// Once the reply is complete it's saved to the conversation and sent as a "done" event with the new message.
+// It's saved as it arrives too, flagged incomplete, so a reply that's cut off isn't lost: if the LLM fails,
+// or the reply takes longer than chat_config.handlerTimeoutSeconds, the stream ends with an "incomplete" event
+// with what was saved and then an "error" event. The same is saved if the client disconnects, which cancels
+// the LLM request. Incomplete replies are continued with ResumeStream.
+// The oldest messages are left out if the conversation doesn't fit in the model's context window.
+// The reply is from the conversation's model if it has one (see SetConversationModel), and the LLM is sent the
+// conversation's system prompt ahead of the history, or else llm_config.default_system_prompt (see SetSystemPrompt).
+// Only one reply to a conversation is generated at a time, it's a 409 while another one is streamed or resumed
+func StreamConversation(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		convo, messages, unlock, ok := streamedConversation(w, r, store, llmClient)
+		if !ok {
+			return
+		}
+		defer unlock()
+		history := mergeConversationHistory(messages)
+		if len(history) == 0 {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("conversation %s has no messages to reply to", conversationId)))
+			return
+		}
+
+		llmCfg := conversationModel(llmCfg, convo)
+		parentId := history[len(history)-1].MessageId
+		reply := structs.Message{
+			ConversationId: convo.ConversationId,
+			MessageId:      uuid.New(),
+			ParentId:       &parentId,
+			ModelName:      llmCfg.ModelName,
+			Role:           structs.SystemRole,
+		}
+		streamReply(w, r, store, llmClient, llmCfg, llm.TruncateHistory(llmCfg, withSystemPrompt(llmCfg, convo, llmMessages(history))), reply)
+	}
+}
+
+// the instruction the LLM gets after the part of a reply it's asked to continue
+const resumePrompt = "Your last reply was cut off. Continue it from exactly where it stopped, without repeating any of it."
+
+// Continue an incomplete reply (see StreamConversation) where it stopped, as server-sent events
+// "POST /conversations/{conversationId}/messages/{messageId}/resume"
+// The events are those of StreamConversation, with only the new part of the reply in the "chunk" events and
+// the whole reply in the "done" one. The LLM is sent the system prompt and the history up to the reply, and asked
+// to continue it
+func ResumeStream(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		convo, messages, unlock, ok := streamedConversation(w, r, store, llmClient)
+		if !ok {
+			return
+		}
+		defer unlock()
+		history, found := db.BranchTo(messages, r.PathValue("messageId"))
+		if !found {
+			writeError(w, apierror.NotFound(messageNotFound(r)))
+			return
+		}
+		reply := history[len(history)-1]
+		if !reply.Incomplete {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("message %s is complete, there's nothing to resume", reply.MessageId)))
+			return
+		}
+
+		llmCfg := conversationModel(llmCfg, convo)
+		reply.ModelName = llmCfg.ModelName
+		prompt := append(withSystemPrompt(llmCfg, convo, llmMessages(history)), llm.Message{Role: "user", Content: resumePrompt})
+		streamReply(w, r, store, llmClient, llmCfg, llm.TruncateHistory(llmCfg, prompt), reply)
+	}
+}
+
+// Generate the assistant's reply to the latest message of a conversation again, as server-sent events
+// "POST /conversations/{conversationId}/regenerate"
+// The events are those of StreamConversation. The new reply is to the same history as the last one, and replaces it
+// as the latest message, so the merged history ends with it. The last reply isn't deleted: it stays in the
+// conversation on a branch of its own, a sibling of the new one with the same parent_id, and GET
+// /conversation/{conversationId} still returns it. It's a 400 if the latest message isn't the assistant's
+func RegenerateReply(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		convo, messages, unlock, ok := streamedConversation(w, r, store, llmClient)
+		if !ok {
+			return
+		}
+		defer unlock()
+		history := mergeConversationHistory(messages)
+		if len(history) == 0 || !isReply(history[len(history)-1]) {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("the latest message of conversation %s isn't the assistant's, there's no reply to regenerate", conversationId)))
+			return
+		}
+		last := history[len(history)-1]
+		history = history[:len(history)-1]
+		if len(history) == 0 {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("conversation %s has no messages before its reply to regenerate it from", conversationId)))
+			return
+		}
+
+		llmCfg := conversationModel(llmCfg, convo)
+		reply := structs.Message{
+			ConversationId: convo.ConversationId,
+			MessageId:      uuid.New(),
+			ParentId:       last.ParentId,
+			ModelName:      llmCfg.ModelName,
+			Role:           structs.SystemRole,
+		}
+		streamReply(w, r, store, llmClient, llmCfg, llm.TruncateHistory(llmCfg, withSystemPrompt(llmCfg, convo, llmMessages(history))), reply)
+	}
+}
+
+// contextRequest is the body of ContinueFromContext
+type contextRequest struct {
+	Content string `json:"content"`
+	// the context message's id, one is chosen if it's left out
+	MessageId uuid.UUID `json:"message_id"`
+}
+
+// Add what GraphRAG retrieved for the latest message of a conversation and stream the reply to it, as server-sent events
+// "POST /conversations/{conversationId}/continue" with {"content": "...", "message_id": "..."}
+// The content is added after the latest message as a context message, which the LLM is sent as a system message
+// right before the reply. It's hidden from GET /conversation/{conversationId}, the export and shared links unless
+// they're asked for it: the reply's parent is the message before it there. message_id can be left out.
+// The events are those of StreamConversation
+func ContinueFromContext(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		convo, messages, unlock, ok := streamedConversation(w, r, store, llmClient)
+		if !ok {
+			return
+		}
+		defer unlock()
+		var req contextRequest
+		if apiErr := decodeBody(r, &req, `body must be a JSON object like {"content": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		history := mergeConversationHistory(messages)
+		if len(history) == 0 {
+			writeError(w, apierror.InvalidRequest(fmt.Sprintf("conversation %s has no messages to reply to", conversationId)))
+			return
+		}
+		if req.MessageId == uuid.Nil {
+			req.MessageId = uuid.New()
+		}
+
+		parentId := history[len(history)-1].MessageId
+		message := structs.Message{ConversationId: convo.ConversationId, MessageId: req.MessageId, ParentId: &parentId, Content: req.Content, Role: structs.ContextRole}
+		if _, err := store.AppendMessage(message, db.AnyVersion); err != nil {
+			writeError(w, storeError(err, "", "failed to update conversation"))
+			return
+		}
+		// read back, the store can change the content (see chat_config.maxMessageChars)
+		messages, err := store.GetConversation(convo.UserId, conversationId)
+		if err != nil {
+			writeError(w, apierror.Internal("failed to retrieve conversation"))
+			return
+		}
+		history = mergeConversationHistory(messages)
+
+		llmCfg := conversationModel(llmCfg, convo)
+		reply := structs.Message{
+			ConversationId: convo.ConversationId,
+			MessageId:      uuid.New(),
+			ParentId:       &message.MessageId,
+			ModelName:      llmCfg.ModelName,
+			Role:           structs.SystemRole,
+		}
+		streamReply(w, r, store, llmClient, llmCfg, llm.TruncateHistory(llmCfg, withSystemPrompt(llmCfg, convo, llmMessages(history))), reply)
+	}
+}
+
+// isReply reports whether the message is one of the assistant's. Replies are stored with the system or assistant role
+func isReply(m structs.Message) bool {
+	return m.Role == structs.SystemRole || m.Role == structs.AssistantRole
+}
+
+// streamedConversation returns the conversation in r's path and its messages if there's an LLM to reply with,
+// the caller can write to it and no other reply to it is being generated. It takes the conversation's generation
+// lock until unlock is called, or the request times out. Otherwise it responds with the error and returns false
+func streamedConversation(w http.ResponseWriter, r *http.Request, store db.ConversationStore, llmClient llm.Client) (_ *structs.Conversation, _ []structs.Message, unlock func(), _ bool) {
+	convo, ok := repliedConversation(w, r, store, llmClient)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	// the messages are read once the lock is taken, so they include the reply generated before
+	expires, ok := r.Context().Deadline()
+	if !ok || time.Until(expires) > maxGeneration {
+		expires = time.Now().Add(maxGeneration)
+	}
+	unlock, ok = generating.lock(convo.ConversationId, expires)
+	if !ok {
+		writeError(w, errGenerating(convo.ConversationId.String()))
+		return nil, nil, nil, false
+	}
+	messages, err := store.GetConversation(convo.UserId, convo.ConversationId.String())
+	if err != nil {
+		unlock()
+		writeError(w, apierror.Internal("failed to retrieve conversation"))
+		return nil, nil, nil, false
+	}
+	return convo, messages, unlock, true
+}
+
+// repliedConversation returns the conversation in r's path if there's an LLM to reply with and the caller can
+// write to it. Otherwise it responds with the error and returns false
+func repliedConversation(w http.ResponseWriter, r *http.Request, store db.ConversationStore, llmClient llm.Client) (*structs.Conversation, bool) {
+	conversationId := r.PathValue("conversationId")
+	userId, authErr := auth("", r)
+	if authErr != nil {
+		writeError(w, authErr)
+		return nil, false
+	}
+	if llmClient == nil {
+		writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "no LLM is configured"))
+		return nil, false
+	}
+
+	convo, err := store.FindConversation(conversationId)
+	if err != nil {
+		writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+		return nil, false
+	}
+	if !allowed(r, store, userId, convo, structs.PermissionWrite) {
+		writeError(w, apierror.Forbidden(fmt.Sprintf("%s is not authorized to update conversation %s", userId, conversationId)))
+		return nil, false
+	}
+	return convo, true
+}
+
+// errGenerating is the error of a reply to a conversation while another one is being generated
+func errGenerating(conversationId string) *apierror.APIError {
+	return apierror.New(http.StatusConflict, apierror.CodeGenerationInProgress, fmt.Sprintf("a reply to conversation %s is already being generated, try again once it's done", conversationId))
+}
+
+// how often a reply is saved while it's streamed. It's saved when it ends as well
+const streamSaveInterval = time.Second
+
+// streamReply streams the LLM's reply to prompt as events, adding it to reply's content, and saves it as it grows.
+// reply has the content so far, which is empty unless it's an incomplete reply being resumed
+func streamReply(w http.ResponseWriter, r *http.Request, store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig, prompt []llm.Message, reply structs.Message) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("streaming is not supported by the response writer", "err", err)
+		return
+	}
+	generateReply(r.Context(), store, llmClient, llmCfg, prompt, reply, func(event string, v any) error {
+		if err := writeEvent(w, r, event, v); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+}
+
+// generateReply is streamReply sending the events with send: a "chunk" for each piece of the reply, then "done"
+// with the saved reply, or "incomplete" with what was saved and "error". ctx is cancelled when the client is gone,
+// and it's past its deadline when the reply took too long. It returns the reply as it was last saved, complete or
+// not, or nil if none of it was
+func generateReply(ctx context.Context, store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig, prompt []llm.Message, reply structs.Message, send func(event string, v any) error) *structs.Message {
+	// the reply is saved even once the request is cancelled, that's when it's cut off
+	saver := store.WithContext(context.WithoutCancel(ctx))
+	var content strings.Builder
+	content.WriteString(reply.Content)
+	start, saved := time.Now(), time.Time{}
+	var last *structs.Message
+	previousTime := reply.ResponseTime
+	// the provider that answered, when the primary fails over to a fallback
+	served := llm.Served{Provider: llmCfg.Provider, Model: llmCfg.ModelName}
+	save := func(incomplete bool) error {
+		reply.Content, reply.Incomplete = content.String(), incomplete
+		reply.Provider, reply.ModelName = served.Provider, served.Model
+		reply.ResponseTime = previousTime + time.Since(start).Seconds()
+		if strings.TrimSpace(reply.Content) == "" {
+			// messages must have content, there's nothing to save yet
+			return nil
+		}
+		saved = time.Now()
+		if _, err := saver.SaveStreamedMessage(reply); err != nil {
+			return err
+		}
+		stored := reply
+		last = &stored
+		return nil
+	}
+	// what was sent to the LLM and what it sent back, kept with the reply for admins to look into it
+	ctx = llm.RecordServed(llm.WithModel(ctx, llmCfg.ModelName), &served)
+	var exchange llm.Exchange
+	if llmCfg.DebugStoreLLMIO {
+		ctx = llm.RecordExchange(ctx, &exchange)
+	}
+	saveExchange := func() {
+		if !llmCfg.DebugStoreLLMIO || last == nil {
+			return
+		}
+		err := saver.SaveLLMExchange(structs.LLMExchange{
+			MessageId:      last.MessageId,
+			ConversationId: last.ConversationId,
+			Provider:       served.Provider,
+			Model:          served.Model,
+			Request:        exchange.Request,
+			Response:       exchange.Response,
+		})
+		if err != nil {
+			slog.Error("failed to save the LLM request and response", "conversation_id", reply.ConversationId, "err", err)
+		}
+	}
+	// ends the stream with the error, after the part of the reply that was saved
+	fail := func(apiErr *apierror.APIError) {
+		err := save(true)
+		saveExchange()
+		if err != nil {
+			slog.Error("failed to save the incomplete reply", "conversation_id", reply.ConversationId, "err", err)
+		} else if strings.TrimSpace(reply.Content) != "" {
+			send("incomplete", reply)
+		}
+		send("error", apiErr)
+	}
+
+	// ctx is cancelled when the client disconnects, which cancels the LLM request
+	_, err := llmClient.ChatStream(ctx, prompt, func(chunk string) error {
+		content.WriteString(chunk)
+		if time.Since(saved) >= streamSaveInterval {
+			if err := save(true); err != nil {
+				slog.Warn("failed to save the reply so far", "conversation_id", reply.ConversationId, "err", err)
+			}
+		}
+		return send("chunk", map[string]string{"content": chunk})
+	})
+	if err := ctx.Err(); err != nil {
+		// the client is still there when the request ran out of time (see middleware.Timeout)
+		if errors.Is(err, context.DeadlineExceeded) {
+			fail(apierror.Timeout("the reply took too long"))
+			return last
+		}
+		// the client is still there when the server is shutting down (see Sockets)
+		if errors.Is(context.Cause(ctx), errShuttingDown) {
+			fail(apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "the server is shutting down, resume the reply once it's back"))
+			return last
+		}
+		// otherwise the client is gone, there's no one to send the error or the reply to
+		if err := save(true); err != nil {
+			slog.Error("failed to save the incomplete reply", "conversation_id", reply.ConversationId, "err", err)
+		}
+		saveExchange()
+		return last
+	}
+	if err != nil {
+		slog.Error("failed to stream a reply", "conversation_id", reply.ConversationId, "err", err)
+		fail(llmError(err, "failed to get a reply from the LLM"))
+		return last
+	}
+	if strings.TrimSpace(content.String()) == "" {
+		// messages must have content, there's nothing to save
+		slog.Warn("the LLM sent an empty reply", "conversation_id", reply.ConversationId)
+		send("error", apierror.Internal("the LLM sent an empty reply"))
+		return last
+	}
+
+	if err := save(false); err != nil {
+		slog.Error("failed to save the reply", "conversation_id", reply.ConversationId, "err", err)
+		send("error", apierror.Internal("failed to save the reply"))
+		return last
+	}
+	saveExchange()
+	send("done", reply)
+	return last
+}
+
+// writeEvent writes a single server-sent event with v as its JSON data, on one line in r's style
+func writeEvent(w http.ResponseWriter, r *http.Request, event string, v any) error {
+	data, err := marshalLine(r, v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// llmMessages converts a conversation's history to the LLM's roles. Replies are stored with the system or assistant role.
+// Tool and function results are given as the user's, the providers only take them right after the call that asked
+// for them and calls aren't stored
+func llmMessages(history []structs.Message) []llm.Message {
+	out := make([]llm.Message, 0, len(history))
+	for _, m := range history {
+		switch m.Role {
+		case structs.SystemRole, structs.AssistantRole:
+			out = append(out, llm.Message{Role: "assistant", Content: m.Content})
+		case structs.ToolRole, structs.FunctionRole:
+			out = append(out, llm.Message{Role: "user", Content: toolResult(m)})
+		case structs.ContextRole:
+			out = append(out, llm.Message{Role: "system", Content: m.Content})
+		default:
+			out = append(out, llm.Message{Role: "user", Content: m.Content})
+		}
+	}
+	return out
+}
+
+// toolResult is the content of a tool or function result, with what it's the result of so the LLM can tell
+func toolResult(m structs.Message) string {
+	name := m.ToolName
+	if name == "" {
+		name = "a tool"
+	}
+	if m.ToolCallId != "" {
+		return fmt.Sprintf("Result of %s (call %s):\n\n%s", name, m.ToolCallId, m.Content)
+	}
+	return fmt.Sprintf("Result of %s:\n\n%s", name, m.Content)
+}
@@ -0,0 +1,691 @@
+package routes
+
+import (
+	"bufio"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// streamingLLM sends each chunk it receives on chunks as part of the reply, until chunks is closed
+type streamingLLM struct {
+	chunks    chan string
+	messages  []llm.Message
+	cancelled chan struct{}
+}
+
+func newStreamingLLM() *streamingLLM {
+	return &streamingLLM{chunks: make(chan string), cancelled: make(chan struct{})}
+}
+
+func (c *streamingLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func (c *streamingLLM) ChatStream(ctx context.Context, messages []llm.Message, onChunk func(string) error) (string, error) {
+	c.messages = messages
+	var reply strings.Builder
+	for {
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				return reply.String(), nil
+			}
+			reply.WriteString(chunk)
+			if err := onChunk(chunk); err != nil {
+				return "", err
+			}
+		case <-ctx.Done():
+			close(c.cancelled)
+			return "", ctx.Err()
+		}
+	}
+}
+
+func startStream(t *testing.T, handler http.HandlerFunc, ctx context.Context, user, conversationId string) *http.Response {
+	t.Helper()
+	return startEvents(t, handler, ctx, user, "POST /conversations/{conversationId}/stream", fmt.Sprintf("/conversations/%s/stream", conversationId), "")
+}
+
+func startResume(t *testing.T, handler http.HandlerFunc, user, conversationId, messageId string) *http.Response {
+	t.Helper()
+	path := fmt.Sprintf("/conversations/%s/messages/%s/resume", conversationId, messageId)
+	return startEvents(t, handler, context.Background(), user, "POST /conversations/{conversationId}/messages/{messageId}/resume", path, "")
+}
+
+func startRegenerate(t *testing.T, handler http.HandlerFunc, user, conversationId string) *http.Response {
+	t.Helper()
+	path := fmt.Sprintf("/conversations/%s/regenerate", conversationId)
+	return startEvents(t, handler, context.Background(), user, "POST /conversations/{conversationId}/regenerate", path, "")
+}
+
+func startContinue(t *testing.T, handler http.HandlerFunc, user, conversationId, body string) *http.Response {
+	t.Helper()
+	path := fmt.Sprintf("/conversations/%s/continue", conversationId)
+	return startEvents(t, handler, context.Background(), user, "POST /conversations/{conversationId}/continue", path, body)
+}
+
+func startEvents(t *testing.T, handler http.HandlerFunc, ctx context.Context, user, pattern, path, body string) *http.Response {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, handler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+path, strings.NewReader(body))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// setupStreamDB stores a conversation of USER's with a question and a reply, a second apart.
+// The DEV data is written too quickly to tell from its timestamps which message is the latest
+func setupStreamDB(t *testing.T) db.ConversationStore {
+	t.Helper()
+	store := setupDB(t, false)
+	now := time.Now()
+	question := structs.Message{
+		Model:          structs.Model{CreatedAt: now.Add(-2 * time.Second), UpdatedAt: now.Add(-2 * time.Second)},
+		ConversationId: uuid.MustParse(CONVO_ID),
+		MessageId:      uuid.New(),
+		Content:        "How many transactions?",
+		Role:           structs.UserRole,
+	}
+	parentId := question.MessageId
+	reply := structs.Message{
+		Model:          structs.Model{CreatedAt: now.Add(-time.Second), UpdatedAt: now.Add(-time.Second)},
+		ConversationId: question.ConversationId,
+		MessageId:      uuid.New(),
+		ParentId:       &parentId,
+		Content:        "There are 100 transactions",
+		Role:           structs.SystemRole,
+	}
+	if _, err := store.CreateConversation(USER, "conv1", question); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// readEvent reads the next event from the stream, failing if it takes longer than a second
+func readEvent(t *testing.T, events *bufio.Reader) (string, string) {
+	t.Helper()
+	type result struct {
+		event, data string
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				res.err = err
+				break
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				break
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				res.event = v
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				res.data = v
+			}
+		}
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.event, res.data
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event, it wasn't flushed")
+	}
+	return "", ""
+}
+
+func TestStreamConversation(t *testing.T) {
+	store := setupStreamDB(t)
+	client := newStreamingLLM()
+	resp := startStream(t, StreamConversation(store, client, config.LLMConfig{ModelName: "GPT-4o"}), context.Background(), USER, CONVO_ID)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Response code should be 200. It is: %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type should be text/event-stream. It's: %s", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+
+	// each chunk is only sent once the previous one was read, so it has to be flushed as it arrives
+	for _, chunk := range []string{"Sure, ", "here's ", "an answer"} {
+		client.chunks <- chunk
+		event, data := readEvent(t, events)
+		if event != "chunk" || data != fmt.Sprintf(`{"content":%q}`, chunk) {
+			t.Fatalf("expected a chunk event with %q. Got %s: %s", chunk, event, data)
+		}
+	}
+	close(client.chunks)
+
+	event, data := readEvent(t, events)
+	if event != "done" {
+		t.Fatalf("expected a done event. Got %s: %s", event, data)
+	}
+	var reply structs.Message
+	if err := json.Unmarshal([]byte(data), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Sure, here's an answer" || reply.Role != structs.SystemRole || reply.ModelName != "GPT-4o" {
+		t.Fatalf("done event should have the assembled reply: %+v", reply)
+	}
+
+	// the LLM is sent the history with the stored replies as the assistant
+	if len(client.messages) != 2 || client.messages[0].Role != "user" || client.messages[1].Role != "assistant" {
+		t.Fatalf("LLM should be sent the conversation history: %+v", client.messages)
+	}
+
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := mergeConversationHistory(messages)
+	if len(history) != 3 {
+		t.Fatalf("reply should be appended to the conversation. It has %d messages", len(history))
+	}
+	saved := history[2]
+	if saved.MessageId != reply.MessageId || saved.Content != reply.Content || *saved.ParentId != history[1].MessageId {
+		t.Fatalf("reply should be saved as a child of the latest message: %+v", saved)
+	}
+}
+
+func TestStreamConversation_Fallback(t *testing.T) {
+	store := setupStreamDB(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{`{"choices":[{"delta":{"content":"100 "}}]}`, `{"choices":[{"delta":{"content":"transactions"}}]}`, `[DONE]`} {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer up.Close()
+	llmCfg := config.LLMConfig{Provider: config.ProviderOllama, ModelName: "llama3", BaseURL: down.URL,
+		Fallbacks: []config.LLMProvider{{Provider: config.ProviderOpenAI, ModelName: "gpt-4o", BaseURL: up.URL, APIKeyEnv: "OPENAI_API_KEY"}}}
+	client, err := llm.NewClient(llmCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := bufio.NewReader(startStream(t, StreamConversation(store, client, llmCfg), context.Background(), USER, CONVO_ID).Body)
+	var event, data string
+	for event != "done" && event != "error" {
+		event, data = readEvent(t, events)
+	}
+	var reply structs.Message
+	json.Unmarshal([]byte(data), &reply)
+	if event != "done" || reply.Content != "100 transactions" {
+		t.Fatalf("the fallback should write the reply. Got %s: %s", event, data)
+	}
+	// the reply is the fallback's
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := mergeConversationHistory(messages)[2]
+	if reply.Provider != config.ProviderOpenAI || saved.Provider != config.ProviderOpenAI || saved.ModelName != "gpt-4o" {
+		t.Fatalf("the reply should be saved as the fallback's. Got %q %q: %+v", saved.Provider, saved.ModelName, reply)
+	}
+}
+
+func TestStreamConversation_TruncatesHistory(t *testing.T) {
+	store := setupStreamDB(t)
+	client := newStreamingLLM()
+	go func() {
+		client.chunks <- "100"
+		close(client.chunks)
+	}()
+	// only room for the question
+	cfg := config.LLMConfig{ModelName: "GPT-4o", MaxContextTokens: 10}
+	resp := startStream(t, StreamConversation(store, client, cfg), context.Background(), USER, CONVO_ID)
+	events := bufio.NewReader(resp.Body)
+	readEvent(t, events)
+	if event, data := readEvent(t, events); event != "done" {
+		t.Fatalf("expected a done event. Got %s: %s", event, data)
+	}
+	if len(client.messages) != 1 || client.messages[0].Content != "How many transactions?" {
+		t.Fatalf("LLM should only be sent the latest question: %+v", client.messages)
+	}
+}
+
+func TestStreamConversation_EmptyReply(t *testing.T) {
+	store := setupStreamDB(t)
+	client := newStreamingLLM()
+	close(client.chunks)
+	resp := startStream(t, StreamConversation(store, client, config.LLMConfig{ModelName: "GPT-4o"}), context.Background(), USER, CONVO_ID)
+	if event, data := readEvent(t, bufio.NewReader(resp.Body)); event != "error" {
+		t.Fatalf("expected an error event. Got %s: %s", event, data)
+	}
+	if messages, err := store.GetConversation(USER, CONVO_ID); err != nil || len(messages) != 2 {
+		t.Fatalf("the empty reply shouldn't be saved. Got %d messages, %v", len(messages), err)
+	}
+}
+
+func TestStreamConversation_ClientDisconnects(t *testing.T) {
+	store := setupStreamDB(t)
+	client := newStreamingLLM()
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := startStream(t, StreamConversation(store, client, config.LLMConfig{ModelName: "GPT-4o"}), ctx, USER, CONVO_ID)
+	events := bufio.NewReader(resp.Body)
+
+	client.chunks <- "partial "
+	readEvent(t, events)
+	cancel()
+
+	select {
+	case <-client.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("LLM request should be cancelled when the client disconnects")
+	}
+
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("the partial reply should be saved. The conversation has %d messages", len(messages))
+	}
+	if partial := messages[2]; partial.Content != "partial " || !partial.Incomplete {
+		t.Fatalf("the partial reply should be saved as incomplete. Got %q, incomplete %v", partial.Content, partial.Incomplete)
+	}
+}
+
+// failingLLM sends its chunks and then fails
+type failingLLM struct {
+	chunks   []string
+	messages []llm.Message
+}
+
+func (c *failingLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func (c *failingLLM) ChatStream(ctx context.Context, messages []llm.Message, onChunk func(string) error) (string, error) {
+	c.messages = messages
+	for _, chunk := range c.chunks {
+		if err := onChunk(chunk); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("connection reset")
+}
+
+func TestStreamConversation_LLMFails(t *testing.T) {
+	store := setupStreamDB(t)
+	client := &failingLLM{chunks: []string{"There are ", "100 "}}
+	resp := startStream(t, StreamConversation(store, client, config.LLMConfig{ModelName: "GPT-4o"}), context.Background(), USER, CONVO_ID)
+	events := bufio.NewReader(resp.Body)
+
+	readEvent(t, events)
+	readEvent(t, events)
+	event, data := readEvent(t, events)
+	if event != "incomplete" {
+		t.Fatalf("expected an incomplete event. Got %s: %s", event, data)
+	}
+	partial := structs.Message{}
+	if err := json.Unmarshal([]byte(data), &partial); err != nil {
+		t.Fatal(err)
+	}
+	if partial.Content != "There are 100 " || !partial.Incomplete {
+		t.Fatalf("incomplete event should have the partial reply. Got %q, incomplete %v", partial.Content, partial.Incomplete)
+	}
+	if event, data := readEvent(t, events); event != "error" {
+		t.Fatalf("expected an error event. Got %s: %s", event, data)
+	}
+
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[2].MessageId != partial.MessageId || messages[2].Content != partial.Content || !messages[2].Incomplete {
+		t.Fatalf("the partial reply should be saved as incomplete. Got %v", messages)
+	}
+}
+
+func TestResumeStream(t *testing.T) {
+	store := setupStreamDB(t)
+	history, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parentId := history[1].MessageId
+	partial := structs.Message{
+		ConversationId: uuid.MustParse(CONVO_ID),
+		MessageId:      uuid.New(),
+		ParentId:       &parentId,
+		Content:        "The biggest is ",
+		Role:           structs.SystemRole,
+		ResponseTime:   2,
+		Incomplete:     true,
+	}
+	if _, err := store.SaveStreamedMessage(partial); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newStreamingLLM()
+	resp := startResume(t, ResumeStream(store, client, config.LLMConfig{ModelName: "GPT-4o"}), USER, CONVO_ID, partial.MessageId.String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v", resp.StatusCode)
+	}
+	events := bufio.NewReader(resp.Body)
+	client.chunks <- "$500"
+	if event, data := readEvent(t, events); event != "chunk" || data != `{"content":"$500"}` {
+		t.Fatalf("chunk event should have only the new content. Got %s: %s", event, data)
+	}
+	close(client.chunks)
+	if event, data := readEvent(t, events); event != "done" {
+		t.Fatalf("expected a done event. Got %s: %s", event, data)
+	}
+
+	// the LLM gets the partial reply, and is asked to continue it
+	if n := len(client.messages); n != 4 || client.messages[2].Content != partial.Content || client.messages[3].Content != resumePrompt {
+		t.Fatalf("LLM should get the history up to the reply and the prompt to continue. Got %v", client.messages)
+	}
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("the reply should be continued in place. The conversation has %d messages", len(messages))
+	}
+	reply := messages[2]
+	if reply.MessageId != partial.MessageId || reply.Content != "The biggest is $500" || reply.Incomplete {
+		t.Fatalf("the reply should be complete. Got %q, incomplete %v", reply.Content, reply.Incomplete)
+	}
+	if reply.ResponseTime < partial.ResponseTime {
+		t.Fatalf("response time should include the first attempt's. Got %v", reply.ResponseTime)
+	}
+}
+
+func TestResumeStream_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	history, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		user      string
+		messageId string
+		code      int
+	}{
+		{"complete", USER, history[1].MessageId.String(), http.StatusBadRequest},
+		{"not found", USER, uuid.NewString(), http.StatusNotFound},
+		{"not the owner", "someone-else", history[1].MessageId.String(), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := startResume(t, ResumeStream(store, newStreamingLLM(), config.LLMConfig{}), tt.user, CONVO_ID, tt.messageId)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRegenerateReply(t *testing.T) {
+	store := setupStreamDB(t)
+	before, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, previous := before[0], before[1]
+
+	client := newStreamingLLM()
+	resp := startRegenerate(t, RegenerateReply(store, client, config.LLMConfig{ModelName: "GPT-4o"}), USER, CONVO_ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v", resp.StatusCode)
+	}
+	events := bufio.NewReader(resp.Body)
+	client.chunks <- "There are 101 transactions"
+	if event, data := readEvent(t, events); event != "chunk" {
+		t.Fatalf("expected a chunk event. Got %s: %s", event, data)
+	}
+	close(client.chunks)
+	event, data := readEvent(t, events)
+	if event != "done" {
+		t.Fatalf("expected a done event. Got %s: %s", event, data)
+	}
+	var reply structs.Message
+	if err := json.Unmarshal([]byte(data), &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// the LLM is sent what the previous reply was a reply to, without it
+	if len(client.messages) != 1 || client.messages[0].Content != question.Content {
+		t.Fatalf("LLM should be sent the history before the last reply: %+v", client.messages)
+	}
+
+	// the new reply is a sibling of the previous one, which is kept
+	messages, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[1].MessageId != previous.MessageId || messages[1].Content != previous.Content {
+		t.Fatalf("the previous reply should still be in the conversation: %+v", messages)
+	}
+	saved := messages[2]
+	if saved.MessageId != reply.MessageId || saved.Content != "There are 101 transactions" || saved.ParentId == nil || *saved.ParentId != question.MessageId {
+		t.Fatalf("the new reply should be saved with the previous one's parent: %+v", saved)
+	}
+	history := mergeConversationHistory(messages)
+	if len(history) != 2 || history[1].MessageId != reply.MessageId {
+		t.Fatalf("the new reply should replace the previous one in the merged history: %+v", history)
+	}
+}
+
+func TestContinueFromContext(t *testing.T) {
+	store := setupStreamDB(t)
+	before, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	question, previous := before[0], before[1]
+
+	client := newStreamingLLM()
+	retrieved := "Accounts 3 and 7 transferred 40 times yesterday"
+	resp := startContinue(t, ContinueFromContext(store, client, config.LLMConfig{ModelName: "GPT-4o"}), USER, CONVO_ID, fmt.Sprintf(`{"content": %q}`, retrieved))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v", resp.StatusCode)
+	}
+	events := bufio.NewReader(resp.Body)
+	client.chunks <- "There were 40 transfers"
+	readEvent(t, events)
+	close(client.chunks)
+	event, data := readEvent(t, events)
+	var reply structs.Message
+	json.Unmarshal([]byte(data), &reply)
+	if event != "done" || reply.Content != "There were 40 transfers" {
+		t.Fatalf("the reply should be saved. Got %s: %s", event, data)
+	}
+
+	// the LLM got the context right before the reply, as a system message
+	if last := client.messages[len(client.messages)-1]; last.Role != "system" || last.Content != retrieved || len(client.messages) != 3 {
+		t.Fatalf("LLM should be sent the history and then the context: %+v", client.messages)
+	}
+
+	// it's stored, and hidden unless it's asked for
+	messages, _ := store.GetConversation(USER, CONVO_ID)
+	history := mergeConversationHistory(messages)
+	if len(history) != 4 || history[2].Role != structs.ContextRole || history[2].Content != retrieved || *reply.ParentId != history[2].MessageId {
+		t.Fatalf("the context should be stored between the previous reply and the new one: %+v", history)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversation/{conversationId}", GetConversation(store))
+	mux.HandleFunc("GET /conversations/{conversationId}/export", ExportConversation(store))
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	var visible []structs.Message
+	read := get(fmt.Sprintf("/conversation/%s?merge=true", CONVO_ID))
+	json.Unmarshal(read.Body.Bytes(), &visible)
+	if len(visible) != 3 || visible[0].MessageId != question.MessageId || visible[2].MessageId != reply.MessageId || read.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("the context should be hidden from the history: %s", read.Body)
+	}
+	if *visible[2].ParentId != previous.MessageId {
+		t.Fatalf("the reply should follow the previous one where the context is hidden: %+v", visible[2])
+	}
+	json.Unmarshal(get(fmt.Sprintf("/conversation/%s?include_hidden=true", CONVO_ID)).Body.Bytes(), &visible)
+	if len(visible) != 4 || visible[2].Role != structs.ContextRole {
+		t.Fatalf("include_hidden=true should return the context: %+v", visible)
+	}
+	if export := get(fmt.Sprintf("/conversations/%s/export?format=markdown", CONVO_ID)).Body.String(); strings.Contains(export, retrieved) || !strings.Contains(export, reply.Content) {
+		t.Fatalf("the export should leave the context out:\n%s", export)
+	}
+	if export := get(fmt.Sprintf("/conversations/%s/export?format=markdown&include_hidden=true", CONVO_ID)).Body.String(); !strings.Contains(export, retrieved) {
+		t.Fatalf("the export should have the context with include_hidden=true:\n%s", export)
+	}
+}
+
+func TestContinueFromContext_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	tests := []struct {
+		name           string
+		conversationId string
+		body           string
+		code           int
+	}{
+		{"no content", CONVO_ID, `{"content": ""}`, http.StatusBadRequest},
+		{"not JSON", CONVO_ID, `retrieved`, http.StatusBadRequest},
+		{"not found", uuid.NewString(), `{"content": "context"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp := startContinue(t, ContinueFromContext(store, newStreamingLLM(), config.LLMConfig{}), USER, tt.conversationId, tt.body)
+		if resp.StatusCode != tt.code {
+			t.Fatalf("%s: the response code should be %d. It is: %v", tt.name, tt.code, resp.StatusCode)
+		}
+	}
+	if messages, _ := store.GetConversation(USER, CONVO_ID); len(messages) != 2 {
+		t.Fatalf("nothing should be added: %+v", messages)
+	}
+}
+
+func TestRegenerateReply_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	before, err := store.GetConversation(USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the latest message is now the user's
+	parentId := before[1].MessageId
+	followUp := structs.Message{
+		ConversationId: uuid.MustParse(CONVO_ID),
+		MessageId:      uuid.New(),
+		ParentId:       &parentId,
+		Content:        "And yesterday?",
+		Role:           structs.UserRole,
+	}
+	if _, err := store.AppendMessage(followUp, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		client         llm.Client
+		user           string
+		conversationId string
+		code           int
+	}{
+		{"latest isn't a reply", newStreamingLLM(), USER, CONVO_ID, http.StatusBadRequest},
+		{"no LLM", nil, USER, CONVO_ID, http.StatusNotImplemented},
+		{"not found", newStreamingLLM(), USER, uuid.NewString(), http.StatusNotFound},
+		{"not the owner", newStreamingLLM(), "someone-else", CONVO_ID, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := startRegenerate(t, RegenerateReply(store, tt.client, config.LLMConfig{}), tt.user, tt.conversationId)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.StatusCode)
+			}
+		})
+	}
+	if messages, err := store.GetConversation(USER, CONVO_ID); err != nil || len(messages) != 3 {
+		t.Fatalf("nothing should be added or removed. Got %d messages, %v", len(messages), err)
+	}
+}
+
+func TestStreamConversation_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	tests := []struct {
+		name           string
+		client         llm.Client
+		user           string
+		conversationId string
+		code           int
+	}{
+		{"no LLM", nil, USER, CONVO_ID, http.StatusNotImplemented},
+		{"not found", newStreamingLLM(), USER, uuid.NewString(), http.StatusNotFound},
+		{"not the owner", newStreamingLLM(), "someone-else", CONVO_ID, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := startStream(t, StreamConversation(store, tt.client, config.LLMConfig{}), context.Background(), tt.user, tt.conversationId)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("Response code should be %d. It is: %v", tt.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestLLMMessages(t *testing.T) {
+	history := []structs.Message{
+		{Role: structs.UserRole, Content: "How many accounts are flagged?"},
+		{Role: structs.SystemRole, Content: "Let me count them"},
+		{Role: structs.ToolRole, Content: `{"flagged": 12}`, ToolName: "count_flagged", ToolCallId: "call_42"},
+		{Role: structs.FunctionRole, Content: "12", ToolName: "count_flagged"},
+		{Role: structs.AssistantRole, Content: "12 accounts are flagged"},
+		{Role: structs.ContextRole, Content: "Account 7 was flagged twice"},
+	}
+	want := []llm.Message{
+		{Role: "user", Content: "How many accounts are flagged?"},
+		{Role: "assistant", Content: "Let me count them"},
+		{Role: "user", Content: "Result of count_flagged (call call_42):\n\n{\"flagged\": 12}"},
+		{Role: "user", Content: "Result of count_flagged:\n\n12"},
+		{Role: "assistant", Content: "12 accounts are flagged"},
+		{Role: "system", Content: "Account 7 was flagged twice"},
+	}
+	got := llmMessages(history)
+	if len(got) != len(want) {
+		t.Fatalf("every message should be sent. Got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("message %d should be %+v. Got %+v", i, want[i], got[i])
+		}
+	}
+}
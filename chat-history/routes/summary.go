@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the most summaries kept in memory. One is dropped to make room for a new one when it's full
+const maxCachedSummaries = 1000
+
+type conversationSummary struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	Summary        string    `json:"summary"`
+	// the conversation's update_ts when it was summarized
+	UpdatedAt time.Time `json:"update_ts"`
+}
+
+// summaryCache keeps the latest summary of each conversation. A summary is only used while the
+// conversation's updated_at is the same as when it was made, so new or edited messages re-summarize it
+type summaryCache struct {
+	mu        sync.Mutex
+	summaries map[uuid.UUID]conversationSummary
+}
+
+func (c *summaryCache) get(conversationId uuid.UUID, updatedAt time.Time) (conversationSummary, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.summaries[conversationId]
+	if !ok || !s.UpdatedAt.Equal(updatedAt) {
+		return conversationSummary{}, false
+	}
+	return s, true
+}
+
+func (c *summaryCache) put(s conversationSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.summaries[s.ConversationId]; !ok && len(c.summaries) >= maxCachedSummaries {
+		for id := range c.summaries {
+			delete(c.summaries, id)
+			break
+		}
+	}
+	c.summaries[s.ConversationId] = s
+}
+
+// Summarize a conversation with the LLM
+// "GET /conversations/{conversationId}/summary"
+// The summary is cached until the conversation changes. It's written by the conversation's model if it has one
+func SummarizeConversation(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig) http.HandlerFunc {
+	cache := &summaryCache{summaries: map[uuid.UUID]conversationSummary{}}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		if llmClient == nil {
+			writeError(w, apierror.New(http.StatusNotImplemented, apierror.CodeNotImplemented, "no LLM is configured"))
+			return
+		}
+
+		convo, err := store.FindConversation(conversationId)
+		if err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation"))
+			return
+		}
+		// superusers and users granted access can summarize the conversation
+		if !allowed(r, store, userId, convo, structs.PermissionRead) {
+			writeError(w, apierror.Forbidden(fmt.Sprintf("%s is not authorized to read conversation %s", userId, conversationId)))
+			return
+		}
+
+		summary, ok := cache.get(convo.ConversationId, convo.UpdatedAt)
+		if !ok {
+			messages, err := store.GetConversation(convo.UserId, conversationId)
+			if err != nil {
+				writeError(w, apierror.Internal("failed to retrieve conversation"))
+				return
+			}
+			history := mergeConversationHistory(messages)
+			if len(history) == 0 {
+				writeError(w, apierror.InvalidRequest(fmt.Sprintf("conversation %s has no messages to summarize", conversationId)))
+				return
+			}
+
+			llmCfg := conversationModel(llmCfg, convo)
+			text, err := llm.Summarize(llm.WithModel(r.Context(), llmCfg.ModelName), llmClient, llmCfg, llmMessages(history))
+			if err != nil {
+				slog.Error("failed to summarize conversation", "conversation_id", conversationId, "err", err)
+				writeError(w, llmError(err, "failed to get a summary from the LLM"))
+				return
+			}
+			summary = conversationSummary{ConversationId: convo.ConversationId, Summary: text, UpdatedAt: convo.UpdatedAt}
+			cache.put(summary)
+		}
+
+		out, err := marshal(r, summary)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/structs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// summaryLLM replies with the number of times it's been asked for a summary
+type summaryLLM struct {
+	calls    int
+	messages []llm.Message
+}
+
+func (c *summaryLLM) Chat(ctx context.Context, messages []llm.Message) (string, error) {
+	c.calls++
+	c.messages = messages
+	return fmt.Sprintf("summary %d", c.calls), nil
+}
+
+func (c *summaryLLM) ChatStream(ctx context.Context, messages []llm.Message, onChunk func(string) error) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func getSummary(t *testing.T, handler http.HandlerFunc, user string) (*httptest.ResponseRecorder, conversationSummary) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversations/{conversationId}/summary", handler)
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/conversations/%s/summary", CONVO_ID), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	var summary conversationSummary
+	if resp.Code == http.StatusOK {
+		if err := json.Unmarshal(resp.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, summary
+}
+
+func TestSummarizeConversation(t *testing.T) {
+	store := setupStreamDB(t)
+	client := &summaryLLM{}
+	handler := SummarizeConversation(store, client, config.LLMConfig{})
+
+	resp, summary := getSummary(t, handler, USER)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if summary.Summary != "summary 1" || summary.ConversationId.String() != CONVO_ID {
+		t.Fatalf("the LLM's summary should be returned: %+v", summary)
+	}
+	// the prompt, then the history with the stored replies as the assistant
+	if len(client.messages) != 3 || client.messages[0].Role != "system" || client.messages[1].Role != "user" || client.messages[2].Role != "assistant" {
+		t.Fatalf("LLM should be sent the conversation history: %+v", client.messages)
+	}
+
+	// unchanged, so the cached summary is used
+	if _, summary := getSummary(t, handler, USER); summary.Summary != "summary 1" || client.calls != 1 {
+		t.Fatalf("the cached summary should be returned without asking the LLM. Got %q after %d calls", summary.Summary, client.calls)
+	}
+
+	// a new message changes updated_at, so it's summarized again
+	time.Sleep(10 * time.Millisecond)
+	msg := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "And last week?", Role: structs.UserRole}
+	if _, err := store.AppendMessage(msg, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, summary := getSummary(t, handler, USER); summary.Summary != "summary 2" || client.calls != 2 {
+		t.Fatalf("the conversation should be summarized again once it changes. Got %q after %d calls", summary.Summary, client.calls)
+	}
+}
+
+func TestSummarizeConversation_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+
+	// no LLM
+	if resp, _ := getSummary(t, SummarizeConversation(store, nil, config.LLMConfig{}), USER); resp.Code != http.StatusNotImplemented {
+		t.Fatalf("Response code should be 501 without an LLM. It is: %v", resp.Code)
+	}
+
+	// someone else's conversation
+	client := &summaryLLM{}
+	if resp, _ := getSummary(t, SummarizeConversation(store, client, config.LLMConfig{}), "Miss_Take"); resp.Code != http.StatusForbidden {
+		t.Fatalf("Response code should be 403 for another user's conversation. It is: %v", resp.Code)
+	}
+	if client.calls != 0 {
+		t.Fatal("the LLM shouldn't be asked for a summary the caller can't see")
+	}
+}
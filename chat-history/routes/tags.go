@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"chat-history/db"
+	"fmt"
+	"net/http"
+)
+
+// Tag a conversation
+// "PUT /conversation/{conversationId}/tags/{tag}"
+func AddTag(store db.ConversationStore) http.HandlerFunc {
+	return tagHandler(store, db.ConversationStore.AddTag)
+}
+
+// Take a tag off a conversation
+// "DELETE /conversation/{conversationId}/tags/{tag}"
+func RemoveTag(store db.ConversationStore) http.HandlerFunc {
+	return tagHandler(store, db.ConversationStore.RemoveTag)
+}
+
+// tagHandler checks the caller owns the conversation before changing its tags with update
+func tagHandler(store db.ConversationStore, update func(store db.ConversationStore, userId, conversationId, tag string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		conversationId := r.PathValue("conversationId")
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		// superusers can tag any conversation, tag it as its owner
+		if isSuperuser(r) {
+			if c, err := store.FindConversation(conversationId); err == nil {
+				userId = c.UserId
+			}
+		}
+
+		// conversations of other users are reported as not found too, so their ids aren't revealed
+		if err := update(store, userId, conversationId, r.PathValue("tag")); err != nil {
+			writeError(w, storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to update tags"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
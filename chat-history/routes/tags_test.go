@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	store := setupDB(t, true)
+	resolve := fakeRoles(map[string][]string{"admin": {SuperuserRole}, USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user/{userId}", GetUserConversations(store))
+	mux.Handle("PUT /conversation/{conversationId}/tags/{tag}", withRoles(AddTag(store)))
+	mux.Handle("DELETE /conversation/{conversationId}/tags/{tag}", withRoles(RemoveTag(store)))
+
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	list := func(tags ...string) []structs.Conversation {
+		t.Helper()
+		q := url.Values{"tag": tags}
+		resp := do(http.MethodGet, fmt.Sprintf("/user/%s?%s", USER, q.Encode()), USER)
+		if resp.Code != 200 {
+			t.Fatalf("Response code should be 200. It is: %v", resp.Code)
+		}
+		var convos []structs.Conversation
+		json.Unmarshal(resp.Body.Bytes(), &convos)
+		return convos
+	}
+	all := len(list())
+
+	tagPath := func(tag string) string {
+		return fmt.Sprintf("/conversation/%s/tags/%s", CONVO_ID, url.PathEscape(tag))
+	}
+	if resp := do(http.MethodPut, tagPath("work"), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	// superusers can tag any conversation
+	if resp := do(http.MethodPut, tagPath("q3 review"), "admin"); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	// other users can't, and can't tell the conversation exists
+	if resp := do(http.MethodPut, tagPath("mine"), "Miss_Take"); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodDelete, tagPath("work"), "Miss_Take"); resp.Code != 404 {
+		t.Fatalf("Response code should be 404. It is: %v", resp.Code)
+	}
+	if resp := do(http.MethodPut, tagPath(strings.Repeat("x", 65)), USER); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+
+	// conversations without tags are only listed when there's no filter
+	if n := len(list()); n != all {
+		t.Fatalf("every conversation should be listed without a tag filter. Got %d of %d", n, all)
+	}
+	convos := list("work", "q3 review")
+	if len(convos) != 1 || convos[0].ConversationId.String() != CONVO_ID || !slices.Equal(convos[0].Tags, []string{"q3 review", "work"}) {
+		t.Fatalf("only the conversation with both tags should be listed, with its tags: %+v", convos)
+	}
+	if convos := list("work", "mine"); len(convos) != 0 {
+		t.Fatalf("tags should be combined with AND. Got: %+v", convos)
+	}
+
+	if resp := do(http.MethodDelete, tagPath("work"), USER); resp.Code != 204 {
+		t.Fatalf("Response code should be 204. It is: %v", resp.Code)
+	}
+	if convos := list("work"); len(convos) != 0 {
+		t.Fatalf("removed tag shouldn't match. Got: %+v", convos)
+	}
+}
@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/structs"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type templateRequest struct {
+	Title    string                    `json:"title"`
+	Shared   bool                      `json:"shared"`
+	Messages []structs.TemplateMessage `json:"messages"`
+}
+
+const templateBody = `body must be {"title": "...", "shared": false, "messages": [{"role": "...", "content": "..."}]}`
+
+// decodeTemplate reads a templateRequest from r. Only superusers can share templates, so everyone's shared
+// templates are the ones the team agreed on. It responds with the error and returns false if it can't be used
+func decodeTemplate(w http.ResponseWriter, r *http.Request) (templateRequest, bool) {
+	var req templateRequest
+	if apiErr := decodeBody(r, &req, templateBody); apiErr != nil {
+		writeError(w, apiErr)
+		return req, false
+	}
+	if req.Shared && !isSuperuser(r) {
+		writeError(w, apierror.Forbidden("only superusers can share templates"))
+		return req, false
+	}
+	return req, true
+}
+
+func templateNotFound(r *http.Request) string {
+	return fmt.Sprintf("template %s not found", r.PathValue("templateId"))
+}
+
+// Save a template of the first messages of conversations, owned by the caller
+// "POST /templates" with {"title": "...", "shared": false, "messages": [{"role": "...", "content": "..."}]}
+// Shared templates are listed for everyone, only superusers can share them
+func CreateTemplate(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		req, ok := decodeTemplate(w, r)
+		if !ok {
+			return
+		}
+
+		template, err := store.CreateTemplate(structs.Template{UserId: userId, Title: req.Title, Shared: req.Shared, Messages: req.Messages})
+		if err != nil {
+			writeError(w, storeError(err, "", "failed to create the template"))
+			return
+		}
+		if out, err := marshal(r, template); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// List the caller's templates and the shared ones, by title
+// "GET /templates"
+func ListTemplates(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		templates, err := store.ListTemplates(userId)
+		if err != nil {
+			writeError(w, apierror.Internal("failed to retrieve templates"))
+			return
+		}
+		if out, err := marshal(r, templates); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Get one of the caller's templates or a shared one
+// "GET /templates/{templateId}"
+func GetTemplate(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		template, err := store.GetTemplate(userId, r.PathValue("templateId"))
+		if err != nil {
+			writeError(w, storeError(err, templateNotFound(r), "failed to retrieve the template"))
+			return
+		}
+		if out, err := marshal(r, template); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Replace the title, messages and sharing of one of the caller's templates
+// "PUT /templates/{templateId}" with the body of CreateTemplate
+func UpdateTemplate(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+		templateId, err := uuid.Parse(r.PathValue("templateId"))
+		if err != nil {
+			writeError(w, apierror.NotFound(templateNotFound(r)))
+			return
+		}
+		req, ok := decodeTemplate(w, r)
+		if !ok {
+			return
+		}
+
+		template := structs.Template{TemplateId: templateId, Title: req.Title, Shared: req.Shared, Messages: req.Messages}
+		updated, err := store.UpdateTemplate(userId, template)
+		if err != nil {
+			writeError(w, storeError(err, templateNotFound(r), "failed to update the template"))
+			return
+		}
+		if out, err := marshal(r, updated); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
+
+// Delete one of the caller's templates. Conversations started from it keep their messages
+// "DELETE /templates/{templateId}"
+func DeleteTemplate(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := store.WithContext(r.Context())
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		if err := store.DeleteTemplate(userId, r.PathValue("templateId")); err != nil {
+			writeError(w, storeError(err, templateNotFound(r), "failed to delete the template"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type templateConversationRequest struct {
+	Name string `json:"name"`
+}
+
+// Start a conversation of the caller's with a template's messages
+// "POST /templates/{templateId}/conversations" with an optional {"name": "..."}
+// The conversation is named after the template without a name. The template must be the caller's or shared
+func CreateConversationFromTemplate(store db.ConversationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := conversationLimit(r, store.WithContext(r.Context()))
+		userId, authErr := auth("", r)
+		if authErr != nil {
+			writeError(w, authErr)
+			return
+		}
+
+		var req templateConversationRequest
+		if apiErr := decodeOptionalBody(r, &req, `body must be empty or {"name": "..."}`); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		convo, err := store.CreateConversationFromTemplate(userId, r.PathValue("templateId"), req.Name)
+		if err != nil {
+			writeError(w, storeError(err, templateNotFound(r), "failed to create the conversation"))
+			return
+		}
+		if out, err := marshal(r, convo); err == nil {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(out))
+		} else {
+			panic(err)
+		}
+	}
+}
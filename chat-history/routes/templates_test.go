@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	store := setupDB(t, false)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}, "admin": {SuperuserRole}})
+	withRoles := RequireRoles([]string{SuperuserRole, "globaldesigner"}, resolve)
+	mux := http.NewServeMux()
+	mux.Handle("POST /templates", withRoles(CreateTemplate(store)))
+	mux.Handle("GET /templates", withRoles(ListTemplates(store)))
+	mux.Handle("GET /templates/{templateId}", withRoles(GetTemplate(store)))
+	mux.Handle("PUT /templates/{templateId}", withRoles(UpdateTemplate(store)))
+	mux.Handle("DELETE /templates/{templateId}", withRoles(DeleteTemplate(store)))
+	mux.Handle("POST /templates/{templateId}/conversations", withRoles(CreateConversationFromTemplate(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	body := `{"title": "Triage", "shared": %v, "messages": [
+		{"role": "system", "content": "You triage fraud alerts."},
+		{"role": "user", "content": "What was flagged today?"}]}`
+
+	// only superusers share templates
+	if resp := do(http.MethodPost, "/templates", USER, fmt.Sprintf(body, true)); resp.Code != http.StatusForbidden {
+		t.Fatalf("Response code should be 403. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp := do(http.MethodPost, "/templates", "admin", fmt.Sprintf(body, true))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var shared structs.Template
+	json.Unmarshal(resp.Body.Bytes(), &shared)
+	resp = do(http.MethodPost, "/templates", USER, fmt.Sprintf(body, false))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var private structs.Template
+	json.Unmarshal(resp.Body.Bytes(), &private)
+	if private.UserId != USER || len(private.Messages) != 2 || private.Shared {
+		t.Fatalf("the template should be the caller's: %s", resp.Body)
+	}
+
+	resp = do(http.MethodGet, "/templates", "Miss_Take", "")
+	var listed []structs.Template
+	json.Unmarshal(resp.Body.Bytes(), &listed)
+	if resp.Code != 200 || len(listed) != 1 || listed[0].TemplateId != shared.TemplateId {
+		t.Fatalf("other users should only list the shared template. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodGet, "/templates/"+private.TemplateId.String(), "Miss_Take", ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404. It is: %v: %s", resp.Code, resp.Body)
+	}
+
+	// starting a conversation seeds it with the messages
+	resp = do(http.MethodPost, "/templates/"+shared.TemplateId.String()+"/conversations", "Miss_Take", "")
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Response code should be 201. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var convo structs.Conversation
+	json.Unmarshal(resp.Body.Bytes(), &convo)
+	if convo.UserId != "Miss_Take" || convo.Name != "Triage" {
+		t.Fatalf("the conversation should be the caller's, named after the template: %s", resp.Body)
+	}
+	resp = do(http.MethodGet, "/conversation/"+convo.ConversationId.String(), "Miss_Take", "")
+	var messages []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if resp.Code != 200 || len(messages) != 2 || messages[0].Role != structs.SystemRole || messages[1].Content != "What was flagged today?" {
+		t.Fatalf("the conversation should have the template's messages. Got %v: %s", resp.Code, resp.Body)
+	}
+	resp = do(http.MethodPost, "/templates/"+private.TemplateId.String()+"/conversations", USER, `{"name": "Monday"}`)
+	json.Unmarshal(resp.Body.Bytes(), &convo)
+	if resp.Code != http.StatusCreated || convo.Name != "Monday" {
+		t.Fatalf("the conversation should have the name given. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// only the owner changes and deletes them
+	update := `{"title": "Weekly triage", "messages": [{"role": "user", "content": "What was flagged this week?"}]}`
+	if resp := do(http.MethodPut, "/templates/"+private.TemplateId.String(), "Miss_Take", update); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404. It is: %v: %s", resp.Code, resp.Body)
+	}
+	resp = do(http.MethodPut, "/templates/"+private.TemplateId.String(), USER, update)
+	var updated structs.Template
+	json.Unmarshal(resp.Body.Bytes(), &updated)
+	if resp.Code != 200 || updated.Title != "Weekly triage" || len(updated.Messages) != 1 {
+		t.Fatalf("the template should be updated. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodDelete, "/templates/"+shared.TemplateId.String(), USER, ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("Response code should be 404. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := do(http.MethodDelete, "/templates/"+private.TemplateId.String(), USER, ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("Response code should be 204. It is: %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestCreateTemplate_Invalid(t *testing.T) {
+	store := setupDB(t, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /templates", CreateTemplate(store))
+	for name, body := range map[string]string{
+		"not json":    `{"title":`,
+		"no messages": `{"title": "t", "messages": []}`,
+		"bad role":    `{"title": "t", "messages": [{"role": "tool", "content": "hi"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/templates", strings.NewReader(body))
+			req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != http.StatusBadRequest {
+				t.Fatalf("Response code should be 400. It is: %v: %s", resp.Code, resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"chat-history/authn"
+	"chat-history/structs"
+	"chat-history/tenant"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTenants(t *testing.T) {
+	store := setupDB(t, false)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	withRoles := RequireRoles([]string{"globaldesigner"}, authn.NewTenants(resolve))
+	mux := http.NewServeMux()
+	mux.Handle("GET /user/{userId}", withRoles(GetUserConversations(store)))
+	mux.Handle("GET /conversation/{conversationId}", withRoles(GetConversation(store)))
+
+	acme := store.WithContext(tenant.NewContext(context.Background(), "acme"))
+	message := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Which accounts share a phone number?", Role: structs.UserRole}
+	convo, err := acme.CreateConversation(USER, "fraud rings", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, tenantId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth(USER, USER)
+		if tenantId != "" {
+			req.Header.Set(tenant.Header, tenantId)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	convoPath := "/conversation/" + convo.ConversationId.String()
+
+	if resp := get(convoPath, "acme"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), message.MessageId.String()) {
+		t.Fatalf("the tenant should get its conversation. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get("/user/"+USER, "acme"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), convo.ConversationId.String()) {
+		t.Fatalf("the tenant should list its conversation. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	// the same user in another tenant gets nothing, as for a conversation that doesn't exist
+	if resp := get(convoPath, "globex"); resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), message.MessageId.String()) {
+		t.Fatalf("another tenant shouldn't get the conversation. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get("/user/"+USER, "globex"); resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), convo.ConversationId.String()) {
+		t.Fatalf("another tenant shouldn't list the conversation. Got %v: %s", resp.Code, resp.Body)
+	}
+
+	if resp := get(convoPath, ""); resp.Code != http.StatusBadRequest {
+		t.Fatalf("a request without a tenant should be a 400. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get(convoPath, "ac me"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("a request with an invalid tenant should be a 400. Got %v: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestTenants_GetFeedback(t *testing.T) {
+	store := setupDB(t, false)
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	withRoles := RequireRoles([]string{"globaldesigner"}, authn.NewTenants(resolve))
+	// whoever can see every user's feedback, and whoever can only see their own
+	everyone := withRoles(GetFeedback(store, "", "", func() []string { return []string{"globaldesigner"} }))
+	own := withRoles(GetFeedback(store, "", "", func() []string { return []string{"superuser"} }))
+
+	acme := store.WithContext(tenant.NewContext(context.Background(), "acme"))
+	message := structs.Message{ConversationId: uuid.New(), MessageId: uuid.New(), Content: "Which accounts share a phone number?", Role: structs.UserRole}
+	if _, err := acme.CreateConversation(USER, "fraud rings", message); err != nil {
+		t.Fatal(err)
+	}
+	get := func(handler http.Handler, tenantId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/get_feedback", nil)
+		// as the RequireTenant middleware does
+		req = req.WithContext(tenant.Require(req.Context()))
+		req.SetBasicAuth(USER, USER)
+		if tenantId != "" {
+			req.Header.Set(tenant.Header, tenantId)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for name, handler := range map[string]http.Handler{"every user's": everyone, "their own": own} {
+		if resp := get(handler, "acme"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), message.MessageId.String()) {
+			t.Fatalf("the tenant should get %s feedback. Got %v: %s", name, resp.Code, resp.Body)
+		}
+		if resp := get(handler, "globex"); resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), message.MessageId.String()) {
+			t.Fatalf("another tenant shouldn't get %s feedback. Got %v: %s", name, resp.Code, resp.Body)
+		}
+		if resp := get(handler, ""); resp.Code != http.StatusBadRequest {
+			t.Fatalf("a request for %s feedback without a tenant should be a 400. Got %v: %s", name, resp.Code, resp.Body)
+		}
+	}
+
+	// without a tenant the store doesn't give out every tenant's messages
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/get_feedback", nil)
+	req = req.WithContext(authn.NewContext(tenant.Require(req.Context()), authn.Identity{UserId: USER, Roles: []string{"globaldesigner"}}))
+	GetFeedback(store, "", "", func() []string { return []string{"globaldesigner"} })(resp, req)
+	if resp.Code == http.StatusOK || strings.Contains(resp.Body.String(), message.MessageId.String()) {
+		t.Fatalf("a request without its tenant shouldn't get the feedback. Got %v: %s", resp.Code, resp.Body)
+	}
+}
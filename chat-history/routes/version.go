@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"chat-history/buildinfo"
+	"chat-history/config"
+	"net/http"
+)
+
+type versionResponse struct {
+	buildinfo.Info
+	Config versionConfig `json:"config"`
+}
+
+// versionConfig is what the service was configured with that's safe to show anyone: no hosts, keys or secrets
+type versionConfig struct {
+	SchemaVersion  int    `json:"schema_version"`
+	LLMProvider    string `json:"llm_provider,omitempty"`
+	LLMModel       string `json:"llm_model,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	AuthProvider   string `json:"auth_provider"`
+	// callers sign in with their TigerGraph credentials
+	TigerGraphAuth bool `json:"tigergraph_auth"`
+	ReadOnly       bool `json:"read_only"`
+	DevMode        bool `json:"dev_mode"`
+}
+
+// The build that's running and what it's configured with
+// "GET /version"
+// The version and commit are set when the binary is built, see buildinfo
+func Version(cfg config.Config) http.HandlerFunc {
+	resp := versionResponse{
+		Info: buildinfo.Get(),
+		Config: versionConfig{
+			SchemaVersion:  cfg.SchemaVersion,
+			LLMProvider:    cfg.LLMConfig.Provider,
+			LLMModel:       cfg.LLMConfig.ModelName,
+			EmbeddingModel: cfg.LLMConfig.EmbeddingModel,
+			AuthProvider:   cfg.AuthConfig.Provider,
+			TigerGraphAuth: cfg.AuthConfig.Provider == config.AuthTigerGraph,
+			ReadOnly:       cfg.ChatDbConfig.ReadOnly,
+			DevMode:        cfg.ChatDbConfig.DevMode,
+		},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		out, err := marshal(r, resp)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"chat-history/buildinfo"
+	"chat-history/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	version, commit := buildinfo.Version, buildinfo.Commit
+	buildinfo.Version, buildinfo.Commit = "v1.2.0", "0123abcd"
+	t.Cleanup(func() { buildinfo.Version, buildinfo.Commit = version, commit })
+
+	cfg := config.Config{
+		SchemaVersion: 3,
+		LLMConfig:     config.LLMConfig{Provider: "openai", ModelName: "gpt-4o", APIKeyEnv: "SECRET_KEY_ENV"},
+		AuthConfig:    config.AuthConfig{Provider: config.AuthTigerGraph},
+		ChatDbConfig:  config.ChatDbConfig{ReadOnly: true},
+		TgDbConfig:    config.TgDbConfig{Hostname: "http://tigergraph.internal", Password: "hunter2"},
+	}
+	w := httptest.NewRecorder()
+	Version(cfg)(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp versionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "v1.2.0" || resp.Commit != "0123abcd" || resp.GoVersion != runtime.Version() {
+		t.Fatalf("expected the injected build, got %+v", resp.Info)
+	}
+	want := versionConfig{SchemaVersion: 3, LLMProvider: "openai", LLMModel: "gpt-4o", AuthProvider: config.AuthTigerGraph, TigerGraphAuth: true, ReadOnly: true}
+	if resp.Config != want {
+		t.Fatalf("expected config %+v, got %+v", want, resp.Config)
+	}
+	for _, secret := range []string{"SECRET_KEY_ENV", "tigergraph.internal", "hunter2"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Fatalf("response shouldn't have %q: %s", secret, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/db"
+	"chat-history/structs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag is the ETag of a conversation at version
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// notModified is whether the client's copy of the conversation, from the ETag or Last-Modified of an earlier
+// response, is current. If-None-Match takes precedence over If-Modified-Since, Last-Modified is in seconds
+func notModified(r *http.Request, convo *structs.Conversation) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag(convo.Version) {
+				return true
+			}
+		}
+		return false
+	}
+	modifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !convo.UpdatedAt.Truncate(time.Second).After(modifiedSince)
+}
+
+// expectedVersion is the conversation version the client sent in If-Match, from the ETag of an earlier
+// response. It's db.AnyVersion if there's no If-Match or it's "*", so the write isn't conditional
+func expectedVersion(r *http.Request) (int, *apierror.APIError) {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" || match == "*" {
+		return db.AnyVersion, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+	if err != nil || version < 0 {
+		return 0, apierror.InvalidRequest("If-Match must be the ETag of the conversation")
+	}
+	return version, nil
+}
@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationVersions(t *testing.T) {
+	store := setupDB(t, true)
+	withRoles := RequireRoles([]string{"globaldesigner"}, fakeRoles(map[string][]string{USER: {"globaldesigner"}}))
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}", GetConversation(store))
+	mux.Handle("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+	mux.Handle("PUT /conversation/{conversationId}/messages/{messageId}", withRoles(EditMessage(store)))
+
+	do := func(method, path, ifMatch string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	appendMessage := func(ifMatch string) *httptest.ResponseRecorder {
+		msg, _ := json.Marshal(structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+		return do(http.MethodPost, "/conversation", ifMatch, strings.NewReader(string(msg)))
+	}
+
+	resp := do(http.MethodGet, "/conversation/"+CONVO_ID, "", nil)
+	read := resp.Header().Get("ETag")
+	if resp.Code != 200 || read == "" {
+		t.Fatalf("the conversation should be returned with an ETag. Got %v, %q", resp.Code, read)
+	}
+	var messages []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	msgPath := fmt.Sprintf("/conversation/%s/messages/%s", CONVO_ID, messages[0].MessageId)
+
+	// a write at the version that was read goes through and returns the new ETag
+	resp = appendMessage(read)
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	appended := resp.Header().Get("ETag")
+	if appended == "" || appended == read {
+		t.Fatalf("the response should have the new ETag. Got %q", appended)
+	}
+	resp = do(http.MethodPut, msgPath, appended, strings.NewReader(`{"content":"fixed typo"}`))
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	edited := resp.Header().Get("ETag")
+	if got := do(http.MethodGet, "/conversation/"+CONVO_ID, "", nil).Header().Get("ETag"); edited == "" || got != edited {
+		t.Fatalf("the edit should return the conversation's ETag %q. Got %q", got, edited)
+	}
+
+	// writes at the version read at first conflict
+	for name, resp := range map[string]*httptest.ResponseRecorder{
+		"append": appendMessage(read),
+		"edit":   do(http.MethodPut, msgPath, read, strings.NewReader(`{"content":"overwritten"}`)),
+	} {
+		var body apierror.APIError
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != http.StatusConflict || body.Code != apierror.CodeConflict {
+			t.Fatalf("the stale %s should respond 409 conflict. It is: %v: %s", name, resp.Code, resp.Body)
+		}
+	}
+	resp = do(http.MethodGet, "/conversation/"+CONVO_ID, "", nil)
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if resp.Header().Get("ETag") != edited || messages[0].Content != "fixed typo" {
+		t.Fatalf("the conflicting writes shouldn't change the conversation: %s", resp.Body)
+	}
+
+	// without If-Match, writes aren't conditional
+	if resp := appendMessage(""); resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	if resp := appendMessage(`"abc"`); resp.Code != 400 {
+		t.Fatalf("an If-Match that isn't an ETag should be rejected. It is: %v", resp.Code)
+	}
+}
+
+func TestGetConversation_Conditional(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}", GetConversation(store))
+	mux.Handle("POST /conversation", UpdateConversation(store, nil, config.LLMConfig{}, time.Hour, nil))
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/conversation/"+CONVO_ID, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("", "")
+	tag, lastModified := resp.Header().Get("ETag"), resp.Header().Get("Last-Modified")
+	if resp.Code != 200 || tag == "" || lastModified == "" {
+		t.Fatalf("the conversation should be returned with an ETag and Last-Modified. Got %v, %q, %q", resp.Code, tag, lastModified)
+	}
+	for _, tt := range []struct{ header, value string }{
+		{"If-None-Match", tag},
+		{"If-None-Match", `"999", W/` + tag},
+		{"If-Modified-Since", lastModified},
+	} {
+		resp := get(tt.header, tt.value)
+		if resp.Code != http.StatusNotModified || resp.Body.Len() != 0 || resp.Header().Get("ETag") != tag {
+			t.Fatalf("%s: %s should be 304 with no body while it's unchanged. It is: %v: %s", tt.header, tt.value, resp.Code, resp.Body)
+		}
+	}
+	if resp := get("If-None-Match", `"999"`); resp.Code != 200 {
+		t.Fatalf("another ETag should get the conversation. Response code is: %v", resp.Code)
+	}
+
+	// changed
+	time.Sleep(time.Second)
+	msg, _ := json.Marshal(structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), Content: "Hi", Role: structs.UserRole})
+	req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(string(msg)))
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	for _, tt := range []struct{ header, value string }{
+		{"If-None-Match", tag},
+		{"If-Modified-Since", lastModified},
+	} {
+		if resp := get(tt.header, tt.value); resp.Code != 200 || resp.Header().Get("ETag") == tag {
+			t.Fatalf("%s: the changed conversation should be returned with a new ETag. It is: %v, %q", tt.header, resp.Code, resp.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestGetConversation_Since(t *testing.T) {
+	store := setupDB(t, true)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversation/{conversationId}", GetConversation(store))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/conversation/"+CONVO_ID+query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp
+	}
+	var all []structs.Message
+	json.Unmarshal(get("").Body.Bytes(), &all)
+	last := all[len(all)-1]
+
+	// polling with the update_ts of the last message gets only what came after it
+	reply := structs.Message{ConversationId: uuid.MustParse(CONVO_ID), MessageId: uuid.New(), ParentId: &last.MessageId, Content: "new", Role: structs.SystemRole}
+	if _, err := store.AppendMessage(reply, db.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	resp := get("?since=" + url.QueryEscape(last.UpdatedAt.Format(time.RFC3339Nano)))
+	if resp.Code != 200 {
+		t.Fatalf("Response code should be 200. It is: %v: %s", resp.Code, resp.Body)
+	}
+	var messages []structs.Message
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if len(messages) != 1 || messages[0].MessageId != reply.MessageId {
+		t.Fatalf("only the new message should be returned. Got: %s", resp.Body)
+	}
+
+	resp = get("?since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	if resp.Code != 200 || strings.TrimSpace(resp.Body.String()) != "[]" {
+		t.Fatalf("nothing is newer than the future. Got %v: %s", resp.Code, resp.Body)
+	}
+	if resp := get("?since=yesterday"); resp.Code != 400 {
+		t.Fatalf("Response code should be 400. It is: %v", resp.Code)
+	}
+}
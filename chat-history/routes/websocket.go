@@ -0,0 +1,262 @@
+package routes
+
+import (
+	"bytes"
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/llm"
+	"chat-history/middleware"
+	"chat-history/structs"
+	"chat-history/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how many of the client's messages can wait for the reply being generated. More are rejected
+const maxQueuedMessages = 8
+
+// socketMessage is what a WebSocket client sends, the content of the user's next message
+type socketMessage struct {
+	Content string `json:"content"`
+	// the new message's id, one is chosen if it's left out
+	MessageId uuid.UUID `json:"message_id"`
+}
+
+// sameOrigin reports whether origin is the host r was sent to, i.e., the page is served by this server
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// socketEvent is what's sent to a WebSocket client, an event of StreamConversation with its data
+type socketEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// Chat in a conversation over a WebSocket, for realtime clients
+// "GET /conversations/{conversationId}/ws"
+// The client sends the user's messages as JSON text messages, {"content": "...", "message_id": "..."}, where
+// message_id can be left out. Each is added to the end of the conversation's latest branch, a "conversation"
+// event is sent with the conversation and a "message" event with the stored message, and then the assistant's
+// reply is streamed with the events of StreamConversation. Events are JSON text messages,
+// {"type": "chunk", "data": {"content": "..."}}. A message that can't be added is an "error" event with the
+// API error, and the connection stays open for the next one.
+// The caller needs write access to the conversation, which is checked again for every message. Messages sent
+// while a reply is streamed wait for it. Only one reply to a conversation is generated at a time: a message sent
+// while one is being generated by another connection or request is a generation_in_progress error.
+// A reply gets replyTimeout, or 10 minutes if it's 0, and is cancelled when the connection closes.
+// A user sending the same content to the conversation again, on any connection, while the reply to it is generated
+// or within dedupWindow of it being done, as clients that reconnected do, isn't added again: once the reply is done
+// they get its "conversation", "message" and "done" events. A reply that was cut off is sent in an "incomplete"
+// event followed by an "error" one, it can be resumed (see ResumeStream).
+// Browsers don't keep WebSockets to their page's origin, so a handshake with an Origin that isn't the server's own
+// or one of allowedOrigins (see middleware.CORS) is refused with forbidden.
+// Each message takes a token from the user's bucket of limiter, as a write request does, one sent without any left is
+// a rate_limited error. limiter can be nil
+// The sockets are kept in sockets, which can be nil: once it shuts down, the reply being streamed is cut off as an
+// "incomplete" event followed by an unavailable error, the connection is closed as going away, and handshakes are
+// refused with unavailable
+func ConversationSocket(store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig, replyTimeout, dedupWindow time.Duration, allowedOrigins []string, limiter *middleware.RateLimiter, sockets *Sockets) http.HandlerFunc {
+	if replyTimeout <= 0 || replyTimeout > maxGeneration {
+		replyTimeout = maxGeneration
+	}
+	dedup := newReplyDedup(dedupWindow)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(r, origin) && !middleware.AllowedOrigin(allowedOrigins, origin) {
+			writeError(w, apierror.Forbidden(fmt.Sprintf("origin %s is not allowed", origin)))
+			return
+		}
+		// the connection outlives the request's timeout, every reply has one of its own
+		ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+		defer cancel(nil)
+		done, ok := sockets.open(cancel)
+		if !ok {
+			writeError(w, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "the server is shutting down"))
+			return
+		}
+		defer done()
+		store := store.WithContext(ctx)
+		if _, ok := repliedConversation(w, r, store, llmClient); !ok {
+			return
+		}
+		conn, err := websocket.Upgrade(w, r)
+		if errors.Is(err, websocket.ErrHandshake) {
+			writeError(w, apierror.InvalidRequest(err.Error()))
+			return
+		} else if err != nil {
+			slog.Error("failed to upgrade to a WebSocket", "err", err)
+			return
+		}
+		defer func() {
+			if errors.Is(context.Cause(ctx), errShuttingDown) {
+				conn.Close(websocket.CloseGoingAway, errShuttingDown.Error())
+			} else {
+				conn.Close(websocket.CloseNormal, "")
+			}
+		}()
+
+		send := func(event string, v any) error {
+			out, err := marshalLine(r, socketEvent{Type: event, Data: v})
+			if err != nil {
+				return err
+			}
+			if err := conn.WriteText(out); err != nil {
+				// the client is gone, stop the reply
+				cancel(nil)
+				return err
+			}
+			return nil
+		}
+
+		// messages are read while a reply is streamed, so it's cancelled as soon as the client closes
+		incoming := make(chan []byte, maxQueuedMessages)
+		go func() {
+			defer close(incoming)
+			defer cancel(nil)
+			for {
+				data, err := conn.Read()
+				if err != nil {
+					return
+				}
+				select {
+				case incoming <- data:
+				default:
+					send("error", apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "too many messages are waiting for the reply, send them once it's done"))
+				}
+			}
+		}()
+
+		for {
+			var data []byte
+			select {
+			case data, ok = <-incoming:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if limiter != nil {
+				if ok, wait := limiter.AllowRequest(r); !ok {
+					send("error", apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, fmt.Sprintf("too many messages, send the next one in %.0fs", math.Ceil(wait.Seconds()))))
+					continue
+				}
+			}
+			if apiErr := socketReply(ctx, r, store, llmClient, llmCfg, replyTimeout, dedup, data, send); apiErr != nil {
+				send("error", apiErr)
+			}
+		}
+	}
+}
+
+// socketReply adds the message the client sent to the conversation and streams the reply to it with send
+func socketReply(ctx context.Context, r *http.Request, store db.ConversationStore, llmClient llm.Client, llmCfg config.LLMConfig, replyTimeout time.Duration, dedup *replyDedup, data []byte, send func(event string, v any) error) *apierror.APIError {
+	conversationId := r.PathValue("conversationId")
+	var msg socketMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&msg); err != nil {
+		return apierror.InvalidRequest(`messages must be JSON like {"content": "..."}: ` + decodeError(err))
+	}
+	if msg.MessageId == uuid.Nil {
+		msg.MessageId = uuid.New()
+	}
+
+	// the access is checked again, it can be taken away while the connection is open
+	userId, _ := caller(r)
+	convo, err := store.FindConversation(conversationId)
+	if err != nil {
+		return storeError(err, fmt.Sprintf("conversation %s not found", conversationId), "failed to retrieve conversation")
+	}
+	if !allowed(r, store, userId, convo, structs.PermissionWrite) {
+		return apierror.Forbidden(fmt.Sprintf("%s is not authorized to update conversation %s", userId, conversationId))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, replyTimeout)
+	defer cancel()
+	key := newDedupKey(convo.ConversationId, userId, msg.Content)
+	entry, first := dedup.start(key)
+	for !first {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return apierror.Timeout("the reply to the same message took too long")
+		}
+		if entry.result != nil {
+			return replayReply(entry.result, send)
+		}
+		// the first one wasn't added, this one is
+		entry, first = dedup.start(key)
+	}
+	var result *replyResult
+	defer func() { dedup.finish(key, entry, result) }()
+
+	expires, _ := ctx.Deadline()
+	unlock, ok := generating.lock(convo.ConversationId, expires)
+	if !ok {
+		return errGenerating(conversationId)
+	}
+	defer unlock()
+
+	messages, err := store.GetConversation(convo.UserId, conversationId)
+	if err != nil {
+		return apierror.Internal("failed to retrieve conversation")
+	}
+	message := structs.Message{ConversationId: convo.ConversationId, MessageId: msg.MessageId, Content: msg.Content, Role: structs.UserRole}
+	if history := mergeConversationHistory(messages); len(history) > 0 {
+		message.ParentId = &history[len(history)-1].MessageId
+	}
+	updated, err := store.AppendMessage(message, db.AnyVersion)
+	if err != nil {
+		return storeError(err, "", "failed to update conversation")
+	}
+	// read back, the store can change the content (see chat_config.maxMessageChars)
+	messages, err = store.GetConversation(convo.UserId, conversationId)
+	if err != nil {
+		return apierror.Internal("failed to retrieve conversation")
+	}
+	history := mergeConversationHistory(messages)
+	result = &replyResult{conversation: updated, message: history[len(history)-1]}
+	send("conversation", result.conversation)
+	send("message", result.message)
+
+	llmCfg = conversationModel(llmCfg, convo)
+	reply := structs.Message{
+		ConversationId: convo.ConversationId,
+		MessageId:      uuid.New(),
+		ParentId:       &message.MessageId,
+		ModelName:      llmCfg.ModelName,
+		Role:           structs.SystemRole,
+	}
+	result.reply = generateReply(ctx, store, llmClient, llmCfg, llm.TruncateHistory(llmCfg, withSystemPrompt(llmCfg, convo, llmMessages(history))), reply, send)
+	return nil
+}
+
+// replayReply sends the events of the reply to a message that was sent again with send, see ConversationSocket
+func replayReply(result *replyResult, send func(event string, v any) error) *apierror.APIError {
+	send("conversation", result.conversation)
+	send("message", result.message)
+	switch {
+	case result.reply == nil:
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("the reply to message %s failed, stream one with POST /conversations/%s/stream", result.message.MessageId, result.message.ConversationId))
+	case result.reply.Incomplete:
+		send("incomplete", result.reply)
+		return apierror.New(http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("the reply to message %s was cut off, resume it with POST /conversations/%s/messages/%s/resume", result.message.MessageId, result.message.ConversationId, result.reply.MessageId))
+	}
+	send("done", result.reply)
+	return nil
+}
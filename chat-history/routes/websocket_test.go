@@ -0,0 +1,434 @@
+package routes
+
+import (
+	"chat-history/apierror"
+	"chat-history/config"
+	"chat-history/db"
+	"chat-history/middleware"
+	"chat-history/structs"
+	"chat-history/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// startSocket serves ConversationSocket behind RequireRoles and the request timeout, which the connection outlives
+func startSocket(t *testing.T, store db.ConversationStore, llmClient *streamingLLM) string {
+	t.Helper()
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}, "Miss_Take": {"globaldesigner"}})
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversations/{conversationId}/ws", RequireRoles([]string{"globaldesigner"}, resolve)(ConversationSocket(store, llmClient, config.LLMConfig{}, time.Minute, 0, []string{"https://app.example.com"}, nil, nil)))
+	srv := httptest.NewServer(middleware.ChainMiddleware(mux, middleware.Timeout(100*time.Millisecond)))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func dialSocket(t *testing.T, base, user, conversationId string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	header := http.Header{}
+	if user != "" {
+		header.Set("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(user, PASS)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, resp, err := websocket.Dial(ctx, fmt.Sprintf("%s/conversations/%s/ws", base, conversationId), header)
+	if err == nil {
+		t.Cleanup(func() { conn.Close(websocket.CloseNormal, "") })
+	}
+	return conn, resp, err
+}
+
+// readSocketEvent reads the next event, failing if it takes longer than a second
+func readSocketEvent(t *testing.T, conn *websocket.Conn) (string, json.RawMessage) {
+	t.Helper()
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := conn.Read()
+		done <- result{data, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("failed to read an event: %v", res.err)
+		}
+		var event struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(res.data, &event); err != nil {
+			t.Fatalf("events should be JSON: %s", res.data)
+		}
+		return event.Type, event.Data
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return "", nil
+}
+
+func TestConversationSocket(t *testing.T) {
+	store := setupStreamDB(t)
+	llmClient := newStreamingLLM()
+	base := startSocket(t, store, llmClient)
+	conn, _, err := dialSocket(t, base, USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past the request timeout, the connection is still open
+	time.Sleep(200 * time.Millisecond)
+
+	if err := conn.WriteText([]byte(`{"content": "How many accounts?"}`)); err != nil {
+		t.Fatal(err)
+	}
+	event, data := readSocketEvent(t, conn)
+	var convo structs.Conversation
+	json.Unmarshal(data, &convo)
+	if event != "conversation" || convo.ConversationId.String() != CONVO_ID {
+		t.Fatalf("the conversation should be sent once the message is added. Got %s: %s", event, data)
+	}
+	event, data = readSocketEvent(t, conn)
+	var question structs.Message
+	json.Unmarshal(data, &question)
+	if event != "message" || question.Content != "How many accounts?" || question.Role != structs.UserRole || question.ParentId == nil {
+		t.Fatalf("the stored message should be sent. Got %s: %s", event, data)
+	}
+
+	for _, chunk := range []string{"There are ", "42 accounts"} {
+		llmClient.chunks <- chunk
+		if event, data := readSocketEvent(t, conn); event != "chunk" || !strings.Contains(string(data), chunk) {
+			t.Fatalf("each chunk should be sent as it arrives. Got %s: %s", event, data)
+		}
+	}
+	close(llmClient.chunks)
+	event, data = readSocketEvent(t, conn)
+	var reply structs.Message
+	json.Unmarshal(data, &reply)
+	if event != "done" || reply.Content != "There are 42 accounts" || *reply.ParentId != question.MessageId {
+		t.Fatalf("the reply to the message should be sent once it's saved. Got %s: %s", event, data)
+	}
+	// the LLM was sent the history with the new message
+	if last := llmClient.messages[len(llmClient.messages)-1]; last.Content != "How many accounts?" || len(llmClient.messages) != 3 {
+		t.Fatalf("the LLM should get the history ending with the new message: %+v", llmClient.messages)
+	}
+	messages, _ := store.GetConversation(USER, CONVO_ID)
+	if history := mergeConversationHistory(messages); history[len(history)-1].MessageId != reply.MessageId {
+		t.Fatalf("the reply should be the latest message: %+v", history)
+	}
+
+	// errors are events, the connection stays open
+	if err := conn.WriteText([]byte(`{"text": "hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr apierror.APIError
+	event, data = readSocketEvent(t, conn)
+	json.Unmarshal(data, &apiErr)
+	if event != "error" || apiErr.Code != apierror.CodeInvalidRequest {
+		t.Fatalf("a message that isn't like {\"content\": ...} should be an error. Got %s: %s", event, data)
+	}
+	unlock, _ := generating.lock(uuid.MustParse(CONVO_ID), time.Now().Add(time.Minute))
+	conn.WriteText([]byte(`{"content": "and now?"}`))
+	event, data = readSocketEvent(t, conn)
+	json.Unmarshal(data, &apiErr)
+	unlock()
+	if event != "error" || apiErr.Code != apierror.CodeGenerationInProgress {
+		t.Fatalf("a message while another reply is generated should be a conflict. Got %s: %s", event, data)
+	}
+	if after, _ := store.GetConversation(USER, CONVO_ID); len(after) != len(messages) {
+		t.Fatalf("rejected messages shouldn't be stored. There were %d messages, now %d", len(messages), len(after))
+	}
+}
+
+func TestConversationSocket_RateLimit(t *testing.T) {
+	store := setupStreamDB(t)
+	llmClient := newStreamingLLM()
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	// a token for the first message, and none soon after
+	limiter := middleware.NewRateLimiter(0.001, 1)
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversations/{conversationId}/ws", RequireRoles([]string{"globaldesigner"}, resolve)(ConversationSocket(store, llmClient, config.LLMConfig{}, time.Minute, 0, nil, limiter, nil)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	conn, _, err := dialSocket(t, srv.URL, USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := store.GetConversation(USER, CONVO_ID)
+
+	conn.WriteText([]byte(`{"content": "How many accounts?"}`))
+	for _, want := range []string{"conversation", "message"} {
+		if event, data := readSocketEvent(t, conn); event != want {
+			t.Fatalf("the first message should be added. Got %s: %s", event, data)
+		}
+	}
+	llmClient.chunks <- "42"
+	readSocketEvent(t, conn)
+	close(llmClient.chunks)
+	if event, data := readSocketEvent(t, conn); event != "done" {
+		t.Fatalf("the first message should be replied to. Got %s: %s", event, data)
+	}
+
+	// every message takes a token, like a write request
+	conn.WriteText([]byte(`{"content": "and the next?"}`))
+	var apiErr apierror.APIError
+	event, data := readSocketEvent(t, conn)
+	json.Unmarshal(data, &apiErr)
+	if event != "error" || apiErr.Code != apierror.CodeRateLimited {
+		t.Fatalf("a message over the user's limit should be rate limited. Got %s: %s", event, data)
+	}
+	if after, _ := store.GetConversation(USER, CONVO_ID); len(after) != len(before)+2 {
+		t.Fatalf("only the first message and its reply should be stored. There were %d messages, now %d", len(before), len(after))
+	}
+	// it's the bucket the user's write requests take from
+	req := httptest.NewRequest(http.MethodPost, "/conversation", nil)
+	req.SetBasicAuth(USER, PASS)
+	if ok, _ := limiter.AllowRequest(req); ok {
+		t.Fatal("the socket's messages should count against the user's write requests")
+	}
+}
+
+func TestConversationSocket_Reconnect(t *testing.T) {
+	store := setupStreamDB(t)
+	llmClient := newStreamingLLM()
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversations/{conversationId}/ws", RequireRoles([]string{"globaldesigner"}, resolve)(ConversationSocket(store, llmClient, config.LLMConfig{}, time.Minute, 300*time.Millisecond, nil, nil, nil)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	dial := func() *websocket.Conn {
+		conn, _, err := dialSocket(t, srv.URL, USER, CONVO_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	// the events of a message up to the reply, which is returned. started skips the first two, that were read
+	readReply := func(conn *websocket.Conn, started bool) structs.Message {
+		t.Helper()
+		if !started {
+			for _, want := range []string{"conversation", "message"} {
+				if event, data := readSocketEvent(t, conn); event != want {
+					t.Fatalf("expected a %s event. Got %s: %s", want, event, data)
+				}
+			}
+		}
+		for {
+			event, data := readSocketEvent(t, conn)
+			if event == "chunk" {
+				continue
+			}
+			var reply structs.Message
+			json.Unmarshal(data, &reply)
+			if event != "done" {
+				t.Fatalf("expected a done event. Got %s: %s", event, data)
+			}
+			return reply
+		}
+	}
+	asked := func() int {
+		messages, _ := store.GetConversation(USER, CONVO_ID)
+		n := 0
+		for _, m := range messages {
+			if m.Content == "How many accounts?" {
+				n++
+			}
+		}
+		return n
+	}
+	question := []byte(`{"content": "How many accounts?"}`)
+
+	first := dial()
+	first.WriteText(question)
+	readSocketEvent(t, first)
+	readSocketEvent(t, first)
+	llmClient.chunks <- "There are "
+	readSocketEvent(t, first)
+
+	// the client reconnects and sends the message again while the reply is generated, it waits for it
+	second := dial()
+	second.WriteText(question)
+	time.Sleep(50 * time.Millisecond)
+	llmClient.chunks <- "42 accounts"
+	close(llmClient.chunks)
+	reply := readReply(first, true)
+	if replayed := readReply(second, false); replayed.MessageId != reply.MessageId || replayed.Content != "There are 42 accounts" {
+		t.Fatalf("the second connection should get the first reply. Got %+v, want %+v", replayed, reply)
+	}
+	// and right after it's done
+	second.WriteText(question)
+	if replayed := readReply(second, false); replayed.MessageId != reply.MessageId {
+		t.Fatalf("the message sent again should get the reply. Got %+v", replayed)
+	}
+	if n := asked(); n != 1 {
+		t.Fatalf("the message should be added once, it was added %d times", n)
+	}
+
+	// after the window it's a new message, asked again
+	time.Sleep(350 * time.Millisecond)
+	llmClient.chunks = make(chan string)
+	second.WriteText(question)
+	go func() {
+		llmClient.chunks <- "Still 42"
+		close(llmClient.chunks)
+	}()
+	if again := readReply(second, false); again.MessageId == reply.MessageId || again.Content != "Still 42" {
+		t.Fatalf("a repeat after the window should get a new reply. Got %+v", again)
+	}
+	if n := asked(); n != 2 {
+		t.Fatalf("the repeat should be added, the message is there %d times", n)
+	}
+}
+
+func TestConversationSocket_Disconnect(t *testing.T) {
+	store := setupStreamDB(t)
+	llmClient := newStreamingLLM()
+	conn, _, err := dialSocket(t, startSocket(t, store, llmClient), USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteText([]byte(`{"content": "How many accounts?"}`))
+	readSocketEvent(t, conn)
+	readSocketEvent(t, conn)
+	llmClient.chunks <- "There are"
+	readSocketEvent(t, conn)
+
+	// closing the connection cancels the reply, and what there was of it is saved
+	conn.Close(websocket.CloseGoingAway, "")
+	select {
+	case <-llmClient.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the LLM request should be cancelled when the client disconnects")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		messages, _ := store.GetConversation(USER, CONVO_ID)
+		history := mergeConversationHistory(messages)
+		if last := history[len(history)-1]; last.Incomplete && last.Content == "There are" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the incomplete reply should be saved: %+v", history[len(history)-1])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConversationSocket_Shutdown(t *testing.T) {
+	store := setupStreamDB(t)
+	llmClient := newStreamingLLM()
+	resolve := fakeRoles(map[string][]string{USER: {"globaldesigner"}})
+	sockets := NewSockets()
+	handler := RequireRoles([]string{"globaldesigner"}, resolve)(ConversationSocket(store, llmClient, config.LLMConfig{}, time.Minute, 0, nil, nil, sockets))
+	mux := http.NewServeMux()
+	mux.Handle("GET /conversations/{conversationId}/ws", handler)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.RegisterOnShutdown(sockets.Shutdown)
+	srv.Start()
+	defer srv.Close()
+	conn, _, err := dialSocket(t, srv.URL, USER, CONVO_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteText([]byte(`{"content": "How many accounts?"}`))
+	readSocketEvent(t, conn)
+	readSocketEvent(t, conn)
+	llmClient.chunks <- "There are"
+	readSocketEvent(t, conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the reply is cut off and the client told why, then to go away
+	if event, data := readSocketEvent(t, conn); event != "incomplete" {
+		t.Fatalf("the reply should be sent as incomplete. Got %s: %s", event, data)
+	}
+	var apiErr apierror.APIError
+	event, data := readSocketEvent(t, conn)
+	json.Unmarshal(data, &apiErr)
+	if event != "error" || apiErr.Code != apierror.CodeUnavailable {
+		t.Fatalf("the client should be told the server is shutting down. Got %s: %s", event, data)
+	}
+	var closeErr *websocket.CloseError
+	if _, err := conn.Read(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("the connection should be closed as going away, got %v", err)
+	}
+	if err := sockets.Wait(ctx); err != nil {
+		t.Fatalf("the socket's handler should return: %v", err)
+	}
+	// before the store is closed, the reply is saved
+	messages, _ := store.GetConversation(USER, CONVO_ID)
+	history := mergeConversationHistory(messages)
+	if last := history[len(history)-1]; !last.Incomplete || last.Content != "There are" {
+		t.Fatalf("the incomplete reply should be saved: %+v", last)
+	}
+
+	// no socket is opened once it's shutting down
+	req := httptest.NewRequest(http.MethodGet, "/conversations/"+CONVO_ID+"/ws", nil)
+	req.SetBasicAuth(USER, PASS)
+	req.SetPathValue("conversationId", CONVO_ID)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("a handshake during shutdown should be refused with 503, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestConversationSocket_Errors(t *testing.T) {
+	store := setupStreamDB(t)
+	base := startSocket(t, store, newStreamingLLM())
+	for name, tt := range map[string]struct {
+		user, conversationId string
+		code                 int
+	}{
+		"no credentials": {"", CONVO_ID, http.StatusUnauthorized},
+		"other user":     {"Miss_Take", CONVO_ID, http.StatusForbidden},
+		"not found":      {USER, uuid.NewString(), http.StatusNotFound},
+	} {
+		_, resp, err := dialSocket(t, base, tt.user, tt.conversationId)
+		if !errors.Is(err, websocket.ErrHandshake) || resp.StatusCode != tt.code {
+			t.Fatalf("%s: the handshake should be refused with %d. Got %v", name, tt.code, err)
+		}
+	}
+
+	// browsers send their page's origin, only the allowed ones and the server's own can connect
+	for origin, code := range map[string]int{"https://evil.example.com": http.StatusForbidden, "https://app.example.com": http.StatusSwitchingProtocols, base: http.StatusSwitchingProtocols} {
+		header := http.Header{}
+		header.Set("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+		header.Set("Origin", origin)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		conn, resp, err := websocket.Dial(ctx, fmt.Sprintf("%s/conversations/%s/ws", base, CONVO_ID), header)
+		cancel()
+		if resp == nil || resp.StatusCode != code {
+			t.Fatalf("a handshake from %s should get %d. Got %v, %v", origin, code, resp, err)
+		}
+		if err == nil {
+			conn.Close(websocket.CloseNormal, "")
+		} else if !errors.Is(err, websocket.ErrHandshake) {
+			t.Fatal(err)
+		}
+	}
+
+	// a plain request isn't upgraded
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/conversations/%s/ws", base, CONVO_ID), nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", basicAuthSetup(USER, PASS)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("a request without the WebSocket handshake should be a 400. It's %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer serves handler on a random port until the returned cancel func is called
+func startServer(t *testing.T, handler http.Handler, grace time.Duration) (string, context.CancelFunc, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, &http.Server{Handler: handler}, ln, grace)
+	}()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(`{"status":"OK"}`))
+	})
+	url, shutdown, done := startServer(t, handler, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		results <- result{string(b), err}
+	}()
+
+	// shut down while the request is being handled
+	<-started
+	shutdown()
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("the in-flight request should complete. It failed with: %v", res.err)
+	}
+	if res.body != `{"status":"OK"}` {
+		t.Fatalf("body should be {\"status\":\"OK\"}. It's: %s", res.body)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve should return nil after a clean shutdown. It returned: %v", err)
+	}
+
+	// no new connections after shutdown
+	if _, err := http.Get(url); err == nil {
+		t.Fatal("the server should not accept requests after shutdown")
+	}
+}
+
+func TestServe_GracePeriodExceeded(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	url, shutdown, done := startServer(t, handler, 50*time.Millisecond)
+
+	go http.Get(url)
+	<-started
+	shutdown()
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Serve should return DeadlineExceeded when requests outlive the grace period. It returned: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package startup checks what the service depends on before it takes requests, so a misconfigured
+// deployment exits at once, with a code that says what's wrong, instead of failing its first request
+package startup
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// the exit codes of the checks by what failed. A panic exits with 2 as well, so nothing at startup panics
+const (
+	ExitConfig     = 2
+	ExitDatabase   = 3
+	ExitTigerGraph = 4
+	ExitLLM        = 5
+	// a log file couldn't be opened or the port listened on
+	ExitIO = 6
+)
+
+// Sequence runs the checks one after the other. Once one fails the process exits, and if exit returns,
+// like in tests, the checks after it aren't run
+type Sequence struct {
+	log     *slog.Logger
+	exit    func(code int)
+	timeout time.Duration
+	failed  bool
+}
+
+// New returns a Sequence that logs to log, or to slog.Default() at the time if it's nil, and exits with exit.
+// Each check gets timeout to finish
+func New(log *slog.Logger, exit func(code int), timeout time.Duration) *Sequence {
+	return &Sequence{log: log, exit: exit, timeout: timeout}
+}
+
+// Check runs fn, logs whether it passed and how long it took, and exits with code if it didn't.
+// It returns whether it passed
+func (s *Sequence) Check(name string, code int, fn func(ctx context.Context) error) bool {
+	if s.failed {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	start := time.Now()
+	if err := fn(ctx); err != nil {
+		s.failed = true
+		s.logger().Error("startup check failed", "check", name, "err", err, "exit_code", code)
+		s.exit(code)
+		return false
+	}
+	s.logger().Info("startup check passed", "check", name, "duration", time.Since(start))
+	return true
+}
+
+// Skip logs that the check isn't run, and why
+func (s *Sequence) Skip(name, reason string) {
+	if !s.failed {
+		s.logger().Warn("startup check skipped", "check", name, "reason", reason)
+	}
+}
+
+// Warmup runs the steps at once, so the tokens and connections the first requests would wait for are ready
+// before they come in. It waits for all of them, each gets the Sequence's timeout. A step that fails is only
+// logged, the service works without it
+func (s *Sequence) Warmup(steps map[string]func(ctx context.Context) error) {
+	if s.failed {
+		return
+	}
+	var wg sync.WaitGroup
+	for name, fn := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+			defer cancel()
+			start := time.Now()
+			if err := fn(ctx); err != nil {
+				s.logger().Warn("warm-up failed", "step", name, "err", err)
+				return
+			}
+			s.logger().Info("warmed up", "step", name, "duration", time.Since(start))
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Sequence) logger() *slog.Logger {
+	if s.log == nil {
+		return slog.Default()
+	}
+	return s.log
+}
@@ -0,0 +1,114 @@
+package startup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sequence returns a Sequence logging to the buffer, and the codes it exited with
+func sequence(timeout time.Duration) (*Sequence, *bytes.Buffer, *[]int) {
+	var logs bytes.Buffer
+	codes := &[]int{}
+	s := New(slog.New(slog.NewTextHandler(&logs, nil)), func(code int) { *codes = append(*codes, code) }, timeout)
+	return s, &logs, codes
+}
+
+func TestSequence(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name   string
+		failAt string
+		code   int
+	}{
+		{"everything is up", "", 0},
+		{"bad config", "config", ExitConfig},
+		{"database is down", "database", ExitDatabase},
+		{"tigergraph is down", "tigergraph", ExitTigerGraph},
+		{"llm rejects the key", "llm", ExitLLM},
+		{"port is taken", "listen", ExitIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, logs, codes := sequence(time.Second)
+			var ran []string
+			failed := false
+			for _, check := range []struct {
+				name string
+				code int
+			}{{"config", ExitConfig}, {"database", ExitDatabase}, {"tigergraph", ExitTigerGraph}, {"llm", ExitLLM}, {"listen", ExitIO}} {
+				fn := ok
+				if check.name == tt.failAt {
+					fn = down
+				}
+				passed := s.Check(check.name, check.code, func(ctx context.Context) error {
+					ran = append(ran, check.name)
+					return fn(ctx)
+				})
+				failed = failed || check.name == tt.failAt
+				if passed == failed {
+					t.Fatalf("%s should pass only if no check has failed, it returned %v", check.name, passed)
+				}
+			}
+
+			if tt.failAt == "" {
+				if len(*codes) != 0 || len(ran) != 5 {
+					t.Fatalf("every check should pass without exiting, ran %v and exited with %v", ran, *codes)
+				}
+				if n := strings.Count(logs.String(), "startup check passed"); n != 5 {
+					t.Fatalf("every check should be logged, %d are:\n%s", n, logs)
+				}
+				return
+			}
+			if len(*codes) != 1 || (*codes)[0] != tt.code {
+				t.Fatalf("expected to exit once with %d, exited with %v", tt.code, *codes)
+			}
+			if ran[len(ran)-1] != tt.failAt {
+				t.Fatalf("the checks after %s shouldn't run, ran %v", tt.failAt, ran)
+			}
+			if !strings.Contains(logs.String(), "check="+tt.failAt) || !strings.Contains(logs.String(), "connection refused") {
+				t.Fatalf("the failed check should be logged with its error:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestSequence_Timeout(t *testing.T) {
+	s, _, codes := sequence(10 * time.Millisecond)
+	s.Check("tigergraph", ExitTigerGraph, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if len(*codes) != 1 || (*codes)[0] != ExitTigerGraph {
+		t.Fatalf("a check that doesn't finish in time should fail, exited with %v", *codes)
+	}
+}
+
+func TestSequence_Skip(t *testing.T) {
+	s, logs, codes := sequence(time.Second)
+	s.Skip("llm credentials", "-skip-llm-check is set")
+	if len(*codes) != 0 || !strings.Contains(logs.String(), "startup check skipped") || !strings.Contains(logs.String(), "-skip-llm-check") {
+		t.Fatalf("the skipped check should be logged with why, exited with %v:\n%s", *codes, logs)
+	}
+}
+
+func TestSequence_Warmup(t *testing.T) {
+	s, logs, codes := sequence(time.Second)
+	s.Warmup(map[string]func(context.Context) error{
+		"tigergraph token": func(context.Context) error { return nil },
+		"llm connection":   func(context.Context) error { return errors.New("connection refused") },
+	})
+	if len(*codes) != 0 {
+		t.Fatalf("a failed warm-up shouldn't exit, exited with %v", *codes)
+	}
+	if !strings.Contains(logs.String(), `msg="warmed up" step="tigergraph token"`) || !strings.Contains(logs.String(), `msg="warm-up failed" step="llm connection"`) ||
+		!strings.Contains(logs.String(), "connection refused") {
+		t.Fatalf("each step should be logged, the failed one with its error:\n%s", logs)
+	}
+}
@@ -8,6 +8,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// Tenant is the tenant a row belongs to, on deployments that serve several. The store sets it from its context
+// and only reads the rows of that tenant, see tenant.FromContext. It's empty on single-tenant deployments
+type Tenant struct {
+	TenantId string `json:"-" gorm:"not null;default:''"`
+}
+
 // overwrite gorm.Model for better json output
 type Model struct {
 	ID        uint           `json:"id" gorm:"primarykey"`
@@ -18,9 +24,46 @@ type Model struct {
 
 type Conversation struct {
 	Model
+	Tenant
 	UserId         string    `json:"user_id" gorm:"not null"`
 	ConversationId uuid.UUID `json:"conversation_id" gorm:"unique;not null"`
 	Name           string    `json:"name"`
+	// Version is incremented each time a message is added or changed. Writes can be made conditional on it
+	Version int `json:"version" gorm:"not null;default:0"`
+	// the model the conversation's LLM calls use instead of llm_config.model_name. Empty uses model_name
+	ModelName string `json:"model_name,omitempty"`
+	// the instructions the LLM is given ahead of the conversation's messages instead of
+	// llm_config.default_system_prompt. Empty uses default_system_prompt
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// the conversation this one was forked from, see ForkConversation. It may since have been deleted
+	ParentId *uuid.UUID `json:"parent_id,omitempty"`
+	// the id an external system the conversation was synced from knows it by, unique among its owner's
+	// conversations. See db.ConversationStore.CreateConversationWithExternalId
+	ExternalId string `json:"external_id,omitempty"`
+	// the language most of its messages are in, an ISO 639-1 code like "en". It's empty until one of them is
+	// long enough to tell, see language.Detect
+	Language string `json:"language,omitempty" gorm:"index"`
+	// when its owner pinned it to the top of their list, nil if it isn't pinned. See db.ConversationStore.PinConversation
+	PinnedAt *time.Time `json:"pinned_ts,omitempty"`
+	// the tokens of its messages, kept up to date as they're written. See db.ConversationStore.TokenUsage
+	TokenCount int64 `json:"token_count" gorm:"not null;default:0"`
+	// what integrations attach to it, i.e., the app it came from or a case number. See db.ConversationStore.SetMetadata
+	Metadata map[string]string `json:"metadata,omitempty" gorm:"serializer:json"`
+	// filled in by ListConversations
+	Tags         []string `json:"tags,omitempty" gorm:"-"`
+	MessageCount int      `json:"message_count,omitempty" gorm:"-"`
+	// the messages after the last one the listing user read, see ReadMarker
+	UnreadCount int `json:"unread_count,omitempty" gorm:"-"`
+	// Archived is set on the conversations ListConversations returns from the archive
+	Archived bool `json:"archived,omitempty" gorm:"-"`
+}
+
+// ConversationTag puts a tag on a conversation. A conversation can have many tags, and a tag many conversations
+type ConversationTag struct {
+	Tenant
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"primaryKey"`
+	Tag            string    `json:"tag" gorm:"primaryKey;index"`
+	CreatedAt      time.Time `json:"create_ts"`
 }
 
 func (c *Conversation) New(userId, name string, convoId uuid.UUID) {
@@ -36,7 +79,17 @@ type Feedback uint
 const (
 	SystemRole MessagengerRole = "system"
 	UserRole   MessagengerRole = "user"
+	// replies are stored as SystemRole, AssistantRole is accepted from clients that use the LLM's name for it
+	AssistantRole MessagengerRole = "assistant"
+	// the results of tool and function calls, for tool-calling workflows. They're only accepted when they're
+	// in chat_config.messageRoles, with Message.ToolCallId and Message.ToolName
+	ToolRole     MessagengerRole = "tool"
+	FunctionRole MessagengerRole = "function"
+	// what GraphRAG retrieved for the next reply, i.e., from the graph. The LLM gets it as a system message, and
+	// it's left out of the history users read unless they ask for it, see db.MessageOptions.IncludeHidden
+	ContextRole MessagengerRole = "context"
 )
+
 const (
 	NoFeedback = iota
 	ThumbsUp
@@ -45,6 +98,7 @@ const (
 
 type Message struct {
 	Model
+	Tenant
 	ConversationId uuid.UUID       `json:"conversation_id" gorm:"not null"`
 	MessageId      uuid.UUID       `json:"message_id" gorm:"unique;not null"`
 	ParentId       *uuid.UUID      `json:"parent_id"` // pointer allows nil
@@ -54,6 +108,22 @@ type Message struct {
 	ResponseTime   float64         `json:"response_time"`
 	Feedback       Feedback        `json:"feedback"` // time in fractional seconds (i.e., 1.25 seconds)
 	Comment        string          `json:"comment"`
+	// the LLM provider a reply was generated by, one of llm_config's fallbacks if the primary failed
+	Provider string `json:"provider,omitempty"`
+	// set with PinMessage, new messages are never pinned
+	Pinned bool `json:"pinned" gorm:"not null;default:false"`
+	// a streamed reply that was cut off, with the content it got to. It can be resumed
+	Incomplete bool `json:"incomplete,omitempty" gorm:"not null;default:false"`
+	// the language of its content, detected when it's written. See Conversation.Language
+	Language string `json:"language,omitempty"`
+	// the tokens of its content, counted when it's written. See Conversation.TokenCount
+	TokenCount int `json:"token_count" gorm:"not null;default:0"`
+	// the tool or function a ToolRole or FunctionRole message is the result of, and the id of the call
+	ToolName   string `json:"tool_name,omitempty"`
+	ToolCallId string `json:"tool_call_id,omitempty"`
+	// what GraphRAG retrieved to write the message. It's stored when the message is appended, and only read
+	// back with GraphContexts
+	GraphContext *GraphContext `json:"graph_context,omitempty" gorm:"-"`
 }
 
 func (m Message) String() string {
@@ -66,16 +136,285 @@ func (m Message) String() string {
 	ParentId       %v
 		`,
 		m.ID, m.UpdatedAt, m.ConversationId, m.MessageId, m.ParentId)
-	return fmt.Sprintf(`
-	ID             %v
-	ConversationId %v
-	MessageId      %v
-	ParentId       %v
-	ModelName      %v
-	Content        %v
-	Role           %v
-	Feedback       %v
-	Comment        %v`, m.ID, m.ConversationId, m.MessageId, m.ParentId, m.ModelName, m.Content, m.Role, m.Feedback, m.Comment)
+}
+
+// MessageRevision is the content a message had before it was edited. Revision 1 is the original
+type MessageRevision struct {
+	Tenant
+	ID        uint      `json:"-" gorm:"primarykey"`
+	MessageId uuid.UUID `json:"message_id" gorm:"not null;uniqueIndex:idx_message_revisions_message_revision"`
+	Revision  int       `json:"revision" gorm:"not null;uniqueIndex:idx_message_revisions_message_revision"`
+	Content   string    `json:"content"`
+	// when the content was replaced
+	CreatedAt time.Time `json:"create_ts"`
+}
+
+// Attachment is a file or image on a message. Only its metadata is stored, the file itself is at StorageURL
+type Attachment struct {
+	Tenant
+	ID           uint      `json:"-" gorm:"primarykey"`
+	AttachmentId uuid.UUID `json:"attachment_id" gorm:"unique;not null"`
+	MessageId    uuid.UUID `json:"message_id" gorm:"not null;index"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	// in bytes
+	Size       int64     `json:"size"`
+	StorageURL string    `json:"storage_url"`
+	CreatedAt  time.Time `json:"create_ts"`
+}
+
+// MessageEmbedding is a vector of a message's content, see RetrieveSimilar. A message has at most one
+type MessageEmbedding struct {
+	Tenant
+	MessageId      uuid.UUID `json:"message_id" gorm:"primaryKey"`
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"not null;index"`
+	// the embedding model the vector is from. Vectors from other models are made again
+	Model  string    `json:"model" gorm:"not null"`
+	Vector []float32 `json:"vector" gorm:"serializer:json"`
+	// the message's update_ts when its content was embedded. Messages changed since are embedded again
+	MessageUpdatedAt time.Time `json:"message_update_ts"`
+	CreatedAt        time.Time `json:"create_ts"`
+}
+
+// SimilarMessage is a message RetrieveSimilar found, with how similar it is to the query
+type SimilarMessage struct {
+	Message
+	// the cosine similarity of the vectors, from -1 to 1. Higher is more similar
+	Similarity float64 `json:"similarity"`
+}
+
+// ShareLink gives read access to a conversation, to anyone with its token, until it expires or is revoked
+type ShareLink struct {
+	Tenant
+	ShareId        uuid.UUID `json:"share_id" gorm:"primaryKey"`
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"not null;index"`
+	// owner of the conversation, who created the link
+	UserId    string     `json:"user_id" gorm:"not null"`
+	ExpiresAt time.Time  `json:"expires_ts"`
+	RevokedAt *time.Time `json:"revoked_ts,omitempty"`
+	CreatedAt time.Time  `json:"create_ts"`
+	// signed from the fields above, it isn't stored
+	Token string `json:"token" gorm:"-"`
+}
+
+// The permissions a ConversationAccess can grant. Write includes read
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// ConversationAccess grants a user other than the owner access to one conversation
+type ConversationAccess struct {
+	Tenant
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"primaryKey"`
+	UserId         string    `json:"user_id" gorm:"primaryKey;index"`
+	Permission     string    `json:"permission" gorm:"not null"`
+	// the owner or admin who granted it
+	GrantedBy string    `json:"granted_by"`
+	CreatedAt time.Time `json:"create_ts"`
+	UpdatedAt time.Time `json:"update_ts"`
+}
+
+func (ConversationAccess) TableName() string {
+	return "conversation_access"
+}
+
+// ReadMarker is the last message of a conversation a user has read. The messages after it are unread,
+// all of them are if the user hasn't read any
+type ReadMarker struct {
+	Tenant
+	ConversationId    uuid.UUID `json:"conversation_id" gorm:"primaryKey"`
+	UserId            string    `json:"user_id" gorm:"primaryKey;index"`
+	LastReadMessageId uuid.UUID `json:"last_read_message_id" gorm:"not null"`
+	// when the last read message was written, the messages after it are unread
+	ReadUpTo  time.Time `json:"read_up_to"`
+	UpdatedAt time.Time `json:"update_ts"`
+}
+
+// MessageFeedback is a user's rating of an answer, with an optional comment. Each user who can read the
+// conversation has their own, set with SetFeedback
+type MessageFeedback struct {
+	Tenant
+	MessageId      uuid.UUID `json:"message_id" gorm:"primaryKey"`
+	UserId         string    `json:"user_id" gorm:"primaryKey;index"`
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"not null;index"`
+	// ThumbsUp or ThumbsDown
+	Rating    Feedback  `json:"rating" gorm:"not null"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"create_ts"`
+	UpdatedAt time.Time `json:"update_ts"`
+}
+
+func (MessageFeedback) TableName() string {
+	return "message_feedback"
+}
+
+// ConversationEvent is an entry of a conversation's timeline: it was created, a message was added to it, it was
+// renamed or it was shared. See db's Event types
+type ConversationEvent struct {
+	Tenant
+	ID             uint      `json:"id" gorm:"primarykey"`
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"not null;index"`
+	Type           string    `json:"type" gorm:"not null"`
+	// who did it. Empty for what the service does itself, like naming new conversations
+	UserId string `json:"user_id,omitempty"`
+	// the message that was added, or the first message of a new conversation
+	MessageId *uuid.UUID `json:"message_id,omitempty"`
+	// the new name of a renamed conversation, and the share link or the user a conversation was shared with
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"create_ts"`
+}
+
+func (ConversationEvent) TableName() string {
+	return "conversation_events"
+}
+
+// FeedbackSummary is the feedback on one answer, from every user who rated it, see AggregateFeedback
+type FeedbackSummary struct {
+	MessageId      uuid.UUID `json:"message_id"`
+	ConversationId uuid.UUID `json:"conversation_id"`
+	// the model that wrote the answer
+	ModelName  string   `json:"model"`
+	ThumbsUp   int      `json:"thumbs_up"`
+	ThumbsDown int      `json:"thumbs_down"`
+	Comments   []string `json:"comments"`
+}
+
+// GraphContext is what GraphRAG retrieved from the graph to answer with: the query it ran and the vertices
+// and edges in its results, see tigergraph.NewGraphContext
+type GraphContext struct {
+	Query    string        `json:"query"`
+	Vertices []GraphVertex `json:"vertices"`
+	Edges    []GraphEdge   `json:"edges"`
+}
+
+// GraphVertex and GraphEdge identify the elements of the graph the way TigerGraph prints them
+type GraphVertex struct {
+	Type string `json:"v_type"`
+	Id   string `json:"v_id"`
+}
+
+type GraphEdge struct {
+	Type     string `json:"e_type"`
+	FromType string `json:"from_type"`
+	FromId   string `json:"from_id"`
+	ToType   string `json:"to_type"`
+	ToId     string `json:"to_id"`
+}
+
+// MessageContext is the GraphContext of a message. It's kept apart from the message since it's only read
+// when debugging answers
+type MessageContext struct {
+	Tenant
+	MessageId      uuid.UUID    `json:"message_id" gorm:"primaryKey"`
+	ConversationId uuid.UUID    `json:"conversation_id" gorm:"not null;index"`
+	Context        GraphContext `json:"context" gorm:"serializer:json"`
+	CreatedAt      time.Time    `json:"create_ts"`
+}
+
+// LLMExchange is the request sent to the LLM for a reply and the response it got, as they were sent. They're only
+// stored with llm_config.debug_store_llm_io
+type LLMExchange struct {
+	Tenant
+	MessageId      uuid.UUID `json:"message_id" gorm:"primaryKey"`
+	ConversationId uuid.UUID `json:"conversation_id" gorm:"not null;index"`
+	Provider       string    `json:"provider"`
+	Model          string    `json:"model"`
+	Request        string    `json:"request"`
+	Response       string    `json:"response"`
+	CreatedAt      time.Time `json:"create_ts" gorm:"index"`
+}
+
+func (LLMExchange) TableName() string {
+	return "llm_exchanges"
+}
+
+// Allows reports whether the access is enough for permission
+func (a ConversationAccess) Allows(permission string) bool {
+	return a.Permission == PermissionWrite || a.Permission == permission
+}
+
+// Template is a standard start for conversations: a title and the first messages, see
+// CreateConversationFromTemplate. Shared templates can be used by everyone, the others only by their owner
+type Template struct {
+	Tenant
+	TemplateId uuid.UUID `json:"template_id" gorm:"primaryKey"`
+	// owner, who can change and delete it
+	UserId    string            `json:"user_id" gorm:"not null;index"`
+	Title     string            `json:"title" gorm:"not null"`
+	Shared    bool              `json:"shared" gorm:"not null;default:false"`
+	Messages  []TemplateMessage `json:"messages" gorm:"serializer:json"`
+	CreatedAt time.Time         `json:"create_ts"`
+	UpdatedAt time.Time         `json:"update_ts"`
+}
+
+// TemplateMessage is one of the messages a Template starts conversations with, in order
+type TemplateMessage struct {
+	Role    MessagengerRole `json:"role"`
+	Content string          `json:"content"`
+}
+
+// UserStats are totals over a user's conversations. The activity times are nil if they have no messages
+type UserStats struct {
+	UserId                  string     `json:"user_id"`
+	Conversations           int64      `json:"conversations"`
+	Messages                int64      `json:"messages"`
+	MessagesPerConversation float64    `json:"messages_per_conversation"`
+	FirstActivity           *time.Time `json:"first_activity,omitempty"`
+	LastActivity            *time.Time `json:"last_activity,omitempty"`
+}
+
+// TokenUsage is the tokens of a user's conversations, in total and each one's, most recently updated first
+type TokenUsage struct {
+	UserId        string               `json:"user_id"`
+	TokenCount    int64                `json:"token_count"`
+	Conversations []ConversationTokens `json:"conversations"`
+}
+
+// ConversationTokens is the tokens of one of the conversations of a TokenUsage
+type ConversationTokens struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	Name           string    `json:"name"`
+	TokenCount     int64     `json:"token_count"`
+}
+
+// ActiveConversation is one of the most recently active conversations of the instance, see RecentConversations
+type ActiveConversation struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	UserId         string    `json:"user_id"`
+	Name           string    `json:"name"`
+	UpdatedAt      time.Time `json:"update_ts"`
+	// when its latest message was written, nil if it has none
+	LastMessageAt *time.Time `json:"last_message_ts,omitempty"`
+	MessageCount  int        `json:"message_count"`
+	// the conversation's row, for the cursor of the next page
+	ID uint `json:"-"`
+}
+
+// A message matching a search query
+type SearchResult struct {
+	ConversationId uuid.UUID `json:"conversation_id"`
+	MessageId      uuid.UUID `json:"message_id"`
+	Snippet        string    `json:"snippet"`
+	Rank           float64   `json:"rank"` // higher is more relevant
+}
+
+// The kinds of match a CombinedSearchResult can be
+const (
+	ResultTitle   = "title"
+	ResultTag     = "tag"
+	ResultMessage = "message"
+)
+
+// A conversation title, tag or message matching a combined search query
+type CombinedSearchResult struct {
+	Type           string    `json:"type"` // ResultTitle, ResultTag or ResultMessage
+	ConversationId uuid.UUID `json:"conversation_id"`
+	// the conversation's name
+	Name      string     `json:"name"`
+	MessageId *uuid.UUID `json:"message_id,omitempty"` // only for ResultMessage
+	Snippet   string     `json:"snippet"`
+	Rank      float64    `json:"rank"` // higher is more relevant
 }
 
 type User struct {
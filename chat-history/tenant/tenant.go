@@ -0,0 +1,66 @@
+// Package tenant carries the tenant a request is for, on deployments that serve several tenants from one
+// instance. The store only reads and writes the rows of the tenant in its context, see db's tenant filter
+package tenant
+
+import "context"
+
+// Header is the header a caller's tenant is read from when their token doesn't have one
+const Header = "X-Tenant-ID"
+
+// the longest tenant id accepted
+const maxLength = 64
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant in ctx. ok is false if there isn't one, as for the store's own work, like
+// purging the trash, which is for every tenant
+func FromContext(ctx context.Context) (id string, ok bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok = ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+type requiredKey struct{}
+
+// Require returns a copy of ctx whose store queries must be for a tenant, the store rejects the ones without.
+// Every request is, on deployments that serve several tenants, so a route that doesn't resolve its caller's
+// tenant fails instead of reaching every tenant's rows
+func Require(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requiredKey{}, true)
+}
+
+// AnyTenant returns a copy of ctx whose store queries don't need a tenant even if it was required, and reach every
+// tenant's rows. It's for requests that something other than their caller is checked for, i.e., share links, whose
+// token says which conversation they can read
+func AnyTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requiredKey{}, false)
+}
+
+// Required reports whether ctx's store queries must be for a tenant, see Require
+func Required(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	required, _ := ctx.Value(requiredKey{}).(bool)
+	return required
+}
+
+// Valid reports whether id can be a tenant: 1 to 64 printable ASCII characters without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,331 @@
+package tigergraph
+
+import (
+	"bytes"
+	"chat-history/clock"
+	"chat-history/config"
+	"chat-history/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BaseURL returns the root URL of the TigerGraph GSQL server. TigerGraph Cloud is always served on 443
+func BaseURL(hostname, gsPort string) string {
+	if strings.Contains(hostname, "tgcloud") {
+		return fmt.Sprintf("%s:443", hostname)
+	}
+	return fmt.Sprintf("%s:%s", hostname, gsPort)
+}
+
+// TgClient talks to TigerGraph as the configured service user.
+// It logs in with the username and password and caches the token it gets back. With replicas configured, requests
+// that can't connect to a TigerGraph go to the next one, and keep going to the one that answered
+type TgClient struct {
+	cfg    config.TgDbConfig
+	hosts  []*host
+	client *http.Client
+	// sleep waits between retries, swapped out by tests
+	sleep func(time.Duration)
+	// clock tells when the tokens expire
+	clock clock.Clock
+
+	// index in hosts of the last one that answered, requests go to it first
+	current atomic.Int32
+}
+
+// host is one of the TigerGraphs of a TgClient. Each has its own token
+type host struct {
+	baseURL string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Option configures a TgClient
+type Option func(*TgClient)
+
+// WithHTTPClient makes the TgClient send its requests with client instead of one from NewHTTPClient,
+// i.e., to go through a test transport or a proxy of its own. Its transport is used as it is, so cfg's TLS
+// and pooling settings don't apply, but the requests are still in the metrics and carry the X-Request-ID.
+// client itself isn't changed
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *TgClient) {
+		if client == nil {
+			return
+		}
+		wrapped := *client
+		wrapped.Transport = forwardRequestID(metrics.InstrumentTigerGraph(client.Transport))
+		c.client = &wrapped
+	}
+}
+
+// WithClock checks when the tokens expire with c instead of the system's clock, i.e., a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(tc *TgClient) {
+		tc.clock = c
+	}
+}
+
+// NewTgClient returns a client for the TigerGraph in cfg. Without WithHTTPClient, it only fails if
+// cfg.CACertPath can't be loaded
+func NewTgClient(cfg config.TgDbConfig, opts ...Option) (*TgClient, error) {
+	c := &TgClient{
+		cfg:   cfg,
+		hosts: []*host{{baseURL: BaseURL(cfg.Hostname, cfg.GsPort)}},
+		sleep: time.Sleep,
+		clock: clock.Real,
+	}
+	for _, replica := range cfg.Replicas {
+		c.hosts = append(c.hosts, &host{baseURL: replicaURL(replica, cfg.GsPort)})
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.client == nil {
+		client, err := NewHTTPClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c, nil
+}
+
+// replicaURL is BaseURL for a replica, which may have a port of its own
+func replicaURL(hostname, gsPort string) string {
+	if u, err := url.Parse(hostname); err == nil && u.Port() != "" {
+		return strings.TrimSuffix(hostname, "/")
+	}
+	return BaseURL(hostname, gsPort)
+}
+
+// failover calls send with each host in turn, starting with the last one that answered, until one does.
+// It only moves on to the next host when send couldn't connect to one, see unreachable
+func (c *TgClient) failover(send func(h *host) error) error {
+	start := int(c.current.Load())
+	var err error
+	for i := range c.hosts {
+		n := (start + i) % len(c.hosts)
+		if err = send(c.hosts[n]); !unreachable(err) {
+			c.current.Store(int32(n))
+			return err
+		}
+	}
+	return err
+}
+
+// unreachable reports whether err is a failure to connect to TigerGraph, so the request wasn't sent and
+// can go to another host whatever its method
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+type tokenResponse struct {
+	Error      bool   `json:"error"`
+	Message    string `json:"message"`
+	Expiration int64  `json:"expiration"`
+	Results    struct {
+		Token string `json:"token"`
+	} `json:"results"`
+}
+
+// RequestToken returns the cached token of the TigerGraph that answered last, logging in for a new one if
+// there isn't one or it expired. It fails over to the replicas like Do
+func (c *TgClient) RequestToken() (string, error) {
+	var token string
+	err := c.failover(func(h *host) error {
+		var err error
+		token, err = c.requestToken(h)
+		return err
+	})
+	return token, err
+}
+
+// Warmup logs in before the first request needs the token, and leaves the connection it did it on open
+// for the next one
+func (c *TgClient) Warmup() error {
+	_, err := c.RequestToken()
+	return err
+}
+
+func (c *TgClient) requestToken(h *host) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.token != "" && (h.expires.IsZero() || c.clock.Now().Before(h.expires)) {
+		return h.token, nil
+	}
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+"/restpp/requesttoken", strings.NewReader("{}"))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var tkn tokenResponse
+	if err := json.Unmarshal(body, &tkn); err != nil {
+		return "", fmt.Errorf("requesttoken returned %s: %s", resp.Status, body)
+	}
+	if tkn.Error || tkn.Results.Token == "" {
+		return "", fmt.Errorf("requesttoken failed: %s", tkn.Message)
+	}
+
+	h.token = tkn.Results.Token
+	h.expires = time.Time{}
+	if tkn.Expiration > 0 {
+		h.expires = time.Unix(tkn.Expiration, 0)
+	}
+	return h.token, nil
+}
+
+// invalidate drops the host's cached token if it's still the one that was rejected
+func (h *host) invalidate(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.token == token {
+		h.token = ""
+	}
+}
+
+// Do sends a request to path on TigerGraph with the token attached.
+// If TigerGraph rejects the token, it's refreshed and the request is retried once.
+// Idempotent requests that fail with a connection error or a 5xx (i.e., while TigerGraph restarts)
+// are retried up to cfg.MaxRetries times with backoff, see retry.go
+func (c *TgClient) Do(method, path string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doWithToken(method, path, body)
+		if attempt >= c.cfg.MaxRetries || !idempotent(method) || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			// drain it so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		c.sleep(c.backoff(attempt))
+	}
+}
+
+// doWithToken sends the request to the first host that answers, refreshing the token and sending it again if
+// it's rejected
+func (c *TgClient) doWithToken(method, path string, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	err := c.failover(func(h *host) error {
+		var err error
+		resp, err = c.doOn(h, method, path, body)
+		return err
+	})
+	return resp, err
+}
+
+func (c *TgClient) doOn(h *host, method, path string, body []byte) (*http.Response, error) {
+	resp, token, err := c.do(h, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	// the token expired or was revoked
+	resp.Body.Close()
+	h.invalidate(token)
+	resp, _, err = c.do(h, method, path, body)
+	return resp, err
+}
+
+func (c *TgClient) do(h *host, method, path string, body []byte) (*http.Response, string, error) {
+	token, err := c.requestToken(h)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	ctx, cancel := c.requestContext()
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, reader)
+	if err != nil {
+		cancel()
+		return nil, "", err
+	}
+	req.URL.RawQuery = c.queryLimits(req.URL.Query()).Encode()
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, token, err
+	}
+	// the timeout covers reading the body too, so only cancel once the caller is done with it
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, token, nil
+}
+
+// requestContext times out after cfg.RequestTimeoutSeconds, or never if it's 0
+func (c *TgClient) requestContext() (context.Context, context.CancelFunc) {
+	if c.cfg.RequestTimeoutSeconds <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(c.cfg.RequestTimeoutSeconds)*time.Second)
+}
+
+// queryLimits adds the default query limits from the config to q, unless the caller already set them
+func (c *TgClient) queryLimits(q url.Values) url.Values {
+	limits := []struct {
+		param string
+		value int
+	}{
+		{"timeout", c.cfg.DefaultTimeout * 1000}, // TigerGraph takes ms
+		{"mem_threshold", c.cfg.DefaultMemThreshold},
+		{"thread_limit", c.cfg.DefaultThreadLimit},
+	}
+	for _, l := range limits {
+		if l.value > 0 && !q.Has(l.param) {
+			q.Set(l.param, strconv.Itoa(l.value))
+		}
+	}
+	return q
+}
+
+// cancelOnClose releases the request's context when the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,463 @@
+package tigergraph
+
+import (
+	"chat-history/clock"
+	"chat-history/config"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTigerGraph issues numbered tokens and only accepts the latest one
+type fakeTigerGraph struct {
+	tokens     atomic.Int32
+	expiration int64
+}
+
+func (f *fakeTigerGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/restpp/requesttoken":
+		if u, p, ok := r.BasicAuth(); !ok || u != "tigergraph" || p != "tigergraph" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":true,"message":"bad credentials"}`))
+			return
+		}
+		n := f.tokens.Add(1)
+		fmt.Fprintf(w, `{"error":false,"expiration":%d,"results":{"token":"token-%d"}}`, f.expiration, n)
+	default:
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", f.tokens.Load()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}
+}
+
+func newTestClient(t *testing.T, tg http.Handler, username, password string, opts ...Option) *TgClient {
+	srv := httptest.NewServer(tg)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	c, err := NewTgClient(config.TgDbConfig{
+		Hostname: u.Scheme + "://" + u.Hostname(),
+		GsPort:   u.Port(),
+		Username: username,
+		Password: password,
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRequestToken_Cached(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	c := newTestClient(t, tg, "tigergraph", "tigergraph")
+
+	for range 3 {
+		tkn, err := c.RequestToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tkn != "token-1" {
+			t.Fatalf("token should be token-1. It's: %s", tkn)
+		}
+	}
+	if n := tg.tokens.Load(); n != 1 {
+		t.Fatalf("token should be requested once. It was requested %d times", n)
+	}
+}
+
+// recordingTransport serves requests with a handler in process, and records them
+type recordingTransport struct {
+	handler  http.Handler
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.requests = append(rt.requests, req)
+	rt.mu.Unlock()
+	// handlers expect a body, as servers always give them one
+	served := req.Clone(req.Context())
+	if served.Body == nil {
+		served.Body = http.NoBody
+	}
+	resp := httptest.NewRecorder()
+	rt.handler.ServeHTTP(resp, served)
+	return resp.Result(), nil
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	rt := &recordingTransport{handler: &fakeTigerGraph{}}
+	client := &http.Client{Transport: rt}
+	// the host doesn't exist, and the CA cert would fail to load if the client were built from cfg
+	c, err := NewTgClient(config.TgDbConfig{
+		Hostname: "https://tigergraph.invalid", GsPort: "14240", Username: "tigergraph", Password: "tigergraph",
+		CACertPath: "/does/not/exist.pem",
+	}, WithHTTPClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Do(http.MethodGet, "/restpp/echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "GET /restpp/echo " {
+		t.Fatalf("the request should be served through the injected client. Got: %s", body)
+	}
+
+	if len(rt.requests) != 2 {
+		t.Fatalf("the token and the request should go through the injected client. Got %d requests", len(rt.requests))
+	}
+	for i, want := range []string{"https://tigergraph.invalid:14240/restpp/requesttoken", "https://tigergraph.invalid:14240/restpp/echo"} {
+		if got := rt.requests[i].URL.String(); got != want {
+			t.Fatalf("request %d should be to %s. It was to %s", i, want, got)
+		}
+	}
+	if auth := rt.requests[1].Header.Get("Authorization"); auth != "Bearer token-1" {
+		t.Fatalf("the request should have the token from the injected client. It has %q", auth)
+	}
+	if client.Transport != rt {
+		t.Fatal("the injected client shouldn't be changed")
+	}
+}
+
+func TestRequestToken_Expired(t *testing.T) {
+	// the token expired a second ago, so every call logs in again
+	tg := &fakeTigerGraph{expiration: time.Now().Add(-time.Second).Unix()}
+	c := newTestClient(t, tg, "tigergraph", "tigergraph")
+
+	c.RequestToken()
+	tkn, err := c.RequestToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn != "token-2" {
+		t.Fatalf("expired token should be refreshed. Got: %s", tkn)
+	}
+}
+
+func TestRequestToken_ExpiresOnClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	tg := &fakeTigerGraph{expiration: fake.Now().Add(time.Hour).Unix()}
+	c := newTestClient(t, tg, "tigergraph", "tigergraph", WithClock(fake))
+
+	c.RequestToken()
+	fake.Advance(59 * time.Minute)
+	if tkn, err := c.RequestToken(); err != nil || tkn != "token-1" {
+		t.Fatalf("the token should still be cached. Got %s, %v", tkn, err)
+	}
+	fake.Advance(time.Minute)
+	if tkn, err := c.RequestToken(); err != nil || tkn != "token-2" {
+		t.Fatalf("the token should be refreshed once it expires. Got %s, %v", tkn, err)
+	}
+}
+
+func TestRequestToken_BadCredentials(t *testing.T) {
+	c := newTestClient(t, &fakeTigerGraph{}, "tigergraph", "wrong")
+	if _, err := c.RequestToken(); err == nil {
+		t.Fatal("expected an error for bad credentials")
+	}
+}
+
+func TestDo(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	c := newTestClient(t, tg, "tigergraph", "tigergraph")
+
+	resp, err := c.Do(http.MethodPost, "/restpp/query/g/q", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != `POST /restpp/query/g/q {"a":1}` {
+		t.Fatalf("unexpected response %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestDo_RefreshOn401(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	c := newTestClient(t, tg, "tigergraph", "tigergraph")
+	if _, err := c.RequestToken(); err != nil {
+		t.Fatal(err)
+	}
+
+	// TigerGraph revokes token-1, the client still has it cached
+	tg.tokens.Add(1)
+
+	resp, err := c.Do(http.MethodPost, "/restpp/query/g/q", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != `POST /restpp/query/g/q {"a":1}` {
+		t.Fatalf("request should be retried with a new token. Got %d: %s", resp.StatusCode, body)
+	}
+	if tkn, _ := c.RequestToken(); tkn != "token-3" {
+		t.Fatalf("refreshed token should be cached. It's: %s", tkn)
+	}
+}
+
+// newConfiguredClient is newTestClient with cfg changed by configure before the client is made
+func newConfiguredClient(t *testing.T, srv *httptest.Server, configure func(cfg *config.TgDbConfig)) *TgClient {
+	u, _ := url.Parse(srv.URL)
+	cfg := config.TgDbConfig{
+		Hostname: u.Scheme + "://" + u.Hostname(),
+		GsPort:   u.Port(),
+		Username: "tigergraph",
+		Password: "tigergraph",
+	}
+	configure(&cfg)
+	c, err := NewTgClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDo_Timeout(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/restpp/query/g/slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		tg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) { cfg.RequestTimeoutSeconds = 1 })
+
+	start := time.Now()
+	_, err := c.Do(http.MethodGet, "/restpp/query/g/slow", nil)
+	if err == nil {
+		t.Fatal("a request slower than requestTimeoutSeconds should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("the request should be cut off after 1s. It took: %v", elapsed)
+	}
+
+	// fast requests are unaffected
+	resp, err := c.Do(http.MethodGet, "/restpp/query/g/fast", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestDo_QueryLimits(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/restpp/requesttoken" {
+			query = r.URL.Query()
+		}
+		tg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) {
+		cfg.DefaultTimeout = 300
+		cfg.DefaultMemThreshold = 5000
+		cfg.DefaultThreadLimit = 8
+	})
+
+	resp, err := c.Do(http.MethodGet, "/restpp/query/g/q?thread_limit=2&a=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := url.Values{"timeout": {"300000"}, "mem_threshold": {"5000"}, "thread_limit": {"2"}, "a": {"1"}}
+	if query.Encode() != want.Encode() {
+		t.Fatalf("query should be %s. It's: %s", want.Encode(), query.Encode())
+	}
+}
+
+func TestDo_ReusesConnections(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(tg)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) { cfg.MaxIdleConns = 2 })
+
+	for range 5 {
+		resp, err := c.Do(http.MethodGet, "/restpp/query/g/q", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("sequential requests should share 1 connection. They opened: %d", n)
+	}
+}
+
+// countingTransport counts the requests sent to each host, whether they connect or not
+type countingTransport struct {
+	mu    sync.Mutex
+	hosts map[string]int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.hosts[req.URL.Host]++
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (t *countingTransport) count(host string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hosts[host]
+}
+
+// downAddress returns an address nothing is listening on
+func downAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestDo_Failover(t *testing.T) {
+	primary := downAddress(t)
+	replica := httptest.NewServer(&fakeTigerGraph{})
+	t.Cleanup(replica.Close)
+	replicaURL, _ := url.Parse(replica.URL)
+
+	_, primaryPort, _ := net.SplitHostPort(primary)
+	transport := &countingTransport{hosts: map[string]int{}}
+	c, err := NewTgClient(config.TgDbConfig{
+		Hostname: "http://127.0.0.1",
+		GsPort:   primaryPort,
+		// a replica with a port of its own, and one that's never needed
+		Replicas: []string{replica.URL, "http://" + downAddress(t)},
+		Username: "tigergraph",
+		Password: "tigergraph",
+	}, WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		// not idempotent, it's failed over anyway since the primary never got it
+		resp, err := c.Do(http.MethodPost, "/restpp/query/g/q", []byte(`{"a":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != `POST /restpp/query/g/q {"a":1}` {
+			t.Fatalf("request should go to the replica. Got %d: %s", resp.StatusCode, body)
+		}
+	}
+	// the replica is remembered as the last to answer
+	if n := transport.count(primary); n != 1 {
+		t.Fatalf("the primary should only be tried once. It got %d requests", n)
+	}
+	if n := transport.count(replicaURL.Host); n != 3 {
+		t.Fatalf("the replica should get the login and both requests. It got %d", n)
+	}
+	if tkn, err := c.RequestToken(); err != nil || tkn != "token-1" {
+		t.Fatalf("the replica's token should be cached. It's %q, %v", tkn, err)
+	}
+}
+
+func TestDo_FailoverAllDown(t *testing.T) {
+	_, port, _ := net.SplitHostPort(downAddress(t))
+	c, err := NewTgClient(config.TgDbConfig{
+		Hostname: "http://127.0.0.1",
+		GsPort:   port,
+		Replicas: []string{"http://" + downAddress(t)},
+		Username: "tigergraph",
+		Password: "tigergraph",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(http.MethodGet, "/restpp/echo", nil); !unreachable(err) {
+		t.Fatalf("should fail to connect when every host is down. Got %v", err)
+	}
+}
+
+func TestDo_NoFailoverOnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":true,"message":"bad credentials"}`))
+	}))
+	t.Cleanup(srv.Close)
+	replica := &fakeTigerGraph{}
+	replicaSrv := httptest.NewServer(replica)
+	t.Cleanup(replicaSrv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) { cfg.Replicas = []string{replicaSrv.URL} })
+
+	// the primary answered, with an error that the replica wouldn't fix
+	if _, err := c.RequestToken(); err == nil {
+		t.Fatal("the primary's error should be returned")
+	}
+	if n := replica.tokens.Load(); n != 0 {
+		t.Fatalf("the replica shouldn't be used while the primary answers. It issued %d tokens", n)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(tg)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(*config.TgDbConfig) {})
+
+	if err := c.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tg.tokens.Load(); n != 1 {
+		t.Fatalf("warming up should log in once. It logged in %d times", n)
+	}
+	resp, err := c.Do(http.MethodGet, "/restpp/query/g/q", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if n := tg.tokens.Load(); n != 1 {
+		t.Fatalf("the first request should use the cached token. It logged in %d times", n)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("the first request should use the warm-up's connection. There were %d", n)
+	}
+
+	if err := newTestClient(t, tg, "tigergraph", "wrong").Warmup(); err == nil {
+		t.Fatal("warming up with bad credentials should fail")
+	}
+}
@@ -0,0 +1,91 @@
+package tigergraph
+
+import (
+	"bytes"
+	"chat-history/structs"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// NewGraphContext collects the vertices and edges in the results RunQuery returned for query, wherever they're
+// printed in them, for storing with the message that was written from them. Each is in it once, in the order
+// it's first found
+func NewGraphContext(query string, results []json.RawMessage) *structs.GraphContext {
+	c := &structs.GraphContext{Query: query, Vertices: []structs.GraphVertex{}, Edges: []structs.GraphEdge{}}
+	vertices := map[structs.GraphVertex]bool{}
+	edges := map[structs.GraphEdge]bool{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if vertex, ok := asVertex(v); ok {
+				if !vertices[vertex] {
+					vertices[vertex] = true
+					c.Vertices = append(c.Vertices, vertex)
+				}
+			} else if edge, ok := asEdge(v); ok {
+				if !edges[edge] {
+					edges[edge] = true
+					c.Edges = append(c.Edges, edge)
+				}
+			}
+			// vertices printed with their edges, like in the results of a path query. The keys are sorted,
+			// so the order doesn't depend on the map's
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				walk(v[key])
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	for _, result := range results {
+		// numbers are kept as they're printed, so numeric ids don't lose digits
+		dec := json.NewDecoder(bytes.NewReader(result))
+		dec.UseNumber()
+		var v any
+		// results that aren't JSON have nothing to collect
+		if dec.Decode(&v) == nil {
+			walk(v)
+		}
+	}
+	return c
+}
+
+// asVertex reads a vertex as it's printed, {"v_id": ..., "v_type": ..., "attributes": ...}
+func asVertex(v map[string]any) (structs.GraphVertex, bool) {
+	id, hasId := v["v_id"]
+	typ, hasType := v["v_type"].(string)
+	if !hasId || !hasType {
+		return structs.GraphVertex{}, false
+	}
+	return structs.GraphVertex{Type: typ, Id: idString(id)}, true
+}
+
+// asEdge reads an edge as it's printed, {"e_type": ..., "from_type": ..., "from_id": ..., "to_type": ..., "to_id": ...}
+func asEdge(v map[string]any) (structs.GraphEdge, bool) {
+	typ, hasType := v["e_type"].(string)
+	from, hasFrom := v["from_id"]
+	to, hasTo := v["to_id"]
+	if !hasType || !hasFrom || !hasTo {
+		return structs.GraphEdge{}, false
+	}
+	fromType, _ := v["from_type"].(string)
+	toType, _ := v["to_type"].(string)
+	return structs.GraphEdge{Type: typ, FromType: fromType, FromId: idString(from), ToType: toType, ToId: idString(to)}, true
+}
+
+// ids are strings unless the vertex's primary id is a number
+func idString(id any) string {
+	if s, ok := id.(string); ok {
+		return s
+	}
+	return fmt.Sprint(id)
+}
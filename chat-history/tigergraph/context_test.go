@@ -0,0 +1,35 @@
+package tigergraph
+
+import (
+	"chat-history/structs"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewGraphContext(t *testing.T) {
+	// a vertex set, edges printed with an accumulator, a vertex nested in another result, and a result without any
+	results := []json.RawMessage{
+		json.RawMessage(`{"docs": [{"v_id": "doc1", "v_type": "Document", "attributes": {"text": "GSQL"}},
+			{"v_id": 12345678901234567890, "v_type": "Chunk", "attributes": {}}]}`),
+		json.RawMessage(`{"@@edges": [{"e_type": "HAS_CHUNK", "from_type": "Document", "from_id": "doc1", "to_type": "Chunk", "to_id": "12345678901234567890", "directed": true, "attributes": {}}]}`),
+		json.RawMessage(`{"path": {"start": {"v_id": "doc1", "v_type": "Document"}, "hops": 1}}`),
+		json.RawMessage(`{"count": 2}`),
+		json.RawMessage(`not json`),
+	}
+	got := NewGraphContext("retrieve_chunks", results)
+	want := &structs.GraphContext{
+		Query: "retrieve_chunks",
+		// doc1 is printed twice
+		Vertices: []structs.GraphVertex{{Type: "Document", Id: "doc1"}, {Type: "Chunk", Id: "12345678901234567890"}},
+		Edges:    []structs.GraphEdge{{Type: "HAS_CHUNK", FromType: "Document", FromId: "doc1", ToType: "Chunk", ToId: "12345678901234567890"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	empty := NewGraphContext("count_docs", results[3:])
+	if len(empty.Vertices) != 0 || len(empty.Edges) != 0 {
+		t.Fatalf("results without vertices or edges should have none, got %+v", empty)
+	}
+}
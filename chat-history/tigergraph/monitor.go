@@ -0,0 +1,102 @@
+package tigergraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Ping calls the RESTPP echo endpoint, which doesn't require authentication
+func Ping(ctx context.Context, hostname, gsPort string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, BaseURL(hostname, gsPort)+"/restpp/echo", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("echo returned %s", resp.Status)
+	}
+	return nil
+}
+
+// errNotChecked is the Monitor's error until its first check
+var errNotChecked = errors.New("tigergraph hasn't been checked yet")
+
+// Monitor keeps track of whether TigerGraph can be reached, so requests that need it can fail fast
+// while it can't instead of each waiting on a timeout. Start checks it in the background
+type Monitor struct {
+	ping    func(ctx context.Context) error
+	timeout time.Duration
+
+	mu  sync.RWMutex
+	err error
+}
+
+// NewMonitor returns a Monitor that checks TigerGraph with ping, giving up on each check after timeout.
+// TigerGraph counts as unavailable until the first check
+func NewMonitor(ping func(ctx context.Context) error, timeout time.Duration) *Monitor {
+	return &Monitor{ping: ping, timeout: timeout, err: errNotChecked}
+}
+
+// Err is why TigerGraph is unavailable, or nil if it's available
+func (m *Monitor) Err() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+// Fail records that a request to TigerGraph failed with err, so it's unavailable until the next check succeeds
+func (m *Monitor) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Check pings TigerGraph now and records the result
+func (m *Monitor) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	err := m.ping(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil && m.err == nil {
+		slog.Warn("TigerGraph is unavailable", "err", err)
+	} else if err == nil && m.err != nil && m.err != errNotChecked {
+		slog.Info("TigerGraph is available again")
+	}
+	m.err = err
+	return err
+}
+
+// Start checks TigerGraph right away and then every interval until the returned stop func is called.
+// It doesn't wait for the first check, so the server can start while TigerGraph is down
+func (m *Monitor) Start(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Check(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,68 @@
+package tigergraph
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMonitor_DownThenUp(t *testing.T) {
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() || r.URL.Path != "/restpp/echo" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"error":false, "message":"Hello GSQL"}`))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMonitor(func(ctx context.Context) error {
+		return Ping(ctx, u.Scheme+"://"+u.Hostname(), u.Port())
+	}, time.Second)
+
+	if m.Err() == nil {
+		t.Fatal("TigerGraph shouldn't count as available before it's checked")
+	}
+	// it's down when the server starts, the first check doesn't block Start
+	stop := m.Start(10 * time.Millisecond)
+	defer stop()
+	time.Sleep(50 * time.Millisecond)
+	if m.Err() == nil {
+		t.Fatal("TigerGraph should be unavailable")
+	}
+
+	up.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for m.Err() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("TigerGraph should be available once it's back. Err: %v", m.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMonitor_Fail(t *testing.T) {
+	m := NewMonitor(func(ctx context.Context) error { return nil }, time.Second)
+	if err := m.Check(context.Background()); err != nil || m.Err() != nil {
+		t.Fatalf("TigerGraph should be available. Got %v, %v", err, m.Err())
+	}
+
+	refused := errors.New("connection refused")
+	m.Fail(refused)
+	if !errors.Is(m.Err(), refused) {
+		t.Fatalf("a failed request should make TigerGraph unavailable until the next check. Err: %v", m.Err())
+	}
+	m.Check(context.Background())
+	if m.Err() != nil {
+		t.Fatalf("a successful check should make it available again. Err: %v", m.Err())
+	}
+}
@@ -0,0 +1,147 @@
+package tigergraph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrQueryRuntime is matched by QueryErrors for queries that failed while they ran, i.e., a GSQL
+// runtime error like a division by zero or an exception the query raised, rather than the query
+// not being installed or its parameters being wrong
+var ErrQueryRuntime = errors.New("query failed while running")
+
+// QueryError is the error TigerGraph reported for an installed query
+type QueryError struct {
+	Query string
+	// the status of the response, and the code and message from its envelope
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("query %s failed with %s: %s", e.Query, e.Code, e.Message)
+	}
+	return fmt.Sprintf("query %s failed: %s", e.Query, e.Message)
+}
+
+// Is matches ErrQueryRuntime if TigerGraph reported a runtime error
+func (e *QueryError) Is(target error) bool {
+	return target == ErrQueryRuntime && strings.HasPrefix(strings.TrimSpace(e.Message), "Runtime Error")
+}
+
+// QueryNotAllowedError is returned by RunQuery for queries that aren't in db_config.allowedQueries.
+// They aren't sent to TigerGraph
+type QueryNotAllowedError struct {
+	Query string
+}
+
+func (e *QueryNotAllowedError) Error() string {
+	return fmt.Sprintf("query %s is not in db_config.allowedQueries", e.Query)
+}
+
+// queryResponse is the envelope TigerGraph wraps the results of RESTPP endpoints in
+type queryResponse struct {
+	Error   bool              `json:"error"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Results []json.RawMessage `json:"results"`
+}
+
+// RunQuery runs the installed query name on cfg.Graphname and returns the results from the response,
+// one per PRINT statement, for the caller to decode. Params are sent in the query string: slices are
+// sent as the same parameter repeated, for SET and BAG parameters. If TigerGraph reports an error the
+// returned error is a *QueryError. If db_config.allowedQueries is set, other queries are a *QueryNotAllowedError
+func (c *TgClient) RunQuery(name string, params map[string]any) ([]json.RawMessage, error) {
+	if c.cfg.Graphname == "" {
+		return nil, errors.New("db_config.graphname is needed to run queries")
+	}
+	if len(c.cfg.AllowedQueries) > 0 && !slices.Contains(c.cfg.AllowedQueries, name) {
+		return nil, &QueryNotAllowedError{Query: name}
+	}
+	q, err := queryParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", name, err)
+	}
+	path := fmt.Sprintf("/restpp/query/%s/%s", url.PathEscape(c.cfg.Graphname), url.PathEscape(name))
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	resp, err := c.Do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope queryResponse
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, &QueryError{Query: name, Status: resp.StatusCode, Message: fmt.Sprintf("%s: %s", resp.Status, body)}
+	}
+	if envelope.Error || resp.StatusCode != http.StatusOK {
+		return nil, &QueryError{Query: name, Status: resp.StatusCode, Code: envelope.Code, Message: envelope.Message}
+	}
+	return envelope.Results, nil
+}
+
+// queryParams encodes the params of an installed query. Maps and structs can't be sent in a query string
+func queryParams(params map[string]any) (url.Values, error) {
+	// sorted so the errors don't depend on map order
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	q := url.Values{}
+	for _, name := range names {
+		v := reflect.ValueOf(params[name])
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			for i := range v.Len() {
+				s, err := queryParam(name, v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				q.Add(name, s)
+			}
+			continue
+		}
+		s, err := queryParam(name, v)
+		if err != nil {
+			return nil, err
+		}
+		q.Set(name, s)
+	}
+	return q, nil
+}
+
+func queryParam(name string, v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return "", fmt.Errorf("parameter %s is nil", name)
+		}
+		return queryParam(name, v.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), nil
+	case reflect.Invalid:
+		return "", fmt.Errorf("parameter %s is nil", name)
+	}
+	return "", fmt.Errorf("parameter %s is a %s, only strings, numbers, booleans and lists of them can be sent", name, v.Type())
+}
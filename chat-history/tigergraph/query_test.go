@@ -0,0 +1,137 @@
+package tigergraph
+
+import (
+	"chat-history/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// queryTigerGraph answers installed queries on the graph g with the envelopes TigerGraph sends
+func queryTigerGraph(t *testing.T) *TgClient {
+	tg := &fakeTigerGraph{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/restpp/query/g/count_docs":
+			ids, _ := json.Marshal(r.URL.Query()["ids"])
+			fmt.Fprintf(w, `{"version":{"edition":"enterprise"},"error":false,"message":"","results":[{"count":%s},{"ids":%s}]}`,
+				r.URL.Query().Get("limit"), ids)
+		case "/restpp/query/g/divide":
+			fmt.Fprint(w, `{"version":{"edition":"enterprise"},"error":true,"message":"Runtime Error: divider is zero.","code":"GSQL-7000","results":[]}`)
+		case "/restpp/query/g/missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"version":{"edition":"enterprise"},"error":true,"message":"Endpoint is not found from url = /query/g/missing","code":"REST-1000"}`)
+		default:
+			tg.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) { cfg.Graphname = "g" })
+}
+
+func TestRunQuery(t *testing.T) {
+	c := queryTigerGraph(t)
+
+	results, err := c.RunQuery("count_docs", map[string]any{"limit": 3, "ids": []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("there should be a result per PRINT. Got: %s", results)
+	}
+	var count struct{ Count int }
+	if err := json.Unmarshal(results[0], &count); err != nil || count.Count != 3 {
+		t.Fatalf("params should be sent in the query string. Got %s: %v", results[0], err)
+	}
+	if string(results[1]) != `{"ids":["a","b"]}` {
+		t.Fatalf("lists should be sent as a repeated param. Got: %s", results[1])
+	}
+}
+
+func TestRunQuery_Errors(t *testing.T) {
+	c := queryTigerGraph(t)
+
+	_, err := c.RunQuery("divide", nil)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || queryErr.Code != "GSQL-7000" || queryErr.Query != "divide" {
+		t.Fatalf("the error from the envelope should be returned. Got: %v", err)
+	}
+	if !errors.Is(err, ErrQueryRuntime) {
+		t.Fatalf("a GSQL runtime error should be ErrQueryRuntime. Got: %v", err)
+	}
+
+	_, err = c.RunQuery("missing", nil)
+	if !errors.As(err, &queryErr) || queryErr.Status != http.StatusNotFound || queryErr.Code != "REST-1000" {
+		t.Fatalf("the error from the envelope should be returned. Got: %v", err)
+	}
+	if errors.Is(err, ErrQueryRuntime) {
+		t.Fatal("a query that isn't installed isn't a runtime error")
+	}
+
+	// not something that can go in a query string, so it isn't sent
+	if _, err := c.RunQuery("count_docs", map[string]any{"filter": map[string]string{"a": "b"}}); err == nil || errors.As(err, &queryErr) {
+		t.Fatalf("a map param should be rejected before the query is run. Got: %v", err)
+	}
+}
+
+func TestRunQuery_NoGraph(t *testing.T) {
+	c := newTestClient(t, &fakeTigerGraph{}, "tigergraph", "tigergraph")
+	if _, err := c.RunQuery("count_docs", nil); err == nil {
+		t.Fatal("queries can't be run without a graph")
+	}
+}
+
+func TestRunQuery_AllowedQueries(t *testing.T) {
+	tg := &fakeTigerGraph{}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/restpp/query/") {
+			tg.ServeHTTP(w, r)
+			return
+		}
+		requests++
+		fmt.Fprint(w, `{"version":{"edition":"enterprise"},"error":false,"message":"","results":[{"count":1}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) {
+		cfg.Graphname = "g"
+		cfg.AllowedQueries = []string{"count_docs"}
+	})
+
+	if _, err := c.RunQuery("count_docs", nil); err != nil {
+		t.Fatalf("an allowed query should be run. Got: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("the allowed query should be sent. There were %d requests", requests)
+	}
+
+	_, err := c.RunQuery("drop_all", nil)
+	var notAllowed *QueryNotAllowedError
+	if !errors.As(err, &notAllowed) || notAllowed.Query != "drop_all" {
+		t.Fatalf("a query that isn't allowed should be a *QueryNotAllowedError. Got: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("a query that isn't allowed shouldn't be sent. There were %d requests", requests)
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	limit := 5
+	q, err := queryParams(map[string]any{"limit": &limit, "ok": true, "score": 0.5, "ids": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{"limit": {"5"}, "ok": {"true"}, "score": {"0.5"}, "ids": {"1", "2"}}
+	if q.Encode() != want.Encode() {
+		t.Fatalf("params should be %s. They're: %s", want.Encode(), q.Encode())
+	}
+
+	if _, err := queryParams(map[string]any{"v": nil}); err == nil {
+		t.Fatal("a nil param should be rejected")
+	}
+}
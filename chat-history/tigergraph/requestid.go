@@ -0,0 +1,27 @@
+package tigergraph
+
+import (
+	"chat-history/requestid"
+	"net/http"
+)
+
+// requestIDTransport sends the request id in the context of requests to TigerGraph
+// in their X-Request-ID header, so they can be found in TigerGraph's logs
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func forwardRequestID(next http.RoundTripper) http.RoundTripper {
+	return requestIDTransport{next: next}
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := requestid.FromContext(req.Context())
+	if id == "" || req.Header.Get(requestid.Header) != "" {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrip must not change the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set(requestid.Header, id)
+	return t.next.RoundTrip(req)
+}
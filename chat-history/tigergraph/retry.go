@@ -0,0 +1,47 @@
+package tigergraph
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// the longest wait between retries, however many there have been
+const maxBackoff = 10 * time.Second
+
+// idempotent reports whether sending the request twice has the same effect as sending it once.
+// Anything else (i.e., POST) may have been applied by TigerGraph before it failed, so it's never retried
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether the request failed in a way that may succeed if it's sent again:
+// TigerGraph couldn't be reached or returned a 5xx. Timeouts aren't retried, the request
+// already took as long as it's allowed to
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) && !urlErr.Timeout()
+	}
+	return resp.StatusCode >= 500
+}
+
+// backoff is how long to wait before retry attempt+1. It doubles from cfg.RetryBaseMillis with
+// every attempt, and is jittered between half and all of that so clients don't retry in lockstep
+func (c *TgClient) backoff(attempt int) time.Duration {
+	d := time.Duration(c.cfg.RetryBaseMillis) * time.Millisecond
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,146 @@
+package tigergraph
+
+import (
+	"chat-history/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyTigerGraph fails the first `failures` queries with fail, then passes them to fakeTigerGraph
+type flakyTigerGraph struct {
+	fakeTigerGraph
+	failures int32
+	fail     func(w http.ResponseWriter)
+	queries  atomic.Int32
+}
+
+func (f *flakyTigerGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/restpp/requesttoken" && f.queries.Add(1) <= f.failures {
+		f.fail(w)
+		return
+	}
+	f.fakeTigerGraph.ServeHTTP(w, r)
+}
+
+func unavailable(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// dropConnection closes the connection without a response, like a TigerGraph that's restarting
+func dropConnection(w http.ResponseWriter) {
+	conn, _, _ := w.(http.Hijacker).Hijack()
+	conn.Close()
+}
+
+// newRetryClient returns a client for tg that retries up to maxRetries times, recording the waits instead of sleeping
+func newRetryClient(t *testing.T, tg http.Handler, maxRetries int) (*TgClient, *[]time.Duration) {
+	srv := httptest.NewUnstartedServer(tg)
+	// http.Transport resends requests on reused connections that are dropped, without keep-alives
+	// every attempt is one of Do's
+	srv.Config.SetKeepAlivesEnabled(false)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	c := newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) {
+		cfg.MaxRetries = maxRetries
+		cfg.RetryBaseMillis = 100
+	})
+	waits := &[]time.Duration{}
+	c.sleep = func(d time.Duration) { *waits = append(*waits, d) }
+	return c, waits
+}
+
+func TestDo_RetriesTransientFailures(t *testing.T) {
+	for name, fail := range map[string]func(http.ResponseWriter){"5xx": unavailable, "connection error": dropConnection} {
+		t.Run(name, func(t *testing.T) {
+			tg := &flakyTigerGraph{failures: 2, fail: fail}
+			c, waits := newRetryClient(t, tg, 3)
+
+			resp, err := c.Do(http.MethodGet, "/restpp/query/g/q", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != 200 || string(body) != "GET /restpp/query/g/q " {
+				t.Fatalf("request should succeed on the third attempt. Got %d: %s", resp.StatusCode, body)
+			}
+			if n := tg.queries.Load(); n != 3 {
+				t.Fatalf("request should be sent 3 times. It was sent: %d", n)
+			}
+
+			// 100ms then 200ms, each jittered down by up to half
+			if len(*waits) != 2 {
+				t.Fatalf("there should be 2 waits. There were: %v", *waits)
+			}
+			for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+				if w := (*waits)[i]; w < want/2 || w > want {
+					t.Fatalf("wait %d should be between %v and %v. It's: %v", i, want/2, want, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDo_GivesUpAfterMaxRetries(t *testing.T) {
+	tg := &flakyTigerGraph{failures: 5, fail: unavailable}
+	c, _ := newRetryClient(t, tg, 2)
+
+	resp, err := c.Do(http.MethodGet, "/restpp/query/g/q", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("the last failure should be returned. Got: %d", resp.StatusCode)
+	}
+	if n := tg.queries.Load(); n != 3 {
+		t.Fatalf("request should be sent once and retried twice. It was sent: %d", n)
+	}
+}
+
+func TestDo_NeverRetriesNonIdempotent(t *testing.T) {
+	for name, fail := range map[string]func(http.ResponseWriter){"5xx": unavailable, "connection error": dropConnection} {
+		t.Run(name, func(t *testing.T) {
+			tg := &flakyTigerGraph{failures: 2, fail: fail}
+			c, waits := newRetryClient(t, tg, 3)
+
+			resp, err := c.Do(http.MethodPost, "/restpp/query/g/q", []byte(`{"a":1}`))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if n := tg.queries.Load(); n != 1 {
+				t.Fatalf("a POST must only be sent once. It was sent: %d", n)
+			}
+			if len(*waits) != 0 {
+				t.Fatalf("a POST shouldn't wait to retry. It waited: %v", *waits)
+			}
+		})
+	}
+}
+
+func TestDo_DoesNotRetryClientErrors(t *testing.T) {
+	tg := &flakyTigerGraph{failures: 1, fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadRequest) }}
+	c, _ := newRetryClient(t, tg, 3)
+
+	resp, err := c.Do(http.MethodGet, "/restpp/query/g/q", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || tg.queries.Load() != 1 {
+		t.Fatalf("a 4xx should be returned without retrying. Got %d after %d attempts", resp.StatusCode, tg.queries.Load())
+	}
+}
+
+func TestBackoff_Capped(t *testing.T) {
+	c := &TgClient{cfg: config.TgDbConfig{RetryBaseMillis: 100}}
+	for _, attempt := range []int{10, 62, 100} {
+		if d := c.backoff(attempt); d < maxBackoff/2 || d > maxBackoff {
+			t.Fatalf("backoff for attempt %d should be capped at %v. It's: %v", attempt, maxBackoff, d)
+		}
+	}
+}
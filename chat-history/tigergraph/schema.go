@@ -0,0 +1,197 @@
+package tigergraph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoGraph is returned for requests about the graph when db_config.graphname isn't set
+var ErrNoGraph = errors.New("db_config.graphname is not set")
+
+// Schema is the vertex and edge types of a graph, sorted by name
+type Schema struct {
+	Graph       string       `json:"graph"`
+	VertexTypes []VertexType `json:"vertex_types"`
+	EdgeTypes   []EdgeType   `json:"edge_types"`
+}
+
+// Attribute is an attribute of a vertex or edge type. Type is its GSQL type, i.e., STRING or LIST<INT>
+type Attribute struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type VertexType struct {
+	Name       string      `json:"name"`
+	PrimaryId  Attribute   `json:"primary_id"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+type EdgeType struct {
+	Name     string `json:"name"`
+	Directed bool   `json:"directed"`
+	// the vertex types it connects. Most edge types have one pair, edges between several types have more
+	Pairs      []EdgePair  `json:"pairs"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+type EdgePair struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// the schema as GSQL returns it
+type gsqlSchema struct {
+	GraphName   string `json:"GraphName"`
+	VertexTypes []struct {
+		Name       string          `json:"Name"`
+		PrimaryId  gsqlAttribute   `json:"PrimaryId"`
+		Attributes []gsqlAttribute `json:"Attributes"`
+	} `json:"VertexTypes"`
+	EdgeTypes []struct {
+		Name               string          `json:"Name"`
+		IsDirected         bool            `json:"IsDirected"`
+		FromVertexTypeName string          `json:"FromVertexTypeName"`
+		ToVertexTypeName   string          `json:"ToVertexTypeName"`
+		EdgePairs          []EdgePair      `json:"EdgePairs"`
+		Attributes         []gsqlAttribute `json:"Attributes"`
+	} `json:"EdgeTypes"`
+}
+
+type gsqlAttribute struct {
+	AttributeName string `json:"AttributeName"`
+	AttributeType struct {
+		Name          string `json:"Name"`
+		KeyTypeName   string `json:"KeyTypeName"`
+		ValueTypeName string `json:"ValueTypeName"`
+		TupleTypeName string `json:"TupleTypeName"`
+	} `json:"AttributeType"`
+}
+
+func (a gsqlAttribute) normalize() Attribute {
+	t := a.AttributeType
+	typ := t.Name
+	switch {
+	case t.KeyTypeName != "":
+		typ = fmt.Sprintf("%s<%s, %s>", t.Name, t.KeyTypeName, valueType(t.ValueTypeName, t.TupleTypeName))
+	case t.ValueTypeName != "":
+		typ = fmt.Sprintf("%s<%s>", t.Name, valueType(t.ValueTypeName, t.TupleTypeName))
+	}
+	return Attribute{Name: a.AttributeName, Type: typ}
+}
+
+// valueType is the type of a container's values, which is the tuple's name if they're UDTs
+func valueType(name, tuple string) string {
+	if tuple != "" {
+		return tuple
+	}
+	return name
+}
+
+func normalizeAttributes(attrs []gsqlAttribute) []Attribute {
+	out := make([]Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		out = append(out, a.normalize())
+	}
+	return out
+}
+
+// normalize converts the schema GSQL returns to a Schema. Attributes keep GSQL's order, which is the
+// order they were declared in
+func (s gsqlSchema) normalize() *Schema {
+	schema := &Schema{Graph: s.GraphName, VertexTypes: []VertexType{}, EdgeTypes: []EdgeType{}}
+	for _, v := range s.VertexTypes {
+		schema.VertexTypes = append(schema.VertexTypes, VertexType{
+			Name:       v.Name,
+			PrimaryId:  v.PrimaryId.normalize(),
+			Attributes: normalizeAttributes(v.Attributes),
+		})
+	}
+	for _, e := range s.EdgeTypes {
+		pairs := e.EdgePairs
+		if len(pairs) == 0 {
+			pairs = []EdgePair{{From: e.FromVertexTypeName, To: e.ToVertexTypeName}}
+		}
+		schema.EdgeTypes = append(schema.EdgeTypes, EdgeType{
+			Name:       e.Name,
+			Directed:   e.IsDirected,
+			Pairs:      pairs,
+			Attributes: normalizeAttributes(e.Attributes),
+		})
+	}
+	slices.SortFunc(schema.VertexTypes, func(a, b VertexType) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(schema.EdgeTypes, func(a, b EdgeType) int { return strings.Compare(a.Name, b.Name) })
+	return schema
+}
+
+type schemaResponse struct {
+	Error   bool       `json:"error"`
+	Message string     `json:"message"`
+	Results gsqlSchema `json:"results"`
+}
+
+// Schema returns the vertex and edge types of cfg.Graphname, or ErrNoGraph
+func (c *TgClient) Schema() (*Schema, error) {
+	if c.cfg.Graphname == "" {
+		return nil, ErrNoGraph
+	}
+	resp, err := c.Do(http.MethodGet, "/gsqlserver/gsql/schema?graph="+url.QueryEscape(c.cfg.Graphname), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope schemaResponse
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("schema of %s: %s: %s", c.cfg.Graphname, resp.Status, body)
+	}
+	if envelope.Error || resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema of %s: %s: %s", c.cfg.Graphname, resp.Status, envelope.Message)
+	}
+	return envelope.Results.normalize(), nil
+}
+
+// SchemaCache keeps the schema it fetched for ttl. Schemas rarely change, and clients (i.e., the
+// frontend) ask for it whenever they load
+type SchemaCache struct {
+	fetch func() (*Schema, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	schema  *Schema
+	expires time.Time
+}
+
+// NewSchemaCache returns a SchemaCache that gets the schema with fetch, i.e., TgClient.Schema
+func NewSchemaCache(fetch func() (*Schema, error), ttl time.Duration) *SchemaCache {
+	return &SchemaCache{fetch: fetch, ttl: ttl, now: time.Now}
+}
+
+// Get returns the cached schema, fetching it again if it's older than the ttl. Errors aren't cached
+func (c *SchemaCache) Get() (*Schema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.schema != nil && c.now().Before(c.expires) {
+		return c.schema, nil
+	}
+	schema, err := c.fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.schema, c.expires = schema, c.now().Add(c.ttl)
+	return schema, nil
+}
@@ -0,0 +1,131 @@
+package tigergraph
+
+import (
+	"chat-history/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a schema as GSQL returns it, with the types out of order and containers, UDTs and edges between several types
+const sampleSchema = `{"error":false,"message":"","results":{
+	"GraphName":"g",
+	"VertexTypes":[
+		{"Name":"Person","PrimaryId":{"AttributeName":"id","AttributeType":{"Name":"STRING"}},"Attributes":[
+			{"AttributeName":"name","AttributeType":{"Name":"STRING"}},
+			{"AttributeName":"emails","AttributeType":{"Name":"SET","ValueTypeName":"STRING"}},
+			{"AttributeName":"scores","AttributeType":{"Name":"MAP","KeyTypeName":"STRING","ValueTypeName":"DOUBLE"}}
+		]},
+		{"Name":"Account","PrimaryId":{"AttributeName":"number","AttributeType":{"Name":"INT"}},"Attributes":[
+			{"AttributeName":"history","AttributeType":{"Name":"LIST","ValueTypeName":"UDT","TupleTypeName":"Txn"}}
+		]}
+	],
+	"EdgeTypes":[
+		{"Name":"owns","IsDirected":true,"FromVertexTypeName":"Person","ToVertexTypeName":"Account","Attributes":[
+			{"AttributeName":"since","AttributeType":{"Name":"DATETIME"}}
+		]},
+		{"Name":"linked","IsDirected":false,"FromVertexTypeName":"*","ToVertexTypeName":"*","EdgePairs":[
+			{"From":"Person","To":"Person"},{"From":"Account","To":"Account"}
+		],"Attributes":[]}
+	]
+}}`
+
+// schemaTigerGraph serves sampleSchema for the graph g, and counts how many times it's asked for it
+func schemaTigerGraph(t *testing.T, calls *int) *TgClient {
+	tg := &fakeTigerGraph{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gsqlserver/gsql/schema" && r.URL.Query().Get("graph") == "g":
+			*calls++
+			fmt.Fprint(w, sampleSchema)
+		case r.URL.Path == "/gsqlserver/gsql/schema":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":true,"message":"Graph 'nope' does not exist."}`)
+		default:
+			tg.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return newConfiguredClient(t, srv, func(cfg *config.TgDbConfig) { cfg.Graphname = "g" })
+}
+
+func TestSchema(t *testing.T) {
+	calls := 0
+	c := schemaTigerGraph(t, &calls)
+
+	schema, err := c.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Schema{
+		Graph: "g",
+		VertexTypes: []VertexType{
+			{Name: "Account", PrimaryId: Attribute{"number", "INT"}, Attributes: []Attribute{{"history", "LIST<Txn>"}}},
+			{Name: "Person", PrimaryId: Attribute{"id", "STRING"}, Attributes: []Attribute{
+				{"name", "STRING"}, {"emails", "SET<STRING>"}, {"scores", "MAP<STRING, DOUBLE>"},
+			}},
+		},
+		EdgeTypes: []EdgeType{
+			{Name: "linked", Pairs: []EdgePair{{"Person", "Person"}, {"Account", "Account"}}, Attributes: []Attribute{}},
+			{Name: "owns", Directed: true, Pairs: []EdgePair{{"Person", "Account"}}, Attributes: []Attribute{{"since", "DATETIME"}}},
+		},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Fatalf("the schema should be normalized.\nExpected: %+v\nGot:      %+v", want, schema)
+	}
+}
+
+func TestSchema_Errors(t *testing.T) {
+	if _, err := newTestClient(t, &fakeTigerGraph{}, "tigergraph", "tigergraph").Schema(); !errors.Is(err, ErrNoGraph) {
+		t.Fatalf("expected ErrNoGraph without a graph, got: %v", err)
+	}
+
+	calls := 0
+	c := schemaTigerGraph(t, &calls)
+	c.cfg.Graphname = "nope"
+	if _, err := c.Schema(); err == nil {
+		t.Fatal("the error TigerGraph sends should be returned")
+	}
+}
+
+func TestSchemaCache(t *testing.T) {
+	calls := 0
+	c := schemaTigerGraph(t, &calls)
+	cache := NewSchemaCache(c.Schema, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for range 3 {
+		if _, err := cache.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("the schema should be fetched once while it's cached. It was fetched %d times", calls)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := cache.Get(); err != nil || calls != 2 {
+		t.Fatalf("the schema should be fetched again after the ttl. Fetched %d times: %v", calls, err)
+	}
+
+	// errors aren't cached
+	fail := true
+	failing := NewSchemaCache(func() (*Schema, error) {
+		if fail {
+			return nil, errors.New("TigerGraph is down")
+		}
+		return &Schema{Graph: "g"}, nil
+	}, time.Minute)
+	if _, err := failing.Get(); err == nil {
+		t.Fatal("the error should be returned")
+	}
+	fail = false
+	if s, err := failing.Get(); err != nil || s.Graph != "g" {
+		t.Fatalf("the schema should be fetched again after an error. Got %v, %v", s, err)
+	}
+}
@@ -0,0 +1,79 @@
+package tigergraph
+
+import (
+	"chat-history/config"
+	"chat-history/metrics"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TLSConfig returns the TLS settings for connecting to TigerGraph: the system roots plus
+// cfg.CACertPath if it's set, or no verification at all if cfg.InsecureSkipVerify is set
+func TLSConfig(cfg config.TgDbConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACertPath != "" {
+		pool, err := config.LoadCACert(cfg.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled for TigerGraph (db_config.insecureSkipVerify)", "hostname", cfg.Hostname)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// how long an unused connection to TigerGraph stays open
+const idleConnTimeout = 90 * time.Second
+
+// NewHTTPClient returns a client for requests to TigerGraph with cfg's TLS settings. It keeps up to
+// cfg.MaxIdleConns connections open for reuse, since every request goes to the same host, and goes
+// through the proxy in HTTP_PROXY or HTTPS_PROXY, unless NO_PROXY has the host. Its requests are recorded in the tigergraph_request_duration_seconds metric and carry the
+// X-Request-ID of the request they're made for
+func NewHTTPClient(cfg config.TgDbConfig) (*http.Client, error) {
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.IdleConnTimeout = idleConnTimeout
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	return &http.Client{Transport: forwardRequestID(metrics.InstrumentTigerGraph(transport))}, nil
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultClient = &http.Client{Transport: forwardRequestID(metrics.InstrumentTigerGraph(nil))}
+)
+
+// HTTPClient returns the shared client for requests to TigerGraph that don't go through a TgClient.
+// It uses the system roots until Configure is called
+func HTTPClient() *http.Client {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultClient
+}
+
+// Configure applies cfg's TLS, pooling and timeout settings to the client returned by HTTPClient.
+// Call it once at startup
+func Configure(cfg config.TgDbConfig) error {
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+	client.Timeout = time.Duration(cfg.RequestTimeoutSeconds) * time.Second
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultClient = client
+	return nil
+}
@@ -0,0 +1,91 @@
+package tigergraph
+
+import (
+	"chat-history/config"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// newTLSTigerGraph starts a TLS test server signed by its own CA and returns the config
+// to reach it and the path of the CA's PEM file
+func newTLSTigerGraph(t *testing.T, tg http.Handler) (config.TgDbConfig, string) {
+	srv := httptest.NewTLSServer(tg)
+	t.Cleanup(srv.Close)
+
+	caPath := fmt.Sprintf("%s/%s", t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caPath, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	return config.TgDbConfig{
+		Hostname: u.Scheme + "://" + u.Hostname(),
+		GsPort:   u.Port(),
+		Username: "tigergraph",
+		Password: "tigergraph",
+	}, caPath
+}
+
+func TestNewHTTPClient_CustomCA(t *testing.T) {
+	cfg, caPath := newTLSTigerGraph(t, &fakeTigerGraph{})
+	echo := BaseURL(cfg.Hostname, cfg.GsPort) + "/restpp/echo"
+
+	// the test CA isn't in the system roots
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(echo); err == nil {
+		t.Fatal("a certificate from an unknown CA should fail verification")
+	}
+
+	cfg.CACertPath = caPath
+	client, err = NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(echo)
+	if err != nil {
+		t.Fatalf("the certificate should verify with the custom CA. %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewHTTPClient_InsecureSkipVerify(t *testing.T) {
+	cfg, _ := newTLSTigerGraph(t, &fakeTigerGraph{})
+	cfg.InsecureSkipVerify = true
+
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(BaseURL(cfg.Hostname, cfg.GsPort) + "/restpp/echo")
+	if err != nil {
+		t.Fatalf("verification should be skipped. %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewTgClient_CustomCA(t *testing.T) {
+	cfg, caPath := newTLSTigerGraph(t, &fakeTigerGraph{})
+	cfg.CACertPath = caPath
+
+	c, err := NewTgClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RequestToken(); err != nil {
+		t.Fatalf("the client should log in over TLS. %v", err)
+	}
+
+	cfg.CACertPath = fmt.Sprintf("%s/%s", t.TempDir(), "missing.pem")
+	if _, err := NewTgClient(cfg); err == nil {
+		t.Fatal("a missing CA file should return an error")
+	}
+}
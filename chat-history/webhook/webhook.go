@@ -0,0 +1,169 @@
+// Package webhook tells other services about changes to conversations by POSTing them to a URL as JSON
+package webhook
+
+import (
+	"bytes"
+	"chat-history/db"
+	"chat-history/structs"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SignatureHeader has the HMAC-SHA256 of the body with the secret, as sha256=<hex>, when there's a secret
+const SignatureHeader = "X-Webhook-Signature"
+
+// Payload is the body of a delivery
+type Payload struct {
+	// the same for every attempt at delivering the event, so receivers can tell retries apart from new events
+	Id           uuid.UUID            `json:"id"`
+	Type         string               `json:"type"`
+	Timestamp    time.Time            `json:"timestamp"`
+	Conversation structs.Conversation `json:"conversation"`
+	Message      structs.Message      `json:"message"`
+}
+
+const (
+	// events waiting to be delivered. Once it's full new ones are dropped, so a slow receiver can't hold up requests
+	queueSize = 1000
+	// times a delivery is retried after a connection error, 429 or 5xx. The wait doubles every time
+	maxRetries = 3
+)
+
+// Emitter delivers the events it's given one at a time, in order, in the background.
+// A nil Emitter drops them, so callers don't have to check whether webhooks are configured
+type Emitter struct {
+	url    string
+	secret []byte
+	client *http.Client
+	// how long to wait before the first retry
+	retryBase time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan Payload
+	done   chan struct{}
+}
+
+// New starts delivering the events Emit is given to url, signed with secret if it isn't empty
+func New(url string, secret []byte) *Emitter {
+	e := &Emitter{
+		url:       url,
+		secret:    secret,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		retryBase: time.Second,
+		queue:     make(chan Payload, queueSize),
+		done:      make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Emit queues the event to be delivered and returns right away. It's the func for db.Notify
+func (e *Emitter) Emit(ev db.Event) {
+	if e == nil {
+		return
+	}
+	p := Payload{Id: uuid.New(), Type: ev.Type, Timestamp: time.Now().UTC(), Conversation: ev.Conversation, Message: ev.Message}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- p:
+	default:
+		slog.Warn("the webhook queue is full, dropping the event", "type", p.Type, "conversation_id", p.Conversation.ConversationId)
+	}
+}
+
+// Close stops taking events and waits until the ones already queued are delivered, or ctx is done
+func (e *Emitter) Close(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d webhook events weren't delivered: %w", len(e.queue), ctx.Err())
+	}
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+	for p := range e.queue {
+		if err := e.deliver(p); err != nil {
+			// failures are only logged, the change itself went through
+			slog.Error("failed to deliver the webhook event", "id", p.Id, "type", p.Type, "conversation_id", p.Conversation.ConversationId, "err", err)
+		}
+	}
+}
+
+// deliver POSTs p, retrying with backoff while the receiver might still take it
+func (e *Emitter) deliver(p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	wait := e.retryBase
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == maxRetries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post sends one attempt, and reports whether it's worth retrying if it failed
+func (e *Emitter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(e.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(e.secret, body))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%s: %s", resp.Status, msg)
+}
+
+// Sign is the SignatureHeader of a delivery of body. Receivers check it against the one they got with hmac.Equal
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"chat-history/db"
+	"chat-history/structs"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// receiver records the deliveries it gets, responding with the statuses in order and then 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	if len(rc.statuses) > 0 {
+		w.WriteHeader(rc.statuses[0])
+		rc.statuses = rc.statuses[1:]
+	}
+}
+
+func newEmitter(t *testing.T, rc *receiver, secret []byte) *Emitter {
+	t.Helper()
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+	e := New(srv.URL, secret)
+	e.retryBase = time.Millisecond
+	return e
+}
+
+func closeEmitter(t *testing.T, e *Emitter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEvent(eventType string) db.Event {
+	convoId := uuid.New()
+	return db.Event{
+		Type:         eventType,
+		Conversation: structs.Conversation{UserId: "sam_pull", ConversationId: convoId, Name: "convo"},
+		Message:      structs.Message{ConversationId: convoId, MessageId: uuid.New(), Content: "Hello, world", Role: structs.UserRole},
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	rc := &receiver{}
+	secret := []byte("webhook-secret")
+	e := newEmitter(t, rc, secret)
+	created, appended := testEvent(db.EventConversationCreated), testEvent(db.EventMessageAppended)
+	e.Emit(created)
+	e.Emit(appended)
+	closeEmitter(t, e)
+
+	if len(rc.bodies) != 2 {
+		t.Fatalf("both events should be delivered. Got %d deliveries", len(rc.bodies))
+	}
+	for i, want := range []db.Event{created, appended} {
+		var p Payload
+		if err := json.Unmarshal(rc.bodies[i], &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != want.Type || p.Conversation.ConversationId != want.Conversation.ConversationId ||
+			p.Message.MessageId != want.Message.MessageId || p.Message.Content != "Hello, world" || p.Id == uuid.Nil || p.Timestamp.IsZero() {
+			t.Fatalf("delivery %d should be the %s event in order: %s", i, want.Type, rc.bodies[i])
+		}
+		if ct := rc.headers[i].Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("Content-Type should be application/json. It's: %s", ct)
+		}
+		sig := rc.headers[i].Get(SignatureHeader)
+		if !hmac.Equal([]byte(sig), []byte(Sign(secret, rc.bodies[i]))) {
+			t.Fatalf("the delivery should be signed with the secret. Its signature is %q", sig)
+		}
+		if hmac.Equal([]byte(sig), []byte(Sign([]byte("another-secret"), rc.bodies[i]))) {
+			t.Fatal("the signature shouldn't match another secret")
+		}
+	}
+}
+
+func TestEmitter_Unsigned(t *testing.T) {
+	rc := &receiver{}
+	e := newEmitter(t, rc, nil)
+	e.Emit(testEvent(db.EventConversationCreated))
+	closeEmitter(t, e)
+	if len(rc.headers) != 1 || rc.headers[0].Get(SignatureHeader) != "" {
+		t.Fatalf("without a secret the delivery shouldn't be signed: %v", rc.headers)
+	}
+}
+
+func TestEmitter_Retries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		// deliveries of the event the receiver should get
+		attempts int
+	}{
+		{"recovers", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3},
+		{"gives up", []int{500, 500, 500, 500, 500}, maxRetries + 1},
+		{"rejected", []int{http.StatusBadRequest}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{statuses: tt.statuses}
+			e := newEmitter(t, rc, nil)
+			e.Emit(testEvent(db.EventMessageAppended))
+			closeEmitter(t, e)
+
+			if len(rc.bodies) != tt.attempts {
+				t.Fatalf("the event should be sent %d times. It was sent %d", tt.attempts, len(rc.bodies))
+			}
+			// every attempt is the same delivery
+			for _, body := range rc.bodies[1:] {
+				if string(body) != string(rc.bodies[0]) {
+					t.Fatalf("retries should resend the same body. Got %s and %s", rc.bodies[0], body)
+				}
+			}
+		})
+	}
+}
+
+func TestEmitter_DoesntBlock(t *testing.T) {
+	// a receiver that doesn't answer until the test is over
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	e := New(srv.URL, nil)
+
+	start := time.Now()
+	for range queueSize + 10 {
+		e.Emit(testEvent(db.EventMessageAppended))
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Emit shouldn't wait for deliveries. It took %v", time.Since(start))
+	}
+
+	// events emitted after Close are dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.Close(ctx); err == nil {
+		t.Fatal("Close should report the events it couldn't deliver in time")
+	}
+	e.Emit(testEvent(db.EventMessageAppended))
+
+	var nilEmitter *Emitter
+	nilEmitter.Emit(testEvent(db.EventMessageAppended))
+	if err := nilEmitter.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
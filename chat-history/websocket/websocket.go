@@ -0,0 +1,384 @@
+// Package websocket is the WebSocket protocol (RFC 6455), as much of it as the API's realtime clients need:
+// messages, fragmented or not, with pings answered and closes acknowledged. Extensions and subprotocols
+// aren't supported. Upgrade is the server side of a connection, Dial the client side, for tests and tools
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrHandshake is matched by the errors of Upgrade when the request isn't a WebSocket handshake, and of Dial
+// when the server doesn't accept it
+var ErrHandshake = errors.New("websocket: bad handshake")
+
+// ErrTooBig is returned by Read when a message is longer than the read limit. The connection is closed
+var ErrTooBig = errors.New("websocket: message too big")
+
+// close codes, see CloseError
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	ClosePolicy        = 1008
+	CloseTooBig        = 1009
+	CloseInternalError = 1011
+	// there was no code in the close frame
+	closeNoStatus = 1005
+)
+
+// CloseError is returned by Read once the peer closes the connection, with its code and reason
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Reason)
+}
+
+// DefaultReadLimit is the longest message Read accepts unless SetReadLimit changes it
+const DefaultReadLimit = 1 << 20
+
+// opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// the GUID the accept key is derived with, see RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Conn is a WebSocket connection. Read must only be called from one goroutine at a time, the writes from any
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+	limit  int
+
+	wmu sync.Mutex
+	// a close frame was sent, nothing is written after it
+	closeSent bool
+}
+
+// Upgrade completes the handshake of a WebSocket request, taking over its connection. On an ErrHandshake
+// nothing is written, the caller responds with the error. It doesn't check the request's Origin, the caller
+// decides which pages can connect
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("%w: the method must be GET", ErrHandshake)
+	}
+	if !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: the request must have Connection: Upgrade and Upgrade: websocket", ErrHandshake)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, fmt.Errorf("%w: Sec-WebSocket-Version must be 13", ErrHandshake)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		return nil, fmt.Errorf("%w: Sec-WebSocket-Key must be 16 bytes in base64", ErrHandshake)
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: can't take over the connection: %w", err)
+	}
+	// the server's read and write timeouts are for requests, not for a connection that stays open
+	conn.SetDeadline(time.Time{})
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader, limit: DefaultReadLimit}, nil
+}
+
+// Dial opens a WebSocket connection to u, a ws, wss, http or https URL, sending header with the handshake.
+// If the server doesn't accept it, the error matches ErrHandshake and the response is returned with its body
+func Dial(ctx context.Context, u string, header http.Header) (*Conn, *http.Response, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch parsed.Scheme {
+	case "ws", "http":
+		parsed.Scheme = "http"
+	case "wss", "https":
+		parsed.Scheme, secure = "https", true
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", parsed.Scheme)
+	}
+	host := parsed.Host
+	if parsed.Port() == "" && secure {
+		host = net.JoinHostPort(parsed.Hostname(), "443")
+	} else if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "80")
+	}
+	var conn net.Conn
+	if secure {
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: parsed.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: parsed, Host: parsed.Host, Header: http.Header{}, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		// the body is read before the connection is closed, so the caller can still see why
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		return nil, resp, fmt.Errorf("%w: the server responded %s", ErrHandshake, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, br: br, client: true, limit: DefaultReadLimit}, resp, nil
+}
+
+// SetReadLimit sets the longest message Read accepts
+func (c *Conn) SetReadLimit(n int) {
+	c.limit = n
+}
+
+// Read returns the next text or binary message. Pings are answered while it waits. Once the peer closes
+// the connection it's a *CloseError, after the close is acknowledged
+func (c *Conn) Read() ([]byte, error) {
+	var message []byte
+	// the opcode of the message's first frame, 0 until there is one
+	var kind byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: closeNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code, closeErr.Reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			}
+			c.Close(CloseNormal, "")
+			return nil, closeErr
+		case opText, opBinary:
+			if kind != 0 {
+				return nil, c.fail(CloseProtocolError, "a new message started before the last one ended")
+			}
+			kind, message = op, payload
+		case opContinuation:
+			if kind == 0 {
+				return nil, c.fail(CloseProtocolError, "a continuation without a message")
+			}
+			if len(message)+len(payload) > c.limit {
+				c.Close(CloseTooBig, "message too big")
+				return nil, ErrTooBig
+			}
+			message = append(message, payload...)
+		default:
+			return nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", op))
+		}
+		if fin {
+			if kind == opText && !utf8.Valid(message) {
+				// binary messages are taken as they are, text ones must be UTF-8
+				return nil, c.fail(CloseInvalidData, "text must be UTF-8")
+			}
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads a frame, unmasking what the client sent
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "no extensions were negotiated")
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		// clients mask what they send and servers don't
+		return false, 0, nil, c.fail(CloseProtocolError, "frames must be masked by the client only")
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || n > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "control frames must be whole and at most 125 bytes")
+	}
+	if n > uint64(c.limit) {
+		c.Close(CloseTooBig, "message too big")
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// fail closes the connection because the peer broke the protocol
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteText sends data as a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.write(opText, data)
+}
+
+func (c *Conn) write(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrame(op, payload)
+}
+
+// writeFrame writes payload as a single frame, masked by the client. wmu is held
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|op)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with code and reason, unless one was sent already, and closes the connection
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	if !c.closeSent {
+		c.closeSent = true
+		// the reason has to fit in a control frame
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, append(payload, reason...))
+	}
+	c.wmu.Unlock()
+	return c.conn.Close()
+}
+
+// acceptKey is the Sec-WebSocket-Accept of a Sec-WebSocket-Key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma separated values of the header include token, whatever the case
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
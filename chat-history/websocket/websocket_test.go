@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer sends back every message in upper case, and the error that ended the connection on errs
+func echoServer(t *testing.T, limit int) (string, chan error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn.SetReadLimit(limit)
+		for {
+			data, err := conn.Read()
+			if err != nil {
+				errs <- err
+				return
+			}
+			conn.WriteText([]byte(strings.ToUpper(string(data))))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, errs
+}
+
+func dial(t *testing.T, u string) *Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, _, err := Dial(ctx, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestConn(t *testing.T) {
+	u, errs := echoServer(t, DefaultReadLimit)
+	conn := dial(t, strings.Replace(u, "http", "ws", 1))
+
+	// short and long messages, whose lengths take 2 and 8 more bytes
+	for _, msg := range []string{"hello", strings.Repeat("é", 200), strings.Repeat("x", 70000)} {
+		if err := conn.WriteText([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := conn.Read()
+		if err != nil || string(got) != strings.ToUpper(msg) {
+			t.Fatalf("the message should be echoed. Got %d bytes, %v", len(got), err)
+		}
+	}
+
+	// pings are answered and fragments put together
+	conn.write(opPing, []byte("are you there"))
+	conn.wmu.Lock()
+	conn.conn.Write(fragment(opText, false, "frag"))
+	conn.conn.Write(fragment(opContinuation, true, "mented"))
+	conn.wmu.Unlock()
+	if got, err := conn.Read(); err != nil || string(got) != "FRAGMENTED" {
+		t.Fatalf("the fragments should be one message. Got %q, %v", got, err)
+	}
+
+	// closing is acknowledged
+	conn.Close(CloseGoingAway, "bye")
+	var closeErr *CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Reason != "bye" {
+		t.Fatalf("the server should see the close with its code. Got %v", err)
+	}
+}
+
+// fragment is a masked frame, fin or not
+func fragment(op byte, fin bool, payload string) []byte {
+	head := op
+	if fin {
+		head |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := append([]byte{head, 0x80 | byte(len(payload))}, mask[:]...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestConn_TooBig(t *testing.T) {
+	u, errs := echoServer(t, 10)
+	conn := dial(t, u)
+	conn.WriteText([]byte("this is more than ten bytes"))
+	if err := <-errs; !errors.Is(err, ErrTooBig) {
+		t.Fatalf("a message over the limit should be ErrTooBig. Got %v", err)
+	}
+	var closeErr *CloseError
+	if _, err := conn.Read(); !errors.As(err, &closeErr) || closeErr.Code != CloseTooBig {
+		t.Fatalf("the client should be told why it's closed. Got %v", err)
+	}
+}
+
+func TestConn_Unmasked(t *testing.T) {
+	u, errs := echoServer(t, DefaultReadLimit)
+	conn := dial(t, u)
+	// clients must mask their frames
+	conn.conn.Write([]byte{0x81, 2, 'h', 'i'})
+	var closeErr *CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != CloseProtocolError {
+		t.Fatalf("an unmasked frame should be a protocol error. Got %v", err)
+	}
+}
+
+func TestUpgrade_Handshake(t *testing.T) {
+	u, _ := echoServer(t, DefaultReadLimit)
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("a request without the handshake shouldn't be upgraded. It's %v", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("an unsupported version should be refused with the supported one. Got %v %v", resp.StatusCode, resp.Header)
+	}
+
+	// the example of RFC 6455
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("the accept key should be the RFC's. It's %s", got)
+	}
+}
+
+func TestDial_Refused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "who are you", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	_, resp, err := Dial(context.Background(), srv.URL, nil)
+	if !errors.Is(err, ErrHandshake) || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("a refused handshake should be ErrHandshake with the response. Got %v", err)
+	}
+	body, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if body != "who are you\n" {
+		t.Fatalf("the response body should still be readable. It's %q", body)
+	}
+}
+
+func TestReadFrame_Lengths(t *testing.T) {
+	// a server's frame of 300 bytes, read by a client
+	server, client := net.Pipe()
+	defer server.Close()
+	c := &Conn{conn: client, br: bufio.NewReader(client), client: true, limit: DefaultReadLimit}
+	go func() {
+		frame := []byte{0x82, 126}
+		frame = binary.BigEndian.AppendUint16(frame, 300)
+		server.Write(append(frame, make([]byte, 300)...))
+	}()
+	if got, err := c.Read(); err != nil || len(got) != 300 {
+		t.Fatalf("the extended length should be read. Got %d bytes, %v", len(got), err)
+	}
+}